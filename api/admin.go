@@ -0,0 +1,574 @@
+package api
+
+import (
+	"context"
+	"distrikv/cluster"
+	"distrikv/events"
+	"distrikv/membership"
+	"distrikv/ops"
+	"distrikv/replication"
+	"distrikv/storage"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Replication is the replication state of the node.
+type Replication interface {
+	Changes(seq uint64, limit int, node string) (*replication.ChangesResponse, error)
+	History(seq uint64, fn func(replication.Change) bool) error
+	Promote() error
+	Status() replication.Status
+	Freshness() replication.Freshness
+	Checkpoint(ctx context.Context, dir string) (*replication.Checkpoint, error)
+	Flush(flush func() error) error
+	CanAcknowledge(ack replication.Ack) error
+	Acknowledge(ctx context.Context, ack replication.Ack) error
+}
+
+// Membership is the gossip membership of the node.
+type Membership interface {
+	Gossip(members []membership.Member) []membership.Member
+	Members() []membership.MemberStatus
+}
+
+// Backups starts backups of the node.
+type Backups interface {
+	Start(root string) ops.Operation
+}
+
+// AdminHandler serves the admin and intra-cluster routes.
+// membership is nil when the cluster nodes are configured statically.
+type AdminHandler struct {
+	cluster     Cluster
+	replication Replication
+	membership  Membership
+	hints       Hints
+	backups     Backups
+	compactions *compactionLog
+
+	// events feed the changelog streams, which end once stop is closed.
+	events *events.Bus
+	stop   <-chan struct{}
+
+	// startedAt is when the node started serving.
+	startedAt time.Time
+}
+
+// Stats is a snapshot of the state of a node.
+type Stats struct {
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+
+	Shards      map[int]storage.Stats `json:"shards"`
+	Replication replication.Status    `json:"replication"`
+}
+
+// NewAdminHandler creates the admin handler, the compactions published
+// on bus are kept for the admin API. Changelog streams end when ctx is done.
+func NewAdminHandler(
+	ctx context.Context,
+	cluster Cluster,
+	replication Replication,
+	membership Membership,
+	hints Hints,
+	backups Backups,
+	bus *events.Bus,
+) *AdminHandler {
+	return &AdminHandler{
+		cluster:     cluster,
+		replication: replication,
+		membership:  membership,
+		hints:       hints,
+		backups:     backups,
+		compactions: newCompactionLog(bus),
+		events:      bus,
+		stop:        ctx.Done(),
+		startedAt:   time.Now(),
+	}
+}
+
+// Ring handles GET /admin/ring.
+func (h *AdminHandler) Ring(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.cluster.Status())
+}
+
+// Health handles GET /healthz, unhealthy nodes
+// respond with 503 so load balancers stop sending writes.
+func (h *AdminHandler) Health(ctx *gin.Context) {
+	health := h.cluster.Health()
+	if !health.Healthy {
+		ctx.JSON(http.StatusServiceUnavailable, health)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, health)
+}
+
+// Ready handles GET /readyz, nodes with shards refusing writes,
+// read-only or out of disk space, respond with 503. A full disk
+// leaves /healthz untouched, writes resume once space is freed.
+func (h *AdminHandler) Ready(ctx *gin.Context) {
+	health := h.cluster.Health()
+	if !health.Ready {
+		ctx.JSON(http.StatusServiceUnavailable, health)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, health)
+}
+
+// Levels handles GET /admin/levels, the SST count
+// and size of every level of the local shards.
+func (h *AdminHandler) Levels(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.cluster.Levels())
+}
+
+// Stats handles GET /admin/stats, a snapshot of the
+// local shards, the replication changelog and the uptime.
+func (h *AdminHandler) Stats(ctx *gin.Context) {
+	shards, err := h.cluster.Stats()
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, Stats{
+		StartedAt:     h.startedAt,
+		UptimeSeconds: int64(time.Since(h.startedAt).Seconds()),
+		Shards:        shards,
+		Replication:   h.replication.Status(),
+	})
+}
+
+// Caches handles GET /admin/cache, the row and negative
+// cache entries and hit ratios of the local shards.
+func (h *AdminHandler) Caches(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.cluster.Caches())
+}
+
+// Compactions handles GET /admin/compactions,
+// the latest compactions of this node, newest first.
+func (h *AdminHandler) Compactions(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.compactions.recent(0))
+}
+
+// CompactionPlan handles GET /admin/compaction/plan, the next
+// compaction of every level of the local shards.
+func (h *AdminHandler) CompactionPlan(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.cluster.CompactionPlan())
+}
+
+// CompactionHistory handles GET /admin/compaction/history?limit=,
+// the last completed compactions of this node, newest first,
+// with their durations and byte counts.
+func (h *AdminHandler) CompactionHistory(ctx *gin.Context) {
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(RecentCompactions)))
+	if err != nil || limit < 1 {
+		abortWithError(ctx, newValidationError("invalid limit", ctx.Query("limit")))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, h.compactions.recent(limit))
+}
+
+// Replication handles GET /admin/replication.
+func (h *AdminHandler) Replication(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.replication.Status())
+}
+
+// Promote handles POST /admin/promote, making a standby the primary.
+func (h *AdminHandler) Promote(ctx *gin.Context) {
+	if err := h.replication.Promote(); err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, h.replication.Status())
+}
+
+// Changes handles GET /internal/changes?from=&limit=&node=,
+// standbys poll it to follow the primary.
+func (h *AdminHandler) Changes(ctx *gin.Context) {
+	from, err := strconv.ParseUint(ctx.Query("from"), 10, 64)
+	if err != nil {
+		abortWithError(ctx, newValidationError("invalid from", ctx.Query("from")))
+		return
+	}
+
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "1000"))
+	if err != nil || limit < 1 {
+		abortWithError(ctx, newValidationError("invalid limit", ctx.Query("limit")))
+		return
+	}
+
+	res, err := h.replication.Changes(from, limit, ctx.Query("node"))
+	if errors.Is(err, replication.ErrChangesTruncated) || errors.Is(err, replication.ErrChangesAhead) {
+		ctx.AbortWithStatusJSON(http.StatusGone, ErrorResponse{
+			Code:    CodeChangesUnavailable,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	res.Generation = h.cluster.Generation()
+
+	ctx.JSON(http.StatusOK, res)
+}
+
+// Members handles GET /admin/members.
+func (h *AdminHandler) Members(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.membership.Members())
+}
+
+// Gossip handles POST /internal/gossip, peers exchange
+// their member tables through it.
+func (h *AdminHandler) Gossip(ctx *gin.Context) {
+	var req membership.GossipMessage
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		abortWithError(ctx, newValidationError("invalid gossip message", err.Error()))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, membership.GossipMessage{
+		Members: h.membership.Gossip(req.Members),
+	})
+}
+
+// Hints handles GET /admin/hints, the number of
+// writes waiting for every unreachable node.
+func (h *AdminHandler) Hints(ctx *gin.Context) {
+	pending, err := h.hints.Pending()
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, pending)
+}
+
+// Rebalance handles GET /admin/rebalance/status,
+// the progress of the shard moves of this node.
+func (h *AdminHandler) Rebalance(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.cluster.Rebalance())
+}
+
+// Operations handles GET /admin/operations,
+// the running and latest finished operations.
+func (h *AdminHandler) Operations(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.cluster.Operations().List())
+}
+
+// Operation handles GET /admin/operations/:id.
+func (h *AdminHandler) Operation(ctx *gin.Context) {
+	op, err := h.cluster.Operations().Get(ctx.Param("id"))
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, op)
+}
+
+// CancelOperation handles POST /admin/operations/:id/cancel. The
+// operation is canceled once it stopped, which is polled at its ID.
+func (h *AdminHandler) CancelOperation(ctx *gin.Context) {
+	op, err := h.cluster.Operations().Cancel(ctx.Param("id"))
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, op)
+}
+
+// Compact handles POST /admin/compact, starting a manual
+// compaction of the local shards tracked as an operation.
+func (h *AdminHandler) Compact(ctx *gin.Context) {
+	ctx.JSON(http.StatusAccepted, h.cluster.CompactAll())
+}
+
+// PauseCompactions handles POST /admin/compaction/pause, pausing the
+// background compactions of the local shards until they're resumed.
+// Running compactions are aborted, the response is sent once they stopped.
+func (h *AdminHandler) PauseCompactions(ctx *gin.Context) {
+	h.cluster.PauseCompactions()
+	ctx.JSON(http.StatusOK, CompactionPauseStatus{PausedShards: h.cluster.CompactionsPaused()})
+}
+
+// ResumeCompactions handles POST /admin/compaction/resume.
+func (h *AdminHandler) ResumeCompactions(ctx *gin.Context) {
+	h.cluster.ResumeCompactions()
+	ctx.JSON(http.StatusOK, CompactionPauseStatus{PausedShards: h.cluster.CompactionsPaused()})
+}
+
+// CompactionStatus handles GET /admin/compaction/status.
+func (h *AdminHandler) CompactionStatus(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, CompactionPauseStatus{PausedShards: h.cluster.CompactionsPaused()})
+}
+
+// Flush handles POST /admin/flush, writing the
+// memtables of the local shards to SSTs.
+func (h *AdminHandler) Flush(ctx *gin.Context) {
+	var entries int
+	err := h.replication.Flush(func() error {
+		var err error
+		entries, err = h.cluster.Flush(ctx.Request.Context())
+		return err
+	})
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, FlushResponse{Entries: entries})
+}
+
+// Checkpoint handles POST /admin/checkpoint, checkpointing the local
+// shards into a directory of the node that must not exist yet.
+func (h *AdminHandler) Checkpoint(ctx *gin.Context) {
+	var req CheckpointRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		abortWithError(ctx, newValidationError("invalid checkpoint request", err.Error()))
+		return
+	}
+
+	checkpoint, err := h.replication.Checkpoint(ctx.Request.Context(), req.Dir)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, checkpoint)
+}
+
+// Ingest handles POST /admin/ingest, adding an SST
+// file of the node to the local shard owning its keys.
+func (h *AdminHandler) Ingest(ctx *gin.Context) {
+	var req IngestRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		abortWithError(ctx, newValidationError("invalid ingest request", err.Error()))
+		return
+	}
+
+	// the changes replayed on startup must not shadow the ingested entries,
+	// the memtables of every shard are flushed first with writes paused
+	var res *cluster.IngestResult
+	err := h.replication.Flush(func() error {
+		if _, err := h.cluster.Flush(ctx.Request.Context()); err != nil {
+			return err
+		}
+
+		var err error
+		res, err = h.cluster.IngestSST(ctx.Request.Context(), req.Path)
+		return err
+	})
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, res)
+}
+
+// Backup handles POST /admin/backups, starting a backup
+// operation into a new directory of a path of the node.
+func (h *AdminHandler) Backup(ctx *gin.Context) {
+	var req BackupRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		abortWithError(ctx, newValidationError("invalid backup request", err.Error()))
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, h.backups.Start(req.Path))
+}
+
+// HandoffShard handles POST /internal/shards/:shard/handoff?to=,
+// the new owner of a shard pulls it through it.
+func (h *AdminHandler) HandoffShard(ctx *gin.Context) {
+	shard, err := strconv.Atoi(ctx.Param("shard"))
+	if err != nil {
+		abortWithError(ctx, newValidationError("invalid shard", ctx.Param("shard")))
+		return
+	}
+
+	ctx.Header("Content-Type", "application/x-ndjson")
+
+	err = h.cluster.HandoffShard(shard, ctx.Query("to"), ctx.Writer)
+	if err == nil {
+		return
+	}
+
+	// the status is sent with the first chunk,
+	// the new owner sees the stream end early
+	if ctx.Writer.Written() {
+		ctx.Error(err)
+		return
+	}
+
+	abortWithError(ctx, err)
+}
+
+// ReleaseShard handles POST /internal/shards/:shard/release,
+// the new owner of a shard releases it once it's pulled.
+func (h *AdminHandler) ReleaseShard(ctx *gin.Context) {
+	shard, err := strconv.Atoi(ctx.Param("shard"))
+	if err != nil {
+		abortWithError(ctx, newValidationError("invalid shard", ctx.Param("shard")))
+		return
+	}
+
+	if err := h.cluster.ReleaseShard(shard); err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+// ClusterResponse is the body of GET /admin/cluster.
+type ClusterResponse struct {
+	cluster.Info
+
+	Replication    replication.Status `json:"replication"`
+	ReplicationLag uint64             `json:"replication_lag"`
+}
+
+// FlushResponse is the body of POST /admin/flush.
+type FlushResponse struct {
+	Entries int `json:"entries"`
+}
+
+// CompactionPauseStatus is the body of GET /admin/compaction/status, and of
+// the pause and resume routes: the local shards whose compactions are paused.
+type CompactionPauseStatus struct {
+	PausedShards []int `json:"paused_shards"`
+}
+
+// DecommissionRequest is the body of POST /admin/cluster/decommission.
+type DecommissionRequest struct {
+	Node string `json:"node" binding:"required"`
+}
+
+// CheckpointRequest is the body of POST /admin/checkpoint.
+type CheckpointRequest struct {
+	Dir string `json:"dir" binding:"required"`
+}
+
+// IngestRequest is the body of POST /admin/ingest.
+type IngestRequest struct {
+	Path string `json:"path" binding:"required"`
+}
+
+// BackupRequest is the body of POST /admin/backups. Path is a
+// directory of the node or an s3://bucket/prefix location.
+type BackupRequest struct {
+	Path string `json:"path" binding:"required"`
+}
+
+// MoveShardRequest is the body of POST /admin/cluster/shards/:shard/move.
+type MoveShardRequest struct {
+	Node string `json:"node" binding:"required"`
+}
+
+// ReplicationFactorRequest is the body of PUT /admin/cluster/replication-factor.
+type ReplicationFactorRequest struct {
+	ReplicationFactor int `json:"replication_factor" binding:"required"`
+}
+
+// Cluster handles GET /admin/cluster, the nodes, shard ownership,
+// replication lag and disk usage seen from this node.
+func (h *AdminHandler) Cluster(ctx *gin.Context) {
+	res := ClusterResponse{
+		Info:        h.cluster.Info(),
+		Replication: h.replication.Status(),
+	}
+
+	if res.Replication.PrimarySeq > res.Replication.LastSeq {
+		res.ReplicationLag = res.Replication.PrimarySeq - res.Replication.LastSeq
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
+
+// Decommission handles POST /admin/cluster/decommission,
+// the shards of the node are moved to the other nodes.
+func (h *AdminHandler) Decommission(ctx *gin.Context) {
+	var req DecommissionRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		abortWithError(ctx, newValidationError("invalid decommission request", err.Error()))
+		return
+	}
+
+	placement, err := h.cluster.Decommission(req.Node)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, placement)
+}
+
+// MoveShard handles POST /admin/cluster/shards/:shard/move.
+func (h *AdminHandler) MoveShard(ctx *gin.Context) {
+	shard, err := strconv.Atoi(ctx.Param("shard"))
+	if err != nil {
+		abortWithError(ctx, newValidationError("invalid shard", ctx.Param("shard")))
+		return
+	}
+
+	var req MoveShardRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		abortWithError(ctx, newValidationError("invalid move request", err.Error()))
+		return
+	}
+
+	placement, err := h.cluster.MoveShard(shard, req.Node)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, placement)
+}
+
+// ReplicationFactor handles PUT /admin/cluster/replication-factor.
+func (h *AdminHandler) ReplicationFactor(ctx *gin.Context) {
+	var req ReplicationFactorRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		abortWithError(ctx, newValidationError("invalid replication factor request", err.Error()))
+		return
+	}
+
+	if err := h.cluster.SetReplicationFactor(req.ReplicationFactor); err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, req)
+}
+
+// Placement handles POST /internal/cluster/placement,
+// the node a placement was changed on sends it to its peers.
+func (h *AdminHandler) Placement(ctx *gin.Context) {
+	var req cluster.Placement
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		abortWithError(ctx, newValidationError("invalid placement", err.Error()))
+		return
+	}
+
+	if err := h.cluster.SetPlacement(req); err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
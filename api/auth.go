@@ -0,0 +1,85 @@
+package api
+
+import (
+	"distrikv/auth"
+	"distrikv/pkg"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHeader carries an API key, for clients that can't
+// set the Authorization header.
+const APIKeyHeader = "X-API-Key"
+
+// principalKey is the key of the authenticated caller in the gin context.
+const principalKey = "principal"
+
+// authenticated rejects requests without a valid API key or token,
+// except health checks. Peers presenting a certificate of the cluster CA
+// are authenticated by it, so forwarded requests and replayed hints
+// need no credentials.
+func authenticated(a *auth.Authenticator) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.Request.URL.Path == "/healthz" || ctx.Request.URL.Path == "/readyz" {
+			ctx.Next()
+			return
+		}
+
+		if pkg.Verified(ctx.Request.TLS) {
+			ctx.Set(principalKey, auth.Peer)
+			ctx.Next()
+			return
+		}
+
+		credential := auth.BearerToken(ctx.GetHeader("Authorization"))
+		if credential == "" {
+			credential = ctx.GetHeader(APIKeyHeader)
+		}
+
+		p, err := a.Authenticate(credential)
+		if err != nil {
+			ctx.Header("WWW-Authenticate", `Bearer realm="distrikv"`)
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{
+				Code:    CodeUnauthenticated,
+				Message: err.Error(),
+			})
+			return
+		}
+
+		ctx.Set(principalKey, p)
+		ctx.Next()
+	}
+}
+
+// principal returns the authenticated caller of ctx,
+// nil without authentication.
+func principal(ctx *gin.Context) *auth.Principal {
+	p, _ := ctx.Get(principalKey)
+	principal, _ := p.(*auth.Principal)
+	return principal
+}
+
+// authorize rejects requests of callers without role, or whose
+// prefixes don't cover the key of the route, sequence names are
+// scoped like keys. It runs before the request is forwarded,
+// the owner sees the forwarding node as a peer.
+func authorize(role string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var keys []string
+		if key := ctx.Param("key"); key != "" {
+			keys = append(keys, key)
+		} else if name := ctx.Param("name"); name != "" {
+			keys = append(keys, name)
+		} else if key := ctx.Query("key"); key != "" {
+			keys = append(keys, key)
+		}
+
+		if err := principal(ctx).Authorize(role, keys...); err != nil {
+			abortWithError(ctx, err)
+			return
+		}
+
+		ctx.Next()
+	}
+}
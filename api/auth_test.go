@@ -0,0 +1,212 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"distrikv/auth"
+	"distrikv/cluster"
+	"distrikv/config"
+	"distrikv/events"
+	"distrikv/pkg"
+	"distrikv/replication"
+	"distrikv/storage"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKeys are the API keys of an admin, and of a reader
+// and a writer scoped to the keys starting with "app/".
+type testKeys struct {
+	admin, reader, writer string
+}
+
+// newTestRouter returns the routes of a single node cluster of a
+// temporary directory, authenticating requests with the keys it returns.
+func newTestRouter(t *testing.T) (*gin.Engine, testKeys) {
+	gin.SetMode(gin.TestMode)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	logger := slog.New(slog.DiscardHandler)
+	cfg := config.Config{NodeID: "a", DataDir: t.TempDir(), Shards: 1, ShardVnodes: 1, ChangelogSize: 100, ScanDefaultLimit: 10, ScanMaxLimit: 10, MaxBatchSize: 10}
+
+	open := func(dir string) (*storage.Store, error) {
+		return storage.Open(ctx, logger, dir, storage.OPEN_FAST, nil, nil, nil)
+	}
+	c, err := cluster.New(logger, cfg, nil, open)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Shutdown(context.Background()) })
+
+	bus := events.NewBus()
+	r, err := replication.New(logger, c, cfg, bus)
+	require.NoError(t, err)
+	t.Cleanup(func() { r.Close() })
+
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	addKey := func(name string, role string, prefixes ...string) string {
+		key, err := auth.AddKey(keysFile, name, role, prefixes)
+		require.NoError(t, err)
+		return key
+	}
+	keys := testKeys{
+		admin:  addKey("admin", auth.ROLE_ADMIN),
+		reader: addKey("reader", auth.ROLE_READ, "app/"),
+		writer: addKey("writer", auth.ROLE_WRITE, "app/"),
+	}
+
+	authenticator, err := auth.New(logger, keysFile, nil)
+	require.NoError(t, err)
+
+	deps := Deps{Store: r, Cluster: c, Replication: r, Events: bus, Auth: authenticator}
+	return NewRouter(ctx, deps, cfg), keys
+}
+
+// serve answers a request of the caller of key with router.
+func serve(router http.Handler, key string, method string, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestAuthorization(t *testing.T) {
+	router, keys := newTestRouter(t)
+
+	// keys are escaped in paths, they may contain slashes
+	for _, key := range []string{"app/a", "other/a"} {
+		rec := serve(router, keys.admin, http.MethodPut, "/v1/keys/"+url.PathEscape(key), "1")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve(router, "", http.MethodGet, "/v1/keys/app%2Fa", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(router, "dkv_unknown", http.MethodGet, "/v1/keys/app%2Fa", "").Code)
+
+	tests := []struct {
+		key    string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{keys.reader, http.MethodGet, "/v1/keys/app%2Fa", "", http.StatusOK},
+		{keys.reader, http.MethodGet, "/v1/keys/other%2Fa", "", http.StatusForbidden},
+		{keys.reader, http.MethodGet, "/v1/keys/other%2Fa/ttl", "", http.StatusForbidden},
+		{keys.reader, http.MethodPost, "/v1/mget", `{"keys":["app/a"]}`, http.StatusOK},
+		{keys.reader, http.MethodPost, "/v1/mget", `{"keys":["app/a","other/a"]}`, http.StatusForbidden},
+		{keys.reader, http.MethodPut, "/v1/keys/app%2Fa", "2", http.StatusForbidden},
+		{keys.reader, http.MethodDelete, "/v1/keys/app%2Fa", "", http.StatusForbidden},
+		{keys.writer, http.MethodPut, "/v1/keys/app%2Fb", "2", http.StatusOK},
+		{keys.writer, http.MethodPut, "/v1/keys/other%2Fb", "2", http.StatusForbidden},
+		{keys.writer, http.MethodDelete, "/v1/keys/other%2Fa", "", http.StatusForbidden},
+		{keys.writer, http.MethodPost, "/v1/keys/other%2Fa/incr", "", http.StatusForbidden},
+
+		// the admin and internal routes need the admin role
+		{keys.reader, http.MethodGet, "/admin/ring", "", http.StatusForbidden},
+		{keys.writer, http.MethodGet, "/admin/ring", "", http.StatusForbidden},
+		{keys.writer, http.MethodPost, "/admin/flush", "", http.StatusForbidden},
+		{keys.writer, http.MethodGet, "/dashboard", "", http.StatusForbidden},
+		{keys.writer, http.MethodGet, "/internal/changes?from=0", "", http.StatusForbidden},
+		{keys.admin, http.MethodGet, "/admin/ring", "", http.StatusOK},
+		{keys.admin, http.MethodGet, "/internal/changes?from=0", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		rec := serve(router, tt.key, tt.method, tt.path, tt.body)
+		assert.Equal(t, tt.status, rec.Code, "%s %s: %s", tt.method, tt.path, rec.Body.String())
+	}
+
+	// scans skip the keys out of the prefixes of the caller
+	rec := serve(router, keys.reader, http.MethodGet, "/v1/scan", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var res scanResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+
+	var scanned []string
+	for _, item := range res.Items {
+		scanned = append(scanned, item.Key)
+	}
+	assert.Equal(t, []string{"app/a", "app/b"}, scanned)
+}
+
+func TestInternalRoutesRequirePeers(t *testing.T) {
+	router, keys := newTestRouter(t)
+
+	pkg.SetPeerTLS(&pkg.PeerTLS{})
+	defer pkg.SetPeerTLS(nil)
+
+	// with mTLS an admin key isn't enough, the certificate of a peer is
+	rec := serve(router, keys.admin, http.MethodGet, "/internal/changes?from=0", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, rec.Body.String())
+
+	req := httptest.NewRequest(http.MethodGet, "/internal/changes?from=0", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{}}}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+}
+
+func TestWatchAuthorization(t *testing.T) {
+	router, keys := newTestRouter(t)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/v1/watch", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+keys.reader)
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	// the watch is subscribed once its headers are sent
+	for _, key := range []string{"other/a", "app/a"} {
+		rec := serve(router, keys.admin, http.MethodPut, "/v1/keys/"+url.PathEscape(key), "1")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var watched []string
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		data, ok := bytes.CutPrefix(scanner.Bytes(), []byte("data:"))
+		if !ok {
+			continue
+		}
+
+		var event WatchEvent
+		require.NoError(t, json.Unmarshal(data, &event))
+		watched = append(watched, event.Key)
+
+		if event.Key == "app/a" {
+			break
+		}
+	}
+	assert.Equal(t, []string{"app/a"}, watched)
+
+	cancel()
+	io.Copy(io.Discard, res.Body)
+}
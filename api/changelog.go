@@ -0,0 +1,95 @@
+package api
+
+import (
+	"distrikv/events"
+	"distrikv/replication"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Changelog handles GET /v1/changelog?from=, streaming every write
+// applied on this node after the sequence from as server-sent events, in
+// order: the retained history first, then the writes as they are applied.
+// Writes are "write" events like those of GET /v1/watch, whose id is their
+// sequence, so a client reconnecting with Last-Event-ID resumes after the
+// last write it got. Without either the stream starts with the next write.
+// A from whose next writes are no longer retained is rejected with 410.
+// Callers scoped to prefixes only get the writes of their keys, and the
+// stream ends like a watch.
+func (h *AdminHandler) Changelog(ctx *gin.Context) {
+	raw := ctx.Query("from")
+	if id := ctx.GetHeader("Last-Event-ID"); id != "" {
+		raw = id
+	}
+
+	// subscribed first, the writes recorded while the history is read are sent after it
+	sub := h.events.Subscribe(events.WatchBuffer, events.TOPIC_WRITE)
+	defer sub.Close()
+
+	from := h.replication.Freshness().AppliedSeq
+	if raw != "" {
+		var err error
+		from, err = strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			abortWithError(ctx, newValidationError("invalid from", raw))
+			return
+		}
+	}
+
+	p := principal(ctx)
+	last := from
+	var started bool
+
+	err := h.replication.History(from, func(change replication.Change) bool {
+		if !started {
+			startEvents(ctx)
+			started = true
+		}
+
+		data := events.WriteEvent{
+			Seq:       change.Seq,
+			Op:        change.Op.String(),
+			Key:       change.Key,
+			End:       change.End,
+			Value:     change.Value,
+			ExpiresAt: change.ExpiresAt,
+		}
+
+		last = data.Seq
+		if watched(p, "", data) {
+			sendWrite(ctx, data)
+		}
+
+		return ctx.Request.Context().Err() == nil
+	})
+
+	switch {
+	case err != nil && started:
+		ctx.Error(err)
+		return
+	case errors.Is(err, replication.ErrChangesTruncated) || errors.Is(err, replication.ErrChangesAhead):
+		ctx.AbortWithStatusJSON(http.StatusGone, ErrorResponse{
+			Code:    CodeChangesUnavailable,
+			Message: err.Error(),
+		})
+		return
+	case err != nil:
+		abortWithError(ctx, err)
+		return
+	case !started:
+		startEvents(ctx)
+	}
+
+	ctx.Writer.Flush()
+	streamWrites(ctx, sub, h.stop, func(data events.WriteEvent) bool {
+		if data.Seq <= last {
+			return false
+		}
+
+		last = data.Seq
+		return watched(p, "", data)
+	})
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"distrikv/cluster"
+	"distrikv/handoff"
+	"distrikv/ops"
+	"distrikv/pkg"
+	"distrikv/replication"
+	"distrikv/storage"
+	"distrikv/tracing"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cluster locates the owner of keys, so requests
+// for keys owned by other nodes can be forwarded.
+type Cluster interface {
+	Owner(key string) (cluster.Node, bool)
+	Shard(key string) int
+	Status() cluster.Status
+	Health() cluster.Health
+	Levels() map[int][]storage.LevelStats
+	CompactionPlan() map[int][]storage.CompactionPlan
+	Caches() map[int]storage.CacheStats
+	Stats() (map[int]storage.Stats, error)
+	Rebalance() cluster.RebalanceStatus
+	HandoffShard(shard int, to string, w io.Writer) error
+	ReleaseShard(shard int) error
+	Info() cluster.Info
+	Decommission(node string) (cluster.Placement, error)
+	MoveShard(shard int, node string) (cluster.Placement, error)
+	SetReplicationFactor(n int) error
+	SetPlacement(p cluster.Placement) error
+	Generation() string
+	Operations() *ops.Registry
+	CompactAll() ops.Operation
+	PauseCompactions()
+	ResumeCompactions()
+	CompactionsPaused() []int
+	Flush(ctx context.Context) (int, error)
+	IngestSST(ctx context.Context, path string) (*cluster.IngestResult, error)
+}
+
+// Hints stores writes for unreachable nodes.
+type Hints interface {
+	Add(hint handoff.Hint) error
+	Pending() (map[string]int, error)
+}
+
+// HopsHeader counts the nodes a request was forwarded by.
+const HopsHeader = "X-Distrikv-Hops"
+
+// MaxForwardHops bounds the forwards of a request, so nodes
+// with diverging views of the ring can't forward it in a loop.
+const MaxForwardHops = 3
+
+// GenerationHeader carries the generation of the node serving a
+// request. A changed generation means its data was replaced or restored,
+// cursors and snapshots taken before are no longer valid.
+const GenerationHeader = "X-Distrikv-Generation"
+
+// MovedHeader tells clients the shard of a key served by another
+// node and the node's address, as "<shard> <addr>". It's set on
+// wrong_node errors and on responses of forwarded requests.
+const MovedHeader = "X-Distrikv-Moved"
+
+// setMoved sets the moved header for the shard served by node,
+// nodes without an advertised address can't be reached directly.
+func setMoved(ctx *gin.Context, shard int, node cluster.Node) {
+	if node.Addr == "" {
+		return
+	}
+
+	ctx.Header(MovedHeader, fmt.Sprintf("%d %s", shard, node.Addr))
+}
+
+// peersOnly rejects requests of callers that didn't present a
+// certificate of the cluster CA, when the nodes use mTLS.
+func peersOnly(ctx *gin.Context) {
+	if pkg.GetPeerTLS() != nil && !pkg.Verified(ctx.Request.TLS) {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{
+			Code:    CodeUnauthenticated,
+			Message: "a certificate of the cluster ca is required",
+		})
+		return
+	}
+
+	ctx.Next()
+}
+
+// generation sets the generation header of every response.
+func generation(c Cluster) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Header(GenerationHeader, c.Generation())
+		ctx.Next()
+	}
+}
+
+// forward proxies requests for keys owned by other nodes to the
+// owner, requests for local keys continue to the handler.
+// Writes for an unreachable owner are stored as hints when hints
+// isn't nil, and replayed once the owner is back. Conditional writes
+// and writes acknowledged past the memtable aren't hinted, their
+// outcome is only known to the owner.
+func forward(c Cluster, hints Hints) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.Param("key")
+		if key == "" {
+			key = ctx.Query("key")
+		}
+
+		owner, local := c.Owner(key)
+		if local {
+			ctx.Next()
+			return
+		}
+
+		hops := 0
+		if header := ctx.GetHeader(HopsHeader); header != "" {
+			var err error
+			hops, err = strconv.Atoi(header)
+			if err != nil || hops < 0 {
+				abortWithError(ctx, newValidationError("invalid "+HopsHeader+" header", header))
+				return
+			}
+		}
+
+		moved := &cluster.MovedError{Key: key, Shard: c.Shard(key), Node: owner}
+		if hops >= MaxForwardHops {
+			abortWithError(ctx, fmt.Errorf("%w after %d forwards", moved, hops))
+			return
+		}
+
+		ctx.Request.Header.Set(HopsHeader, strconv.Itoa(hops+1))
+
+		target, err := url.Parse(owner.Addr)
+		if err != nil {
+			abortWithError(ctx, fmt.Errorf("invalid address of node %s: %w", owner.ID, err))
+			return
+		}
+
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		proxy.Transport = pkg.PeerTransport()
+
+		reqCtx, span := tracing.Start(ctx.Request.Context(), "forward", tracing.SPAN_KIND_CLIENT, tracing.Attr{Key: "distrikv.node", Value: owner.ID})
+		defer span.End()
+
+		ctx.Request = ctx.Request.WithContext(reqCtx)
+		tracing.Inject(reqCtx, ctx.Request.Header)
+
+		// the owner responds with the generation of the key's data
+		ctx.Writer.Header().Del(GenerationHeader)
+		setMoved(ctx, moved.Shard, moved.Node)
+
+		if hints != nil && ctx.Request.Method != http.MethodGet && ctx.Query("mode") == "" && ackOf(ctx) == replication.ACK_MEMORY {
+			body, err := ctx.GetRawData()
+			if err != nil {
+				abortWithError(ctx, err)
+				return
+			}
+			ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+			proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+				hint := handoff.Hint{
+					Node:        owner.ID,
+					Addr:        owner.Addr,
+					Method:      r.Method,
+					URI:         ctx.Request.RequestURI,
+					ContentType: r.Header.Get("Content-Type"),
+					Body:        body,
+					CreatedAt:   time.Now(),
+				}
+
+				if hintErr := hints.Add(hint); hintErr != nil {
+					abortWithError(ctx, fmt.Errorf("node %s is unreachable: %w", owner.ID, errors.Join(err, hintErr)))
+					return
+				}
+
+				ctx.JSON(http.StatusAccepted, "hinted")
+			}
+		}
+
+		proxy.ServeHTTP(ctx.Writer, ctx.Request)
+		ctx.Abort()
+	}
+}
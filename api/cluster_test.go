@@ -0,0 +1,168 @@
+package api
+
+import (
+	"distrikv/cluster"
+	"distrikv/handoff"
+	"distrikv/replication"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCluster places every key on owner, or on the node itself when local.
+type fakeCluster struct {
+	Cluster
+	owner cluster.Node
+	local bool
+}
+
+func (c *fakeCluster) Owner(key string) (cluster.Node, bool) {
+	return c.owner, c.local
+}
+
+func (c *fakeCluster) Shard(key string) int {
+	return 7
+}
+
+// fakeHints keeps the hints added.
+type fakeHints struct {
+	hints []handoff.Hint
+}
+
+func (h *fakeHints) Add(hint handoff.Hint) error {
+	h.hints = append(h.hints, hint)
+	return nil
+}
+
+func (h *fakeHints) Pending() (map[string]int, error) {
+	return nil, nil
+}
+
+// serveForward serves the keys through forward, over a real server:
+// the reverse proxy needs a response writer a recorder can't stand for.
+func serveForward(t *testing.T, c Cluster, hints Hints) string {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Any("/v1/keys/:key", forward(c, hints), func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, "local")
+	})
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+// call sends a request and returns its response with the body read.
+func call(t *testing.T, req *http.Request) (*http.Response, string) {
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return res, string(body)
+}
+
+func TestForwardToOwner(t *testing.T) {
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("owner " + r.Header.Get(HopsHeader)))
+	}))
+	defer owner.Close()
+
+	c := &fakeCluster{owner: cluster.Node{ID: "b", Addr: owner.URL}}
+	url := serveForward(t, c, nil)
+
+	forwarded := func(hops string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, url+"/v1/keys/a", nil)
+		require.NoError(t, err)
+		if hops != "" {
+			req.Header.Set(HopsHeader, hops)
+		}
+
+		return call(t, req)
+	}
+
+	// the owner answers, told the request was forwarded once more
+	res, body := forwarded("")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "owner 1", body)
+	assert.Equal(t, "7 "+owner.URL, res.Header.Get(MovedHeader))
+
+	_, body = forwarded("2")
+	assert.Equal(t, "owner 3", body)
+
+	// requests forwarded too many times aren't forwarded again
+	res, body = forwarded("3")
+	assert.Equal(t, http.StatusMisdirectedRequest, res.StatusCode)
+	assert.Contains(t, body, CodeWrongNode)
+
+	res, _ = forwarded("x")
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// local keys are served by the node
+	c.local = true
+	_, body = forwarded("3")
+	assert.Equal(t, "local", body)
+}
+
+func TestForwardHintsUnreachableOwner(t *testing.T) {
+	hints := &fakeHints{}
+	c := &fakeCluster{owner: cluster.Node{ID: "b", Addr: "http://127.0.0.1:1"}}
+	url := serveForward(t, c, hints)
+
+	req, err := http.NewRequest(http.MethodPut, url+"/v1/keys/a", strings.NewReader(`{"value":"1"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	res, _ := call(t, req)
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+
+	require.Len(t, hints.hints, 1)
+	hint := hints.hints[0]
+	assert.Equal(t, "b", hint.Node)
+	assert.Equal(t, http.MethodPut, hint.Method)
+	assert.Equal(t, "/v1/keys/a", hint.URI)
+	assert.Equal(t, `{"value":"1"}`, string(hint.Body))
+
+	// reads of an unreachable owner fail
+	req, err = http.NewRequest(http.MethodGet, url+"/v1/keys/a", nil)
+	require.NoError(t, err)
+	res, _ = call(t, req)
+	assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+	assert.Len(t, hints.hints, 1)
+}
+
+func TestGenerationReturned(t *testing.T) {
+	router, keys := newTestRouter(t)
+
+	// every response carries the generation of the node
+	rec := serve(router, keys.admin, http.MethodGet, "/admin/cluster", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	generation := rec.Header().Get(GenerationHeader)
+	assert.NotEmpty(t, generation)
+
+	var info ClusterResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	assert.Equal(t, generation, info.Generation)
+
+	rec = serve(router, keys.reader, http.MethodGet, "/v1/keys/app%2Fa", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, generation, rec.Header().Get(GenerationHeader))
+
+	// standbys compare it with the one they follow
+	rec = serve(router, keys.admin, http.MethodGet, "/internal/changes?from=0", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var changes replication.ChangesResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &changes))
+	assert.Equal(t, generation, changes.Generation)
+}
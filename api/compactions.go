@@ -0,0 +1,89 @@
+package api
+
+import (
+	"distrikv/events"
+	"slices"
+	"sync"
+	"time"
+)
+
+// RecentCompactions is the number of compactions kept for the admin API.
+const RecentCompactions = 50
+
+// Compaction is a compaction that ran on this node.
+type Compaction struct {
+	Time        time.Time `json:"time"`
+	Dir         string    `json:"dir"`
+	Level       int       `json:"level"`
+	OutputLevel int       `json:"output_level"`
+	Inputs      []string  `json:"inputs"`
+	Outputs     []string  `json:"outputs"`
+	Moved       bool      `json:"moved"`
+	InputBytes  int64     `json:"input_bytes"`
+	OutputBytes int64     `json:"output_bytes"`
+	DurationMS  int64     `json:"duration_ms"`
+}
+
+// compactionLog keeps the latest compactions published on the bus.
+type compactionLog struct {
+	mu          sync.Mutex
+	compactions []Compaction
+}
+
+func newCompactionLog(bus *events.Bus) *compactionLog {
+	l := &compactionLog{}
+	if bus == nil {
+		return l
+	}
+
+	sub := bus.Subscribe(events.DefaultBuffer, events.TOPIC_COMPACTION)
+	go func() {
+		for event := range sub.C {
+			data := event.Data.(events.CompactionEvent)
+			l.add(Compaction{
+				Time:        event.Time,
+				Dir:         data.Dir,
+				Level:       data.Level,
+				OutputLevel: data.OutputLevel,
+				Inputs:      data.Inputs,
+				Outputs:     data.Outputs,
+				Moved:       data.Moved,
+				InputBytes:  data.InputBytes,
+				OutputBytes: data.OutputBytes,
+				DurationMS:  data.Duration.Milliseconds(),
+			})
+		}
+	}()
+
+	return l
+}
+
+func (l *compactionLog) add(c Compaction) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.compactions = append(l.compactions, c)
+	if len(l.compactions) > RecentCompactions {
+		l.compactions = slices.Delete(l.compactions, 0, len(l.compactions)-RecentCompactions)
+	}
+}
+
+// recent returns the kept compactions, newest first,
+// at most limit of them unless it's zero.
+func (l *compactionLog) recent(limit int) []Compaction {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	res := slices.Clone(l.compactions)
+	slices.Reverse(res)
+
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+
+	if res == nil {
+		res = []Compaction{}
+	}
+
+	return res
+}
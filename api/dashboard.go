@@ -0,0 +1,114 @@
+package api
+
+import (
+	"distrikv/cluster"
+	"distrikv/membership"
+	"distrikv/replication"
+	"distrikv/storage"
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dashboardRefresh is how often the dashboard page reloads itself.
+const dashboardRefresh = 5 * time.Second
+
+// shardLevels is the level structure of a local shard.
+type shardLevels struct {
+	Shard  int
+	Levels []storage.LevelStats
+}
+
+// dashboardData is rendered by the dashboard template.
+type dashboardData struct {
+	Now         time.Time
+	Refresh     int
+	Health      cluster.Health
+	Cluster     cluster.Info
+	Replication replication.Status
+	Members     []membership.MemberStatus
+	Shards      []shardLevels
+	Compactions []Compaction
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>distrikv {{.Cluster.Self.ID}}</title>
+<style>
+body { font-family: monospace; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.8em; text-align: left; }
+.bad { color: #b00; }
+</style>
+</head>
+<body>
+<h1>distrikv {{.Cluster.Self.ID}}</h1>
+<p>{{.Now.Format "2006-01-02 15:04:05 MST"}},
+{{if .Health.Healthy}}healthy{{else}}<span class="bad">unhealthy</span>{{end}},
+{{.Replication.Role}} at seq {{.Replication.LastSeq}}{{if .Replication.PrimaryURL}} following {{.Replication.PrimaryURL}} at seq {{.Replication.PrimarySeq}}{{end}},
+{{.Cluster.MovesRunning}} shard moves running, generation {{.Cluster.Generation}}</p>
+
+<h2>Nodes</h2>
+<table>
+<tr><th>node</th><th>addr</th><th>shards</th><th>state</th></tr>
+{{range .Cluster.Nodes}}<tr><td>{{.ID}}</td><td>{{.Addr}}</td><td>{{.Shards}}</td><td>{{if .Decommissioned}}decommissioned{{else}}active{{end}}</td></tr>
+{{end}}</table>
+
+{{if .Members}}<h2>Members</h2>
+<table>
+<tr><th>member</th><th>addr</th><th>state</th><th>last seen</th></tr>
+{{range .Members}}<tr><td>{{.ID}}</td><td>{{.Addr}}</td><td>{{.State}}</td><td>{{.LastSeen.Format "15:04:05"}}</td></tr>
+{{end}}</table>
+{{end}}
+
+<h2>Levels</h2>
+<table>
+<tr><th>shard</th><th>level</th><th>files</th><th>bytes</th><th>dead bytes</th></tr>
+{{range $s := .Shards}}{{range .Levels}}<tr><td>{{$s.Shard}}</td><td>{{.Level}}</td><td>{{.Files}}</td><td>{{.Bytes}}</td><td>{{.DeadBytes}}</td></tr>
+{{end}}{{end}}</table>
+
+<h2>Recent compactions</h2>
+<table>
+<tr><th>time</th><th>dir</th><th>levels</th><th>inputs</th><th>outputs</th></tr>
+{{range .Compactions}}<tr><td>{{.Time.Format "15:04:05"}}</td><td>{{.Dir}}</td><td>{{.Level}} &rarr; {{.OutputLevel}}</td><td>{{len .Inputs}}</td><td>{{range $i, $o := .Outputs}}{{if $i}}, {{end}}{{$o}}{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// Dashboard handles GET /dashboard, an HTML page
+// of the admin API state that reloads itself.
+func (h *AdminHandler) Dashboard(ctx *gin.Context) {
+	data := dashboardData{
+		Now:         time.Now(),
+		Refresh:     int(dashboardRefresh.Seconds()),
+		Health:      h.cluster.Health(),
+		Cluster:     h.cluster.Info(),
+		Replication: h.replication.Status(),
+		Compactions: h.compactions.recent(0),
+	}
+
+	if h.membership != nil {
+		data.Members = h.membership.Members()
+	}
+
+	for shard, levels := range h.cluster.Levels() {
+		data.Shards = append(data.Shards, shardLevels{Shard: shard, Levels: levels})
+	}
+
+	sort.Slice(data.Shards, func(a, b int) bool {
+		return data.Shards[a].Shard < data.Shards[b].Shard
+	})
+
+	ctx.Status(http.StatusOK)
+	ctx.Header("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(ctx.Writer, data); err != nil {
+		ctx.Error(err)
+	}
+}
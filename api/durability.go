@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"distrikv/replication"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AckHeader asks for a write acknowledged once durable as its value,
+// "memory", "wal" or "quorum", like the ack query parameter.
+const AckHeader = "X-Distrikv-Ack"
+
+const ackKey = "ack"
+
+// Durability acknowledges the writes of the node.
+type Durability interface {
+	CanAcknowledge(ack replication.Ack) error
+	Acknowledge(ctx context.Context, ack replication.Ack) error
+}
+
+// acknowledgment reads the ack of a write, the query parameter over the
+// header, rejecting unknown ones and the ones durability can't provide
+// before the write is applied.
+func acknowledgment(durability Durability) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		raw := ctx.Query("ack")
+		if raw == "" {
+			raw = ctx.GetHeader(AckHeader)
+		}
+
+		ack, err := replication.ParseAck(raw)
+		if err != nil {
+			abortWithError(ctx, newValidationError("invalid ack", raw))
+			return
+		}
+
+		if err := durability.CanAcknowledge(ack); err != nil {
+			abortWithError(ctx, err)
+			return
+		}
+
+		ctx.Set(ackKey, ack)
+		ctx.Next()
+	}
+}
+
+// ackOf returns the ack of the write of ctx, ACK_MEMORY by default.
+func ackOf(ctx *gin.Context) replication.Ack {
+	a, _ := ctx.Get(ackKey)
+	ack, _ := a.(replication.Ack)
+	return ack
+}
+
+// acknowledge returns err when the write failed, otherwise
+// waits until it is as durable as the client asked for.
+func (h *Handler) acknowledge(ctx *gin.Context, err error) error {
+	if err != nil {
+		return err
+	}
+
+	return h.durability.Acknowledge(ctx.Request.Context(), ackOf(ctx))
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"context"
+	"distrikv/auth"
+	"distrikv/cluster"
+	"distrikv/ops"
+	"distrikv/replication"
+	"distrikv/sequence"
+	"distrikv/storage"
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes of ErrorResponse.
+const (
+	CodeInvalidArgument    = "invalid_argument"
+	CodeNotFound           = "not_found"
+	CodeWrongNode          = "wrong_node"
+	CodeReadOnly           = "read_only"
+	CodeDiskFull           = "disk_full"
+	CodeNotStandby         = "not_standby"
+	CodeChangesUnavailable = "changes_unavailable"
+	CodeNotHandedOff       = "not_handed_off"
+	CodeOperationFinished  = "operation_finished"
+	CodeAlreadyExists      = "already_exists"
+	CodeLeaseHeld          = "lease_held"
+	CodeLeaseNotHeld       = "lease_not_held"
+	CodeStaleRead          = "stale_read"
+	CodeTooLarge           = "too_large"
+	CodeUnauthenticated    = "unauthenticated"
+	CodePermissionDenied   = "permission_denied"
+	CodeRateLimited        = "rate_limited"
+	CodeWatchOverflow      = "watch_overflow"
+	CodeDeadlineExceeded   = "deadline_exceeded"
+	CodeNoQuorum           = "no_quorum"
+	CodeInternal           = "internal"
+)
+
+// ErrorResponse is the body of every failed request.
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// MovedDetails are the details of wrong_node errors
+// of keys served by a known node.
+type MovedDetails struct {
+	Shard int    `json:"shard"`
+	Node  string `json:"node"`
+	Addr  string `json:"addr,omitempty"`
+}
+
+// validationError is returned for invalid client input.
+type validationError struct {
+	message string
+	details any
+}
+
+func (e *validationError) Error() string {
+	return e.message
+}
+
+func newValidationError(message string, details any) error {
+	return &validationError{
+		message: message,
+		details: details,
+	}
+}
+
+// abortWithError maps err to its status code and error response:
+// validation errors are 400, callers without permission are 403,
+// missing keys are 404, existing keys created again and locks held
+// by others are 409, values over
+// the size limit are 413, keys owned by other nodes are 421, key
+// listings over the limit are 429, writes
+// to read-only stores or full disks and writes
+// without standbys for their quorum are 503, requests past their
+// deadline or cancelled are 504, anything else is an internal error.
+func abortWithError(ctx *gin.Context, err error) {
+	var verr *validationError
+	var moved *cluster.MovedError
+
+	switch {
+	case errors.As(err, &verr):
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{
+			Code:    CodeInvalidArgument,
+			Message: verr.message,
+			Details: verr.details,
+		})
+	case errors.Is(err, storage.ErrInvalidTTL),
+		errors.Is(err, storage.ErrNotInteger),
+		errors.Is(err, storage.ErrKeyTooLarge),
+		errors.Is(err, storage.ErrIngestInvalid),
+		errors.Is(err, storage.ErrVersionsNotRetained),
+		errors.Is(err, storage.ErrLeaseOwner),
+		errors.Is(err, replication.ErrNoWAL),
+		errors.Is(err, sequence.ErrInvalidName),
+		errors.Is(err, sequence.ErrInvalidCount),
+		errors.Is(err, sequence.ErrTooManySequences),
+		errors.Is(err, sequence.ErrExhausted):
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{
+			Code:    CodeInvalidArgument,
+			Message: err.Error(),
+		})
+	case errors.Is(err, auth.ErrForbidden):
+		ctx.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{
+			Code:    CodePermissionDenied,
+			Message: err.Error(),
+		})
+	case errors.Is(err, errTooManyListings):
+		ctx.AbortWithStatusJSON(http.StatusTooManyRequests, ErrorResponse{
+			Code:    CodeRateLimited,
+			Message: err.Error(),
+		})
+	case errors.Is(err, storage.ErrValueTooLarge):
+		ctx.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+			Code:    CodeTooLarge,
+			Message: err.Error(),
+		})
+	case errors.Is(err, storage.ErrKeyNotFound),
+		errors.Is(err, ops.ErrUnknownOperation):
+		ctx.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{
+			Code:    CodeNotFound,
+			Message: err.Error(),
+		})
+	case errors.Is(err, storage.ErrReadOnly):
+		ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, ErrorResponse{
+			Code:    CodeReadOnly,
+			Message: err.Error(),
+		})
+	case errors.Is(err, storage.ErrDiskFull):
+		ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, ErrorResponse{
+			Code:    CodeDiskFull,
+			Message: err.Error(),
+		})
+	case errors.Is(err, replication.ErrNoStandbys):
+		ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, ErrorResponse{
+			Code:    CodeNoQuorum,
+			Message: err.Error(),
+		})
+	case errors.Is(err, replication.ErrNotStandby):
+		ctx.AbortWithStatusJSON(http.StatusConflict, ErrorResponse{
+			Code:    CodeNotStandby,
+			Message: err.Error(),
+		})
+	case errors.Is(err, cluster.ErrUnknownNode),
+		errors.Is(err, cluster.ErrDecommissioned),
+		errors.Is(err, cluster.ErrInvalidShard),
+		errors.Is(err, cluster.ErrReplicationFactor):
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{
+			Code:    CodeInvalidArgument,
+			Message: err.Error(),
+		})
+	case errors.Is(err, ops.ErrFinished):
+		ctx.AbortWithStatusJSON(http.StatusConflict, ErrorResponse{
+			Code:    CodeOperationFinished,
+			Message: err.Error(),
+		})
+	case errors.Is(err, os.ErrExist),
+		errors.Is(err, storage.ErrKeyExists):
+		ctx.AbortWithStatusJSON(http.StatusConflict, ErrorResponse{
+			Code:    CodeAlreadyExists,
+			Message: err.Error(),
+		})
+	case errors.Is(err, storage.ErrLeaseHeld):
+		ctx.AbortWithStatusJSON(http.StatusConflict, ErrorResponse{
+			Code:    CodeLeaseHeld,
+			Message: err.Error(),
+		})
+	case errors.Is(err, storage.ErrLeaseNotHeld):
+		ctx.AbortWithStatusJSON(http.StatusConflict, ErrorResponse{
+			Code:    CodeLeaseNotHeld,
+			Message: err.Error(),
+		})
+	case errors.Is(err, cluster.ErrNotHandedOff):
+		ctx.AbortWithStatusJSON(http.StatusConflict, ErrorResponse{
+			Code:    CodeNotHandedOff,
+			Message: err.Error(),
+		})
+	case errors.As(err, &moved):
+		setMoved(ctx, moved.Shard, moved.Node)
+		ctx.AbortWithStatusJSON(http.StatusMisdirectedRequest, ErrorResponse{
+			Code:    CodeWrongNode,
+			Message: err.Error(),
+			Details: MovedDetails{
+				Shard: moved.Shard,
+				Node:  moved.Node.ID,
+				Addr:  moved.Node.Addr,
+			},
+		})
+	case errors.Is(err, cluster.ErrNotOwner):
+		ctx.AbortWithStatusJSON(http.StatusMisdirectedRequest, ErrorResponse{
+			Code:    CodeWrongNode,
+			Message: err.Error(),
+		})
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, context.Canceled):
+		ctx.AbortWithStatusJSON(http.StatusGatewayTimeout, ErrorResponse{
+			Code:    CodeDeadlineExceeded,
+			Message: err.Error(),
+		})
+	default:
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    CodeInternal,
+			Message: err.Error(),
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// etag returns the entity tag of a value of size bytes at version,
+// see storage.KVData.Timestamp, in the content type of the response:
+// every representation of a value has its own tag.
+func etag(version time.Time, size int64, contentType string) string {
+	var representation string
+	switch contentType {
+	case octetStream:
+		representation = "r"
+	case SchemaV1:
+		representation = "v1"
+	default:
+		representation = "j"
+	}
+
+	return `"` + strconv.FormatInt(version.UnixNano(), 36) + "-" + strconv.FormatInt(size, 36) + "-" + representation + `"`
+}
+
+// notModified sets the ETag header of the response and answers 304 when
+// it matches the If-None-Match header of the request, reporting whether
+// it did. Weak tags match like strong ones, "*" matches any tag.
+func notModified(ctx *gin.Context, tag string) bool {
+	ctx.Header("ETag", tag)
+	ctx.Header("Vary", "Accept")
+
+	header := ctx.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			ctx.AbortWithStatus(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AppliedSeqHeader carries the sequence of the last change applied
+// by the node serving a read.
+const AppliedSeqHeader = "X-Distrikv-Applied-Seq"
+
+// StalenessHeader carries how many milliseconds a standby may lag
+// behind the primary: the time since it was last caught up. It's
+// missing on primaries and on standbys that never caught up.
+const StalenessHeader = "X-Distrikv-Staleness-Ms"
+
+// MinSeqHeader asks for a read served from data containing at least
+// the change of this sequence, nodes that are further behind respond
+// with 412 so the client can retry elsewhere.
+const MinSeqHeader = "X-Distrikv-Min-Seq"
+
+// StaleDetails are the details of stale_read errors.
+type StaleDetails struct {
+	AppliedSeq uint64 `json:"applied_seq"`
+	MinSeq     uint64 `json:"min_seq"`
+}
+
+// freshness sets the freshness headers of reads
+// and rejects reads asking for unapplied changes.
+func freshness(r Replication) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		state := r.Freshness()
+
+		ctx.Header(AppliedSeqHeader, strconv.FormatUint(state.AppliedSeq, 10))
+		if state.Standby && !state.SyncedAt.IsZero() {
+			ctx.Header(StalenessHeader, strconv.FormatInt(time.Since(state.SyncedAt).Milliseconds(), 10))
+		}
+
+		header := ctx.GetHeader(MinSeqHeader)
+		if header == "" {
+			ctx.Next()
+			return
+		}
+
+		minSeq, err := strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			abortWithError(ctx, newValidationError("invalid "+MinSeqHeader+" header", header))
+			return
+		}
+
+		if state.AppliedSeq < minSeq {
+			ctx.AbortWithStatusJSON(http.StatusPreconditionFailed, ErrorResponse{
+				Code:    CodeStaleRead,
+				Message: "node has not applied the requested sequence yet",
+				Details: StaleDetails{
+					AppliedSeq: state.AppliedSeq,
+					MinSeq:     minSeq,
+				},
+			})
+			return
+		}
+
+		ctx.Next()
+	}
+}
@@ -1,43 +1,576 @@
 package api
 
 import (
+	"context"
+	"distrikv/auth"
+	"distrikv/config"
+	"distrikv/events"
+	"distrikv/ops"
+	"distrikv/pkg"
 	"distrikv/storage"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// octetStream is the content type of raw values.
+const octetStream = "application/octet-stream"
+
 type Store interface {
-	Get(key string) (*storage.KVData, error)
-	Set(key string, value string)
+	Get(ctx context.Context, key string) (*storage.KVData, error)
+	GetAt(ctx context.Context, key string, t time.Time) (*storage.KVData, error)
+	Versions(ctx context.Context, key string) ([]storage.Version, error)
+	MGet(ctx context.Context, keys []string) ([]*storage.KVData, error)
+	GetValue(ctx context.Context, key string) (*storage.ValueReader, error)
+	Set(key string, value string) error
+	Delete(key string) error
+	SetWithTTL(key string, value string, ttl time.Duration) error
+	SetWithMode(key string, value string, ttl time.Duration, mode storage.SetMode) error
+	Incr(key string, delta int64) (*storage.KVData, error)
+	GetDel(key string) (*storage.KVData, error)
+	GetSet(key string, value string) (*storage.KVData, error)
+	Append(key string, suffix string) (*storage.KVData, error)
+	AcquireLease(key string, owner string, ttl time.Duration) (*storage.KVData, error)
+	RenewLease(key string, owner string, ttl time.Duration) (*storage.KVData, error)
+	ReleaseLease(key string, owner string) error
+	Write(ops []storage.BatchOp) error
+	Scan(ctx context.Context, start string, end string, fn func(*storage.KVData) bool) error
+}
+
+// setRequest is the JSON body of PUT /v1/keys/:key.
+type setRequest struct {
+	Value string `json:"value"`
+}
+
+// incrRequest is the optional JSON body of POST /v1/keys/:key/incr.
+type incrRequest struct {
+	Delta *int64 `json:"delta"`
+}
+
+// incrResponse is the body of POST /v1/keys/:key/incr.
+type incrResponse struct {
+	Key   string `json:"key"`
+	Value int64  `json:"value"`
+}
+
+// appendResponse is the body of PATCH /v1/keys/:key,
+// Length is the size in bytes of the value appended to.
+type appendResponse struct {
+	Key    string `json:"key"`
+	Length int    `json:"length"`
+}
+
+// ttlResponse is the body of GET /v1/keys/:key/ttl.
+// TTL is in seconds, -1 for keys that don't expire.
+type ttlResponse struct {
+	Key       string    `json:"key"`
+	TTL       int64     `json:"ttl"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// mgetRequest is the JSON body of POST /v1/mget.
+type mgetRequest struct {
+	Keys []string `json:"keys" binding:"required"`
+}
+
+// mgetResponse is the body of POST /v1/mget, Items
+// follow the requested keys and are null for missing keys.
+type mgetResponse struct {
+	Items []*storage.KVData `json:"items"`
+}
+
+// scanResponse is a page of GET /v1/scan.
+// NextCursor is empty on the last page.
+type scanResponse struct {
+	Items      []*storage.KVData `json:"items"`
+	NextCursor string            `json:"next_cursor"`
 }
 
 type Handler struct {
-	store Store
+	store      Store
+	durability Durability
+	cfg        config.Config
+
+	// jobs runs the imports.
+	jobs *ops.Registry
+
+	// events feed the watches, which end once stop is closed.
+	events *events.Bus
+	stop   <-chan struct{}
+
+	// sequences is nil without the sequence routes.
+	sequences Sequences
+
+	listings *keyListings
 }
 
-func NewHandler(store Store) *Handler {
+// NewHandler creates the handler of the key routes,
+// the watches of the writes of bus end when ctx is done.
+func NewHandler(ctx context.Context, store Store, durability Durability, jobs *ops.Registry, bus *events.Bus, sequences Sequences, cfg config.Config) *Handler {
 	return &Handler{
-		store: store,
+		store:      store,
+		durability: durability,
+		cfg:        cfg,
+		jobs:       jobs,
+		events:     bus,
+		stop:       ctx.Done(),
+		sequences:  sequences,
+		listings:   newKeyListings(),
+	}
+}
+
+// GetKey handles GET /v1/keys/:key. With ?at=, an RFC 3339 time or unix
+// seconds, the version of the key visible at that time is returned,
+// read from the previous versions the store retains. The latest version
+// carries an ETag, requests with a matching If-None-Match get a 304.
+func (h *Handler) GetKey(ctx *gin.Context) {
+	format := ctx.NegotiateFormat(gin.MIMEJSON, octetStream, SchemaV1)
+	raw := format == octetStream
+	at := ctx.Query("at")
+	if raw && at == "" {
+		h.getRawValue(ctx)
+		return
+	}
+
+	var res *storage.KVData
+	var err error
+	if at == "" {
+		res, err = h.store.Get(ctx.Request.Context(), ctx.Param("key"))
+	} else {
+		var t time.Time
+		t, err = parseTime(at)
+		if err == nil {
+			res, err = h.store.GetAt(ctx.Request.Context(), ctx.Param("key"), t)
+		}
+	}
+
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	if at == "" && notModified(ctx, etag(res.Timestamp, int64(len(res.Value)), format)) {
+		return
+	}
+
+	if raw {
+		ctx.Data(http.StatusOK, octetStream, []byte(res.Value))
+		return
+	}
+
+	respond(ctx, res, func() any { return newItem(res) })
+}
+
+// parseTime parses an RFC 3339 time or unix seconds.
+func parseTime(raw string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return time.Time{}, newValidationError("invalid time, expected RFC 3339 or unix seconds", raw)
+	}
+
+	return t, nil
+}
+
+// getRawValue writes the value of a key as the response body, without
+// JSON. Values held in SST files are copied from the file to the
+// connection, which uses sendfile where the platform supports it.
+func (h *Handler) getRawValue(ctx *gin.Context) {
+	value, err := h.store.GetValue(ctx.Request.Context(), ctx.Param("key"))
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+	defer value.Close()
+
+	// unchanged values aren't read
+	if notModified(ctx, etag(value.Timestamp, value.Size, octetStream)) {
+		return
+	}
+
+	header := ctx.Writer.Header()
+	header.Set("Content-Type", octetStream)
+	header.Set("Content-Length", strconv.FormatInt(value.Size, 10))
+	ctx.Writer.WriteHeaderNow()
+
+	// gin's writer doesn't implement io.ReaderFrom
+	var w io.Writer = ctx.Writer
+	if u, ok := ctx.Writer.(interface{ Unwrap() http.ResponseWriter }); ok {
+		w = u.Unwrap()
+	}
+
+	// the status is sent, a failed copy only shows as a short body
+	if _, err := io.Copy(w, value); err != nil {
+		ctx.Error(err)
+	}
+}
+
+// PutKey handles PUT /v1/keys/:key.
+// The value is read from a JSON body, any other
+// content type is stored as the raw body.
+func (h *Handler) PutKey(ctx *gin.Context) {
+	value, err := readValue(ctx)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	if err := h.set(ctx, ctx.Param("key"), value); err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	respond(ctx, "success", func() any { return WriteResult{Key: ctx.Param("key")} })
+}
+
+// PatchKey handles PATCH /v1/keys/:key, appending the value of the
+// body, read like the one of PutKey, to the value of the key.
+func (h *Handler) PatchKey(ctx *gin.Context) {
+	suffix, err := readValue(ctx)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	res, err := h.store.Append(ctx.Param("key"), suffix)
+	if err := h.acknowledge(ctx, err); err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	patched := appendResponse{
+		Key:    res.Key,
+		Length: len(res.Value),
+	}
+
+	respond(ctx, patched, func() any { return patched })
+}
+
+// readValue reads the value of a JSON body,
+// or the raw body of any other content type.
+func readValue(ctx *gin.Context) (string, error) {
+	if strings.HasPrefix(ctx.ContentType(), "application/json") {
+		var req setRequest
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			return "", newValidationError("invalid request body", err.Error())
+		}
+
+		return req.Value, nil
+	}
+
+	// larger bodies aren't read, they can't be stored
+	body, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, int64(storage.MaxValueSize)))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return "", fmt.Errorf("%w: more than %d bytes", storage.ErrValueTooLarge, storage.MaxValueSize)
+	}
+
+	if err != nil {
+		return "", newValidationError("invalid request body", err.Error())
+	}
+
+	return string(body), nil
+}
+
+// set stores key, expiring it after the ttl query parameter
+// when given, either a duration ("90s", "1h") or seconds ("90").
+// The mode query parameter, "if-not-exists" or "must-exist",
+// only creates or only overwrites the key. It returns once the
+// write is acknowledged, see acknowledgment.
+func (h *Handler) set(ctx *gin.Context, key string, value string) error {
+	mode, err := storage.ParseSetMode(ctx.Query("mode"))
+	if err != nil {
+		return newValidationError("invalid mode", ctx.Query("mode"))
+	}
+
+	var ttl time.Duration
+	if raw := ctx.Query("ttl"); raw != "" {
+		ttl, err = parseTTL(raw)
+		if err != nil {
+			return newValidationError("invalid ttl", raw)
+		}
+	}
+
+	switch {
+	case mode != storage.SET_ALWAYS:
+		err = h.store.SetWithMode(key, value, ttl, mode)
+	case ttl > 0:
+		err = h.store.SetWithTTL(key, value, ttl)
+	default:
+		err = h.store.Set(key, value)
+	}
+
+	return h.acknowledge(ctx, err)
+}
+
+func parseTTL(raw string) (time.Duration, error) {
+	if seconds, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		raw = strconv.FormatInt(seconds, 10) + "s"
 	}
+
+	ttl, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, err
+	}
+
+	if ttl <= 0 {
+		return 0, storage.ErrInvalidTTL
+	}
+
+	return ttl, nil
+}
+
+// GetDel handles POST /v1/keys/:key/getdel, deleting
+// the key and returning the entry it held.
+func (h *Handler) GetDel(ctx *gin.Context) {
+	res, err := h.store.GetDel(ctx.Param("key"))
+	if err := h.acknowledge(ctx, err); err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	respond(ctx, res, func() any { return newItem(res) })
+}
+
+// GetSet handles POST /v1/keys/:key/getset, storing the value of the
+// JSON body and returning the entry it replaced, null for a new key.
+func (h *Handler) GetSet(ctx *gin.Context) {
+	var req setRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		abortWithError(ctx, newValidationError("invalid request body", err.Error()))
+		return
+	}
+
+	res, err := h.store.GetSet(ctx.Param("key"), req.Value)
+	if err := h.acknowledge(ctx, err); err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	respond(ctx, res, func() any { return newItem(res) })
+}
+
+// TTL handles GET /v1/keys/:key/ttl.
+func (h *Handler) TTL(ctx *gin.Context) {
+	res, err := h.store.Get(ctx.Request.Context(), ctx.Param("key"))
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ttl := ttlResponse{
+		Key: res.Key,
+		TTL: -1,
+	}
+
+	if !res.ExpiresAt.IsZero() {
+		ttl.TTL = int64(time.Until(res.ExpiresAt).Round(time.Second) / time.Second)
+		ttl.ExpiresAt = res.ExpiresAt
+	}
+
+	respond(ctx, ttl, func() any { return ttl })
+}
+
+// Versions handles GET /v1/keys/:key/versions, listing the versions
+// of the key the store retains, newest first, deletes included.
+func (h *Handler) Versions(ctx *gin.Context) {
+	key := ctx.Param("key")
+
+	versions, err := h.store.Versions(ctx.Request.Context(), key)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	list := newVersionList(key, versions)
+	respond(ctx, list, func() any { return list })
+}
+
+// Incr handles POST /v1/keys/:key/incr, adding delta to the
+// integer value of the key. Delta is 1 unless set in the body.
+func (h *Handler) Incr(ctx *gin.Context) {
+	var req incrRequest
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			abortWithError(ctx, newValidationError("invalid request body", err.Error()))
+			return
+		}
+	}
+
+	delta := int64(1)
+	if req.Delta != nil {
+		delta = *req.Delta
+	}
+
+	res, err := h.store.Incr(ctx.Param("key"), delta)
+	if err := h.acknowledge(ctx, err); err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	value, err := strconv.ParseInt(res.Value, 10, 64)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	incr := incrResponse{
+		Key:   res.Key,
+		Value: value,
+	}
+
+	respond(ctx, incr, func() any { return incr })
+}
+
+// DeleteKey handles DELETE /v1/keys/:key.
+func (h *Handler) DeleteKey(ctx *gin.Context) {
+	if err := h.acknowledge(ctx, h.store.Delete(ctx.Param("key"))); err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	respond(ctx, "success", func() any { return WriteResult{Key: ctx.Param("key")} })
+}
+
+// Scan handles GET /v1/scan?start=&end=&limit=&cursor=.
+// Pages are capped at the configured maximum and
+// continued by passing next_cursor as cursor.
+func (h *Handler) Scan(ctx *gin.Context) {
+	start := ctx.Query("start")
+	end := ctx.Query("end")
+
+	var after string
+	if cursor := ctx.Query("cursor"); cursor != "" {
+		var err error
+		after, err = pkg.DecodeCursor(cursor)
+		if err != nil {
+			abortWithError(ctx, newValidationError("invalid cursor", nil))
+			return
+		}
+
+		start = after
+	}
+
+	var limit int
+	if l := ctx.Query("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			abortWithError(ctx, newValidationError("invalid limit", ctx.Query("limit")))
+			return
+		}
+	}
+	limit = h.cfg.ScanLimit(limit)
+
+	res := scanResponse{
+		Items: make([]*storage.KVData, 0),
+	}
+
+	// callers scoped to prefixes only see their keys
+	p := principal(ctx)
+
+	// one extra key is read to know if there is a next page
+	fn := func(data *storage.KVData) bool {
+		if p != nil && !p.CanAccess(data.Key) {
+			return true
+		}
+
+		if len(res.Items) == limit {
+			res.NextCursor = pkg.EncodeCursor(res.Items[limit-1].Key)
+			return false
+		}
+
+		res.Items = append(res.Items, data)
+		return true
+	}
+
+	// a continued scan starts after the last key of the previous page
+	if after != "" {
+		cmp, err := storage.ParseComparator(h.cfg.Comparator)
+		if err != nil {
+			abortWithError(ctx, err)
+			return
+		}
+
+		fn = storage.After(cmp, after, fn)
+	}
+
+	err := h.store.Scan(ctx.Request.Context(), start, end, fn)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	respond(ctx, res, func() any { return newItemList(res.Items, res.NextCursor) })
 }
 
+// MGet handles POST /v1/mget. Every key must be owned by this
+// node, keys of other nodes are rejected with wrong_node.
+func (h *Handler) MGet(ctx *gin.Context) {
+	var req mgetRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		abortWithError(ctx, newValidationError("invalid request body", err.Error()))
+		return
+	}
+
+	if len(req.Keys) > h.cfg.MaxBatchSize {
+		abortWithError(ctx, newValidationError(fmt.Sprintf("mget exceeds %d keys", h.cfg.MaxBatchSize), len(req.Keys)))
+		return
+	}
+
+	if err := principal(ctx).Authorize(auth.ROLE_READ, req.Keys...); err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	items, err := h.store.MGet(ctx.Request.Context(), req.Keys)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	respond(ctx, mgetResponse{Items: items}, func() any { return newItemList(items, "") })
+}
+
+// Get is the deprecated query parameter variant of GetKey.
 func (h *Handler) Get(ctx *gin.Context) {
 	key := ctx.Query("key")
+	if key == "" {
+		abortWithError(ctx, newValidationError("key is required", nil))
+		return
+	}
 
-	res, err := h.store.Get(key)
+	res, err := h.store.Get(ctx.Request.Context(), key)
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, err)
+		abortWithError(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, res)
+
+	respond(ctx, res, func() any { return newItem(res) })
 }
 
+// Set is the deprecated query parameter variant of PutKey.
 func (h *Handler) Set(ctx *gin.Context) {
 	key := ctx.Query("key")
 	value := ctx.Query("value")
+	if key == "" {
+		abortWithError(ctx, newValidationError("key is required", nil))
+		return
+	}
 
-	h.store.Set(key, value)
+	if err := h.set(ctx, key, value); err != nil {
+		abortWithError(ctx, err)
+		return
+	}
 
-	ctx.JSON(http.StatusOK, "success")
+	respond(ctx, "success", func() any { return WriteResult{Key: key} })
 }
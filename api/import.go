@@ -0,0 +1,169 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"distrikv/auth"
+	"distrikv/ops"
+	"distrikv/storage"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OP_KIND_IMPORT is the operation kind of POST /v1/import.
+const OP_KIND_IMPORT = "import"
+
+// Imported keys are written in batches of up to importBatchKeys
+// keys and importBatchBytes bytes of keys and values.
+const (
+	importBatchKeys  = 10000
+	importBatchBytes = 8 << 20
+)
+
+// Import handles POST /v1/import, loading the NDJSON body, one Item per
+// line as written by distrikv export, as a job. Keys are written in large
+// batches through the replication changelog, like batch writes: they land
+// in the memtables and reach the disk, synced, when a memtable is flushed,
+// not per key. Keys already expired are skipped, expiring keys are written
+// one by one.
+//
+// The 202 status and the Location of the job, GET /v1/jobs/:id, are sent
+// before the body is read, so the progress can be polled during the upload.
+// The response body is the job once it finished. Batches written before a
+// failure are kept. Every key must be owned by this node, keys owned by
+// other nodes fail the job.
+func (h *Handler) Import(ctx *gin.Context) {
+	body, principal := ctx.Request.Body, principal(ctx)
+	job := h.jobs.Start(OP_KIND_IMPORT, "import from "+ctx.ClientIP(), func(jobCtx context.Context, p *ops.Progress) error {
+		return h.importRecords(jobCtx, body, principal, p)
+	})
+
+	// without it the server reads the whole body before sending the status
+	rc := http.NewResponseController(ctx.Writer)
+	_ = rc.EnableFullDuplex()
+
+	ctx.Header("Location", "/v1/jobs/"+job.ID)
+	ctx.Header("Content-Type", gin.MIMEJSON)
+	ctx.Status(http.StatusAccepted)
+	ctx.Writer.WriteHeaderNow()
+	ctx.Writer.Flush()
+
+	// a client gone stops the reads of the body, which fails the job
+	job, err := h.jobs.Wait(context.WithoutCancel(ctx.Request.Context()), job.ID)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, job)
+}
+
+// importRecords writes the keys of body until its end or ctx is done,
+// keys principal may not write fail the import.
+func (h *Handler) importRecords(ctx context.Context, body io.Reader, principal *auth.Principal, p *ops.Progress) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(nil, storage.MaxKeySize+4*storage.MaxValueSize)
+
+	var batch []storage.BatchOp
+	var size int
+
+	write := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := h.store.Write(batch); err != nil {
+			return err
+		}
+
+		p.Add(int64(len(batch)))
+		batch, size = nil, 0
+		return nil
+	}
+
+	var line int
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var item Item
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if err := principal.Authorize(auth.ROLE_WRITE, item.Key); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if item.Encoding != "" && item.Encoding != ENCODING_UTF8 && item.Encoding != ENCODING_BASE64 {
+			return fmt.Errorf("line %d: unknown encoding %q", line, item.Encoding)
+		}
+
+		value, err := item.RawValue()
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if !item.ExpiresAt.IsZero() {
+			ttl := time.Until(item.ExpiresAt)
+			if ttl <= 0 {
+				continue
+			}
+
+			// batches don't carry ttls, the key follows the keys before it
+			if err := write(); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+
+			if err := h.store.SetWithTTL(item.Key, value, ttl); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+
+			p.Add(1)
+			continue
+		}
+
+		batch = append(batch, storage.BatchOp{Type: storage.BATCH_PUT, Key: item.Key, Value: value})
+		size += len(item.Key) + len(value)
+		if len(batch) < importBatchKeys && size < importBatchBytes {
+			continue
+		}
+
+		if err := write(); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("line %d: %w", line+1, err)
+	}
+
+	return write()
+}
+
+// Job handles GET /v1/jobs/:id, the progress of an import,
+// Done counts the keys written so far.
+func (h *Handler) Job(ctx *gin.Context) {
+	job, err := h.jobs.Get(ctx.Param("id"))
+	if err == nil && job.Kind != OP_KIND_IMPORT {
+		err = ops.ErrUnknownOperation
+	}
+
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, job)
+}
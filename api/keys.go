@@ -0,0 +1,243 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"distrikv/auth"
+	"distrikv/storage"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// listingIdleTimeout is how long a key listing is kept
+	// without a request for its next page.
+	listingIdleTimeout = time.Minute
+
+	// maxListings caps the key listings kept at once.
+	maxListings = 1000
+)
+
+var (
+	errTooManyListings = fmt.Errorf("more than %d key listings in progress", maxListings)
+	errListingStopped  = errors.New("key listing stopped by the shutdown of the node")
+)
+
+// keyListResponse is a page of GET /v1/keys.
+// NextCursor is empty on the last page.
+type keyListResponse struct {
+	Keys       []string `json:"keys"`
+	NextCursor string   `json:"next_cursor"`
+}
+
+// keyListing is a scan paused between the pages of a listing. It holds
+// the memtables and SSTs it started with until it ends, so compactions
+// finishing during the listing don't move keys around it.
+type keyListing struct {
+	principal *auth.Principal
+
+	// mu serializes the pages read, head is the key read ahead
+	mu      sync.Mutex
+	keys    chan string
+	err     chan error
+	head    string
+	hasHead bool
+	ended   bool
+
+	done  chan struct{}
+	close sync.Once
+	timer *time.Timer
+}
+
+// keyListings are the listings of a node by cursor.
+type keyListings struct {
+	mu       sync.Mutex
+	listings map[string]*keyListing
+}
+
+func newKeyListings() *keyListings {
+	return &keyListings{listings: make(map[string]*keyListing)}
+}
+
+// start scans the keys starting with prefix for p,
+// the listing ends once its keys are read, stop is closed
+// or it wasn't read for listingIdleTimeout.
+func (l *keyListings) start(store Store, p *auth.Principal, prefix string, stop <-chan struct{}) (string, *keyListing, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.listings) >= maxListings {
+		return "", nil, errTooManyListings
+	}
+
+	cursor := rand.Text()
+	listing := &keyListing{
+		principal: p,
+		keys:      make(chan string),
+		err:       make(chan error, 1),
+		done:      make(chan struct{}),
+	}
+
+	listing.timer = time.AfterFunc(listingIdleTimeout, func() { l.end(cursor, listing) })
+	l.listings[cursor] = listing
+
+	go func() {
+		// the scan outlives the request starting it, it ends with the listing
+		stopped := false
+		err := store.Scan(context.Background(), prefix, storage.PrefixEnd(prefix), func(data *storage.KVData) bool {
+			if !strings.HasPrefix(data.Key, prefix) || (p != nil && !p.CanAccess(data.Key)) {
+				return true
+			}
+
+			select {
+			case listing.keys <- data.Key:
+				return true
+			case <-listing.done:
+				return false
+			case <-stop:
+				stopped = true
+				return false
+			}
+		})
+
+		if err == nil && stopped {
+			err = errListingStopped
+		}
+
+		listing.err <- err
+		close(listing.keys)
+	}()
+
+	return cursor, listing, nil
+}
+
+// get returns the listing of cursor, nil if it ended or expired.
+func (l *keyListings) get(cursor string) *keyListing {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.listings[cursor]
+}
+
+// end stops the scan of listing and forgets it.
+func (l *keyListings) end(cursor string, listing *keyListing) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	listing.close.Do(func() { close(listing.done) })
+	listing.timer.Stop()
+	if l.listings[cursor] == listing {
+		delete(l.listings, cursor)
+	}
+}
+
+// page returns the next limit keys of the listing and whether more
+// keys follow. One key is read ahead to know if there is a next page.
+func (k *keyListing) page(limit int) ([]string, bool, error) {
+	keys := make([]string, 0, limit)
+	if k.hasHead {
+		keys = append(keys, k.head)
+		k.hasHead = false
+	}
+
+	for !k.ended {
+		key, ok := <-k.keys
+		if !ok {
+			k.ended = true
+			break
+		}
+
+		if len(keys) == limit {
+			k.head, k.hasHead = key, true
+			return keys, true, nil
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, false, <-k.err
+}
+
+// ListKeys handles GET /v1/keys?prefix=&limit=&cursor=, listing the
+// keys of the node without their values. The first page starts a scan
+// of the keys kept open on the node between pages: the cursor of a page
+// continues it, on this node only, until it wasn't used for a minute.
+// Keys live for the whole listing are listed once, keys written or
+// deleted during the listing may or may not be.
+func (h *Handler) ListKeys(ctx *gin.Context) {
+	var limit int
+	if l := ctx.Query("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			abortWithError(ctx, newValidationError("invalid limit", ctx.Query("limit")))
+			return
+		}
+	}
+	limit = h.cfg.ScanLimit(limit)
+
+	p := principal(ctx)
+
+	cursor := ctx.Query("cursor")
+	var listing *keyListing
+	if cursor == "" {
+		var err error
+		cursor, listing, err = h.listings.start(h.store, p, ctx.Query("prefix"), h.stop)
+		if err != nil {
+			abortWithError(ctx, err)
+			return
+		}
+	} else {
+		listing = h.listings.get(cursor)
+
+		// cursors are only continued by the principal that started them
+		if listing == nil || !samePrincipal(listing.principal, p) {
+			abortWithError(ctx, newValidationError("unknown or expired cursor", nil))
+			return
+		}
+	}
+
+	listing.mu.Lock()
+	defer listing.mu.Unlock()
+
+	// a listing expiring now is ended
+	if !listing.timer.Stop() {
+		abortWithError(ctx, newValidationError("unknown or expired cursor", nil))
+		return
+	}
+
+	keys, more, err := listing.page(limit)
+	if !more {
+		h.listings.end(cursor, listing)
+	} else {
+		listing.timer.Reset(listingIdleTimeout)
+	}
+
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	res := keyListResponse{Keys: keys}
+	if more {
+		res.NextCursor = cursor
+	}
+
+	respond(ctx, res, func() any { return res })
+}
+
+// samePrincipal reports whether a and b, nil without
+// authentication, are the same caller.
+func samePrincipal(a *auth.Principal, b *auth.Principal) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Name == b.Name && a.Kind == b.Kind
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"crypto/rand"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultLockTTL is the ttl of the locks acquired
+// or renewed without the ttl query parameter.
+const defaultLockTTL = 10 * time.Second
+
+// lockRequest is the JSON body of the lock routes, Owner is
+// generated when acquiring a lock without one.
+type lockRequest struct {
+	Owner string `json:"owner"`
+}
+
+// lockResponse is the body of the acquire and renew lock routes,
+// the lock is held by Owner until ExpiresAt.
+type lockResponse struct {
+	Key       string    `json:"key"`
+	Owner     string    `json:"owner"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// AcquireLock handles POST /v1/locks/:key/acquire?ttl=, holding the lock
+// of the key for its owner. Locks held by another owner are conflicts.
+func (h *Handler) AcquireLock(ctx *gin.Context) {
+	req, ttl, ok := bindLock(ctx)
+	if !ok {
+		return
+	}
+
+	if req.Owner == "" {
+		req.Owner = rand.Text()
+	}
+
+	res, err := h.store.AcquireLease(ctx.Param("key"), req.Owner, ttl)
+	if err := h.acknowledge(ctx, err); err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	lock := lockResponse{Key: res.Key, Owner: res.Value, ExpiresAt: res.ExpiresAt}
+	respond(ctx, lock, func() any { return lock })
+}
+
+// RenewLock handles POST /v1/locks/:key/renew?ttl=, extending the
+// lock of the key held by its owner to ttl from now.
+func (h *Handler) RenewLock(ctx *gin.Context) {
+	req, ttl, ok := bindLock(ctx)
+	if !ok {
+		return
+	}
+
+	res, err := h.store.RenewLease(ctx.Param("key"), req.Owner, ttl)
+	if err := h.acknowledge(ctx, err); err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	lock := lockResponse{Key: res.Key, Owner: res.Value, ExpiresAt: res.ExpiresAt}
+	respond(ctx, lock, func() any { return lock })
+}
+
+// ReleaseLock handles POST /v1/locks/:key/release,
+// freeing the lock of the key held by its owner.
+func (h *Handler) ReleaseLock(ctx *gin.Context) {
+	req, _, ok := bindLock(ctx)
+	if !ok {
+		return
+	}
+
+	if err := h.acknowledge(ctx, h.store.ReleaseLease(ctx.Param("key"), req.Owner)); err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	respond(ctx, "success", func() any { return WriteResult{Key: ctx.Param("key")} })
+}
+
+// bindLock reads the body and the ttl of a lock route,
+// aborting the request when they're invalid.
+func bindLock(ctx *gin.Context) (lockRequest, time.Duration, bool) {
+	var req lockRequest
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			abortWithError(ctx, newValidationError("invalid request body", err.Error()))
+			return req, 0, false
+		}
+	}
+
+	ttl := defaultLockTTL
+	if raw := ctx.Query("ttl"); raw != "" {
+		var err error
+		ttl, err = parseTTL(raw)
+		if err != nil {
+			abortWithError(ctx, newValidationError("invalid ttl", raw))
+			return req, 0, false
+		}
+	}
+
+	return req, ttl, true
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"distrikv/pkg"
+	"distrikv/ratelimit"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimited rejects the requests of clients over their limits with
+// 429 and the seconds to wait in Retry-After. The bytes of the request
+// and response bodies are charged once the request is served. Health
+// checks and peers aren't limited.
+func rateLimited(l *ratelimit.Limiter) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.Request.URL.Path == "/healthz" || ctx.Request.URL.Path == "/readyz" || pkg.Verified(ctx.Request.TLS) {
+			ctx.Next()
+			return
+		}
+
+		key := ratelimit.Key(principal(ctx), ctx.Request.RemoteAddr)
+		if wait := l.Allow(key); wait > 0 {
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, ErrorResponse{
+				Code:    CodeRateLimited,
+				Message: fmt.Sprintf("rate limit exceeded, retry in %s", wait),
+			})
+			return
+		}
+
+		body := &countingReader{ReadCloser: ctx.Request.Body}
+		ctx.Request.Body = body
+
+		ctx.Next()
+
+		l.Charge(key, body.n+int64(max(ctx.Writer.Size(), 0)))
+	}
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -1,11 +1,124 @@
 package api
 
-import "github.com/gin-gonic/gin"
+import (
+	"distrikv/auth"
 
-func Routes(router *gin.Engine, handler *Handler) {
-	routes := router.Group("/")
+	"github.com/gin-gonic/gin"
+)
+
+func Routes(
+	router *gin.Engine,
+	handler *Handler,
+	adminHandler *AdminHandler,
+	legacyRoutes bool,
+) {
+	router.Use(generation(adminHandler.cluster))
+
+	keyRoute := forward(adminHandler.cluster, adminHandler.hints)
+
+	// locks and the writes returning the previous value must be
+	// answered by the owner, hints would leave them without an answer
+	ownerRoute := forward(adminHandler.cluster, nil)
+	read := freshness(adminHandler.replication)
+
+	reader, writer := authorize(auth.ROLE_READ), authorize(auth.ROLE_WRITE)
+	bounded := deadline(handler.cfg.RequestTimeout)
+	acked := acknowledgment(handler.durability)
+
+	v1 := router.Group("/v1")
+	{
+		v1.GET("/keys", reader, read, handler.ListKeys)
+		v1.GET("/keys/:key", reader, bounded, keyRoute, read, handler.GetKey)
+		v1.GET("/keys/:key/ttl", reader, bounded, keyRoute, read, handler.TTL)
+		v1.GET("/keys/:key/versions", reader, bounded, keyRoute, read, handler.Versions)
+		v1.PUT("/keys/:key", writer, bounded, acked, keyRoute, handler.PutKey)
+		v1.PATCH("/keys/:key", writer, bounded, acked, keyRoute, handler.PatchKey)
+		v1.DELETE("/keys/:key", writer, bounded, acked, keyRoute, handler.DeleteKey)
+		v1.POST("/keys/:key/incr", writer, bounded, acked, keyRoute, handler.Incr)
+		v1.POST("/keys/:key/getdel", writer, bounded, acked, ownerRoute, handler.GetDel)
+		v1.POST("/keys/:key/getset", writer, bounded, acked, ownerRoute, handler.GetSet)
+		v1.POST("/locks/:key/acquire", writer, bounded, acked, ownerRoute, handler.AcquireLock)
+		v1.POST("/locks/:key/renew", writer, bounded, acked, ownerRoute, handler.RenewLock)
+		v1.POST("/locks/:key/release", writer, bounded, acked, ownerRoute, handler.ReleaseLock)
+		v1.POST("/mget", reader, bounded, read, handler.MGet)
+		v1.GET("/scan", reader, bounded, read, handler.Scan)
+		v1.POST("/import", writer, handler.Import)
+		v1.GET("/jobs/:id", reader, handler.Job)
+	}
+
+	if handler.sequences != nil {
+		v1.POST("/sequences/:name/next", writer, handler.NextSequence)
+	}
+
+	if handler.events != nil {
+		v1.GET("/watch", reader, handler.Watch)
+		v1.GET("/changelog", reader, adminHandler.Changelog)
+	}
+
+	router.GET("/healthz", adminHandler.Health)
+	router.GET("/readyz", adminHandler.Ready)
+	router.GET("/dashboard", authorize(auth.ROLE_ADMIN), adminHandler.Dashboard)
+
+	admin := router.Group("/admin", authorize(auth.ROLE_ADMIN))
+	{
+		admin.GET("/ring", adminHandler.Ring)
+		admin.GET("/replication", adminHandler.Replication)
+		admin.POST("/promote", adminHandler.Promote)
+		admin.GET("/rebalance/status", adminHandler.Rebalance)
+		admin.GET("/levels", adminHandler.Levels)
+		admin.GET("/cache", adminHandler.Caches)
+		admin.GET("/stats", adminHandler.Stats)
+		admin.GET("/compactions", adminHandler.Compactions)
+		admin.GET("/compaction/plan", adminHandler.CompactionPlan)
+		admin.GET("/compaction/history", adminHandler.CompactionHistory)
+		admin.GET("/compaction/status", adminHandler.CompactionStatus)
+		admin.POST("/compaction/pause", adminHandler.PauseCompactions)
+		admin.POST("/compaction/resume", adminHandler.ResumeCompactions)
+		admin.POST("/compact", adminHandler.Compact)
+		admin.POST("/flush", adminHandler.Flush)
+		admin.POST("/checkpoint", adminHandler.Checkpoint)
+		admin.POST("/ingest", adminHandler.Ingest)
+		admin.POST("/backups", adminHandler.Backup)
+		admin.GET("/operations", adminHandler.Operations)
+		admin.GET("/operations/:id", adminHandler.Operation)
+		admin.POST("/operations/:id/cancel", adminHandler.CancelOperation)
+		admin.GET("/cluster", adminHandler.Cluster)
+		admin.POST("/cluster/decommission", adminHandler.Decommission)
+		admin.POST("/cluster/shards/:shard/move", adminHandler.MoveShard)
+		admin.PUT("/cluster/replication-factor", adminHandler.ReplicationFactor)
+	}
+
+	internal := router.Group("/internal", peersOnly, authorize(auth.ROLE_ADMIN))
 	{
-		routes.GET("", handler.Get)
-		routes.POST("", handler.Set)
+		internal.GET("/changes", adminHandler.Changes)
+		internal.POST("/shards/:shard/handoff", adminHandler.HandoffShard)
+		internal.POST("/shards/:shard/release", adminHandler.ReleaseShard)
+		internal.POST("/cluster/placement", adminHandler.Placement)
+	}
+
+	if adminHandler.hints != nil {
+		admin.GET("/hints", adminHandler.Hints)
 	}
+
+	if adminHandler.membership != nil {
+		admin.GET("/members", adminHandler.Members)
+		internal.POST("/gossip", adminHandler.Gossip)
+	}
+
+	// the query parameter routes are deprecated,
+	// they leak values into access logs and can't carry large values.
+	if legacyRoutes {
+		routes := router.Group("/", deprecated)
+		{
+			routes.GET("", reader, bounded, keyRoute, read, handler.Get)
+			routes.POST("", writer, bounded, acked, keyRoute, handler.Set)
+		}
+	}
+}
+
+// deprecated marks the response of deprecated routes.
+func deprecated(ctx *gin.Context) {
+	ctx.Header("Deprecation", "true")
+	ctx.Header("Link", `</v1/keys/{key}>; rel="successor-version"`)
+	ctx.Next()
 }
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"distrikv/config"
+	"distrikv/storage"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanPagesWithNumericComparator(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+
+	store, err := storage.Open(ctx, slog.New(slog.DiscardHandler), t.TempDir(), storage.OPEN_FAST, nil, storage.NumericComparator, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	// key2 sorts between key02 and key10, but after key02 with any suffix
+	keys := []string{"key1", "key02", "key2", "key10"}
+	for _, key := range keys {
+		require.NoError(t, store.Set(key, key))
+	}
+
+	cfg := config.Config{Comparator: storage.NumericComparator.Name(), ScanDefaultLimit: 1, ScanMaxLimit: 1}
+	handler := NewHandler(ctx, store, nil, nil, nil, nil, cfg)
+
+	router := gin.New()
+	router.GET("/v1/scan", handler.Scan)
+
+	var scanned []string
+	var cursor string
+	for range len(keys) + 1 {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/scan?cursor="+url.QueryEscape(cursor), nil))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var res scanResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		for _, item := range res.Items {
+			scanned = append(scanned, item.Key)
+		}
+
+		cursor = res.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+
+	assert.Equal(t, keys, scanned)
+}
+
+func TestScanAndMGetCapped(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+
+	store, err := storage.Open(ctx, slog.New(slog.DiscardHandler), t.TempDir(), storage.OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, store.Set(key, key))
+	}
+
+	cfg := config.Config{ScanDefaultLimit: 2, ScanMaxLimit: 3, MaxBatchSize: 2}
+	handler := NewHandler(ctx, store, nil, nil, nil, nil, cfg)
+
+	router := gin.New()
+	router.GET("/v1/scan", handler.Scan)
+	router.POST("/v1/mget", handler.MGet)
+
+	scan := func(query string) scanResponse {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/scan"+query, nil))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var res scanResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		return res
+	}
+
+	// scans without a limit get the default page, larger limits are capped
+	res := scan("")
+	assert.Len(t, res.Items, 2)
+	assert.NotEmpty(t, res.NextCursor)
+
+	res = scan("?limit=10")
+	assert.Len(t, res.Items, 3)
+
+	res = scan("?limit=10&cursor=" + url.QueryEscape(res.NextCursor))
+	require.Len(t, res.Items, 2)
+	assert.Equal(t, "d", res.Items[0].Key)
+	assert.Empty(t, res.NextCursor)
+
+	mget := func(body string) int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/mget", strings.NewReader(body)))
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, mget(`{"keys":["a","b"]}`))
+	assert.Equal(t, http.StatusBadRequest, mget(`{"keys":["a","b","c"]}`))
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"distrikv/storage"
+	"encoding/base64"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SchemaV1 is the media type of the versioned response schema. Clients
+// sending it in Accept get responses wrapped in an Envelope with typed,
+// snake_case fields; plain JSON responses keep their historical shape.
+// Fields may be added to a version, never removed or changed.
+const SchemaV1 = "application/vnd.distrikv.v1+json"
+
+// Value encodings of Item.
+const (
+	ENCODING_UTF8   = "utf8"
+	ENCODING_BASE64 = "base64"
+)
+
+// Flags of Item.
+const (
+	// FLAG_EXPIRING is set on keys with a TTL.
+	FLAG_EXPIRING = "expiring"
+)
+
+// Envelope wraps every response of the versioned schema.
+type Envelope struct {
+	Version int `json:"version"`
+	Data    any `json:"data"`
+}
+
+// Item is a key of the versioned schema. Values that aren't valid
+// UTF-8 are base64 encoded, as told by Encoding.
+type Item struct {
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	Encoding  string    `json:"encoding"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	Flags     []string  `json:"flags"`
+}
+
+// ItemList is a list of keys of the versioned schema, Items of
+// mget are null for missing keys. NextCursor is empty on the last page.
+type ItemList struct {
+	Items      []*Item `json:"items"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// VersionItem is a version of a key, the value it held from Timestamp
+// until the next version. Deletes are versions with Deleted set.
+type VersionItem struct {
+	Value     string    `json:"value"`
+	Encoding  string    `json:"encoding"`
+	Deleted   bool      `json:"deleted"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// VersionList is the body of GET /v1/keys/:key/versions, newest first.
+type VersionList struct {
+	Key      string        `json:"key"`
+	Versions []VersionItem `json:"versions"`
+}
+
+func newVersionList(key string, versions []storage.Version) VersionList {
+	list := VersionList{
+		Key:      key,
+		Versions: make([]VersionItem, 0, len(versions)),
+	}
+
+	for _, v := range versions {
+		item := VersionItem{
+			Value:     v.Value,
+			Encoding:  ENCODING_UTF8,
+			Deleted:   v.IsDeleted,
+			ExpiresAt: v.ExpiresAt,
+			Timestamp: v.Timestamp,
+		}
+
+		if !utf8.ValidString(v.Value) {
+			item.Value = base64.StdEncoding.EncodeToString([]byte(v.Value))
+			item.Encoding = ENCODING_BASE64
+		}
+
+		list.Versions = append(list.Versions, item)
+	}
+
+	return list
+}
+
+// WriteResult is the versioned response of writes.
+type WriteResult struct {
+	Key string `json:"key"`
+}
+
+// RawValue returns the value of the item, decoding base64 values.
+func (i *Item) RawValue() (string, error) {
+	if i.Encoding != ENCODING_BASE64 {
+		return i.Value, nil
+	}
+
+	value, err := base64.StdEncoding.DecodeString(i.Value)
+	return string(value), err
+}
+
+func newItem(data *storage.KVData) *Item {
+	if data == nil {
+		return nil
+	}
+
+	item := &Item{
+		Key:       data.Key,
+		Value:     data.Value,
+		Encoding:  ENCODING_UTF8,
+		ExpiresAt: data.ExpiresAt,
+		Flags:     []string{},
+	}
+
+	if !utf8.ValidString(data.Value) {
+		item.Value = base64.StdEncoding.EncodeToString([]byte(data.Value))
+		item.Encoding = ENCODING_BASE64
+	}
+
+	if !data.ExpiresAt.IsZero() {
+		item.Flags = append(item.Flags, FLAG_EXPIRING)
+	}
+
+	return item
+}
+
+func newItemList(data []*storage.KVData, nextCursor string) ItemList {
+	list := ItemList{
+		Items:      make([]*Item, 0, len(data)),
+		NextCursor: nextCursor,
+	}
+
+	for _, d := range data {
+		list.Items = append(list.Items, newItem(d))
+	}
+
+	return list
+}
+
+// wantsV1 reports whether the client asked for the versioned schema.
+func wantsV1(ctx *gin.Context) bool {
+	return ctx.NegotiateFormat(gin.MIMEJSON, SchemaV1) == SchemaV1
+}
+
+// respond writes res, or the versioned v1 when the client asked for it.
+func respond(ctx *gin.Context, res any, v1 func() any) {
+	if !wantsV1(ctx) {
+		ctx.JSON(http.StatusOK, res)
+		return
+	}
+
+	ctx.Header("Content-Type", SchemaV1)
+	ctx.JSON(http.StatusOK, Envelope{
+		Version: 1,
+		Data:    v1(),
+	})
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseSchemaV1(t *testing.T) {
+	router, keys := newTestRouter(t)
+
+	request := func(method string, path string, body string, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+keys.admin)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request(http.MethodPut, "/v1/keys/a?ttl=1h", "\xff\x00", SchemaV1)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, SchemaV1, rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"version":1,"data":{"key":"a"}}`, rec.Body.String())
+
+	require.Equal(t, http.StatusOK, request(http.MethodPut, "/v1/keys/b", "text", "").Code)
+
+	// binary values are base64 encoded, expiring keys flagged
+	rec = request(http.MethodGet, "/v1/keys/a", "", SchemaV1)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var res struct {
+		Version int   `json:"version"`
+		Data    *Item `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Equal(t, 1, res.Version)
+	assert.Equal(t, ENCODING_BASE64, res.Data.Encoding)
+	assert.Equal(t, []string{FLAG_EXPIRING}, res.Data.Flags)
+	assert.False(t, res.Data.ExpiresAt.IsZero())
+
+	value, err := res.Data.RawValue()
+	require.NoError(t, err)
+	assert.Equal(t, "\xff\x00", value)
+
+	// mget items follow the keys, null for missing ones
+	rec = request(http.MethodPost, "/v1/mget", `{"keys":["b","c"]}`, SchemaV1)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.JSONEq(t, `{"version":1,"data":{"items":[{"key":"b","value":"text","encoding":"utf8","flags":[]},null]}}`, rec.Body.String())
+
+	// plain JSON keeps its historical shape, with Go field names
+	rec = request(http.MethodGet, "/v1/keys/b", "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json"))
+
+	var plain map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &plain))
+	assert.NotContains(t, plain, "version")
+	assert.Equal(t, "text", plain["Value"])
+}
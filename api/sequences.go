@@ -0,0 +1,49 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sequences allocates ids from named sequences, see sequence.Sequences.
+type Sequences interface {
+	Next(name string, count int) (uint64, error)
+}
+
+// sequenceResponse is the body of POST /v1/sequences/:name/next,
+// the ids allocated are First to Last included.
+type sequenceResponse struct {
+	Name  string `json:"name"`
+	First uint64 `json:"first"`
+	Last  uint64 `json:"last"`
+}
+
+// NextSequence handles POST /v1/sequences/:name/next?batch=, allocating
+// batch consecutive ids of the sequence, 1 by default. Ids are allocated
+// by the node serving the request and never allocated twice by it.
+func (h *Handler) NextSequence(ctx *gin.Context) {
+	batch := 1
+	if raw := ctx.Query("batch"); raw != "" {
+		var err error
+		batch, err = strconv.Atoi(raw)
+		if err != nil {
+			abortWithError(ctx, newValidationError("invalid batch", raw))
+			return
+		}
+	}
+
+	first, err := h.sequences.Next(ctx.Param("name"), batch)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	res := sequenceResponse{
+		Name:  ctx.Param("name"),
+		First: first,
+		Last:  first + uint64(batch) - 1,
+	}
+
+	respond(ctx, res, func() any { return res })
+}
@@ -1,21 +1,145 @@
 package api
 
 import (
-	"os"
+	"context"
+	"crypto/tls"
+	"distrikv/auth"
+	"distrikv/config"
+	"distrikv/events"
+	"distrikv/pkg"
+	"distrikv/ratelimit"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
 
 	"github.com/gin-gonic/gin"
 )
 
-func Start(store Store) {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "6090"
+// Deps are the services the HTTP API is built on.
+type Deps struct {
+	Store       Store
+	Cluster     Cluster
+	Replication Replication
+
+	// Membership is nil without gossip.
+	Membership Membership
+
+	// Hints is nil when writes for unreachable nodes fail.
+	Hints Hints
+
+	Backups Backups
+
+	// Sequences is nil without the sequence routes.
+	Sequences Sequences
+
+	// Events are the internal notifications of the node.
+	Events *events.Bus
+
+	// TLS serves the API over TLS, nil serves plain HTTP.
+	TLS *tls.Config
+
+	// Auth authenticates the requests, nil accepts every request.
+	Auth *auth.Authenticator
+
+	// Limiter limits the requests of every client, nil is unlimited.
+	Limiter *ratelimit.Limiter
+}
+
+// Start serves the HTTP API on every configured client and cluster
+// address until ctx is done. It then stops accepting connections and
+// waits up to cfg.ShutdownDrainTimeout for the requests in flight,
+// those still running after it are aborted.
+func Start(ctx context.Context, deps Deps, cfg config.Config) error {
+	listeners, err := pkg.Listen(slices.Concat(cfg.HTTPAddrs, cfg.ClusterAddrs))
+	if err != nil {
+		return err
+	}
+
+	httpServer := newHTTPServer(NewRouter(ctx, deps, cfg), deps.TLS, cfg)
+
+	// with mTLS the cluster addresses only accept peers
+	peers := make(map[net.Listener]bool)
+	if peerTLS := pkg.GetPeerTLS(); peerTLS != nil {
+		nextProtos := []string{"http/1.1"}
+		if cfg.HTTP2 {
+			nextProtos = []string{"h2", "http/1.1"}
+		}
+
+		for i, l := range listeners[len(cfg.HTTPAddrs):] {
+			listeners[len(cfg.HTTPAddrs)+i] = tls.NewListener(l, peerTLS.ServerConfig(nextProtos))
+			peers[listeners[len(cfg.HTTPAddrs)+i]] = true
+		}
 	}
 
-	handler := NewHandler(store)
-	server := gin.Default()
+	return pkg.ServeUntil(ctx, listeners, cfg.ShutdownDrainTimeout, func(l net.Listener) error {
+		if httpServer.TLSConfig != nil && !peers[l] {
+			return httpServer.ServeTLS(l, "", "")
+		}
+
+		return httpServer.Serve(l)
+	}, func(ctx context.Context) error {
+		if err := httpServer.Shutdown(ctx); err != nil {
+			return errors.Join(err, httpServer.Close())
+		}
+
+		return nil
+	})
+}
+
+// NewRouter returns the routes of the HTTP API, the
+// watches and changelog streams of clients end when ctx is done.
+func NewRouter(ctx context.Context, deps Deps, cfg config.Config) *gin.Engine {
+	handler := NewHandler(ctx, deps.Store, deps.Replication, deps.Cluster.Operations(), deps.Events, deps.Sequences, cfg)
+	router := gin.Default()
 	gin.SetMode(gin.ReleaseMode)
 
-	Routes(server, handler)
-	server.Run(":" + port)
+	// keys may contain url encoded slashes
+	router.UseRawPath = true
+	router.UnescapePathValues = true
+
+	router.Use(traced)
+
+	if deps.Auth != nil {
+		router.Use(authenticated(deps.Auth))
+	}
+
+	if deps.Limiter != nil {
+		router.Use(rateLimited(deps.Limiter))
+	}
+
+	Routes(
+		router,
+		handler,
+		NewAdminHandler(ctx, deps.Cluster, deps.Replication, deps.Membership, deps.Hints, deps.Backups, deps.Events),
+		cfg.LegacyRoutes,
+	)
+
+	return router
+}
+
+// newHTTPServer configures the connection handling of the API,
+// gin's defaults have no timeouts and keep idle connections forever.
+// HTTP/2 is negotiated with TLS when tlsConfig isn't nil.
+func newHTTPServer(handler http.Handler, tlsConfig *tls.Config, cfg config.Config) *http.Server {
+	server := &http.Server{
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		IdleTimeout:       cfg.HTTPIdleTimeout,
+		ReadHeaderTimeout: cfg.HTTPReadHeaderTimeout,
+		Protocols:         new(http.Protocols),
+		HTTP2: &http.HTTP2Config{
+			MaxConcurrentStreams: cfg.HTTP2MaxStreams,
+		},
+	}
+
+	server.Protocols.SetHTTP1(true)
+	if tlsConfig != nil {
+		server.Protocols.SetHTTP2(cfg.HTTP2)
+	} else {
+		server.Protocols.SetUnencryptedHTTP2(cfg.HTTP2)
+	}
+	server.SetKeepAlivesEnabled(cfg.HTTPKeepAlive)
+
+	return server
 }
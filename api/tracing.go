@@ -0,0 +1,48 @@
+package api
+
+import (
+	"distrikv/tracing"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// traced records a server span of every request,
+// continuing the trace of the traceparent header of the caller.
+func traced(ctx *gin.Context) {
+	name := ctx.Request.Method
+	if route := ctx.FullPath(); route != "" {
+		name += " " + route
+	}
+
+	reqCtx, span := tracing.Start(
+		tracing.Extract(ctx.Request.Context(), ctx.Request.Header),
+		name,
+		tracing.SPAN_KIND_SERVER,
+		tracing.Attr{Key: "http.request.method", Value: ctx.Request.Method},
+		tracing.Attr{Key: "http.route", Value: ctx.FullPath()},
+		tracing.Attr{Key: "url.path", Value: ctx.Request.URL.Path},
+	)
+	if span == nil {
+		ctx.Next()
+		return
+	}
+	defer span.End()
+
+	ctx.Request = ctx.Request.WithContext(reqCtx)
+	ctx.Next()
+
+	status := ctx.Writer.Status()
+	span.SetAttr("http.response.status_code", status)
+
+	if status >= http.StatusInternalServerError {
+		msg := http.StatusText(status)
+		if errs := ctx.Errors.Errors(); len(errs) > 0 {
+			msg = strings.Join(errs, "; ")
+		}
+
+		span.SetError(errors.New(msg))
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"distrikv/auth"
+	"distrikv/events"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// watchHeartbeat is how often an idle watch sends a comment,
+// so proxies don't close its connection.
+const watchHeartbeat = 15 * time.Second
+
+// Events of the watch stream.
+const (
+	WATCH_EVENT_WRITE    = "write"
+	WATCH_EVENT_OVERFLOW = "overflow"
+)
+
+// WatchEvent is the data of the write events of GET /v1/watch.
+// Op is "set", "delete" or "delete_range" of the keys in [Key, End).
+// Keys and values that aren't valid UTF-8 are base64 encoded,
+// as told by Encoding.
+type WatchEvent struct {
+	Seq       uint64    `json:"seq"`
+	Op        string    `json:"op"`
+	Key       string    `json:"key"`
+	End       string    `json:"end,omitempty"`
+	Value     string    `json:"value,omitempty"`
+	Encoding  string    `json:"encoding"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+func newWatchEvent(data events.WriteEvent) WatchEvent {
+	event := WatchEvent{
+		Seq:       data.Seq,
+		Op:        data.Op,
+		Key:       data.Key,
+		End:       data.End,
+		Value:     data.Value,
+		Encoding:  ENCODING_UTF8,
+		ExpiresAt: data.ExpiresAt,
+	}
+
+	if !utf8.ValidString(data.Key) || !utf8.ValidString(data.End) || !utf8.ValidString(data.Value) {
+		event.Key = base64.StdEncoding.EncodeToString([]byte(data.Key))
+		event.End = base64.StdEncoding.EncodeToString([]byte(data.End))
+		event.Value = base64.StdEncoding.EncodeToString([]byte(data.Value))
+		event.Encoding = ENCODING_BASE64
+	}
+
+	return event
+}
+
+// watched tells whether the watch of p on prefix sends data.
+// Callers scoped to prefixes only see the writes of their keys.
+func watched(p *auth.Principal, prefix string, data events.WriteEvent) bool {
+	if !data.Matches(prefix) {
+		return false
+	}
+
+	return p == nil || p.CanAccess(data.Key)
+}
+
+// Watch handles GET /v1/watch?prefix=, streaming the writes of the keys
+// starting with prefix as server-sent events, from the time of the
+// request. Only the writes applied on this node are sent, a client
+// watching a cluster watches every node. Every write is a "write" event
+// whose data is a WatchEvent and whose id is its sequence.
+//
+// Writes are buffered per watch, a client falling events.WatchBuffer
+// writes behind gets an "overflow" event and the watch ends, it then
+// reads the keys again and watches anew. Watches end when the server
+// shuts down.
+func (h *Handler) Watch(ctx *gin.Context) {
+	prefix := ctx.Query("prefix")
+	p := principal(ctx)
+
+	sub := h.events.Subscribe(events.WatchBuffer, events.TOPIC_WRITE)
+	defer sub.Close()
+
+	startEvents(ctx)
+	streamWrites(ctx, sub, h.stop, func(data events.WriteEvent) bool {
+		return watched(p, prefix, data)
+	})
+}
+
+// startEvents sends the headers of a stream of server-sent events.
+func startEvents(ctx *gin.Context) {
+	header := ctx.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	ctx.Status(http.StatusOK)
+	ctx.Writer.WriteHeaderNow()
+	ctx.Writer.Flush()
+}
+
+// sendWrite sends data as a write event whose id is its sequence.
+func sendWrite(ctx *gin.Context, data events.WriteEvent) {
+	fmt.Fprintf(ctx.Writer, "id: %d\n", data.Seq)
+	ctx.SSEvent(WATCH_EVENT_WRITE, newWatchEvent(data))
+}
+
+// streamWrites sends the writes of sub passing filter until the client
+// is gone, stop is closed or sub fell behind, which sends an overflow event.
+func streamWrites(ctx *gin.Context, sub *events.Subscription, stop <-chan struct{}, filter func(events.WriteEvent) bool) {
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Request.Context().Done():
+			return
+		case <-stop:
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(ctx.Writer, ": heartbeat\n\n")
+		case event := <-sub.C:
+			if sub.Dropped() > 0 {
+				ctx.SSEvent(WATCH_EVENT_OVERFLOW, ErrorResponse{
+					Code:    CodeWatchOverflow,
+					Message: fmt.Sprintf("fell more than %d writes behind", events.WatchBuffer),
+				})
+				ctx.Writer.Flush()
+				return
+			}
+
+			data := event.Data.(events.WriteEvent)
+			if !filter(data) {
+				continue
+			}
+
+			sendWrite(ctx, data)
+		}
+
+		ctx.Writer.Flush()
+	}
+}
@@ -0,0 +1,372 @@
+// Package auth authenticates the clients of the APIs, with API keys
+// kept in a file of the node or with bearer tokens signed by a secret
+// shared by the deployment.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnauthenticated error = errors.New("missing or invalid credentials")
+	ErrForbidden       error = errors.New("permission denied")
+	ErrInvalidRole     error = errors.New("invalid role")
+	ErrKeyExists       error = errors.New("api key already exists")
+	ErrUnknownKey      error = errors.New("api key does not exist")
+)
+
+// KeyPrefix starts every API key, telling them from tokens.
+const KeyPrefix = "dkv_"
+
+// Kinds of principals.
+const (
+	KIND_KEY   = "key"
+	KIND_TOKEN = "token"
+	KIND_PEER  = "peer"
+)
+
+// Roles of credentials, each role is allowed
+// what the roles before it are allowed.
+const (
+	ROLE_READ  = "read"
+	ROLE_WRITE = "write"
+	ROLE_ADMIN = "admin"
+)
+
+var roleRanks = map[string]int{ROLE_READ: 1, ROLE_WRITE: 2, ROLE_ADMIN: 3}
+
+// ParseRole validates role, empty is admin
+// for credentials created before roles.
+func ParseRole(role string) (string, error) {
+	if role == "" {
+		return ROLE_ADMIN, nil
+	}
+
+	if _, ok := roleRanks[role]; !ok {
+		return "", fmt.Errorf("%w %q, read, write or admin", ErrInvalidRole, role)
+	}
+
+	return role, nil
+}
+
+// keysCheckInterval is how often the keys file is checked
+// for changes, on the next authentication.
+var keysCheckInterval = 5 * time.Second
+
+// Principal is an authenticated caller.
+type Principal struct {
+	// Name is the name of the key or the subject of the token.
+	Name string
+	Kind string
+	Role string
+
+	// Prefixes scope the principal to the keys starting
+	// with one of them, empty allows every key.
+	Prefixes []string
+}
+
+// Peer is the principal of the other nodes of the cluster.
+var Peer = &Principal{Name: "peer", Kind: KIND_PEER, Role: ROLE_ADMIN}
+
+// Can tells whether p has role, or a role allowed more.
+func (p *Principal) Can(role string) bool {
+	return roleRanks[p.Role] >= roleRanks[role]
+}
+
+// CanAccess tells whether key is in the scope of p.
+func (p *Principal) CanAccess(key string) bool {
+	if len(p.Prefixes) == 0 {
+		return true
+	}
+
+	return slices.ContainsFunc(p.Prefixes, func(prefix string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// Authorize returns ErrForbidden unless p has role and may access keys.
+// A nil p, without authentication, is allowed everything.
+func (p *Principal) Authorize(role string, keys ...string) error {
+	if p == nil {
+		return nil
+	}
+
+	if !p.Can(role) {
+		return fmt.Errorf("%w: %s %s has role %s, %s is required", ErrForbidden, p.Kind, p.Name, p.Role, role)
+	}
+
+	for _, key := range keys {
+		if !p.CanAccess(key) {
+			return fmt.Errorf("%w: key %q is outside the prefixes of %s %s", ErrForbidden, key, p.Kind, p.Name)
+		}
+	}
+
+	return nil
+}
+
+// Key is an API key of the keys file, only its hash is kept.
+type Key struct {
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Role      string    `json:"role,omitempty"`
+	Prefixes  []string  `json:"prefixes,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Authenticator checks the credentials of requests. The keys file
+// is read again once it changed, so keys added or removed with
+// distrikv auth apply without a restart.
+type Authenticator struct {
+	logger      *slog.Logger
+	keysFile    string
+	tokenSecret []byte
+
+	mu      sync.Mutex
+	keys    []Key
+	modTime time.Time
+	checked time.Time
+}
+
+// New returns an Authenticator of the keys of keysFile, which may not
+// exist yet, and of the tokens signed with tokenSecret, nil rejects
+// every token.
+func New(logger *slog.Logger, keysFile string, tokenSecret []byte) (*Authenticator, error) {
+	a := &Authenticator{
+		logger:      logger,
+		keysFile:    keysFile,
+		tokenSecret: tokenSecret,
+	}
+
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// reload reads the keys file when it changed. Must be called with mu held,
+// or before a is shared.
+func (a *Authenticator) reload() error {
+	a.checked = time.Now()
+
+	info, err := os.Stat(a.keysFile)
+	if errors.Is(err, os.ErrNotExist) {
+		a.keys, a.modTime = nil, time.Time{}
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if info.ModTime().Equal(a.modTime) {
+		return nil
+	}
+
+	keys, err := ReadKeys(a.keysFile)
+	if err != nil {
+		return err
+	}
+
+	a.keys, a.modTime = keys, info.ModTime()
+	return nil
+}
+
+// Authenticate returns the principal of credential, an API key or a token.
+func (a *Authenticator) Authenticate(credential string) (*Principal, error) {
+	if strings.HasPrefix(credential, KeyPrefix) {
+		return a.authenticateKey(credential)
+	}
+
+	if a.tokenSecret == nil {
+		return nil, ErrUnauthenticated
+	}
+
+	claims, err := VerifyToken(a.tokenSecret, credential, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := ParseRole(claims.Role)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnauthenticated, err)
+	}
+
+	return &Principal{Name: claims.Subject, Kind: KIND_TOKEN, Role: role, Prefixes: claims.Prefixes}, nil
+}
+
+func (a *Authenticator) authenticateKey(key string) (*Principal, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if time.Since(a.checked) >= keysCheckInterval {
+		if err := a.reload(); err != nil {
+			a.logger.Error("error reloading api keys, keeping the previous ones", "file", a.keysFile, "err", err)
+		}
+	}
+
+	hash := hashKey(key)
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hash)) == 1 {
+			role, err := ParseRole(k.Role)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrUnauthenticated, err)
+			}
+
+			return &Principal{Name: k.Name, Kind: KIND_KEY, Role: role, Prefixes: k.Prefixes}, nil
+		}
+	}
+
+	return nil, ErrUnauthenticated
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ReadKeys returns the keys of the keys file.
+func ReadKeys(path string) ([]Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	return keys, nil
+}
+
+// writeKeys replaces the keys file, readable by its owner only.
+func writeKeys(path string, keys []Key) error {
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// AddKey adds a new key named name with role, scoped to prefixes
+// when there are some, to the keys file and returns it, it can't be
+// read back later.
+func AddKey(path string, name string, role string, prefixes []string) (string, error) {
+	if _, err := ParseRole(role); err != nil {
+		return "", err
+	}
+
+	keys, err := ReadKeys(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	if slices.ContainsFunc(keys, func(k Key) bool { return k.Name == name }) {
+		return "", fmt.Errorf("%w: %s", ErrKeyExists, name)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	key := KeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	keys = append(keys, Key{
+		Name:      name,
+		Hash:      hashKey(key),
+		Role:      role,
+		Prefixes:  prefixes,
+		CreatedAt: time.Now().UTC(),
+	})
+
+	return key, writeKeys(path, keys)
+}
+
+// RemoveKey removes the key named name from the keys file.
+func RemoveKey(path string, name string) error {
+	keys, err := ReadKeys(path)
+	if err != nil {
+		return err
+	}
+
+	i := slices.IndexFunc(keys, func(k Key) bool { return k.Name == name })
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrUnknownKey, name)
+	}
+
+	return writeKeys(path, slices.Delete(keys, i, i+1))
+}
+
+// Bootstrap creates the keys file with a first key named admin when it
+// doesn't exist, and writes the key to secretFile, readable by its owner
+// only. It returns false when the keys file existed.
+func Bootstrap(path string, secretFile string) (bool, error) {
+	if _, err := os.Stat(path); err == nil || !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	key, err := AddKey(path, "admin", ROLE_ADMIN, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(secretFile), 0755); err != nil {
+		return false, err
+	}
+
+	if err := os.WriteFile(secretFile, []byte(key+"\n"), 0600); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// BearerToken returns the credential of an Authorization header,
+// empty when it isn't a bearer credential.
+func BearerToken(header string) string {
+	scheme, credential, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(credential)
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying p.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of ctx, nil without authentication.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticator(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+	keysFile := filepath.Join(dir, "api-keys.json")
+	secretFile := filepath.Join(dir, "admin-key")
+
+	created, err := Bootstrap(keysFile, secretFile)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	info, err := os.Stat(secretFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	admin, err := os.ReadFile(secretFile)
+	require.NoError(t, err)
+
+	// an existing keys file is left alone
+	created, err = Bootstrap(keysFile, secretFile)
+	require.NoError(t, err)
+	assert.False(t, created)
+
+	secret := []byte("secret")
+	a, err := New(logger, keysFile, secret)
+	require.NoError(t, err)
+
+	p, err := a.Authenticate(string(admin[:len(admin)-1]))
+	require.NoError(t, err)
+	assert.Equal(t, Principal{Name: "admin", Kind: KIND_KEY, Role: ROLE_ADMIN}, *p)
+
+	_, err = a.Authenticate(KeyPrefix + "unknown")
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	// keys added later are picked up
+	defer func(interval time.Duration) { keysCheckInterval = interval }(keysCheckInterval)
+	keysCheckInterval = 0
+
+	key, err := AddKey(keysFile, "app", ROLE_WRITE, []string{"app/"})
+	require.NoError(t, err)
+
+	_, err = AddKey(keysFile, "app", ROLE_WRITE, nil)
+	assert.ErrorIs(t, err, ErrKeyExists)
+
+	_, err = AddKey(keysFile, "other", "owner", nil)
+	assert.ErrorIs(t, err, ErrInvalidRole)
+
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(keysFile, future, future))
+
+	p, err = a.Authenticate(key)
+	require.NoError(t, err)
+	assert.Equal(t, "app", p.Name)
+
+	assert.NoError(t, p.Authorize(ROLE_WRITE, "app/1", "app/2"))
+	assert.ErrorIs(t, p.Authorize(ROLE_READ, "app/1", "other/1"), ErrForbidden)
+	assert.ErrorIs(t, p.Authorize(ROLE_ADMIN), ErrForbidden)
+
+	require.NoError(t, RemoveKey(keysFile, "app"))
+	require.NoError(t, os.Chtimes(keysFile, future.Add(time.Minute), future.Add(time.Minute)))
+
+	_, err = a.Authenticate(key)
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	assert.ErrorIs(t, RemoveKey(keysFile, "app"), ErrUnknownKey)
+
+	// tokens
+	token, err := NewToken(secret, "ci", ROLE_READ, []string{"builds/"}, time.Hour)
+	require.NoError(t, err)
+
+	p, err = a.Authenticate(token)
+	require.NoError(t, err)
+	assert.Equal(t, Principal{Name: "ci", Kind: KIND_TOKEN, Role: ROLE_READ, Prefixes: []string{"builds/"}}, *p)
+	assert.ErrorIs(t, p.Authorize(ROLE_WRITE, "builds/1"), ErrForbidden)
+
+	forged, err := NewToken([]byte("other"), "ci", ROLE_ADMIN, nil, time.Hour)
+	require.NoError(t, err)
+
+	_, err = a.Authenticate(forged)
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	_, err = VerifyToken(secret, token, time.Now().Add(2*time.Hour))
+	assert.ErrorContains(t, err, "expired")
+
+	// without a secret, tokens are refused
+	a, err = New(logger, keysFile, nil)
+	require.NoError(t, err)
+
+	_, err = a.Authenticate(token)
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+}
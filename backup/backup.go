@@ -0,0 +1,405 @@
+// Package backup copies checkpoints of a node into backups, kept in a
+// local directory or an S3 location with the checksum of every file,
+// and restores them into new data directories.
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"distrikv/cluster"
+	"distrikv/ops"
+	"distrikv/replication"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotBackup       error = errors.New("not a backup")
+	ErrBackupExists    error = errors.New("backup already exists")
+	ErrChecksum        error = errors.New("backup file checksum mismatch")
+	ErrDataDirNotEmpty error = errors.New("data directory is not empty")
+)
+
+// MetadataFile describes a backup, it's written last.
+const MetadataFile = "BACKUP"
+
+// checkpointPrefix names the checkpoints taken
+// into the data directory for a backup.
+const checkpointPrefix = "backup-checkpoint-"
+
+// Metadata describes a backup. Seq is the sequence
+// of the last change of the primary it contains.
+type Metadata struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Seq       uint64    `json:"seq"`
+	Shards    []int     `json:"shards"`
+	Files     []File    `json:"files"`
+}
+
+// File is a file of a backup, Path is relative
+// to the backup and separated by slashes.
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Size returns the size of the files of the backup.
+func (m *Metadata) Size() int64 {
+	var size int64
+	for _, file := range m.Files {
+		size += file.Size
+	}
+
+	return size
+}
+
+// Checkpointer checkpoints the local shards, see replication.Replicator.
+type Checkpointer interface {
+	Checkpoint(ctx context.Context, dir string) (*replication.Checkpoint, error)
+}
+
+// Backups creates the backups of a node as operations.
+type Backups struct {
+	logger       *slog.Logger
+	dataDir      string
+	checkpointer Checkpointer
+	operations   *ops.Registry
+
+	// mu serializes backups, they checkpoint into the data directory.
+	mu sync.Mutex
+}
+
+// New removes the checkpoints left in dataDir by interrupted backups.
+func New(logger *slog.Logger, dataDir string, checkpointer Checkpointer, operations *ops.Registry) (*Backups, error) {
+	leftovers, err := filepath.Glob(filepath.Join(dataDir, checkpointPrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dir := range leftovers {
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Backups{
+		logger:       logger,
+		dataDir:      dataDir,
+		checkpointer: checkpointer,
+		operations:   operations,
+	}, nil
+}
+
+// Start starts a backup of the local shards into location, see
+// OpenTarget. Its progress counts the bytes copied.
+func (b *Backups) Start(location string) ops.Operation {
+	return b.operations.Start("backup", "backup into "+location, func(ctx context.Context, p *ops.Progress) error {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		target, err := OpenTarget(location)
+		if err != nil {
+			return err
+		}
+
+		metadata, err := b.create(ctx, target, p)
+		if err != nil {
+			return err
+		}
+
+		b.logger.Info("backup created", "target", target, "id", metadata.ID, "seq", metadata.Seq, "files", len(metadata.Files), "size", metadata.Size())
+		return nil
+	})
+}
+
+func (b *Backups) create(ctx context.Context, target Target, p *ops.Progress) (*Metadata, error) {
+	src := filepath.Join(b.dataDir, checkpointPrefix+uuid.NewString())
+	checkpoint, err := b.checkpointer.Checkpoint(ctx, src)
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint: %w", err)
+	}
+	defer os.RemoveAll(src)
+
+	metadata := &Metadata{
+		ID:        checkpoint.CreatedAt.UTC().Format("20060102T150405Z"),
+		CreatedAt: checkpoint.CreatedAt,
+		Seq:       checkpoint.Seq,
+	}
+
+	for shard := range checkpoint.Shards {
+		metadata.Shards = append(metadata.Shards, shard)
+	}
+	sort.Ints(metadata.Shards)
+
+	if _, err := ReadMetadata(ctx, target, metadata.ID); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrBackupExists, metadata.ID)
+	}
+
+	files, total, err := listFiles(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// a failed backup has no metadata, its files are removed
+	err = func() error {
+		var done int64
+		for _, path := range files {
+			file, err := putFile(ctx, target, metadata.ID, src, path)
+			if err != nil {
+				return fmt.Errorf("copy %s: %w", path, err)
+			}
+
+			metadata.Files = append(metadata.Files, file)
+
+			done += file.Size
+			p.Set(done, total)
+		}
+
+		data, err := json.MarshalIndent(metadata, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		_, err = target.Put(ctx, metadata.ID+"/"+MetadataFile, bytes.NewReader(data))
+		return err
+	}()
+	if err != nil {
+		if removeErr := target.Remove(context.WithoutCancel(ctx), metadata.ID); removeErr != nil {
+			b.logger.Error("error removing failed backup", "target", target, "id", metadata.ID, "err", removeErr)
+		}
+
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+func putFile(ctx context.Context, target Target, id string, dir string, path string) (File, error) {
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(path)))
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+
+	file, err := target.Put(ctx, id+"/"+path, f)
+	if err != nil {
+		return File{}, err
+	}
+
+	file.Path = path
+	return file, nil
+}
+
+// List returns the backups of target, oldest first.
+func List(ctx context.Context, target Target) ([]*Metadata, error) {
+	ids, err := target.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*Metadata
+	for _, id := range ids {
+		// incomplete backups have no metadata
+		metadata, err := ReadMetadata(ctx, target, id)
+		if errors.Is(err, ErrNotBackup) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, metadata)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreatedAt.Before(res[j].CreatedAt)
+	})
+
+	return res, nil
+}
+
+// ReadMetadata reads the metadata of the backup id of target.
+func ReadMetadata(ctx context.Context, target Target, id string) (*Metadata, error) {
+	r, err := target.Open(ctx, id+"/"+MetadataFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s in %s", ErrNotBackup, id, target)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var metadata Metadata
+	if err := json.NewDecoder(r).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", MetadataFile, err)
+	}
+
+	return &metadata, nil
+}
+
+// Restore copies the backup id of target into dataDir, which must not
+// exist or be empty, verifying the checksum of every file. The restored
+// data gets a new generation, its history differs from the node's.
+func Restore(ctx context.Context, target Target, id string, dataDir string) (*Metadata, error) {
+	metadata, err := ReadMetadata(ctx, target, id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range metadata.Files {
+		if !filepath.IsLocal(filepath.FromSlash(file.Path)) {
+			return nil, fmt.Errorf("%w: file %s is outside of the backup", ErrNotBackup, file.Path)
+		}
+	}
+
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrDataDirNotEmpty, dataDir)
+	}
+
+	for _, file := range metadata.Files {
+		if err := restoreFile(ctx, target, id, file, dataDir); err != nil {
+			removeContents(dataDir)
+			return nil, fmt.Errorf("restore %s: %w", file.Path, err)
+		}
+	}
+
+	if _, err := cluster.NewGeneration(dataDir); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+func restoreFile(ctx context.Context, target Target, id string, file File, dataDir string) error {
+	r, err := target.Open(ctx, id+"/"+file.Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	dst := filepath.Join(dataDir, filepath.FromSlash(file.Path))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, h), r)
+	if err != nil {
+		return err
+	}
+
+	if size != file.Size || hex.EncodeToString(h.Sum(nil)) != file.SHA256 {
+		return ErrChecksum
+	}
+
+	if err := out.Sync(); err != nil {
+		return err
+	}
+
+	return out.Close()
+}
+
+// Verify checks the files of the backup id of target against its metadata.
+func Verify(ctx context.Context, target Target, id string) (*Metadata, error) {
+	metadata, err := ReadMetadata(ctx, target, id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range metadata.Files {
+		if err := verifyFile(ctx, target, id, file); err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Path, err)
+		}
+	}
+
+	return metadata, nil
+}
+
+func verifyFile(ctx context.Context, target Target, id string, file File) error {
+	r, err := target.Open(ctx, id+"/"+file.Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+
+	if size != file.Size || hex.EncodeToString(h.Sum(nil)) != file.SHA256 {
+		return ErrChecksum
+	}
+
+	return nil
+}
+
+// listFiles returns the slash separated paths of the files in dir
+// relative to it, sorted, and their total size.
+func listFiles(dir string) ([]string, int64, error) {
+	var files []string
+	var total int64
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, filepath.ToSlash(rel))
+		total += info.Size()
+		return nil
+	})
+
+	return files, total, err
+}
+
+// removeContents removes the contents of dir, keeping dir.
+func removeContents(dir string) {
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		os.RemoveAll(filepath.Join(dir, entry.Name()))
+	}
+}
@@ -0,0 +1,88 @@
+package backup
+
+import (
+	"context"
+	"distrikv/ops"
+	"distrikv/replication"
+	"distrikv/storage"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCheckpointer struct{}
+
+func (fakeCheckpointer) Checkpoint(ctx context.Context, dir string) (*replication.Checkpoint, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "shard-001"), 0755); err != nil {
+		return nil, err
+	}
+
+	for path, data := range map[string]string{"CHECKPOINT": "{}", "shard-001/0_1_a.sst": "entries"} {
+		if err := os.WriteFile(filepath.Join(dir, path), []byte(data), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	return &replication.Checkpoint{
+		Seq:       5,
+		CreatedAt: time.Now(),
+		Shards:    map[int]*storage.Checkpoint{1: {}},
+	}, nil
+}
+
+func TestBackupRestore(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dataDir, root := t.TempDir(), t.TempDir()
+
+	registry := ops.NewRegistry(logger)
+	defer registry.Close()
+
+	backups, err := New(logger, dataDir, fakeCheckpointer{}, registry)
+	require.NoError(t, err)
+
+	op := backups.Start(root)
+	require.Eventually(t, func() bool {
+		op, err = registry.Get(op.ID)
+		return err == nil && op.State != ops.OP_RUNNING.String()
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, ops.OP_DONE.String(), op.State, op.Error)
+
+	// the checkpoint is removed once copied
+	leftovers, err := filepath.Glob(filepath.Join(dataDir, checkpointPrefix+"*"))
+	require.NoError(t, err)
+	assert.Empty(t, leftovers)
+
+	ctx := context.Background()
+	target := localTarget(root)
+	list, err := List(ctx, target)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, uint64(5), list[0].Seq)
+	assert.Equal(t, []int{1}, list[0].Shards)
+	assert.Len(t, list[0].Files, 2)
+
+	id := list[0].ID
+	restored := filepath.Join(t.TempDir(), "data")
+	_, err = Restore(ctx, target, id, restored)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(filepath.Join(restored, "shard-001", "0_1_a.sst"))
+	require.NoError(t, err)
+	assert.Equal(t, "entries", string(data))
+
+	_, err = Restore(ctx, target, id, restored)
+	assert.ErrorIs(t, err, ErrDataDirNotEmpty)
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, id, "shard-001", "0_1_a.sst"), []byte("corrupt"), 0644))
+	_, err = Verify(ctx, target, id)
+	assert.ErrorIs(t, err, ErrChecksum)
+
+	_, err = Restore(ctx, target, id, t.TempDir())
+	assert.ErrorIs(t, err, ErrChecksum)
+}
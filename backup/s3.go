@@ -0,0 +1,563 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Uploads larger than S3PartSize are split into parts of that size,
+// S3 requires parts of at least 5MiB but the last one.
+const S3PartSize = 16 << 20
+
+// S3 requests failing with a network error, a server error or a
+// throttling response are retried up to s3Attempts times.
+const (
+	s3Attempts     = 5
+	s3RetryBackoff = 200 * time.Millisecond
+)
+
+// S3Options configure the S3 client of backups.
+type S3Options struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Region          string
+
+	// Endpoint replaces the AWS endpoint of the region, for S3 compatible
+	// stores. Buckets are then addressed in the path of requests.
+	Endpoint string
+
+	// PartSize is the size of the parts of uploads, S3PartSize when 0.
+	PartSize int
+}
+
+// S3OptionsFromEnv reads the options from the standard AWS variables
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN and
+// AWS_REGION, and the endpoint from S3_ENDPOINT.
+func S3OptionsFromEnv() S3Options {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return S3Options{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Region:          region,
+		Endpoint:        os.Getenv("S3_ENDPOINT"),
+	}
+}
+
+// s3Target keeps backups under a prefix of an S3 bucket. Every request
+// is signed with AWS signature version 4 and sends the SHA-256 checksum
+// of its body, which S3 validates before storing it.
+type s3Target struct {
+	opts     S3Options
+	client   *http.Client
+	location string
+	backoff  time.Duration
+
+	bucket string
+	prefix string
+
+	// endpoint is the base URL of the bucket,
+	// path holds the bucket for path-style addressing.
+	endpoint *url.URL
+}
+
+func newS3Target(location string, opts S3Options) (*s3Target, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid backup location %s, expected s3://bucket/prefix", location)
+	}
+
+	if opts.AccessKeyID == "" || opts.SecretAccessKey == "" {
+		return nil, errors.New("S3 backups require AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+
+	if opts.PartSize == 0 {
+		opts.PartSize = S3PartSize
+	}
+
+	t := &s3Target{
+		opts:     opts,
+		client:   &http.Client{Timeout: 5 * time.Minute},
+		location: location,
+		backoff:  s3RetryBackoff,
+		bucket:   u.Host,
+		prefix:   strings.Trim(u.Path, "/"),
+	}
+
+	if opts.Endpoint != "" {
+		t.endpoint, err = url.Parse(strings.TrimRight(opts.Endpoint, "/") + "/" + t.bucket)
+	} else {
+		t.endpoint, err = url.Parse(fmt.Sprintf("https://%s.s3.%s.amazonaws.com", t.bucket, opts.Region))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *s3Target) String() string {
+	return t.location
+}
+
+func (t *s3Target) key(path string) string {
+	if t.prefix == "" {
+		return path
+	}
+
+	return t.prefix + "/" + path
+}
+
+func (t *s3Target) Put(ctx context.Context, path string, r io.Reader) (File, error) {
+	key := t.key(path)
+	h := sha256.New()
+	r = io.TeeReader(r, h)
+
+	buf := make([]byte, t.opts.PartSize)
+	n, err := io.ReadFull(r, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		if err := t.putObject(ctx, key, buf[:n]); err != nil {
+			return File{}, err
+		}
+
+		return File{Size: int64(n), SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+	}
+
+	if err != nil {
+		return File{}, err
+	}
+
+	size, err := t.putMultipart(ctx, key, buf, r)
+	if err != nil {
+		return File{}, err
+	}
+
+	return File{Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func (t *s3Target) putObject(ctx context.Context, key string, data []byte) error {
+	res, err := t.do(ctx, http.MethodPut, key, nil, data, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber     int
+	ETag           string
+	ChecksumSHA256 string
+}
+
+// putMultipart uploads first, a full part, followed by the rest of r.
+// An upload that failed is aborted, so its parts aren't kept.
+func (t *s3Target) putMultipart(ctx context.Context, key string, first []byte, r io.Reader) (int64, error) {
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+
+	query := url.Values{"uploads": {""}}
+	if err := t.doXML(ctx, http.MethodPost, key, query, nil, &initiated); err != nil {
+		return 0, fmt.Errorf("create multipart upload: %w", err)
+	}
+
+	complete := completeMultipartUpload{}
+	size, err := func() (int64, error) {
+		var size int64
+		part := first
+		for number := 1; len(part) > 0; number++ {
+			query := url.Values{
+				"partNumber": {strconv.Itoa(number)},
+				"uploadId":   {initiated.UploadID},
+			}
+
+			res, err := t.do(ctx, http.MethodPut, key, query, part, http.StatusOK)
+			if err != nil {
+				return 0, fmt.Errorf("upload part %d: %w", number, err)
+			}
+			res.Body.Close()
+
+			complete.Parts = append(complete.Parts, completedPart{
+				PartNumber:     number,
+				ETag:           res.Header.Get("ETag"),
+				ChecksumSHA256: checksumHeader(part),
+			})
+			size += int64(len(part))
+
+			n, err := io.ReadFull(r, first)
+			if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return 0, err
+			}
+			part = first[:n]
+		}
+
+		body, err := xml.Marshal(complete)
+		if err != nil {
+			return 0, err
+		}
+
+		query := url.Values{"uploadId": {initiated.UploadID}}
+		if err := t.doXML(ctx, http.MethodPost, key, query, body, nil); err != nil {
+			return 0, fmt.Errorf("complete multipart upload: %w", err)
+		}
+
+		return size, nil
+	}()
+	if err != nil {
+		query := url.Values{"uploadId": {initiated.UploadID}}
+		if res, abortErr := t.do(context.WithoutCancel(ctx), http.MethodDelete, key, query, nil, http.StatusNoContent); abortErr == nil {
+			res.Body.Close()
+		}
+
+		return 0, err
+	}
+
+	return size, nil
+}
+
+func (t *s3Target) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	res, err := t.do(ctx, http.MethodGet, t.key(path), nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// list calls fn for every page of the keys under prefix,
+// grouped by the next slash when delimit is set.
+func (t *s3Target) list(ctx context.Context, prefix string, delimit bool, fn func(page *listBucketResult)) error {
+	token := ""
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {prefix},
+		}
+
+		if delimit {
+			query.Set("delimiter", "/")
+		}
+
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		var page listBucketResult
+		if err := t.doXML(ctx, http.MethodGet, "", query, nil, &page); err != nil {
+			return err
+		}
+
+		fn(&page)
+
+		if !page.IsTruncated {
+			return nil
+		}
+
+		token = page.NextContinuationToken
+	}
+}
+
+func (t *s3Target) List(ctx context.Context) ([]string, error) {
+	prefix := t.key("")
+
+	var ids []string
+	err := t.list(ctx, prefix, true, func(page *listBucketResult) {
+		for _, p := range page.CommonPrefixes {
+			ids = append(ids, strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/"))
+		}
+	})
+
+	return ids, err
+}
+
+func (t *s3Target) Remove(ctx context.Context, id string) error {
+	var keys []string
+	err := t.list(ctx, t.key(id)+"/", false, func(page *listBucketResult) {
+		for _, c := range page.Contents {
+			keys = append(keys, c.Key)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		res, err := t.do(ctx, http.MethodDelete, key, nil, nil, http.StatusNoContent)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+	}
+
+	return nil
+}
+
+// s3Error is the error body of S3 responses.
+type s3Error struct {
+	Status  int    `xml:"-"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("s3: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// retryable reports whether the request may succeed when sent again.
+// A bad digest means the body was corrupted on its way to S3.
+func (e *s3Error) retryable() bool {
+	switch e.Code {
+	case "SlowDown", "RequestTimeout", "InternalError", "BadDigest", "XAmzContentChecksumMismatch":
+		return true
+	}
+
+	return e.Status >= 500 || e.Status == http.StatusTooManyRequests
+}
+
+func (e *s3Error) Unwrap() error {
+	if e.Status == http.StatusNotFound && e.Code != "NoSuchBucket" {
+		return fs.ErrNotExist
+	}
+
+	return nil
+}
+
+// doXML sends a request and decodes its XML response into res, if not nil.
+// Some S3 errors come with a 200 status, they're detected in the body.
+func (t *s3Target) doXML(ctx context.Context, method string, key string, query url.Values, body []byte, res any) error {
+	resp, err := t.do(ctx, method, key, query, body, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Contains(data, []byte("<Error>")) {
+		s3Err := &s3Error{Status: resp.StatusCode}
+		if err := xml.Unmarshal(data, s3Err); err != nil {
+			return err
+		}
+
+		return s3Err
+	}
+
+	if res == nil {
+		return nil
+	}
+
+	return xml.Unmarshal(data, res)
+}
+
+// do sends a signed request, retrying transient failures, and returns
+// the response when its status is expected. The caller closes its body.
+func (t *s3Target) do(ctx context.Context, method string, key string, query url.Values, body []byte, expected int) (*http.Response, error) {
+	var err error
+	for attempt := range s3Attempts {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(t.backoff << (attempt - 1)):
+			}
+		}
+
+		var res *http.Response
+		res, err = t.send(ctx, method, key, query, body)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			continue
+		}
+
+		if res.StatusCode == expected || (expected == http.StatusOK && res.StatusCode == http.StatusNoContent) {
+			return res, nil
+		}
+
+		s3Err := &s3Error{Status: res.StatusCode}
+		data, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		xml.Unmarshal(data, s3Err)
+
+		err = s3Err
+		if !s3Err.retryable() {
+			return nil, err
+		}
+	}
+
+	return nil, err
+}
+
+func (t *s3Target) send(ctx context.Context, method string, key string, query url.Values, body []byte) (*http.Response, error) {
+	u := *t.endpoint
+	u.Path = strings.TrimRight(u.Path, "/") + "/" + key
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("x-amz-checksum-sha256", checksumHeader(body))
+	}
+
+	// a multipart upload is told the algorithm of its part checksums
+	if _, ok := query["uploads"]; ok {
+		req.Header.Set("x-amz-checksum-algorithm", "SHA256")
+	}
+
+	t.sign(req, u.RawPath, time.Now().UTC())
+
+	return t.client.Do(req)
+}
+
+// sign adds the AWS signature version 4 of req. The payload isn't
+// signed, its integrity is protected by the checksum header.
+func (t *s3Target) sign(req *http.Request, path string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+	if t.opts.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", t.opts.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	scope := date + "/" + t.opts.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+t.opts.SecretAccessKey), date)
+	key = hmacSHA256(key, t.opts.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.opts.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// checksumHeader returns the base64 SHA-256 of data, as sent in S3 headers.
+func checksumHeader(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// canonicalQuery encodes query sorted by name, as signed.
+func canonicalQuery(query url.Values) string {
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	sort.Strings(params)
+
+	return strings.Join(params, "&")
+}
+
+// s3Escape percent-encodes s as signed by S3,
+// keeping the unreserved characters of RFC 3986.
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,274 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"distrikv/ops"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is an S3 server keeping the objects of a bucket in memory, it
+// rejects bodies not matching their checksum like S3 does.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	parts   map[string]map[int][]byte
+
+	// failures is the number of next requests failing with a 500
+	failures int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		objects: map[string][]byte{},
+		parts:   map[string]map[int][]byte{},
+	}
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		s3Fail(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	if s.failures > 0 {
+		s.failures--
+		s3Fail(w, http.StatusInternalServerError, "InternalError")
+		return
+	}
+
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+
+	if len(body) > 0 && r.Header.Get("x-amz-checksum-sha256") != checksumHeader(body) {
+		s3Fail(w, http.StatusBadRequest, "BadDigest")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && query.Get("list-type") == "2":
+		s.list(w, query.Get("prefix"), query.Get("delimiter"))
+	case r.Method == http.MethodGet:
+		data, ok := s.objects[key]
+		if !ok {
+			s3Fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Write(data)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := strconv.Itoa(len(s.parts) + 1)
+		s.parts[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		s.parts[query.Get("uploadId")][number] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, number))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		var complete completeMultipartUpload
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			s3Fail(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+
+		var data []byte
+		for _, part := range complete.Parts {
+			stored := s.parts[query.Get("uploadId")][part.PartNumber]
+			if part.ChecksumSHA256 != checksumHeader(stored) {
+				s3Fail(w, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			data = append(data, stored...)
+		}
+
+		s.objects[key] = data
+		delete(s.parts, query.Get("uploadId"))
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(s.parts, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		s.objects[key] = body
+	default:
+		s3Fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (s *fakeS3) list(w http.ResponseWriter, prefix string, delimiter string) {
+	var res listBucketResult
+	seen := map[string]bool{}
+	for key := range s.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		rest := strings.TrimPrefix(key, prefix)
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			common := prefix + rest[:i+1]
+			if !seen[common] {
+				seen[common] = true
+				res.CommonPrefixes = append(res.CommonPrefixes, struct{ Prefix string }{common})
+			}
+			continue
+		}
+
+		res.Contents = append(res.Contents, struct{ Key string }{key})
+	}
+
+	sort.Slice(res.CommonPrefixes, func(i, j int) bool {
+		return res.CommonPrefixes[i].Prefix < res.CommonPrefixes[j].Prefix
+	})
+
+	data, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		listBucketResult
+	}{listBucketResult: res})
+	w.Write(data)
+}
+
+func s3Fail(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>fake</Message></Error>", code)
+}
+
+func testS3Options(endpoint string) S3Options {
+	return S3Options{
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		Region:          "us-east-1",
+		Endpoint:        endpoint,
+	}
+}
+
+func TestS3BackupRestore(t *testing.T) {
+	s3 := newFakeS3()
+	srv := httptest.NewServer(s3)
+	defer srv.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("S3_ENDPOINT", srv.URL)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	registry := ops.NewRegistry(logger)
+	defer registry.Close()
+
+	backups, err := New(logger, t.TempDir(), fakeCheckpointer{}, registry)
+	require.NoError(t, err)
+
+	op := backups.Start("s3://bucket/backups")
+	require.Eventually(t, func() bool {
+		op, err = registry.Get(op.ID)
+		return err == nil && op.State != ops.OP_RUNNING.String()
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, ops.OP_DONE.String(), op.State, op.Error)
+
+	ctx := context.Background()
+	target, err := OpenTarget("s3://bucket/backups")
+	require.NoError(t, err)
+
+	list, err := List(ctx, target)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, uint64(5), list[0].Seq)
+
+	location, id := SplitRef("s3://bucket/backups/" + list[0].ID)
+	assert.Equal(t, "s3://bucket/backups", location)
+
+	_, err = Verify(ctx, target, id)
+	require.NoError(t, err)
+
+	restored := t.TempDir()
+	_, err = Restore(ctx, target, id, restored)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(filepath.Join(restored, "shard-001", "0_1_a.sst"))
+	require.NoError(t, err)
+	assert.Equal(t, "entries", string(data))
+
+	_, err = ReadMetadata(ctx, target, "missing")
+	assert.ErrorIs(t, err, ErrNotBackup)
+
+	require.NoError(t, target.Remove(ctx, id))
+	list, err = List(ctx, target)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestS3MultipartUpload(t *testing.T) {
+	s3 := newFakeS3()
+	srv := httptest.NewServer(s3)
+	defer srv.Close()
+
+	opts := testS3Options(srv.URL)
+	opts.PartSize = 4
+	target, err := newS3Target("s3://bucket/backups", opts)
+	require.NoError(t, err)
+	target.backoff = time.Millisecond
+
+	// failed requests are retried
+	s3.failures = 2
+
+	ctx := context.Background()
+	data := []byte("0123456789")
+	file, err := target.Put(ctx, "id/file", bytes.NewReader(data))
+	require.NoError(t, err)
+
+	sum := sha256.Sum256(data)
+	assert.Equal(t, File{Size: 10, SHA256: hex.EncodeToString(sum[:])}, file)
+	assert.Equal(t, data, s3.objects["backups/id/file"])
+	assert.Empty(t, s3.parts)
+
+	r, err := target.Open(ctx, "id/file")
+	require.NoError(t, err)
+	defer r.Close()
+
+	read, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, data, read)
+}
+
+func TestS3ChecksumMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s3Fail(w, http.StatusBadRequest, "BadDigest")
+	}))
+	defer srv.Close()
+
+	target, err := newS3Target("s3://bucket", testS3Options(srv.URL))
+	require.NoError(t, err)
+	target.backoff = time.Millisecond
+
+	_, err = target.Put(context.Background(), "id/file", strings.NewReader("data"))
+	var s3Err *s3Error
+	require.ErrorAs(t, err, &s3Err)
+	assert.Equal(t, "BadDigest", s3Err.Code)
+}
+
+func TestS3Escape(t *testing.T) {
+	assert.Equal(t, "/bucket/a%20b/c~d", s3Escape("/bucket/a b/c~d", false))
+	assert.Equal(t, "a%2Fb", s3Escape("a/b", true))
+	assert.Equal(t, "list-type=2&prefix=a%2F", canonicalQuery(map[string][]string{"prefix": {"a/"}, "list-type": {"2"}}))
+}
@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Target keeps backups, every backup is a directory named by its ID.
+// Paths are relative to the target and separated by slashes.
+type Target interface {
+	// Put writes the contents of r to path and
+	// returns their size and checksum.
+	Put(ctx context.Context, path string, r io.Reader) (File, error)
+
+	// Open opens path, missing paths return an fs.ErrNotExist error.
+	Open(ctx context.Context, path string) (io.ReadCloser, error)
+
+	// List returns the IDs of the backups, complete or not.
+	List(ctx context.Context) ([]string, error)
+
+	// Remove removes the backup id.
+	Remove(ctx context.Context, id string) error
+
+	String() string
+}
+
+// OpenTarget returns the target at location, an
+// s3://bucket/prefix URL or a local directory.
+func OpenTarget(location string) (Target, error) {
+	if strings.HasPrefix(location, "s3://") {
+		t, err := newS3Target(location, S3OptionsFromEnv())
+		if err != nil {
+			return nil, err
+		}
+
+		return t, nil
+	}
+
+	if location == "" {
+		return nil, fmt.Errorf("empty backup location")
+	}
+
+	return localTarget(location), nil
+}
+
+// SplitRef splits a reference to a backup, its
+// location followed by a slash and its ID.
+func SplitRef(ref string) (string, string) {
+	ref = strings.TrimRight(ref, "/")
+
+	i := strings.LastIndex(ref, "/")
+	if i < 0 {
+		return ".", ref
+	}
+
+	return ref[:i], ref[i+1:]
+}
+
+// localTarget keeps backups in a local directory.
+type localTarget string
+
+func (t localTarget) path(path string) string {
+	return filepath.Join(string(t), filepath.FromSlash(path))
+}
+
+func (t localTarget) Put(ctx context.Context, path string, r io.Reader) (File, error) {
+	dst := t.path(path)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return File{}, err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return File{}, err
+	}
+	defer out.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, h), r)
+	if err != nil {
+		return File{}, err
+	}
+
+	if err := out.Sync(); err != nil {
+		return File{}, err
+	}
+
+	return File{Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, out.Close()
+}
+
+func (t localTarget) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return os.Open(t.path(path))
+}
+
+func (t localTarget) List(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(string(t))
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			ids = append(ids, entry.Name())
+		}
+	}
+
+	return ids, nil
+}
+
+func (t localTarget) Remove(ctx context.Context, id string) error {
+	return os.RemoveAll(t.path(id))
+}
+
+func (t localTarget) String() string {
+	return string(t)
+}
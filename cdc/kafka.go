@@ -0,0 +1,593 @@
+package cdc
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Kafka wire protocol, see https://kafka.apache.org/protocol. Only the
+// requests a producer needs are implemented: Metadata v1 to find the
+// leaders of the partitions and Produce v3 with record batches of the
+// v2 format, uncompressed, over plaintext connections.
+const (
+	apiProduce  = 0
+	apiMetadata = 3
+
+	produceVersion  = 3
+	metadataVersion = 1
+
+	// acksAll waits for every in-sync replica.
+	acksAll = -1
+
+	recordBatchMagic = 2
+)
+
+// Kafka error codes telling that the metadata of the producer is stale.
+var staleMetadataErrors = map[int16]bool{
+	3:  true, // UNKNOWN_TOPIC_OR_PARTITION
+	5:  true, // LEADER_NOT_AVAILABLE
+	6:  true, // NOT_LEADER_OR_FOLLOWER
+	7:  true, // REQUEST_TIMED_OUT
+	19: true, // NOT_ENOUGH_REPLICAS
+	20: true, // NOT_ENOUGH_REPLICAS_AFTER_APPEND
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// KafkaError is an error code returned by a broker.
+type KafkaError struct {
+	Code      int16
+	Topic     string
+	Partition int32
+}
+
+func (e *KafkaError) Error() string {
+	return fmt.Sprintf("kafka error %d on %s/%d", e.Code, e.Topic, e.Partition)
+}
+
+// Message is a record produced to a topic,
+// messages with the same key go to the same partition.
+type Message struct {
+	Key   []byte
+	Value []byte
+	Time  time.Time
+}
+
+// KafkaOptions configure a KafkaProducer.
+type KafkaOptions struct {
+	Brokers  []string
+	Topic    string
+	ClientID string
+
+	// Timeout bounds every request, and the
+	// time the brokers wait for the replicas.
+	Timeout time.Duration
+}
+
+// KafkaProducer produces messages to the partitions of a topic,
+// choosing them like the Java client does, by the murmur2 hash of
+// the key. Every produce waits for the in-sync replicas.
+type KafkaProducer struct {
+	opts KafkaOptions
+
+	mu    sync.Mutex
+	conns map[int32]*kafkaConn
+
+	// leaders are the leader brokers of the partitions,
+	// addrs the addresses of the brokers by id.
+	leaders []int32
+	addrs   map[int32]string
+}
+
+func NewKafkaProducer(opts KafkaOptions) *KafkaProducer {
+	return &KafkaProducer{
+		opts:  opts,
+		conns: make(map[int32]*kafkaConn),
+	}
+}
+
+// Produce sends messages and returns once every in-sync replica
+// has them. On failure some of them may have been written.
+func (p *KafkaProducer) Produce(ctx context.Context, messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.leaders == nil {
+		if err := p.refresh(ctx); err != nil {
+			return err
+		}
+	}
+
+	// every partition keeps the order of its messages
+	byLeader := make(map[int32]map[int32][]Message)
+	for _, m := range messages {
+		partition := int32(murmur2(m.Key)&0x7fffffff) % int32(len(p.leaders))
+		leader := p.leaders[partition]
+		if byLeader[leader] == nil {
+			byLeader[leader] = make(map[int32][]Message)
+		}
+
+		byLeader[leader][partition] = append(byLeader[leader][partition], m)
+	}
+
+	for leader, partitions := range byLeader {
+		if err := p.produce(ctx, leader, partitions); err != nil {
+			var kerr *KafkaError
+			if !errors.As(err, &kerr) || staleMetadataErrors[kerr.Code] {
+				p.leaders = nil
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *KafkaProducer) produce(ctx context.Context, leader int32, partitions map[int32][]Message) error {
+	conn, err := p.conn(ctx, leader)
+	if err != nil {
+		return err
+	}
+
+	req := &encoder{}
+	req.int16(-1) // no transactional id
+	req.int16(acksAll)
+	req.int32(int32(p.opts.Timeout / time.Millisecond))
+	req.int32(1)
+	req.string(p.opts.Topic)
+	req.int32(int32(len(partitions)))
+	for partition, messages := range partitions {
+		req.int32(partition)
+		req.bytes(recordBatch(messages))
+	}
+
+	res, err := conn.roundTrip(ctx, apiProduce, produceVersion, req.buf, p.opts.Timeout)
+	if err != nil {
+		p.drop(leader)
+		return err
+	}
+
+	for range res.array() {
+		topic := res.string()
+		for range res.array() {
+			partition := res.int32()
+			code := res.int16()
+			res.int64() // base offset
+			res.int64() // log append time
+			if res.err == nil && code != 0 {
+				return &KafkaError{Code: code, Topic: topic, Partition: partition}
+			}
+		}
+	}
+
+	return res.err
+}
+
+// refresh reads the leaders of the partitions of the topic
+// from the first broker answering. Must be called with mu held.
+func (p *KafkaProducer) refresh(ctx context.Context) error {
+	var errs []error
+	for _, addr := range p.opts.Brokers {
+		err := p.metadata(ctx, addr)
+		if err == nil {
+			return nil
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+	}
+
+	return fmt.Errorf("kafka metadata: %w", errors.Join(errs...))
+}
+
+func (p *KafkaProducer) metadata(ctx context.Context, addr string) error {
+	conn, err := dialKafka(ctx, addr, p.opts.ClientID, p.opts.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+
+	req := &encoder{}
+	req.int32(1)
+	req.string(p.opts.Topic)
+
+	res, err := conn.roundTrip(ctx, apiMetadata, metadataVersion, req.buf, p.opts.Timeout)
+	if err != nil {
+		return err
+	}
+
+	addrs := make(map[int32]string)
+	for range res.array() {
+		id := res.int32()
+		host := res.string()
+		port := res.int32()
+		res.nullableString() // rack
+		addrs[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+
+	res.int32() // controller
+
+	var leaders []int32
+	for range res.array() {
+		code := res.int16()
+		topic := res.string()
+		res.int8() // internal
+
+		partitions := res.array()
+		if res.err == nil && code != 0 {
+			return &KafkaError{Code: code, Topic: topic, Partition: -1}
+		}
+
+		leaders = make([]int32, partitions)
+		for range partitions {
+			code := res.int16()
+			partition := res.int32()
+			leader := res.int32()
+			for range res.array() {
+				res.int32() // replicas
+			}
+			for range res.array() {
+				res.int32() // in-sync replicas
+			}
+
+			if res.err != nil {
+				break
+			}
+
+			if code != 0 && !staleMetadataErrors[code] || partition < 0 || int(partition) >= len(leaders) {
+				return &KafkaError{Code: code, Topic: topic, Partition: partition}
+			}
+
+			leaders[partition] = leader
+		}
+	}
+
+	if res.err != nil {
+		return res.err
+	}
+
+	if len(leaders) == 0 {
+		return fmt.Errorf("topic %s has no partitions", p.opts.Topic)
+	}
+
+	p.leaders, p.addrs = leaders, addrs
+	return nil
+}
+
+// conn returns the connection to broker. Must be called with mu held.
+func (p *KafkaProducer) conn(ctx context.Context, broker int32) (*kafkaConn, error) {
+	if conn, ok := p.conns[broker]; ok {
+		return conn, nil
+	}
+
+	addr, ok := p.addrs[broker]
+	if !ok {
+		p.leaders = nil
+		return nil, fmt.Errorf("unknown kafka broker %d", broker)
+	}
+
+	conn, err := dialKafka(ctx, addr, p.opts.ClientID, p.opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	p.conns[broker] = conn
+	return conn, nil
+}
+
+// drop closes the connection to broker after a failed request, a
+// response still on the way must not be read as the next one.
+func (p *KafkaProducer) drop(broker int32) {
+	if conn, ok := p.conns[broker]; ok {
+		conn.close()
+		delete(p.conns, broker)
+	}
+}
+
+// Close closes the connections to the brokers.
+func (p *KafkaProducer) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for broker := range p.conns {
+		p.drop(broker)
+	}
+}
+
+type kafkaConn struct {
+	conn          net.Conn
+	r             *bufio.Reader
+	clientID      string
+	correlationID int32
+}
+
+func dialKafka(ctx context.Context, addr string, clientID string, timeout time.Duration) (*kafkaConn, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafkaConn{
+		conn:     conn,
+		r:        bufio.NewReader(conn),
+		clientID: clientID,
+	}, nil
+}
+
+// roundTrip sends a request with a v1 header
+// and returns the body of its response.
+func (c *kafkaConn) roundTrip(ctx context.Context, apiKey int16, version int16, body []byte, timeout time.Duration) (*decoder, error) {
+	c.correlationID++
+
+	req := &encoder{}
+	req.int32(0) // size, set below
+	req.int16(apiKey)
+	req.int16(version)
+	req.int32(c.correlationID)
+	req.string(c.clientID)
+	req.buf = append(req.buf, body...)
+	binary.BigEndian.PutUint32(req.buf, uint32(len(req.buf)-4))
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	if _, err := c.conn.Write(req.buf); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size < 4 {
+		return nil, fmt.Errorf("kafka response of %d bytes", size)
+	}
+
+	if id := int32(binary.BigEndian.Uint32(header[4:8])); id != c.correlationID {
+		return nil, fmt.Errorf("kafka response to request %d, expected %d", id, c.correlationID)
+	}
+
+	res := make([]byte, size-4)
+	if _, err := io.ReadFull(c.r, res); err != nil {
+		return nil, err
+	}
+
+	return &decoder{buf: res}, nil
+}
+
+func (c *kafkaConn) close() {
+	c.conn.Close()
+}
+
+// recordBatch encodes messages as a record batch of the v2 format,
+// without producer id, so brokers don't deduplicate retried batches.
+func recordBatch(messages []Message) []byte {
+	first, last := messages[0].Time.UnixMilli(), messages[0].Time.UnixMilli()
+	for _, m := range messages {
+		first, last = min(first, m.Time.UnixMilli()), max(last, m.Time.UnixMilli())
+	}
+
+	// the fields covered by the crc
+	body := &encoder{}
+	body.int16(0) // attributes, uncompressed with create times
+	body.int32(int32(len(messages) - 1))
+	body.int64(first)
+	body.int64(last)
+	body.int64(-1) // producer id
+	body.int16(-1) // producer epoch
+	body.int32(-1) // base sequence
+	body.int32(int32(len(messages)))
+
+	for i, m := range messages {
+		record := &encoder{}
+		record.int8(0) // attributes
+		record.varint(m.Time.UnixMilli() - first)
+		record.varint(int64(i))
+		record.varintBytes(m.Key)
+		record.varintBytes(m.Value)
+		record.varint(0) // headers
+
+		body.varint(int64(len(record.buf)))
+		body.buf = append(body.buf, record.buf...)
+	}
+
+	batch := &encoder{}
+	batch.int64(0)                        // base offset
+	batch.int32(int32(len(body.buf) + 9)) // length after this field
+	batch.int32(-1)                       // partition leader epoch
+	batch.int8(recordBatchMagic)
+	batch.int32(int32(crc32.Checksum(body.buf, castagnoli)))
+	batch.buf = append(batch.buf, body.buf...)
+
+	return batch.buf
+}
+
+// murmur2 is the hash of the Java client partitioning keys.
+func murmur2(data []byte) int32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+
+	length := len(data)
+	h := uint32(seed) ^ uint32(length)
+
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+
+	return int32(h)
+}
+
+// encoder appends the big endian types of the protocol.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) int8(v int8) {
+	e.buf = append(e.buf, byte(v))
+}
+
+func (e *encoder) int16(v int16) {
+	e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v))
+}
+
+func (e *encoder) int32(v int32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v))
+}
+
+func (e *encoder) int64(v int64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v))
+}
+
+func (e *encoder) string(v string) {
+	e.int16(int16(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) bytes(v []byte) {
+	e.int32(int32(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// varint appends a zigzag varint, as records encode their fields.
+func (e *encoder) varint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+// varintBytes appends v with a varint length, nil is null.
+func (e *encoder) varintBytes(v []byte) {
+	if v == nil {
+		e.varint(-1)
+		return
+	}
+
+	e.varint(int64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// decoder reads the types of the protocol, the first
+// failed read sets err and the next ones return zeros.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	if n < 0 || n > len(d.buf) {
+		d.err = fmt.Errorf("kafka response truncated, %d bytes left, %d needed", len(d.buf), n)
+		return nil
+	}
+
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+
+	return 0
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+
+	return 0
+}
+
+func (d *decoder) string() string {
+	return string(d.next(int(d.int16())))
+}
+
+func (d *decoder) nullableString() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+
+	return string(d.next(int(n)))
+}
+
+// array returns the length of an array, 0 for null arrays.
+func (d *decoder) array() int {
+	n := int(d.int32())
+	if n > len(d.buf) {
+		d.err = fmt.Errorf("kafka response truncated, array of %d elements in %d bytes", n, len(d.buf))
+	}
+
+	if d.err != nil || n < 0 {
+		return 0
+	}
+
+	return n
+}
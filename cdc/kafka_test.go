@@ -0,0 +1,254 @@
+package cdc
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMurmur2(t *testing.T) {
+	// the hashes of the Java client
+	hashes := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+
+	for key, hash := range hashes {
+		assert.Equal(t, hash, murmur2([]byte(key)), key)
+	}
+}
+
+// fakeBroker is a single broker leading the partitions of a topic,
+// recording the keys and values produced to every partition.
+type fakeBroker struct {
+	t          *testing.T
+	listener   net.Listener
+	partitions int
+
+	mu       sync.Mutex
+	produced map[int32][]Message
+}
+
+func newFakeBroker(t *testing.T, partitions int) *fakeBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	b := &fakeBroker{
+		t:          t,
+		listener:   listener,
+		partitions: partitions,
+		produced:   make(map[int32][]Message),
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go b.serve(conn)
+		}
+	}()
+
+	return b
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		size := make([]byte, 4)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return
+		}
+
+		req := &decoder{buf: make([]byte, binary.BigEndian.Uint32(size))}
+		if _, err := io.ReadFull(conn, req.buf); err != nil {
+			return
+		}
+
+		apiKey := req.int16()
+		req.int16() // version
+		correlationID := req.int32()
+		req.nullableString() // client id
+
+		res := &encoder{}
+		res.int32(0)
+		res.int32(correlationID)
+
+		switch apiKey {
+		case apiMetadata:
+			b.metadata(req, res)
+		case apiProduce:
+			b.produce(req, res)
+		default:
+			b.t.Errorf("unexpected api key %d", apiKey)
+			return
+		}
+
+		assert.NoError(b.t, req.err)
+		binary.BigEndian.PutUint32(res.buf, uint32(len(res.buf)-4))
+		if _, err := conn.Write(res.buf); err != nil {
+			return
+		}
+	}
+}
+
+func (b *fakeBroker) metadata(req *decoder, res *encoder) {
+	topic := ""
+	for range req.array() {
+		topic = req.string()
+	}
+
+	host, port, _ := net.SplitHostPort(b.listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+
+	res.int32(1)
+	res.int32(7) // broker id
+	res.string(host)
+	res.int32(int32(portNum))
+	res.int16(-1) // rack
+	res.int32(7)  // controller
+
+	res.int32(1)
+	res.int16(0)
+	res.string(topic)
+	res.int8(0)
+	res.int32(int32(b.partitions))
+	for partition := range b.partitions {
+		res.int16(0)
+		res.int32(int32(partition))
+		res.int32(7)
+		res.int32(1)
+		res.int32(7)
+		res.int32(1)
+		res.int32(7)
+	}
+}
+
+func (b *fakeBroker) produce(req *decoder, res *encoder) {
+	req.nullableString() // transactional id
+	assert.Equal(b.t, int16(acksAll), req.int16())
+	req.int32() // timeout
+
+	res.int32(int32(req.array()))
+	topic := req.string()
+	res.string(topic)
+
+	partitions := req.array()
+	res.int32(int32(partitions))
+	for range partitions {
+		partition := req.int32()
+		batch := &decoder{buf: req.next(int(req.int32()))}
+
+		b.mu.Lock()
+		b.produced[partition] = append(b.produced[partition], b.records(batch)...)
+		b.mu.Unlock()
+
+		res.int32(partition)
+		res.int16(0)
+		res.int64(0)
+		res.int64(-1)
+	}
+
+	res.int32(0) // throttle time
+}
+
+// records decodes a record batch, checking its crc.
+func (b *fakeBroker) records(batch *decoder) []Message {
+	batch.int64() // base offset
+	batch.int32() // length
+	batch.int32() // partition leader epoch
+	assert.Equal(b.t, int8(recordBatchMagic), batch.int8())
+	crc := uint32(batch.int32())
+	assert.Equal(b.t, crc32.Checksum(batch.buf, castagnoli), crc)
+
+	batch.next(2 + 4 + 8 + 8 + 8 + 2 + 4)
+	count := batch.int32()
+
+	varint := func() int64 {
+		v, n := binary.Varint(batch.buf)
+		batch.next(n)
+		return v
+	}
+
+	var messages []Message
+	for range count {
+		varint() // length
+		batch.int8()
+		varint() // timestamp delta
+		varint() // offset delta
+
+		key := batch.next(int(varint()))
+		value := batch.next(int(varint()))
+		varint() // headers
+
+		messages = append(messages, Message{Key: key, Value: value})
+	}
+
+	assert.NoError(b.t, batch.err)
+	assert.Empty(b.t, batch.buf)
+	return messages
+}
+
+func TestKafkaProducer(t *testing.T) {
+	broker := newFakeBroker(t, 3)
+
+	producer := NewKafkaProducer(KafkaOptions{
+		Brokers:  []string{"127.0.0.1:1", broker.listener.Addr().String()},
+		Topic:    "changes",
+		ClientID: "test",
+		Timeout:  time.Second,
+	})
+	defer producer.Close()
+
+	var messages []Message
+	for _, key := range []string{"a", "b", "c", "a", "d", "a"} {
+		messages = append(messages, Message{
+			Key:   []byte(key),
+			Value: []byte(key + strconv.Itoa(len(messages))),
+			Time:  time.Now(),
+		})
+	}
+
+	assert.NoError(t, producer.Produce(context.Background(), messages[:3]))
+	assert.NoError(t, producer.Produce(context.Background(), messages[3:]))
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	var total int
+	for partition, produced := range broker.produced {
+		total += len(produced)
+		for _, m := range produced {
+			assert.Equal(t, int32(murmur2(m.Key)&0x7fffffff)%3, partition)
+		}
+	}
+
+	assert.Equal(t, len(messages), total)
+
+	// the messages of a key keep their order
+	a := broker.produced[int32(murmur2([]byte("a"))&0x7fffffff)%3]
+	var values []string
+	for _, m := range a {
+		if string(m.Key) == "a" {
+			values = append(values, string(m.Value))
+		}
+	}
+
+	assert.Equal(t, []string{"a0", "a3", "a5"}, values)
+}
@@ -0,0 +1,286 @@
+package cdc
+
+import (
+	"context"
+	"distrikv/events"
+	"distrikv/replication"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+	"unicode/utf8"
+)
+
+// Serializations of the messages of a Sink.
+const (
+	// FORMAT_JSON is an Event per message.
+	FORMAT_JSON = "json"
+
+	// FORMAT_BINARY is the record of the change in the changes kept
+	// on disk, see wal.WALEntry, CRC included.
+	FORMAT_BINARY = "binary"
+)
+
+// CheckpointFile is the file of the data dir holding
+// the sequence of the last change a Sink published.
+const CheckpointFile = "CDC_CHECKPOINT"
+
+const (
+	// batchSize is the number of changes produced at once.
+	batchSize = 500
+
+	// linger is how long a sink waits for more writes
+	// once woken up, so they are produced together.
+	linger = 50 * time.Millisecond
+
+	// pollInterval is how often an idle sink reads
+	// the changelog, in case it missed a wake-up.
+	pollInterval = time.Second
+
+	minRetryInterval = 500 * time.Millisecond
+	maxRetryInterval = 30 * time.Second
+)
+
+// Event is the JSON of a change. Op is "set", "delete" or "delete_range"
+// of the keys in [Key, End). Keys and values that aren't valid UTF-8
+// are base64 encoded, as told by Encoding.
+type Event struct {
+	Seq       uint64    `json:"seq"`
+	Op        string    `json:"op"`
+	Key       string    `json:"key"`
+	End       string    `json:"end,omitempty"`
+	Value     string    `json:"value,omitempty"`
+	Encoding  string    `json:"encoding"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+func newEvent(change replication.Change) Event {
+	event := Event{
+		Seq:       change.Seq,
+		Op:        change.Op.String(),
+		Key:       change.Key,
+		End:       change.End,
+		Value:     change.Value,
+		Encoding:  "utf8",
+		ExpiresAt: change.ExpiresAt,
+	}
+
+	if !utf8.ValidString(change.Key) || !utf8.ValidString(change.End) || !utf8.ValidString(change.Value) {
+		event.Key = base64.StdEncoding.EncodeToString([]byte(change.Key))
+		event.End = base64.StdEncoding.EncodeToString([]byte(change.End))
+		event.Value = base64.StdEncoding.EncodeToString([]byte(change.Value))
+		event.Encoding = replication.ENCODING_BASE64
+	}
+
+	return event
+}
+
+// Changelog is the history of the changes a Sink publishes.
+type Changelog interface {
+	History(seq uint64, fn func(replication.Change) bool) error
+	Freshness() replication.Freshness
+}
+
+// Producer produces messages, every one of them once it returns nil.
+type Producer interface {
+	Produce(ctx context.Context, messages []Message) error
+}
+
+// Sink publishes the changes of a changelog to a producer, in order,
+// keyed by the key they write. The sequence of the last change produced
+// is checkpointed once the producer acknowledged it, and a sink started
+// again resumes after it: every change is published at least once, the
+// ones produced before a crash but not checkpointed are published again.
+// Consumers tell them apart by their sequence.
+type Sink struct {
+	logger    *slog.Logger
+	changelog Changelog
+	producer  Producer
+	bus       *events.Bus
+	format    string
+	path      string
+
+	// seq is the sequence of the last change produced.
+	seq uint64
+}
+
+// NewSink returns a sink resuming after the checkpoint of dataDir.
+// Without one it starts with the next change of changelog.
+func NewSink(logger *slog.Logger, changelog Changelog, producer Producer, bus *events.Bus, format string, dataDir string) (*Sink, error) {
+	if format != FORMAT_JSON && format != FORMAT_BINARY {
+		return nil, fmt.Errorf("invalid cdc format %q, expected %s or %s", format, FORMAT_JSON, FORMAT_BINARY)
+	}
+
+	s := &Sink{
+		logger:    logger,
+		changelog: changelog,
+		producer:  producer,
+		bus:       bus,
+		format:    format,
+		path:      filepath.Join(dataDir, CheckpointFile),
+	}
+
+	var checkpoint struct {
+		Seq uint64 `json:"seq"`
+	}
+
+	data, err := os.ReadFile(s.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if err := s.checkpoint(changelog.Freshness().AppliedSeq); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &checkpoint); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.path, err)
+		}
+
+		s.seq = checkpoint.Seq
+	}
+
+	return s, nil
+}
+
+// Seq returns the sequence of the last change produced.
+func (s *Sink) Seq() uint64 {
+	return s.seq
+}
+
+// Start publishes the changes until ctx is done, retrying with a
+// growing interval while the producer or the changelog fails. Changes
+// no longer retained when the sink gets to them are never published,
+// the sink then logs errors until its checkpoint is removed.
+func (s *Sink) Start(ctx context.Context) {
+	var wake <-chan events.Event
+	if s.bus != nil {
+		// a wake-up, the writes themselves are read from the changelog
+		sub := s.bus.Subscribe(1, events.TOPIC_WRITE)
+		defer sub.Close()
+		wake = sub.C
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	retry := minRetryInterval
+	for {
+		err := s.Publish(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		wait := linger
+		if err != nil {
+			s.logger.Error("error publishing changes", "seq", s.seq, "err", err)
+			wait, retry = retry, min(2*retry, maxRetryInterval)
+		} else {
+			retry = minRetryInterval
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-wake:
+			case <-ticker.C:
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Publish produces the changes after the checkpoint,
+// checkpointing every batch once it was produced.
+func (s *Sink) Publish(ctx context.Context) error {
+	var batch []Message
+	var last uint64
+	var err error
+
+	flush := func() error {
+		if err := s.producer.Produce(ctx, batch); err != nil {
+			return err
+		}
+
+		batch = batch[:0]
+		return s.checkpoint(last)
+	}
+
+	historyErr := s.changelog.History(s.seq, func(change replication.Change) bool {
+		var message Message
+		message, err = s.message(change)
+		if err != nil {
+			return false
+		}
+
+		batch = append(batch, message)
+		last = change.Seq
+
+		if len(batch) == batchSize {
+			err = flush()
+		}
+
+		return err == nil && ctx.Err() == nil
+	})
+
+	switch {
+	case err != nil:
+		return err
+	case errors.Is(historyErr, replication.ErrChangesTruncated):
+		return fmt.Errorf("changes after %d: %w", s.seq, historyErr)
+	case errors.Is(historyErr, replication.ErrChangesAhead):
+		// the changelog was reset behind the checkpoint, e.g. by a restore
+		return fmt.Errorf("checkpoint %d: %w", s.seq, historyErr)
+	case historyErr != nil:
+		return historyErr
+	case len(batch) > 0:
+		return flush()
+	}
+
+	return ctx.Err()
+}
+
+// message serializes change in the format of the sink.
+func (s *Sink) message(change replication.Change) (Message, error) {
+	message := Message{
+		Key:  []byte(change.Key),
+		Time: time.Now(),
+	}
+
+	var err error
+	if s.format == FORMAT_BINARY {
+		message.Value, err = change.WALEntry().Encode()
+	} else {
+		message.Value, err = json.Marshal(newEvent(change))
+	}
+
+	return message, err
+}
+
+// checkpoint writes seq as the sequence of the last change produced.
+func (s *Sink) checkpoint(seq uint64) error {
+	data, err := json.Marshal(map[string]uint64{"seq": seq})
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	s.seq = seq
+	return nil
+}
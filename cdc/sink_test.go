@@ -0,0 +1,104 @@
+package cdc
+
+import (
+	"context"
+	"distrikv/replication"
+	"distrikv/wal"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeChangelog struct {
+	changes []replication.Change
+}
+
+func (c *fakeChangelog) History(seq uint64, fn func(replication.Change) bool) error {
+	for _, change := range c.changes {
+		if change.Seq > seq && !fn(change) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (c *fakeChangelog) Freshness() replication.Freshness {
+	var f replication.Freshness
+	if len(c.changes) > 0 {
+		f.AppliedSeq = c.changes[len(c.changes)-1].Seq
+	}
+
+	return f
+}
+
+// fakeProducer fails the produces while err is set.
+type fakeProducer struct {
+	produced []Message
+	err      error
+}
+
+func (p *fakeProducer) Produce(ctx context.Context, messages []Message) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	p.produced = append(p.produced, messages...)
+	return nil
+}
+
+func TestSink(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	changelog := &fakeChangelog{changes: []replication.Change{
+		{Seq: 1, Op: replication.OP_SET, Key: "a", Value: "1"},
+	}}
+	producer := &fakeProducer{}
+
+	// a sink without a checkpoint starts with the next change
+	sink, err := NewSink(logger, changelog, producer, nil, FORMAT_JSON, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), sink.Seq())
+
+	changelog.changes = append(changelog.changes,
+		replication.Change{Seq: 2, Op: replication.OP_DELETE, Key: "a"},
+		replication.Change{Seq: 3, Op: replication.OP_SET, Key: "\xff", Value: "2"},
+	)
+
+	assert.NoError(t, sink.Publish(context.Background()))
+	assert.Len(t, producer.produced, 2)
+	assert.Equal(t, uint64(3), sink.Seq())
+
+	var event Event
+	assert.NoError(t, json.Unmarshal(producer.produced[0].Value, &event))
+	assert.Equal(t, Event{Seq: 2, Op: "delete", Key: "a", Encoding: "utf8"}, event)
+	assert.NoError(t, json.Unmarshal(producer.produced[1].Value, &event))
+	assert.Equal(t, replication.ENCODING_BASE64, event.Encoding)
+	assert.Equal(t, []byte("\xff"), producer.produced[1].Key)
+
+	// changes that failed to be produced are published again
+	changelog.changes = append(changelog.changes, replication.Change{Seq: 4, Op: replication.OP_SET, Key: "b", Value: "3"})
+	producer.err = errors.New("unavailable")
+	assert.Error(t, sink.Publish(context.Background()))
+	assert.Equal(t, uint64(3), sink.Seq())
+
+	// a new sink resumes after the checkpoint
+	producer = &fakeProducer{}
+	sink, err = NewSink(logger, changelog, producer, nil, FORMAT_BINARY, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), sink.Seq())
+	assert.NoError(t, sink.Publish(context.Background()))
+	assert.Len(t, producer.produced, 1)
+
+	expected, err := (&wal.WALEntry{Seq: 4, Type: wal.RecordPut, Key: "b", Value: "3"}).Encode()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, producer.produced[0].Value)
+
+	_, err = NewSink(logger, changelog, producer, nil, "avro", dir)
+	assert.Error(t, err)
+}
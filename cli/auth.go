@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"distrikv/auth"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// tokenEnv holds the API key or token the commands
+// talking to a node authenticate with.
+const tokenEnv = "DISTRIKV_TOKEN"
+
+const authUsage = `usage: distrikv auth <command>
+
+commands:
+  add-key [-keys-file path] [-role r] [-prefixes p] <name>            create an API key and print it, it can't be shown again
+  remove-key [-keys-file path] <name>                                 revoke an API key
+  list-keys [-keys-file path]                                         names, roles and prefixes of the API keys
+  token [-secret-file path] [-role r] [-prefixes p] [-ttl d] <subject> print a token signed with the secret shared by the nodes
+
+roles are read (GET, mget and scan), write (read and PUT, DELETE, incr and
+import) or admin (write and /admin), read by default. -prefixes restricts a
+credential to the keys starting with one of the comma separated prefixes.
+
+the keys file defaults to $AUTH_KEYS_FILE or $DATA_DIR/api-keys.json, nodes pick
+up its changes within seconds. The secret file defaults to $AUTH_TOKEN_SECRET_FILE.
+Other commands send the key or token of $DISTRIKV_TOKEN.`
+
+// Auth manages the API keys of a node and issues tokens.
+func Auth(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(authUsage)
+	}
+
+	flags := flag.NewFlagSet("auth "+args[0], flag.ContinueOnError)
+	keysFile := flags.String("keys-file", defaultKeysFile(), "API keys file of the node")
+	secretFile := flags.String("secret-file", os.Getenv("AUTH_TOKEN_SECRET_FILE"), "token secret file of the nodes")
+	ttl := flags.Duration("ttl", 24*time.Hour, "lifetime of the token, 0 never expires")
+	role := flags.String("role", auth.ROLE_READ, "read, write or admin")
+	prefixes := flags.String("prefixes", "", "comma separated key prefixes the credential is restricted to")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch {
+	case args[0] == "add-key" && flags.NArg() == 1:
+		key, err := auth.AddKey(*keysFile, flags.Arg(0), *role, splitList(*prefixes))
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(out, key)
+		return nil
+	case args[0] == "remove-key" && flags.NArg() == 1:
+		if err := auth.RemoveKey(*keysFile, flags.Arg(0)); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "removed api key %s\n", flags.Arg(0))
+		return nil
+	case args[0] == "list-keys" && flags.NArg() == 0:
+		keys, err := auth.ReadKeys(*keysFile)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tROLE\tPREFIXES\tCREATED")
+		for _, k := range keys {
+			role, _ := auth.ParseRole(k.Role)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", k.Name, role, strings.Join(k.Prefixes, ","), k.CreatedAt.Format(time.RFC3339))
+		}
+
+		return w.Flush()
+	case args[0] == "token" && flags.NArg() == 1:
+		if *secretFile == "" {
+			return errors.New("a token secret file is required")
+		}
+
+		secret, err := auth.ReadSecret(*secretFile)
+		if err != nil {
+			return err
+		}
+
+		token, err := auth.NewToken(secret, flags.Arg(0), *role, splitList(*prefixes), *ttl)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(out, token)
+		return nil
+	default:
+		return errors.New(authUsage)
+	}
+}
+
+func defaultKeysFile() string {
+	if path := os.Getenv("AUTH_KEYS_FILE"); path != "" {
+		return path
+	}
+
+	dataDir := os.Getenv("DATA_DIR")
+	if dataDir == "" {
+		dataDir = "data"
+	}
+
+	return filepath.Join(dataDir, "api-keys.json")
+}
+
+// splitList splits comma separated values, dropping empty ones.
+func splitList(list string) []string {
+	var res []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
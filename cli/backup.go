@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"context"
+	"distrikv/api"
+	"distrikv/backup"
+	"distrikv/ops"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const backupUsage = `usage: distrikv backup <command>
+
+commands:
+  create [-addr url] <path>       back up the node's shards into a new directory of path on the node
+  list <path>                     backups in path
+  verify <backup>                 check the files of a backup against their checksums
+  restore <backup> <data-dir>     restore a backup into an empty data directory
+
+paths are directories or s3://bucket/prefix locations, backups are <path>/<id>.
+S3 credentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+AWS_SESSION_TOKEN and AWS_REGION, S3_ENDPOINT sets an S3 compatible endpoint.`
+
+// backupPollInterval is the wait between checks of a running backup.
+const backupPollInterval = 500 * time.Millisecond
+
+// Backup runs the backup commands. Backups are created by a node,
+// listed, verified and restored locally.
+func Backup(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(backupUsage)
+	}
+
+	ctx := context.Background()
+	switch {
+	case args[0] == "create":
+		return createBackup(args[1:], out)
+	case args[0] == "list" && len(args) == 2:
+		target, err := backup.OpenTarget(args[1])
+		if err != nil {
+			return err
+		}
+
+		backups, err := backup.List(ctx, target)
+		if err != nil {
+			return err
+		}
+
+		printBackups(out, backups)
+		return nil
+	case args[0] == "verify" && len(args) == 2:
+		location, id := backup.SplitRef(args[1])
+		target, err := backup.OpenTarget(location)
+		if err != nil {
+			return err
+		}
+
+		metadata, err := backup.Verify(ctx, target, id)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "backup %s is intact, %d files\n", metadata.ID, len(metadata.Files))
+		return nil
+	case args[0] == "restore" && len(args) == 3:
+		location, id := backup.SplitRef(args[1])
+		target, err := backup.OpenTarget(location)
+		if err != nil {
+			return err
+		}
+
+		metadata, err := backup.Restore(ctx, target, id, args[2])
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "restored backup %s at seq %d into %s, %d files verified\n", metadata.ID, metadata.Seq, args[2], len(metadata.Files))
+		return nil
+	default:
+		return errors.New(backupUsage)
+	}
+}
+
+// createBackup starts a backup on a node and waits until it finished.
+func createBackup(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("backup create", flag.ContinueOnError)
+	addr := flags.String("addr", "http://localhost:6090", "base URL of a node")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New(backupUsage)
+	}
+
+	c := newAdminClient(*addr, 30*time.Second)
+
+	var op ops.Operation
+	if err := c.do(http.MethodPost, "/admin/backups", api.BackupRequest{Path: flags.Arg(0)}, &op); err != nil {
+		return err
+	}
+
+	for op.State == ops.OP_RUNNING.String() {
+		time.Sleep(backupPollInterval)
+
+		if err := c.do(http.MethodGet, "/admin/operations/"+url.PathEscape(op.ID), nil, &op); err != nil {
+			return err
+		}
+	}
+
+	if op.State != ops.OP_DONE.String() {
+		return fmt.Errorf("backup %s: %s", op.State, op.Error)
+	}
+
+	fmt.Fprintf(out, "backup written to %s, %d bytes\n", flags.Arg(0), op.Done)
+	return nil
+}
+
+func printBackups(out io.Writer, backups []*backup.Metadata) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "ID\tCREATED\tSEQ\tSHARDS\tFILES\tSIZE")
+	for _, b := range backups {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\n", b.ID, b.CreatedAt.Format(time.RFC3339), b.Seq, len(b.Shards), len(b.Files), strconv.FormatInt(b.Size(), 10))
+	}
+}
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"context"
+	"distrikv/perf"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const benchUsage = `usage: distrikv bench [-dir shard-dir | [-addr url] [-grpc addr]] [flags]
+
+runs a workload against a store in a temporary directory, the shard
+directory -dir, which no node may be serving, or the node at -addr, over
+gRPC with -grpc, and reports the throughput and the read and write latencies.
+readrandom and readwrite first write every key unless -no-fill is set.`
+
+// Bench runs a benchmark workload.
+func Bench(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	dir := flags.String("dir", "", "shard directory to open, a temporary directory by default")
+	comparator := flags.String("comparator", "bytewise", "key order of the shard directory")
+	addr := flags.String("addr", "", "base URL of a node")
+	grpcAddr := flags.String("grpc", "", "gRPC address of the node to read and write keys through")
+	workload := flags.String("workload", perf.WORKLOAD_FILLRANDOM, "one of "+strings.Join(perf.Workloads, ", "))
+	duration := flags.Duration("duration", 10*time.Second, "duration of the run")
+	workers := flags.Int("workers", 16, "concurrent clients")
+	keys := flags.Int("keys", 100000, "distinct keys")
+	keySize := flags.Int("key-size", 16, "key size in bytes")
+	valueSize := flags.Int("value-size", 100, "value size in bytes")
+	readPercent := flags.Int("read-percent", 50, "share of reads of readwrite")
+	noFill := flags.Bool("no-fill", false, "don't write the keys before reading them")
+	asJSON := flags.Bool("json", false, "print JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() > 0 || (*dir != "" && (*addr != "" || *grpcAddr != "")) {
+		return errors.New(benchUsage)
+	}
+
+	var target shellStore
+	var err error
+	switch {
+	case *grpcAddr != "":
+		target, err = openGRPCStore(*grpcAddr, *addr)
+	case *addr != "":
+		target, err = openHTTPStore(*addr)
+	default:
+		path := *dir
+		if path == "" {
+			if path, err = os.MkdirTemp("", "distrikv-bench-"); err != nil {
+				return err
+			}
+			defer os.RemoveAll(path)
+		}
+
+		target, err = openLocalStore(path, *comparator)
+	}
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	report, err := perf.Bench(context.Background(), target, perf.BenchOptions{
+		Workload:    *workload,
+		Duration:    *duration,
+		Workers:     *workers,
+		Keys:        *keys,
+		KeySize:     *keySize,
+		ValueSize:   *valueSize,
+		ReadPercent: *readPercent,
+		SkipFill:    *noFill,
+		IsNotFound:  isNotFound,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	report.Write(out)
+	return nil
+}
@@ -0,0 +1,183 @@
+package cli
+
+import (
+	"bytes"
+	"distrikv/api"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const clusterUsage = `usage: distrikv cluster [-addr url] <command>
+
+commands:
+  status                     nodes, shard ownership, replication lag and disk usage
+  decommission <node>        move every shard of node to the other nodes
+  move-shard <shard> <node>  move shard to node
+  replication-factor <n>     change the number of owners of every shard
+  compact                    start a manual compaction of the node's shards
+  operations                 running and recent operations of the node
+  cancel <operation>         cancel a running operation`
+
+// Cluster runs the cluster admin commands against the admin API of a node.
+func Cluster(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("cluster", flag.ContinueOnError)
+	addr := flags.String("addr", "http://localhost:6090", "base URL of a node")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	c := newAdminClient(*addr, 30*time.Second)
+
+	args = flags.Args()
+	if len(args) == 0 {
+		return errors.New(clusterUsage)
+	}
+
+	switch {
+	case args[0] == "status" && len(args) == 1:
+		var info api.ClusterResponse
+		if err := c.do(http.MethodGet, "/admin/cluster", nil, &info); err != nil {
+			return err
+		}
+
+		printClusterStatus(out, info)
+		return nil
+	case args[0] == "decommission" && len(args) == 2:
+		return c.print(out, http.MethodPost, "/admin/cluster/decommission", api.DecommissionRequest{Node: args[1]})
+	case args[0] == "move-shard" && len(args) == 3:
+		if _, err := strconv.Atoi(args[1]); err != nil {
+			return fmt.Errorf("invalid shard %q", args[1])
+		}
+
+		return c.print(out, http.MethodPost, "/admin/cluster/shards/"+args[1]+"/move", api.MoveShardRequest{Node: args[2]})
+	case args[0] == "replication-factor" && len(args) == 2:
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid replication factor %q", args[1])
+		}
+
+		return c.print(out, http.MethodPut, "/admin/cluster/replication-factor", api.ReplicationFactorRequest{ReplicationFactor: n})
+	case args[0] == "compact" && len(args) == 1:
+		return c.print(out, http.MethodPost, "/admin/compact", nil)
+	case args[0] == "operations" && len(args) == 1:
+		return c.print(out, http.MethodGet, "/admin/operations", nil)
+	case args[0] == "cancel" && len(args) == 2:
+		return c.print(out, http.MethodPost, "/admin/operations/"+url.PathEscape(args[1])+"/cancel", nil)
+	default:
+		return errors.New(clusterUsage)
+	}
+}
+
+func printClusterStatus(out io.Writer, info api.ClusterResponse) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "self\t%s\n", info.Self.ID)
+	fmt.Fprintf(w, "replication\t%s, lag %d\n", info.Replication.Role, info.ReplicationLag)
+	fmt.Fprintf(w, "replication factor\t%d\n", info.ReplicationFactor)
+	fmt.Fprintf(w, "moves running\t%d\n\n", info.MovesRunning)
+
+	fmt.Fprintln(w, "NODE\tADDR\tSHARDS\tSTATE")
+	for _, node := range info.Nodes {
+		state := "active"
+		if node.Decommissioned {
+			state = "decommissioned"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", node.ID, node.Addr, node.Shards, state)
+	}
+
+	fmt.Fprintln(w, "\nSHARD\tOWNER\tDISK")
+	for _, shard := range info.Shards {
+		disk := "-"
+		if size, ok := info.DiskUsage[shard.ID]; ok {
+			disk = strconv.FormatInt(size, 10)
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\n", shard.ID, shard.Owner, disk)
+	}
+}
+
+// adminClient calls the admin API of a node.
+type adminClient struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+// newAdminClient returns a client of the admin API of the node at addr,
+// authenticated with the credential of $DISTRIKV_TOKEN.
+func newAdminClient(addr string, timeout time.Duration) *adminClient {
+	return &adminClient{
+		addr:   addr,
+		token:  os.Getenv(tokenEnv),
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// print sends body and prints the JSON response.
+func (c *adminClient) print(out io.Writer, method string, path string, body any) error {
+	var res json.RawMessage
+	if err := c.do(method, path, body, &res); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, res, "", "  "); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintln(out, buf.String())
+	return err
+}
+
+func (c *adminClient) do(method string, path string, body any, res any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.addr+path, reader)
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		var e api.ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Message == "" {
+			return fmt.Errorf("node responded with %s", resp.Status)
+		}
+
+		return fmt.Errorf("%s: %s", e.Code, e.Message)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(res)
+}
@@ -0,0 +1,272 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// lineReader reads the commands of the shell.
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+	History() []string
+	Close() error
+}
+
+// newLineReader edits lines on terminals, keeping their history
+// in historyFile. Other input, e.g. a script, is read as is.
+func newLineReader(in io.Reader, out io.Writer, historyFile string) lineReader {
+	if f, ok := in.(*os.File); ok && isTerminal(f) {
+		return &terminalReader{
+			in:      f,
+			out:     out,
+			reader:  bufio.NewReader(f),
+			history: loadHistory(historyFile),
+		}
+	}
+
+	return &plainReader{
+		scanner: bufio.NewScanner(in),
+		history: &history{},
+	}
+}
+
+// history is the list of previous commands, oldest first.
+type history struct {
+	file  string
+	lines []string
+}
+
+// loadHistory reads the commands of previous sessions from file,
+// a missing or unreadable file starts an empty history.
+func loadHistory(file string) *history {
+	h := &history{file: file}
+	if file == "" {
+		return h
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return h
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			h.lines = append(h.lines, line)
+		}
+	}
+
+	return h
+}
+
+// add appends line unless it's empty or repeats the last command.
+func (h *history) add(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" || (len(h.lines) > 0 && h.lines[len(h.lines)-1] == line) {
+		return
+	}
+
+	h.lines = append(h.lines, line)
+}
+
+// save writes the latest shellHistorySize commands to the file.
+func (h *history) save() error {
+	if h.file == "" {
+		return nil
+	}
+
+	lines := h.lines[max(0, len(h.lines)-shellHistorySize):]
+	return os.WriteFile(h.file, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
+
+// plainReader reads lines without prompt or editing.
+type plainReader struct {
+	scanner *bufio.Scanner
+	history *history
+}
+
+func (r *plainReader) ReadLine(string) (string, error) {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+
+		return "", io.EOF
+	}
+
+	line := r.scanner.Text()
+	r.history.add(line)
+
+	return line, nil
+}
+
+func (r *plainReader) History() []string {
+	return r.history.lines
+}
+
+func (r *plainReader) Close() error {
+	return nil
+}
+
+// terminalReader edits lines in raw mode: arrows move the cursor and
+// walk the history, Ctrl-A/E jump to the start/end, Ctrl-U/K cut before/after
+// the cursor, Ctrl-C discards the line and Ctrl-D on an empty line ends input.
+type terminalReader struct {
+	in      *os.File
+	out     io.Writer
+	reader  *bufio.Reader
+	history *history
+}
+
+// lineEdit is the state of the line being edited.
+type lineEdit struct {
+	prompt string
+	line   []rune
+	cursor int
+
+	// pos is the history entry shown, len(lines) for the new line
+	// whose draft is kept while walking the history.
+	pos   int
+	draft []rune
+}
+
+func (r *terminalReader) ReadLine(prompt string) (string, error) {
+	// the terminal is raw only while editing,
+	// so command output and Ctrl-C work as usual
+	restore, err := makeRaw(r.in)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	e := &lineEdit{prompt: prompt, pos: len(r.history.lines)}
+	fmt.Fprint(r.out, prompt)
+
+	for {
+		c, _, err := r.reader.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch c {
+		case '\r', '\n':
+			fmt.Fprint(r.out, "\n")
+			line := string(e.line)
+			r.history.add(line)
+			return line, nil
+		case 3: // Ctrl-C
+			fmt.Fprint(r.out, "^C\n", prompt)
+			e = &lineEdit{prompt: prompt, pos: len(r.history.lines)}
+			continue
+		case 4: // Ctrl-D
+			if len(e.line) == 0 {
+				fmt.Fprint(r.out, "\n")
+				return "", io.EOF
+			}
+
+			e.deleteAt(e.cursor)
+		case 127, 8: // Backspace
+			if e.cursor > 0 {
+				e.cursor--
+				e.deleteAt(e.cursor)
+			}
+		case 1: // Ctrl-A
+			e.cursor = 0
+		case 5: // Ctrl-E
+			e.cursor = len(e.line)
+		case 21: // Ctrl-U
+			e.line = e.line[e.cursor:]
+			e.cursor = 0
+		case 11: // Ctrl-K
+			e.line = e.line[:e.cursor]
+		case 27: // escape sequences of the arrows, home, end and delete
+			r.escape(e)
+		default:
+			if !unicode.IsPrint(c) {
+				continue
+			}
+
+			e.line = append(e.line[:e.cursor], append([]rune{c}, e.line[e.cursor:]...)...)
+			e.cursor++
+		}
+
+		r.refresh(e)
+	}
+}
+
+func (r *terminalReader) escape(e *lineEdit) {
+	if c, _, err := r.reader.ReadRune(); err != nil || (c != '[' && c != 'O') {
+		return
+	}
+
+	c, _, err := r.reader.ReadRune()
+	if err != nil {
+		return
+	}
+
+	switch c {
+	case 'A':
+		r.walkHistory(e, -1)
+	case 'B':
+		r.walkHistory(e, 1)
+	case 'C':
+		e.cursor = min(e.cursor+1, len(e.line))
+	case 'D':
+		e.cursor = max(e.cursor-1, 0)
+	case 'H':
+		e.cursor = 0
+	case 'F':
+		e.cursor = len(e.line)
+	case '3':
+		if c, _, _ := r.reader.ReadRune(); c == '~' {
+			e.deleteAt(e.cursor)
+		}
+	}
+}
+
+// walkHistory shows the entry step entries away from the shown one.
+func (r *terminalReader) walkHistory(e *lineEdit, step int) {
+	lines := r.history.lines
+	pos := e.pos + step
+	if pos < 0 || pos > len(lines) {
+		return
+	}
+
+	if e.pos == len(lines) {
+		e.draft = e.line
+	}
+
+	e.pos = pos
+	if pos == len(lines) {
+		e.line = e.draft
+	} else {
+		e.line = []rune(lines[pos])
+	}
+
+	e.cursor = len(e.line)
+}
+
+// refresh redraws the line and puts the cursor back.
+func (r *terminalReader) refresh(e *lineEdit) {
+	fmt.Fprintf(r.out, "\r%s%s\x1b[K", e.prompt, string(e.line))
+	if n := len(e.line) - e.cursor; n > 0 {
+		fmt.Fprintf(r.out, "\x1b[%dD", n)
+	}
+}
+
+func (e *lineEdit) deleteAt(i int) {
+	if i < len(e.line) {
+		e.line = append(e.line[:i:i], e.line[i+1:]...)
+	}
+}
+
+func (r *terminalReader) History() []string {
+	return r.history.lines
+}
+
+func (r *terminalReader) Close() error {
+	return r.history.save()
+}
@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"distrikv/storage"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+const repairUsage = `usage: distrikv repair [-json] <data-dir>
+
+fixes what verify finds in the data directory and its shard-* directories.
+Incomplete SSTs are dropped, the readable entries of corrupted or unordered
+SSTs are rewritten, misnamed SSTs renamed and leftover snapshots removed.
+Replaced files are kept in the quarantine directory of their shard.
+No node may be running on the directory.`
+
+// Repair repairs the stores of a data directory without opening them.
+// It fails when problems are left.
+func Repair(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("repair", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New(repairUsage)
+	}
+
+	dirs, err := storeDirs(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var reports []*storage.RepairReport
+	var left int
+	for _, dir := range dirs {
+		report, err := storage.RepairDir(dir)
+		if report != nil {
+			reports = append(reports, report)
+			left += len(report.Unresolved)
+		}
+
+		if err != nil {
+			printRepairReports(out, reports)
+			return err
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			return err
+		}
+	} else {
+		printRepairReports(out, reports)
+	}
+
+	if left > 0 {
+		return fmt.Errorf("%d problems left", left)
+	}
+
+	return nil
+}
+
+func printRepairReports(out io.Writer, reports []*storage.RepairReport) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	var actions, unresolved int
+	for _, r := range reports {
+		for _, a := range r.Actions {
+			if actions == 0 {
+				fmt.Fprintln(w, "FILE\tACTION\tDETAIL")
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\n", filepath.Join(r.Dir, a.File), a.Action, a.Detail)
+			actions++
+		}
+	}
+
+	if actions == 0 {
+		fmt.Fprintln(w, "nothing to repair")
+	}
+
+	for _, r := range reports {
+		for _, p := range r.Unresolved {
+			if unresolved == 0 {
+				fmt.Fprintln(w, "\nUNRESOLVED\tPROBLEM\tDETAIL")
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\n", filepath.Join(r.Dir, p.File), p.Kind, p.Detail)
+			unresolved++
+		}
+	}
+}
@@ -0,0 +1,512 @@
+package cli
+
+import (
+	"context"
+	"distrikv/api"
+	"distrikv/client"
+	"distrikv/grpc"
+	"distrikv/pkg"
+	"distrikv/storage"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const shellUsage = `usage: distrikv cli [-dir shard-dir | -addr url [-grpc addr]] [-history file]
+
+connects to the node at -addr, over gRPC for keys with -grpc,
+or opens the shard directory -dir, which no node may be serving.`
+
+const shellHelp = `commands:
+  set <key> <value>            write key, values may contain spaces
+  get <key>                    read key
+  delete <key>                 delete key
+  scan [start] [end] [limit]   keys from start until end, 100 by default
+  stats                        keys, levels, memtables and caches
+  flush                        write the memtables to SSTs
+  compact                      compact every level
+  history                      previous commands
+  help                         this list
+  exit                         quit
+
+keys and values can be double quoted, e.g. set "a key" "a\tvalue".`
+
+// shellScanLimit is the number of keys scan prints by default.
+const shellScanLimit = 100
+
+// shellHistorySize is the number of commands kept in the history file.
+const shellHistorySize = 1000
+
+var errExit error = errors.New("exit")
+
+// shellStore is what the shell runs its commands against,
+// an embedded store or a node.
+type shellStore interface {
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key string, value string) error
+	Delete(ctx context.Context, key string) error
+	Scan(ctx context.Context, start string, end string, fn func(key string, value string) bool) error
+	Stats(ctx context.Context) (any, error)
+	Flush(ctx context.Context) (int, error)
+	Compact(ctx context.Context) (string, error)
+	Close() error
+}
+
+// Shell runs an interactive shell reading commands from in.
+// Terminals get line editing and the history of previous sessions.
+func Shell(args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("cli", flag.ContinueOnError)
+	dir := flags.String("dir", "", "shard directory to open, e.g. data/shard-000")
+	comparator := flags.String("comparator", "bytewise", "key order of the shard directory")
+	addr := flags.String("addr", "", "base URL of a node")
+	grpcAddr := flags.String("grpc", "", "gRPC address of the node to read and write keys through")
+	history := flags.String("history", defaultHistoryFile(), "file the command history is kept in, empty keeps none")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() > 0 || (*dir == "") == (*addr == "") {
+		return errors.New(shellUsage)
+	}
+
+	var store shellStore
+	var err error
+	switch {
+	case *dir != "":
+		store, err = openLocalStore(*dir, *comparator)
+	case *grpcAddr != "":
+		store, err = openGRPCStore(*grpcAddr, *addr)
+	default:
+		store, err = openHTTPStore(*addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	lines := newLineReader(in, out, *history)
+	defer lines.Close()
+
+	fmt.Fprintln(out, `distrikv shell, "help" lists the commands`)
+	for {
+		line, err := lines.ReadLine("> ")
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = runShellCommand(context.Background(), store, lines, line, out)
+		if errors.Is(err, errExit) {
+			return nil
+		}
+
+		if err != nil {
+			fmt.Fprintln(out, "error:", err)
+		}
+	}
+}
+
+func runShellCommand(ctx context.Context, store shellStore, lines lineReader, line string, out io.Writer) error {
+	args, err := splitArgs(line)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return nil
+	}
+
+	switch cmd := args[0]; {
+	case cmd == "set" && len(args) >= 3:
+		if err := store.Set(ctx, args[1], strings.Join(args[2:], " ")); err != nil {
+			return err
+		}
+
+		fmt.Fprintln(out, "OK")
+	case cmd == "get" && len(args) == 2:
+		value, err := store.Get(ctx, args[1])
+		if isNotFound(err) {
+			fmt.Fprintln(out, "(not found)")
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(out, strconv.Quote(value))
+	case cmd == "delete" && len(args) == 2:
+		if err := store.Delete(ctx, args[1]); err != nil {
+			return err
+		}
+
+		fmt.Fprintln(out, "OK")
+	case cmd == "scan" && len(args) <= 4:
+		return shellScan(ctx, store, args[1:], out)
+	case cmd == "stats" && len(args) == 1:
+		stats, err := store.Stats(ctx)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(out, string(data))
+	case cmd == "flush" && len(args) == 1:
+		entries, err := store.Flush(ctx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "flushed %d entries\n", entries)
+	case cmd == "compact" && len(args) == 1:
+		res, err := store.Compact(ctx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(out, res)
+	case cmd == "history" && len(args) == 1:
+		for i, line := range lines.History() {
+			fmt.Fprintf(out, "%5d  %s\n", i+1, line)
+		}
+	case cmd == "help":
+		fmt.Fprintln(out, shellHelp)
+	case cmd == "exit" || cmd == "quit":
+		return errExit
+	default:
+		return fmt.Errorf("invalid command %q, \"help\" lists the commands", line)
+	}
+
+	return nil
+}
+
+func shellScan(ctx context.Context, store shellStore, args []string, out io.Writer) error {
+	var start, end string
+	limit := shellScanLimit
+	if len(args) > 0 {
+		start = args[0]
+	}
+
+	if len(args) > 1 {
+		end = args[1]
+	}
+
+	if len(args) > 2 {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid limit %q", args[2])
+		}
+
+		limit = n
+	}
+
+	var n int
+	more := false
+	err := store.Scan(ctx, start, end, func(key string, value string) bool {
+		if n == limit {
+			more = true
+			return false
+		}
+
+		fmt.Fprintf(out, "%s\t%s\n", strconv.Quote(key), strconv.Quote(value))
+		n++
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if more {
+		fmt.Fprintf(out, "(first %d keys)\n", n)
+	} else {
+		fmt.Fprintf(out, "(%d keys)\n", n)
+	}
+
+	return nil
+}
+
+// splitArgs splits line at spaces, double quoted
+// arguments are unquoted like Go string literals.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return args, nil
+		}
+
+		if line[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted argument %s", line)
+			}
+
+			arg, _ := strconv.Unquote(quoted)
+			args = append(args, arg)
+			line = line[len(quoted):]
+			continue
+		}
+
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			i = len(line)
+		}
+
+		args = append(args, line[:i])
+		line = line[i:]
+	}
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, storage.ErrKeyNotFound) ||
+		errors.Is(err, client.ErrNotFound) ||
+		status.Code(err) == codes.NotFound
+}
+
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".distrikv_history")
+}
+
+// localStore runs the commands against a shard directory.
+type localStore struct {
+	store *storage.Store
+}
+
+func openLocalStore(dir string, comparator string) (*localStore, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	cmp, err := storage.ParseComparator(comparator)
+	if err != nil {
+		return nil, err
+	}
+
+	logger, err := pkg.NewLogger(os.Stderr, "text", "warn", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := storage.Open(context.Background(), logger.With(pkg.ComponentKey, "storage"), dir, storage.OPEN_FAST, nil, cmp, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localStore{store: store}, nil
+}
+
+func (s *localStore) Get(ctx context.Context, key string) (string, error) {
+	data, err := s.store.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+
+	return data.Value, nil
+}
+
+func (s *localStore) Set(_ context.Context, key string, value string) error {
+	return s.store.Set(key, value)
+}
+
+func (s *localStore) Delete(_ context.Context, key string) error {
+	return s.store.Delete(key)
+}
+
+func (s *localStore) Scan(ctx context.Context, start string, end string, fn func(key string, value string) bool) error {
+	return s.store.Scan(ctx, start, end, func(data *storage.KVData) bool {
+		return fn(data.Key, data.Value)
+	})
+}
+
+func (s *localStore) Stats(context.Context) (any, error) {
+	return s.store.Stats()
+}
+
+func (s *localStore) Flush(ctx context.Context) (int, error) {
+	return s.store.Flush(ctx)
+}
+
+func (s *localStore) Compact(ctx context.Context) (string, error) {
+	if err := s.store.CompactAll(ctx, func(int, int) {}); err != nil {
+		return "", err
+	}
+
+	return "compacted every level", nil
+}
+
+// Close closes the store, flushing the memtables
+// which are not kept anywhere else.
+func (s *localStore) Close() error {
+	_, err := s.store.Close(context.Background())
+	return err
+}
+
+// keyClient reads and writes the keys of a node.
+type keyClient interface {
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key string, value string) error
+	Delete(ctx context.Context, key string) error
+	Scan(ctx context.Context, start string, end string, fn func(key string, value string) bool) error
+}
+
+// remoteStore runs the commands against a node,
+// keys through keyClient and the rest through its admin API.
+type remoteStore struct {
+	keyClient
+	admin *adminClient
+	close func() error
+}
+
+func openHTTPStore(addr string) (*remoteStore, error) {
+	opts := client.DefaultOptions
+	opts.Token = os.Getenv(tokenEnv)
+
+	c, err := client.NewWithOptions(opts, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteStore{
+		keyClient: c,
+		admin:     newShellAdminClient(addr),
+		close: func() error {
+			c.Close()
+			return nil
+		},
+	}, nil
+}
+
+// openGRPCStore sends keys to the gRPC server at grpcAddr,
+// addr is needed for the other commands.
+func openGRPCStore(grpcAddr string, addr string) (*remoteStore, error) {
+	c, err := grpc.NewClient(grpcAddr, nil, os.Getenv(tokenEnv))
+	if err != nil {
+		return nil, err
+	}
+
+	store := &remoteStore{
+		keyClient: grpcKeyClient{client: c},
+		close:     c.Close,
+	}
+
+	if addr != "" {
+		store.admin = newShellAdminClient(addr)
+	}
+
+	return store, nil
+}
+
+func newShellAdminClient(addr string) *adminClient {
+	return newAdminClient(addr, 5*time.Minute)
+}
+
+func (s *remoteStore) Stats(context.Context) (any, error) {
+	var stats json.RawMessage
+	if err := s.adminRequest(http.MethodGet, "/admin/stats", &stats); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func (s *remoteStore) Flush(context.Context) (int, error) {
+	var res api.FlushResponse
+	if err := s.adminRequest(http.MethodPost, "/admin/flush", &res); err != nil {
+		return 0, err
+	}
+
+	return res.Entries, nil
+}
+
+// Compact starts a compaction of the node, which
+// runs on as an operation listed by "cluster operations".
+func (s *remoteStore) Compact(context.Context) (string, error) {
+	var op struct {
+		ID string `json:"id"`
+	}
+	if err := s.adminRequest(http.MethodPost, "/admin/compact", &op); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("started compaction %s", op.ID), nil
+}
+
+func (s *remoteStore) adminRequest(method string, path string, res any) error {
+	if s.admin == nil {
+		return errors.New("the command needs the node's -addr")
+	}
+
+	return s.admin.do(method, path, nil, res)
+}
+
+func (s *remoteStore) Close() error {
+	return s.close()
+}
+
+// grpcKeyClient reads and writes keys over gRPC.
+type grpcKeyClient struct {
+	client *grpc.Client
+}
+
+func (c grpcKeyClient) Get(ctx context.Context, key string) (string, error) {
+	res, err := c.client.Get(ctx, &grpc.GetRequest{Key: key})
+	if err != nil {
+		return "", err
+	}
+
+	return string(res.Value), nil
+}
+
+func (c grpcKeyClient) Set(ctx context.Context, key string, value string) error {
+	_, err := c.client.Set(ctx, &grpc.SetRequest{Key: key, Value: []byte(value)})
+	return err
+}
+
+func (c grpcKeyClient) Delete(ctx context.Context, key string) error {
+	_, err := c.client.Delete(ctx, &grpc.DeleteRequest{Key: key})
+	return err
+}
+
+// Scan reads the pages of the scan until fn returns false.
+func (c grpcKeyClient) Scan(ctx context.Context, start string, end string, fn func(key string, value string) bool) error {
+	req := &grpc.ScanRequest{Start: start, End: end}
+	for {
+		stopped := false
+		cursor, err := c.client.Scan(ctx, req, func(kv *grpc.KeyValue) bool {
+			if !fn(kv.Key, string(kv.Value)) {
+				stopped = true
+				return false
+			}
+
+			return true
+		})
+		if err != nil || stopped || cursor == "" {
+			return err
+		}
+
+		req.Cursor = cursor
+	}
+}
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShellLocal(t *testing.T) {
+	dir := t.TempDir()
+	script := strings.Join([]string{
+		`set a 1`,
+		`set "b c" "x\ty" z`,
+		`get "b c"`,
+		`delete a`,
+		`get a`,
+		`scan "" "" 1`,
+		`get "unterminated`,
+		`exit`,
+		`get "b c"`,
+	}, "\n")
+
+	var out bytes.Buffer
+	require.NoError(t, Shell([]string{"-dir", dir, "-history", ""}, strings.NewReader(script), &out))
+
+	assert.Equal(t, strings.Join([]string{
+		`distrikv shell, "help" lists the commands`,
+		`OK`,
+		`OK`,
+		`"x\ty z"`,
+		`OK`,
+		`(not found)`,
+		`"b c"	"x\ty z"`,
+		`(1 keys)`,
+		`error: invalid quoted argument "unterminated`,
+		``,
+	}, "\n"), out.String())
+
+	// the memtable was flushed on exit
+	out.Reset()
+	require.NoError(t, Shell([]string{"-dir", dir, "-history", ""}, strings.NewReader(`get "b c"`), &out))
+	assert.Contains(t, out.String(), `"x\ty z"`)
+}
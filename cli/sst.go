@@ -0,0 +1,190 @@
+package cli
+
+import (
+	"distrikv/storage"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const sstUsage = `usage: distrikv sst <command>
+
+commands:
+  dump [-records] [-json] <file>   metadata, entry counts and key range of an SST file,
+                                   -records lists every entry with its offset and flags`
+
+// sstValuePreview is the length of the values printed by dump, longer
+// values are cut, their size is printed next to them.
+const sstValuePreview = 40
+
+// SSTDump is the content of an SST file printed by sst dump.
+type SSTDump struct {
+	File       string    `json:"file"`
+	Size       int64     `json:"size"`
+	ID         uint64    `json:"id"`
+	Level      int       `json:"level"`
+	CreatedAt  time.Time `json:"created_at"`
+	Comparator string    `json:"comparator"`
+
+	Entries    int    `json:"entries"`
+	Tombstones int    `json:"tombstones"`
+	Expiring   int    `json:"expiring"`
+	MinKey     string `json:"min_key"`
+	MaxKey     string `json:"max_key"`
+
+	// Error is the corruption that stopped the dump,
+	// the counts above cover the entries before it.
+	Error string `json:"error,omitempty"`
+
+	Records []SSTRecord `json:"records,omitempty"`
+}
+
+// SSTRecord is an entry of an SST file.
+type SSTRecord struct {
+	Offset    int64     `json:"offset"`
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	Deleted   bool      `json:"deleted,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// SST runs the commands inspecting SST files, they
+// read the files directly and need no running node.
+func SST(args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] != "dump" {
+		return errors.New(sstUsage)
+	}
+
+	flags := flag.NewFlagSet("sst dump", flag.ContinueOnError)
+	records := flags.Bool("records", false, "list every entry")
+	asJSON := flags.Bool("json", false, "print JSON")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New(sstUsage)
+	}
+
+	dump, err := dumpSST(flags.Arg(0), *records)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(dump)
+	}
+
+	printSSTDump(out, dump)
+	return nil
+}
+
+func dumpSST(file string, records bool) (*SSTDump, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	sst, err := storage.OpenSSTFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	dump := &SSTDump{
+		File:       file,
+		Size:       info.Size(),
+		ID:         sst.ID,
+		Level:      sst.Level,
+		CreatedAt:  sst.Timestamp,
+		Comparator: sst.Comparator,
+	}
+
+	err = sst.ReadEntries(func(offset int64, entry *storage.SSTEntry) bool {
+		if dump.Entries == 0 {
+			dump.MinKey = entry.Key
+		}
+
+		dump.MaxKey = entry.Key
+		dump.Entries++
+		if entry.IsDeleted {
+			dump.Tombstones++
+		}
+
+		if !entry.ExpiresAt.IsZero() {
+			dump.Expiring++
+		}
+
+		if records {
+			dump.Records = append(dump.Records, SSTRecord{
+				Offset:    offset,
+				Key:       entry.Key,
+				Value:     entry.Value,
+				Deleted:   entry.IsDeleted,
+				ExpiresAt: entry.ExpiresAt,
+			})
+		}
+
+		return true
+	})
+	if err != nil {
+		dump.Error = err.Error()
+	}
+
+	return dump, nil
+}
+
+func printSSTDump(out io.Writer, dump *SSTDump) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "file\t%s\n", dump.File)
+	fmt.Fprintf(w, "size\t%d bytes\n", dump.Size)
+	fmt.Fprintf(w, "id\t%d\n", dump.ID)
+	fmt.Fprintf(w, "level\t%d\n", dump.Level)
+	fmt.Fprintf(w, "created\t%s\n", dump.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "comparator\t%s\n", dump.Comparator)
+	fmt.Fprintf(w, "entries\t%d, %d tombstones, %d expiring\n", dump.Entries, dump.Tombstones, dump.Expiring)
+	if dump.Entries > 0 {
+		fmt.Fprintf(w, "key range\t%s - %s\n", strconv.Quote(dump.MinKey), strconv.Quote(dump.MaxKey))
+	}
+
+	if dump.Error != "" {
+		fmt.Fprintf(w, "error\t%s\n", dump.Error)
+	}
+
+	w.Flush()
+
+	if len(dump.Records) == 0 {
+		return
+	}
+
+	w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "\nOFFSET\tFLAGS\tKEY\tVALUE")
+	for _, r := range dump.Records {
+		flags := "-"
+		switch {
+		case r.Deleted:
+			flags = "deleted"
+		case !r.ExpiresAt.IsZero():
+			flags = "expires " + r.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+
+		value := r.Value
+		if len(value) > sstValuePreview {
+			value = fmt.Sprintf("%s... (%d bytes)", strconv.Quote(value[:sstValuePreview]), len(r.Value))
+		} else {
+			value = strconv.Quote(value)
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", r.Offset, flags, strconv.Quote(r.Key), value)
+	}
+}
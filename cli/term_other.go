@@ -0,0 +1,18 @@
+//go:build !linux
+
+package cli
+
+import (
+	"errors"
+	"os"
+)
+
+// Line editing needs the termios of Linux,
+// other systems read commands without it.
+func isTerminal(*os.File) bool {
+	return false
+}
+
+func makeRaw(*os.File) (func(), error) {
+	return nil, errors.New("line editing is not supported on this system")
+}
@@ -0,0 +1,333 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"distrikv/api"
+	"distrikv/pkg"
+	"distrikv/storage"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+	"unicode/utf8"
+)
+
+const exportUsage = `usage: distrikv export [-format ndjson|csv] [-comparator name] [-o file] <data-dir>
+
+writes the live keys of the data directory and of its shard-* directories,
+which no node may be serving, to stdout or -o. Shards are written one after
+the other, each in key order. Expired and deleted keys are left out.`
+
+const importUsage = `usage: distrikv import [-format ndjson|csv] [-comparator name] <shard-dir> [file]
+
+loads the keys of file, stdin by default, into the shard directory,
+which no node may be serving, by writing SSTs directly. Imported keys
+replace the ones already in the directory.`
+
+// Formats of export and import. An ndjson line is an object with the key,
+// the value, base64 encoded when it isn't valid UTF-8 with encoding set to
+// base64, and expires_at for expiring keys. csv has a key,value,expires_at
+// header, values are written as they are.
+const (
+	FORMAT_NDJSON = "ndjson"
+	FORMAT_CSV    = "csv"
+)
+
+var csvHeader = []string{"key", "value", "expires_at"}
+
+// record is a key of an ndjson export.
+type record struct {
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	Encoding  string    `json:"encoding,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// Export writes the keys of a data directory.
+func Export(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", FORMAT_NDJSON, "ndjson or csv")
+	comparator := flags.String("comparator", "bytewise", "key order of the data directory")
+	output := flags.String("o", "", "file to write, stdout by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 || (*format != FORMAT_NDJSON && *format != FORMAT_CSV) {
+		return errors.New(exportUsage)
+	}
+
+	cmp, err := storage.ParseComparator(*comparator)
+	if err != nil {
+		return err
+	}
+
+	dirs, err := storeDirs(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var file *os.File
+	if *output != "" {
+		if file, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer file.Close()
+
+		out = file
+	}
+
+	w := newRecordWriter(out, *format)
+
+	logger, err := pkg.NewLogger(os.Stderr, "text", "warn", nil)
+	if err != nil {
+		return err
+	}
+
+	var keys int
+	for _, dir := range dirs {
+		// the store is opened read-only, as checkpoints are
+		store, err := storage.OpenCheckpoint(logger.With(pkg.ComponentKey, "storage"), dir, cmp)
+		if err != nil {
+			return fmt.Errorf("%s: %w", dir, err)
+		}
+
+		var writeErr error
+		err = store.Scan(context.Background(), "", "", func(data *storage.KVData) bool {
+			writeErr = w.write(data.Key, data.Value, data.ExpiresAt)
+			keys++
+			return writeErr == nil
+		})
+		if err := errors.Join(err, writeErr); err != nil {
+			return fmt.Errorf("%s: %w", dir, err)
+		}
+	}
+
+	if err := w.flush(); err != nil {
+		return err
+	}
+
+	if file != nil {
+		fmt.Fprintf(os.Stderr, "exported %d keys to %s\n", keys, *output)
+		return file.Close()
+	}
+
+	return nil
+}
+
+// Import loads a file into a shard directory.
+func Import(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", FORMAT_NDJSON, "ndjson or csv")
+	comparator := flags.String("comparator", "bytewise", "key order of the shard directory")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() < 1 || flags.NArg() > 2 || (*format != FORMAT_NDJSON && *format != FORMAT_CSV) {
+		return errors.New(importUsage)
+	}
+
+	dir := flags.Arg(0)
+	if info, err := os.Stat(dir); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	// keys of a data directory would have to be routed to their shards
+	shards, err := filepath.Glob(filepath.Join(dir, "shard-*"))
+	if err != nil {
+		return err
+	}
+
+	if len(shards) > 0 {
+		return fmt.Errorf("%s holds shard directories, import into one of them", dir)
+	}
+
+	cmp, err := storage.ParseComparator(*comparator)
+	if err != nil {
+		return err
+	}
+
+	in := io.Reader(os.Stdin)
+	if flags.NArg() == 2 && flags.Arg(1) != "-" {
+		f, err := os.Open(flags.Arg(1))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		in = f
+	}
+
+	w, err := storage.NewBulkWriter(dir, cmp)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var expired int
+	err = readRecords(in, *format, func(key string, value string, expiresAt time.Time) error {
+		if !expiresAt.IsZero() && !expiresAt.After(now) {
+			expired++
+			return nil
+		}
+
+		return w.Put(key, value, expiresAt)
+	})
+	if err := errors.Join(err, w.Close()); err != nil {
+		return fmt.Errorf("%w, %d SSTs written", err, len(w.Files))
+	}
+
+	fmt.Fprintf(out, "imported %d keys into %d SSTs, %d expired keys skipped\n", w.Entries, len(w.Files), expired)
+	return nil
+}
+
+// recordWriter writes keys in the format of an export.
+type recordWriter struct {
+	format string
+	buf    *bufio.Writer
+	enc    *json.Encoder
+	csv    *csv.Writer
+	header bool
+}
+
+func newRecordWriter(out io.Writer, format string) *recordWriter {
+	w := &recordWriter{format: format, buf: bufio.NewWriter(out)}
+	if format == FORMAT_CSV {
+		w.csv = csv.NewWriter(w.buf)
+	} else {
+		w.enc = json.NewEncoder(w.buf)
+	}
+
+	return w
+}
+
+func (w *recordWriter) write(key string, value string, expiresAt time.Time) error {
+	if w.format == FORMAT_NDJSON {
+		r := record{Key: key, Value: value, ExpiresAt: expiresAt}
+		if !utf8.ValidString(value) {
+			r.Value = base64.StdEncoding.EncodeToString([]byte(value))
+			r.Encoding = api.ENCODING_BASE64
+		}
+
+		return w.enc.Encode(r)
+	}
+
+	if !w.header {
+		w.header = true
+		if err := w.csv.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+
+	var expires string
+	if !expiresAt.IsZero() {
+		expires = expiresAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	return w.csv.Write([]string{key, value, expires})
+}
+
+func (w *recordWriter) flush() error {
+	if w.csv != nil {
+		if !w.header {
+			if err := w.csv.Write(csvHeader); err != nil {
+				return err
+			}
+		}
+
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+
+	return w.buf.Flush()
+}
+
+// readRecords calls fn with every key of in, read in format, until fn fails.
+func readRecords(in io.Reader, format string, fn func(key string, value string, expiresAt time.Time) error) error {
+	if format == FORMAT_CSV {
+		return readCSVRecords(in, fn)
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, storage.MaxKeySize+4*storage.MaxValueSize)
+
+	var line int
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		value := r.Value
+		switch r.Encoding {
+		case "", api.ENCODING_UTF8:
+		case api.ENCODING_BASE64:
+			raw, err := base64.StdEncoding.DecodeString(r.Value)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+
+			value = string(raw)
+		default:
+			return fmt.Errorf("line %d: unknown encoding %q", line, r.Encoding)
+		}
+
+		if err := fn(r.Key, value, r.ExpiresAt); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func readCSVRecords(in io.Reader, fn func(key string, value string, expiresAt time.Time) error) error {
+	r := csv.NewReader(in)
+	r.FieldsPerRecord = -1
+
+	for n := 1; ; n++ {
+		fields, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if n == 1 && len(fields) > 0 && fields[0] == csvHeader[0] {
+			continue
+		}
+
+		if len(fields) < 2 || len(fields) > 3 {
+			return fmt.Errorf("record %d: %d fields, expected key,value[,expires_at]", n, len(fields))
+		}
+
+		var expiresAt time.Time
+		if len(fields) == 3 && fields[2] != "" {
+			if expiresAt, err = time.Parse(time.RFC3339Nano, fields[2]); err != nil {
+				return fmt.Errorf("record %d: %w", n, err)
+			}
+		}
+
+		if err := fn(fields[0], fields[1], expiresAt); err != nil {
+			return fmt.Errorf("record %d: %w", n, err)
+		}
+	}
+}
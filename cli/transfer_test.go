@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"bytes"
+	"distrikv/storage"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	src := t.TempDir()
+	shard := filepath.Join(src, "shard-000")
+	require.NoError(t, os.Mkdir(shard, 0755))
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	w, err := storage.NewBulkWriter(shard, nil)
+	require.NoError(t, err)
+	require.NoError(t, w.Put("a", "1", time.Time{}))
+	require.NoError(t, w.Put("b", "x,\"y\"\nz", expiresAt))
+	require.NoError(t, w.Put("c", "\xff\xfe", time.Time{}))
+	require.NoError(t, w.Put("d", "gone", time.Now().Add(-time.Hour)))
+	require.NoError(t, w.Close())
+
+	for _, format := range []string{FORMAT_NDJSON, FORMAT_CSV} {
+		t.Run(format, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "keys."+format)
+			require.NoError(t, Export([]string{"-format", format, "-o", file, src}, nil))
+
+			dst := t.TempDir()
+			var out bytes.Buffer
+			require.NoError(t, Import([]string{"-format", format, dst, file}, &out))
+			assert.Equal(t, "imported 3 keys into 1 SSTs, 0 expired keys skipped\n", out.String())
+
+			// the import exports the same keys
+			var exported, reexported bytes.Buffer
+			require.NoError(t, Export([]string{"-format", format, src}, &exported))
+			require.NoError(t, Export([]string{"-format", format, dst}, &reexported))
+			assert.Equal(t, exported.String(), reexported.String())
+		})
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, Export([]string{src}, &out))
+	assert.Equal(t, `{"key":"a","value":"1"}
+{"key":"b","value":"x,\"y\"\nz","expires_at":"`+expiresAt.Format(time.RFC3339)+`"}
+{"key":"c","value":"//4=","encoding":"base64"}
+`, out.String())
+
+	assert.ErrorContains(t, Import([]string{src, "-"}, &out), "holds shard directories")
+}
@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"distrikv/storage"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+const verifyUsage = `usage: distrikv verify [-quarantine] [-json] <data-dir>
+
+checks the SSTs of the data directory and of its shard-* directories:
+their footer, the framing and order of their entries, that their names
+match their footer, and that the SSTs of a shard agree on the comparator.
+-quarantine moves incomplete, corrupted and orphaned files into the
+quarantine directory of their shard. No node may be running on the directory.`
+
+// Verify checks the stores of a data directory without opening them.
+// It fails when problems are left.
+func Verify(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	quarantine := flags.Bool("quarantine", false, "move incomplete, corrupted and orphaned files into quarantine")
+	asJSON := flags.Bool("json", false, "print JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New(verifyUsage)
+	}
+
+	dirs, err := storeDirs(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var reports []*storage.VerifyReport
+	var left int
+	for _, dir := range dirs {
+		report, err := storage.VerifyDir(dir)
+		if err != nil {
+			return err
+		}
+
+		if *quarantine {
+			moved, err := report.Quarantine()
+			for _, file := range moved {
+				fmt.Fprintf(os.Stderr, "quarantined %s\n", file)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			// the problems of the moved files are gone, e.g. duplicates
+			if len(moved) > 0 {
+				if report, err = storage.VerifyDir(dir); err != nil {
+					return err
+				}
+			}
+		}
+
+		reports = append(reports, report)
+		left += len(report.Problems)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			return err
+		}
+	} else {
+		printVerifyReports(out, reports)
+	}
+
+	if left > 0 {
+		return fmt.Errorf("%d problems found", left)
+	}
+
+	return nil
+}
+
+// storeDirs returns the store directories of a data directory,
+// the directory itself for a single shard or its shard-* directories.
+func storeDirs(dataDir string) ([]string, error) {
+	info, err := os.Stat(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dataDir)
+	}
+
+	shards, err := filepath.Glob(filepath.Join(dataDir, "shard-*"))
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]string{dataDir}, shards...), nil
+}
+
+func printVerifyReports(out io.Writer, reports []*storage.VerifyReport) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "DIR\tSSTS\tENTRIES\tPROBLEMS")
+	for _, r := range reports {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", r.Dir, r.SSTs, r.Entries, len(r.Problems))
+	}
+
+	var problems bool
+	for _, r := range reports {
+		for _, p := range r.Problems {
+			if !problems {
+				fmt.Fprintln(w, "\nFILE\tPROBLEM\tDETAIL")
+				problems = true
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\n", filepath.Join(r.Dir, p.File), p.Kind, p.Detail)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"distrikv/wal"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+)
+
+const walUsage = `usage: distrikv wal <command>
+
+commands:
+  dump [-verify] <file>   sequence, type, key, value size and CRC status of every record
+                          of a WAL file, batches followed by their records,
+                          -verify only reports the offset of the first corruption`
+
+// WAL runs the commands inspecting WAL files, they
+// read the files directly and need no running node.
+func WAL(args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] != "dump" {
+		return errors.New(walUsage)
+	}
+
+	flags := flag.NewFlagSet("wal dump", flag.ContinueOnError)
+	verify := flags.Bool("verify", false, "report the offset of the first corruption")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New(walUsage)
+	}
+
+	file := flags.Arg(0)
+	if *verify {
+		return verifyWAL(out, file)
+	}
+
+	return dumpWAL(out, file)
+}
+
+func dumpWAL(out io.Writer, file string) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	var records, mismatches int
+	fmt.Fprintln(w, "OFFSET\tSEQ\tTYPE\tKEY\tVALUE SIZE\tCRC")
+	err := wal.ReadRecords(file, func(offset int64, entry *wal.WALEntry, err error) bool {
+		crc := "ok"
+		if err != nil {
+			crc = "mismatch"
+			mismatches++
+		}
+
+		records++
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%d\t%s\n", offset, entry.Seq, entry.Type, strconv.Quote(entry.Key), len(entry.Value), crc)
+
+		// the records of a batch follow it, under its offset and CRC
+		for _, entry := range entry.Batch {
+			fmt.Fprintf(w, "\t%d\t%s\t%s\t%d\t\n", entry.Seq, entry.Type, strconv.Quote(entry.Key), len(entry.Value))
+		}
+
+		return true
+	})
+	w.Flush()
+
+	fmt.Fprintf(out, "\n%d records, %d CRC mismatches\n", records, mismatches)
+	if err != nil {
+		return err
+	}
+
+	if mismatches > 0 {
+		return fmt.Errorf("%d records failed their CRC", mismatches)
+	}
+
+	return nil
+}
+
+func verifyWAL(out io.Writer, file string) error {
+	var records int
+	var corrupted error
+	err := wal.ReadRecords(file, func(offset int64, entry *wal.WALEntry, err error) bool {
+		if err != nil {
+			corrupted = err
+			return false
+		}
+
+		records++
+		return true
+	})
+	if err == nil {
+		err = corrupted
+	}
+
+	if err != nil {
+		fmt.Fprintf(out, "%d records ok before the first corruption\n", records)
+		return err
+	}
+
+	fmt.Fprintf(out, "%d records ok\n", records)
+	return nil
+}
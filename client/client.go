@@ -0,0 +1,425 @@
+// Package client is the Go client of the distrikv HTTP API.
+// In cluster mode requests are sent directly to the node owning the key.
+package client
+
+import (
+	"bytes"
+	"context"
+	"distrikv/api"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrNotFound error = errors.New("key not found")
+	ErrNoAddrs  error = errors.New("no node addresses")
+)
+
+// Options configure a Client, zero fields use the defaults.
+type Options struct {
+	// Timeout bounds every HTTP request.
+	Timeout time.Duration
+
+	// Retries is the number of retries of a failed request,
+	// Backoff the wait before the first retry, doubled after every retry.
+	Retries int
+	Backoff time.Duration
+
+	// MaxIdleConnsPerNode is the connection pool size of every node.
+	MaxIdleConnsPerNode int
+
+	// RefreshInterval is how often the cluster topology is reloaded.
+	RefreshInterval time.Duration
+
+	// Token is the API key or token sent as bearer credential,
+	// needed when the nodes run with authentication.
+	Token string
+}
+
+var DefaultOptions = Options{
+	Timeout:             10 * time.Second,
+	Retries:             3,
+	Backoff:             50 * time.Millisecond,
+	MaxIdleConnsPerNode: 32,
+	RefreshInterval:     30 * time.Second,
+}
+
+// Client sends requests to the nodes at addrs. It's safe for concurrent use.
+type Client struct {
+	addrs  []string
+	opts   Options
+	http   *http.Client
+	router *router
+
+	// rr picks the node of requests that can go to any node.
+	rr atomic.Uint64
+}
+
+// New creates a client of the nodes at addrs with the default options.
+func New(addrs ...string) (*Client, error) {
+	return NewWithOptions(DefaultOptions, addrs...)
+}
+
+func NewWithOptions(opts Options, addrs ...string) (*Client, error) {
+	if len(addrs) == 0 {
+		return nil, ErrNoAddrs
+	}
+
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultOptions.Timeout
+	}
+
+	if opts.Backoff == 0 {
+		opts.Backoff = DefaultOptions.Backoff
+	}
+
+	if opts.MaxIdleConnsPerNode == 0 {
+		opts.MaxIdleConnsPerNode = DefaultOptions.MaxIdleConnsPerNode
+	}
+
+	if opts.RefreshInterval == 0 {
+		opts.RefreshInterval = DefaultOptions.RefreshInterval
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerNode
+
+	c := &Client{
+		opts: opts,
+		http: &http.Client{
+			Timeout:   opts.Timeout,
+			Transport: transport,
+		},
+	}
+
+	for _, addr := range addrs {
+		c.addrs = append(c.addrs, strings.TrimRight(addr, "/"))
+	}
+
+	c.router = &router{client: c}
+
+	return c, nil
+}
+
+// Close closes the idle pooled connections.
+func (c *Client) Close() {
+	c.http.CloseIdleConnections()
+}
+
+// Get returns the value of key, ErrNotFound when it doesn't exist.
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	var res api.Item
+	if err := c.keyRequest(ctx, http.MethodGet, key, nil, &res); err != nil {
+		return "", err
+	}
+
+	return res.RawValue()
+}
+
+// MGet returns the values of the keys that exist, read
+// with a single request to every node owning some of the keys.
+func (c *Client) MGet(ctx context.Context, keys []string) (map[string]string, error) {
+	groups := make(map[string][]string)
+	for _, key := range keys {
+		addr := c.router.route(ctx, key)
+		groups[addr] = append(groups[addr], key)
+	}
+
+	var mu sync.Mutex
+	res := make(map[string]string, len(keys))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(groups))
+
+	for addr, group := range groups {
+		wg.Add(1)
+		go func(addr string, group []string) {
+			defer wg.Done()
+
+			items, err := c.mget(ctx, addr, group)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			// items follow the requested keys
+			for i, item := range items {
+				if item == nil {
+					continue
+				}
+
+				value, err := item.RawValue()
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				res[group[i]] = value
+			}
+		}(addr, group)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *Client) mget(ctx context.Context, addr string, keys []string) ([]*api.Item, error) {
+	body, err := json.Marshal(struct {
+		Keys []string `json:"keys"`
+	}{keys})
+	if err != nil {
+		return nil, err
+	}
+
+	var res api.ItemList
+
+	err = c.retry(ctx, func() error {
+		err := c.do(ctx, addr, http.MethodPost, "/v1/mget", body, "application/json", &res)
+
+		var apiErr *Error
+		if errors.As(err, &apiErr) && apiErr.Code == api.CodeWrongNode && apiErr.Addr == "" {
+			c.router.invalidate()
+		}
+
+		return err
+	})
+
+	return res.Items, err
+}
+
+func (c *Client) Set(ctx context.Context, key string, value string) error {
+	return c.keyRequest(ctx, http.MethodPut, key, []byte(value), nil)
+}
+
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.keyRequest(ctx, http.MethodDelete, key, nil, nil)
+}
+
+// Op is a write of a Batch, Delete ignores Value.
+type Op struct {
+	Key    string
+	Value  string
+	Delete bool
+}
+
+// Batch applies ops concurrently, grouped by the node owning their key.
+// The batch isn't atomic, the ops of a key are applied in order
+// and the first error is returned once every op ran.
+func (c *Client) Batch(ctx context.Context, ops []Op) error {
+	groups := make(map[string][]Op)
+	for _, op := range ops {
+		addr := c.router.route(ctx, op.Key)
+		groups[addr] = append(groups[addr], op)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(groups))
+
+	for _, group := range groups {
+		wg.Add(1)
+		go func(group []Op) {
+			defer wg.Done()
+
+			for _, op := range group {
+				var err error
+				if op.Delete {
+					err = c.Delete(ctx, op.Key)
+				} else {
+					err = c.Set(ctx, op.Key, op.Value)
+				}
+
+				if err != nil {
+					errs <- fmt.Errorf("%s: %w", op.Key, err)
+					return
+				}
+			}
+		}(group)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
+
+func (c *Client) keyRequest(ctx context.Context, method string, key string, body []byte, res any) error {
+	path := "/v1/keys/" + url.PathEscape(key)
+
+	return c.retry(ctx, func() error {
+		addr := c.router.route(ctx, key)
+
+		err := c.do(ctx, addr, method, path, body, "text/plain", res)
+
+		// the topology changed, the next attempt goes to the
+		// node named by the error or reloads the topology
+		var apiErr *Error
+		if errors.As(err, &apiErr) && apiErr.Code == api.CodeWrongNode && apiErr.Addr == "" {
+			c.router.invalidate()
+		}
+
+		return err
+	})
+}
+
+// retry runs fn until it succeeds, fails with an error that isn't
+// retryable, or the retries are exhausted.
+func (c *Client) retry(ctx context.Context, fn func() error) error {
+	backoff := c.opts.Backoff
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = fn()
+		if err == nil || !retryable(err) || attempt >= c.opts.Retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+// Error is an error response of a node.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+
+	// Addr is the node serving the key of a wrong_node error, if known.
+	Addr string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func retryable(err error) bool {
+	if errors.Is(err, ErrNotFound) {
+		return false
+	}
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		// connection errors
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+
+	switch apiErr.Status {
+	case http.StatusMisdirectedRequest, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		// read-only stores don't recover by retrying
+		return apiErr.Code != api.CodeReadOnly
+	default:
+		return false
+	}
+}
+
+// do sends a request to the node at addr and decodes
+// the JSON response into res when it isn't nil.
+func (c *Client) do(ctx context.Context, addr string, method string, path string, body []byte, contentType string, res any) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, addr+path, reader)
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	// the versioned schema keeps binary values intact
+	req.Header.Set("Accept", api.SchemaV1)
+
+	if c.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.opts.Token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// the key was served by or misrouted to another node
+	movedAddr := c.router.moved(resp.Header.Get(api.MovedHeader))
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &Error{Status: resp.StatusCode, Code: api.CodeInternal, Message: resp.Status, Addr: movedAddr}
+
+		var e api.ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err == nil && e.Code != "" {
+			apiErr.Code, apiErr.Message = e.Code, e.Message
+		}
+
+		return apiErr
+	}
+
+	if res == nil {
+		_, err := io.Copy(io.Discard, resp.Body)
+		return err
+	}
+
+	// admin routes answer plain JSON
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), api.SchemaV1) {
+		res = &api.Envelope{Data: res}
+	}
+
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+// next returns the next configured address in round robin.
+func (c *Client) next() string {
+	return c.addrs[(c.rr.Add(1)-1)%uint64(len(c.addrs))]
+}
+
+// scanLimit is the page size of scans.
+const scanLimit = 1000
+
+func (c *Client) scanPage(ctx context.Context, addr string, start string, end string, cursor string) (*api.ItemList, error) {
+	query := url.Values{}
+	query.Set("start", start)
+	query.Set("limit", strconv.Itoa(scanLimit))
+	if end != "" {
+		query.Set("end", end)
+	}
+
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+
+	var page api.ItemList
+	err := c.retry(ctx, func() error {
+		return c.do(ctx, addr, http.MethodGet, "/v1/scan?"+query.Encode(), nil, "", &page)
+	})
+
+	return &page, err
+}
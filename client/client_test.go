@@ -0,0 +1,190 @@
+package client
+
+import (
+	"context"
+	"distrikv/api"
+	"distrikv/cluster"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNode serves the key and scan routes from a map.
+type fakeNode struct {
+	mu       sync.Mutex
+	data     map[string]string
+	failures int
+}
+
+func (n *fakeNode) handler(ring func() cluster.Status) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/ring", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ring())
+	})
+	mux.HandleFunc("/v1/keys/{key}", func(w http.ResponseWriter, r *http.Request) {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		if n.failures > 0 {
+			n.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		key := r.PathValue("key")
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			n.data[key] = string(body)
+		case http.MethodGet:
+			value, ok := n.data[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			writeV1(w, fakeItem(key, value))
+		}
+	})
+	mux.HandleFunc("GET /v1/scan", func(w http.ResponseWriter, r *http.Request) {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		var keys []string
+		for key := range n.data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		page := api.ItemList{}
+		for _, key := range keys {
+			page.Items = append(page.Items, fakeItem(key, n.data[key]))
+		}
+
+		writeV1(w, page)
+	})
+
+	return mux
+}
+
+func fakeItem(key string, value string) *api.Item {
+	item := &api.Item{Key: key, Value: value, Encoding: api.ENCODING_UTF8}
+	if !utf8.ValidString(value) {
+		item.Value = base64.StdEncoding.EncodeToString([]byte(value))
+		item.Encoding = api.ENCODING_BASE64
+	}
+
+	return item
+}
+
+func writeV1(w http.ResponseWriter, data any) {
+	w.Header().Set("Content-Type", api.SchemaV1)
+	json.NewEncoder(w).Encode(api.Envelope{Version: 1, Data: data})
+}
+
+func TestClientRoutesToOwner(t *testing.T) {
+	a := &fakeNode{data: make(map[string]string), failures: 1}
+	b := &fakeNode{data: make(map[string]string)}
+
+	var status cluster.Status
+	ring := func() cluster.Status { return status }
+
+	serverA := httptest.NewServer(a.handler(ring))
+	defer serverA.Close()
+	serverB := httptest.NewServer(b.handler(ring))
+	defer serverB.Close()
+
+	status = cluster.Status{
+		Nodes:  []cluster.Node{{ID: "a", Addr: serverA.URL}, {ID: "b", Addr: serverB.URL}},
+		Vnodes: 64,
+	}
+	for shard := range 4 {
+		owner := "a"
+		if shard >= 2 {
+			owner = "b"
+		}
+
+		status.Shards = append(status.Shards, cluster.ShardStatus{ID: shard, Owner: owner})
+	}
+
+	c, err := New(serverA.URL)
+	require.NoError(t, err)
+	defer c.Close()
+
+	ctx := context.Background()
+	for i := range 20 {
+		require.NoError(t, c.Set(ctx, fmt.Sprintf("key-%02d", i), fmt.Sprintf("value-%d", i)))
+	}
+
+	r := cluster.NewRing(4, 64)
+	for i := range 20 {
+		key := fmt.Sprintf("key-%02d", i)
+
+		owner, other := a, b
+		if r.Shard(key) >= 2 {
+			owner, other = b, a
+		}
+
+		assert.Equal(t, fmt.Sprintf("value-%d", i), owner.data[key])
+		assert.NotContains(t, other.data, key)
+
+		value, err := c.Get(ctx, key)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), value)
+	}
+
+	_, err = c.Get(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// binary values are sent raw and read base64 encoded
+	binary := "\xff\x00\nbinary"
+	require.NoError(t, c.Set(ctx, "key-binary", binary))
+	value, err := c.Get(ctx, "key-binary")
+	require.NoError(t, err)
+	assert.Equal(t, binary, value)
+
+	var keys []string
+	require.NoError(t, c.Scan(ctx, "", "", func(key string, value string) bool {
+		keys = append(keys, key)
+		return true
+	}))
+
+	assert.Len(t, keys, 21)
+	assert.True(t, sort.StringsAreSorted(keys))
+	assert.True(t, strings.HasPrefix(keys[0], "key-00"))
+}
+
+func TestRouterMoved(t *testing.T) {
+	c, err := New("http://localhost:1")
+	require.NoError(t, err)
+	defer c.Close()
+
+	c.router.topo = &topology{
+		ring:   cluster.NewRing(2, 64),
+		owners: []string{"http://a", "http://b"},
+		nodes:  []string{"http://a", "http://b"},
+	}
+	c.router.fetchedAt = time.Now()
+	before := c.router.topo
+
+	assert.Equal(t, "http://c", c.router.moved("1 http://c"))
+	assert.Equal(t, []string{"http://a", "http://c"}, c.router.topo.owners)
+	assert.Equal(t, []string{"http://a", "http://b"}, before.owners)
+
+	assert.Empty(t, c.router.moved(""))
+	assert.Empty(t, c.router.moved("x http://d"))
+	assert.Equal(t, "http://d", c.router.moved("7 http://d"))
+	assert.Equal(t, []string{"http://a", "http://c"}, c.router.topo.owners)
+}
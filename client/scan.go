@@ -0,0 +1,85 @@
+package client
+
+import (
+	"context"
+	"distrikv/api"
+)
+
+// nodeScan pages through the scan of a single node.
+type nodeScan struct {
+	addr   string
+	page   *api.ItemList
+	pos    int
+	cursor string
+	done   bool
+}
+
+func (s *nodeScan) valid() bool {
+	return s.page != nil && s.pos < len(s.page.Items)
+}
+
+func (s *nodeScan) key() string {
+	return s.page.Items[s.pos].Key
+}
+
+// fill loads the next page once the current one is consumed.
+func (s *nodeScan) fill(ctx context.Context, c *Client, start string, end string) error {
+	for !s.valid() && !s.done {
+		page, err := c.scanPage(ctx, s.addr, start, end, s.cursor)
+		if err != nil {
+			return err
+		}
+
+		s.page, s.pos = page, 0
+		s.cursor = page.NextCursor
+		s.done = page.NextCursor == ""
+	}
+
+	return nil
+}
+
+// Scan calls fn for every key in [start, end) in bytewise order
+// until fn returns false, an empty end scans to the last key.
+// Every node is scanned, as nodes only scan the shards they own.
+func (c *Client) Scan(ctx context.Context, start string, end string, fn func(key string, value string) bool) error {
+	var scans []*nodeScan
+	for _, addr := range c.router.nodes(ctx) {
+		scans = append(scans, &nodeScan{addr: addr})
+	}
+
+	var last *string
+	for {
+		var next *nodeScan
+		for _, s := range scans {
+			if err := s.fill(ctx, c, start, end); err != nil {
+				return err
+			}
+
+			if s.valid() && (next == nil || s.key() < next.key()) {
+				next = s
+			}
+		}
+
+		if next == nil {
+			return nil
+		}
+
+		item := next.page.Items[next.pos]
+		next.pos++
+
+		// a shard being moved is served by both of its nodes
+		if last != nil && item.Key == *last {
+			continue
+		}
+		last = &item.Key
+
+		value, err := item.RawValue()
+		if err != nil {
+			return err
+		}
+
+		if !fn(item.Key, value) {
+			return nil
+		}
+	}
+}
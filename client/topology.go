@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"distrikv/cluster"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// topology maps keys to the address of the node owning them.
+type topology struct {
+	ring *cluster.Ring
+
+	// owners holds the address of the owner of every shard.
+	owners []string
+
+	// nodes holds the address of every node.
+	nodes []string
+}
+
+func (t *topology) owner(key string) string {
+	return t.owners[t.ring.Shard(key)]
+}
+
+// router keeps the topology of the cluster, refreshed from the ring
+// of any reachable node when it's stale or a node owns no longer a key.
+type router struct {
+	client *Client
+
+	mu        sync.Mutex
+	topo      *topology
+	fetchedAt time.Time
+}
+
+// route returns the address serving key, any node
+// when the topology can't be loaded.
+func (r *router) route(ctx context.Context, key string) string {
+	topo := r.topology(ctx)
+	if topo == nil {
+		return r.client.next()
+	}
+
+	return topo.owner(key)
+}
+
+// nodes returns the address of every node of the cluster.
+func (r *router) nodes(ctx context.Context) []string {
+	topo := r.topology(ctx)
+	if topo == nil {
+		return []string{r.client.next()}
+	}
+
+	return topo.nodes
+}
+
+// invalidate forces the next route to reload the topology.
+func (r *router) invalidate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fetchedAt = time.Time{}
+}
+
+// moved updates the owner of a shard from the moved header of a
+// response and returns the address, empty if the header is missing.
+func (r *router) moved(header string) string {
+	shardField, addr, ok := strings.Cut(header, " ")
+	if !ok {
+		return ""
+	}
+
+	shard, err := strconv.Atoi(shardField)
+	if err != nil {
+		return ""
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.topo == nil || shard < 0 || shard >= len(r.topo.owners) {
+		return addr
+	}
+
+	// the topology is shared with running requests
+	owners := slices.Clone(r.topo.owners)
+	owners[shard] = addr
+	r.topo = &topology{
+		ring:   r.topo.ring,
+		owners: owners,
+		nodes:  r.topo.nodes,
+	}
+
+	return addr
+}
+
+func (r *router) topology(ctx context.Context) *topology {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.topo != nil && time.Since(r.fetchedAt) < r.client.opts.RefreshInterval {
+		return r.topo
+	}
+
+	for range r.client.addrs {
+		addr := r.client.next()
+
+		topo, err := r.fetch(ctx, addr)
+		if err != nil {
+			continue
+		}
+
+		r.topo = topo
+		r.fetchedAt = time.Now()
+		break
+	}
+
+	// a stale topology beats none
+	return r.topo
+}
+
+func (r *router) fetch(ctx context.Context, addr string) (*topology, error) {
+	var status cluster.Status
+	if err := r.client.do(ctx, addr, http.MethodGet, "/admin/ring", nil, "", &status); err != nil {
+		return nil, err
+	}
+
+	if len(status.Shards) == 0 {
+		return nil, fmt.Errorf("node %s has no shards", addr)
+	}
+
+	// nodes without an advertised address are reached
+	// at the address the ring was read from
+	addrs := make(map[string]string)
+	topo := &topology{
+		ring: cluster.NewRing(len(status.Shards), status.Vnodes),
+	}
+
+	for _, node := range status.Nodes {
+		nodeAddr := node.Addr
+		if nodeAddr == "" {
+			nodeAddr = addr
+		}
+
+		addrs[node.ID] = nodeAddr
+		topo.nodes = append(topo.nodes, nodeAddr)
+	}
+
+	for _, shard := range status.Shards {
+		owner, ok := addrs[shard.Owner]
+		if !ok {
+			return nil, fmt.Errorf("shard %d is owned by unknown node %s", shard.ID, shard.Owner)
+		}
+
+		topo.owners = append(topo.owners, owner)
+	}
+
+	return topo, nil
+}
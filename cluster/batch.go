@@ -0,0 +1,98 @@
+package cluster
+
+import (
+	"context"
+	"distrikv/storage"
+)
+
+// Write applies ops to the local shards owning their keys, every op
+// is checked on its shard before any is applied. The ops of a shard are
+// applied together, a batch spanning several shards isn't atomic across
+// them: a shard failing after the check, on a disk error, keeps the ops
+// applied to the shards before it. Ranges are deleted on every local shard.
+func (c *Cluster) Write(ops []storage.BatchOp) error {
+	c.writeMu.RLock()
+	defer c.writeMu.RUnlock()
+
+	var order []*storage.Store
+	batches := make(map[*storage.Store][]storage.BatchOp)
+	add := func(store *storage.Store, op storage.BatchOp) {
+		if _, ok := batches[store]; !ok {
+			order = append(order, store)
+		}
+		batches[store] = append(batches[store], op)
+	}
+
+	for _, op := range ops {
+		if op.Type != storage.BATCH_DELETE_RANGE {
+			store, err := c.writeStore(op.Key)
+			if err != nil {
+				return err
+			}
+
+			add(store, op)
+			continue
+		}
+
+		for shard, store := range c.localShards() {
+			if c.frozen(shard) {
+				return storage.ErrReadOnly
+			}
+
+			add(store, op)
+		}
+	}
+
+	for _, store := range order {
+		if err := store.CheckWrite(batches[store]); err != nil {
+			return err
+		}
+	}
+
+	for _, store := range order {
+		if err := store.Write(batches[store]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MGet looks keys up in the local shards owning them, in one
+// lookup per shard. ErrNotOwner is returned if another node owns any key.
+func (c *Cluster) MGet(ctx context.Context, keys []string) ([]*storage.KVData, error) {
+	var order []*storage.Store
+	groups := make(map[*storage.Store][]int)
+	for i, key := range keys {
+		store, err := c.store(key)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := groups[store]; !ok {
+			order = append(order, store)
+		}
+		groups[store] = append(groups[store], i)
+	}
+
+	res := make([]*storage.KVData, len(keys))
+	for _, store := range order {
+		idx := groups[store]
+
+		shardKeys := make([]string, len(idx))
+		for j, i := range idx {
+			shardKeys[j] = keys[i]
+		}
+
+		data, err := store.MGet(ctx, shardKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		for j, i := range idx {
+			res[i] = data[j]
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,44 @@
+package cluster
+
+import (
+	"context"
+	"distrikv/config"
+	"distrikv/storage"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteChecksEveryShardFirst(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	open := func(dir string) (*storage.Store, error) {
+		return storage.Open(context.Background(), logger, dir, storage.OPEN_FAST, nil, nil, nil)
+	}
+
+	c, err := New(logger, config.Config{NodeID: "a", DataDir: t.TempDir(), Shards: 4, ShardVnodes: 64}, []Node{{ID: "a"}}, open)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Shutdown(context.Background()) })
+
+	// the keys span the shards, the last one is refused by its shard
+	var ops []storage.BatchOp
+	for i := range 10 {
+		ops = append(ops, storage.BatchOp{Type: storage.BATCH_PUT, Key: fmt.Sprintf("key-%d", i), Value: "1"})
+	}
+	ops = append(ops, storage.BatchOp{Type: storage.BATCH_PUT, Key: strings.Repeat("k", storage.MaxKeySize+1), Value: "1"})
+
+	assert.ErrorIs(t, c.Write(ops), storage.ErrKeyTooLarge)
+
+	for _, op := range ops[:10] {
+		_, err := c.Get(context.Background(), op.Key)
+		assert.ErrorIs(t, err, storage.ErrKeyNotFound, op.Key)
+	}
+
+	require.NoError(t, c.Write(ops[:10]))
+	data, err := c.Get(context.Background(), "key-0")
+	require.NoError(t, err)
+	assert.Equal(t, "1", data.Value)
+}
@@ -0,0 +1,44 @@
+package cluster
+
+import (
+	"context"
+	"distrikv/storage"
+	"fmt"
+	"os"
+)
+
+// Checkpoint checkpoints every local shard into its shard directory
+// under dir, so dir can be used as the data directory of a node.
+// The placement is copied along. Writes must be paused by the caller.
+func (c *Cluster) Checkpoint(ctx context.Context, dir string) (map[int]*storage.Checkpoint, error) {
+	// a single shard is checkpointed straight into dir, which is
+	// checked upfront so a failed checkpoint removes only its own files
+	if _, err := os.Lstat(dir); err == nil {
+		return nil, &os.PathError{Op: "checkpoint", Path: dir, Err: os.ErrExist}
+	}
+
+	shards := c.ring.Shards()
+	if shards > 1 {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	res := make(map[int]*storage.Checkpoint)
+	for shard, store := range c.localShards() {
+		checkpoint, err := store.Checkpoint(ctx, ShardDir(dir, shards, shard))
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("checkpoint shard %d: %w", shard, err)
+		}
+
+		res[shard] = checkpoint
+	}
+
+	if err := savePlacement(dir, c.Placement()); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,631 @@
+package cluster
+
+import (
+	"context"
+	"distrikv/config"
+	"distrikv/ops"
+	"distrikv/pkg"
+	"distrikv/storage"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrNotOwner error = errors.New("key is owned by another node")
+
+// OpenFunc opens the store of a shard kept in dir.
+type OpenFunc func(dir string) (*storage.Store, error)
+
+// Cluster routes keys to the shards of the cluster.
+// Shards owned by this node are opened locally, requests
+// for other shards are forwarded by the API to their owner.
+type Cluster struct {
+	logger  *slog.Logger
+	open    OpenFunc
+	dataDir string
+
+	self Node
+	ring *Ring
+
+	// mu guards the membership of the cluster,
+	// which changes when nodes join or leave.
+	mu    sync.RWMutex
+	nodes []Node
+
+	// owners holds the owner node of every shard.
+	owners []Node
+
+	// placement overrides the owners computed from the ring.
+	placement Placement
+
+	// shards holds the stores opened by this node. A shard moved
+	// to another node is closed once the new owner pulled it.
+	shards map[int]*storage.Store
+
+	// movingIn holds the shards this node pulls from their previous
+	// owner, requests for them are forwarded to it until they're pulled.
+	movingIn map[int]Node
+
+	// movingOut holds the shards this node lost but keeps
+	// serving until their new owner pulled them.
+	movingOut map[int]*outgoing
+
+	// moves is the history of shard moves, newest last.
+	moves []*Move
+
+	// writeMu is held by writes, and exclusively
+	// to pause them while a shard is handed off.
+	writeMu sync.RWMutex
+
+	client *http.Client
+
+	// cmp orders the keys of the shards.
+	cmp storage.Comparator
+
+	generation string
+
+	// operations runs the shard moves and manual compactions.
+	operations *ops.Registry
+
+	// sequencer numbers the writes of the shards, see SetSequencer.
+	sequencer storage.Sequencer
+}
+
+// New creates the cluster with nodes as its initial members,
+// the configured cluster nodes are used when nodes is nil.
+func New(logger *slog.Logger, cfg config.Config, nodes []Node, open OpenFunc) (*Cluster, error) {
+	if cfg.Shards < 1 {
+		return nil, fmt.Errorf("invalid shard count %d", cfg.Shards)
+	}
+
+	if nodes == nil {
+		var err error
+		nodes, err = ParseNodes(cfg.ClusterNodes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	self := Node{ID: cfg.NodeID, Addr: cfg.AdvertiseURL}
+
+	// without configured nodes this node owns every shard,
+	// gossip adds the other members as they are discovered.
+	if len(nodes) == 0 {
+		nodes = []Node{self}
+	}
+
+	found := false
+	for _, node := range nodes {
+		if node.ID == self.ID {
+			self = node
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("node %q is not part of the cluster nodes", self.ID)
+	}
+
+	cmp, err := storage.ParseComparator(cfg.Comparator)
+	if err != nil {
+		return nil, err
+	}
+
+	placement, err := loadPlacement(cfg.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("load placement: %w", err)
+	}
+
+	generation, err := loadGeneration(cfg.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("load generation: %w", err)
+	}
+
+	c := &Cluster{
+		logger:  logger,
+		open:    open,
+		dataDir: cfg.DataDir,
+		self:    self,
+		ring:    NewRing(cfg.Shards, cfg.ShardVnodes),
+		shards:  make(map[int]*storage.Store),
+
+		placement: placement,
+		movingIn:  make(map[int]Node),
+		movingOut: make(map[int]*outgoing),
+		client:    &http.Client{Transport: pkg.PeerTransport()},
+		cmp:       cmp,
+
+		generation: generation,
+		operations: ops.NewRegistry(logger),
+	}
+
+	if err := c.SetNodes(nodes); err != nil {
+		return nil, err
+	}
+
+	logger.Info("joined cluster", "node", self.ID, "generation", generation, "nodes", len(nodes), "local_shards", len(c.localShards()))
+
+	return c, nil
+}
+
+// SetNodes replaces the members of the cluster and reassigns the
+// shards. Shards this node gains are pulled from their previous owner,
+// shards it loses are served until the new owner pulled them.
+func (c *Cluster) SetNodes(nodes []Node) error {
+	if !slices.ContainsFunc(nodes, func(n Node) bool { return n.ID == c.self.ID }) {
+		nodes = append(slices.Clone(nodes), c.self)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.assign(nodes)
+}
+
+// assign computes the owners of the shards among nodes.
+// Must be called with mu held.
+func (c *Cluster) assign(nodes []Node) error {
+	shardCount := c.ring.Shards()
+	prev := c.owners
+
+	var owners []Node
+	for shard := range shardCount {
+		owner := c.placement.owner(shard, nodes)
+		owners = append(owners, owner)
+
+		wasOwner := len(prev) == shardCount && prev[shard].ID == c.self.ID
+		if len(prev) == shardCount && prev[shard].ID != owner.ID {
+			c.logger.Info("shard moved", "shard", shard, "from", prev[shard].ID, "to", owner.ID)
+		}
+
+		if owner.ID != c.self.ID {
+			// a move in is abandoned once the shard moves on
+			delete(c.movingIn, shard)
+
+			_, retained := c.movingOut[shard]
+			if wasOwner && !retained && c.shards[shard] != nil {
+				c.movingOut[shard] = &outgoing{
+					to:       owner,
+					deadline: time.Now().Add(MoveTimeout),
+				}
+			}
+
+			continue
+		}
+
+		delete(c.movingOut, shard)
+
+		if _, ok := c.shards[shard]; ok {
+			continue
+		}
+
+		if _, ok := c.movingIn[shard]; ok {
+			continue
+		}
+
+		if source, ok := c.previousOwner(shard, prev, nodes); ok {
+			c.movingIn[shard] = source
+			c.operations.Start("shard_move", fmt.Sprintf("pull shard %d from %s", shard, source.ID), func(ctx context.Context, p *ops.Progress) error {
+				return c.moveIn(ctx, shard, source, p)
+			})
+			continue
+		}
+
+		store, err := c.openShard(ShardDir(c.dataDir, shardCount, shard))
+		if err != nil {
+			return fmt.Errorf("open shard %d: %w", shard, err)
+		}
+
+		c.shards[shard] = store
+	}
+
+	c.nodes = nodes
+	c.owners = owners
+
+	return nil
+}
+
+// openShard opens the store of a shard kept in dir,
+// numbered by the sequencer of the cluster.
+// Must be called with mu held.
+func (c *Cluster) openShard(dir string) (*storage.Store, error) {
+	store, err := c.open(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.sequencer != nil {
+		store.SetSequencer(c.sequencer)
+	}
+
+	return store, nil
+}
+
+// ShardDir returns the LSM directory of a shard,
+// a single shard is kept directly in the data directory.
+func ShardDir(dataDir string, shards int, shard int) string {
+	if shards == 1 {
+		return dataDir
+	}
+
+	return filepath.Join(dataDir, fmt.Sprintf("shard-%03d", shard))
+}
+
+// Owner returns the node owning key and whether it is this node.
+func (c *Cluster) Owner(key string) (Node, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.route(c.ring.Shard(key))
+}
+
+// route returns the node serving shard and whether it is this node,
+// shards being moved are served by their previous owner.
+// Must be called with mu held.
+func (c *Cluster) route(shard int) (Node, bool) {
+	if source, ok := c.movingIn[shard]; ok {
+		return source, false
+	}
+
+	if out, ok := c.movingOut[shard]; ok && out.active() {
+		return c.self, true
+	}
+
+	owner := c.owners[shard]
+
+	return owner, owner.ID == c.self.ID
+}
+
+// store returns the local store of the shard owning key.
+func (c *Cluster) store(key string) (*storage.Store, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	shard := c.ring.Shard(key)
+	if node, local := c.route(shard); !local {
+		return nil, &MovedError{Key: key, Shard: shard, Node: node}
+	}
+
+	return c.shards[shard], nil
+}
+
+// writeStore returns the local store of the shard owning key,
+// shards frozen for a handoff are read-only.
+// Must be called with writeMu held.
+func (c *Cluster) writeStore(key string) (*storage.Store, error) {
+	store, err := c.store(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.frozen(c.ring.Shard(key)) {
+		return nil, storage.ErrReadOnly
+	}
+
+	return store, nil
+}
+
+// frozen reports whether shard is frozen for a handoff.
+func (c *Cluster) frozen(shard int) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	out, ok := c.movingOut[shard]
+	return ok && out.frozen
+}
+
+// localShards returns the stores of the shards owned by this node.
+func (c *Cluster) localShards() map[int]*storage.Store {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	res := make(map[int]*storage.Store)
+	for shard := range c.owners {
+		if _, local := c.route(shard); local {
+			res[shard] = c.shards[shard]
+		}
+	}
+
+	return res
+}
+
+func (c *Cluster) Get(ctx context.Context, key string) (*storage.KVData, error) {
+	store, err := c.store(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.Get(ctx, key)
+}
+
+// GetAt returns the version of key visible at t, see storage.LSM.GetAt.
+func (c *Cluster) GetAt(ctx context.Context, key string, t time.Time) (*storage.KVData, error) {
+	store, err := c.store(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.GetAt(ctx, key, t)
+}
+
+// Versions returns the versions of key retained, see storage.LSM.Versions.
+func (c *Cluster) Versions(ctx context.Context, key string) ([]storage.Version, error) {
+	store, err := c.store(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.Versions(ctx, key)
+}
+
+func (c *Cluster) GetValue(ctx context.Context, key string) (*storage.ValueReader, error) {
+	store, err := c.store(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.GetValue(ctx, key)
+}
+
+func (c *Cluster) Set(key string, value string) error {
+	c.writeMu.RLock()
+	defer c.writeMu.RUnlock()
+
+	store, err := c.writeStore(key)
+	if err != nil {
+		return err
+	}
+
+	return store.Set(key, value)
+}
+
+func (c *Cluster) SetWithTTL(key string, value string, ttl time.Duration) error {
+	c.writeMu.RLock()
+	defer c.writeMu.RUnlock()
+
+	store, err := c.writeStore(key)
+	if err != nil {
+		return err
+	}
+
+	return store.SetWithTTL(key, value, ttl)
+}
+
+func (c *Cluster) SetWithMode(key string, value string, ttl time.Duration, mode storage.SetMode) error {
+	c.writeMu.RLock()
+	defer c.writeMu.RUnlock()
+
+	store, err := c.writeStore(key)
+	if err != nil {
+		return err
+	}
+
+	return store.SetWithMode(key, value, ttl, mode)
+}
+
+func (c *Cluster) Incr(key string, delta int64) (*storage.KVData, error) {
+	c.writeMu.RLock()
+	defer c.writeMu.RUnlock()
+
+	store, err := c.writeStore(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.Incr(key, delta)
+}
+
+func (c *Cluster) GetDel(key string) (*storage.KVData, error) {
+	c.writeMu.RLock()
+	defer c.writeMu.RUnlock()
+
+	store, err := c.writeStore(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.GetDel(key)
+}
+
+func (c *Cluster) GetSet(key string, value string) (*storage.KVData, error) {
+	c.writeMu.RLock()
+	defer c.writeMu.RUnlock()
+
+	store, err := c.writeStore(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.GetSet(key, value)
+}
+
+func (c *Cluster) Append(key string, suffix string) (*storage.KVData, error) {
+	c.writeMu.RLock()
+	defer c.writeMu.RUnlock()
+
+	store, err := c.writeStore(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.Append(key, suffix)
+}
+
+func (c *Cluster) AcquireLease(key string, owner string, ttl time.Duration) (*storage.KVData, error) {
+	c.writeMu.RLock()
+	defer c.writeMu.RUnlock()
+
+	store, err := c.writeStore(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.AcquireLease(key, owner, ttl)
+}
+
+func (c *Cluster) RenewLease(key string, owner string, ttl time.Duration) (*storage.KVData, error) {
+	c.writeMu.RLock()
+	defer c.writeMu.RUnlock()
+
+	store, err := c.writeStore(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.RenewLease(key, owner, ttl)
+}
+
+func (c *Cluster) ReleaseLease(key string, owner string) error {
+	c.writeMu.RLock()
+	defer c.writeMu.RUnlock()
+
+	store, err := c.writeStore(key)
+	if err != nil {
+		return err
+	}
+
+	return store.ReleaseLease(key, owner)
+}
+
+func (c *Cluster) Delete(key string) error {
+	c.writeMu.RLock()
+	defer c.writeMu.RUnlock()
+
+	store, err := c.writeStore(key)
+	if err != nil {
+		return err
+	}
+
+	return store.Delete(key)
+}
+
+type ShardStatus struct {
+	ID    int    `json:"id"`
+	Owner string `json:"owner"`
+	Local bool   `json:"local"`
+}
+
+// Status is the view of the ring from this node.
+type Status struct {
+	Self   Node          `json:"self"`
+	Nodes  []Node        `json:"nodes"`
+	Vnodes int           `json:"vnodes"`
+	Shards []ShardStatus `json:"shards"`
+}
+
+func (c *Cluster) Status() Status {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	status := Status{
+		Self:   c.self,
+		Nodes:  c.nodes,
+		Vnodes: c.ring.vnodes,
+	}
+
+	for shard, owner := range c.owners {
+		_, local := c.route(shard)
+		status.Shards = append(status.Shards, ShardStatus{
+			ID:    shard,
+			Owner: owner.ID,
+			Local: local,
+		})
+	}
+
+	return status
+}
+
+// Snapshot takes a snapshot of every local shard.
+func (c *Cluster) Snapshot() (map[int]*storage.Snapshot, error) {
+	res := make(map[int]*storage.Snapshot)
+	for shard, store := range c.localShards() {
+		snapshot, err := store.Snapshot()
+		if err != nil {
+			for _, s := range res {
+				s.Close()
+			}
+
+			return nil, fmt.Errorf("snapshot shard %d: %w", shard, err)
+		}
+
+		res[shard] = snapshot
+	}
+
+	return res, nil
+}
+
+// Levels returns the level structure of every local shard.
+func (c *Cluster) Levels() map[int][]storage.LevelStats {
+	res := make(map[int][]storage.LevelStats)
+	for shard, store := range c.localShards() {
+		res[shard] = store.Levels()
+	}
+
+	return res
+}
+
+// CompactionPlan returns the next compaction of every level of every local shard.
+func (c *Cluster) CompactionPlan() map[int][]storage.CompactionPlan {
+	res := make(map[int][]storage.CompactionPlan)
+	for shard, store := range c.localShards() {
+		res[shard] = store.CompactionPlan()
+	}
+
+	return res
+}
+
+// Stats returns a snapshot of the state of every local shard.
+func (c *Cluster) Stats() (map[int]storage.Stats, error) {
+	res := make(map[int]storage.Stats)
+	for shard, store := range c.localShards() {
+		stats, err := store.Stats()
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %w", shard, err)
+		}
+
+		res[shard] = stats
+	}
+
+	return res, nil
+}
+
+// Caches returns the cache counters of every local shard.
+func (c *Cluster) Caches() map[int]storage.CacheStats {
+	res := make(map[int]storage.CacheStats)
+	for shard, store := range c.localShards() {
+		res[shard] = store.Caches()
+	}
+
+	return res
+}
+
+// Health is the disk health of the local shards, the node is
+// unhealthy when any shard is and not ready when any shard
+// refuses writes, read-only or out of disk space.
+type Health struct {
+	Healthy bool                   `json:"healthy"`
+	Ready   bool                   `json:"ready"`
+	Shards  map[int]storage.Health `json:"shards"`
+}
+
+func (c *Cluster) Health() Health {
+	health := Health{
+		Healthy: true,
+		Ready:   true,
+		Shards:  make(map[int]storage.Health),
+	}
+
+	for shard, store := range c.localShards() {
+		shardHealth := store.Health()
+		health.Shards[shard] = shardHealth
+		health.Healthy = health.Healthy && shardHealth.Healthy
+		health.Ready = health.Ready && shardHealth.Writable()
+	}
+
+	return health
+}
@@ -0,0 +1,55 @@
+package cluster
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const generationFile = "GENERATION"
+
+// loadGeneration returns the generation of the data directory.
+// A directory without one, e.g. a new or replaced one, gets a new generation.
+func loadGeneration(dataDir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, generationFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return NewGeneration(dataDir)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// NewGeneration replaces the generation of the data directory,
+// once its data was restored and its history rewritten.
+func NewGeneration(dataDir string) (string, error) {
+	generation := uuid.NewString()
+
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return "", err
+	}
+
+	tmp := filepath.Join(dataDir, generationFile+".tmp")
+	if err := os.WriteFile(tmp, []byte(generation+"\n"), 0644); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmp, filepath.Join(dataDir, generationFile)); err != nil {
+		return "", err
+	}
+
+	return generation, nil
+}
+
+// Generation identifies the history of the data of this node. It changes
+// when the data directory is replaced or restored, cursors and snapshots
+// taken before are then no longer valid.
+func (c *Cluster) Generation() string {
+	return c.generation
+}
@@ -0,0 +1,46 @@
+package cluster
+
+import (
+	"context"
+	"distrikv/config"
+	"distrikv/storage"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneration(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	open := func(dir string) (*storage.Store, error) {
+		return storage.Open(context.Background(), logger, dir, storage.OPEN_FAST, nil, nil, nil)
+	}
+
+	newCluster := func(dir string) *Cluster {
+		c, err := New(logger, config.Config{NodeID: "a", DataDir: dir, Shards: 1, ShardVnodes: 1}, nil, open)
+		require.NoError(t, err)
+		c.Shutdown(context.Background())
+
+		return c
+	}
+
+	dir := t.TempDir()
+	first := newCluster(dir).Generation()
+	assert.NotEmpty(t, first)
+	assert.Equal(t, first, newCluster(dir).Info().Generation)
+
+	// a restored data dir gets a new generation
+	restored, err := NewGeneration(dir)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, restored)
+	assert.Equal(t, restored, newCluster(dir).Generation())
+
+	// so does a replaced one
+	require.NoError(t, os.Remove(filepath.Join(dir, generationFile)))
+	replaced := newCluster(dir).Generation()
+	assert.NotEqual(t, restored, replaced)
+	assert.NotEqual(t, first, replaced)
+}
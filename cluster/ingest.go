@@ -0,0 +1,58 @@
+package cluster
+
+import (
+	"context"
+	"distrikv/storage"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// IngestResult describes an SST added to a local shard by IngestSST.
+type IngestResult struct {
+	Shard int `json:"shard"`
+	storage.IngestResult
+}
+
+// IngestSST adds the SST file at path, on this node, to the local shard
+// owning its keys, see storage.Store.IngestSST. The keys must belong to a
+// single shard, an SST of keys of several shards fails with the shards.
+func (c *Cluster) IngestSST(ctx context.Context, path string) (*IngestResult, error) {
+	sst, err := storage.OpenSSTFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", storage.ErrIngestInvalid, path, err)
+	}
+
+	var key string
+	shards := make(map[int]bool)
+	err = sst.ReadEntries(func(_ int64, entry *storage.SSTEntry) bool {
+		key = entry.Key
+		shards[c.ring.Shard(entry.Key)] = true
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", storage.ErrIngestInvalid, path, err)
+	}
+
+	switch {
+	case len(shards) == 0:
+		return nil, fmt.Errorf("%w: %s has no entries", storage.ErrIngestInvalid, path)
+	case len(shards) > 1:
+		return nil, fmt.Errorf("%w: %s holds keys of shards %v", storage.ErrIngestInvalid, path, slices.Sorted(maps.Keys(shards)))
+	}
+
+	c.writeMu.RLock()
+	defer c.writeMu.RUnlock()
+
+	store, err := c.writeStore(key)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := store.IngestSST(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IngestResult{Shard: c.ring.Shard(key), IngestResult: *res}, nil
+}
@@ -0,0 +1,24 @@
+package cluster
+
+import "fmt"
+
+// MovedError is returned for keys of shards served by another node,
+// clients can send the key straight to Node instead of reloading the ring.
+type MovedError struct {
+	Key   string
+	Shard int
+	Node  Node
+}
+
+func (e *MovedError) Error() string {
+	return fmt.Sprintf("%s, shard %d is served by %s", ErrNotOwner, e.Shard, e.Node.ID)
+}
+
+func (e *MovedError) Unwrap() error {
+	return ErrNotOwner
+}
+
+// Shard returns the shard of key.
+func (c *Cluster) Shard(key string) int {
+	return c.ring.Shard(key)
+}
@@ -0,0 +1,48 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Node is a member of the cluster.
+// Addr is the base URL peers use to forward requests to the node.
+type Node struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr"`
+}
+
+// ParseNodes parses a comma separated list of id=addr pairs.
+func ParseNodes(nodes []string) ([]Node, error) {
+	var res []Node
+	for _, n := range nodes {
+		id, addr, ok := strings.Cut(n, "=")
+		if !ok || id == "" || addr == "" {
+			return nil, fmt.Errorf("invalid node %q, expected id=addr", n)
+		}
+
+		res = append(res, Node{
+			ID:   id,
+			Addr: addr,
+		})
+	}
+
+	return res, nil
+}
+
+// ownerOf assigns shard to a node with rendezvous hashing,
+// membership changes only move the shards of the changed node.
+func ownerOf(shard int, nodes []Node) Node {
+	var owner Node
+	var best uint64
+
+	for _, node := range nodes {
+		score := hashKey(fmt.Sprintf("%s-%d", node.ID, shard))
+		if owner.ID == "" || score > best {
+			owner = node
+			best = score
+		}
+	}
+
+	return owner
+}
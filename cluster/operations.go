@@ -0,0 +1,114 @@
+package cluster
+
+import (
+	"context"
+	"distrikv/ops"
+	"distrikv/storage"
+	"fmt"
+	"sort"
+)
+
+// Operations returns the registry of the long-running operations of this node.
+func (c *Cluster) Operations() *ops.Registry {
+	return c.operations
+}
+
+// Flush writes the memtables of the local shards to SSTs
+// and returns the number of entries written.
+func (c *Cluster) Flush(ctx context.Context) (int, error) {
+	var flushed int
+	for shard, store := range c.localShards() {
+		entries, err := store.Flush(ctx)
+		flushed += entries
+		if err != nil {
+			return flushed, fmt.Errorf("shard %d: %w", shard, err)
+		}
+	}
+
+	return flushed, nil
+}
+
+// SetSequencer numbers the writes of the shards opened by this node with
+// seq, shards opened afterwards too, see storage.Store.SetSequencer.
+func (c *Cluster) SetSequencer(seq storage.Sequencer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sequencer = seq
+	for _, store := range c.shards {
+		store.SetSequencer(seq)
+	}
+}
+
+// UnflushedSeq returns the sequence of the first write still in a memtable
+// of a shard opened by this node, false if none holds a numbered write.
+func (c *Cluster) UnflushedSeq() (uint64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var first uint64
+	for _, store := range c.shards {
+		if seq, ok := store.UnflushedSeq(); ok && (first == 0 || seq < first) {
+			first = seq
+		}
+	}
+
+	return first, first != 0
+}
+
+// PauseCompactions pauses the background compactions of the local
+// shards and returns once they stopped. Shards opened afterwards,
+// e.g. moved to this node, compact as usual.
+func (c *Cluster) PauseCompactions() {
+	for _, store := range c.localShards() {
+		store.PauseCompactions()
+	}
+}
+
+// ResumeCompactions resumes the background
+// compactions of the local shards.
+func (c *Cluster) ResumeCompactions() {
+	for _, store := range c.localShards() {
+		store.ResumeCompactions()
+	}
+}
+
+// CompactionsPaused returns the local shards whose
+// background compactions are paused.
+func (c *Cluster) CompactionsPaused() []int {
+	shards := []int{}
+	for shard, store := range c.localShards() {
+		if store.CompactionsPaused() {
+			shards = append(shards, shard)
+		}
+	}
+
+	sort.Ints(shards)
+
+	return shards
+}
+
+// CompactAll starts a manual compaction of every level of the local
+// shards, one shard after the other. Its progress counts the shards.
+func (c *Cluster) CompactAll() ops.Operation {
+	local := c.localShards()
+
+	var shards []int
+	for shard := range local {
+		shards = append(shards, shard)
+	}
+	sort.Ints(shards)
+
+	return c.operations.Start("compaction", fmt.Sprintf("manual compaction of %d shards", len(shards)), func(ctx context.Context, p *ops.Progress) error {
+		for i, shard := range shards {
+			p.Set(int64(i), int64(len(shards)))
+
+			if err := local[shard].CompactAll(ctx, func(int, int) {}); err != nil {
+				return fmt.Errorf("shard %d: %w", shard, err)
+			}
+		}
+
+		p.Set(int64(len(shards)), int64(len(shards)))
+		return nil
+	})
+}
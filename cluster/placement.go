@@ -0,0 +1,314 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+var (
+	ErrUnknownNode       error = errors.New("node is not a cluster member")
+	ErrDecommissioned    error = errors.New("node is decommissioned")
+	ErrInvalidShard      error = errors.New("shard does not exist")
+	ErrReplicationFactor error = errors.New("shards have a single owner, only a replication factor of 1 is supported")
+)
+
+// ReplicationFactor is the number of nodes owning a shard,
+// redundancy comes from the standbys of every node.
+const ReplicationFactor = 1
+
+// placementTimeout bounds sending the placement to a node.
+const placementTimeout = 5 * time.Second
+
+// placementFile keeps the placement of the cluster in the data dir.
+const placementFile = "placement.json"
+
+// Placement overrides the ownership of shards computed from the ring.
+// It's changed through the admin API of any node and sent to every
+// other node, the highest version wins.
+type Placement struct {
+	Version uint64 `json:"version"`
+
+	// Decommissioned nodes own no shards,
+	// their shards are moved to the other nodes.
+	Decommissioned []string `json:"decommissioned,omitempty"`
+
+	// Pins maps shards to the node owning them.
+	Pins map[int]string `json:"pins,omitempty"`
+}
+
+// owner returns the owner of shard among nodes.
+func (p Placement) owner(shard int, nodes []Node) Node {
+	if id, ok := p.Pins[shard]; ok {
+		if i := slices.IndexFunc(nodes, func(n Node) bool { return n.ID == id }); i >= 0 {
+			return nodes[i]
+		}
+	}
+
+	active := slices.DeleteFunc(slices.Clone(nodes), func(n Node) bool {
+		return slices.Contains(p.Decommissioned, n.ID)
+	})
+
+	// a fully decommissioned cluster keeps serving
+	if len(active) == 0 {
+		active = nodes
+	}
+
+	return ownerOf(shard, active)
+}
+
+func (p Placement) clone() Placement {
+	pins := make(map[int]string, len(p.Pins))
+	for shard, node := range p.Pins {
+		pins[shard] = node
+	}
+
+	return Placement{
+		Version:        p.Version,
+		Decommissioned: slices.Clone(p.Decommissioned),
+		Pins:           pins,
+	}
+}
+
+func loadPlacement(dataDir string) (Placement, error) {
+	var p Placement
+
+	data, err := os.ReadFile(filepath.Join(dataDir, placementFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+
+	if err != nil {
+		return p, err
+	}
+
+	return p, json.Unmarshal(data, &p)
+}
+
+func savePlacement(dataDir string, p Placement) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(dataDir, placementFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(dataDir, placementFile))
+}
+
+func (c *Cluster) Placement() Placement {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.placement.clone()
+}
+
+// SetPlacement applies a placement newer than the current one
+// and moves the shards whose owner changed.
+func (c *Cluster) SetPlacement(p Placement) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if p.Version <= c.placement.Version {
+		return nil
+	}
+
+	if err := savePlacement(c.dataDir, p); err != nil {
+		return err
+	}
+
+	c.placement = p
+
+	return c.assign(c.nodes)
+}
+
+// Decommission moves every shard of node to the other nodes.
+func (c *Cluster) Decommission(node string) (Placement, error) {
+	return c.updatePlacement(func(p *Placement) error {
+		if !c.isMember(node) {
+			return ErrUnknownNode
+		}
+
+		if !slices.Contains(p.Decommissioned, node) {
+			p.Decommissioned = append(p.Decommissioned, node)
+		}
+
+		for shard, owner := range p.Pins {
+			if owner == node {
+				delete(p.Pins, shard)
+			}
+		}
+
+		return nil
+	})
+}
+
+// MoveShard pins shard to node.
+func (c *Cluster) MoveShard(shard int, node string) (Placement, error) {
+	return c.updatePlacement(func(p *Placement) error {
+		if shard < 0 || shard >= c.ring.Shards() {
+			return ErrInvalidShard
+		}
+
+		if !c.isMember(node) {
+			return ErrUnknownNode
+		}
+
+		if slices.Contains(p.Decommissioned, node) {
+			return ErrDecommissioned
+		}
+
+		p.Pins[shard] = node
+
+		return nil
+	})
+}
+
+// SetReplicationFactor changes the number of owners of every shard.
+func (c *Cluster) SetReplicationFactor(n int) error {
+	if n != ReplicationFactor {
+		return ErrReplicationFactor
+	}
+
+	return nil
+}
+
+// updatePlacement applies fn to a copy of the placement,
+// then applies it locally and sends it to the other nodes.
+func (c *Cluster) updatePlacement(fn func(p *Placement) error) (Placement, error) {
+	p := c.Placement()
+	if err := fn(&p); err != nil {
+		return Placement{}, err
+	}
+
+	p.Version++
+	if err := c.SetPlacement(p); err != nil {
+		return Placement{}, err
+	}
+
+	c.mu.RLock()
+	nodes := c.nodes
+	c.mu.RUnlock()
+
+	for _, node := range nodes {
+		if node.ID == c.self.ID {
+			continue
+		}
+
+		if err := c.sendPlacement(node, p); err != nil {
+			c.logger.Warn("error sending placement", "node", node.ID, "err", err)
+		}
+	}
+
+	return p, nil
+}
+
+func (c *Cluster) sendPlacement(node Node, p Placement) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), placementTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, node.Addr+"/internal/cluster/placement", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("node responded with %s", res.Status)
+	}
+
+	return nil
+}
+
+func (c *Cluster) isMember(node string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return slices.ContainsFunc(c.nodes, func(n Node) bool { return n.ID == node })
+}
+
+// NodeInfo is a cluster member and the number of shards it owns.
+type NodeInfo struct {
+	Node
+	Shards         int  `json:"shards"`
+	Decommissioned bool `json:"decommissioned"`
+}
+
+// Info is the state of the cluster seen from this node,
+// disk usage is only known for the local shards.
+type Info struct {
+	Self              Node          `json:"self"`
+	Generation        string        `json:"generation"`
+	Nodes             []NodeInfo    `json:"nodes"`
+	Shards            []ShardStatus `json:"shards"`
+	Placement         Placement     `json:"placement"`
+	ReplicationFactor int           `json:"replication_factor"`
+	DiskUsage         map[int]int64 `json:"disk_usage"`
+	MovesRunning      int           `json:"moves_running"`
+}
+
+func (c *Cluster) Info() Info {
+	status := c.Status()
+
+	info := Info{
+		Self:              status.Self,
+		Generation:        c.generation,
+		Shards:            status.Shards,
+		Placement:         c.Placement(),
+		ReplicationFactor: ReplicationFactor,
+		DiskUsage:         make(map[int]int64),
+		MovesRunning:      c.Rebalance().Running,
+	}
+
+	for _, node := range status.Nodes {
+		ni := NodeInfo{
+			Node:           node,
+			Decommissioned: slices.Contains(info.Placement.Decommissioned, node.ID),
+		}
+
+		for _, shard := range status.Shards {
+			if shard.Owner == node.ID {
+				ni.Shards++
+			}
+		}
+
+		info.Nodes = append(info.Nodes, ni)
+	}
+
+	for shard, store := range c.localShards() {
+		size, err := store.DiskUsage()
+		if err != nil {
+			c.logger.Warn("error reading disk usage", "shard", shard, "err", err)
+			continue
+		}
+
+		info.DiskUsage[shard] = size
+	}
+
+	return info
+}
@@ -0,0 +1,31 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlacementOwner(t *testing.T) {
+	nodes := []Node{{ID: "n1"}, {ID: "n2"}, {ID: "n3"}}
+
+	p := Placement{
+		Decommissioned: []string{"n2"},
+		Pins:           map[int]string{3: "n3"},
+	}
+
+	for shard := range 32 {
+		owner := p.owner(shard, nodes)
+		assert.NotEqual(t, "n2", owner.ID)
+
+		// shards of the remaining nodes stay in place
+		if before := ownerOf(shard, nodes); before.ID != "n2" && shard != 3 {
+			assert.Equal(t, before, owner)
+		}
+	}
+
+	assert.Equal(t, "n3", p.owner(3, nodes).ID)
+
+	// pins to nodes that left fall back to the ring
+	assert.Equal(t, ownerOf(3, nodes[:1]), p.owner(3, nodes[:1]))
+}
@@ -0,0 +1,529 @@
+package cluster
+
+import (
+	"context"
+	"distrikv/ops"
+	"distrikv/storage"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+var ErrNotHandedOff error = errors.New("shard is not handed off")
+
+// MoveTimeout is how long a node keeps serving a shard it lost
+// while waiting for the new owner to pull it.
+var MoveTimeout = 30 * time.Second
+
+const (
+	// pulls of a shard before the new owner gives up
+	// and opens its local copy of the shard
+	moveAttempts = 3
+
+	// moves kept in the rebalance history
+	moveHistory = 100
+
+	shardChunkSize = 1 << 20
+)
+
+type MoveState int
+
+// Shard Move States
+//
+// [MOVE_STREAMING] -> (1) -> [MOVE_DONE]
+//
+//	-> (2) -> [MOVE_FAILED]
+//
+// 1. The new owner installed the shard and released the previous owner,
+// which removes its copy.
+//
+// 2. The previous owner couldn't be reached, the new owner
+// serves its local copy of the shard, empty for a new node.
+const (
+	MOVE_STREAMING MoveState = iota
+
+	MOVE_DONE
+
+	MOVE_FAILED
+)
+
+func (s MoveState) String() string {
+	switch s {
+	case MOVE_DONE:
+		return "done"
+	case MOVE_FAILED:
+		return "failed"
+	default:
+		return "streaming"
+	}
+}
+
+// Move is a shard moved between nodes.
+type Move struct {
+	Shard      int       `json:"shard"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	State      string    `json:"state"`
+	Bytes      int64     `json:"bytes"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// RebalanceStatus lists the running and past moves of this node.
+type RebalanceStatus struct {
+	Running int    `json:"running"`
+	Moves   []Move `json:"moves"`
+}
+
+// outgoing is a shard lost by this node and not pulled yet.
+type outgoing struct {
+	to       Node
+	deadline time.Time
+
+	// frozen rejects writes once the shard is handed off,
+	// streaming keeps the shard served while it is sent.
+	frozen    bool
+	streaming bool
+}
+
+func (o *outgoing) active() bool {
+	return o.streaming || time.Now().Before(o.deadline)
+}
+
+// shardChunk is a message of a shard handoff stream,
+// the last one is marked Done.
+type shardChunk struct {
+	File    string             `json:"file,omitempty"`
+	Data    []byte             `json:"data,omitempty"`
+	Entries []storage.SSTEntry `json:"entries,omitempty"`
+	Done    bool               `json:"done,omitempty"`
+}
+
+func (c *Cluster) Rebalance() RebalanceStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	status := RebalanceStatus{
+		Moves: []Move{},
+	}
+
+	for _, move := range c.moves {
+		if move.State == MOVE_STREAMING.String() {
+			status.Running++
+		}
+
+		status.Moves = append(status.Moves, *move)
+	}
+
+	return status
+}
+
+// startMove records a new move. Must be called with mu held.
+func (c *Cluster) startMove(shard int, from string, to string) *Move {
+	move := &Move{
+		Shard:     shard,
+		From:      from,
+		To:        to,
+		State:     MOVE_STREAMING.String(),
+		StartedAt: time.Now(),
+	}
+
+	c.moves = append(c.moves, move)
+	if len(c.moves) > moveHistory {
+		c.moves = c.moves[len(c.moves)-moveHistory:]
+	}
+
+	return move
+}
+
+// finishMove records the result of move. Must be called with mu held.
+func (c *Cluster) finishMove(move *Move, err error) {
+	move.FinishedAt = time.Now()
+	move.State = MOVE_DONE.String()
+
+	if err != nil {
+		move.State = MOVE_FAILED.String()
+		move.Error = err.Error()
+	}
+}
+
+// previousOwner returns the node a shard gained by this node
+// is pulled from. On startup, shards without local data are pulled
+// from the node owning them without this node.
+// Must be called with mu held.
+func (c *Cluster) previousOwner(shard int, prev []Node, nodes []Node) (Node, bool) {
+	if len(prev) == c.ring.Shards() {
+		return prev[shard], prev[shard].ID != c.self.ID
+	}
+
+	others := slices.DeleteFunc(slices.Clone(nodes), func(n Node) bool {
+		return n.ID == c.self.ID
+	})
+
+	if len(others) == 0 {
+		return Node{}, false
+	}
+
+	files, err := filepath.Glob(filepath.Join(ShardDir(c.dataDir, c.ring.Shards(), shard), "*"+storage.SSTFileFormat))
+	if err != nil || len(files) > 0 {
+		return Node{}, false
+	}
+
+	return c.placement.owner(shard, others), true
+}
+
+// moveIn pulls shard from source and starts serving it. Canceling ctx
+// stops the pull, the shard is then served from its local copy.
+func (c *Cluster) moveIn(ctx context.Context, shard int, source Node, p *ops.Progress) error {
+	c.mu.Lock()
+	move := c.startMove(shard, source.ID, c.self.ID)
+	c.mu.Unlock()
+
+	dir := ShardDir(c.dataDir, c.ring.Shards(), shard)
+	tmp := filepath.Join(c.dataDir, fmt.Sprintf("incoming-%03d", shard))
+
+	var entries []storage.SSTEntry
+	var err error
+	for attempt := range moveAttempts {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			break
+		}
+
+		entries, err = c.pull(ctx, shard, source, tmp, move, p)
+		if err == nil || errors.Is(err, ErrNotOwner) {
+			break
+		}
+
+		c.logger.Warn("error pulling shard", "shard", shard, "from", source.ID, "attempt", attempt+1, "err", err)
+	}
+
+	c.mu.Lock()
+
+	// the shard moved on while it was pulled
+	if current, ok := c.movingIn[shard]; !ok || current.ID != source.ID {
+		err := errors.New("move abandoned")
+		c.finishMove(move, err)
+		c.mu.Unlock()
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	// the source never served the shard, there's nothing to pull
+	notOwner := errors.Is(err, ErrNotOwner)
+	if notOwner {
+		err = nil
+	} else if err == nil {
+		err = installShard(tmp, dir, entries, c.cmp)
+	}
+	os.RemoveAll(tmp)
+
+	if err != nil {
+		c.logger.Error("serving local copy of shard, it couldn't be pulled", "shard", shard, "from", source.ID, "err", err)
+	}
+
+	store, openErr := c.openShard(dir)
+	if openErr != nil {
+		c.logger.Error("error opening shard", "shard", shard, "err", openErr)
+		err = errors.Join(err, openErr)
+		c.finishMove(move, err)
+		c.mu.Unlock()
+		return err
+	}
+
+	c.shards[shard] = store
+	delete(c.movingIn, shard)
+
+	if err != nil || notOwner {
+		c.finishMove(move, err)
+		c.mu.Unlock()
+		return err
+	}
+	c.mu.Unlock()
+
+	c.logger.Info("pulled shard", "shard", shard, "from", source.ID, "bytes", move.Bytes)
+
+	// the move is done once the previous owner stopped serving the shard
+	if err := c.release(shard, source); err != nil {
+		c.logger.Warn("error releasing shard", "shard", shard, "node", source.ID, "err", err)
+	}
+
+	c.mu.Lock()
+	c.finishMove(move, nil)
+	c.mu.Unlock()
+
+	return nil
+}
+
+// pull streams the handoff of shard from source into tmp
+// and returns the memtable entries of the shard.
+func (c *Cluster) pull(ctx context.Context, shard int, source Node, tmp string, move *Move, p *ops.Progress) ([]storage.SSTEntry, error) {
+	if err := os.RemoveAll(tmp); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/internal/shards/%d/handoff?to=%s", source.Addr, shard, c.self.ID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusMisdirectedRequest {
+		return nil, ErrNotOwner
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("node responded with %s", res.Status)
+	}
+
+	var entries []storage.SSTEntry
+	dec := json.NewDecoder(res.Body)
+	for {
+		var chunk shardChunk
+		if err := dec.Decode(&chunk); err != nil {
+			return nil, fmt.Errorf("handoff stream ended early: %w", err)
+		}
+
+		if chunk.Done {
+			return entries, nil
+		}
+
+		entries = append(entries, chunk.Entries...)
+
+		if chunk.File == "" {
+			continue
+		}
+
+		if err := appendFile(filepath.Join(tmp, filepath.Base(chunk.File)), chunk.Data); err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		move.Bytes += int64(len(chunk.Data))
+		c.mu.Unlock()
+
+		p.Add(int64(len(chunk.Data)))
+	}
+}
+
+func appendFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// installShard replaces the SSTs of dir with the pulled ones.
+func installShard(tmp string, dir string, entries []storage.SSTEntry, cmp storage.Comparator) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if err := removeSSTs(dir); err != nil {
+		return err
+	}
+
+	files, err := os.ReadDir(tmp)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := os.Rename(filepath.Join(tmp, file.Name()), filepath.Join(dir, file.Name())); err != nil {
+			return err
+		}
+	}
+
+	return storage.WriteSnapshotEntries(dir, entries, cmp)
+}
+
+func removeSSTs(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+storage.SSTFileFormat))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Cluster) release(shard int, source Node) error {
+	res, err := c.client.Post(fmt.Sprintf("%s/internal/shards/%d/release", source.Addr, shard), "", nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("node responded with %s", res.Status)
+	}
+
+	return nil
+}
+
+// HandoffShard freezes the writes of a local shard and streams it to
+// node to. The shard is served until it is released or MoveTimeout
+// passed after the handoff.
+func (c *Cluster) HandoffShard(shard int, to string, w io.Writer) error {
+	c.writeMu.Lock()
+	c.mu.Lock()
+
+	store, ok := c.shards[shard]
+	if !ok {
+		c.mu.Unlock()
+		c.writeMu.Unlock()
+		return ErrNotOwner
+	}
+
+	// the new owner can learn about the move first
+	out, ok := c.movingOut[shard]
+	if !ok {
+		out = &outgoing{to: Node{ID: to}}
+		c.movingOut[shard] = out
+	}
+
+	out.frozen = true
+	out.streaming = true
+	move := c.startMove(shard, c.self.ID, to)
+
+	c.mu.Unlock()
+	c.writeMu.Unlock()
+
+	err := c.streamShard(store, w, move)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out.streaming = false
+	out.deadline = time.Now().Add(MoveTimeout)
+
+	if err != nil {
+		out.frozen = false
+		c.finishMove(move, err)
+	}
+
+	return err
+}
+
+func (c *Cluster) streamShard(store *storage.Store, w io.Writer, move *Move) error {
+	snapshot, err := store.Snapshot()
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+
+	enc := json.NewEncoder(w)
+	buf := make([]byte, shardChunkSize)
+
+	for _, file := range snapshot.Files {
+		f, err := os.Open(filepath.Join(snapshot.Dir, file))
+		if err != nil {
+			return err
+		}
+
+		for {
+			n, err := io.ReadFull(f, buf)
+			if n > 0 {
+				if err := enc.Encode(shardChunk{File: file, Data: buf[:n]}); err != nil {
+					f.Close()
+					return err
+				}
+
+				c.mu.Lock()
+				move.Bytes += int64(n)
+				c.mu.Unlock()
+			}
+
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+
+			if err != nil {
+				f.Close()
+				return err
+			}
+		}
+
+		f.Close()
+	}
+
+	for entries := range slices.Chunk(snapshot.Entries, 1000) {
+		if err := enc.Encode(shardChunk{Entries: entries}); err != nil {
+			return err
+		}
+	}
+
+	return enc.Encode(shardChunk{Done: true})
+}
+
+// ReleaseShard closes a handed off shard and removes its data,
+// the new owner serves it from now on.
+func (c *Cluster) ReleaseShard(shard int) error {
+	c.mu.Lock()
+
+	out, ok := c.movingOut[shard]
+	if !ok || !out.frozen {
+		c.mu.Unlock()
+		return ErrNotHandedOff
+	}
+
+	store := c.shards[shard]
+	delete(c.movingOut, shard)
+	delete(c.shards, shard)
+
+	for _, move := range slices.Backward(c.moves) {
+		if move.Shard == shard && move.From == c.self.ID && move.State == MOVE_STREAMING.String() {
+			c.finishMove(move, nil)
+			break
+		}
+	}
+
+	c.mu.Unlock()
+
+	if _, err := store.Close(context.Background()); err != nil {
+		return err
+	}
+
+	return removeSSTs(store.Dir())
+}
@@ -0,0 +1,80 @@
+package cluster
+
+import (
+	"context"
+	"distrikv/config"
+	"distrikv/storage"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoiningNodePullsShards(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	open := func(dir string) (*storage.Store, error) {
+		return storage.Open(context.Background(), logger, dir, storage.OPEN_FAST, nil, nil, nil)
+	}
+
+	var a *Cluster
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /internal/shards/{shard}/handoff", func(w http.ResponseWriter, r *http.Request) {
+		shard, _ := strconv.Atoi(r.PathValue("shard"))
+		a.HandoffShard(shard, r.URL.Query().Get("to"), w)
+	})
+	mux.HandleFunc("POST /internal/shards/{shard}/release", func(w http.ResponseWriter, r *http.Request) {
+		shard, _ := strconv.Atoi(r.PathValue("shard"))
+		if err := a.ReleaseShard(shard); err != nil {
+			w.WriteHeader(http.StatusConflict)
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	nodeA := Node{ID: "a", Addr: server.URL}
+	nodeB := Node{ID: "b", Addr: "http://127.0.0.1:1"}
+
+	a, err := New(logger, config.Config{NodeID: "a", DataDir: t.TempDir(), Shards: 4, ShardVnodes: 64}, []Node{nodeA}, open)
+	require.NoError(t, err)
+
+	for i := range 50 {
+		require.NoError(t, a.Set(fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)))
+	}
+
+	require.NoError(t, a.SetNodes([]Node{nodeA, nodeB}))
+
+	b, err := New(logger, config.Config{NodeID: "b", DataDir: t.TempDir(), Shards: 4, ShardVnodes: 64}, []Node{nodeA, nodeB}, open)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		status := b.Rebalance()
+		return len(status.Moves) > 0 && status.Running == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for _, move := range b.Rebalance().Moves {
+		assert.Equal(t, MOVE_DONE.String(), move.State, move.Error)
+	}
+
+	for i := range 50 {
+		key := fmt.Sprintf("key-%d", i)
+
+		owner, _ := b.Owner(key)
+		if owner.ID != "b" {
+			continue
+		}
+
+		data, err := b.Get(context.Background(), key)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), data.Value)
+
+		// the previous owner released the shard
+		_, err = a.Get(context.Background(), key)
+		assert.ErrorIs(t, err, ErrNotOwner)
+	}
+}
@@ -0,0 +1,80 @@
+package cluster
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// hashKey hashes keys and ring points onto the ring.
+// FNV alone spreads short, similar strings poorly,
+// so its sum is passed through the splitmix64 finalizer.
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+type point struct {
+	hash  uint64
+	shard int
+}
+
+// Ring maps keys to shards with consistent hashing.
+// Every shard is placed on the ring as vnodes points, a key belongs
+// to the shard of the first point at or after the hash of the key.
+type Ring struct {
+	shards int
+	vnodes int
+	points []point
+}
+
+func NewRing(shards int, vnodes int) *Ring {
+	r := &Ring{
+		shards: shards,
+		vnodes: vnodes,
+	}
+
+	for shard := range shards {
+		for v := range vnodes {
+			r.points = append(r.points, point{
+				hash:  hashKey(fmt.Sprintf("shard-%d-%d", shard, v)),
+				shard: shard,
+			})
+		}
+	}
+
+	sort.Slice(r.points, func(a, b int) bool {
+		return r.points[a].hash < r.points[b].hash
+	})
+
+	return r
+}
+
+// Shard returns the shard owning key.
+func (r *Ring) Shard(key string) int {
+	h := hashKey(key)
+
+	idx := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= h
+	})
+
+	// wrap around the ring
+	if idx == len(r.points) {
+		idx = 0
+	}
+
+	return r.points[idx].shard
+}
+
+// Shards returns the number of shards on the ring.
+func (r *Ring) Shards() int {
+	return r.shards
+}
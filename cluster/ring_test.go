@@ -0,0 +1,41 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRingShard(t *testing.T) {
+	ring := NewRing(8, 64)
+
+	counts := make(map[int]int)
+	for i := range 8000 {
+		key := fmt.Sprintf("key-%d", i)
+		shard := ring.Shard(key)
+
+		assert.Equal(t, shard, ring.Shard(key))
+		counts[shard]++
+	}
+
+	// every shard gets a share of the keys
+	assert.Len(t, counts, 8)
+	for _, count := range counts {
+		assert.Greater(t, count, 500)
+	}
+}
+
+func TestOwnerOfMovesOnlyToNewNode(t *testing.T) {
+	nodes := []Node{{ID: "n1"}, {ID: "n2"}}
+	grown := append(nodes, Node{ID: "n3"})
+
+	for shard := range 64 {
+		before := ownerOf(shard, nodes)
+		after := ownerOf(shard, grown)
+
+		if before.ID != after.ID {
+			assert.Equal(t, "n3", after.ID)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package cluster
+
+import (
+	"context"
+	"distrikv/storage"
+	"sort"
+)
+
+// shardStream streams the scan of a single shard.
+type shardStream struct {
+	items chan *storage.KVData
+	err   chan error
+	head  *storage.KVData
+}
+
+// Scan calls fn for every live key of the local shards in [start, end)
+// in the order of the comparator until fn returns false or ctx is done.
+// Keys of remote shards are not included.
+func (c *Cluster) Scan(ctx context.Context, start string, end string, fn func(*storage.KVData) bool) error {
+	local := c.localShards()
+
+	var shards []int
+	for shard := range local {
+		shards = append(shards, shard)
+	}
+	sort.Ints(shards)
+
+	if len(shards) == 1 {
+		return local[shards[0]].Scan(ctx, start, end, fn)
+	}
+
+	// shards are scanned concurrently and merged by key,
+	// done stops the remaining scans once fn returns false.
+	done := make(chan struct{})
+
+	var streams []*shardStream
+	for _, shard := range shards {
+		stream := &shardStream{
+			items: make(chan *storage.KVData),
+			err:   make(chan error, 1),
+		}
+		streams = append(streams, stream)
+
+		go func(store *storage.Store) {
+			stream.err <- store.Scan(ctx, start, end, func(data *storage.KVData) bool {
+				select {
+				case stream.items <- data:
+					return true
+				case <-done:
+					return false
+				}
+			})
+			close(stream.items)
+		}(local[shard])
+	}
+
+	for _, stream := range streams {
+		stream.head = <-stream.items
+	}
+
+	for {
+		// shards own disjoint keys, the smallest head is next
+		var next *shardStream
+		for _, stream := range streams {
+			if stream.head != nil && (next == nil || c.cmp.Compare(stream.head.Key, next.head.Key) < 0) {
+				next = stream
+			}
+		}
+
+		if next == nil || !fn(next.head) {
+			break
+		}
+
+		next.head = <-next.items
+	}
+
+	close(done)
+
+	var scanErr error
+	for _, stream := range streams {
+		// drain the stream so its scan can finish
+		for range stream.items {
+		}
+
+		if err := <-stream.err; err != nil && scanErr == nil {
+			scanErr = err
+		}
+	}
+
+	return scanErr
+}
@@ -0,0 +1,105 @@
+package cluster
+
+import (
+	"context"
+	"distrikv/storage"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Phase is a step of a shutdown and the time it took.
+type Phase struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+}
+
+// ShutdownReport summarizes the shutdown of the local shards.
+type ShutdownReport struct {
+	Shards             int
+	EntriesFlushed     int
+	CompactionsAborted int
+	Phases             []Phase
+}
+
+// Degraded reports whether a phase failed, e.g. memtables
+// that couldn't be flushed before the deadline.
+func (r ShutdownReport) Degraded() bool {
+	for _, phase := range r.Phases {
+		if phase.Err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LogValue logs the report with the duration of every phase.
+func (r ShutdownReport) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.Int("shards", r.Shards),
+		slog.Int("entries_flushed", r.EntriesFlushed),
+		slog.Int("compactions_aborted", r.CompactionsAborted),
+		slog.Bool("degraded", r.Degraded()),
+	}
+
+	for _, phase := range r.Phases {
+		phaseAttrs := []any{slog.Duration("duration", phase.Duration)}
+		if phase.Err != nil {
+			phaseAttrs = append(phaseAttrs, slog.String("err", phase.Err.Error()))
+		}
+
+		attrs = append(attrs, slog.Group(phase.Name, phaseAttrs...))
+	}
+
+	return slog.GroupValue(attrs...)
+}
+
+// Shutdown cancels the running operations and stops every shard
+// opened by this node, aborting running compactions, then closes
+// them, flushing their memtables until ctx is done. Shards are stopped
+// first so writes arriving meanwhile are rejected instead of being left
+// in the memtables.
+func (c *Cluster) Shutdown(ctx context.Context) ShutdownReport {
+	// canceled moves open their shard, which is then closed below
+	c.operations.Close()
+
+	c.mu.RLock()
+	shards := make(map[int]*storage.Store, len(c.shards))
+	for shard, store := range c.shards {
+		shards[shard] = store
+	}
+	c.mu.RUnlock()
+
+	report := ShutdownReport{Shards: len(shards)}
+
+	start := time.Now()
+	for _, store := range shards {
+		report.CompactionsAborted += store.Stop()
+	}
+
+	report.Phases = append(report.Phases, Phase{
+		Name:     "close",
+		Duration: time.Since(start),
+	})
+
+	start = time.Now()
+	var errs []error
+	for shard, store := range shards {
+		entries, err := store.Close(ctx)
+		report.EntriesFlushed += entries
+		if err != nil {
+			errs = append(errs, fmt.Errorf("close shard %d: %w", shard, err))
+		}
+	}
+
+	report.Phases = append(report.Phases, Phase{
+		Name:     "flush",
+		Duration: time.Since(start),
+		Err:      errors.Join(errs...),
+	})
+
+	return report
+}
@@ -0,0 +1,428 @@
+package config
+
+import (
+	"distrikv/pkg"
+	"distrikv/ratelimit"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the server configuration.
+// Values are read from environment variables.
+type Config struct {
+	// HTTPAddrs, GRPCAddrs and RedisAddrs are the listen addresses
+	// of each client API. Every API can listen on several
+	// addresses, e.g. "0.0.0.0:6090,[::]:6090" for dual stack.
+	HTTPAddrs  []string
+	GRPCAddrs  []string
+	RedisAddrs []string
+
+	// HTTPIdleTimeout closes idle keep-alive connections,
+	// HTTPReadHeaderTimeout bounds reading request headers.
+	// HTTPKeepAlive disables keep-alive when false.
+	HTTPIdleTimeout       time.Duration
+	HTTPReadHeaderTimeout time.Duration
+	HTTPKeepAlive         bool
+
+	// ShutdownDrainTimeout bounds waiting for the requests in flight
+	// of every client API on shutdown, before the memtables are flushed.
+	ShutdownDrainTimeout time.Duration
+
+	// RequestTimeout bounds the storage reads of a key request of the
+	// HTTP and Redis APIs, 0 doesn't bound them.
+	RequestTimeout time.Duration
+
+	// HTTP2 serves HTTP/2 without TLS next to HTTP/1.1,
+	// HTTP2MaxStreams caps the concurrent streams of a connection.
+	HTTP2           bool
+	HTTP2MaxStreams int
+
+	// TLSCertFile and TLSKeyFile serve the HTTP and gRPC APIs over
+	// TLS. TLSAutocertDomains get the certificate from Let's Encrypt
+	// instead, cached in $DATA_DIR/autocert. TLSMinVersion is 1.2 or
+	// 1.3, TLSCipherSuites restrict the TLS 1.2 cipher suites.
+	TLSCertFile        string
+	TLSKeyFile         string
+	TLSAutocertDomains []string
+	TLSAutocertEmail   string
+	TLSMinVersion      string
+	TLSCipherSuites    []string
+
+	// ClusterAddrs are additional HTTP listen addresses
+	// for intra-cluster traffic, e.g. on a private network.
+	ClusterAddrs []string
+
+	// ClusterTLSCAFile, ClusterTLSCertFile and ClusterTLSKeyFile
+	// authenticate the nodes to each other with certificates of the
+	// cluster CA. ClusterAddrs then only accept peers, the internal
+	// routes and the snapshot service reject other callers.
+	ClusterTLSCAFile   string
+	ClusterTLSCertFile string
+	ClusterTLSKeyFile  string
+
+	// Auth requires an API key or a token from the clients of every
+	// API. Keys are kept hashed in AuthKeysFile, $DATA_DIR/api-keys.json
+	// when empty, created with a first admin key when missing. Tokens
+	// are signed with the secret of AuthTokenSecretFile, shared by the
+	// nodes; without it only keys are accepted.
+	Auth                bool
+	AuthKeysFile        string
+	AuthTokenSecretFile string
+
+	// RateLimitRequests and RateLimitBytes are the requests and the
+	// request and response bytes per second allowed to every client,
+	// identified by its credential or its IP address. Zero is unlimited.
+	// The bursts are the requests and bytes allowed at once.
+	RateLimitRequests   float64
+	RateLimitBurst      int
+	RateLimitBytes      int
+	RateLimitBytesBurst int
+
+	// DataDir is the directory holding the data of every shard.
+	DataDir string
+
+	// NodeID identifies this node in ClusterNodes.
+	NodeID string
+
+	// ClusterNodes lists the cluster members as id=addr pairs,
+	// addr is the base URL requests are forwarded to.
+	// Without nodes this node runs alone and owns every shard.
+	ClusterNodes []string
+
+	// AdvertiseURL is the base URL peers reach this node at.
+	// Setting it enables gossip membership, nodes are then
+	// discovered through GossipSeeds instead of ClusterNodes.
+	AdvertiseURL string
+	GossipSeeds  []string
+
+	// Shards is the number of shards the key space is split into,
+	// ShardVnodes the number of points of each shard on the hash ring.
+	Shards      int
+	ShardVnodes int
+
+	// Role is either "primary" or "standby". A standby applies
+	// the changes of the primary at PrimaryURL and rejects writes
+	// until it is promoted.
+	Role       string
+	PrimaryURL string
+
+	// PrimaryGRPCAddr is the gRPC address of the primary, a standby
+	// started with an empty data dir installs a snapshot streamed from it.
+	PrimaryGRPCAddr string
+
+	// ChangelogSize is the number of changes a primary
+	// retains for its standbys.
+	ChangelogSize int
+
+	// ChangelogRetention is the bytes of changes kept on disk, in
+	// segments of ChangelogSegmentSize bytes, for the consumers of
+	// the changelog. 0 only keeps the changes of ChangelogSize.
+	ChangelogRetention   int
+	ChangelogSegmentSize int
+
+	// KafkaBrokers enables publishing the changelog to KafkaTopic,
+	// every change as a message serialized by KafkaFormat, either
+	// "json" or "binary". KafkaTimeout bounds every produce.
+	KafkaBrokers []string
+	KafkaTopic   string
+	KafkaFormat  string
+	KafkaTimeout time.Duration
+
+	// OpenMode is the storage startup consistency level,
+	// either "fast" or "verified".
+	OpenMode string
+
+	// SSTSync is "sync" to sync the SSTs written by flushes and
+	// compactions, "nosync" to leave them to the page cache.
+	SSTSync string
+
+	// GarbageRatio is the share of dead entries at which an SST is
+	// compacted whatever the size of its level, 0.5 by default.
+	// Zero disables it.
+	GarbageRatio float64
+
+	// PurgeSchedule is the cron schedule of the purges of the bottom
+	// level of every shard, "0 3 * * *" by default, "off" never purges.
+	// Tombstones are purged once older than PurgeTombstoneAge.
+	PurgeSchedule     string
+	PurgeTombstoneAge time.Duration
+
+	// CompactionLevels overrides the compaction settings of the levels:
+	// max_files, target_file_size, compression and concurrency,
+	// e.g. "*:concurrency=2;0:max_files=4". Empty keeps the defaults.
+	CompactionLevels string
+
+	// StartupCheck is what stores do with inconsistent files
+	// found on startup: "warn", "fail" or "repair".
+	StartupCheck string
+
+	// Comparator orders the keys: bytewise, case-insensitive or numeric.
+	// It can't change once data is written.
+	Comparator string
+
+	// RetentionRules are prefix=age pairs, keys under prefix
+	// not written for age are deleted, e.g. "events/=30d".
+	RetentionRules []string
+
+	// LegacyRoutes keeps serving the deprecated
+	// query parameter routes of the HTTP API.
+	LegacyRoutes bool
+
+	// ScanDefaultLimit is the page size of scans
+	// that don't request one, 100 by default.
+	ScanDefaultLimit int
+
+	// ScanMaxLimit caps the page size of a single scan,
+	// 1000 by default. Larger scans are continued with a cursor.
+	ScanMaxLimit int
+
+	// MaxBatchSize caps the number of keys of a single
+	// multi-get or batch write, 1000 by default.
+	MaxBatchSize int
+
+	// MaxKeySize and MaxValueSize cap the size in bytes of written
+	// keys and values, 64KiB and 32MiB by default. Standbys need
+	// limits at least as large as their primary's.
+	MaxKeySize   int
+	MaxValueSize int
+
+	// CacheMaxSize turns every shard into a cache of at most this many
+	// bytes of keys and values, evicting the least recently used keys.
+	// Zero, the default, never evicts.
+	CacheMaxSize int
+
+	// RowCacheSize caps the bytes of recently read entries
+	// every shard keeps in memory, 8MiB by default.
+	RowCacheSize int
+
+	// NegativeCacheSize caps the bytes of keys recently not found
+	// every shard keeps in memory, 1MiB by default.
+	NegativeCacheSize int
+
+	// MinFreeSpace is the number of bytes kept available on the volume
+	// of the data directory, writes are refused below it. Zero, the
+	// default, never refuses writes.
+	MinFreeSpace int
+
+	// VersionsRetained is the number of previous versions kept for every
+	// key, VersionRetention keeps the ones superseded for less than it as
+	// well. They serve reads at a past time, both zero keeps none.
+	VersionsRetained int
+	VersionRetention time.Duration
+
+	// TracingEndpoint is the base URL of an OTLP/HTTP collector spans
+	// are exported to, tracing is disabled without it. TracingSampleRatio
+	// is the fraction of new traces recorded, 1 by default.
+	TracingEndpoint    string
+	TracingServiceName string
+	TracingSampleRatio float64
+
+	// LogLevel is the level of logged records, info by default.
+	// LogLevels override it by component, e.g. "storage=debug",
+	// and LogFormat is text or json.
+	LogLevel  string
+	LogLevels []string
+	LogFormat string
+}
+
+func Load() Config {
+	return Config{
+		HTTPAddrs:  envList("HTTP_ADDRS", ":"+envString("PORT", "6090")),
+		GRPCAddrs:  envList("GRPC_ADDRS", ":"+envString("GRPC_PORT", "6091")),
+		RedisAddrs: envList("REDIS_ADDRS", ":"+envString("REDIS_PORT", "6092")),
+
+		HTTPIdleTimeout:       envDuration("HTTP_IDLE_TIMEOUT", 120*time.Second),
+		HTTPReadHeaderTimeout: envDuration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second),
+		HTTPKeepAlive:         envBool("HTTP_KEEP_ALIVE", true),
+		ShutdownDrainTimeout:  envDuration("SHUTDOWN_DRAIN_TIMEOUT", 20*time.Second),
+		RequestTimeout:        envDuration("REQUEST_TIMEOUT", 30*time.Second),
+		HTTP2:                 envBool("HTTP2", true),
+		HTTP2MaxStreams:       envInt("HTTP2_MAX_STREAMS", 250),
+
+		TLSCertFile:        os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:         os.Getenv("TLS_KEY_FILE"),
+		TLSAutocertDomains: envList("TLS_AUTOCERT_DOMAINS", ""),
+		TLSAutocertEmail:   os.Getenv("TLS_AUTOCERT_EMAIL"),
+		TLSMinVersion:      envString("TLS_MIN_VERSION", "1.2"),
+		TLSCipherSuites:    envList("TLS_CIPHER_SUITES", ""),
+
+		ClusterAddrs:       envList("CLUSTER_ADDRS", ""),
+		ClusterTLSCAFile:   os.Getenv("CLUSTER_TLS_CA_FILE"),
+		ClusterTLSCertFile: os.Getenv("CLUSTER_TLS_CERT_FILE"),
+		ClusterTLSKeyFile:  os.Getenv("CLUSTER_TLS_KEY_FILE"),
+
+		Auth:                envBool("AUTH", false),
+		AuthKeysFile:        os.Getenv("AUTH_KEYS_FILE"),
+		AuthTokenSecretFile: os.Getenv("AUTH_TOKEN_SECRET_FILE"),
+
+		RateLimitRequests:   envFloat("RATE_LIMIT_REQUESTS", 0),
+		RateLimitBurst:      envInt("RATE_LIMIT_BURST", 0),
+		RateLimitBytes:      envInt("RATE_LIMIT_BYTES", 0),
+		RateLimitBytesBurst: envInt("RATE_LIMIT_BYTES_BURST", 0),
+
+		DataDir:      envString("DATA_DIR", "data"),
+		NodeID:       envString("NODE_ID", "local"),
+		ClusterNodes: envList("CLUSTER_NODES", ""),
+		AdvertiseURL: os.Getenv("ADVERTISE_URL"),
+		GossipSeeds:  envList("GOSSIP_SEEDS", ""),
+		Shards:       envInt("SHARDS", 1),
+		ShardVnodes:  envInt("SHARD_VNODES", 64),
+
+		Role:            envString("ROLE", "primary"),
+		PrimaryURL:      os.Getenv("PRIMARY_URL"),
+		PrimaryGRPCAddr: os.Getenv("PRIMARY_GRPC_ADDR"),
+		ChangelogSize:   envInt("CHANGELOG_SIZE", 100000),
+
+		ChangelogRetention:   envInt("CHANGELOG_RETENTION", 256<<20),
+		ChangelogSegmentSize: envInt("CHANGELOG_SEGMENT_SIZE", 16<<20),
+
+		KafkaBrokers: envList("KAFKA_BROKERS", ""),
+		KafkaTopic:   envString("KAFKA_TOPIC", "distrikv.changelog"),
+		KafkaFormat:  envString("KAFKA_FORMAT", "json"),
+		KafkaTimeout: envDuration("KAFKA_TIMEOUT", 10*time.Second),
+
+		OpenMode:          os.Getenv("OPEN_MODE"),
+		StartupCheck:      os.Getenv("STARTUP_CHECK"),
+		SSTSync:           envString("SST_SYNC", "sync"),
+		GarbageRatio:      envFloat("GARBAGE_RATIO", 0.5),
+		PurgeSchedule:     envString("PURGE_SCHEDULE", "0 3 * * *"),
+		PurgeTombstoneAge: envDuration("PURGE_TOMBSTONE_AGE", 24*time.Hour),
+		CompactionLevels:  os.Getenv("COMPACTION_LEVELS"),
+		Comparator:        envString("COMPARATOR", "bytewise"),
+		RetentionRules:    envList("RETENTION_RULES", ""),
+		LegacyRoutes:      envBool("LEGACY_ROUTES", true),
+
+		ScanDefaultLimit:  envInt("SCAN_DEFAULT_LIMIT", 100),
+		ScanMaxLimit:      envInt("SCAN_MAX_LIMIT", 1000),
+		MaxBatchSize:      envInt("MAX_BATCH_SIZE", 1000),
+		MaxKeySize:        envInt("MAX_KEY_SIZE", 64<<10),
+		MaxValueSize:      envInt("MAX_VALUE_SIZE", 32<<20),
+		CacheMaxSize:      envInt("CACHE_MAX_SIZE", 0),
+		RowCacheSize:      envInt("ROW_CACHE_SIZE", 8<<20),
+		NegativeCacheSize: envInt("NEGATIVE_CACHE_SIZE", 1<<20),
+		MinFreeSpace:      envInt("MIN_FREE_SPACE", 0),
+		VersionsRetained:  envInt("VERSIONS_RETAINED", 0),
+		VersionRetention:  envDuration("VERSION_RETENTION", 0),
+
+		TracingEndpoint:    os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		TracingServiceName: envString("OTEL_SERVICE_NAME", "distrikv"),
+		TracingSampleRatio: envFloat("OTEL_TRACES_SAMPLER_ARG", 1),
+
+		LogLevel:  envString("LOG_LEVEL", "info"),
+		LogLevels: envList("LOG_LEVELS", ""),
+		LogFormat: envString("LOG_FORMAT", "text"),
+	}
+}
+
+// TLS returns the TLS options of the client APIs.
+func (c Config) TLS() pkg.TLSOptions {
+	return pkg.TLSOptions{
+		CertFile:        c.TLSCertFile,
+		KeyFile:         c.TLSKeyFile,
+		AutocertDomains: c.TLSAutocertDomains,
+		AutocertDir:     filepath.Join(c.DataDir, "autocert"),
+		AutocertEmail:   c.TLSAutocertEmail,
+		MinVersion:      c.TLSMinVersion,
+		CipherSuites:    c.TLSCipherSuites,
+	}
+}
+
+// RateLimits returns the limits of every client.
+func (c Config) RateLimits() ratelimit.Limits {
+	return ratelimit.Limits{
+		Requests:     c.RateLimitRequests,
+		RequestBurst: c.RateLimitBurst,
+		Bytes:        float64(c.RateLimitBytes),
+		ByteBurst:    int64(c.RateLimitBytesBurst),
+	}
+}
+
+// KeysFile returns the path of the API keys file.
+func (c Config) KeysFile() string {
+	if c.AuthKeysFile != "" {
+		return c.AuthKeysFile
+	}
+
+	return filepath.Join(c.DataDir, "api-keys.json")
+}
+
+// ScanLimit returns the page size to use for a scan
+// requesting limit keys, zero requests the default.
+func (c Config) ScanLimit(limit int) int {
+	if limit <= 0 {
+		limit = c.ScanDefaultLimit
+	}
+
+	return min(limit, c.ScanMaxLimit)
+}
+
+// envString reads an environment variable,
+// returning def when it is unset.
+func envString(name string, def string) string {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	return v
+}
+
+// envList reads a comma separated environment variable,
+// returning def as a single item list when it is unset.
+func envList(name string, def string) []string {
+	var res []string
+	for _, v := range strings.Split(envString(name, def), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
+
+// envBool reads a boolean environment variable,
+// returning def when it is unset or invalid.
+func envBool(name string, def bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+
+	return v
+}
+
+// envInt reads an integer environment variable,
+// returning def when it is unset or invalid.
+func envInt(name string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+
+	return v
+}
+
+// envFloat reads a float environment variable,
+// returning def when it is unset or invalid.
+func envFloat(name string, def float64) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil {
+		return def
+	}
+
+	return v
+}
+
+// envDuration reads a duration environment variable,
+// returning def when it is unset or invalid.
+func envDuration(name string, def time.Duration) time.Duration {
+	v, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+
+	return v
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"flag"
+	"strings"
+)
+
+// RegisterFlags adds flags overriding the settings most often changed
+// per node, their defaults are the values read from the environment.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	listFlag(fs, &c.HTTPAddrs, "http", "client HTTP addresses, comma separated")
+	listFlag(fs, &c.GRPCAddrs, "grpc", "gRPC addresses, comma separated")
+	listFlag(fs, &c.RedisAddrs, "redis", "Redis protocol addresses, comma separated")
+	listFlag(fs, &c.ClusterNodes, "cluster-nodes", "id=url of every node of a static cluster, comma separated")
+	listFlag(fs, &c.GossipSeeds, "gossip-seeds", "URLs of nodes to learn the cluster from, comma separated")
+
+	fs.StringVar(&c.DataDir, "data-dir", c.DataDir, "directory of the shards, hints and backups")
+	fs.StringVar(&c.NodeID, "node-id", c.NodeID, "id of the node in the cluster")
+	fs.StringVar(&c.AdvertiseURL, "advertise-url", c.AdvertiseURL, "URL other nodes reach this node at")
+	fs.IntVar(&c.Shards, "shards", c.Shards, "number of shards of the cluster")
+	fs.StringVar(&c.Role, "role", c.Role, "primary or standby")
+	fs.StringVar(&c.PrimaryURL, "primary-url", c.PrimaryURL, "URL of the primary followed by a standby")
+	fs.StringVar(&c.PrimaryGRPCAddr, "primary-grpc", c.PrimaryGRPCAddr, "gRPC address of the primary a new standby copies")
+	fs.StringVar(&c.OpenMode, "open-mode", c.OpenMode, "fast or verified, verified checks every SST on start")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "debug, info, warn or error")
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "text or json")
+}
+
+// listFlag adds a flag of comma separated values.
+func listFlag(fs *flag.FlagSet, list *[]string, name string, usage string) {
+	if len(*list) > 0 {
+		usage += " (default " + strings.Join(*list, ",") + ")"
+	}
+
+	fs.Func(name, usage, func(v string) error {
+		*list = nil
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*list = append(*list, item)
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlagsOverrideEnvironment(t *testing.T) {
+	t.Setenv("HTTP_ADDRS", "127.0.0.1:7000")
+	t.Setenv("GRPC_PORT", "7001")
+	t.Setenv("NODE_ID", "env")
+	t.Setenv("DATA_DIR", "/env")
+
+	cfg := Load()
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	cfg.RegisterFlags(fs)
+
+	require.NoError(t, fs.Parse([]string{"-node-id", "b", "-http", "0.0.0.0:6090, [::]:6090", "-role", "standby"}))
+
+	// flags replace their setting, the others keep the environment's
+	assert.Equal(t, "b", cfg.NodeID)
+	assert.Equal(t, []string{"0.0.0.0:6090", "[::]:6090"}, cfg.HTTPAddrs)
+	assert.Equal(t, "standby", cfg.Role)
+	assert.Equal(t, []string{":7001"}, cfg.GRPCAddrs)
+	assert.Equal(t, "/env", cfg.DataDir)
+
+	// the usage tells the defaults of list flags
+	assert.Contains(t, fs.Lookup("grpc").Usage, "(default :7001)")
+	assert.ErrorIs(t, fs.Parse([]string{"-h"}), flag.ErrHelp)
+}
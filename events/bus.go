@@ -0,0 +1,147 @@
+package events
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Topic string
+
+const (
+	// TOPIC_FLUSH is published with a FlushEvent
+	// once a memtable is written to an SST.
+	TOPIC_FLUSH Topic = "flush"
+
+	// TOPIC_COMPACTION is published with a CompactionEvent
+	// once SSTs are merged into the next level.
+	TOPIC_COMPACTION Topic = "compaction"
+
+	// TOPIC_INGEST is published with an IngestEvent
+	// once an SST built outside a store was added to it.
+	TOPIC_INGEST Topic = "ingest"
+
+	// TOPIC_GARBAGE is published with a GarbageEvent once
+	// the dead entries of an SST reach the garbage ratio.
+	TOPIC_GARBAGE Topic = "garbage"
+
+	// TOPIC_MEMBERSHIP is published with a MemberEvent
+	// when a cluster member changes state.
+	TOPIC_MEMBERSHIP Topic = "membership"
+
+	// TOPIC_WRITE is published with a WriteEvent
+	// for every write applied to the node.
+	TOPIC_WRITE Topic = "write"
+)
+
+// DefaultBuffer is the channel buffer of a subscription.
+const DefaultBuffer = 64
+
+// WatchBuffer is the channel buffer of the subscriptions of client
+// watches, a watch falling further behind is ended.
+const WatchBuffer = 4096
+
+type Event struct {
+	Topic Topic
+	Time  time.Time
+	Data  any
+}
+
+// Subscription receives the events of its topics on C.
+// Events are dropped rather than blocking the publisher
+// when C is full, Dropped counts them.
+type Subscription struct {
+	C <-chan Event
+
+	c       chan Event
+	topics  []Topic
+	bus     *Bus
+	dropped atomic.Uint64
+	closed  bool
+}
+
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close unsubscribes and closes C.
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// Bus is an in-process publish/subscribe bus for internal notifications.
+// A nil *Bus discards every event.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[Topic][]*Subscription
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subs: make(map[Topic][]*Subscription),
+	}
+}
+
+// Subscribe returns a subscription to topics
+// with a channel buffer of buffer events.
+func (b *Bus) Subscribe(buffer int, topics ...Topic) *Subscription {
+	c := make(chan Event, buffer)
+	sub := &Subscription{
+		C:      c,
+		c:      c,
+		topics: topics,
+		bus:    b,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, topic := range topics {
+		b.subs[topic] = append(b.subs[topic], sub)
+	}
+
+	return sub
+}
+
+func (b *Bus) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if sub.closed {
+		return
+	}
+
+	for _, topic := range sub.topics {
+		b.subs[topic] = slices.DeleteFunc(b.subs[topic], func(s *Subscription) bool {
+			return s == sub
+		})
+	}
+
+	sub.closed = true
+	close(sub.c)
+}
+
+// Publish sends data to the subscribers of topic without blocking.
+func (b *Bus) Publish(topic Topic, data any) {
+	if b == nil {
+		return
+	}
+
+	event := Event{
+		Topic: topic,
+		Time:  time.Now(),
+		Data:  data,
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, sub := range b.subs[topic] {
+		select {
+		case sub.c <- event:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
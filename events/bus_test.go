@@ -0,0 +1,43 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+
+	sub := bus.Subscribe(1, TOPIC_FLUSH)
+	bus.Publish(TOPIC_FLUSH, FlushEvent{File: "a.sst"})
+	bus.Publish(TOPIC_COMPACTION, CompactionEvent{})
+
+	event := <-sub.C
+	assert.Equal(t, TOPIC_FLUSH, event.Topic)
+	assert.Equal(t, FlushEvent{File: "a.sst"}, event.Data)
+
+	// a full subscription drops instead of blocking
+	bus.Publish(TOPIC_FLUSH, FlushEvent{})
+	bus.Publish(TOPIC_FLUSH, FlushEvent{})
+	assert.Equal(t, uint64(1), sub.Dropped())
+
+	sub.Close()
+	bus.Publish(TOPIC_FLUSH, FlushEvent{})
+	<-sub.C
+	_, ok := <-sub.C
+	assert.False(t, ok)
+
+	// a nil bus discards events
+	var nilBus *Bus
+	nilBus.Publish(TOPIC_FLUSH, FlushEvent{})
+}
+
+func TestWriteEventMatches(t *testing.T) {
+	assert.True(t, WriteEvent{Op: "set", Key: "app/a"}.Matches("app/"))
+	assert.True(t, WriteEvent{Op: "delete", Key: "app/a"}.Matches(""))
+	assert.False(t, WriteEvent{Op: "set", Key: "other"}.Matches("app/"))
+
+	// a deleted range may cover keys of any prefix
+	assert.True(t, WriteEvent{Op: "delete_range", Key: "a", End: "b"}.Matches("app/"))
+}
@@ -0,0 +1,73 @@
+package events
+
+import (
+	"strings"
+	"time"
+)
+
+// FlushEvent is the data of TOPIC_FLUSH.
+// Dir is the directory of the store that flushed.
+type FlushEvent struct {
+	Dir      string
+	File     string
+	Duration time.Duration
+}
+
+// CompactionEvent is the data of TOPIC_COMPACTION,
+// Inputs of Level were merged into Outputs on OutputLevel,
+// none when every entry was dropped. Moved inputs are copied
+// to an output each without being merged. InputBytes and
+// OutputBytes are the sizes of the files read and written.
+type CompactionEvent struct {
+	Dir         string
+	Level       int
+	OutputLevel int
+	Inputs      []string
+	Outputs     []string
+	Moved       bool
+	InputBytes  int64
+	OutputBytes int64
+	Duration    time.Duration
+}
+
+// IngestEvent is the data of TOPIC_INGEST,
+// File was added to Level of the store in Dir.
+type IngestEvent struct {
+	Dir      string
+	File     string
+	Level    int
+	Duration time.Duration
+}
+
+// GarbageEvent is the data of TOPIC_GARBAGE, DeadBytes
+// of File on Level of the store in Dir are shadowed.
+type GarbageEvent struct {
+	Dir       string
+	File      string
+	Level     int
+	DeadBytes int64
+}
+
+// MemberEvent is the data of TOPIC_MEMBERSHIP.
+type MemberEvent struct {
+	ID    string
+	Addr  string
+	State string
+}
+
+// WriteEvent is the data of TOPIC_WRITE, Op is "set", "delete" or
+// "delete_range" of the keys in [Key, End).
+type WriteEvent struct {
+	Seq       uint64
+	Op        string
+	Key       string
+	End       string
+	Value     string
+	ExpiresAt time.Time
+}
+
+// Matches tells whether e writes keys starting with prefix. Deleted
+// ranges match every prefix, as they may cover keys starting with it.
+func (e WriteEvent) Matches(prefix string) bool {
+	return e.Op == "delete_range" || strings.HasPrefix(e.Key, prefix)
+}
@@ -0,0 +1,131 @@
+package grpc
+
+import (
+	"context"
+	"distrikv/auth"
+	"distrikv/pkg"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// methodRoles are the roles required by the methods,
+// others require the admin role.
+var methodRoles = map[string]string{
+	"/" + serviceName + "/Get":        auth.ROLE_READ,
+	"/" + serviceName + "/Scan":       auth.ROLE_READ,
+	"/" + serviceName + "/Watch":      auth.ROLE_READ,
+	"/" + serviceName + "/Set":        auth.ROLE_WRITE,
+	"/" + serviceName + "/Delete":     auth.ROLE_WRITE,
+	"/" + serviceName + "/BatchWrite": auth.ROLE_WRITE,
+}
+
+// authenticate returns the principal of the bearer credential of the
+// authorization metadata of ctx, peers presenting a certificate of the
+// cluster CA are authenticated by it.
+func authenticate(a *auth.Authenticator, ctx context.Context) (*auth.Principal, error) {
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && pkg.Verified(&tlsInfo.State) {
+			return auth.Peer, nil
+		}
+	}
+
+	var credential string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			credential = auth.BearerToken(values[0])
+		}
+	}
+
+	p, err := a.Authenticate(credential)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return p, nil
+}
+
+// authorize checks the role required by method and the keys of req.
+func authorize(p *auth.Principal, method string, req any) error {
+	role, ok := methodRoles[method]
+	if !ok {
+		role = auth.ROLE_ADMIN
+	}
+
+	var keys []string
+	switch req := req.(type) {
+	case *GetRequest:
+		keys = []string{req.Key}
+	case *SetRequest:
+		keys = []string{req.Key}
+	case *DeleteRequest:
+		keys = []string{req.Key}
+	case *BatchWriteRequest:
+		for _, op := range req.Ops {
+			keys = append(keys, op.Key)
+		}
+	}
+
+	if err := p.Authorize(role, keys...); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	return nil
+}
+
+func unaryAuth(a *auth.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		p, err := authenticate(a, ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := authorize(p, info.FullMethod, req); err != nil {
+			return nil, err
+		}
+
+		return handler(auth.NewContext(ctx, p), req)
+	}
+}
+
+// streamAuth checks the role of streaming methods, their handlers
+// filter what they send with the principal of the stream context.
+func streamAuth(a *auth.Authenticator) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		p, err := authenticate(a, stream.Context())
+		if err != nil {
+			return err
+		}
+
+		if err := authorize(p, info.FullMethod, nil); err != nil {
+			return err
+		}
+
+		return handler(srv, &principalStream{ServerStream: stream, ctx: auth.NewContext(stream.Context(), p)})
+	}
+}
+
+// principalStream is a stream whose context holds its principal.
+type principalStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *principalStream) Context() context.Context {
+	return s.ctx
+}
+
+// bearer sends a credential with every call.
+type bearer string
+
+func (b bearer) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(b)}, nil
+}
+
+func (b bearer) RequireTransportSecurity() bool {
+	return false
+}
@@ -0,0 +1,197 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Client is a client of the KV service.
+type Client struct {
+	conn *grpc.ClientConn
+}
+
+// NewClient connects to the server at addr, over TLS
+// when tlsConfig isn't nil, sending token with every call
+// when it isn't empty.
+func NewClient(addr string, tlsConfig *tls.Config, token string) (*Client, error) {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+	}
+
+	if token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(bearer(token)))
+	}
+
+	conn, err := grpc.NewClient(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		conn: conn,
+	}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) Get(ctx context.Context, req *GetRequest) (*GetResponse, error) {
+	res := new(GetResponse)
+	err := c.conn.Invoke(ctx, "/"+serviceName+"/Get", req, res)
+	return res, err
+}
+
+func (c *Client) Set(ctx context.Context, req *SetRequest) (*SetResponse, error) {
+	res := new(SetResponse)
+	err := c.conn.Invoke(ctx, "/"+serviceName+"/Set", req, res)
+	return res, err
+}
+
+func (c *Client) Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	res := new(DeleteResponse)
+	err := c.conn.Invoke(ctx, "/"+serviceName+"/Delete", req, res)
+	return res, err
+}
+
+func (c *Client) BatchWrite(ctx context.Context, req *BatchWriteRequest) (*BatchWriteResponse, error) {
+	res := new(BatchWriteResponse)
+	err := c.conn.Invoke(ctx, "/"+serviceName+"/BatchWrite", req, res)
+	return res, err
+}
+
+// Scan calls fn for every streamed key value until
+// the stream ends or fn returns false. The returned cursor
+// continues a scan capped by the server, it is empty on the last page.
+func (c *Client) Scan(ctx context.Context, req *ScanRequest, fn func(*KeyValue) bool) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.conn.NewStream(
+		ctx,
+		&serviceDesc.Streams[0],
+		"/"+serviceName+"/Scan",
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if err := stream.SendMsg(req); err != nil {
+		return "", err
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return "", err
+	}
+
+	for {
+		kv := new(KeyValue)
+		err := stream.RecvMsg(kv)
+		if errors.Is(err, io.EOF) {
+			var cursor string
+			if values := stream.Trailer().Get(nextCursorTrailer); len(values) > 0 {
+				cursor = values[0]
+			}
+
+			return cursor, nil
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		if !fn(kv) {
+			return "", nil
+		}
+	}
+}
+
+// Watch calls fn for every streamed write until the watch
+// ends, ctx is done or fn returns false.
+func (c *Client) Watch(ctx context.Context, req *WatchRequest, fn func(*WatchEvent) bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.conn.NewStream(
+		ctx,
+		&serviceDesc.Streams[1],
+		"/"+serviceName+"/Watch",
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := stream.SendMsg(req); err != nil {
+		return err
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	for {
+		event := new(WatchEvent)
+		err := stream.RecvMsg(event)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if !fn(event) {
+			return nil
+		}
+	}
+}
+
+// Snapshot calls fn for every chunk of a snapshot of the server.
+func (c *Client) Snapshot(ctx context.Context, fn func(*SnapshotChunk) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.conn.NewStream(
+		ctx,
+		&replicationServiceDesc.Streams[0],
+		"/"+replicationServiceName+"/Snapshot",
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := stream.SendMsg(&SnapshotRequest{}); err != nil {
+		return err
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	for {
+		chunk := new(SnapshotChunk)
+		err := stream.RecvMsg(chunk)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := fn(chunk); err != nil {
+			return err
+		}
+	}
+}
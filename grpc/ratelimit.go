@@ -0,0 +1,127 @@
+package grpc
+
+import (
+	"context"
+	"distrikv/auth"
+	"distrikv/pkg"
+	"distrikv/ratelimit"
+	"fmt"
+	"math"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// retryAfterTrailer carries the seconds to wait before retrying a
+// call rejected by the rate limit.
+const retryAfterTrailer = "retry-after"
+
+// limitKey returns the rate limit key of the caller of ctx,
+// empty for peers, which aren't limited.
+func limitKey(ctx context.Context) string {
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && pkg.Verified(&tlsInfo.State) {
+			return ""
+		}
+
+		addr = p.Addr.String()
+	}
+
+	return ratelimit.Key(auth.FromContext(ctx), addr)
+}
+
+func allow(ctx context.Context, l *ratelimit.Limiter, key string) error {
+	wait := l.Allow(key)
+	if wait == 0 {
+		return nil
+	}
+
+	grpc.SetTrailer(ctx, metadata.Pairs(retryAfterTrailer, strconv.Itoa(int(math.Ceil(wait.Seconds())))))
+	return status.Error(codes.ResourceExhausted, fmt.Sprintf("rate limit exceeded, retry in %s", wait))
+}
+
+// messageSize is the size charged for the keys and values of msg.
+func messageSize(msg any) int64 {
+	switch msg := msg.(type) {
+	case *GetRequest:
+		return int64(len(msg.Key))
+	case *GetResponse:
+		return int64(len(msg.Key) + len(msg.Value))
+	case *SetRequest:
+		return int64(len(msg.Key) + len(msg.Value))
+	case *DeleteRequest:
+		return int64(len(msg.Key))
+	case *BatchWriteRequest:
+		var n int
+		for _, op := range msg.Ops {
+			n += len(op.Key) + len(op.Value)
+		}
+
+		return int64(n)
+	case *ScanRequest:
+		return int64(len(msg.Start) + len(msg.End))
+	case *KeyValue:
+		return int64(len(msg.Key) + len(msg.Value))
+	default:
+		return 0
+	}
+}
+
+func unaryLimit(l *ratelimit.Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		key := limitKey(ctx)
+		if key == "" {
+			return handler(ctx, req)
+		}
+
+		if err := allow(ctx, l, key); err != nil {
+			return nil, err
+		}
+
+		res, err := handler(ctx, req)
+		l.Charge(key, messageSize(req)+messageSize(res))
+		return res, err
+	}
+}
+
+func streamLimit(l *ratelimit.Limiter) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		key := limitKey(stream.Context())
+		if key == "" {
+			return handler(srv, stream)
+		}
+
+		if err := allow(stream.Context(), l, key); err != nil {
+			return err
+		}
+
+		return handler(srv, &limitedStream{ServerStream: stream, limiter: l, key: key})
+	}
+}
+
+// limitedStream charges the messages of a stream.
+type limitedStream struct {
+	grpc.ServerStream
+	limiter *ratelimit.Limiter
+	key     string
+}
+
+func (s *limitedStream) SendMsg(m any) error {
+	s.limiter.Charge(s.key, messageSize(m))
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *limitedStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.limiter.Charge(s.key, messageSize(m))
+	}
+
+	return err
+}
@@ -0,0 +1,174 @@
+package grpc
+
+import (
+	"distrikv/pkg"
+	"distrikv/storage"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const replicationServiceName = "distrikv.Replication"
+
+const (
+	// snapshotChunkSize is the size of the file data of a chunk.
+	snapshotChunkSize = 1 << 20
+
+	// snapshotEntriesPerChunk is the number of memtable entries of a chunk.
+	snapshotEntriesPerChunk = 1000
+)
+
+// Snapshotter takes consistent snapshots of the local shards.
+type Snapshotter interface {
+	Snapshot(fn func(seq uint64, shards map[int]*storage.Snapshot) error) error
+}
+
+// ReplicationServer is the server API of the replication service.
+type ReplicationServer interface {
+	Snapshot(*SnapshotRequest, grpc.ServerStream) error
+}
+
+// ReplicationService streams snapshots to bootstrapping standbys.
+type ReplicationService struct {
+	snapshots Snapshotter
+}
+
+func NewReplicationService(snapshots Snapshotter) *ReplicationService {
+	return &ReplicationService{
+		snapshots: snapshots,
+	}
+}
+
+// Snapshot streams the sequence of the snapshot first,
+// then the SST files and memtable entries of every shard.
+func (s *ReplicationService) Snapshot(req *SnapshotRequest, stream grpc.ServerStream) error {
+	err := s.snapshots.Snapshot(func(seq uint64, shards map[int]*storage.Snapshot) error {
+		if err := stream.SendMsg(&SnapshotChunk{Seq: seq}); err != nil {
+			return err
+		}
+
+		var ids []int
+		for shard := range shards {
+			ids = append(ids, shard)
+		}
+		sort.Ints(ids)
+
+		for _, shard := range ids {
+			if err := sendSnapshot(stream, shard, shards[shard]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return statusError(err)
+	}
+
+	return nil
+}
+
+func sendSnapshot(stream grpc.ServerStream, shard int, snapshot *storage.Snapshot) error {
+	buf := make([]byte, snapshotChunkSize)
+
+	for _, file := range snapshot.Files {
+		f, err := os.Open(filepath.Join(snapshot.Dir, file))
+		if err != nil {
+			return err
+		}
+
+		for {
+			n, err := io.ReadFull(f, buf)
+			if n > 0 {
+				sendErr := stream.SendMsg(&SnapshotChunk{
+					Shard: int32(shard),
+					File:  file,
+					Data:  buf[:n],
+				})
+				if sendErr != nil {
+					f.Close()
+					return sendErr
+				}
+			}
+
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+
+			if err != nil {
+				f.Close()
+				return err
+			}
+		}
+
+		f.Close()
+	}
+
+	for entries := range slices.Chunk(snapshot.Entries, snapshotEntriesPerChunk) {
+		chunk := &SnapshotChunk{Shard: int32(shard)}
+		for _, entry := range entries {
+			chunk.Entries = append(chunk.Entries, &SnapshotEntry{
+				Key:     entry.Key,
+				Value:   []byte(entry.Value),
+				Deleted: entry.IsDeleted,
+
+				ExpiresAt: timestamp(entry.ExpiresAt),
+			})
+		}
+
+		if err := stream.SendMsg(chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// peersOnly rejects the replication calls of callers that didn't present
+// a certificate of the cluster CA, when the nodes use mTLS.
+func peersOnly(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if pkg.GetPeerTLS() == nil || !strings.HasPrefix(info.FullMethod, "/"+replicationServiceName+"/") {
+		return handler(srv, stream)
+	}
+
+	p, ok := peer.FromContext(stream.Context())
+	if !ok {
+		return status.Error(codes.Unauthenticated, "a certificate of the cluster ca is required")
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || !pkg.Verified(&tlsInfo.State) {
+		return status.Error(codes.Unauthenticated, "a certificate of the cluster ca is required")
+	}
+
+	return handler(srv, stream)
+}
+
+var replicationServiceDesc = grpc.ServiceDesc{
+	ServiceName: replicationServiceName,
+	HandlerType: (*ReplicationServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Snapshot",
+			ServerStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				req := new(SnapshotRequest)
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+
+				return srv.(ReplicationServer).Snapshot(req, stream)
+			},
+		},
+	},
+	Metadata: "grpc/kv.proto",
+}
@@ -0,0 +1,79 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"distrikv/auth"
+	"distrikv/config"
+	"distrikv/events"
+	"distrikv/pkg"
+	"distrikv/ratelimit"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Start serves the KV service on every configured address,
+// separately from the HTTP API, until ctx is done. The calls in
+// flight then get cfg.ShutdownDrainTimeout to finish, watches of the
+// writes published on bus end right away. The replication
+// service is served when snapshots isn't nil, over TLS when tlsConfig
+// isn't nil. Calls need a credential when authenticator isn't nil,
+// and are rate limited by limiter when it isn't nil.
+func Start(ctx context.Context, store Store, bus *events.Bus, snapshots Snapshotter, tlsConfig *tls.Config, authenticator *auth.Authenticator, limiter *ratelimit.Limiter, cfg config.Config) error {
+	listeners, err := pkg.Listen(cfg.GRPCAddrs)
+	if err != nil {
+		return err
+	}
+
+	server := newServer(ctx, store, bus, snapshots, tlsConfig, authenticator, limiter, cfg)
+
+	return pkg.ServeUntil(ctx, listeners, cfg.ShutdownDrainTimeout, server.Serve, func(ctx context.Context) error {
+		stopped := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+			return nil
+		case <-ctx.Done():
+			server.Stop()
+			return ctx.Err()
+		}
+	})
+}
+
+// newServer returns the server of the services of Start.
+func newServer(ctx context.Context, store Store, bus *events.Bus, snapshots Snapshotter, tlsConfig *tls.Config, authenticator *auth.Authenticator, limiter *ratelimit.Limiter, cfg config.Config) *grpc.Server {
+	unary := []grpc.UnaryServerInterceptor{unaryTracing}
+	stream := []grpc.StreamServerInterceptor{streamTracing, peersOnly}
+	if authenticator != nil {
+		unary = append(unary, unaryAuth(authenticator))
+		stream = append(stream, streamAuth(authenticator))
+	}
+
+	if limiter != nil {
+		unary = append(unary, unaryLimit(limiter))
+		stream = append(stream, streamLimit(limiter))
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	}
+
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	server := grpc.NewServer(opts...)
+	server.RegisterService(&serviceDesc, NewService(ctx, store, bus, cfg))
+
+	if snapshots != nil {
+		server.RegisterService(&replicationServiceDesc, NewReplicationService(snapshots))
+	}
+
+	return server
+}
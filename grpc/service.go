@@ -0,0 +1,345 @@
+package grpc
+
+import (
+	"context"
+	"distrikv/auth"
+	"distrikv/cluster"
+	"distrikv/config"
+	"distrikv/events"
+	"distrikv/pkg"
+	"distrikv/storage"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const serviceName = "distrikv.KV"
+
+// nextCursorTrailer is the trailer carrying
+// the continuation cursor of a capped scan.
+const nextCursorTrailer = "next-cursor"
+
+type Store interface {
+	Get(ctx context.Context, key string) (*storage.KVData, error)
+	Set(key string, value string) error
+	Delete(key string) error
+	SetWithTTL(key string, value string, ttl time.Duration) error
+	Write(ops []storage.BatchOp) error
+	Scan(ctx context.Context, start string, end string, fn func(*storage.KVData) bool) error
+}
+
+// KVServer is the server API of the KV service.
+type KVServer interface {
+	Get(context.Context, *GetRequest) (*GetResponse, error)
+	Set(context.Context, *SetRequest) (*SetResponse, error)
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	BatchWrite(context.Context, *BatchWriteRequest) (*BatchWriteResponse, error)
+	Scan(*ScanRequest, grpc.ServerStream) error
+	Watch(*WatchRequest, grpc.ServerStream) error
+}
+
+// Service implements the KV service on top of a Store.
+type Service struct {
+	store Store
+	cfg   config.Config
+
+	// events feed the watches, which end once stop is closed.
+	events *events.Bus
+	stop   <-chan struct{}
+}
+
+// NewService creates the KV service, the watches
+// of the writes of bus end when ctx is done.
+func NewService(ctx context.Context, store Store, bus *events.Bus, cfg config.Config) *Service {
+	return &Service{
+		store:  store,
+		cfg:    cfg,
+		events: bus,
+		stop:   ctx.Done(),
+	}
+}
+
+func (s *Service) Get(ctx context.Context, req *GetRequest) (*GetResponse, error) {
+	res, err := s.store.Get(ctx, req.Key)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return &GetResponse{
+		Key:   res.Key,
+		Value: []byte(res.Value),
+	}, nil
+}
+
+func (s *Service) Set(ctx context.Context, req *SetRequest) (*SetResponse, error) {
+	if req.TtlMs < 0 {
+		return nil, status.Error(codes.InvalidArgument, "ttl must be positive")
+	}
+
+	var err error
+	if req.TtlMs > 0 {
+		err = s.store.SetWithTTL(req.Key, string(req.Value), time.Duration(req.TtlMs)*time.Millisecond)
+	} else {
+		err = s.store.Set(req.Key, string(req.Value))
+	}
+
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return &SetResponse{}, nil
+}
+
+func (s *Service) Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	if err := s.store.Delete(req.Key); err != nil {
+		return nil, statusError(err)
+	}
+
+	return &DeleteResponse{}, nil
+}
+
+func (s *Service) BatchWrite(ctx context.Context, req *BatchWriteRequest) (*BatchWriteResponse, error) {
+	if len(req.Ops) > s.cfg.MaxBatchSize {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("batch exceeds %d ops", s.cfg.MaxBatchSize))
+	}
+
+	// validate every op before applying any of them
+	for _, op := range req.Ops {
+		if op.Type != OpType_OP_SET && op.Type != OpType_OP_DELETE {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("unknown op type %d", op.Type))
+		}
+	}
+
+	batch := storage.NewWriteBatch(s.store)
+	for _, op := range req.Ops {
+		switch op.Type {
+		case OpType_OP_SET:
+			batch.Put(op.Key, string(op.Value))
+		case OpType_OP_DELETE:
+			batch.Delete(op.Key)
+		}
+	}
+
+	if err := batch.Commit(); err != nil {
+		return nil, statusError(err)
+	}
+
+	return &BatchWriteResponse{}, nil
+}
+
+func (s *Service) Scan(req *ScanRequest, stream grpc.ServerStream) error {
+	start := req.Start
+
+	var after string
+	if req.Cursor != "" {
+		var err error
+		after, err = pkg.DecodeCursor(req.Cursor)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, "invalid cursor")
+		}
+
+		start = after
+	}
+
+	limit := s.cfg.ScanLimit(int(req.Limit))
+
+	// callers scoped to prefixes only see their keys
+	p := auth.FromContext(stream.Context())
+
+	var lastKey string
+	var count int
+	var sendErr error
+
+	// one extra key is read to know if the scan was capped
+	fn := func(data *storage.KVData) bool {
+		if p != nil && !p.CanAccess(data.Key) {
+			return true
+		}
+
+		if count == limit {
+			stream.SetTrailer(metadata.Pairs(nextCursorTrailer, pkg.EncodeCursor(lastKey)))
+			return false
+		}
+
+		sendErr = stream.SendMsg(&KeyValue{
+			Key:   data.Key,
+			Value: []byte(data.Value),
+		})
+		if sendErr != nil {
+			return false
+		}
+
+		lastKey = data.Key
+		count++
+		return true
+	}
+
+	// a continued scan starts after the last key of the previous page
+	if after != "" {
+		cmp, err := storage.ParseComparator(s.cfg.Comparator)
+		if err != nil {
+			return statusError(err)
+		}
+
+		fn = storage.After(cmp, after, fn)
+	}
+
+	if err := s.store.Scan(stream.Context(), start, req.End, fn); err != nil {
+		return statusError(err)
+	}
+
+	return sendErr
+}
+
+// Watch streams the writes of the keys starting with the prefix applied
+// on this node from the time of the call, callers scoped to prefixes only
+// see the writes of their keys. A watch falling events.WatchBuffer writes
+// behind fails with ResourceExhausted, and with Unavailable once the
+// server shuts down, the client then reads the keys again and watches anew.
+func (s *Service) Watch(req *WatchRequest, stream grpc.ServerStream) error {
+	if s.events == nil {
+		return status.Error(codes.Unimplemented, "watches are not enabled")
+	}
+
+	p := auth.FromContext(stream.Context())
+
+	sub := s.events.Subscribe(events.WatchBuffer, events.TOPIC_WRITE)
+	defer sub.Close()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.stop:
+			return status.Error(codes.Unavailable, "server shutting down")
+		case event := <-sub.C:
+			if sub.Dropped() > 0 {
+				return status.Error(codes.ResourceExhausted, fmt.Sprintf("watch fell more than %d writes behind", events.WatchBuffer))
+			}
+
+			data := event.Data.(events.WriteEvent)
+			if !data.Matches(req.Prefix) || (p != nil && !p.CanAccess(data.Key)) {
+				continue
+			}
+
+			err := stream.SendMsg(&WatchEvent{
+				Seq:       data.Seq,
+				Op:        data.Op,
+				Key:       data.Key,
+				End:       data.End,
+				Value:     []byte(data.Value),
+				ExpiresAt: timestamp(data.ExpiresAt),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// statusError maps storage errors to their status code.
+func statusError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrKeyNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, storage.ErrReadOnly),
+		errors.Is(err, storage.ErrDiskFull):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, storage.ErrKeyTooLarge),
+		errors.Is(err, storage.ErrValueTooLarge):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, cluster.ErrNotOwner):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// timestamp converts t to a protobuf timestamp, nil for the zero time
+// of the entries without expiry.
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+
+	return timestamppb.New(t)
+}
+
+// unaryMethod builds the method description of a unary call,
+// decoding the request and running it through the interceptor.
+func unaryMethod[Req any, Res any](
+	name string,
+	call func(KVServer, context.Context, *Req) (*Res, error),
+) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(
+			srv any,
+			ctx context.Context,
+			dec func(any) error,
+			interceptor grpc.UnaryServerInterceptor,
+		) (any, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+
+			if interceptor == nil {
+				return call(srv.(KVServer), ctx, req)
+			}
+
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: fmt.Sprintf("/%s/%s", serviceName, name),
+			}
+
+			return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+				return call(srv.(KVServer), ctx, req.(*Req))
+			})
+		},
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*KVServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod("Get", KVServer.Get),
+		unaryMethod("Set", KVServer.Set),
+		unaryMethod("Delete", KVServer.Delete),
+		unaryMethod("BatchWrite", KVServer.BatchWrite),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Scan",
+			ServerStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				req := new(ScanRequest)
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+
+				return srv.(KVServer).Scan(req, stream)
+			},
+		},
+		{
+			StreamName:    "Watch",
+			ServerStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				req := new(WatchRequest)
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+
+				return srv.(KVServer).Watch(req, stream)
+			},
+		},
+	},
+	Metadata: "grpc/kv.proto",
+}
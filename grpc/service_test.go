@@ -0,0 +1,258 @@
+package grpc
+
+import (
+	"context"
+	"distrikv/auth"
+	"distrikv/config"
+	"distrikv/storage"
+	"log/slog"
+	"maps"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// fakeSnapshotter takes empty snapshots.
+type fakeSnapshotter struct{}
+
+func (fakeSnapshotter) Snapshot(fn func(seq uint64, shards map[int]*storage.Snapshot) error) error {
+	return fn(0, nil)
+}
+
+// newTestServer serves the services over a store of a temporary
+// directory, authenticating calls when authenticator isn't nil,
+// and returns a func connecting a client with a token.
+func newTestServer(t *testing.T, authenticator *auth.Authenticator) func(token string) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	store, err := storage.Open(ctx, slog.New(slog.DiscardHandler), t.TempDir(), storage.OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close(context.Background()) })
+
+	cfg := config.Config{ScanDefaultLimit: 2, ScanMaxLimit: 2, MaxBatchSize: 10}
+	server := newServer(ctx, store, nil, fakeSnapshotter{}, nil, authenticator, nil, cfg)
+
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	return func(token string) *Client {
+		opts := []grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			}),
+		}
+
+		if token != "" {
+			opts = append(opts, grpc.WithPerRPCCredentials(bearer(token)))
+		}
+
+		conn, err := grpc.NewClient("passthrough:///bufconn", opts...)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+
+		return &Client{conn: conn}
+	}
+}
+
+func TestService(t *testing.T) {
+	ctx := context.Background()
+	client := newTestServer(t, nil)("")
+
+	_, err := client.Set(ctx, &SetRequest{Key: "a", Value: []byte("\x00\xff")})
+	require.NoError(t, err)
+
+	res, err := client.Get(ctx, &GetRequest{Key: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("\x00\xff"), res.Value)
+
+	_, err = client.BatchWrite(ctx, &BatchWriteRequest{Ops: []*WriteOp{
+		{Type: OpType_OP_SET, Key: "b", Value: []byte("2")},
+		{Type: OpType_OP_SET, Key: "c", Value: []byte("3")},
+		{Type: OpType_OP_DELETE, Key: "a"},
+	}})
+	require.NoError(t, err)
+
+	_, err = client.Get(ctx, &GetRequest{Key: "a"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.BatchWrite(ctx, &BatchWriteRequest{Ops: slices.Repeat([]*WriteOp{{Key: "a"}}, 11)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Set(ctx, &SetRequest{Key: "d", Value: []byte("4")})
+	require.NoError(t, err)
+
+	// pages of 2 keys are continued with the cursor of the trailer
+	var keys []string
+	var cursor string
+	for {
+		cursor, err = client.Scan(ctx, &ScanRequest{Cursor: cursor}, func(kv *KeyValue) bool {
+			keys = append(keys, kv.Key)
+			return true
+		})
+		require.NoError(t, err)
+
+		if cursor == "" {
+			break
+		}
+	}
+	assert.Equal(t, []string{"b", "c", "d"}, keys)
+
+	_, err = client.Scan(ctx, &ScanRequest{Cursor: "!"}, func(*KeyValue) bool { return true })
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestBatchWriteAppliesAllOrNothing(t *testing.T) {
+	ctx := context.Background()
+	client := newTestServer(t, nil)("")
+
+	for _, ops := range [][]*WriteOp{
+		{{Type: OpType_OP_SET, Key: "a", Value: []byte("1")}, {Type: OpType(7), Key: "b"}},
+		{{Type: OpType_OP_SET, Key: "a", Value: []byte("1")}, {Type: OpType_OP_SET, Key: strings.Repeat("b", storage.MaxKeySize+1)}},
+	} {
+		_, err := client.BatchWrite(ctx, &BatchWriteRequest{Ops: ops})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		_, err = client.Get(ctx, &GetRequest{Key: "a"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	}
+}
+
+func TestServiceDescriptors(t *testing.T) {
+	// the service descriptions serve the methods of kv.proto,
+	// the names protoc clients and grpcurl call
+	services := File_grpc_kv_proto.Services()
+	for _, desc := range []grpc.ServiceDesc{serviceDesc, replicationServiceDesc} {
+		service := services.ByName(protoreflect.FullName(desc.ServiceName).Name())
+		require.NotNil(t, service, desc.ServiceName)
+		assert.Equal(t, desc.ServiceName, string(service.FullName()))
+
+		var methods []string
+		for _, method := range desc.Methods {
+			methods = append(methods, method.MethodName)
+		}
+		for _, stream := range desc.Streams {
+			methods = append(methods, stream.StreamName)
+			assert.True(t, service.Methods().ByName(protoreflect.Name(stream.StreamName)).IsStreamingServer(), stream.StreamName)
+		}
+
+		var protoMethods []string
+		for i := range service.Methods().Len() {
+			protoMethods = append(protoMethods, string(service.Methods().Get(i).Name()))
+		}
+		assert.ElementsMatch(t, protoMethods, methods, desc.ServiceName)
+	}
+}
+
+func TestMethodRoles(t *testing.T) {
+	// every method of the KV service has a role, a method
+	// missing from methodRoles would require the admin role
+	var methods []string
+	for _, method := range serviceDesc.Methods {
+		methods = append(methods, "/"+serviceName+"/"+method.MethodName)
+	}
+	for _, stream := range serviceDesc.Streams {
+		methods = append(methods, "/"+serviceName+"/"+stream.StreamName)
+	}
+	assert.ElementsMatch(t, methods, slices.Collect(maps.Keys(methodRoles)))
+
+	reader := &auth.Principal{Name: "reader", Role: auth.ROLE_READ}
+	writer := &auth.Principal{Name: "writer", Role: auth.ROLE_WRITE, Prefixes: []string{"app/"}}
+	admin := &auth.Principal{Name: "admin", Role: auth.ROLE_ADMIN}
+
+	tests := []struct {
+		principal *auth.Principal
+		method    string
+		req       any
+		allowed   bool
+	}{
+		{reader, "Get", &GetRequest{Key: "a"}, true},
+		{reader, "Scan", nil, true},
+		{reader, "Watch", nil, true},
+		{reader, "Set", &SetRequest{Key: "a"}, false},
+		{reader, "Delete", &DeleteRequest{Key: "a"}, false},
+		{reader, "BatchWrite", &BatchWriteRequest{}, false},
+		{writer, "Set", &SetRequest{Key: "app/a"}, true},
+		{writer, "Set", &SetRequest{Key: "other/a"}, false},
+		{writer, "Get", &GetRequest{Key: "other/a"}, false},
+		{writer, "Delete", &DeleteRequest{Key: "other/a"}, false},
+		{writer, "BatchWrite", &BatchWriteRequest{Ops: []*WriteOp{{Key: "app/a"}, {Key: "app/b"}}}, true},
+		{writer, "BatchWrite", &BatchWriteRequest{Ops: []*WriteOp{{Key: "app/a"}, {Key: "other/b"}}}, false},
+		{admin, "Set", &SetRequest{Key: "a"}, true},
+	}
+
+	for _, tt := range tests {
+		err := authorize(tt.principal, "/"+serviceName+"/"+tt.method, tt.req)
+		if tt.allowed {
+			assert.NoError(t, err, "%s %s", tt.principal.Name, tt.method)
+		} else {
+			assert.Equal(t, codes.PermissionDenied, status.Code(err), "%s %s", tt.principal.Name, tt.method)
+		}
+	}
+
+	// unlisted methods, the snapshots of standbys, need the admin role
+	snapshot := "/" + replicationServiceName + "/Snapshot"
+	assert.Equal(t, codes.PermissionDenied, status.Code(authorize(writer, snapshot, nil)))
+	assert.NoError(t, authorize(admin, snapshot, nil))
+}
+
+func TestServiceAuthorization(t *testing.T) {
+	ctx := context.Background()
+	keysFile := filepath.Join(t.TempDir(), "keys")
+
+	addKey := func(name string, role string, prefixes ...string) string {
+		key, err := auth.AddKey(keysFile, name, role, prefixes)
+		require.NoError(t, err)
+		return key
+	}
+	adminKey := addKey("admin", auth.ROLE_ADMIN)
+	readerKey := addKey("reader", auth.ROLE_READ, "app/")
+
+	authenticator, err := auth.New(slog.New(slog.DiscardHandler), keysFile, nil)
+	require.NoError(t, err)
+
+	connect := newTestServer(t, authenticator)
+	admin, reader := connect(adminKey), connect(readerKey)
+
+	_, err = connect("").Get(ctx, &GetRequest{Key: "app/a"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = connect("dkv_unknown").Get(ctx, &GetRequest{Key: "app/a"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	for _, key := range []string{"app/a", "other/a"} {
+		_, err = admin.Set(ctx, &SetRequest{Key: key, Value: []byte("1")})
+		require.NoError(t, err)
+	}
+
+	_, err = reader.Get(ctx, &GetRequest{Key: "app/a"})
+	assert.NoError(t, err)
+
+	_, err = reader.Get(ctx, &GetRequest{Key: "other/a"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = reader.Set(ctx, &SetRequest{Key: "app/a", Value: []byte("2")})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// scans skip the keys out of the prefixes of the caller
+	var keys []string
+	_, err = reader.Scan(ctx, &ScanRequest{}, func(kv *KeyValue) bool {
+		keys = append(keys, kv.Key)
+		return true
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app/a"}, keys)
+}
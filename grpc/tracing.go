@@ -0,0 +1,62 @@
+package grpc
+
+import (
+	"context"
+	"distrikv/tracing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// startSpan starts a server span of method, continuing
+// the trace of the traceparent metadata of the caller.
+func startSpan(ctx context.Context, method string) (context.Context, *tracing.Span) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(tracing.TraceparentHeader); len(values) > 0 {
+			if sc, ok := tracing.ParseTraceparent(values[0]); ok {
+				ctx = tracing.ContextWithSpanContext(ctx, sc)
+			}
+		}
+	}
+
+	return tracing.Start(
+		ctx,
+		method,
+		tracing.SPAN_KIND_SERVER,
+		tracing.Attr{Key: "rpc.system", Value: "grpc"},
+		tracing.Attr{Key: "rpc.method", Value: method},
+	)
+}
+
+func endSpan(span *tracing.Span, err error) {
+	span.SetAttr("rpc.grpc.status_code", int(status.Code(err)))
+	span.SetError(err)
+	span.End()
+}
+
+func unaryTracing(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, span := startSpan(ctx, info.FullMethod)
+	res, err := handler(ctx, req)
+	endSpan(span, err)
+
+	return res, err
+}
+
+func streamTracing(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, span := startSpan(stream.Context(), info.FullMethod)
+	err := handler(srv, &tracedStream{ServerStream: stream, ctx: ctx})
+	endSpan(span, err)
+
+	return err
+}
+
+// tracedStream is a stream whose context holds its span.
+type tracedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tracedStream) Context() context.Context {
+	return s.ctx
+}
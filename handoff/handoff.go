@@ -0,0 +1,261 @@
+package handoff
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"distrikv/pkg"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxHintAge is how long a hint is kept for a node that stays down,
+// older hints are dropped instead of replayed.
+var MaxHintAge = 24 * time.Hour
+
+const (
+	replayInterval = 5 * time.Second
+
+	hintFileFormat = ".hints"
+)
+
+// Hint is a write for a node that was unreachable,
+// stored to be replayed once the node is back.
+type Hint struct {
+	Node        string    `json:"node"`
+	Addr        string    `json:"addr"`
+	Method      string    `json:"method"`
+	URI         string    `json:"uri"`
+	ContentType string    `json:"content_type,omitempty"`
+	Body        []byte    `json:"body,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Hints is the hint log of the node, kept as one
+// JSON lines file per unreachable node in dir.
+type Hints struct {
+	logger *slog.Logger
+	dir    string
+	client *http.Client
+
+	// mu serializes appends with the rewrite of a replayed file.
+	mu sync.Mutex
+}
+
+func New(logger *slog.Logger, dir string) (*Hints, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Hints{
+		logger: logger,
+		dir:    dir,
+		client: &http.Client{Timeout: 5 * time.Second, Transport: pkg.PeerTransport()},
+	}, nil
+}
+
+func (h *Hints) path(node string) string {
+	return filepath.Join(h.dir, url.PathEscape(node)+hintFileFormat)
+}
+
+// Add appends hint to the hint log of its node.
+func (h *Hints) Add(hint Hint) error {
+	line, err := json.Marshal(hint)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, err := os.OpenFile(h.path(hint.Node), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// Pending returns the number of hints stored for every node.
+func (h *Hints) Pending() (map[string]int, error) {
+	files, err := filepath.Glob(filepath.Join(h.dir, "*"+hintFileFormat))
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	res := make(map[string]int)
+	for _, file := range files {
+		hints, err := readHints(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(hints) > 0 {
+			res[hints[0].Node] = len(hints)
+		}
+	}
+
+	return res, nil
+}
+
+// Start replays the stored hints until ctx is done.
+func (h *Hints) Start(ctx context.Context) {
+	ticker := time.NewTicker(replayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		files, err := filepath.Glob(filepath.Join(h.dir, "*"+hintFileFormat))
+		if err != nil {
+			h.logger.Error("error listing hint files", "err", err)
+			continue
+		}
+
+		for _, file := range files {
+			if err := h.replay(ctx, file); err != nil {
+				h.logger.Error("error replaying hints", "file", file, "err", err)
+			}
+		}
+	}
+}
+
+// replay sends the hints of file in order and keeps
+// the ones that couldn't be delivered yet.
+func (h *Hints) replay(ctx context.Context, file string) error {
+	h.mu.Lock()
+	hints, err := readHints(file)
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	var sent int
+	for _, hint := range hints {
+		if time.Since(hint.CreatedAt) > MaxHintAge {
+			h.logger.Warn("dropping expired hint", "node", hint.Node, "uri", hint.URI)
+			sent++
+			continue
+		}
+
+		if err := h.send(ctx, hint); err != nil {
+			h.logger.Debug("node still unreachable", "node", hint.Node, "err", err)
+			break
+		}
+
+		sent++
+	}
+
+	if sent == 0 {
+		return nil
+	}
+
+	h.logger.Info("replayed hints", "file", filepath.Base(file), "hints", sent)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// hints added while replaying follow the ones read before
+	current, err := readHints(file)
+	if err != nil {
+		return err
+	}
+
+	return writeHints(file, current[sent:])
+}
+
+func (h *Hints) send(ctx context.Context, hint Hint) error {
+	req, err := http.NewRequestWithContext(ctx, hint.Method, strings.TrimSuffix(hint.Addr, "/")+hint.URI, bytes.NewReader(hint.Body))
+	if err != nil {
+		return err
+	}
+
+	if hint.ContentType != "" {
+		req.Header.Set("Content-Type", hint.ContentType)
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// the node is back, client errors won't succeed on a retry
+	if res.StatusCode >= 500 {
+		return fmt.Errorf("node responded with %s", res.Status)
+	}
+
+	return nil
+}
+
+func readHints(file string) ([]Hint, error) {
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hints []Hint
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var hint Hint
+		if err := json.Unmarshal(scanner.Bytes(), &hint); err != nil {
+			return nil, fmt.Errorf("invalid hint: %w", err)
+		}
+
+		hints = append(hints, hint)
+	}
+
+	return hints, scanner.Err()
+}
+
+// writeHints replaces file with hints, removing it once empty.
+func writeHints(file string, hints []Hint) error {
+	if len(hints) == 0 {
+		return os.Remove(file)
+	}
+
+	var buf bytes.Buffer
+	for _, hint := range hints {
+		line, err := json.Marshal(hint)
+		if err != nil {
+			return err
+		}
+
+		buf.Write(append(line, '\n'))
+	}
+
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,134 @@
+package handoff
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNode records the writes it gets, answering 503 while down.
+type fakeNode struct {
+	mu     sync.Mutex
+	down   bool
+	writes []string
+}
+
+func (n *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	n.writes = append(n.writes, r.Method+" "+r.RequestURI+" "+string(body))
+}
+
+func (n *fakeNode) setDown(down bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.down = down
+}
+
+func newHints(t *testing.T) *Hints {
+	h, err := New(slog.New(slog.DiscardHandler), t.TempDir())
+	require.NoError(t, err)
+
+	return h
+}
+
+func TestHintsReplayedInOrder(t *testing.T) {
+	node := &fakeNode{down: true}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	h := newHints(t)
+	for _, hint := range []Hint{
+		{Method: http.MethodPut, URI: "/v1/keys/a", Body: []byte("1")},
+		{Method: http.MethodPut, URI: "/v1/keys/b", Body: []byte("2")},
+		{Method: http.MethodDelete, URI: "/v1/keys/a"},
+	} {
+		hint.Node, hint.Addr, hint.CreatedAt = "b/1", server.URL, time.Now()
+		require.NoError(t, h.Add(hint))
+	}
+
+	pending, err := h.Pending()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"b/1": 3}, pending)
+
+	// the hints are kept while the node is down
+	require.NoError(t, h.replay(context.Background(), h.path("b/1")))
+	pending, err = h.Pending()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"b/1": 3}, pending)
+	assert.Empty(t, node.writes)
+
+	node.setDown(false)
+	require.NoError(t, h.replay(context.Background(), h.path("b/1")))
+	assert.Equal(t, []string{"PUT /v1/keys/a 1", "PUT /v1/keys/b 2", "DELETE /v1/keys/a "}, node.writes)
+
+	pending, err = h.Pending()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	_, err = os.Stat(h.path("b/1"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestHintsAddedWhileReplaying(t *testing.T) {
+	var h *Hints
+	var sent int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first hint is delivered, the node is down again for the second
+		sent++
+		if sent == 1 {
+			assert.NoError(t, h.Add(Hint{Node: "b", Addr: "http://" + r.Host, Method: http.MethodPut, URI: "/v1/keys/c", CreatedAt: time.Now()}))
+			return
+		}
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	h = newHints(t)
+	for _, uri := range []string{"/v1/keys/a", "/v1/keys/b"} {
+		require.NoError(t, h.Add(Hint{Node: "b", Addr: server.URL, Method: http.MethodPut, URI: uri, CreatedAt: time.Now()}))
+	}
+
+	require.NoError(t, h.replay(context.Background(), h.path("b")))
+
+	hints, err := readHints(h.path("b"))
+	require.NoError(t, err)
+	require.Len(t, hints, 2)
+	assert.Equal(t, "/v1/keys/b", hints[0].URI)
+	assert.Equal(t, "/v1/keys/c", hints[1].URI)
+}
+
+func TestExpiredHintsDropped(t *testing.T) {
+	node := &fakeNode{}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	h := newHints(t)
+	require.NoError(t, h.Add(Hint{Node: "b", Addr: server.URL, Method: http.MethodPut, URI: "/v1/keys/a", CreatedAt: time.Now().Add(-MaxHintAge - time.Minute)}))
+	require.NoError(t, h.Add(Hint{Node: "b", Addr: server.URL, Method: http.MethodPut, URI: "/v1/keys/b", CreatedAt: time.Now()}))
+
+	require.NoError(t, h.replay(context.Background(), h.path("b")))
+	assert.Equal(t, []string{"PUT /v1/keys/b "}, node.writes)
+
+	pending, err := h.Pending()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
@@ -1,28 +1,101 @@
 package main
 
 import (
-	"context"
-	"distrikv/api"
-	"distrikv/storage"
-	"log/slog"
+	"distrikv/cli"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
+	"strings"
 )
 
+// command is a subcommand of the binary.
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"server", "run a node, the default without command", runServer},
+	{"cli", "interactive shell of a node or shard directory", func(args []string) error {
+		return cli.Shell(args, os.Stdin, os.Stdout)
+	}},
+	{"cluster", "manage the nodes and shards of a cluster", func(args []string) error {
+		return cli.Cluster(args, os.Stdout)
+	}},
+	{"auth", "manage API keys and issue tokens", func(args []string) error {
+		return cli.Auth(args, os.Stdout)
+	}},
+	{"backup", "create, list, verify and restore backups", func(args []string) error {
+		return cli.Backup(args, os.Stdout)
+	}},
+	{"bench", "run a benchmark workload against a store or node", func(args []string) error {
+		return cli.Bench(args, os.Stdout)
+	}},
+	{"export", "write the keys of a data directory as ndjson or csv", func(args []string) error {
+		return cli.Export(args, os.Stdout)
+	}},
+	{"import", "load ndjson or csv into a shard directory", func(args []string) error {
+		return cli.Import(args, os.Stdout)
+	}},
+	{"sst", "inspect SST files", func(args []string) error {
+		return cli.SST(args, os.Stdout)
+	}},
+	{"wal", "inspect WAL files", func(args []string) error {
+		return cli.WAL(args, os.Stdout)
+	}},
+	{"verify", "check the SSTs of a data directory", func(args []string) error {
+		return cli.Verify(args, os.Stdout)
+	}},
+	{"repair", "salvage the SSTs of a damaged data directory", func(args []string) error {
+		return cli.Repair(args, os.Stdout)
+	}},
+}
+
 func main() {
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	args := os.Args[1:]
+
+	// flags alone configure the server
+	name := "server"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
 
-	sstManager, err := storage.NewSSTManager(logger)
-	if err != nil {
-		panic(err)
+	if name == "help" {
+		fmt.Fprint(os.Stdout, usage())
+		return
 	}
 
-	go sstManager.StartCleaner(context.Background())
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
 
-	compactorManager := storage.NewCompactorManager(logger, sstManager)
+		err := cmd.run(args)
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
 
-	compactorManager.StartCompactors(context.Background())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitFailed)
+		}
 
-	store := storage.NewStore(logger, sstManager)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage())
+	os.Exit(2)
+}
+
+func usage() string {
+	var b strings.Builder
+	b.WriteString("usage: distrikv <command> [flags]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "  %-10s %s\n", cmd.name, cmd.usage)
+	}
 
-	api.Start(&store)
+	b.WriteString("\n\"distrikv <command> -h\" lists the flags of a command.\n")
+	return b.String()
 }
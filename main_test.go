@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageListsCommands(t *testing.T) {
+	text := usage()
+	for _, cmd := range commands {
+		assert.Contains(t, text, cmd.name+" ")
+		assert.Contains(t, text, cmd.usage)
+	}
+}
+
+func TestServerFlags(t *testing.T) {
+	t.Setenv("DATA_DIR", t.TempDir())
+
+	// the server is configured by flags only, it takes no arguments
+	assert.ErrorContains(t, runServer([]string{"-node-id", "a", "extra"}), `unexpected arguments ["extra"]`)
+	assert.Error(t, runServer([]string{"-unknown"}))
+	assert.ErrorIs(t, runServer([]string{"-h"}), flag.ErrHelp)
+}
@@ -0,0 +1,401 @@
+package membership
+
+import (
+	"bytes"
+	"context"
+	"distrikv/events"
+	"distrikv/pkg"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// members gossiped with on every round
+	gossipFanout = 3
+
+	gossipInterval = time.Second
+
+	// a member whose heartbeat didn't advance for suspectTimeout
+	// is suspected, and removed from the ring after deadTimeout.
+	suspectTimeout = 5 * time.Second
+	deadTimeout    = 15 * time.Second
+)
+
+type State int
+
+// Member States
+//
+// [STATE_ALIVE] -> (1) -> [STATE_SUSPECT] -> (2) -> [STATE_DEAD]
+//
+// 1. The heartbeat of the member didn't advance for suspectTimeout.
+// Suspected members keep their shards, so a slow member doesn't move data.
+//
+// 2. The heartbeat didn't advance for deadTimeout, the member leaves the ring.
+// Any newer heartbeat makes the member alive again.
+//
+// STATE_LEFT is gossiped by members leaving gracefully.
+const (
+	STATE_ALIVE State = iota
+
+	STATE_SUSPECT
+
+	STATE_DEAD
+
+	STATE_LEFT
+)
+
+func (s State) String() string {
+	switch s {
+	case STATE_SUSPECT:
+		return "suspect"
+	case STATE_DEAD:
+		return "dead"
+	case STATE_LEFT:
+		return "left"
+	default:
+		return "alive"
+	}
+}
+
+// Member is the gossiped state of a node.
+// Heartbeat is only ever advanced by the node itself.
+type Member struct {
+	ID        string `json:"id"`
+	Addr      string `json:"addr"`
+	Heartbeat uint64 `json:"heartbeat"`
+	Left      bool   `json:"left,omitempty"`
+}
+
+// MemberStatus is the local view of a member.
+type MemberStatus struct {
+	Member
+	State    string    `json:"state"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type member struct {
+	Member
+	state    State
+	lastSeen time.Time
+}
+
+// GossipMessage is the body exchanged on POST /internal/gossip.
+type GossipMessage struct {
+	Members []Member `json:"members"`
+}
+
+// Membership discovers the members of the cluster by periodically
+// exchanging member tables with random peers, starting from seeds.
+type Membership struct {
+	logger *slog.Logger
+	client *http.Client
+	events *events.Bus
+	self   string
+	seeds  []string
+
+	mu      sync.Mutex
+	members map[string]*member
+
+	// ring is the last set of members passed to onChange.
+	ring     []Member
+	onChange func([]Member)
+}
+
+// New creates the membership of node id reachable at addr,
+// member state changes are published on bus.
+func New(logger *slog.Logger, id string, addr string, seeds []string, bus *events.Bus) *Membership {
+	m := &Membership{
+		logger:  logger,
+		events:  bus,
+		client:  &http.Client{Timeout: gossipInterval, Transport: pkg.PeerTransport()},
+		self:    id,
+		seeds:   seeds,
+		members: make(map[string]*member),
+	}
+
+	// heartbeats start at the wall clock, so a restarted
+	// node overtakes the heartbeat peers remember of it.
+	m.members[id] = &member{
+		Member: Member{
+			ID:        id,
+			Addr:      addr,
+			Heartbeat: uint64(time.Now().UnixMilli()),
+		},
+		lastSeen: time.Now(),
+	}
+
+	return m
+}
+
+// OnChange registers fn to be called with the ring members,
+// the alive and suspected ones, whenever they change.
+func (m *Membership) OnChange(fn func([]Member)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onChange = fn
+}
+
+// Start gossips until ctx is done.
+func (m *Membership) Start(ctx context.Context) {
+	ticker := time.NewTicker(gossipInterval)
+	defer ticker.Stop()
+
+	for {
+		m.round(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Join gossips once with the seeds and returns the ring members,
+// so a starting node knows the cluster before opening its shards.
+func (m *Membership) Join(ctx context.Context) []Member {
+	m.round(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.ringMembers()
+}
+
+// Leave marks this node as left and tells its peers.
+func (m *Membership) Leave(ctx context.Context) {
+	m.mu.Lock()
+	self := m.members[m.self]
+	self.Heartbeat++
+	self.Left = true
+	self.state = STATE_LEFT
+	m.mu.Unlock()
+
+	m.round(ctx)
+}
+
+// Gossip merges the members sent by a peer and returns the local table.
+func (m *Membership) Gossip(members []Member) []Member {
+	m.merge(members)
+
+	return m.table()
+}
+
+func (m *Membership) Members() []MemberStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var res []MemberStatus
+	for _, mem := range m.members {
+		res = append(res, MemberStatus{
+			Member:   mem.Member,
+			State:    mem.state.String(),
+			LastSeen: mem.lastSeen,
+		})
+	}
+
+	sort.Slice(res, func(a, b int) bool {
+		return res[a].ID < res[b].ID
+	})
+
+	return res
+}
+
+// round advances the own heartbeat, updates the failure detector
+// and exchanges the member table with random peers.
+func (m *Membership) round(ctx context.Context) {
+	m.mu.Lock()
+	m.members[m.self].Heartbeat++
+	m.members[m.self].lastSeen = time.Now()
+	m.detect()
+	m.mu.Unlock()
+	m.notify()
+
+	for _, addr := range m.peers() {
+		members, err := m.send(ctx, addr)
+		if err != nil {
+			m.logger.Debug("error gossiping", "peer", addr, "err", err)
+			continue
+		}
+
+		m.merge(members)
+	}
+}
+
+// peers picks the addresses gossiped with on this round,
+// seeds are used until another member is known.
+func (m *Membership) peers() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var addrs []string
+	for _, mem := range m.members {
+		if mem.ID != m.self && mem.state != STATE_LEFT {
+			addrs = append(addrs, mem.Addr)
+		}
+	}
+
+	if len(addrs) == 0 {
+		addrs = slices.Clone(m.seeds)
+	}
+
+	rand.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+
+	return addrs[:min(gossipFanout, len(addrs))]
+}
+
+func (m *Membership) send(ctx context.Context, addr string) ([]Member, error) {
+	body, err := json.Marshal(GossipMessage{Members: m.table()})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, addr+"/internal/gossip", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer responded with %s", res.Status)
+	}
+
+	var msg GossipMessage
+	if err := json.NewDecoder(res.Body).Decode(&msg); err != nil {
+		return nil, err
+	}
+
+	return msg.Members, nil
+}
+
+func (m *Membership) table() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var res []Member
+	for _, mem := range m.members {
+		res = append(res, mem.Member)
+	}
+
+	return res
+}
+
+// merge keeps the members with a newer heartbeat than the local one.
+func (m *Membership) merge(members []Member) {
+	m.mu.Lock()
+	for _, remote := range members {
+		// only this node advances its own heartbeat
+		if remote.ID == m.self {
+			continue
+		}
+
+		local, ok := m.members[remote.ID]
+		if ok && remote.Heartbeat <= local.Heartbeat {
+			continue
+		}
+
+		if !ok {
+			m.logger.Info("member joined", "id", remote.ID, "addr", remote.Addr)
+		}
+
+		state := STATE_ALIVE
+		if remote.Left {
+			state = STATE_LEFT
+			m.logger.Info("member left", "id", remote.ID)
+		}
+
+		if !ok || local.state != state {
+			m.publish(remote, state)
+		}
+
+		m.members[remote.ID] = &member{
+			Member:   remote,
+			state:    state,
+			lastSeen: time.Now(),
+		}
+	}
+	m.mu.Unlock()
+
+	m.notify()
+}
+
+// detect updates the state of members whose heartbeat stopped.
+// Must be called with mu held.
+func (m *Membership) detect() {
+	for _, mem := range m.members {
+		if mem.ID == m.self || mem.state == STATE_LEFT {
+			continue
+		}
+
+		since := time.Since(mem.lastSeen)
+
+		switch {
+		case since >= deadTimeout && mem.state != STATE_DEAD:
+			mem.state = STATE_DEAD
+			m.logger.Warn("member is dead", "id", mem.ID)
+			m.publish(mem.Member, mem.state)
+		case since >= suspectTimeout && since < deadTimeout && mem.state != STATE_SUSPECT:
+			mem.state = STATE_SUSPECT
+			m.logger.Warn("member is suspected", "id", mem.ID)
+			m.publish(mem.Member, mem.state)
+		}
+	}
+}
+
+func (m *Membership) publish(mem Member, state State) {
+	m.events.Publish(events.TOPIC_MEMBERSHIP, events.MemberEvent{
+		ID:    mem.ID,
+		Addr:  mem.Addr,
+		State: state.String(),
+	})
+}
+
+// notify calls onChange when the ring members changed.
+func (m *Membership) notify() {
+	m.mu.Lock()
+
+	ring := m.ringMembers()
+	if slices.Equal(ring, m.ring) || m.onChange == nil {
+		m.mu.Unlock()
+		return
+	}
+
+	m.ring = ring
+	onChange := m.onChange
+	m.mu.Unlock()
+
+	onChange(ring)
+}
+
+// ringMembers returns the alive and suspected members sorted by id.
+// Must be called with mu held.
+func (m *Membership) ringMembers() []Member {
+	var ring []Member
+	for _, mem := range m.members {
+		if mem.state == STATE_ALIVE || mem.state == STATE_SUSPECT {
+			ring = append(ring, Member{ID: mem.ID, Addr: mem.Addr})
+		}
+	}
+
+	sort.Slice(ring, func(a, b int) bool {
+		return ring[a].ID < ring[b].ID
+	})
+
+	return ring
+}
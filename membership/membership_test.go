@@ -0,0 +1,44 @@
+package membership
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGossipMerge(t *testing.T) {
+	m := New(slog.New(slog.NewTextHandler(io.Discard, nil)), "a", "http://a", nil, nil)
+
+	var ring []Member
+	m.OnChange(func(members []Member) {
+		ring = members
+	})
+
+	m.Gossip([]Member{{ID: "b", Addr: "http://b", Heartbeat: 10}})
+	assert.Equal(t, []Member{{ID: "a", Addr: "http://a"}, {ID: "b", Addr: "http://b"}}, ring)
+
+	// stale heartbeats don't override the local view
+	m.Gossip([]Member{{ID: "b", Addr: "http://b", Heartbeat: 5, Left: true}})
+	assert.Len(t, ring, 2)
+
+	m.Gossip([]Member{{ID: "b", Addr: "http://b", Heartbeat: 11, Left: true}})
+	assert.Equal(t, []Member{{ID: "a", Addr: "http://a"}}, ring)
+}
+
+func TestFailureDetection(t *testing.T) {
+	m := New(slog.New(slog.NewTextHandler(io.Discard, nil)), "a", "http://a", nil, nil)
+	m.Gossip([]Member{{ID: "b", Addr: "http://b", Heartbeat: 1}})
+
+	m.mu.Lock()
+	m.members["b"].lastSeen = time.Now().Add(-suspectTimeout)
+	m.detect()
+	assert.Equal(t, STATE_SUSPECT, m.members["b"].state)
+
+	m.members["b"].lastSeen = time.Now().Add(-deadTimeout)
+	m.detect()
+	assert.Equal(t, STATE_DEAD, m.members["b"].state)
+	m.mu.Unlock()
+}
@@ -0,0 +1,288 @@
+// Package ops tracks the long-running operations of a node,
+// like shard moves and manual compactions, so they can be
+// listed and canceled through the admin API.
+package ops
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUnknownOperation error = errors.New("operation does not exist")
+	ErrFinished         error = errors.New("operation already finished")
+)
+
+// history is the number of finished operations kept.
+const history = 100
+
+type State int
+
+// Operation States
+//
+// [OP_RUNNING] -> [OP_DONE], [OP_FAILED] or [OP_CANCELED]
+//
+// An operation is canceled when it stopped after Cancel was called,
+// even if it returned an error.
+const (
+	OP_RUNNING State = iota
+
+	OP_DONE
+
+	OP_FAILED
+
+	OP_CANCELED
+)
+
+func (s State) String() string {
+	switch s {
+	case OP_DONE:
+		return "done"
+	case OP_FAILED:
+		return "failed"
+	case OP_CANCELED:
+		return "canceled"
+	default:
+		return "running"
+	}
+}
+
+// Operation is the status of a long-running operation. Done and
+// Total count the units of work of the kind, Total is 0 when unknown.
+type Operation struct {
+	ID          string    `json:"id"`
+	Kind        string    `json:"kind"`
+	Description string    `json:"description"`
+	State       string    `json:"state"`
+	Done        int64     `json:"done"`
+	Total       int64     `json:"total"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at,omitzero"`
+	Error       string    `json:"error,omitempty"`
+}
+
+type operation struct {
+	Operation
+	cancel context.CancelFunc
+
+	// done is closed once the operation finished.
+	done chan struct{}
+}
+
+// Registry runs long operations and keeps their status,
+// the running ones and the latest finished ones.
+type Registry struct {
+	logger *slog.Logger
+
+	mu  sync.Mutex
+	ops []*operation
+	wg  sync.WaitGroup
+}
+
+func NewRegistry(logger *slog.Logger) *Registry {
+	return &Registry{
+		logger: logger,
+	}
+}
+
+// Progress reports the progress of an operation.
+type Progress struct {
+	r  *Registry
+	op *operation
+}
+
+// Set sets the units of work done and the total, 0 when unknown.
+func (p *Progress) Set(done int64, total int64) {
+	p.r.mu.Lock()
+	defer p.r.mu.Unlock()
+
+	p.op.Done = done
+	p.op.Total = total
+}
+
+// Add adds n units of work done.
+func (p *Progress) Add(n int64) {
+	p.r.mu.Lock()
+	defer p.r.mu.Unlock()
+
+	p.op.Done += n
+}
+
+// Start runs fn in a new goroutine as an operation of kind, fn must
+// return once its context is canceled.
+func (r *Registry) Start(kind string, description string, fn func(ctx context.Context, p *Progress) error) Operation {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	op := &operation{
+		Operation: Operation{
+			ID:          uuid.NewString(),
+			Kind:        kind,
+			Description: description,
+			State:       OP_RUNNING.String(),
+			StartedAt:   time.Now(),
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	r.mu.Lock()
+	r.ops = append(r.ops, op)
+	r.trim()
+	status := op.Operation
+	r.mu.Unlock()
+
+	r.logger.Info("operation started", "id", op.ID, "kind", kind, "description", description)
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer cancel()
+
+		err := fn(ctx, &Progress{r: r, op: op})
+		r.finish(op, ctx.Err() != nil, err)
+	}()
+
+	return status
+}
+
+func (r *Registry) finish(op *operation, canceled bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	op.FinishedAt = time.Now()
+
+	switch {
+	case canceled:
+		op.State = OP_CANCELED.String()
+	case err != nil:
+		op.State = OP_FAILED.String()
+	default:
+		op.State = OP_DONE.String()
+	}
+
+	if err != nil {
+		op.Error = err.Error()
+	}
+
+	close(op.done)
+
+	r.logger.Info("operation finished", "id", op.ID, "kind", op.Kind, "state", op.State, "duration", op.FinishedAt.Sub(op.StartedAt), "err", err)
+}
+
+// trim drops the oldest finished operations beyond the history.
+// Must be called with mu held.
+func (r *Registry) trim() {
+	finished := 0
+	for _, op := range r.ops {
+		if op.State != OP_RUNNING.String() {
+			finished++
+		}
+	}
+
+	kept := r.ops[:0]
+	for _, op := range r.ops {
+		if finished > history && op.State != OP_RUNNING.String() {
+			finished--
+			continue
+		}
+
+		kept = append(kept, op)
+	}
+
+	r.ops = kept
+}
+
+// List returns the operations, oldest first.
+func (r *Registry) List() []Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := make([]Operation, 0, len(r.ops))
+	for _, op := range r.ops {
+		res = append(res, op.Operation)
+	}
+
+	return res
+}
+
+func (r *Registry) Get(id string) (Operation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	op, err := r.find(id)
+	if err != nil {
+		return Operation{}, err
+	}
+
+	return op.Operation, nil
+}
+
+// Wait returns operation id once it finished,
+// or the error of ctx when it's done first.
+func (r *Registry) Wait(ctx context.Context, id string) (Operation, error) {
+	r.mu.Lock()
+	op, err := r.find(id)
+	r.mu.Unlock()
+	if err != nil {
+		return Operation{}, err
+	}
+
+	select {
+	case <-op.done:
+	case <-ctx.Done():
+		return Operation{}, ctx.Err()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return op.Operation, nil
+}
+
+// Cancel stops a running operation, it's
+// canceled once its func returned.
+func (r *Registry) Cancel(id string) (Operation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	op, err := r.find(id)
+	if err != nil {
+		return Operation{}, err
+	}
+
+	if op.State != OP_RUNNING.String() {
+		return op.Operation, ErrFinished
+	}
+
+	op.cancel()
+	r.logger.Info("operation cancel requested", "id", id, "kind", op.Kind)
+
+	return op.Operation, nil
+}
+
+// find returns the operation id. Must be called with mu held.
+func (r *Registry) find(id string) (*operation, error) {
+	for _, op := range r.ops {
+		if op.ID == id {
+			return op, nil
+		}
+	}
+
+	return nil, ErrUnknownOperation
+}
+
+// Close cancels the running operations and waits until they returned.
+func (r *Registry) Close() {
+	r.mu.Lock()
+	for _, op := range r.ops {
+		op.cancel()
+	}
+	r.mu.Unlock()
+
+	r.wg.Wait()
+}
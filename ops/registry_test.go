@@ -0,0 +1,55 @@
+package ops
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	started := make(chan struct{})
+	running := r.Start("test", "waits until canceled", func(ctx context.Context, p *Progress) error {
+		p.Set(1, 4)
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	failed := r.Start("test", "fails", func(ctx context.Context, p *Progress) error {
+		return errors.New("boom")
+	})
+
+	<-started
+	op, err := r.Get(running.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, OP_RUNNING.String(), op.State)
+	assert.Equal(t, int64(1), op.Done)
+	assert.Equal(t, int64(4), op.Total)
+
+	_, err = r.Cancel(running.ID)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		op, _ := r.Get(running.ID)
+		return op.State == OP_CANCELED.String()
+	}, time.Second, time.Millisecond)
+
+	op, err = r.Wait(context.Background(), failed.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, OP_FAILED.String(), op.State)
+	assert.Equal(t, "boom", op.Error)
+
+	_, err = r.Cancel(failed.ID)
+	assert.ErrorIs(t, err, ErrFinished)
+
+	_, err = r.Get("missing")
+	assert.ErrorIs(t, err, ErrUnknownOperation)
+
+	assert.Len(t, r.List(), 2)
+}
@@ -0,0 +1,364 @@
+package perf
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// Workloads of a benchmark.
+const (
+	// WORKLOAD_FILLSEQ writes every key once, in key order.
+	WORKLOAD_FILLSEQ = "fillseq"
+
+	// WORKLOAD_FILLRANDOM writes random keys.
+	WORKLOAD_FILLRANDOM = "fillrandom"
+
+	// WORKLOAD_READRANDOM reads random keys.
+	WORKLOAD_READRANDOM = "readrandom"
+
+	// WORKLOAD_READWRITE reads and writes random keys,
+	// BenchOptions.ReadPercent of the operations are reads.
+	WORKLOAD_READWRITE = "readwrite"
+)
+
+// Workloads lists the workloads in the order they are documented.
+var Workloads = []string{WORKLOAD_FILLSEQ, WORKLOAD_FILLRANDOM, WORKLOAD_READRANDOM, WORKLOAD_READWRITE}
+
+// BenchTarget is the store a benchmark runs against,
+// an embedded store or a node.
+type BenchTarget interface {
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key string, value string) error
+}
+
+// BenchOptions configure a benchmark. Zero values get defaults.
+type BenchOptions struct {
+	Workload string
+
+	// Duration bounds the run, fillseq also stops once every key is written.
+	Duration time.Duration
+	Workers  int
+
+	// Keys is the number of distinct keys.
+	Keys      int
+	KeySize   int
+	ValueSize int
+
+	// ReadPercent is the share of reads of the readwrite workload.
+	ReadPercent int
+
+	// SkipFill doesn't write the keys before the reading workloads,
+	// for targets already holding them.
+	SkipFill bool
+
+	// IsNotFound tells missing keys from failed reads, nil counts every
+	// read error as an error.
+	IsNotFound func(err error) bool
+}
+
+func (o *BenchOptions) setDefaults() {
+	if o.Workload == "" {
+		o.Workload = WORKLOAD_FILLRANDOM
+	}
+
+	if o.Duration == 0 {
+		o.Duration = 10 * time.Second
+	}
+
+	if o.Workers == 0 {
+		o.Workers = 16
+	}
+
+	if o.Keys == 0 {
+		o.Keys = 100000
+	}
+
+	if o.KeySize == 0 {
+		o.KeySize = 16
+	}
+
+	if o.ValueSize == 0 {
+		o.ValueSize = 100
+	}
+
+	if o.ReadPercent == 0 {
+		o.ReadPercent = 50
+	}
+}
+
+func (o *BenchOptions) validate() error {
+	if !slices.Contains(Workloads, o.Workload) {
+		return fmt.Errorf("unknown workload %q, one of %v", o.Workload, Workloads)
+	}
+
+	if o.ReadPercent < 0 || o.ReadPercent > 100 {
+		return fmt.Errorf("read percent %d is not between 0 and 100", o.ReadPercent)
+	}
+
+	if o.Workers < 0 || o.Keys < 0 || o.KeySize < 0 || o.ValueSize < 0 {
+		return fmt.Errorf("workers, keys, key size and value size can't be negative")
+	}
+
+	return nil
+}
+
+// Latency is the distribution of the latencies of an operation.
+type Latency struct {
+	Count int64
+	Mean  time.Duration
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+	P999  time.Duration
+	Max   time.Duration
+}
+
+func newLatency(latencies []time.Duration) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+
+	slices.Sort(latencies)
+
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+
+	return Latency{
+		Count: int64(len(latencies)),
+		Mean:  total / time.Duration(len(latencies)),
+		P50:   percentile(latencies, 0.50),
+		P95:   percentile(latencies, 0.95),
+		P99:   percentile(latencies, 0.99),
+		P999:  percentile(latencies, 0.999),
+		Max:   latencies[len(latencies)-1],
+	}
+}
+
+// BenchReport is the result of a benchmark.
+type BenchReport struct {
+	Options BenchOptions `json:"-"`
+
+	Workload string
+	Elapsed  time.Duration
+	Ops      int64
+
+	// Misses are reads of missing keys, they count as operations.
+	Misses int64
+	Errors int64
+
+	// Error is the first failed operation.
+	Error string `json:",omitempty"`
+
+	// Throughput is the number of successful operations per second.
+	Throughput float64
+
+	Reads  Latency
+	Writes Latency
+
+	// Fill is the time spent writing the keys before the run.
+	Fill time.Duration `json:",omitempty"`
+}
+
+// Bench runs a workload against target from opts.Workers goroutines
+// for opts.Duration and reports the throughput and the latencies of
+// reads and writes. The reading workloads first write every key, in
+// key order, unless opts.SkipFill is set.
+func Bench(ctx context.Context, target BenchTarget, opts BenchOptions) (*BenchReport, error) {
+	opts.setDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	report := &BenchReport{
+		Options:  opts,
+		Workload: opts.Workload,
+	}
+
+	reads := opts.Workload == WORKLOAD_READRANDOM || opts.Workload == WORKLOAD_READWRITE
+	if reads && !opts.SkipFill {
+		start := time.Now()
+		fill := opts
+		fill.Workload = WORKLOAD_FILLSEQ
+		fill.Duration = 0
+
+		load := runBench(ctx, target, fill)
+		if load.errors > 0 {
+			return nil, fmt.Errorf("%d writes of the fill failed: %w", load.errors, load.err)
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		report.Fill = time.Since(start)
+	}
+
+	load := runBench(ctx, target, opts)
+
+	report.Elapsed = load.elapsed
+	report.Ops = load.ops
+	report.Misses = load.misses
+	report.Errors = load.errors
+	if load.err != nil {
+		report.Error = load.err.Error()
+	}
+	report.Reads = newLatency(load.reads)
+	report.Writes = newLatency(load.writes)
+	if load.elapsed > 0 {
+		report.Throughput = float64(load.ops) / load.elapsed.Seconds()
+	}
+
+	return report, nil
+}
+
+// benchLoad is the outcome of the workers of a benchmark.
+type benchLoad struct {
+	elapsed time.Duration
+	ops     int64
+	misses  int64
+	errors  int64
+
+	// err is the first error.
+	err error
+
+	reads  []time.Duration
+	writes []time.Duration
+}
+
+// runBench runs the workload of opts until its duration, or for
+// fillseq until every key is written. A zero duration runs fillseq
+// to the end.
+func runBench(ctx context.Context, target BenchTarget, opts BenchOptions) *benchLoad {
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+
+	// fillseq hands out the keys in order
+	var next atomic.Int64
+
+	res := &benchLoad{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for range opts.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var reads, writes []time.Duration
+			var ops, misses, errs int64
+			var firstErr error
+
+			for ctx.Err() == nil {
+				i := rand.IntN(max(opts.Keys, 1))
+				if opts.Workload == WORKLOAD_FILLSEQ {
+					i = int(next.Add(1) - 1)
+					if i >= opts.Keys {
+						break
+					}
+				}
+
+				key := benchKey(i, opts.KeySize)
+				read := opts.Workload == WORKLOAD_READRANDOM ||
+					(opts.Workload == WORKLOAD_READWRITE && rand.IntN(100) < opts.ReadPercent)
+
+				sent := time.Now()
+				var err error
+				if read {
+					_, err = target.Get(ctx, key)
+				} else {
+					err = target.Set(ctx, key, randomValue(opts.ValueSize))
+				}
+
+				// operations canceled at the end of the run aren't counted
+				if ctx.Err() != nil {
+					break
+				}
+
+				latency := time.Since(sent)
+				if read {
+					reads = append(reads, latency)
+				} else {
+					writes = append(writes, latency)
+				}
+
+				switch {
+				case err == nil:
+					ops++
+				case read && opts.IsNotFound != nil && opts.IsNotFound(err):
+					ops++
+					misses++
+				default:
+					errs++
+					if firstErr == nil {
+						firstErr = err
+					}
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			res.ops += ops
+			res.misses += misses
+			res.errors += errs
+			res.reads = append(res.reads, reads...)
+			res.writes = append(res.writes, writes...)
+			if res.err == nil {
+				res.err = firstErr
+			}
+		}()
+	}
+
+	wg.Wait()
+	res.elapsed = time.Since(start)
+
+	return res
+}
+
+// benchKey returns the i-th key, zero padded to size
+// so that keys sort in the order of their numbers.
+func benchKey(i int, size int) string {
+	return fmt.Sprintf("%0*d", size, i)
+}
+
+// Write prints the report as text.
+func (r *BenchReport) Write(out io.Writer) {
+	if r.Fill > 0 {
+		fmt.Fprintf(out, "filled %d keys in %s\n", r.Options.Keys, r.Fill.Round(time.Millisecond))
+	}
+
+	fmt.Fprintf(out, "%s: %d ops in %s, %.0f ops/s, %d misses, %d errors\n",
+		r.Workload, r.Ops, r.Elapsed.Round(time.Millisecond), r.Throughput, r.Misses, r.Errors)
+	if r.Error != "" {
+		fmt.Fprintf(out, "first error: %s\n", r.Error)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "\nOP\tCOUNT\tMEAN\tP50\tP95\tP99\tP99.9\tMAX")
+	for _, op := range []struct {
+		name    string
+		latency Latency
+	}{{"read", r.Reads}, {"write", r.Writes}} {
+		l := op.latency
+		if l.Count == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n", op.name, l.Count, l.Mean, l.P50, l.P95, l.P99, l.P999, l.Max)
+	}
+}
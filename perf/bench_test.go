@@ -0,0 +1,105 @@
+package perf
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errBenchNotFound = errors.New("not found")
+
+// mapTarget is a BenchTarget keeping its keys in a map.
+type mapTarget struct {
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+func (t *mapTarget) Get(_ context.Context, key string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	value, ok := t.keys[key]
+	if !ok {
+		return "", errBenchNotFound
+	}
+
+	return value, nil
+}
+
+func (t *mapTarget) Set(_ context.Context, key string, value string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.keys[key] = value
+	return nil
+}
+
+func TestBenchFillSeq(t *testing.T) {
+	target := &mapTarget{keys: map[string]string{}}
+
+	report, err := Bench(context.Background(), target, BenchOptions{
+		Workload:  WORKLOAD_FILLSEQ,
+		Duration:  10 * time.Second,
+		Workers:   4,
+		Keys:      1000,
+		KeySize:   8,
+		ValueSize: 10,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1000), report.Ops)
+	assert.Equal(t, int64(1000), report.Writes.Count)
+	assert.Zero(t, report.Reads.Count)
+	assert.Len(t, target.keys, 1000)
+	assert.Equal(t, strings.Repeat("0", 5)+"999", benchKey(999, 8))
+	assert.Len(t, target.keys[benchKey(999, 8)], 10)
+}
+
+func TestBenchReadWrite(t *testing.T) {
+	target := &mapTarget{keys: map[string]string{}}
+
+	report, err := Bench(context.Background(), target, BenchOptions{
+		Workload:    WORKLOAD_READWRITE,
+		Duration:    100 * time.Millisecond,
+		Workers:     2,
+		Keys:        100,
+		ReadPercent: 90,
+		IsNotFound:  func(err error) bool { return errors.Is(err, errBenchNotFound) },
+	})
+	require.NoError(t, err)
+
+	var out strings.Builder
+	report.Write(&out)
+	t.Log("\n" + out.String())
+
+	assert.Positive(t, report.Fill)
+	assert.Zero(t, report.Misses)
+	assert.Zero(t, report.Errors)
+	assert.Equal(t, report.Ops, report.Reads.Count+report.Writes.Count)
+	assert.Greater(t, report.Reads.Count, report.Writes.Count)
+	assert.LessOrEqual(t, report.Reads.P50, report.Reads.P99)
+}
+
+func TestBenchReadMisses(t *testing.T) {
+	report, err := Bench(context.Background(), &mapTarget{keys: map[string]string{}}, BenchOptions{
+		Workload:   WORKLOAD_READRANDOM,
+		Duration:   50 * time.Millisecond,
+		Workers:    1,
+		SkipFill:   true,
+		IsNotFound: func(err error) bool { return errors.Is(err, errBenchNotFound) },
+	})
+	require.NoError(t, err)
+
+	assert.Zero(t, report.Fill)
+	assert.Positive(t, report.Misses)
+	assert.Equal(t, report.Ops, report.Misses)
+
+	_, err = Bench(context.Background(), &mapTarget{}, BenchOptions{Workload: "scan"})
+	assert.ErrorContains(t, err, "unknown workload")
+}
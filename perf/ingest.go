@@ -0,0 +1,536 @@
+// Package perf drives a node in-process to measure its performance.
+package perf
+
+import (
+	"context"
+	"distrikv/api"
+	"distrikv/cluster"
+	"distrikv/config"
+	"distrikv/events"
+	"distrikv/replication"
+	"distrikv/storage"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Stages of the ingest path, in the order a write goes through them.
+const (
+	// STAGE_HTTP is the time of a request outside of the replicator:
+	// client, connection, routing and JSON decoding.
+	STAGE_HTTP = "http"
+
+	// STAGE_CHANGELOG is the time in the replicator outside of the
+	// store: waiting for earlier writes and logging the change.
+	STAGE_CHANGELOG = "changelog"
+
+	// STAGE_MEMTABLE is the time of the store write, including
+	// waits for the flusher to take a full memtable.
+	STAGE_MEMTABLE = "memtable"
+
+	STAGE_FLUSH      = "flush"
+	STAGE_COMPACTION = "compaction"
+)
+
+// IngestOptions configure an ingest run. Zero values get defaults.
+type IngestOptions struct {
+	// DataDir is the data directory of the node, a temporary
+	// directory removed afterwards when empty.
+	DataDir string
+
+	// Rate is the number of writes per second, 0 writes as fast as possible.
+	Rate     int
+	Duration time.Duration
+	Workers  int
+
+	// Keys is the number of distinct keys written.
+	Keys      int
+	ValueSize int
+	Shards    int
+
+	// MemtableSize overrides storage.MemtableSizeThreshold during the run.
+	MemtableSize int
+
+	// ProfileDir receives cpu.pprof and heap.pprof when set.
+	ProfileDir string
+}
+
+func (o *IngestOptions) setDefaults() {
+	if o.Duration == 0 {
+		o.Duration = 10 * time.Second
+	}
+
+	if o.Workers == 0 {
+		o.Workers = 16
+	}
+
+	if o.Keys == 0 {
+		o.Keys = 100000
+	}
+
+	if o.ValueSize == 0 {
+		o.ValueSize = 100
+	}
+
+	if o.Shards == 0 {
+		o.Shards = 1
+	}
+}
+
+// Stage is the time spent in a stage of the ingest path. Busy is the
+// share of the time of the writers, for the stages of requests, or of
+// the background goroutines of the shards, for flushes and compactions.
+type Stage struct {
+	Name  string
+	Count int64
+	Total time.Duration
+	Mean  time.Duration
+	Busy  float64
+}
+
+// IngestReport is the result of an ingest run.
+type IngestReport struct {
+	Options IngestOptions
+	Elapsed time.Duration
+	Writes  int64
+	Errors  int64
+
+	// Throughput is the number of successful writes per second.
+	Throughput float64
+
+	// Latency percentiles of the requests.
+	P50 time.Duration
+	P99 time.Duration
+	Max time.Duration
+
+	Stages []Stage
+
+	// Bottleneck is the busiest stage.
+	Bottleneck string
+
+	// Profiles are the paths of the written profiles.
+	Profiles []string
+}
+
+// stageTimer sums the durations of a stage.
+type stageTimer struct {
+	mu    sync.Mutex
+	count int64
+	total time.Duration
+}
+
+func (t *stageTimer) add(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.count++
+	t.total += d
+}
+
+func (t *stageTimer) get() (int64, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.count, t.total
+}
+
+// timedStore times the writes of a store.
+type timedStore struct {
+	replication.Store
+	timer *stageTimer
+}
+
+func (s *timedStore) Set(key string, value string) error {
+	start := time.Now()
+	defer func() { s.timer.add(time.Since(start)) }()
+
+	return s.Store.Set(key, value)
+}
+
+// timedAPIStore times the writes of the replicator.
+type timedAPIStore struct {
+	api.Store
+	timer *stageTimer
+}
+
+func (s *timedAPIStore) Set(key string, value string) error {
+	start := time.Now()
+	defer func() { s.timer.add(time.Since(start)) }()
+
+	return s.Store.Set(key, value)
+}
+
+// Ingest writes random keys through the HTTP API of a node started in
+// process, from the handler to the memtables, flushes and compactions,
+// and reports where the time went. Writes are logged by the replication
+// changelog, the write path has no other log.
+func Ingest(ctx context.Context, opts IngestOptions) (*IngestReport, error) {
+	opts.setDefaults()
+
+	if opts.DataDir == "" {
+		dir, err := os.MkdirTemp("", "distrikv-ingest-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+
+		opts.DataDir = dir
+	}
+
+	if opts.MemtableSize > 0 {
+		defer func(size int) { storage.MemtableSizeThreshold = size }(storage.MemtableSizeThreshold)
+		storage.MemtableSizeThreshold = opts.MemtableSize
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	bus := events.NewBus()
+
+	cfg := config.Config{
+		DataDir:          opts.DataDir,
+		NodeID:           "local",
+		Shards:           opts.Shards,
+		ShardVnodes:      64,
+		Role:             "primary",
+		ChangelogSize:    100000,
+		Comparator:       "bytewise",
+		ScanDefaultLimit: 100,
+		ScanMaxLimit:     1000,
+		MaxBatchSize:     1000,
+	}
+
+	// background stages are timed from their events
+	flushes, compactions := &stageTimer{}, &stageTimer{}
+	sub := bus.Subscribe(4096, events.TOPIC_FLUSH, events.TOPIC_COMPACTION)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range sub.C {
+			switch data := event.Data.(type) {
+			case events.FlushEvent:
+				flushes.add(data.Duration)
+			case events.CompactionEvent:
+				compactions.add(data.Duration)
+			}
+		}
+	}()
+
+	c, err := cluster.New(logger, cfg, nil, func(dir string) (*storage.Store, error) {
+		return storage.Open(ctx, logger, dir, storage.OPEN_FAST, bus, storage.BytewiseComparator, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Shutdown(context.WithoutCancel(ctx))
+
+	memtable := &stageTimer{}
+	replicator, err := replication.New(logger, &timedStore{Store: c, timer: memtable}, cfg, bus)
+	if err != nil {
+		return nil, err
+	}
+
+	replicate := &stageTimer{}
+	gin.DefaultWriter = io.Discard
+	router := api.NewRouter(ctx, api.Deps{
+		Store:       &timedAPIStore{Store: replicator, timer: replicate},
+		Cluster:     c,
+		Replication: replicator,
+		Events:      bus,
+	}, cfg)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{Handler: router}
+	go server.Serve(l)
+	defer server.Close()
+
+	var profiles []string
+	if opts.ProfileDir != "" {
+		path := filepath.Join(opts.ProfileDir, "cpu.pprof")
+		stop, err := startCPUProfile(path)
+		if err != nil {
+			return nil, err
+		}
+		defer stop()
+
+		profiles = append(profiles, path)
+	}
+
+	load := runLoad(ctx, "http://"+l.Addr().String(), opts)
+
+	if opts.ProfileDir != "" {
+		path := filepath.Join(opts.ProfileDir, "heap.pprof")
+		if err := writeHeapProfile(path); err != nil {
+			return nil, err
+		}
+
+		profiles = append(profiles, path)
+	}
+
+	sub.Close()
+	<-done
+
+	report := &IngestReport{
+		Options:  opts,
+		Elapsed:  load.elapsed,
+		Writes:   load.writes,
+		Errors:   load.errors,
+		Profiles: profiles,
+	}
+
+	if load.elapsed > 0 {
+		report.Throughput = float64(load.writes) / load.elapsed.Seconds()
+	}
+
+	if len(load.latencies) > 0 {
+		slices.Sort(load.latencies)
+		report.P50 = percentile(load.latencies, 0.50)
+		report.P99 = percentile(load.latencies, 0.99)
+		report.Max = load.latencies[len(load.latencies)-1]
+	}
+
+	// stages of requests are nested, each excludes the next one
+	requests := int64(len(load.latencies))
+	replicateCount, replicateTotal := replicate.get()
+	memtableCount, memtableTotal := memtable.get()
+	flushCount, flushTotal := flushes.get()
+	compactionCount, compactionTotal := compactions.get()
+
+	writers := load.elapsed * time.Duration(opts.Workers)
+	shards := load.elapsed * time.Duration(opts.Shards)
+
+	report.Stages = []Stage{
+		newStage(STAGE_HTTP, requests, load.total-replicateTotal, writers),
+		newStage(STAGE_CHANGELOG, replicateCount, replicateTotal-memtableTotal, writers),
+		newStage(STAGE_MEMTABLE, memtableCount, memtableTotal, writers),
+		newStage(STAGE_FLUSH, flushCount, flushTotal, shards),
+		newStage(STAGE_COMPACTION, compactionCount, compactionTotal, shards),
+	}
+
+	busiest := report.Stages[0]
+	for _, stage := range report.Stages[1:] {
+		if stage.Busy > busiest.Busy {
+			busiest = stage
+		}
+	}
+	report.Bottleneck = busiest.Name
+
+	return report, nil
+}
+
+func newStage(name string, count int64, total time.Duration, capacity time.Duration) Stage {
+	stage := Stage{
+		Name:  name,
+		Count: count,
+		Total: max(total, 0),
+	}
+
+	if count > 0 {
+		stage.Mean = stage.Total / time.Duration(count)
+	}
+
+	if capacity > 0 {
+		stage.Busy = float64(stage.Total) / float64(capacity)
+	}
+
+	return stage
+}
+
+// load is the outcome of the writers of a run.
+type load struct {
+	elapsed   time.Duration
+	writes    int64
+	errors    int64
+	total     time.Duration
+	latencies []time.Duration
+}
+
+// runLoad writes random keys to addr from opts.Workers goroutines, each
+// sending its share of opts.Rate. Writers behind schedule don't wait,
+// so a saturated node shows as a throughput below the rate.
+func runLoad(ctx context.Context, addr string, opts IngestOptions) *load {
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	client := &http.Client{
+		Transport: &http.Transport{MaxIdleConnsPerHost: opts.Workers},
+	}
+
+	var interval time.Duration
+	if opts.Rate > 0 {
+		interval = time.Second * time.Duration(opts.Workers) / time.Duration(opts.Rate)
+	}
+
+	res := &load{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for range opts.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var latencies []time.Duration
+			var writes, errs int64
+			next := time.Now()
+
+			for ctx.Err() == nil {
+				if interval > 0 {
+					next = next.Add(interval)
+					if wait := time.Until(next); wait > 0 {
+						select {
+						case <-ctx.Done():
+						case <-time.After(wait):
+						}
+
+						if ctx.Err() != nil {
+							break
+						}
+					}
+				}
+
+				key := fmt.Sprintf("key-%09d", rand.IntN(opts.Keys))
+				sent := time.Now()
+				err := put(ctx, client, addr, key, randomValue(opts.ValueSize))
+				if ctx.Err() != nil {
+					break
+				}
+
+				latencies = append(latencies, time.Since(sent))
+				if err != nil {
+					errs++
+				} else {
+					writes++
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			res.writes += writes
+			res.errors += errs
+			res.latencies = append(res.latencies, latencies...)
+		}()
+	}
+
+	wg.Wait()
+	res.elapsed = time.Since(start)
+
+	for _, latency := range res.latencies {
+		res.total += latency
+	}
+
+	return res
+}
+
+func put(ctx context.Context, client *http.Client, addr string, key string, value string) error {
+	body := strings.NewReader(`{"value":"` + value + `"}`)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, addr+"/v1/keys/"+url.PathEscape(key), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("put %s: %s", key, res.Status)
+	}
+
+	return nil
+}
+
+const valueAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+func randomValue(size int) string {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = valueAlphabet[rand.IntN(len(valueAlphabet))]
+	}
+
+	return string(b)
+}
+
+// percentile returns the p-th percentile of sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	return sorted[min(int(float64(len(sorted))*p), len(sorted)-1)]
+}
+
+func startCPUProfile(path string) (func(), error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() {
+		pprof.StopCPUProfile()
+		f.Close()
+	}, nil
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		return errors.Join(err, os.Remove(path))
+	}
+
+	return f.Close()
+}
+
+// Write prints the report as text.
+func (r *IngestReport) Write(out io.Writer) {
+	fmt.Fprintf(out, "%d writes in %s, %.0f/s", r.Writes, r.Elapsed.Round(time.Millisecond), r.Throughput)
+	if r.Options.Rate > 0 {
+		fmt.Fprintf(out, " of %d/s", r.Options.Rate)
+	}
+	fmt.Fprintf(out, ", %d errors\n", r.Errors)
+	fmt.Fprintf(out, "latency p50 %s, p99 %s, max %s\n\n", r.P50, r.P99, r.Max)
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STAGE\tCOUNT\tTOTAL\tMEAN\tBUSY")
+	for _, s := range r.Stages {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%.1f%%\n", s.Name, s.Count, s.Total.Round(time.Millisecond), s.Mean, 100*s.Busy)
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "\nbottleneck: %s\n", r.Bottleneck)
+	for _, path := range r.Profiles {
+		fmt.Fprintf(out, "profile: %s\n", path)
+	}
+}
@@ -0,0 +1,72 @@
+package perf
+
+import (
+	"context"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Longer runs are configured with flags, e.g.
+// go test ./perf -run TestIngest -v -args -rate 20000 -duration 30s -profile /tmp/ingest
+var (
+	ingestRate     = flag.Int("rate", 500, "writes per second, 0 for no limit")
+	ingestDuration = flag.Duration("duration", time.Second, "duration of the run")
+	ingestWorkers  = flag.Int("workers", 4, "concurrent writers")
+	ingestKeys     = flag.Int("keys", 10000, "distinct keys")
+	ingestValue    = flag.Int("value-size", 100, "value size in bytes")
+	ingestShards   = flag.Int("shards", 1, "shards of the node")
+	ingestMemtable = flag.Int("memtable", 0, "memtable size in records")
+	ingestProfile  = flag.String("profile", "", "directory of the cpu and heap profiles")
+)
+
+func TestIngest(t *testing.T) {
+	profileDir := *ingestProfile
+	if profileDir == "" {
+		profileDir = t.TempDir()
+	}
+
+	report, err := Ingest(context.Background(), IngestOptions{
+		DataDir:      t.TempDir(),
+		Rate:         *ingestRate,
+		Duration:     *ingestDuration,
+		Workers:      *ingestWorkers,
+		Keys:         *ingestKeys,
+		ValueSize:    *ingestValue,
+		Shards:       *ingestShards,
+		MemtableSize: *ingestMemtable,
+		ProfileDir:   profileDir,
+	})
+	require.NoError(t, err)
+
+	var out strings.Builder
+	report.Write(&out)
+	t.Log("\n" + out.String())
+
+	assert.Positive(t, report.Writes)
+	assert.Zero(t, report.Errors)
+	assert.NotEmpty(t, report.Bottleneck)
+
+	var names []string
+	for _, stage := range report.Stages {
+		names = append(names, stage.Name)
+	}
+	assert.Equal(t, []string{STAGE_HTTP, STAGE_CHANGELOG, STAGE_MEMTABLE, STAGE_FLUSH, STAGE_COMPACTION}, names)
+
+	// every write went through the replicator and the store, requests
+	// canceled at the end of the run may still have been applied
+	assert.GreaterOrEqual(t, report.Stages[1].Count, report.Writes)
+	assert.GreaterOrEqual(t, report.Stages[2].Count, report.Writes)
+
+	for _, path := range report.Profiles {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Positive(t, info.Size())
+	}
+	assert.Len(t, report.Profiles, 2)
+}
@@ -0,0 +1,22 @@
+package pkg
+
+import "encoding/base64"
+
+// EncodeCursor returns an opaque continuation token
+// for a scan that stopped after key.
+func EncodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// DecodeCursor returns the last key returned by the scan continued by
+// cursor. The scan resumes after it, skipping the keys that don't compare
+// greater: appending to the key doesn't give the next one under every
+// comparator, see storage.After.
+func DecodeCursor(cursor string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", err
+	}
+
+	return string(key), nil
+}
@@ -0,0 +1,69 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Listen opens a TCP listener on every address.
+// Addresses are IPv4 or bracketed IPv6, e.g. "[::1]:6090".
+func Listen(addrs []string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+
+			return nil, fmt.Errorf("listen %s: %w", addr, err)
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+// Serve runs serve on every listener
+// and returns the first error.
+func Serve(listeners []net.Listener, serve func(net.Listener) error) error {
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			errs <- serve(l)
+		}()
+	}
+
+	return <-errs
+}
+
+// ServeUntil runs serve on every listener until ctx is done, then calls
+// shutdown with a context expiring after drain. shutdown must close the
+// listeners and wait for the work in flight, or abort it once its
+// context is done. A serve error before ctx is done is returned.
+func ServeUntil(
+	ctx context.Context,
+	listeners []net.Listener,
+	drain time.Duration,
+	serve func(net.Listener) error,
+	shutdown func(ctx context.Context) error,
+) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- Serve(listeners, serve)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), drain)
+	defer cancel()
+
+	return shutdown(ctx)
+}
@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenOnEveryAddress(t *testing.T) {
+	addrs := []string{"127.0.0.1:0"}
+	if l, err := net.Listen("tcp", "[::1]:0"); err == nil {
+		l.Close()
+		addrs = append(addrs, "[::1]:0")
+	}
+
+	listeners, err := Listen(addrs)
+	require.NoError(t, err)
+	require.Len(t, listeners, len(addrs))
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go Serve(listeners, server.Serve)
+	defer server.Close()
+
+	// every address serves the same handler
+	for _, l := range listeners {
+		res, err := http.Get("http://" + l.Addr().String())
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+}
+
+func TestListenClosesListenersOnError(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer taken.Close()
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := free.Addr().String()
+	free.Close()
+
+	_, err = Listen([]string{addr, taken.Addr().String()})
+	assert.ErrorContains(t, err, taken.Addr().String())
+
+	// the listener opened before the failure was closed
+	l, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	l.Close()
+}
+
+func TestServeUntilDrains(t *testing.T) {
+	listeners, err := Listen([]string{"127.0.0.1:0"})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	shutdowns := make(chan time.Time, 1)
+	go func() {
+		served <- ServeUntil(ctx, listeners, time.Second, func(l net.Listener) error {
+			conn, err := l.Accept()
+			if err == nil {
+				conn.Close()
+			}
+
+			<-ctx.Done()
+			return nil
+		}, func(ctx context.Context) error {
+			deadline, _ := ctx.Deadline()
+			shutdowns <- deadline
+			return listeners[0].Close()
+		})
+	}()
+
+	cancel()
+	require.NoError(t, <-served)
+
+	// shutdown gets a context of its own, expiring after the drain
+	deadline := <-shutdowns
+	assert.WithinDuration(t, time.Now().Add(time.Second), deadline, 500*time.Millisecond)
+}
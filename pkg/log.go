@@ -0,0 +1,126 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// ComponentKey names the component of a logger, set with
+// logger.With(ComponentKey, name). Components are dotted paths,
+// the level of "storage" applies to "storage.compactor" too.
+const ComponentKey = "component"
+
+// NewLogger returns a logger writing records of at least level to w as
+// text or json. levels are component=level overrides, e.g. "storage=debug".
+func NewLogger(w io.Writer, format string, level string, levels []string) (*slog.Logger, error) {
+	h := &componentHandler{levels: make(map[string]slog.Level)}
+
+	if err := h.root.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	h.level = h.root
+	minLevel := h.root
+	for _, l := range levels {
+		component, name, ok := strings.Cut(l, "=")
+		if !ok || component == "" {
+			return nil, fmt.Errorf("invalid component log level %q, expected component=level", l)
+		}
+
+		var componentLevel slog.Level
+		if err := componentLevel.UnmarshalText([]byte(name)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q of %s", name, component)
+		}
+
+		h.levels[component] = componentLevel
+		minLevel = min(minLevel, componentLevel)
+	}
+
+	opts := &slog.HandlerOptions{Level: minLevel}
+	switch format {
+	case "", "text":
+		h.next = slog.NewTextHandler(w, opts)
+	case "json":
+		h.next = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q, expected text or json", format)
+	}
+
+	return slog.New(h), nil
+}
+
+// componentHandler filters records by the level of their component.
+// A logger has a single component, the latest one set replaces
+// the previous one instead of being logged next to it.
+type componentHandler struct {
+	next   slog.Handler
+	root   slog.Level
+	levels map[string]slog.Level
+
+	// level is the level of component.
+	component string
+	level     slog.Level
+}
+
+func (h *componentHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *componentHandler) Handle(ctx context.Context, r slog.Record) error {
+	if h.component != "" {
+		r = r.Clone()
+		r.AddAttrs(slog.String(ComponentKey, h.component))
+	}
+
+	return h.next.Handle(ctx, r)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	res := *h
+
+	var rest []slog.Attr
+	for _, attr := range attrs {
+		if attr.Key != ComponentKey {
+			rest = append(rest, attr)
+			continue
+		}
+
+		res.component = attr.Value.String()
+		res.level = h.componentLevel(res.component)
+	}
+
+	if len(rest) > 0 {
+		res.next = h.next.WithAttrs(rest)
+	}
+
+	return &res
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	res := *h
+	res.next = h.next.WithGroup(name)
+
+	return &res
+}
+
+// componentLevel returns the level of component or of its closest
+// parent with one, the default level otherwise.
+func (h *componentHandler) componentLevel(component string) slog.Level {
+	for name := component; name != ""; {
+		if level, ok := h.levels[name]; ok {
+			return level
+		}
+
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			break
+		}
+
+		name = name[:i]
+	}
+
+	return h.root
+}
@@ -0,0 +1,51 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComponentLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "json", "info", []string{"storage=debug", "storage.compactor=warn"})
+	require.NoError(t, err)
+
+	storage := logger.With(ComponentKey, "storage", "dir", "data/0")
+	storage.Debug("store")
+	storage.With(ComponentKey, "storage.lsm").Debug("lsm")
+	storage.With(ComponentKey, "storage.compactor").Info("compactor")
+	logger.With(ComponentKey, "cluster").Debug("cluster")
+	logger.Info("root")
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+
+	require.Len(t, records, 3)
+	assert.Equal(t, "store", records[0]["msg"])
+	assert.Equal(t, "storage", records[0][ComponentKey])
+
+	// the latest component replaces the previous one
+	assert.Equal(t, "lsm", records[1]["msg"])
+	assert.Equal(t, "storage.lsm", records[1][ComponentKey])
+	assert.Equal(t, "data/0", records[1]["dir"])
+
+	assert.Equal(t, "root", records[2]["msg"])
+	assert.NotContains(t, records[2], ComponentKey)
+
+	for _, levels := range [][]string{{"storage"}, {"=debug"}, {"storage=loud"}} {
+		_, err := NewLogger(&buf, "text", "info", levels)
+		assert.Error(t, err, levels)
+	}
+
+	_, err = NewLogger(&buf, "xml", "info", nil)
+	assert.Error(t, err)
+}
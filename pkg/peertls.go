@@ -0,0 +1,265 @@
+package pkg
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// peerTLSCheckInterval is how often the files of a PeerTLS
+// are checked for changes, on the next handshake.
+var peerTLSCheckInterval = 10 * time.Second
+
+// PeerTLS authenticates the nodes of a cluster to each other with
+// certificates signed by the cluster CA. The certificate, key and CA
+// files are read again once they changed, so they are rotated
+// without a restart; a rotation that can't be loaded keeps the
+// previous files and is logged.
+type PeerTLS struct {
+	logger   *slog.Logger
+	caFile   string
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	modTime time.Time
+	checked time.Time
+}
+
+// NewPeerTLS loads the node certificate and the cluster CA.
+func NewPeerTLS(logger *slog.Logger, caFile string, certFile string, keyFile string) (*PeerTLS, error) {
+	p := &PeerTLS{
+		logger:   logger,
+		caFile:   caFile,
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	modTime, err := p.lastModified()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.load(modTime); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// lastModified returns the latest modification time of the files.
+func (p *PeerTLS) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{p.caFile, p.certFile, p.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// load reads the files. Must be called with mu held, or before p is shared.
+func (p *PeerTLS) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(p.certFile, p.keyFile)
+	if err != nil {
+		return fmt.Errorf("load cluster certificate: %w", err)
+	}
+
+	ca, err := os.ReadFile(p.caFile)
+	if err != nil {
+		return fmt.Errorf("load cluster ca: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return fmt.Errorf("no certificate in cluster ca %s", p.caFile)
+	}
+
+	p.cert, p.pool, p.modTime = &cert, pool, modTime
+	p.checked = time.Now()
+	return nil
+}
+
+// current returns the certificate and CA pool,
+// reloading the files when they changed.
+func (p *PeerTLS) current() (*tls.Certificate, *x509.CertPool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Since(p.checked) < peerTLSCheckInterval {
+		return p.cert, p.pool
+	}
+	p.checked = time.Now()
+
+	modTime, err := p.lastModified()
+	if err == nil && modTime.Equal(p.modTime) {
+		return p.cert, p.pool
+	}
+
+	if err == nil {
+		err = p.load(modTime)
+	}
+
+	if err != nil {
+		p.logger.Error("error reloading cluster certificates, keeping the previous ones", "err", err)
+	} else {
+		p.logger.Info("reloaded cluster certificates", "cert", p.certFile, "ca", p.caFile)
+	}
+
+	return p.cert, p.pool
+}
+
+// ServerConfig returns the TLS configuration of listeners only peers
+// connect to, connections without a certificate of the cluster CA are
+// refused during the handshake.
+func (p *PeerTLS) ServerConfig(nextProtos []string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := p.current()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   nextProtos,
+				Certificates: []tls.Certificate{*cert},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    pool,
+			}, nil
+		},
+	}
+}
+
+// AcceptPeers makes base, the TLS configuration of a listener serving
+// clients too, verify the certificates of the cluster CA clients present,
+// so Verified tells peers apart. nextProtos are the protocols of the
+// listener. base nil, no TLS configured for clients, serves the node
+// certificate.
+func (p *PeerTLS) AcceptPeers(base *tls.Config, nextProtos []string) *tls.Config {
+	if base == nil {
+		base = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	// autocert answers its challenge on the listener
+	if slices.Contains(base.NextProtos, acme.ALPNProto) {
+		nextProtos = append(slices.Clip(nextProtos), acme.ALPNProto)
+	}
+
+	config := base.Clone()
+	config.NextProtos = nextProtos
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cert, pool := p.current()
+
+		c := base.Clone()
+		c.NextProtos = nextProtos
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+		c.ClientCAs = pool
+		if len(c.Certificates) == 0 && c.GetCertificate == nil {
+			c.Certificates = []tls.Certificate{*cert}
+		}
+
+		return c, nil
+	}
+
+	return config
+}
+
+// ClientConfig returns the TLS configuration of connections to peers.
+// It presents the node certificate and accepts servers whose certificate
+// is signed by the cluster CA, or by a root of the system for peers
+// serving a public certificate to clients.
+func (p *PeerTLS) ClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := p.current()
+			return cert, nil
+		},
+		// the CA pool may be rotated, chains are verified by VerifyConnection
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("peer presented no certificate")
+			}
+
+			_, pool := p.current()
+			opts := x509.VerifyOptions{
+				DNSName:       state.ServerName,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range state.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+
+			opts.Roots = pool
+			_, err := state.PeerCertificates[0].Verify(opts)
+			if err == nil {
+				return nil
+			}
+
+			opts.Roots = nil
+			if _, sysErr := state.PeerCertificates[0].Verify(opts); sysErr == nil {
+				return nil
+			}
+
+			return err
+		},
+	}
+}
+
+// Verified tells whether the connection of state presented
+// a certificate of the cluster CA.
+func Verified(state *tls.ConnectionState) bool {
+	return state != nil && len(state.VerifiedChains) > 0
+}
+
+var (
+	peerMu        sync.RWMutex
+	peerTLS       *PeerTLS
+	peerTransport http.RoundTripper = http.DefaultTransport
+)
+
+// SetPeerTLS secures the connections to other nodes with p,
+// it must be called before the clients of peers are created.
+func SetPeerTLS(p *PeerTLS) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = p.ClientConfig()
+
+	peerMu.Lock()
+	defer peerMu.Unlock()
+
+	peerTLS, peerTransport = p, transport
+}
+
+// GetPeerTLS returns the PeerTLS set by SetPeerTLS, nil without mTLS.
+func GetPeerTLS() *PeerTLS {
+	peerMu.RLock()
+	defer peerMu.RUnlock()
+
+	return peerTLS
+}
+
+// PeerTransport is the transport of requests to other nodes,
+// presenting the node certificate once SetPeerTLS was called.
+func PeerTransport() http.RoundTripper {
+	peerMu.RLock()
+	defer peerMu.RUnlock()
+
+	return peerTransport
+}
@@ -0,0 +1,175 @@
+package pkg
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA signs the certificates of the nodes of a test cluster.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// writeNode writes the CA and a certificate it signs for 127.0.0.1
+// to dir, returning the paths of the CA, the certificate and the key.
+func (ca *testCA) writeNode(t *testing.T, dir string) (string, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "node"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	certFile, keyFile := filepath.Join(dir, "node.pem"), filepath.Join(dir, "node-key.pem")
+	require.NoError(t, os.WriteFile(caFile, ca.pem, 0600))
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return caFile, certFile, keyFile
+}
+
+func (ca *testCA) newPeerTLS(t *testing.T, logger *slog.Logger, dir string) *PeerTLS {
+	caFile, certFile, keyFile := ca.writeNode(t, dir)
+	p, err := NewPeerTLS(logger, caFile, certFile, keyFile)
+	require.NoError(t, err)
+
+	return p
+}
+
+func TestPeerTLS(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ca := newTestCA(t, "cluster")
+
+	serverDir := t.TempDir()
+	server := ca.newPeerTLS(t, logger, serverDir)
+	client := ca.newPeerTLS(t, logger, t.TempDir())
+
+	verified := func(w http.ResponseWriter, r *http.Request) {
+		if Verified(r.TLS) {
+			w.Write([]byte("peer"))
+		} else {
+			w.Write([]byte("client"))
+		}
+	}
+
+	get := func(url string, config *tls.Config) (string, error) {
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		res, err := c.Get(url)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		return string(body), err
+	}
+
+	// the cluster listener only accepts peers
+	peers := httptest.NewUnstartedServer(http.HandlerFunc(verified))
+	peers.TLS = server.ServerConfig([]string{"http/1.1"})
+	peers.StartTLS()
+	defer peers.Close()
+
+	body, err := get(peers.URL, client.ClientConfig())
+	require.NoError(t, err)
+	assert.Equal(t, "peer", body)
+
+	_, err = get(peers.URL, &tls.Config{RootCAs: certPool(ca)})
+	assert.Error(t, err)
+
+	// a listener serving clients tells peers apart
+	clients := httptest.NewUnstartedServer(http.HandlerFunc(verified))
+	clients.TLS = server.AcceptPeers(nil, []string{"http/1.1"})
+	clients.StartTLS()
+	defer clients.Close()
+
+	body, err = get(clients.URL, client.ClientConfig())
+	require.NoError(t, err)
+	assert.Equal(t, "peer", body)
+
+	body, err = get(clients.URL, &tls.Config{RootCAs: certPool(ca)})
+	require.NoError(t, err)
+	assert.Equal(t, "client", body)
+
+	// a node of another CA is refused until the files are rotated
+	other := newTestCA(t, "other")
+	stranger := other.newPeerTLS(t, logger, t.TempDir())
+
+	_, err = get(peers.URL, stranger.ClientConfig())
+	assert.Error(t, err)
+
+	defer func(interval time.Duration) { peerTLSCheckInterval = interval }(peerTLSCheckInterval)
+	peerTLSCheckInterval = 0
+
+	caFile, certFile, keyFile := other.writeNode(t, serverDir)
+	future := time.Now().Add(time.Minute)
+	for _, file := range []string{caFile, certFile, keyFile} {
+		require.NoError(t, os.Chtimes(file, future, future))
+	}
+
+	body, err = get(peers.URL, stranger.ClientConfig())
+	require.NoError(t, err)
+	assert.Equal(t, "peer", body)
+
+	_, err = get(peers.URL, client.ClientConfig())
+	assert.Error(t, err)
+}
+
+func certPool(ca *testCA) *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
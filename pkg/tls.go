@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSOptions configure the TLS of the client APIs. A certificate
+// is either loaded from CertFile and KeyFile or obtained from
+// Let's Encrypt for AutocertDomains, kept in AutocertDir.
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+
+	AutocertDomains []string
+	AutocertDir     string
+	AutocertEmail   string
+
+	// MinVersion is "1.2" or "1.3", 1.2 when empty.
+	MinVersion string
+
+	// CipherSuites are the names of the TLS 1.2 cipher suites
+	// allowed, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, Go's
+	// defaults when empty. TLS 1.3 suites can't be configured.
+	CipherSuites []string
+}
+
+// Enabled tells whether a certificate is configured.
+func (o TLSOptions) Enabled() bool {
+	return o.CertFile != "" || len(o.AutocertDomains) > 0
+}
+
+// ServerTLS returns the TLS configuration of servers,
+// nil when no certificate is configured.
+func ServerTLS(opts TLSOptions) (*tls.Config, error) {
+	if !opts.Enabled() {
+		return nil, nil
+	}
+
+	if opts.CertFile != "" && len(opts.AutocertDomains) > 0 {
+		return nil, fmt.Errorf("a certificate file and autocert domains are exclusive")
+	}
+
+	minVersion, err := parseTLSVersion(opts.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphers, err := parseCipherSuites(opts.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: ciphers,
+	}
+
+	if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load certificate: %w", err)
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+		return config, nil
+	}
+
+	// the tls-alpn-01 challenge is answered on the TLS listeners,
+	// no plain HTTP listener is needed
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(opts.AutocertDomains...),
+		Cache:      autocert.DirCache(opts.AutocertDir),
+		Email:      opts.AutocertEmail,
+	}
+
+	config.GetCertificate = manager.GetCertificate
+	config.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+
+	return config, nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown tls version %q, 1.2 or 1.3", version)
+	}
+}
+
+// parseCipherSuites returns the ids of the named cipher suites,
+// insecure suites are rejected.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	var ids []uint16
+	for _, name := range names {
+		i := slices.IndexFunc(tls.CipherSuites(), func(suite *tls.CipherSuite) bool {
+			return strings.EqualFold(suite.Name, name)
+		})
+		if i < 0 {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+
+		ids = append(ids, tls.CipherSuites()[i].ID)
+	}
+
+	return ids, nil
+}
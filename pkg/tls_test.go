@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert writes a self-signed certificate for localhost to dir.
+func writeCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return certFile, keyFile
+}
+
+func TestServerTLS(t *testing.T) {
+	config, err := ServerTLS(TLSOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, config)
+
+	certFile, keyFile := writeCert(t, t.TempDir())
+
+	config, err = ServerTLS(TLSOptions{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		MinVersion:   "1.3",
+		CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+	})
+	require.NoError(t, err)
+	assert.Len(t, config.Certificates, 1)
+	assert.Equal(t, uint16(tls.VersionTLS13), config.MinVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, config.CipherSuites)
+
+	_, err = ServerTLS(TLSOptions{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.1"})
+	assert.ErrorContains(t, err, "tls version")
+
+	// insecure suites are refused
+	_, err = ServerTLS(TLSOptions{CertFile: certFile, KeyFile: keyFile, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}})
+	assert.ErrorContains(t, err, "cipher suite")
+
+	_, err = ServerTLS(TLSOptions{CertFile: certFile, KeyFile: keyFile, AutocertDomains: []string{"example.com"}})
+	assert.ErrorContains(t, err, "exclusive")
+
+	config, err = ServerTLS(TLSOptions{AutocertDomains: []string{"example.com"}, AutocertDir: t.TempDir()})
+	require.NoError(t, err)
+	assert.NotNil(t, config.GetCertificate)
+	assert.Contains(t, config.NextProtos, "acme-tls/1")
+}
@@ -0,0 +1,168 @@
+// Package ratelimit limits the requests and bandwidth of every client
+// of the APIs with token buckets.
+package ratelimit
+
+import (
+	"distrikv/auth"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the buckets of idle clients are dropped.
+const sweepInterval = time.Minute
+
+// Limits are the limits of every client, zero rates are unlimited.
+type Limits struct {
+	// Requests is the rate of requests per second,
+	// RequestBurst the requests allowed at once.
+	Requests     float64
+	RequestBurst int
+
+	// Bytes is the rate of request and response bytes per second,
+	// ByteBurst the bytes allowed at once.
+	Bytes     float64
+	ByteBurst int64
+}
+
+// Enabled tells whether l limits anything.
+func (l Limits) Enabled() bool {
+	return l.Requests > 0 || l.Bytes > 0
+}
+
+// bucket holds tokens refilled at rate per second up to burst.
+// Its tokens go negative when more is charged than it holds,
+// the debt is paid by the refills before the next request.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) refill(now time.Time, rate float64, burst float64) {
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+}
+
+// full tells whether b refilled to burst by now.
+func (b *bucket) full(now time.Time, rate float64, burst float64) bool {
+	return rate == 0 || b.tokens+now.Sub(b.last).Seconds()*rate >= burst
+}
+
+type client struct {
+	requests bucket
+	bytes    bucket
+}
+
+// Limiter tracks the buckets of the clients. It's safe for concurrent use.
+type Limiter struct {
+	limits Limits
+	now    func() time.Time
+
+	mu      sync.Mutex
+	clients map[string]*client
+	swept   time.Time
+}
+
+// New returns a Limiter of limits. A burst lower than the rate,
+// or than a single request, is raised to it.
+func New(limits Limits) *Limiter {
+	limits.RequestBurst = max(limits.RequestBurst, int(math.Ceil(limits.Requests)), 1)
+	limits.ByteBurst = max(limits.ByteBurst, int64(math.Ceil(limits.Bytes)))
+
+	return &Limiter{
+		limits:  limits,
+		now:     time.Now,
+		clients: make(map[string]*client),
+	}
+}
+
+// client returns the buckets of key, refilled. Must be called with mu held.
+func (l *Limiter) client(key string, now time.Time) *client {
+	if now.Sub(l.swept) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{
+			requests: bucket{tokens: float64(l.limits.RequestBurst), last: now},
+			bytes:    bucket{tokens: float64(l.limits.ByteBurst), last: now},
+		}
+		l.clients[key] = c
+	}
+
+	c.requests.refill(now, l.limits.Requests, float64(l.limits.RequestBurst))
+	c.bytes.refill(now, l.limits.Bytes, float64(l.limits.ByteBurst))
+	return c
+}
+
+// sweep drops the clients whose buckets are full,
+// they are the same as new clients.
+func (l *Limiter) sweep(now time.Time) {
+	l.swept = now
+	for key, c := range l.clients {
+		if c.requests.full(now, l.limits.Requests, float64(l.limits.RequestBurst)) &&
+			c.bytes.full(now, l.limits.Bytes, float64(l.limits.ByteBurst)) {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// Allow takes a request of the client key. It returns zero when the
+// request is allowed, or how long to wait before retrying: until a
+// request refilled, and until the bytes charged beyond the burst are
+// paid back.
+func (l *Limiter) Allow(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	c := l.client(key, now)
+
+	var wait float64
+	if l.limits.Requests > 0 && c.requests.tokens < 1 {
+		wait = (1 - c.requests.tokens) / l.limits.Requests
+	}
+
+	if l.limits.Bytes > 0 && c.bytes.tokens < 0 {
+		wait = max(wait, -c.bytes.tokens/l.limits.Bytes)
+	}
+
+	if wait > 0 {
+		return time.Duration(math.Ceil(wait * float64(time.Second)))
+	}
+
+	if l.limits.Requests > 0 {
+		c.requests.tokens--
+	}
+
+	return 0
+}
+
+// Charge takes n bytes read or written for the client key,
+// they are charged once they are known so they may exceed the burst.
+func (l *Limiter) Charge(key string, n int64) {
+	if l.limits.Bytes == 0 || n <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.client(key, l.now()).bytes.tokens -= float64(n)
+}
+
+// Key returns the key of the client authenticated as p,
+// or at addr without authentication.
+func Key(p *auth.Principal, addr string) string {
+	if p != nil {
+		return p.Kind + ":" + p.Name
+	}
+
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	return "ip:" + addr
+}
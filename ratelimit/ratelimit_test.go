@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(Limits{Requests: 2, RequestBurst: 3, Bytes: 100, ByteBurst: 200})
+	l.now = func() time.Time { return now }
+
+	// the burst is allowed at once
+	for range 3 {
+		assert.Zero(t, l.Allow("a"))
+	}
+	assert.Equal(t, 500*time.Millisecond, l.Allow("a"))
+
+	// clients have their own buckets
+	assert.Zero(t, l.Allow("b"))
+
+	now = now.Add(500 * time.Millisecond)
+	assert.Zero(t, l.Allow("a"))
+
+	// bytes beyond the burst are paid back before the next request
+	now = now.Add(10 * time.Second)
+	l.Charge("a", 450)
+	assert.Equal(t, 2500*time.Millisecond, l.Allow("a"))
+
+	now = now.Add(2500 * time.Millisecond)
+	assert.Zero(t, l.Allow("a"))
+
+	// idle clients are dropped
+	now = now.Add(time.Hour)
+	l.Allow("c")
+	assert.Len(t, l.clients, 1)
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	l := New(Limits{Bytes: 10})
+
+	// requests aren't limited, bytes are
+	for range 100 {
+		assert.Zero(t, l.Allow("a"))
+	}
+
+	l.Charge("a", 20)
+	assert.Positive(t, l.Allow("a"))
+}
@@ -0,0 +1,159 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrNoWAL is returned for writes acknowledged once logged
+	// by nodes that keep their changelog in memory only.
+	ErrNoWAL error = errors.New("changelog is not logged to disk, set a changelog retention")
+
+	// ErrNoStandbys is returned for writes acknowledged by a
+	// quorum when no standby follows the primary.
+	ErrNoStandbys error = errors.New("no standby follows the primary")
+)
+
+// standbyTimeout is how long a standby that stopped fetching
+// changes still counts towards the quorum of the primary.
+const standbyTimeout = 10 * time.Second
+
+// Ack is when a write is acknowledged to its client.
+type Ack int
+
+const (
+	// ACK_MEMORY acknowledges writes once applied to the memtable.
+	ACK_MEMORY Ack = iota
+
+	// ACK_WAL acknowledges writes once their change is synced
+	// to the changelog on disk.
+	ACK_WAL
+
+	// ACK_QUORUM acknowledges writes once synced to the changelog
+	// and applied by a majority of the primary and its standbys.
+	ACK_QUORUM
+)
+
+func (a Ack) String() string {
+	switch a {
+	case ACK_WAL:
+		return "wal"
+	case ACK_QUORUM:
+		return "quorum"
+	default:
+		return "memory"
+	}
+}
+
+// ParseAck parses "memory", "wal" or "quorum", empty is ACK_MEMORY.
+func ParseAck(s string) (Ack, error) {
+	switch s {
+	case "", "memory":
+		return ACK_MEMORY, nil
+	case "wal":
+		return ACK_WAL, nil
+	case "quorum":
+		return ACK_QUORUM, nil
+	default:
+		return 0, fmt.Errorf("unknown ack %q", s)
+	}
+}
+
+// standby is a standby following the primary, seen by its last fetch.
+type standby struct {
+	seq  uint64
+	seen time.Time
+}
+
+// follows records that the standby node applied the changes up to seq.
+func (r *Replicator) follows(node string, seq uint64) {
+	r.standbysMu.Lock()
+	defer r.standbysMu.Unlock()
+
+	r.standbys[node] = standby{seq: seq, seen: time.Now()}
+
+	close(r.standbysChanged)
+	r.standbysChanged = make(chan struct{})
+}
+
+// quorum returns how many of the standbys seen within standbyTimeout
+// applied seq, how many are needed for a majority with the primary,
+// and a channel closed once a standby fetches again.
+func (r *Replicator) quorum(seq uint64) (int, int, <-chan struct{}) {
+	r.standbysMu.Lock()
+	defer r.standbysMu.Unlock()
+
+	var live, applied int
+	for node, s := range r.standbys {
+		if time.Since(s.seen) > standbyTimeout {
+			delete(r.standbys, node)
+			continue
+		}
+
+		live++
+		if s.seq >= seq {
+			applied++
+		}
+	}
+
+	return applied, (live + 1) / 2, r.standbysChanged
+}
+
+// CanAcknowledge returns ErrNoWAL for ACK_WAL on nodes keeping their
+// changelog in memory only, writes are checked before being applied.
+func (r *Replicator) CanAcknowledge(ack Ack) error {
+	if ack == ACK_WAL && r.wal == nil {
+		return ErrNoWAL
+	}
+
+	return nil
+}
+
+// Acknowledge waits until the writes applied so far are durable as
+// ack asks for, or ctx is done. The writes stay applied when it fails.
+func (r *Replicator) Acknowledge(ctx context.Context, ack Ack) error {
+	if ack == ACK_MEMORY {
+		return nil
+	}
+
+	seq := r.log.LastSeq()
+
+	if err := r.CanAcknowledge(ack); err != nil {
+		return err
+	}
+
+	if r.wal != nil {
+		if err := r.wal.Sync(); err != nil {
+			return fmt.Errorf("sync changelog: %w", err)
+		}
+	}
+
+	if ack != ACK_QUORUM {
+		return nil
+	}
+
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+
+	for {
+		applied, needed, changed := r.quorum(seq)
+		if needed == 0 {
+			return ErrNoStandbys
+		}
+
+		if applied >= needed {
+			return nil
+		}
+
+		// standbys timing out change the quorum without fetching
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package replication
+
+import (
+	"context"
+	"distrikv/config"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAck(t *testing.T) {
+	for raw, want := range map[string]Ack{"": ACK_MEMORY, "memory": ACK_MEMORY, "wal": ACK_WAL, "quorum": ACK_QUORUM} {
+		ack, err := ParseAck(raw)
+		require.NoError(t, err, raw)
+		assert.Equal(t, want, ack, raw)
+	}
+
+	for _, ack := range []Ack{ACK_MEMORY, ACK_WAL, ACK_QUORUM} {
+		parsed, err := ParseAck(ack.String())
+		require.NoError(t, err)
+		assert.Equal(t, ack, parsed)
+	}
+
+	_, err := ParseAck("disk")
+	assert.Error(t, err)
+}
+
+func TestQuorum(t *testing.T) {
+	r := newTestReplicator(t, config.Config{})
+
+	applied, needed, _ := r.quorum(1)
+	assert.Equal(t, 0, applied)
+	assert.Equal(t, 0, needed)
+
+	// the primary and one standby: the standby is needed
+	r.follows("a", 0)
+	applied, needed, _ = r.quorum(1)
+	assert.Equal(t, 0, applied)
+	assert.Equal(t, 1, needed)
+
+	// the primary and two standbys: one of them is enough
+	r.follows("a", 1)
+	r.follows("b", 0)
+	applied, needed, _ = r.quorum(1)
+	assert.Equal(t, 1, applied)
+	assert.Equal(t, 1, needed)
+
+	// three standbys need two
+	r.follows("c", 2)
+	applied, needed, _ = r.quorum(1)
+	assert.Equal(t, 2, applied)
+	assert.Equal(t, 2, needed)
+
+	// standbys that stopped fetching no longer count
+	r.standbysMu.Lock()
+	r.standbys["b"] = standby{seen: time.Now().Add(-2 * standbyTimeout)}
+	r.standbys["c"] = standby{seq: 2, seen: time.Now().Add(-2 * standbyTimeout)}
+	r.standbysMu.Unlock()
+
+	applied, needed, _ = r.quorum(1)
+	assert.Equal(t, 1, applied)
+	assert.Equal(t, 1, needed)
+}
+
+func TestAcknowledge(t *testing.T) {
+	ctx := context.Background()
+
+	r := newTestReplicator(t, config.Config{})
+	require.NoError(t, r.Set("a", "1"))
+
+	assert.NoError(t, r.Acknowledge(ctx, ACK_MEMORY))
+	assert.ErrorIs(t, r.CanAcknowledge(ACK_WAL), ErrNoWAL)
+	assert.ErrorIs(t, r.Acknowledge(ctx, ACK_WAL), ErrNoWAL)
+	assert.NoError(t, r.CanAcknowledge(ACK_QUORUM))
+	assert.ErrorIs(t, r.Acknowledge(ctx, ACK_QUORUM), ErrNoStandbys)
+
+	r = newTestReplicator(t, config.Config{ChangelogRetention: 1 << 20, ChangelogSegmentSize: 1 << 10})
+	require.NoError(t, r.Set("a", "1"))
+
+	assert.NoError(t, r.CanAcknowledge(ACK_WAL))
+	assert.NoError(t, r.Acknowledge(ctx, ACK_WAL))
+
+	// a standby that applied the write makes a quorum with the primary
+	r.follows("a", r.log.LastSeq())
+	assert.NoError(t, r.Acknowledge(ctx, ACK_QUORUM))
+
+	// one that didn't never does
+	r.follows("a", 0)
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, r.Acknowledge(ctx, ACK_QUORUM), context.DeadlineExceeded)
+}
+
+// newTestReplicator returns the replicator of a single node cluster of
+// a temporary data dir, cfg sets its changelog.
+func newTestReplicator(t *testing.T, cfg config.Config) *Replicator {
+	cfg.NodeID = "a"
+	cfg.DataDir = t.TempDir()
+	cfg.ChangelogSize = 100
+
+	c := newTestCluster(t, cfg.DataDir)
+	t.Cleanup(func() { c.Shutdown(context.Background()) })
+
+	r, err := New(slog.New(slog.DiscardHandler), c, cfg, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { r.Close() })
+
+	return r
+}
@@ -0,0 +1,207 @@
+package replication
+
+import (
+	"context"
+	"crypto/tls"
+	"distrikv/cluster"
+	"distrikv/config"
+	"distrikv/grpc"
+	"distrikv/pkg"
+	"distrikv/storage"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Bootstrap installs a snapshot of the primary into the empty data dir
+// of a standby, before its stores are opened. It returns the sequence
+// to follow the primary from, and false when nothing was installed
+// because the node isn't a standby, has data or no primary gRPC address.
+func Bootstrap(ctx context.Context, logger *slog.Logger, cfg config.Config) (uint64, bool, error) {
+	if cfg.Role != "standby" || cfg.PrimaryGRPCAddr == "" {
+		return 0, false, nil
+	}
+
+	// a bootstrap interrupted before its install is started over
+	tmp := filepath.Join(cfg.DataDir, "bootstrap")
+	if err := os.RemoveAll(tmp); err != nil {
+		return 0, false, err
+	}
+
+	empty, err := dataDirEmpty(cfg.DataDir)
+	if err != nil || !empty {
+		return 0, false, err
+	}
+
+	cmp, err := storage.ParseComparator(cfg.Comparator)
+	if err != nil {
+		return 0, false, err
+	}
+
+	logger.Info("bootstrapping from primary snapshot", "addr", cfg.PrimaryGRPCAddr)
+
+	// nodes of a cluster share the TLS setup, the primary's certificate
+	// is checked against the system roots, or the cluster CA with mTLS
+	var tlsConfig *tls.Config
+	if peers := pkg.GetPeerTLS(); peers != nil {
+		tlsConfig = peers.ClientConfig()
+	} else if cfg.TLS().Enabled() {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	client, err := grpc.NewClient(cfg.PrimaryGRPCAddr, tlsConfig, "")
+	if err != nil {
+		return 0, false, err
+	}
+	defer client.Close()
+
+	snapshot, err := receiveSnapshot(ctx, client, tmp, cfg.Shards)
+	if err != nil {
+		return 0, false, fmt.Errorf("receive snapshot: %w", err)
+	}
+
+	for shard, entries := range snapshot.entries {
+		dst := cluster.ShardDir(cfg.DataDir, cfg.Shards, shard)
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return 0, false, err
+		}
+
+		files, err := os.ReadDir(shardTmpDir(tmp, shard))
+		if err != nil && !os.IsNotExist(err) {
+			return 0, false, err
+		}
+
+		for _, file := range files {
+			if err := os.Rename(filepath.Join(shardTmpDir(tmp, shard), file.Name()), filepath.Join(dst, file.Name())); err != nil {
+				return 0, false, err
+			}
+		}
+
+		if err := storage.WriteSnapshotEntries(dst, entries, cmp); err != nil {
+			return 0, false, err
+		}
+	}
+
+	if err := os.RemoveAll(tmp); err != nil {
+		return 0, false, err
+	}
+
+	// the installed data has the history of the primary
+	if _, err := cluster.NewGeneration(cfg.DataDir); err != nil {
+		return 0, false, err
+	}
+
+	logger.Info("installed primary snapshot", "seq", snapshot.seq, "shards", len(snapshot.entries))
+
+	return snapshot.seq, true, nil
+}
+
+type receivedSnapshot struct {
+	seq uint64
+
+	// entries holds the memtable entries of every received shard.
+	entries map[int][]storage.SSTEntry
+}
+
+// receiveSnapshot writes the SST files of every shard to tmp.
+func receiveSnapshot(ctx context.Context, client *grpc.Client, tmp string, shards int) (*receivedSnapshot, error) {
+	res := &receivedSnapshot{
+		entries: make(map[int][]storage.SSTEntry),
+	}
+
+	files := make(map[string]*os.File)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	first := true
+	err := client.Snapshot(ctx, func(chunk *grpc.SnapshotChunk) error {
+		if first {
+			res.seq = chunk.Seq
+			first = false
+			return nil
+		}
+
+		shard := int(chunk.Shard)
+		if shard < 0 || shard >= shards {
+			return fmt.Errorf("snapshot shard %d is out of the %d configured shards", shard, shards)
+		}
+
+		if _, ok := res.entries[shard]; !ok {
+			res.entries[shard] = nil
+		}
+
+		for _, entry := range chunk.Entries {
+			// entries without expiry have no timestamp
+			var expiresAt time.Time
+			if entry.ExpiresAt != nil {
+				expiresAt = entry.ExpiresAt.AsTime()
+			}
+
+			res.entries[shard] = append(res.entries[shard], storage.SSTEntry{
+				Key:       entry.Key,
+				Value:     string(entry.Value),
+				IsDeleted: entry.Deleted,
+				ExpiresAt: expiresAt,
+			})
+		}
+
+		if chunk.File == "" {
+			return nil
+		}
+
+		path := filepath.Join(shardTmpDir(tmp, shard), filepath.Base(chunk.File))
+		f, ok := files[path]
+		if !ok {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+
+			var err error
+			f, err = os.Create(path)
+			if err != nil {
+				return err
+			}
+
+			files[path] = f
+		}
+
+		_, err := f.Write(chunk.Data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		if err := f.Sync(); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+func shardTmpDir(tmp string, shard int) string {
+	return filepath.Join(tmp, fmt.Sprintf("shard-%03d", shard))
+}
+
+// dataDirEmpty reports whether no shard of dataDir holds SSTs.
+func dataDirEmpty(dataDir string) (bool, error) {
+	for _, pattern := range []string{"*", filepath.Join("*", "*")} {
+		files, err := filepath.Glob(filepath.Join(dataDir, pattern+storage.SSTFileFormat))
+		if err != nil {
+			return false, err
+		}
+
+		if len(files) > 0 {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
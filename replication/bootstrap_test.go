@@ -0,0 +1,74 @@
+package replication
+
+import (
+	"context"
+	"distrikv/config"
+	"distrikv/grpc"
+	"distrikv/storage"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBootstrapFromPrimarySnapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	logger := slog.New(slog.DiscardHandler)
+
+	a := newTestCluster(t, t.TempDir())
+	t.Cleanup(func() { a.Shutdown(context.Background()) })
+
+	primary, err := New(logger, a, config.Config{NodeID: "a", ChangelogSize: 100}, nil)
+	require.NoError(t, err)
+
+	// the snapshot holds the SSTs and the memtables of the primary
+	require.NoError(t, primary.Set("a", "1"))
+	_, err = a.Flush(ctx)
+	require.NoError(t, err)
+	require.NoError(t, primary.Set("b", "2"))
+	require.NoError(t, primary.SetWithTTL("c", "3", time.Hour))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	cfg := config.Config{GRPCAddrs: []string{addr}, ShutdownDrainTimeout: time.Second}
+	go grpc.Start(ctx, primary, nil, primary, nil, nil, nil, cfg)
+
+	cfg = config.Config{NodeID: "b", DataDir: t.TempDir(), Shards: 1, Role: "standby", PrimaryGRPCAddr: addr}
+
+	// the primary may not be listening yet
+	var seq uint64
+	require.Eventually(t, func() bool {
+		var installed bool
+		seq, installed, _ = Bootstrap(ctx, logger, cfg)
+		return installed
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, uint64(3), seq)
+
+	// a data dir holding SSTs isn't bootstrapped again
+	_, installed, err := Bootstrap(ctx, logger, cfg)
+	require.NoError(t, err)
+	assert.False(t, installed)
+
+	b := newTestCluster(t, cfg.DataDir)
+	t.Cleanup(func() { b.Shutdown(context.Background()) })
+
+	for key, value := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+		data, err := b.Get(ctx, key)
+		require.NoError(t, err, key)
+		assert.Equal(t, value, data.Value)
+	}
+
+	data, err := b.Get(ctx, "c")
+	require.NoError(t, err)
+	assert.False(t, data.ExpiresAt.IsZero())
+
+	_, err = b.Get(ctx, "d")
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+}
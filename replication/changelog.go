@@ -0,0 +1,203 @@
+package replication
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrChangesTruncated error = errors.New("changes are no longer retained")
+	ErrChangesAhead     error = errors.New("sequence is ahead of the changelog")
+)
+
+type Op int
+
+const (
+	OP_SET Op = iota
+
+	OP_DELETE
+
+	// OP_DELETE_RANGE deletes the keys in [Key, End).
+	OP_DELETE_RANGE
+)
+
+func (o Op) String() string {
+	switch o {
+	case OP_DELETE:
+		return "delete"
+	case OP_DELETE_RANGE:
+		return "delete_range"
+	}
+
+	return "set"
+}
+
+// Change is a single committed write.
+type Change struct {
+	Seq   uint64 `json:"seq"`
+	Op    Op     `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+
+	// End is the end of the range of OP_DELETE_RANGE,
+	// empty to the last key.
+	End string `json:"end,omitempty"`
+
+	// ExpiresAt is set for writes of expiring keys.
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// ENCODING_BASE64 tells that the keys and value of
+// a change are base64 encoded in its JSON.
+const ENCODING_BASE64 = "base64"
+
+// jsonChange is the JSON of a Change. JSON strings can't hold
+// binary data, changes with keys or values that aren't valid
+// UTF-8 are sent base64 encoded.
+type jsonChange struct {
+	Seq       uint64    `json:"seq"`
+	Op        Op        `json:"op"`
+	Key       string    `json:"key"`
+	Value     string    `json:"value,omitempty"`
+	End       string    `json:"end,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	Encoding  string    `json:"encoding,omitempty"`
+}
+
+func (c Change) MarshalJSON() ([]byte, error) {
+	res := jsonChange{
+		Seq:       c.Seq,
+		Op:        c.Op,
+		Key:       c.Key,
+		Value:     c.Value,
+		End:       c.End,
+		ExpiresAt: c.ExpiresAt,
+	}
+
+	if !utf8.ValidString(c.Key) || !utf8.ValidString(c.Value) || !utf8.ValidString(c.End) {
+		res.Key = base64.StdEncoding.EncodeToString([]byte(c.Key))
+		res.Value = base64.StdEncoding.EncodeToString([]byte(c.Value))
+		res.End = base64.StdEncoding.EncodeToString([]byte(c.End))
+		res.Encoding = ENCODING_BASE64
+	}
+
+	return json.Marshal(res)
+}
+
+func (c *Change) UnmarshalJSON(data []byte) error {
+	var res jsonChange
+	if err := json.Unmarshal(data, &res); err != nil {
+		return err
+	}
+
+	if res.Encoding == ENCODING_BASE64 {
+		for _, field := range []*string{&res.Key, &res.Value, &res.End} {
+			decoded, err := base64.StdEncoding.DecodeString(*field)
+			if err != nil {
+				return err
+			}
+
+			*field = string(decoded)
+		}
+	}
+
+	*c = Change{
+		Seq:       res.Seq,
+		Op:        res.Op,
+		Key:       res.Key,
+		Value:     res.Value,
+		End:       res.End,
+		ExpiresAt: res.ExpiresAt,
+	}
+
+	return nil
+}
+
+// Changelog keeps the latest changes in memory,
+// numbered by a sequence incremented on every change.
+type Changelog struct {
+	mu       sync.RWMutex
+	changes  []Change
+	capacity int
+	lastSeq  uint64
+}
+
+func NewChangelog(capacity int) *Changelog {
+	return &Changelog{
+		capacity: capacity,
+	}
+}
+
+// Append records a change and returns its sequence.
+func (c *Changelog) Append(change Change) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastSeq++
+	change.Seq = c.lastSeq
+	c.changes = append(c.changes, change)
+
+	// drop the older half at once so trimming stays amortized
+	if len(c.changes) > c.capacity {
+		c.changes = append([]Change(nil), c.changes[len(c.changes)-c.capacity/2:]...)
+	}
+
+	return c.lastSeq
+}
+
+// Since returns up to limit changes after seq.
+func (c *Changelog) Since(seq uint64, limit int) ([]Change, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if seq > c.lastSeq {
+		return nil, ErrChangesAhead
+	}
+
+	if seq == c.lastSeq {
+		return nil, nil
+	}
+
+	if len(c.changes) == 0 {
+		return nil, ErrChangesTruncated
+	}
+
+	// sequences are contiguous, so the index is an offset from the first one
+	first := c.changes[0].Seq
+	if seq+1 < first {
+		return nil, ErrChangesTruncated
+	}
+
+	start := int(seq + 1 - first)
+	end := min(start+limit, len(c.changes))
+
+	return append([]Change(nil), c.changes[start:end]...), nil
+}
+
+func (c *Changelog) LastSeq() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.lastSeq
+}
+
+// Len returns the number of retained changes.
+func (c *Changelog) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.changes)
+}
+
+// Reset discards the retained changes and continues numbering after seq.
+func (c *Changelog) Reset(seq uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.changes = nil
+	c.lastSeq = seq
+}
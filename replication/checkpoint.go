@@ -0,0 +1,78 @@
+package replication
+
+import (
+	"context"
+	"distrikv/storage"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CheckpointFile is the manifest of a checkpoint, kept in its directory.
+const CheckpointFile = "CHECKPOINT"
+
+// Checkpoint is the manifest of a checkpoint of the local shards.
+// Seq is the sequence of the last change it contains, a standby
+// installing it follows the primary from there.
+type Checkpoint struct {
+	Seq       uint64                      `json:"seq"`
+	CreatedAt time.Time                   `json:"created_at"`
+	Shards    map[int]*storage.Checkpoint `json:"shards"`
+}
+
+// Checkpoint checkpoints every local shard into dir with writes paused
+// and writes its manifest, see storage.Store.Checkpoint.
+func (r *Replicator) Checkpoint(ctx context.Context, dir string) (*Checkpoint, error) {
+	r.mu.Lock()
+	seq := r.log.LastSeq()
+	shards, err := r.store.Checkpoint(ctx, dir)
+	if err == nil {
+		// the memtables were flushed for the checkpoint
+		err = r.flushed(seq)
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := &Checkpoint{
+		Seq:       seq,
+		CreatedAt: time.Now(),
+		Shards:    shards,
+	}
+
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	// the manifest is written last, a directory without one is incomplete
+	tmp := filepath.Join(dir, CheckpointFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(tmp, filepath.Join(dir, CheckpointFile)); err != nil {
+		return nil, err
+	}
+
+	r.logger.Info("checkpoint written", "dir", dir, "seq", seq, "shards", len(shards))
+
+	return checkpoint, nil
+}
+
+// ReadCheckpoint reads the manifest of the checkpoint in dir.
+func ReadCheckpoint(dir string) (*Checkpoint, error) {
+	data, err := os.ReadFile(filepath.Join(dir, CheckpointFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, err
+	}
+
+	return &checkpoint, nil
+}
@@ -0,0 +1,142 @@
+package replication
+
+import (
+	"distrikv/wal"
+	"errors"
+)
+
+// ChangelogDir is the directory of the data dir
+// holding the changes kept on disk.
+const ChangelogDir = "changelog"
+
+// historyBatchSize is the number of changes read at once
+// from the changelog kept in memory.
+const historyBatchSize = 1000
+
+// WALEntry returns the record of change in the changes kept on disk.
+func (c Change) WALEntry() *wal.WALEntry {
+	entry := &wal.WALEntry{
+		Seq:   c.Seq,
+		Key:   c.Key,
+		Value: c.Value,
+	}
+
+	switch {
+	case c.Op == OP_DELETE:
+		entry.Type = wal.RecordDelete
+	case c.Op == OP_DELETE_RANGE:
+		entry.Type = wal.RecordDeleteRange
+		entry.Value = c.End
+	case !c.ExpiresAt.IsZero():
+		entry.Type = wal.RecordPutExpiring
+		entry.ExpiresAt = c.ExpiresAt
+	default:
+		entry.Type = wal.RecordPut
+	}
+
+	return entry
+}
+
+// walEntry returns the record of changes in the changes kept on disk,
+// a single record for the changes of a write batch.
+func walEntry(changes []Change) *wal.WALEntry {
+	if len(changes) == 1 {
+		return changes[0].WALEntry()
+	}
+
+	entries := make([]*wal.WALEntry, len(changes))
+	for i, change := range changes {
+		entries[i] = change.WALEntry()
+	}
+
+	return wal.NewBatch(entries)
+}
+
+// readChanges calls fn with the changes of a record of the changes kept
+// on disk after seq, until fn returns false, and tells whether it did.
+// A record of a write batch holds the changes of every op of the batch.
+func readChanges(entry *wal.WALEntry, seq uint64, fn func(Change) bool) bool {
+	if entry.Type != wal.RecordBatch {
+		return fn(fromWALEntry(entry))
+	}
+
+	for _, entry := range entry.Batch {
+		if entry.Seq > seq && !fn(fromWALEntry(entry)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func fromWALEntry(entry *wal.WALEntry) Change {
+	change := Change{
+		Seq:   entry.Seq,
+		Op:    OP_SET,
+		Key:   entry.Key,
+		Value: entry.Value,
+	}
+
+	switch entry.Type {
+	case wal.RecordDelete:
+		change.Op = OP_DELETE
+	case wal.RecordDeleteRange:
+		change.Op = OP_DELETE_RANGE
+		change.End, change.Value = entry.Value, ""
+	case wal.RecordPutExpiring:
+		change.ExpiresAt = entry.ExpiresAt
+	}
+
+	return change
+}
+
+// History calls fn with the changes after seq, in order, until fn returns
+// false, read from the changes kept on disk or else from the ones kept in
+// memory. Changes recorded while reading may be left out. It fails with
+// ErrChangesAhead when seq is after the last change, and with
+// ErrChangesTruncated when changes after seq are no longer retained,
+// before calling fn or once the next changes were dropped while reading.
+func (r *Replicator) History(seq uint64, fn func(Change) bool) error {
+	if seq > r.log.LastSeq() {
+		return ErrChangesAhead
+	}
+
+	if r.wal == nil {
+		for {
+			changes, err := r.log.Since(seq, historyBatchSize)
+			if err != nil || len(changes) == 0 {
+				return err
+			}
+
+			for _, change := range changes {
+				if !fn(change) {
+					return nil
+				}
+			}
+
+			seq = changes[len(changes)-1].Seq
+		}
+	}
+
+	err := r.wal.Read(seq, func(entry *wal.WALEntry) bool {
+		return readChanges(entry, seq, fn)
+	})
+	if errors.Is(err, wal.ErrTruncated) {
+		return ErrChangesTruncated
+	}
+
+	return err
+}
+
+// Close closes the changes kept on disk,
+// the changes recorded after it aren't logged.
+func (r *Replicator) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.wal == nil {
+		return nil
+	}
+
+	return r.wal.Close()
+}
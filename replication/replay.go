@@ -0,0 +1,172 @@
+package replication
+
+import (
+	"distrikv/cluster"
+	"distrikv/storage"
+	"distrikv/wal"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// FlushedFile records, in the changelog directory, the sequence of the
+// last change written to the SSTs of the stores. The changes after it
+// are only in the memtables and replayed on startup.
+const FlushedFile = "FLUSHED"
+
+// Flush calls flush with writes paused, flush writes the memtables of
+// the stores to SSTs. Once it succeeds the changes applied so far are
+// no longer replayed on startup.
+func (r *Replicator) Flush(flush func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seq := r.log.LastSeq()
+	if err := flush(); err != nil {
+		return err
+	}
+
+	return r.flushed(seq)
+}
+
+// Sequence returns the sequence of the change being applied to the stores,
+// they number their memtables with it, see storage.Sequencer. Writes are
+// applied with mu held before they're recorded, replayed ones keep theirs.
+func (r *Replicator) Sequence() uint64 {
+	if seq := r.replaying.Load(); seq != 0 {
+		return seq
+	}
+
+	return r.log.LastSeq() + 1
+}
+
+// Flushed records the changes written to SSTs once a memtable of the stores
+// is flushed: the ones before the first change still in a memtable, or
+// every change applied if the memtables hold none.
+func (r *Replicator) Flushed() {
+	r.flushedMu.Lock()
+	defer r.flushedMu.Unlock()
+
+	// the changes numbered after seq aren't applied yet,
+	// read before the memtables so none is missed
+	seq := r.Sequence() - 1
+	if unflushed, ok := r.store.UnflushedSeq(); ok {
+		seq = min(seq, unflushed-1)
+	}
+
+	if err := r.writeFlushed(seq); err != nil {
+		r.logger.Error("error recording flushed changes", "seq", seq, "err", err)
+	}
+}
+
+// flushed records that the changes up to seq are written to SSTs.
+func (r *Replicator) flushed(seq uint64) error {
+	r.flushedMu.Lock()
+	defer r.flushedMu.Unlock()
+
+	return r.writeFlushed(seq)
+}
+
+// writeFlushed writes seq to the FlushedFile.
+// Must be called with flushedMu held.
+func (r *Replicator) writeFlushed(seq uint64) error {
+	if r.flushedPath == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(map[string]uint64{"seq": seq})
+	if err != nil {
+		return err
+	}
+
+	tmp := r.flushedPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, r.flushedPath)
+}
+
+// replay applies the changes logged after the last flush to the store and
+// returns how many it applied. Changes are applied in order, as recorded:
+// the value of every key they write ends up as it was after the last one.
+// Changes of keys owned by another node since are skipped.
+func (r *Replicator) replay() (int, error) {
+	var flushed struct {
+		Seq uint64 `json:"seq"`
+	}
+
+	// without a flush recorded every retained change is replayed
+	data, err := os.ReadFile(r.flushedPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(data, &flushed); err != nil {
+			r.logger.Warn("ignoring unreadable flushed sequence", "path", r.flushedPath, "err", err)
+		}
+	}
+
+	seq := flushed.Seq
+	if err := checkFlushed(seq, r.wal.FirstSeq(), r.wal.LastSeq()); err != nil {
+		if storage.StartupCheck == storage.CHECK_FAIL {
+			return 0, err
+		}
+
+		r.logger.Warn("inconsistent changelog", "err", err)
+
+		// the stores hold changes the changelog lost, the next
+		// changes are numbered after them, not over them again
+		if seq > r.wal.LastSeq() {
+			r.log.Reset(seq)
+			return 0, nil
+		}
+
+		seq = r.wal.FirstSeq() - 1
+	}
+
+	var replayed int
+	var applyErr error
+	// flushes before the first change is applied keep seq
+	r.replaying.Store(seq + 1)
+	defer r.replaying.Store(0)
+
+	// the ops of a batch up to seq are flushed and skipped
+	err = r.wal.Read(seq, func(entry *wal.WALEntry) bool {
+		return readChanges(entry, seq, func(change Change) bool {
+			r.replaying.Store(change.Seq)
+			err := r.apply(change)
+			if errors.Is(err, cluster.ErrNotOwner) {
+				return true
+			}
+
+			if err != nil {
+				applyErr = fmt.Errorf("apply change %d: %w", change.Seq, err)
+				return false
+			}
+
+			replayed++
+			return true
+		})
+	})
+
+	return replayed, errors.Join(err, applyErr)
+}
+
+// checkFlushed compares the sequence of the last change flushed to the
+// stores with the first and last changes of the changelog on disk, which
+// must hold every change after it. There is no sequence in the SSTs, the
+// flushed sequence stands for the changes they contain.
+func checkFlushed(flushed uint64, first uint64, last uint64) error {
+	if flushed > last {
+		return fmt.Errorf("%w: changes %d to %d are flushed to the stores but missing from the changelog", storage.ErrInconsistent, last+1, flushed)
+	}
+
+	if first > 0 && flushed+1 < first {
+		return fmt.Errorf("%w: changes %d to %d after the last flush are no longer retained by the changelog, they were lost with the memtables", storage.ErrInconsistent, flushed+1, first-1)
+	}
+
+	return nil
+}
@@ -0,0 +1,301 @@
+package replication
+
+import (
+	"context"
+	"distrikv/cluster"
+	"distrikv/config"
+	"distrikv/storage"
+	"distrikv/wal"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayAfterCrash(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.DiscardHandler)
+	cfg := config.Config{NodeID: "a", DataDir: t.TempDir(), ChangelogSize: 100, ChangelogRetention: 1 << 20, ChangelogSegmentSize: 1 << 10}
+
+	crashed := newTestCluster(t, cfg.DataDir)
+	r, err := New(logger, crashed, cfg, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, r.Set("a", "1"))
+	require.NoError(t, r.Set("b", "2"))
+	require.NoError(t, r.Delete("a"))
+	require.NoError(t, r.Acknowledge(ctx, ACK_WAL))
+
+	// the memtables are lost, the synced changelog isn't
+	cfg.DataDir = crash(t, cfg.DataDir)
+	require.NoError(t, r.Close())
+	crashed.Shutdown(ctx)
+
+	c := newTestCluster(t, cfg.DataDir)
+	t.Cleanup(func() { c.Shutdown(ctx) })
+
+	r, err = New(logger, c, cfg, nil)
+	require.NoError(t, err)
+	defer r.Close()
+
+	_, err = r.Get(ctx, "a")
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+	data, err := r.Get(ctx, "b")
+	require.NoError(t, err)
+	assert.Equal(t, "2", data.Value)
+
+	// writes keep their sequence after the replayed ones
+	require.NoError(t, r.Set("c", "3"))
+	assert.Equal(t, uint64(4), r.log.LastSeq())
+
+	// flushed changes aren't replayed again
+	require.NoError(t, r.Flush(func() error {
+		_, err := c.Flush(ctx)
+		return err
+	}))
+	require.NoError(t, r.Set("d", "4"))
+
+	replayed, err := r.replay()
+	require.NoError(t, err)
+	assert.Equal(t, 1, replayed)
+}
+
+func TestReplayBatch(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.DiscardHandler)
+	cfg := config.Config{NodeID: "a", DataDir: t.TempDir(), ChangelogSize: 100, ChangelogRetention: 1 << 20, ChangelogSegmentSize: 1 << 10}
+
+	crashed := newTestCluster(t, cfg.DataDir)
+	r, err := New(logger, crashed, cfg, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, r.Set("a", "1"))
+	require.NoError(t, r.Write([]storage.BatchOp{
+		{Type: storage.BATCH_PUT, Key: "b", Value: "2"},
+		{Type: storage.BATCH_DELETE, Key: "a"},
+		{Type: storage.BATCH_PUT, Key: "c", Value: "3"},
+	}))
+	require.NoError(t, r.Acknowledge(ctx, ACK_WAL))
+
+	// the ops of the batch are numbered on their own, logged as one record
+	var records []*wal.WALEntry
+	require.NoError(t, r.wal.Read(0, func(entry *wal.WALEntry) bool {
+		records = append(records, entry)
+		return true
+	}))
+	require.Len(t, records, 2)
+	assert.Equal(t, wal.RecordBatch, records[1].Type)
+	assert.Len(t, records[1].Batch, 3)
+
+	var changes []Change
+	require.NoError(t, r.History(2, func(change Change) bool {
+		changes = append(changes, change)
+		return true
+	}))
+	assert.Equal(t, []Change{
+		{Seq: 3, Op: OP_DELETE, Key: "a"},
+		{Seq: 4, Op: OP_SET, Key: "c", Value: "3"},
+	}, changes)
+
+	cfg.DataDir = crash(t, cfg.DataDir)
+	require.NoError(t, r.Close())
+	crashed.Shutdown(ctx)
+
+	c := newTestCluster(t, cfg.DataDir)
+	t.Cleanup(func() { c.Shutdown(ctx) })
+
+	r, err = New(logger, c, cfg, nil)
+	require.NoError(t, err)
+	defer r.Close()
+
+	_, err = r.Get(ctx, "a")
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+	for key, value := range map[string]string{"b": "2", "c": "3"} {
+		data, err := r.Get(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, value, data.Value)
+	}
+	assert.Equal(t, uint64(4), r.log.LastSeq())
+}
+
+func TestReplayAfterBackgroundFlushes(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.DiscardHandler)
+	cfg := config.Config{NodeID: "a", DataDir: t.TempDir(), ChangelogSize: 100, ChangelogRetention: 1 << 20, ChangelogSegmentSize: 1 << 10}
+
+	crashed := newTestCluster(t, cfg.DataDir)
+	r, err := New(logger, crashed, cfg, nil)
+	require.NoError(t, err)
+
+	// the first memtable is flushed once full, the next one isn't
+	writes := storage.MemtableSizeThreshold + 2
+	for i := range writes {
+		require.NoError(t, r.Set(fmt.Sprint(i), "1"))
+	}
+
+	// the write filling the memtable may be applied but not logged
+	// yet when it's flushed, it's then counted as not flushed
+	var flushed struct {
+		Seq int `json:"seq"`
+	}
+	require.Eventually(t, func() bool {
+		data, err := os.ReadFile(filepath.Join(cfg.DataDir, ChangelogDir, FlushedFile))
+		return err == nil && json.Unmarshal(data, &flushed) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, flushed.Seq, storage.MemtableSizeThreshold-1)
+
+	// an expired key is deleted through the changelog
+	require.NoError(t, r.DeleteExpired("0"))
+	require.NoError(t, r.Acknowledge(ctx, ACK_WAL))
+
+	cfg.DataDir = crash(t, cfg.DataDir)
+	require.NoError(t, r.Close())
+	crashed.Shutdown(ctx)
+
+	c := newTestCluster(t, cfg.DataDir)
+	t.Cleanup(func() { c.Shutdown(ctx) })
+
+	r, err = New(logger, c, cfg, nil)
+	require.NoError(t, err)
+	defer r.Close()
+
+	// only the changes of the memtables lost are replayed
+	replayed, err := r.replay()
+	require.NoError(t, err)
+	assert.Equal(t, writes+1-flushed.Seq, replayed)
+
+	_, err = r.Get(ctx, "0")
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+	data, err := r.Get(ctx, fmt.Sprint(writes-1))
+	require.NoError(t, err)
+	assert.Equal(t, "1", data.Value)
+}
+
+func TestReplayAfterChangelogTrimmed(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.DiscardHandler)
+	cfg := config.Config{NodeID: "a", DataDir: t.TempDir(), ChangelogSize: 100, ChangelogRetention: 2 << 10, ChangelogSegmentSize: 1 << 10}
+
+	// the copy of a crash doesn't keep the hard links of
+	// the SSTs compactions move, the SSTs stay on level 0
+	crashed := newTestCluster(t, cfg.DataDir)
+	crashed.PauseCompactions()
+	r, err := New(logger, crashed, cfg, nil)
+	require.NoError(t, err)
+
+	// memtables are flushed in the background while
+	// the changelog drops its oldest segments
+	keys := 202
+	for i := range keys {
+		require.NoError(t, r.Set(fmt.Sprintf("key-%03d", i), "value"))
+	}
+	require.NoError(t, r.Acknowledge(ctx, ACK_WAL))
+	require.Greater(t, r.wal.FirstSeq(), uint64(1))
+
+	// the copy is taken once the full memtables are flushed
+	require.Eventually(t, func() bool {
+		seq, ok := crashed.UnflushedSeq()
+		return ok && seq == 201
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cfg.DataDir = crash(t, cfg.DataDir)
+	require.NoError(t, r.Close())
+	crashed.Shutdown(ctx)
+
+	// the changes no longer retained were flushed, there's no gap
+	var flushed struct {
+		Seq uint64 `json:"seq"`
+	}
+	dir := filepath.Join(cfg.DataDir, ChangelogDir)
+	data, err := os.ReadFile(filepath.Join(dir, FlushedFile))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &flushed))
+
+	log, err := wal.OpenLog(dir, int64(cfg.ChangelogSegmentSize), int64(cfg.ChangelogRetention))
+	require.NoError(t, err)
+	assert.NoError(t, checkFlushed(flushed.Seq, log.FirstSeq(), log.LastSeq()))
+	require.NoError(t, log.Close())
+
+	c := newTestCluster(t, cfg.DataDir)
+	t.Cleanup(func() { c.Shutdown(ctx) })
+
+	r, err = New(logger, c, cfg, nil)
+	require.NoError(t, err)
+	defer r.Close()
+
+	for i := range keys {
+		data, err := r.Get(ctx, fmt.Sprintf("key-%03d", i))
+		require.NoError(t, err)
+		assert.Equal(t, "value", data.Value)
+	}
+}
+
+// newTestCluster returns a single node cluster of one shard in dir.
+func newTestCluster(t *testing.T, dir string) *cluster.Cluster {
+	logger := slog.New(slog.DiscardHandler)
+	open := func(dir string) (*storage.Store, error) {
+		return storage.Open(context.Background(), logger, dir, storage.OPEN_FAST, nil, nil, nil)
+	}
+
+	c, err := cluster.New(logger, config.Config{NodeID: "a", DataDir: dir, Shards: 1, ShardVnodes: 1}, nil, open)
+	require.NoError(t, err)
+
+	return c
+}
+
+// crash returns a copy of the files of the node of dir, as a crash
+// leaves them: the memtables of its stores are lost.
+func crash(t *testing.T, dir string) string {
+	copied := t.TempDir()
+	require.NoError(t, os.CopyFS(copied, os.DirFS(dir)))
+
+	return copied
+}
+
+func TestCheckFlushed(t *testing.T) {
+	assert.NoError(t, checkFlushed(0, 0, 0))
+	assert.NoError(t, checkFlushed(0, 1, 10))
+	assert.NoError(t, checkFlushed(4, 5, 10))
+	assert.NoError(t, checkFlushed(10, 5, 10))
+
+	// changes 5 and 6 were neither flushed nor retained
+	assert.ErrorIs(t, checkFlushed(4, 7, 10), storage.ErrInconsistent)
+
+	// the changelog lost changes 11 and 12
+	assert.ErrorIs(t, checkFlushed(12, 5, 10), storage.ErrInconsistent)
+}
+
+func TestReplayChangelogBehindStores(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	cfg := config.Config{NodeID: "a", DataDir: t.TempDir(), ChangelogSize: 100, ChangelogRetention: 1 << 20, ChangelogSegmentSize: 1 << 10}
+	c := newTestCluster(t, cfg.DataDir)
+	t.Cleanup(func() { c.Shutdown(context.Background()) })
+
+	require.NoError(t, os.MkdirAll(filepath.Join(cfg.DataDir, ChangelogDir), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.DataDir, ChangelogDir, FlushedFile), []byte(`{"seq":7}`), 0644))
+
+	defer func(check storage.CheckPolicy) { storage.StartupCheck = check }(storage.StartupCheck)
+	storage.StartupCheck = storage.CHECK_FAIL
+
+	_, err := New(logger, c, cfg, nil)
+	assert.ErrorIs(t, err, storage.ErrInconsistent)
+
+	// the next change isn't numbered over the flushed ones
+	storage.StartupCheck = storage.CHECK_WARN
+
+	r, err := New(logger, c, cfg, nil)
+	require.NoError(t, err)
+	defer r.Close()
+
+	require.NoError(t, r.Set("a", "1"))
+	assert.Equal(t, uint64(8), r.log.LastSeq())
+}
@@ -0,0 +1,757 @@
+package replication
+
+import (
+	"context"
+	"distrikv/config"
+	"distrikv/events"
+	"distrikv/pkg"
+	"distrikv/storage"
+	"distrikv/wal"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNotStandby error = errors.New("node is not a standby")
+
+const (
+	// changes fetched by a standby per request
+	followBatchSize = 1000
+
+	// wait between fetches once a standby caught up
+	followInterval = 200 * time.Millisecond
+)
+
+type Role int
+
+const (
+	ROLE_PRIMARY Role = iota
+
+	ROLE_STANDBY
+)
+
+func (r Role) String() string {
+	if r == ROLE_STANDBY {
+		return "standby"
+	}
+
+	return "primary"
+}
+
+type Store interface {
+	Get(ctx context.Context, key string) (*storage.KVData, error)
+	GetAt(ctx context.Context, key string, t time.Time) (*storage.KVData, error)
+	Versions(ctx context.Context, key string) ([]storage.Version, error)
+	GetValue(ctx context.Context, key string) (*storage.ValueReader, error)
+	MGet(ctx context.Context, keys []string) ([]*storage.KVData, error)
+	Set(key string, value string) error
+	Delete(key string) error
+	SetWithTTL(key string, value string, ttl time.Duration) error
+	SetWithMode(key string, value string, ttl time.Duration, mode storage.SetMode) error
+	Incr(key string, delta int64) (*storage.KVData, error)
+	GetDel(key string) (*storage.KVData, error)
+	GetSet(key string, value string) (*storage.KVData, error)
+	Append(key string, suffix string) (*storage.KVData, error)
+	AcquireLease(key string, owner string, ttl time.Duration) (*storage.KVData, error)
+	RenewLease(key string, owner string, ttl time.Duration) (*storage.KVData, error)
+	ReleaseLease(key string, owner string) error
+	Write(ops []storage.BatchOp) error
+	Scan(ctx context.Context, start string, end string, fn func(*storage.KVData) bool) error
+	Snapshot() (map[int]*storage.Snapshot, error)
+	Checkpoint(ctx context.Context, dir string) (map[int]*storage.Checkpoint, error)
+	SetSequencer(seq storage.Sequencer)
+	UnflushedSeq() (uint64, bool)
+}
+
+// ChangesResponse is the body of GET /internal/changes.
+type ChangesResponse struct {
+	Changes []Change `json:"changes"`
+	LastSeq uint64   `json:"last_seq"`
+
+	// Generation is the generation of the primary's data.
+	Generation string `json:"generation,omitempty"`
+}
+
+// Status is the replication state of the node.
+type Status struct {
+	Role       string `json:"role"`
+	PrimaryURL string `json:"primary_url,omitempty"`
+	LastSeq    uint64 `json:"last_seq"`
+	PrimarySeq uint64 `json:"primary_seq,omitempty"`
+
+	// Retained is the number of changes kept in memory for standbys.
+	Retained int `json:"retained"`
+
+	// SyncedAt is when a standby last caught up with the primary.
+	SyncedAt time.Time `json:"synced_at,omitzero"`
+}
+
+// Freshness is the freshness of the data served by a node.
+type Freshness struct {
+	AppliedSeq uint64
+	Standby    bool
+
+	// SyncedAt is when a standby last caught up
+	// with the primary, zero if it never did.
+	SyncedAt time.Time
+}
+
+// Replicator records the writes of a primary in a changelog,
+// and on a standby applies the changelog of the primary.
+// A standby rejects client writes until it is promoted.
+type Replicator struct {
+	logger *slog.Logger
+	store  Store
+	log    *Changelog
+	client *http.Client
+
+	// wal keeps the changes on disk for the consumers
+	// of the changelog, nil keeps them in memory only.
+	wal *wal.Log
+
+	// flushedPath is the FlushedFile of the changelog on disk,
+	// flushedMu serializes its updates.
+	flushedPath string
+	flushedMu   sync.Mutex
+
+	// replaying is the sequence of the change being replayed,
+	// zero once the changelog is replayed, see Sequence.
+	replaying atomic.Uint64
+
+	events *events.Bus
+
+	// nodeID identifies a standby to its primary.
+	nodeID     string
+	primaryURL string
+
+	// standbys are the standbys following a primary by node, their
+	// fetches close standbysChanged, replaced by the next one.
+	standbysMu      sync.Mutex
+	standbys        map[string]standby
+	standbysChanged chan struct{}
+
+	// mu serializes writes with their changelog entries,
+	// so changes are logged in the order they are applied.
+	mu         sync.Mutex
+	role       Role
+	primarySeq uint64
+	cancel     context.CancelFunc
+
+	// primaryGeneration is the generation of the primary's data
+	// seen by the first fetch, the standby stops following a
+	// primary whose data was replaced or restored.
+	primaryGeneration string
+
+	// standby mirrors the role and syncedAt holds the unix nanos of
+	// the last catch up, reads check them without waiting for mu.
+	standby  atomic.Bool
+	syncedAt atomic.Int64
+}
+
+// New creates the replicator of store, every applied write is published
+// on bus. With a changelog retention the changes are also logged to the
+// changelog directory of the data dir, and numbered after its last one.
+// The changes logged after the last flush of the stores are replayed into
+// them first, they were lost with the memtables if the node crashed.
+func New(logger *slog.Logger, store Store, cfg config.Config, bus *events.Bus) (*Replicator, error) {
+	r := &Replicator{
+		logger:     logger,
+		store:      store,
+		events:     bus,
+		log:        NewChangelog(cfg.ChangelogSize),
+		client:     &http.Client{Timeout: 10 * time.Second, Transport: pkg.PeerTransport()},
+		nodeID:     cfg.NodeID,
+		primaryURL: cfg.PrimaryURL,
+
+		standbys:        make(map[string]standby),
+		standbysChanged: make(chan struct{}),
+	}
+
+	switch cfg.Role {
+	case "", "primary":
+		r.role = ROLE_PRIMARY
+	case "standby":
+		if cfg.PrimaryURL == "" {
+			return nil, errors.New("a standby requires a primary url")
+		}
+
+		r.role = ROLE_STANDBY
+		r.standby.Store(true)
+	default:
+		return nil, fmt.Errorf("unknown role %q", cfg.Role)
+	}
+
+	if cfg.ChangelogRetention <= 0 {
+		logger.Info("changelog kept in memory only, writes can't be acknowledged once logged")
+		return r, nil
+	}
+
+	dir := filepath.Join(cfg.DataDir, ChangelogDir)
+
+	var err error
+	r.wal, err = wal.OpenLog(dir, int64(cfg.ChangelogSegmentSize), int64(cfg.ChangelogRetention))
+	if err != nil {
+		return nil, fmt.Errorf("open changelog: %w", err)
+	}
+
+	r.flushedPath = filepath.Join(dir, FlushedFile)
+	r.log.Reset(r.wal.LastSeq())
+
+	// the stores tell which changes their flushes made durable
+	store.SetSequencer(r)
+
+	replayed, err := r.replay()
+	if err != nil {
+		store.SetSequencer(nil)
+		r.wal.Close()
+		return nil, fmt.Errorf("replay changelog: %w", err)
+	}
+
+	if replayed > 0 {
+		logger.Info("changelog replayed", "changes", replayed, "last_seq", r.wal.LastSeq())
+	}
+
+	return r, nil
+}
+
+// Start follows the primary when running as a standby.
+func (r *Replicator) Start(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.role != ROLE_STANDBY {
+		return
+	}
+
+	ctx, r.cancel = context.WithCancel(ctx)
+	go r.follow(ctx)
+}
+
+func (r *Replicator) Get(ctx context.Context, key string) (*storage.KVData, error) {
+	return r.store.Get(ctx, key)
+}
+
+func (r *Replicator) MGet(ctx context.Context, keys []string) ([]*storage.KVData, error) {
+	return r.store.MGet(ctx, keys)
+}
+
+func (r *Replicator) GetAt(ctx context.Context, key string, t time.Time) (*storage.KVData, error) {
+	return r.store.GetAt(ctx, key, t)
+}
+
+func (r *Replicator) Versions(ctx context.Context, key string) ([]storage.Version, error) {
+	return r.store.Versions(ctx, key)
+}
+
+func (r *Replicator) GetValue(ctx context.Context, key string) (*storage.ValueReader, error) {
+	return r.store.GetValue(ctx, key)
+}
+
+func (r *Replicator) Scan(ctx context.Context, start string, end string, fn func(*storage.KVData) bool) error {
+	return r.store.Scan(ctx, start, end, fn)
+}
+
+func (r *Replicator) Set(key string, value string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.role == ROLE_STANDBY {
+		return storage.ErrReadOnly
+	}
+
+	if err := r.store.Set(key, value); err != nil {
+		return err
+	}
+
+	r.record(Change{Op: OP_SET, Key: key, Value: value})
+	return nil
+}
+
+// SetWithTTL stores key until ttl elapsed, standbys
+// expire it at the same time as the primary.
+func (r *Replicator) SetWithTTL(key string, value string, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.role == ROLE_STANDBY {
+		return storage.ErrReadOnly
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	if err := r.store.SetWithTTL(key, value, ttl); err != nil {
+		return err
+	}
+
+	r.record(Change{Op: OP_SET, Key: key, Value: value, ExpiresAt: expiresAt})
+	return nil
+}
+
+// SetWithMode stores key when mode allows it, standbys apply
+// the write without checking the mode again.
+func (r *Replicator) SetWithMode(key string, value string, ttl time.Duration, mode storage.SetMode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.role == ROLE_STANDBY {
+		return storage.ErrReadOnly
+	}
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+
+	if err := r.store.SetWithMode(key, value, ttl, mode); err != nil {
+		return err
+	}
+
+	r.record(Change{Op: OP_SET, Key: key, Value: value, ExpiresAt: expiresAt})
+	return nil
+}
+
+// Incr adds delta to the integer value of key,
+// standbys apply the resulting value.
+func (r *Replicator) Incr(key string, delta int64) (*storage.KVData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.role == ROLE_STANDBY {
+		return nil, storage.ErrReadOnly
+	}
+
+	res, err := r.store.Incr(key, delta)
+	if err != nil {
+		return nil, err
+	}
+
+	r.record(Change{Op: OP_SET, Key: key, Value: res.Value, ExpiresAt: res.ExpiresAt})
+	return res, nil
+}
+
+// GetDel deletes key and returns the entry it held,
+// standbys apply the delete.
+func (r *Replicator) GetDel(key string) (*storage.KVData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.role == ROLE_STANDBY {
+		return nil, storage.ErrReadOnly
+	}
+
+	res, err := r.store.GetDel(key)
+	if err != nil {
+		return nil, err
+	}
+
+	r.record(Change{Op: OP_DELETE, Key: key})
+	return res, nil
+}
+
+// GetSet stores key and returns the entry it replaced,
+// standbys apply the write.
+func (r *Replicator) GetSet(key string, value string) (*storage.KVData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.role == ROLE_STANDBY {
+		return nil, storage.ErrReadOnly
+	}
+
+	res, err := r.store.GetSet(key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	r.record(Change{Op: OP_SET, Key: key, Value: value})
+	return res, nil
+}
+
+// Append appends suffix to the value of key,
+// standbys apply the resulting value.
+func (r *Replicator) Append(key string, suffix string) (*storage.KVData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.role == ROLE_STANDBY {
+		return nil, storage.ErrReadOnly
+	}
+
+	res, err := r.store.Append(key, suffix)
+	if err != nil {
+		return nil, err
+	}
+
+	r.record(Change{Op: OP_SET, Key: key, Value: res.Value, ExpiresAt: res.ExpiresAt})
+	return res, nil
+}
+
+// AcquireLease makes owner the holder of the lease of key,
+// standbys apply the lease written.
+func (r *Replicator) AcquireLease(key string, owner string, ttl time.Duration) (*storage.KVData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.role == ROLE_STANDBY {
+		return nil, storage.ErrReadOnly
+	}
+
+	res, err := r.store.AcquireLease(key, owner, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	r.record(Change{Op: OP_SET, Key: key, Value: res.Value, ExpiresAt: res.ExpiresAt})
+	return res, nil
+}
+
+// RenewLease extends the lease of key held by owner,
+// standbys apply the lease written.
+func (r *Replicator) RenewLease(key string, owner string, ttl time.Duration) (*storage.KVData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.role == ROLE_STANDBY {
+		return nil, storage.ErrReadOnly
+	}
+
+	res, err := r.store.RenewLease(key, owner, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	r.record(Change{Op: OP_SET, Key: key, Value: res.Value, ExpiresAt: res.ExpiresAt})
+	return res, nil
+}
+
+// ReleaseLease deletes the lease of key held by owner,
+// standbys apply the delete.
+func (r *Replicator) ReleaseLease(key string, owner string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.role == ROLE_STANDBY {
+		return storage.ErrReadOnly
+	}
+
+	if err := r.store.ReleaseLease(key, owner); err != nil {
+		return err
+	}
+
+	r.record(Change{Op: OP_DELETE, Key: key})
+	return nil
+}
+
+func (r *Replicator) Delete(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.role == ROLE_STANDBY {
+		return storage.ErrReadOnly
+	}
+
+	if err := r.store.Delete(key); err != nil {
+		return err
+	}
+
+	r.record(Change{Op: OP_DELETE, Key: key})
+	return nil
+}
+
+// DeleteExpired deletes a key expired by the retention or the eviction of
+// its store, logged like Delete. A standby logs the changes of its primary
+// only, the key is deleted from its store alone.
+func (r *Replicator) DeleteExpired(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.store.Delete(key); err != nil {
+		return err
+	}
+
+	if r.role != ROLE_STANDBY {
+		r.record(Change{Op: OP_DELETE, Key: key})
+	}
+
+	return nil
+}
+
+// Write applies a write batch and records its ops in order, logged on
+// disk as a single record. Standbys apply every op on its own.
+func (r *Replicator) Write(ops []storage.BatchOp) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.role == ROLE_STANDBY {
+		return storage.ErrReadOnly
+	}
+
+	if err := r.store.Write(ops); err != nil {
+		return err
+	}
+
+	changes := make([]Change, 0, len(ops))
+	for _, op := range ops {
+		switch op.Type {
+		case storage.BATCH_PUT:
+			changes = append(changes, Change{Op: OP_SET, Key: op.Key, Value: op.Value})
+		case storage.BATCH_DELETE:
+			changes = append(changes, Change{Op: OP_DELETE, Key: op.Key})
+		case storage.BATCH_DELETE_RANGE:
+			changes = append(changes, Change{Op: OP_DELETE_RANGE, Key: op.Key, End: op.End})
+		}
+	}
+
+	r.record(changes...)
+	return nil
+}
+
+// record appends applied writes to the changelog and publishes them,
+// the writes of a batch are logged on disk as a single record.
+// Must be called with mu held.
+func (r *Replicator) record(changes ...Change) {
+	if len(changes) == 0 {
+		return
+	}
+
+	for i := range changes {
+		changes[i].Seq = r.log.Append(changes[i])
+	}
+
+	// the writes are applied, changes that can't be
+	// logged are missing from the history on disk
+	if r.wal != nil {
+		if err := r.wal.Append(walEntry(changes)); err != nil {
+			r.logger.Error("error logging change", "seq", changes[len(changes)-1].Seq, "err", err)
+		}
+	}
+
+	for _, change := range changes {
+		r.events.Publish(events.TOPIC_WRITE, events.WriteEvent{
+			Seq:       change.Seq,
+			Op:        change.Op.String(),
+			Key:       change.Key,
+			End:       change.End,
+			Value:     change.Value,
+			ExpiresAt: change.ExpiresAt,
+		})
+	}
+}
+
+// Snapshot takes a snapshot of every local shard with writes paused
+// and calls fn with it and the sequence of the last change it contains.
+// The snapshot is removed once fn returns.
+func (r *Replicator) Snapshot(fn func(seq uint64, shards map[int]*storage.Snapshot) error) error {
+	r.mu.Lock()
+	seq := r.log.LastSeq()
+	shards, err := r.store.Snapshot()
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		for _, snapshot := range shards {
+			snapshot.Close()
+		}
+	}()
+
+	return fn(seq, shards)
+}
+
+// Reset continues following the primary after seq,
+// the sequence of a snapshot installed before starting.
+func (r *Replicator) Reset(seq uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.log.Reset(seq)
+}
+
+// Changes returns up to limit changes after seq to the standby node,
+// which applied the changes up to seq. An empty node is anonymous.
+func (r *Replicator) Changes(seq uint64, limit int, node string) (*ChangesResponse, error) {
+	if node != "" {
+		r.follows(node, seq)
+	}
+
+	changes, err := r.log.Since(seq, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChangesResponse{
+		Changes: changes,
+		LastSeq: r.log.LastSeq(),
+	}, nil
+}
+
+// Promote stops following the primary and makes this node writable.
+func (r *Replicator) Promote() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.role != ROLE_STANDBY {
+		return ErrNotStandby
+	}
+
+	if r.cancel != nil {
+		r.cancel()
+	}
+
+	r.role = ROLE_PRIMARY
+	r.standby.Store(false)
+	r.logger.Info("promoted to primary", "seq", r.log.LastSeq())
+
+	return nil
+}
+
+func (r *Replicator) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := Status{
+		Role:     r.role.String(),
+		LastSeq:  r.log.LastSeq(),
+		Retained: r.log.Len(),
+	}
+
+	if r.role == ROLE_STANDBY {
+		status.PrimaryURL = r.primaryURL
+		status.PrimarySeq = r.primarySeq
+		status.SyncedAt = r.Freshness().SyncedAt
+	}
+
+	return status
+}
+
+// Freshness returns the freshness of the data served by the node.
+func (r *Replicator) Freshness() Freshness {
+	freshness := Freshness{
+		AppliedSeq: r.log.LastSeq(),
+		Standby:    r.standby.Load(),
+	}
+
+	if syncedAt := r.syncedAt.Load(); syncedAt != 0 {
+		freshness.SyncedAt = time.Unix(0, syncedAt)
+	}
+
+	return freshness
+}
+
+// follow applies the changes of the primary until ctx is done.
+func (r *Replicator) follow(ctx context.Context) {
+	r.logger.Info("following primary", "url", r.primaryURL)
+
+	for {
+		applied, err := r.fetch(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.logger.Error("error following primary", "err", err)
+		}
+
+		if applied == 0 || err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(followInterval):
+			}
+		}
+	}
+}
+
+// fetch applies the next batch of changes and returns how many were applied.
+func (r *Replicator) fetch(ctx context.Context) (int, error) {
+	url := fmt.Sprintf("%s/internal/changes?from=%d&limit=%d&node=%s", r.primaryURL, r.log.LastSeq(), followBatchSize, url.QueryEscape(r.nodeID))
+
+	// the primary responds with its state as of a time after start
+	start := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusGone {
+		return 0, errors.New("standby fell behind the primary changelog, restart it with an empty data dir to bootstrap from a snapshot")
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("primary responded with %s", res.Status)
+	}
+
+	var body ChangesResponse
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// promoted while the request was in flight
+	if r.role != ROLE_STANDBY {
+		return 0, nil
+	}
+
+	if r.primaryGeneration == "" {
+		r.primaryGeneration = body.Generation
+	}
+
+	if body.Generation != r.primaryGeneration {
+		return 0, fmt.Errorf("primary generation changed from %s to %s, its history was rewritten, restart the standby with an empty data dir to bootstrap from a snapshot", r.primaryGeneration, body.Generation)
+	}
+
+	r.primarySeq = body.LastSeq
+
+	for _, change := range body.Changes {
+		if err := r.apply(change); err != nil {
+			return 0, fmt.Errorf("apply change %d: %w", change.Seq, err)
+		}
+
+		r.record(change)
+	}
+
+	if r.log.LastSeq() >= body.LastSeq {
+		r.syncedAt.Store(start.UnixNano())
+	}
+
+	return len(body.Changes), nil
+}
+
+// apply applies a change to the store without recording it.
+func (r *Replicator) apply(change Change) error {
+	switch change.Op {
+	case OP_DELETE:
+		return r.store.Delete(change.Key)
+	case OP_DELETE_RANGE:
+		return r.store.Write([]storage.BatchOp{{Type: storage.BATCH_DELETE_RANGE, Key: change.Key, End: change.End}})
+	default:
+		return r.applySet(change)
+	}
+}
+
+// applySet applies a set of the primary or of the changelog,
+// keys that expired before they were applied are deleted.
+func (r *Replicator) applySet(change Change) error {
+	if change.ExpiresAt.IsZero() {
+		return r.store.Set(change.Key, change.Value)
+	}
+
+	ttl := time.Until(change.ExpiresAt)
+	if ttl <= 0 {
+		return r.store.Delete(change.Key)
+	}
+
+	return r.store.SetWithTTL(change.Key, change.Value, ttl)
+}
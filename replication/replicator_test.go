@@ -0,0 +1,105 @@
+package replication
+
+import (
+	"context"
+	"distrikv/config"
+	"distrikv/storage"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// servePrimary serves the changes of primary to standbys,
+// like GET /internal/changes.
+func servePrimary(t *testing.T, primary *Replicator) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from, _ := strconv.ParseUint(r.URL.Query().Get("from"), 10, 64)
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+		res, err := primary.Changes(from, limit, r.URL.Query().Get("node"))
+		if errors.Is(err, ErrChangesTruncated) || errors.Is(err, ErrChangesAhead) {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		res.Generation = "1"
+		json.NewEncoder(w).Encode(res)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestStandbyFollowsPrimary(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.DiscardHandler)
+
+	a := newTestCluster(t, t.TempDir())
+	t.Cleanup(func() { a.Shutdown(ctx) })
+
+	primary, err := New(logger, a, config.Config{NodeID: "a", ChangelogSize: 100}, nil)
+	require.NoError(t, err)
+
+	server := servePrimary(t, primary)
+
+	b := newTestCluster(t, t.TempDir())
+	t.Cleanup(func() { b.Shutdown(ctx) })
+
+	standby, err := New(logger, b, config.Config{NodeID: "b", ChangelogSize: 100, Role: "standby", PrimaryURL: server.URL}, nil)
+	require.NoError(t, err)
+
+	followCtx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+	standby.Start(followCtx)
+
+	require.NoError(t, primary.Set("a", "1"))
+	require.NoError(t, primary.Write([]storage.BatchOp{
+		{Type: storage.BATCH_PUT, Key: "b", Value: "2"},
+		{Type: storage.BATCH_DELETE, Key: "a"},
+	}))
+
+	// the standby applies the changes of the primary in order
+	require.Eventually(t, func() bool {
+		return standby.Freshness().AppliedSeq == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err = standby.Get(ctx, "a")
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+	data, err := standby.Get(ctx, "b")
+	require.NoError(t, err)
+	assert.Equal(t, "2", data.Value)
+
+	status := standby.Status()
+	assert.Equal(t, ROLE_STANDBY.String(), status.Role)
+	assert.Equal(t, uint64(3), status.PrimarySeq)
+	assert.False(t, standby.Freshness().SyncedAt.IsZero())
+
+	assert.ErrorIs(t, standby.Set("c", "3"), storage.ErrReadOnly)
+	assert.ErrorIs(t, primary.Promote(), ErrNotStandby)
+
+	// once promoted the standby takes writes, numbered after the primary's
+	require.NoError(t, standby.Promote())
+	require.NoError(t, standby.Set("c", "3"))
+	assert.Equal(t, uint64(4), standby.Freshness().AppliedSeq)
+	assert.Equal(t, ROLE_PRIMARY.String(), standby.Status().Role)
+
+	// and no longer follows the primary
+	require.NoError(t, primary.Set("d", "4"))
+	time.Sleep(2 * followInterval)
+	_, err = standby.Get(ctx, "d")
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+}
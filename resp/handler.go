@@ -0,0 +1,585 @@
+package resp
+
+import (
+	"context"
+	"distrikv/auth"
+	"distrikv/config"
+	"distrikv/storage"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Store interface {
+	Get(ctx context.Context, key string) (*storage.KVData, error)
+	MGet(ctx context.Context, keys []string) ([]*storage.KVData, error)
+	Set(key string, value string) error
+	Delete(key string) error
+	SetWithTTL(key string, value string, ttl time.Duration) error
+	SetWithMode(key string, value string, ttl time.Duration, mode storage.SetMode) error
+	Incr(key string, delta int64) (*storage.KVData, error)
+	GetDel(key string) (*storage.KVData, error)
+	GetSet(key string, value string) (*storage.KVData, error)
+	Append(key string, suffix string) (*storage.KVData, error)
+	Scan(ctx context.Context, start string, end string, fn func(*storage.KVData) bool) error
+}
+
+type Handler struct {
+	store Store
+	cfg   config.Config
+}
+
+func NewHandler(store Store, cfg config.Config) *Handler {
+	return &Handler{
+		store: store,
+		cfg:   cfg,
+	}
+}
+
+// commandRoles are the roles required by the commands.
+var commandRoles = map[string]string{
+	"PING":    auth.ROLE_READ,
+	"COMMAND": auth.ROLE_READ,
+	"GET":     auth.ROLE_READ,
+	"EXISTS":  auth.ROLE_READ,
+	"MGET":    auth.ROLE_READ,
+	"SCAN":    auth.ROLE_READ,
+	"TTL":     auth.ROLE_READ,
+	"SET":     auth.ROLE_WRITE,
+	"SETNX":   auth.ROLE_WRITE,
+	"GETDEL":  auth.ROLE_WRITE,
+	"GETSET":  auth.ROLE_WRITE,
+	"APPEND":  auth.ROLE_WRITE,
+	"DEL":     auth.ROLE_WRITE,
+	"MSET":    auth.ROLE_WRITE,
+	"INCR":    auth.ROLE_WRITE,
+	"DECR":    auth.ROLE_WRITE,
+	"INCRBY":  auth.ROLE_WRITE,
+	"DECRBY":  auth.ROLE_WRITE,
+}
+
+// commandKeys returns the keys cmd reads or writes.
+func commandKeys(cmd string, args []string) []string {
+	switch cmd {
+	case "GET", "SET", "SETNX", "GETDEL", "GETSET", "APPEND", "TTL", "INCR", "DECR", "INCRBY", "DECRBY":
+		return args[1:min(2, len(args))]
+	case "DEL", "EXISTS", "MGET":
+		return args[1:]
+	case "MSET":
+		var keys []string
+		for i := 1; i < len(args); i += 2 {
+			keys = append(keys, args[i])
+		}
+
+		return keys
+	default:
+		return nil
+	}
+}
+
+// Handle runs a single command of p, nil without authentication,
+// and writes its reply to w.
+func (h *Handler) Handle(w *writer, p *auth.Principal, args []string) {
+	cmd := strings.ToUpper(args[0])
+	if role, ok := commandRoles[cmd]; ok {
+		if err := p.Authorize(role, commandKeys(cmd, args)...); err != nil {
+			w.errorCode("NOPERM", err.Error())
+			return
+		}
+	}
+
+	ctx := context.Background()
+	if h.cfg.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.cfg.RequestTimeout)
+		defer cancel()
+	}
+
+	switch cmd {
+	case "PING":
+		h.ping(w, args)
+	case "GET":
+		h.get(ctx, w, args)
+	case "SET":
+		h.set(w, args)
+	case "SETNX":
+		h.setnx(w, args)
+	case "GETDEL":
+		h.getdel(w, args)
+	case "GETSET":
+		h.getset(w, args)
+	case "APPEND":
+		h.append(w, args)
+	case "DEL":
+		h.del(ctx, w, args)
+	case "EXISTS":
+		h.exists(ctx, w, args)
+	case "MGET":
+		h.mget(ctx, w, args)
+	case "MSET":
+		h.mset(w, args)
+	case "SCAN":
+		h.scan(ctx, w, p, args)
+	case "TTL":
+		h.ttl(ctx, w, args)
+	case "INCR", "DECR", "INCRBY", "DECRBY":
+		h.incr(w, args)
+	case "COMMAND":
+		// clients query the command table on connect,
+		// an empty reply makes them fall back to defaults.
+		w.array(0)
+	default:
+		w.error(fmt.Sprintf("unknown command '%s'", args[0]))
+	}
+}
+
+func wrongArgs(w *writer, cmd string) {
+	w.error(fmt.Sprintf("wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
+
+// lookup returns the value of key and whether it exists.
+func (h *Handler) lookup(ctx context.Context, key string) (string, bool, error) {
+	res, err := h.store.Get(ctx, key)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return "", false, nil
+	}
+
+	if err != nil {
+		return "", false, err
+	}
+
+	return res.Value, true, nil
+}
+
+func (h *Handler) ping(w *writer, args []string) {
+	if len(args) > 1 {
+		w.bulk(args[1])
+		return
+	}
+
+	w.simple("PONG")
+}
+
+func (h *Handler) get(ctx context.Context, w *writer, args []string) {
+	if len(args) != 2 {
+		wrongArgs(w, args[0])
+		return
+	}
+
+	value, ok, err := h.lookup(ctx, args[1])
+	if err != nil {
+		w.storeError(err)
+		return
+	}
+
+	if !ok {
+		w.null()
+		return
+	}
+
+	w.bulk(value)
+}
+
+// getdel deletes a key, replying the value it held.
+func (h *Handler) getdel(w *writer, args []string) {
+	if len(args) != 2 {
+		wrongArgs(w, args[0])
+		return
+	}
+
+	res, err := h.store.GetDel(args[1])
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		w.null()
+		return
+	}
+
+	if err != nil {
+		w.storeError(err)
+		return
+	}
+
+	w.bulk(res.Value)
+}
+
+// getset sets a key, replying the value it replaced.
+func (h *Handler) getset(w *writer, args []string) {
+	if len(args) != 3 {
+		wrongArgs(w, args[0])
+		return
+	}
+
+	res, err := h.store.GetSet(args[1], args[2])
+	if err != nil {
+		w.storeError(err)
+		return
+	}
+
+	if res == nil {
+		w.null()
+		return
+	}
+
+	w.bulk(res.Value)
+}
+
+// append appends to a key, replying the length of its value.
+func (h *Handler) append(w *writer, args []string) {
+	if len(args) != 3 {
+		wrongArgs(w, args[0])
+		return
+	}
+
+	res, err := h.store.Append(args[1], args[2])
+	if err != nil {
+		w.storeError(err)
+		return
+	}
+
+	w.integer(int64(len(res.Value)))
+}
+
+func (h *Handler) set(w *writer, args []string) {
+	if len(args) < 3 {
+		wrongArgs(w, args[0])
+		return
+	}
+
+	// only the expiry options EX seconds and PX milliseconds,
+	// and the conditions NX and XX are supported
+	var ttl time.Duration
+	mode := storage.SET_ALWAYS
+	for i := 3; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); option {
+		case "NX", "XX":
+			if mode != storage.SET_ALWAYS {
+				w.error("syntax error")
+				return
+			}
+
+			mode = storage.SET_IF_NOT_EXISTS
+			if option == "XX" {
+				mode = storage.SET_MUST_EXIST
+			}
+		case "EX", "PX":
+			if ttl > 0 || i+1 == len(args) {
+				w.error("syntax error")
+				return
+			}
+
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil || n <= 0 {
+				w.error("invalid expire time in 'set' command")
+				return
+			}
+
+			ttl = time.Duration(n) * time.Second
+			if option == "PX" {
+				ttl = time.Duration(n) * time.Millisecond
+			}
+		default:
+			w.error("SET options other than EX, PX, NX and XX are not supported")
+			return
+		}
+	}
+
+	var err error
+	switch {
+	case mode != storage.SET_ALWAYS:
+		err = h.store.SetWithMode(args[1], args[2], ttl, mode)
+	case ttl > 0:
+		err = h.store.SetWithTTL(args[1], args[2], ttl)
+	default:
+		err = h.store.Set(args[1], args[2])
+	}
+
+	// keys not written for their condition are a null reply
+	if errors.Is(err, storage.ErrKeyExists) || errors.Is(err, storage.ErrKeyNotFound) {
+		w.null()
+		return
+	}
+
+	if err != nil {
+		w.storeError(err)
+		return
+	}
+
+	w.simple("OK")
+}
+
+// setnx creates a missing key, replying 1 when it was created.
+func (h *Handler) setnx(w *writer, args []string) {
+	if len(args) != 3 {
+		wrongArgs(w, args[0])
+		return
+	}
+
+	err := h.store.SetWithMode(args[1], args[2], 0, storage.SET_IF_NOT_EXISTS)
+	if errors.Is(err, storage.ErrKeyExists) {
+		w.integer(0)
+		return
+	}
+
+	if err != nil {
+		w.storeError(err)
+		return
+	}
+
+	w.integer(1)
+}
+
+func (h *Handler) del(ctx context.Context, w *writer, args []string) {
+	if len(args) < 2 {
+		wrongArgs(w, args[0])
+		return
+	}
+
+	var deleted int64
+	for _, key := range args[1:] {
+		_, ok, err := h.lookup(ctx, key)
+		if err != nil {
+			w.storeError(err)
+			return
+		}
+
+		if ok {
+			if err := h.store.Delete(key); err != nil {
+				w.storeError(err)
+				return
+			}
+
+			deleted++
+		}
+	}
+
+	w.integer(deleted)
+}
+
+func (h *Handler) exists(ctx context.Context, w *writer, args []string) {
+	if len(args) < 2 {
+		wrongArgs(w, args[0])
+		return
+	}
+
+	var count int64
+	for _, key := range args[1:] {
+		_, ok, err := h.lookup(ctx, key)
+		if err != nil {
+			w.storeError(err)
+			return
+		}
+
+		if ok {
+			count++
+		}
+	}
+
+	w.integer(count)
+}
+
+func (h *Handler) mget(ctx context.Context, w *writer, args []string) {
+	if len(args) < 2 {
+		wrongArgs(w, args[0])
+		return
+	}
+
+	if len(args)-1 > h.cfg.MaxBatchSize {
+		w.error(fmt.Sprintf("MGET exceeds %d keys", h.cfg.MaxBatchSize))
+		return
+	}
+
+	values, err := h.store.MGet(ctx, args[1:])
+	if err != nil {
+		w.storeError(err)
+		return
+	}
+
+	w.array(len(values))
+	for _, value := range values {
+		if value == nil {
+			w.null()
+			continue
+		}
+
+		w.bulk(value.Value)
+	}
+}
+
+func (h *Handler) mset(w *writer, args []string) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		wrongArgs(w, args[0])
+		return
+	}
+
+	if (len(args)-1)/2 > h.cfg.MaxBatchSize {
+		w.error(fmt.Sprintf("MSET exceeds %d keys", h.cfg.MaxBatchSize))
+		return
+	}
+
+	for i := 1; i < len(args); i += 2 {
+		if err := h.store.Set(args[i], args[i+1]); err != nil {
+			w.storeError(err)
+			return
+		}
+	}
+
+	w.simple("OK")
+}
+
+// scan implements SCAN cursor [MATCH pattern] [COUNT count].
+// The cursor is the number of keys already iterated,
+// COUNT is capped by the configured scan limit. Keys outside
+// the prefixes of p are skipped like keys not matching the pattern.
+func (h *Handler) scan(ctx context.Context, w *writer, p *auth.Principal, args []string) {
+	if len(args) < 2 {
+		wrongArgs(w, args[0])
+		return
+	}
+
+	cursor, err := strconv.Atoi(args[1])
+	if err != nil || cursor < 0 {
+		w.error("invalid cursor")
+		return
+	}
+
+	pattern := "*"
+	count := 0
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			w.error("syntax error")
+			return
+		}
+
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil || count < 1 {
+				w.error("value is not an integer or out of range")
+				return
+			}
+		default:
+			w.error("syntax error")
+			return
+		}
+	}
+
+	count = h.cfg.ScanLimit(count)
+
+	var keys []string
+	var iterated int
+	var done = true
+
+	err = h.store.Scan(ctx, "", "", func(data *storage.KVData) bool {
+		iterated++
+		if iterated <= cursor {
+			return true
+		}
+
+		if iterated > cursor+count {
+			done = false
+			return false
+		}
+
+		if p != nil && !p.CanAccess(data.Key) {
+			return true
+		}
+
+		if ok, _ := path.Match(pattern, data.Key); ok {
+			keys = append(keys, data.Key)
+		}
+
+		return true
+	})
+	if err != nil {
+		w.storeError(err)
+		return
+	}
+
+	next := "0"
+	if !done {
+		next = strconv.Itoa(cursor + count)
+	}
+
+	w.array(2)
+	w.bulk(next)
+	w.array(len(keys))
+	for _, key := range keys {
+		w.bulk(key)
+	}
+}
+
+// ttl replies -2 for missing keys and -1 for existing keys,
+// as keys never expire.
+// incr handles INCR key, DECR key, INCRBY key delta and DECRBY key delta.
+func (h *Handler) incr(w *writer, args []string) {
+	cmd := strings.ToUpper(args[0])
+	by := strings.HasSuffix(cmd, "BY")
+	if (by && len(args) != 3) || (!by && len(args) != 2) {
+		wrongArgs(w, args[0])
+		return
+	}
+
+	delta := int64(1)
+	if by {
+		var err error
+		delta, err = strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			w.error(storage.ErrNotInteger.Error())
+			return
+		}
+	}
+
+	if strings.HasPrefix(cmd, "DECR") {
+		if delta == math.MinInt64 {
+			w.error("decrement would overflow")
+			return
+		}
+
+		delta = -delta
+	}
+
+	res, err := h.store.Incr(args[1], delta)
+	if err != nil {
+		w.storeError(err)
+		return
+	}
+
+	n, err := strconv.ParseInt(res.Value, 10, 64)
+	if err != nil {
+		w.storeError(err)
+		return
+	}
+
+	w.integer(n)
+}
+
+func (h *Handler) ttl(ctx context.Context, w *writer, args []string) {
+	if len(args) != 2 {
+		wrongArgs(w, args[0])
+		return
+	}
+
+	res, err := h.store.Get(ctx, args[1])
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		w.integer(-2)
+		return
+	}
+
+	if err != nil {
+		w.storeError(err)
+		return
+	}
+
+	if res.ExpiresAt.IsZero() {
+		w.integer(-1)
+		return
+	}
+
+	w.integer(int64(time.Until(res.ExpiresAt).Round(time.Second) / time.Second))
+}
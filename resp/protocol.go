@@ -0,0 +1,178 @@
+package resp
+
+import (
+	"bufio"
+	"distrikv/cluster"
+	"distrikv/storage"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var ErrProtocol error = errors.New("protocol error")
+
+// Limits of the commands read, checked before anything is allocated
+// for them, like the 1M arguments and 512MB bulk strings of Redis.
+const (
+	// MaxArgs is the most arguments of a command.
+	MaxArgs = 1 << 20
+
+	// MaxBulkSize caps bulk strings whatever the size limits of the store.
+	MaxBulkSize = 512 << 20
+
+	// MaxInlineSize is the longest line, an inline command or a header.
+	MaxInlineSize = 64 << 10
+
+	// MaxUnauthenticatedArgs and MaxUnauthenticatedBulkSize bound the
+	// commands of connections that didn't AUTH yet: AUTH [username] <key>.
+	MaxUnauthenticatedArgs     = 3
+	MaxUnauthenticatedBulkSize = 16 << 10
+)
+
+// maxBulkSize returns the longest bulk string read, a key or a value
+// within the size limits of the store, see storage.SetSizeLimits.
+func maxBulkSize(authenticated bool) int {
+	if !authenticated {
+		return MaxUnauthenticatedBulkSize
+	}
+
+	return min(MaxBulkSize, max(storage.MaxKeySize, storage.MaxValueSize))
+}
+
+// readCommand reads a single command from r.
+// Commands are either RESP arrays of bulk strings, as sent by
+// client libraries, or inline space separated commands.
+// Commands over the limits are an ErrProtocol, tighter ones
+// apply until the connection is authenticated.
+func readCommand(r *bufio.Reader, authenticated bool) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 {
+		return nil, nil
+	}
+
+	maxArgs := MaxArgs
+	if !authenticated {
+		maxArgs = MaxUnauthenticatedArgs
+	}
+
+	if line[0] != '*' {
+		args := strings.Fields(line)
+		if len(args) > maxArgs {
+			return nil, fmt.Errorf("%w: too many arguments", ErrProtocol)
+		}
+
+		return args, nil
+	}
+
+	count, err := strconv.Atoi(line[1:])
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
+	}
+
+	if count > maxArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
+	}
+
+	maxSize := maxBulkSize(authenticated)
+
+	args := make([]string, 0, count)
+	for range count {
+		header, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(header) == 0 || header[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got '%s'", ErrProtocol, header)
+		}
+
+		size, err := strconv.Atoi(header[1:])
+		if err != nil || size < 0 || size > maxSize {
+			return nil, fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+		}
+
+		// bulk strings are terminated by CRLF
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+
+		args = append(args, string(buf[:size]))
+	}
+
+	return args, nil
+}
+
+// readLine reads a line of at most MaxInlineSize bytes.
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > MaxInlineSize+2 {
+			return "", fmt.Errorf("%w: too big inline request", ErrProtocol)
+		}
+
+		line = append(line, chunk...)
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+// writer writes RESP replies.
+type writer struct {
+	w *bufio.Writer
+}
+
+func (w *writer) simple(s string) {
+	fmt.Fprintf(w.w, "+%s\r\n", s)
+}
+
+func (w *writer) error(s string) {
+	w.errorCode("ERR", s)
+}
+
+// errorCode writes an error with another code than ERR.
+func (w *writer) errorCode(code string, s string) {
+	fmt.Fprintf(w.w, "-%s %s\r\n", code, s)
+}
+
+// storeError writes an error of the store, keys served by another
+// node are answered like Redis Cluster with MOVED <shard> <addr>.
+func (w *writer) storeError(err error) {
+	var moved *cluster.MovedError
+	if errors.As(err, &moved) && moved.Node.Addr != "" {
+		fmt.Fprintf(w.w, "-MOVED %d %s\r\n", moved.Shard, moved.Node.Addr)
+		return
+	}
+
+	w.error(err.Error())
+}
+
+func (w *writer) integer(n int64) {
+	fmt.Fprintf(w.w, ":%d\r\n", n)
+}
+
+func (w *writer) bulk(s string) {
+	fmt.Fprintf(w.w, "$%d\r\n%s\r\n", len(s), s)
+}
+
+func (w *writer) null() {
+	w.w.WriteString("$-1\r\n")
+}
+
+func (w *writer) array(n int) {
+	fmt.Fprintf(w.w, "*%d\r\n", n)
+}
@@ -0,0 +1,72 @@
+package resp
+
+import (
+	"bufio"
+	"distrikv/storage"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func read(input string, authenticated bool) ([]string, error) {
+	return readCommand(bufio.NewReader(strings.NewReader(input)), authenticated)
+}
+
+func TestReadCommand(t *testing.T) {
+	args, err := read("*3\r\n$3\r\nSET\r\n$1\r\na\r\n$4\r\nx\r\ny\r\n", true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"SET", "a", "x\r\ny"}, args)
+
+	args, err = read("GET  a\r\n", true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GET", "a"}, args)
+
+	args, err = read("\r\n", true)
+	require.NoError(t, err)
+	assert.Empty(t, args)
+
+	_, err = read("", true)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestReadCommandLimits(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		authenticated bool
+	}{
+		{name: "huge multibulk length", input: "*9223372036854775807\r\n", authenticated: true},
+		{name: "multibulk length over MaxArgs", input: fmt.Sprintf("*%d\r\n", MaxArgs+1), authenticated: true},
+		{name: "negative multibulk length", input: "*-1\r\n", authenticated: true},
+		{name: "huge bulk length", input: "*1\r\n$9223372036854775807\r\n", authenticated: true},
+		{name: "bulk length over the value size", input: fmt.Sprintf("*1\r\n$%d\r\n", max(storage.MaxKeySize, storage.MaxValueSize)+1), authenticated: true},
+		{name: "multi GB bulk length", input: "*1\r\n$2000000000\r\n", authenticated: true},
+		{name: "unauthenticated multibulk length", input: "*4\r\n", authenticated: false},
+		{name: "unauthenticated bulk length", input: fmt.Sprintf("*2\r\n$4\r\nAUTH\r\n$%d\r\n", MaxUnauthenticatedBulkSize+1), authenticated: false},
+		{name: "unauthenticated inline arguments", input: "AUTH a b c\r\n", authenticated: false},
+		{name: "inline line too long", input: strings.Repeat("a", MaxInlineSize+1) + "\r\n", authenticated: true},
+		{name: "header too long", input: "*1\r\n$" + strings.Repeat("1", MaxInlineSize+1) + "\r\n", authenticated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := read(tt.input, tt.authenticated)
+			assert.ErrorIs(t, err, ErrProtocol)
+		})
+	}
+
+	// AUTH fits the limits of unauthenticated connections
+	args, err := read("*3\r\n$4\r\nAUTH\r\n$4\r\nuser\r\n$6\r\nsecret\r\n", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"AUTH", "user", "secret"}, args)
+
+	// lines longer than the buffer of the reader are read whole
+	line := strings.Repeat("a", 10000)
+	args, err = read("GET "+line+"\r\n", true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GET", line}, args)
+}
@@ -0,0 +1,260 @@
+package resp
+
+import (
+	"bufio"
+	"context"
+	"distrikv/auth"
+	"distrikv/config"
+	"distrikv/pkg"
+	"distrikv/ratelimit"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Start serves the Redis protocol on every configured address until
+// ctx is done. Connections then answer the commands already received
+// and are closed, those still busy after cfg.ShutdownDrainTimeout are
+// closed right away. Connections must AUTH first when authenticator
+// isn't nil. Commands of clients over their rate limits are delayed
+// until allowed when limiter isn't nil.
+func Start(ctx context.Context, logger *slog.Logger, store Store, authenticator *auth.Authenticator, limiter *ratelimit.Limiter, cfg config.Config) error {
+	listeners, err := pkg.Listen(cfg.RedisAddrs)
+	if err != nil {
+		return err
+	}
+
+	handler := NewHandler(store, cfg)
+	conns := &connSet{conns: make(map[net.Conn]struct{})}
+
+	return pkg.ServeUntil(ctx, listeners, cfg.ShutdownDrainTimeout, func(l net.Listener) error {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return err
+			}
+
+			if !conns.add(conn) {
+				conn.Close()
+				continue
+			}
+
+			go func() {
+				defer conns.remove(conn)
+				serveConn(logger, conn, handler, authenticator, limiter)
+			}()
+		}
+	}, func(ctx context.Context) error {
+		for _, l := range listeners {
+			l.Close()
+		}
+
+		return conns.drain(ctx)
+	})
+}
+
+// connSet tracks the open connections for the shutdown.
+type connSet struct {
+	mu      sync.Mutex
+	conns   map[net.Conn]struct{}
+	wg      sync.WaitGroup
+	closing bool
+}
+
+// add tracks conn, it fails once the set is draining.
+func (s *connSet) add(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return false
+	}
+
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *connSet) remove(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, conn)
+	s.wg.Done()
+}
+
+// drain expires the reads of every connection, so they stop before
+// their next command, and waits until they returned or ctx is done.
+func (s *connSet) drain(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+
+	return ctx.Err()
+}
+
+func serveConn(logger *slog.Logger, conn net.Conn, handler *Handler, authenticator *auth.Authenticator, limiter *ratelimit.Limiter) {
+	defer conn.Close()
+
+	// a command crashing its handler only ends its connection
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("panic serving redis connection", "remote", conn.RemoteAddr(), "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+
+	reader := bufio.NewReader(conn)
+	written := &countingWriter{w: conn}
+	w := &writer{w: bufio.NewWriter(written)}
+	authenticated := authenticator == nil
+
+	// the principal of the last successful AUTH
+	var principal *auth.Principal
+
+	for {
+		args, err := readCommand(reader, authenticated)
+		if errors.Is(err, io.EOF) {
+			return
+		}
+
+		// the server is shutting down
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			w.w.Flush()
+			return
+		}
+
+		if err != nil {
+			w.error(err.Error())
+			w.w.Flush()
+			logger.Error("error reading redis command", "remote", conn.RemoteAddr(), "err", err)
+			return
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		var key string
+		var replied int64
+		if limiter != nil {
+			key = ratelimit.Key(principal, conn.RemoteAddr().String())
+			if !waitLimit(w, limiter, key) {
+				return
+			}
+
+			replied = written.n + int64(w.w.Buffered())
+		}
+
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "QUIT":
+			w.simple("OK")
+			w.w.Flush()
+			return
+		case cmd == "AUTH":
+			if p := authenticate(w, authenticator, args); p != nil {
+				principal, authenticated = p, true
+			}
+		case !authenticated:
+			w.errorCode("NOAUTH", "Authentication required.")
+		default:
+			handler.Handle(w, principal, args)
+		}
+
+		if limiter != nil {
+			n := written.n + int64(w.w.Buffered()) - replied
+			for _, arg := range args {
+				n += int64(len(arg))
+			}
+
+			limiter.Charge(key, n)
+		}
+
+		// pipelined commands are answered together
+		if reader.Buffered() == 0 {
+			if err := w.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// authenticate answers AUTH [username] <key or token> and returns
+// the principal of the credential, the username is ignored.
+func authenticate(w *writer, authenticator *auth.Authenticator, args []string) *auth.Principal {
+	if len(args) != 2 && len(args) != 3 {
+		wrongArgs(w, args[0])
+		return nil
+	}
+
+	if authenticator == nil {
+		w.error("AUTH called without any credentials configured")
+		return nil
+	}
+
+	p, err := authenticator.Authenticate(args[len(args)-1])
+	if err != nil {
+		w.errorCode("WRONGPASS", "invalid username-password pair or user is disabled.")
+		return nil
+	}
+
+	w.simple("OK")
+	return p
+}
+
+// waitLimit delays the next command of the client key until its rate
+// limit allows it, answering the commands before it first. It returns
+// false when the connection failed.
+func waitLimit(w *writer, limiter *ratelimit.Limiter, key string) bool {
+	for {
+		wait := limiter.Allow(key)
+		if wait == 0 {
+			return true
+		}
+
+		if err := w.w.Flush(); err != nil {
+			return false
+		}
+
+		time.Sleep(wait)
+	}
+}
+
+// countingWriter counts the bytes written to a connection.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
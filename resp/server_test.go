@@ -0,0 +1,83 @@
+package resp
+
+import (
+	"bufio"
+	"context"
+	"distrikv/config"
+	"distrikv/storage"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartDrainsConnections(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+
+	store, err := storage.Open(context.Background(), logger, t.TempDir(), storage.OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.Config{RedisAddrs: []string{addr}, ShutdownDrainTimeout: time.Second, MaxBatchSize: 10, ScanMaxLimit: 10}
+	started := make(chan error, 1)
+	go func() {
+		started <- Start(ctx, logger, store, nil, nil, cfg)
+	}()
+
+	// the server may not be listening yet
+	var conn net.Conn
+	require.Eventually(t, func() bool {
+		conn, err = net.Dial("tcp", addr)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	_, err = conn.Write([]byte("SET a 1\r\nGET a\r\n"))
+	require.NoError(t, err)
+
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "+OK\r\n", line)
+
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "$1\r\n", line)
+
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "1\r\n", line)
+
+	// once cancelled, the idle connection is closed before its next command
+	cancel()
+	select {
+	case err := <-started:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server didn't drain")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = reader.ReadString('\n')
+	assert.ErrorIs(t, err, io.EOF)
+
+	// and no new connections are accepted
+	_, err = net.Dial("tcp", addr)
+	assert.Error(t, err)
+
+	data, err := store.Get(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", data.Value)
+}
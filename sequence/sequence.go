@@ -0,0 +1,173 @@
+package sequence
+
+import (
+	"distrikv/wal"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"sync"
+)
+
+var (
+	ErrInvalidName      = fmt.Errorf("sequence names must be 1 to %d bytes", MaxNameSize)
+	ErrInvalidCount     = fmt.Errorf("ids allocated at once must be between 1 and %d", MaxCount)
+	ErrTooManySequences = fmt.Errorf("a node keeps at most %d sequences", MaxSequences)
+	ErrExhausted        = errors.New("sequence is exhausted")
+)
+
+const (
+	MaxNameSize  = 256
+	MaxSequences = 10000
+	MaxCount     = 1 << 20
+)
+
+// Dir is the directory of the data dir the sequences are logged to.
+const Dir = "sequences"
+
+// Reserve is the number of ids logged ahead of the allocations, so most
+// allocations don't write the log. The ids reserved but not allocated
+// when the node crashes are skipped.
+var Reserve uint64 = 1000
+
+const (
+	// stateKey is the key of the records of the log,
+	// each one holding the reservations of every sequence.
+	stateKey = "sequences"
+
+	// segmentSize and retention bound the log, retention is larger
+	// than the records of MaxSequences sequences of MaxNameSize bytes,
+	// so the segment before the last one is kept.
+	segmentSize = 1 << 20
+	retention   = 8 << 20
+)
+
+// Sequences allocates increasing ids from named sequences, starting at 1.
+// Allocations are logged to a WAL and synced before they return, so an
+// id is never allocated twice, across restarts and crashes. Ids skipped
+// after a crash leave gaps.
+type Sequences struct {
+	log *wal.Log
+
+	mu sync.Mutex
+
+	// next is the next id of every sequence,
+	// reserved the first id not logged yet.
+	next     map[string]uint64
+	reserved map[string]uint64
+}
+
+// Open opens the sequences logged to dir, creating it if needed.
+// Sequences continue after the last reserved id.
+func Open(logger *slog.Logger, dir string) (*Sequences, error) {
+	log, err := wal.OpenLog(dir, segmentSize, retention)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Sequences{
+		log:      log,
+		next:     make(map[string]uint64),
+		reserved: make(map[string]uint64),
+	}
+
+	if log.LastSeq() > 0 {
+		var last *wal.WALEntry
+		err := log.Read(log.FirstSeq()-1, func(entry *wal.WALEntry) bool {
+			last = entry
+			return true
+		})
+		if err != nil {
+			log.Close()
+			return nil, fmt.Errorf("read %s: %w", dir, err)
+		}
+
+		if err := json.Unmarshal([]byte(last.Value), &s.reserved); err != nil {
+			log.Close()
+			return nil, fmt.Errorf("record %d of %s: %w", last.Seq, dir, err)
+		}
+	}
+
+	maps.Copy(s.next, s.reserved)
+	logger.Info("opened sequences", "dir", dir, "sequences", len(s.next))
+
+	return s, nil
+}
+
+// Next allocates count consecutive ids of the sequence name
+// and returns the first one, a new sequence starts at 1.
+func (s *Sequences) Next(name string, count int) (uint64, error) {
+	if len(name) == 0 || len(name) > MaxNameSize {
+		return 0, ErrInvalidName
+	}
+
+	if count < 1 || count > MaxCount {
+		return 0, ErrInvalidCount
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	first, ok := s.next[name]
+	if !ok {
+		if len(s.next) >= MaxSequences {
+			return 0, ErrTooManySequences
+		}
+
+		first = 1
+	}
+
+	if first > math.MaxUint64-uint64(count) {
+		return 0, ErrExhausted
+	}
+
+	end := first + uint64(count)
+	if end > s.reserved[name] {
+		reserved := maps.Clone(s.reserved)
+		reserved[name] = end + min(Reserve, math.MaxUint64-end)
+		if err := s.write(reserved); err != nil {
+			return 0, err
+		}
+
+		s.reserved = reserved
+	}
+
+	s.next[name] = end
+	return first, nil
+}
+
+// write logs reserved and syncs it.
+func (s *Sequences) write(reserved map[string]uint64) error {
+	data, err := json.Marshal(reserved)
+	if err != nil {
+		return err
+	}
+
+	err = s.log.Append(&wal.WALEntry{
+		Seq:   s.log.LastSeq() + 1,
+		Type:  wal.RecordPut,
+		Key:   stateKey,
+		Value: string(data),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.log.Sync()
+}
+
+// Close logs the next id of every sequence, so the ids reserved
+// but not allocated are allocated after a restart, and closes the log.
+func (s *Sequences) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if !maps.Equal(s.next, s.reserved) {
+		err = s.write(s.next)
+	}
+
+	return errors.Join(err, s.log.Close())
+}
@@ -0,0 +1,58 @@
+package sequence
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequences(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	s, err := Open(logger, dir)
+	require.NoError(t, err)
+
+	next := func(s *Sequences, name string, count int) uint64 {
+		t.Helper()
+
+		first, err := s.Next(name, count)
+		require.NoError(t, err)
+		return first
+	}
+
+	assert.Equal(t, uint64(1), next(s, "orders", 1))
+	assert.Equal(t, uint64(2), next(s, "orders", 100))
+	assert.Equal(t, uint64(102), next(s, "orders", 1))
+	assert.Equal(t, uint64(1), next(s, "users", 1))
+
+	// a clean restart continues right after the allocated ids
+	require.NoError(t, s.Close())
+	s, err = Open(logger, dir)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(103), next(s, "orders", 1))
+	assert.Equal(t, uint64(2), next(s, "users", 1))
+
+	// after a crash the reserved ids are skipped, none is allocated twice
+	crashed := s
+	s, err = Open(logger, dir)
+	require.NoError(t, err)
+	defer s.Close()
+	defer crashed.log.Close()
+
+	assert.Equal(t, uint64(104+Reserve), next(s, "orders", 1))
+	assert.Equal(t, uint64(3+Reserve), next(s, "users", int(Reserve)))
+
+	_, err = s.Next("", 1)
+	assert.ErrorIs(t, err, ErrInvalidName)
+	_, err = s.Next(strings.Repeat("a", MaxNameSize+1), 1)
+	assert.ErrorIs(t, err, ErrInvalidName)
+	_, err = s.Next("orders", 0)
+	assert.ErrorIs(t, err, ErrInvalidCount)
+	_, err = s.Next("orders", MaxCount+1)
+	assert.ErrorIs(t, err, ErrInvalidCount)
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"distrikv/api"
+	"distrikv/auth"
+	"distrikv/backup"
+	"distrikv/cdc"
+	"distrikv/cluster"
+	"distrikv/config"
+	"distrikv/events"
+	"distrikv/grpc"
+	"distrikv/handoff"
+	"distrikv/membership"
+	"distrikv/pkg"
+	"distrikv/ratelimit"
+	"distrikv/replication"
+	"distrikv/resp"
+	"distrikv/sequence"
+	"distrikv/storage"
+	"distrikv/tracing"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Exit codes of the server. A degraded shutdown left
+// data unflushed, orchestrators can hold back its restart.
+const (
+	exitClean    = 0
+	exitFailed   = 1
+	exitDegraded = 3
+)
+
+// shutdownTimeout bounds flushing the memtables on shutdown.
+const shutdownTimeout = 30 * time.Second
+
+// errShutdownDegraded is returned by a shutdown leaving data unflushed.
+var errShutdownDegraded error = errors.New("shutdown degraded")
+
+// runServer runs a node until SIGINT or SIGTERM. Flags
+// override the configuration read from the environment.
+func runServer(args []string) error {
+	cfg := config.Load()
+
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	cfg.RegisterFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q", flags.Args())
+	}
+
+	logger, err := pkg.NewLogger(os.Stdout, cfg.LogFormat, cfg.LogLevel, cfg.LogLevels)
+	if err != nil {
+		return err
+	}
+
+	openMode, err := storage.ParseOpenMode(cfg.OpenMode)
+	if err != nil {
+		return err
+	}
+
+	storage.StartupCheck, err = storage.ParseCheckPolicy(cfg.StartupCheck)
+	if err != nil {
+		return err
+	}
+
+	storage.SSTSync, err = storage.ParseSyncPolicy(cfg.SSTSync)
+	if err != nil {
+		return err
+	}
+
+	storage.PurgeSchedule, err = storage.ParseSchedule(cfg.PurgeSchedule)
+	if err != nil {
+		return err
+	}
+
+	storage.CompactionLevels, err = storage.ParseLevelConfig(cfg.CompactionLevels)
+	if err != nil {
+		return err
+	}
+
+	if err := storage.SetSizeLimits(cfg.MaxKeySize, cfg.MaxValueSize); err != nil {
+		return err
+	}
+
+	storage.RowCacheSize = int64(cfg.RowCacheSize)
+	storage.NegativeCacheSize = int64(cfg.NegativeCacheSize)
+	storage.MinFreeSpace = int64(cfg.MinFreeSpace)
+	storage.GarbageRatio = cfg.GarbageRatio
+	storage.PurgeTombstoneAge = cfg.PurgeTombstoneAge
+	storage.VersionsRetained = cfg.VersionsRetained
+	storage.VersionRetention = cfg.VersionRetention
+
+	bus := events.NewBus()
+
+	var tracer *tracing.Tracer
+	if cfg.TracingEndpoint != "" {
+		tracer = tracing.New(logger.With(pkg.ComponentKey, "tracing"), tracing.Options{
+			Endpoint:    cfg.TracingEndpoint,
+			ServiceName: cfg.TracingServiceName,
+			Attributes:  []tracing.Attr{{Key: "service.instance.id", Value: cfg.NodeID}},
+			SampleRatio: cfg.TracingSampleRatio,
+		})
+		tracing.SetTracer(tracer)
+	}
+
+	// the client APIs run until the first signal, startup is canceled by it
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// the background loops run until the APIs are drained
+	background, cancelBackground := context.WithCancel(context.Background())
+	defer cancelBackground()
+
+	tlsConfig, err := pkg.ServerTLS(cfg.TLS())
+	if err != nil {
+		return err
+	}
+	httpTLS, grpcTLS := tlsConfig, tlsConfig
+
+	// with mTLS the node presents its certificate to its peers,
+	// and the client APIs accept it
+	if cfg.ClusterTLSCAFile != "" {
+		peers, err := pkg.NewPeerTLS(logger.With(pkg.ComponentKey, "tls"), cfg.ClusterTLSCAFile, cfg.ClusterTLSCertFile, cfg.ClusterTLSKeyFile)
+		if err != nil {
+			return err
+		}
+
+		pkg.SetPeerTLS(peers)
+
+		httpProtos := []string{"http/1.1"}
+		if cfg.HTTP2 {
+			httpProtos = []string{"h2", "http/1.1"}
+		}
+
+		httpTLS = peers.AcceptPeers(tlsConfig, httpProtos)
+		grpcTLS = peers.AcceptPeers(tlsConfig, []string{"h2"})
+	}
+
+	// a new standby starts from a snapshot of the primary
+	snapshotSeq, bootstrapped, err := replication.Bootstrap(signals, logger.With(pkg.ComponentKey, "replication"), cfg)
+	if err != nil {
+		return err
+	}
+
+	// after the bootstrap, which needs an empty data dir
+	authenticator, err := newAuthenticator(logger.With(pkg.ComponentKey, "auth"), cfg)
+	if err != nil {
+		return err
+	}
+
+	var limiter *ratelimit.Limiter
+	if cfg.RateLimits().Enabled() {
+		limiter = ratelimit.New(cfg.RateLimits())
+	}
+
+	cmp, err := storage.ParseComparator(cfg.Comparator)
+	if err != nil {
+		return err
+	}
+
+	retention, err := storage.ParseRetentionRules(cfg.RetentionRules)
+	if err != nil {
+		return err
+	}
+
+	// a joining node learns the cluster first,
+	// so it pulls the shards it takes over from their owners.
+	var members *membership.Membership
+	var nodes []cluster.Node
+	if cfg.AdvertiseURL != "" {
+		members = membership.New(logger.With(pkg.ComponentKey, "membership"), cfg.NodeID, cfg.AdvertiseURL, cfg.GossipSeeds, bus)
+		nodes = toNodes(members.Join(signals))
+	}
+
+	c, err := cluster.New(logger.With(pkg.ComponentKey, "cluster"), cfg, nodes, func(dir string) (*storage.Store, error) {
+		store, err := storage.Open(background, logger.With(pkg.ComponentKey, "storage", "dir", dir), dir, openMode, bus, cmp, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if cfg.CacheMaxSize > 0 {
+			if err := store.EnableEviction(int64(cfg.CacheMaxSize)); err != nil {
+				store.Close(background)
+				return nil, err
+			}
+
+			go store.StartEviction(background)
+		}
+
+		go store.StartRetention(background, retention)
+
+		return store, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if members != nil {
+		members.OnChange(func(ms []membership.Member) {
+			if err := c.SetNodes(toNodes(ms)); err != nil {
+				logger.Error("error updating cluster nodes", "err", err)
+			}
+		})
+
+		go members.Start(background)
+	}
+
+	hints, err := handoff.New(logger.With(pkg.ComponentKey, "handoff"), filepath.Join(cfg.DataDir, "hints"))
+	if err != nil {
+		return err
+	}
+
+	go hints.Start(background)
+
+	replicator, err := replication.New(logger.With(pkg.ComponentKey, "replication"), c, cfg, bus)
+	if err != nil {
+		return err
+	}
+
+	if bootstrapped {
+		replicator.Reset(snapshotSeq)
+	}
+
+	replicator.Start(background)
+
+	if len(cfg.KafkaBrokers) > 0 {
+		producer := cdc.NewKafkaProducer(cdc.KafkaOptions{
+			Brokers:  cfg.KafkaBrokers,
+			Topic:    cfg.KafkaTopic,
+			ClientID: cfg.NodeID,
+			Timeout:  cfg.KafkaTimeout,
+		})
+		defer producer.Close()
+
+		sink, err := cdc.NewSink(logger.With(pkg.ComponentKey, "cdc"), replicator, producer, bus, cfg.KafkaFormat, cfg.DataDir)
+		if err != nil {
+			return err
+		}
+
+		go sink.Start(background)
+	}
+
+	backups, err := backup.New(logger.With(pkg.ComponentKey, "backup"), cfg.DataDir, replicator, c.Operations())
+	if err != nil {
+		return err
+	}
+
+	sequences, err := sequence.Open(logger.With(pkg.ComponentKey, "sequence"), filepath.Join(cfg.DataDir, sequence.Dir))
+	if err != nil {
+		return err
+	}
+
+	var servers sync.WaitGroup
+	servers.Add(2)
+	go func() {
+		defer servers.Done()
+		if err := grpc.Start(signals, replicator, bus, replicator, grpcTLS, authenticator, limiter, cfg); err != nil {
+			logger.Error("grpc server stopped", "err", err)
+		}
+	}()
+
+	go func() {
+		defer servers.Done()
+		if err := resp.Start(signals, logger.With(pkg.ComponentKey, "resp"), replicator, authenticator, limiter, cfg); err != nil {
+			logger.Error("redis server stopped", "err", err)
+		}
+	}()
+
+	deps := api.Deps{
+		Store:       replicator,
+		Cluster:     c,
+		Replication: replicator,
+		Hints:       hints,
+		Backups:     backups,
+		Sequences:   sequences,
+		Events:      bus,
+		TLS:         httpTLS,
+		Auth:        authenticator,
+		Limiter:     limiter,
+	}
+
+	// a nil *Membership must not become a non-nil interface
+	if members != nil {
+		deps.Membership = members
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- api.Start(signals, deps, cfg)
+	}()
+
+	select {
+	case err := <-errs:
+		logger.Error("http server stopped", "err", err)
+		os.Exit(exitFailed)
+	case <-signals.Done():
+	}
+
+	// a second signal exits right away
+	stop()
+
+	logger.Info("shutting down, draining requests", "timeout", cfg.ShutdownDrainTimeout)
+
+	// the servers stop accepting connections and wait for the requests
+	// in flight, aborting those still running after the drain timeout
+	if err := <-errs; err != nil {
+		logger.Warn("error draining http requests", "err", err)
+	}
+	servers.Wait()
+
+	cancelBackground()
+
+	if err := replicator.Close(); err != nil {
+		logger.Warn("error closing the changelog", "err", err)
+	}
+
+	if err := sequences.Close(); err != nil {
+		logger.Warn("error closing the sequences", "err", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+
+	// the changes flushed by a clean shutdown aren't replayed on start
+	var report cluster.ShutdownReport
+	err = replicator.Flush(func() error {
+		report = c.Shutdown(ctx)
+		if report.Degraded() {
+			return errShutdownDegraded
+		}
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, errShutdownDegraded) {
+		logger.Warn("error recording the flushed changes", "err", err)
+	}
+
+	if err := tracer.Shutdown(ctx); err != nil {
+		logger.Warn("error exporting the last spans", "err", err)
+	}
+	cancel()
+
+	if report.Degraded() {
+		logger.Error("shutdown degraded", "report", report)
+		os.Exit(exitDegraded)
+	}
+
+	logger.Info("shutdown complete", "report", report)
+	return nil
+}
+
+func toNodes(members []membership.Member) []cluster.Node {
+	var nodes []cluster.Node
+	for _, m := range members {
+		nodes = append(nodes, cluster.Node{ID: m.ID, Addr: m.Addr})
+	}
+
+	return nodes
+}
+
+// newAuthenticator returns the authenticator of the client APIs, nil
+// without authentication. A node without keys file gets a first admin
+// key, written to $DATA_DIR/admin-key.
+func newAuthenticator(logger *slog.Logger, cfg config.Config) (*auth.Authenticator, error) {
+	if !cfg.Auth {
+		return nil, nil
+	}
+
+	// nodes have no credentials, they authenticate each other with mTLS
+	clustered := len(cfg.ClusterNodes) > 0 || cfg.AdvertiseURL != "" || cfg.Role == "standby"
+	if clustered && cfg.ClusterTLSCAFile == "" {
+		return nil, errors.New("AUTH requires CLUSTER_TLS_CA_FILE on the nodes of a cluster")
+	}
+
+	var secret []byte
+	if cfg.AuthTokenSecretFile != "" {
+		var err error
+		if secret, err = auth.ReadSecret(cfg.AuthTokenSecretFile); err != nil {
+			return nil, err
+		}
+	}
+
+	keyFile := filepath.Join(cfg.DataDir, "admin-key")
+	created, err := auth.Bootstrap(cfg.KeysFile(), keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if created {
+		logger.Warn("created the first api key, named admin, move it out of the data directory", "file", keyFile)
+	}
+
+	return auth.New(logger, cfg.KeysFile(), secret)
+}
@@ -0,0 +1,41 @@
+package storage
+
+import "fmt"
+
+// Append appends suffix to the value of key and returns the stored
+// entry, a missing key is created with suffix. The expiry of the key
+// is kept, values growing past MaxValueSize fail with ErrValueTooLarge.
+func (s *Store) Append(key string, suffix string) (*KVData, error) {
+	if s.closed.Load() {
+		return nil, ErrReadOnly
+	}
+
+	if err := checkSize(key, suffix); err != nil {
+		return nil, err
+	}
+
+	mu := s.locks.lock(key, s.Backend.sstManager.cmp)
+	mu.Lock()
+	defer mu.Unlock()
+
+	current, err := s.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if current == nil {
+		current = &KVData{Key: key}
+	}
+
+	if len(current.Value)+len(suffix) > MaxValueSize {
+		return nil, fmt.Errorf("%w: %d bytes appended to %d", ErrValueTooLarge, len(suffix), len(current.Value))
+	}
+
+	current.Value += suffix
+	if err := s.Backend.setWithExpiry(key, current.Value, current.ExpiresAt); err != nil {
+		return nil, err
+	}
+
+	s.access.put(key, cachedSize(key, current.Value))
+	return current, nil
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppend(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	store, err := Open(context.Background(), logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.Append("log", "ab")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	res, err := store.Get(context.Background(), "log")
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("ab", 50), res.Value)
+
+	// the expiry is kept
+	require.NoError(t, store.SetWithTTL("expiring", "a", time.Hour))
+	res, err = store.Append("expiring", "b")
+	require.NoError(t, err)
+	assert.Equal(t, "ab", res.Value)
+	assert.False(t, res.ExpiresAt.IsZero())
+
+	require.NoError(t, store.Set("large", strings.Repeat("a", MaxValueSize)))
+	_, err = store.Append("large", "b")
+	assert.ErrorIs(t, err, ErrValueTooLarge)
+}
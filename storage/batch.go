@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"context"
+	"slices"
+)
+
+type BatchOpType int
+
+const (
+	BATCH_PUT BatchOpType = iota
+
+	BATCH_DELETE
+
+	// BATCH_DELETE_RANGE deletes the keys in [Key, End),
+	// an empty End deletes to the last key.
+	BATCH_DELETE_RANGE
+)
+
+// BatchOp is a write of a WriteBatch.
+type BatchOp struct {
+	Type  BatchOpType
+	Key   string
+	End   string
+	Value string
+}
+
+// BatchWriter applies write batches, all ops of a batch or none of them.
+type BatchWriter interface {
+	Write(ops []BatchOp) error
+}
+
+// WriteBatch accumulates writes applied together by Commit, in order.
+type WriteBatch struct {
+	w   BatchWriter
+	ops []BatchOp
+}
+
+// NewWriteBatch creates an empty batch committed to w.
+func NewWriteBatch(w BatchWriter) *WriteBatch {
+	return &WriteBatch{w: w}
+}
+
+func (b *WriteBatch) Put(key string, value string) {
+	b.ops = append(b.ops, BatchOp{Type: BATCH_PUT, Key: key, Value: value})
+}
+
+func (b *WriteBatch) Delete(key string) {
+	b.ops = append(b.ops, BatchOp{Type: BATCH_DELETE, Key: key})
+}
+
+// DeleteRange deletes the keys in [start, end) that exist when
+// the batch is committed, including those put before in the batch.
+func (b *WriteBatch) DeleteRange(start string, end string) {
+	b.ops = append(b.ops, BatchOp{Type: BATCH_DELETE_RANGE, Key: start, End: end})
+}
+
+func (b *WriteBatch) Len() int {
+	return len(b.ops)
+}
+
+// Commit applies the writes of the batch and resets it.
+func (b *WriteBatch) Commit() error {
+	if len(b.ops) == 0 {
+		return nil
+	}
+
+	if err := b.w.Write(b.ops); err != nil {
+		return err
+	}
+
+	b.ops = nil
+	return nil
+}
+
+// Write applies ops together, no other write of a key of
+// the batch runs between them and they land in the same memtable.
+func (s *Store) Write(ops []BatchOp) error {
+	if err := s.CheckWrite(ops); err != nil {
+		return err
+	}
+
+	unlock := s.lockBatch(ops)
+	defer unlock()
+
+	ops, err := s.expandRanges(ops)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Backend.write(ops); err != nil {
+		return err
+	}
+
+	for _, op := range ops {
+		switch op.Type {
+		case BATCH_PUT:
+			s.access.put(op.Key, cachedSize(op.Key, op.Value))
+		case BATCH_DELETE:
+			s.access.remove(op.Key)
+		}
+	}
+
+	return nil
+}
+
+// CheckWrite returns the error Write refuses ops with before applying
+// any of them, nil if the store accepts them, so a batch spanning several
+// stores can be checked on all of them first.
+func (s *Store) CheckWrite(ops []BatchOp) error {
+	if s.closed.Load() {
+		return ErrReadOnly
+	}
+
+	for _, op := range ops {
+		if op.Type != BATCH_PUT {
+			continue
+		}
+
+		if err := checkSize(op.Key, op.Value); err != nil {
+			return err
+		}
+	}
+
+	return s.Backend.checkWrite(ops)
+}
+
+// lockBatch takes the key locks of ops in stripe order. Ranges
+// can hold any key, batches with a range take every lock.
+func (s *Store) lockBatch(ops []BatchOp) func() {
+	cmp := s.Backend.sstManager.cmp
+
+	var locked []int
+	if slices.ContainsFunc(ops, func(op BatchOp) bool { return op.Type == BATCH_DELETE_RANGE }) {
+		for i := range s.locks {
+			locked = append(locked, i)
+		}
+	} else {
+		for _, op := range ops {
+			locked = append(locked, s.locks.stripe(op.Key, cmp))
+		}
+
+		slices.Sort(locked)
+		locked = slices.Compact(locked)
+	}
+
+	for _, i := range locked {
+		s.locks[i].Lock()
+	}
+
+	return func() {
+		for _, i := range locked {
+			s.locks[i].Unlock()
+		}
+	}
+}
+
+// expandRanges replaces the ranges of ops with deletes of the stored
+// keys in the range and the keys put before in the batch.
+func (s *Store) expandRanges(ops []BatchOp) ([]BatchOp, error) {
+	cmp := s.Backend.sstManager.cmp
+	inRange := func(key string, op BatchOp) bool {
+		return cmp.Compare(key, op.Key) >= 0 && (op.End == "" || cmp.Compare(key, op.End) < 0)
+	}
+
+	var res []BatchOp
+	for i, op := range ops {
+		if op.Type != BATCH_DELETE_RANGE {
+			res = append(res, op)
+			continue
+		}
+
+		err := s.Backend.Scan(context.Background(), op.Key, op.End, func(data *KVData) bool {
+			res = append(res, BatchOp{Type: BATCH_DELETE, Key: data.Key})
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, put := range ops[:i] {
+			if put.Type == BATCH_PUT && inRange(put.Key, op) {
+				res = append(res, BatchOp{Type: BATCH_DELETE, Key: put.Key})
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// write applies ops to the active memtable under a single
+// acquisition of mu, so a flush can't split the batch.
+// Batches of deletes only are accepted on a full disk, like Delete.
+// The changelog above the store logs a batch as a single record.
+func (l *LSM) write(ops []BatchOp) error {
+	if err := l.checkWrite(ops); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.number(l.Memtable)
+	for _, op := range ops {
+		switch op.Type {
+		case BATCH_PUT:
+			l.Memtable.Set(op.Key, op.Value, false)
+		case BATCH_DELETE:
+			l.Memtable.Set(op.Key, "", false)
+		}
+	}
+	l.mu.Unlock()
+
+	for _, op := range ops {
+		l.invalidate(op.Key)
+	}
+
+	l.checkFlush()
+
+	return nil
+}
+
+// checkWrite returns the error of the disk health refusing ops.
+func (l *LSM) checkWrite(ops []BatchOp) error {
+	if l.sstManager.health.isReadOnly() {
+		return ErrReadOnly
+	}
+
+	for _, op := range ops {
+		if op.Type == BATCH_PUT {
+			return l.sstManager.health.writable()
+		}
+	}
+
+	return nil
+}
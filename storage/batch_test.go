@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteBatch(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	store, err := Open(context.Background(), logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	assert.NoError(t, err)
+	defer store.Close(context.Background())
+
+	assert.NoError(t, store.Set("a", "1"))
+	assert.NoError(t, store.Set("b", "1"))
+	assert.NoError(t, store.Set("d", "1"))
+
+	batch := NewWriteBatch(store)
+	batch.Put("c", "2")
+	batch.Put("e", "2")
+	batch.DeleteRange("b", "e")
+	batch.Put("d", "3")
+	batch.Delete("a")
+	assert.Equal(t, 5, batch.Len())
+
+	assert.NoError(t, batch.Commit())
+	assert.Equal(t, 0, batch.Len())
+
+	var keys []string
+	err = store.Scan(context.Background(), "", "", func(data *KVData) bool {
+		keys = append(keys, data.Key+"="+data.Value)
+		return true
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d=3", "e=2"}, keys)
+
+	store.Close(context.Background())
+	assert.NoError(t, NewWriteBatch(store).Commit())
+
+	batch = NewWriteBatch(store)
+	batch.Put("f", "1")
+	assert.ErrorIs(t, batch.Commit(), ErrReadOnly)
+	assert.Equal(t, 1, batch.Len())
+}
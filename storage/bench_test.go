@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const (
+	benchSSTs       = 4
+	benchKeysPerSST = 1000
+)
+
+// benchSSTManager flushes benchSSTs overlapping SSTs
+// holding values too large to be inlined in the index.
+func benchSSTManager(b *testing.B) *SSTManager {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	sstManager, err := NewSSTManager(logger, b.TempDir(), OPEN_FAST, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	value := strings.Repeat("v", 4*InlineValueSize)
+	for range benchSSTs {
+		memtable := NewMemtable(BytewiseComparator)
+		for i := range benchKeysPerSST {
+			memtable.Set(benchKey(i), value, false)
+		}
+
+		if err := sstManager.FlushSST(memtable); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	return sstManager
+}
+
+func benchKey(i int) string {
+	return fmt.Sprintf("key-%06d", i)
+}
+
+// reportGC reports the GC pause time and cycles per op of
+// the benchmark, call it before the timed loop and defer the result.
+func reportGC(b *testing.B) func() {
+	var before runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	return func() {
+		b.StopTimer()
+
+		var after runtime.MemStats
+		runtime.ReadMemStats(&after)
+
+		b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(b.N), "gc-pause-ns/op")
+		b.ReportMetric(float64(after.NumGC-before.NumGC), "gc-cycles")
+	}
+}
+
+func BenchmarkQueryKey(b *testing.B) {
+	sstManager := benchSSTManager(b)
+
+	defer reportGC(b)()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := sstManager.QueryKey(context.Background(), benchKey(i%benchKeysPerSST)); err != nil {
+				b.Error(err)
+				return
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkScan(b *testing.B) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	lsm := NewLSM(logger, benchSSTManager(b))
+
+	defer reportGC(b)()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			err := lsm.Scan(context.Background(), "", "", func(*KVData) bool { return true })
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkCompact(b *testing.B) {
+	sstManager := benchSSTManager(b)
+	compactor := NewCompactor(slog.New(slog.NewTextHandler(io.Discard, nil)), 0, sstManager)
+	ssts := sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0)
+
+	defer reportGC(b)()
+
+	b.SetParallelism(2)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			out, err := compactor.compact(context.Background(), ssts, 1, false)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+
+			sstManager.discard(1, out)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// BulkChunkSize is the size in bytes of the entries a BulkWriter
+// sorts in memory before writing them to an SST.
+var BulkChunkSize = 64 << 20
+
+// BulkWriter loads entries into the store in dir, which must not be open,
+// by writing them directly to SSTs without going through the memtable.
+// Entries are sorted in chunks of BulkChunkSize, every chunk becomes the
+// newest SST of level 0, so a key put twice keeps the last value and
+// imported keys shadow the ones already in the store. Compactions merge
+// the SSTs once the store is opened.
+type BulkWriter struct {
+	dir string
+	cmp Comparator
+
+	// nextID is the id of the next SST of level 0.
+	nextID uint64
+
+	entries []*SSTEntry
+	size    int
+
+	// Files are the SSTs written so far.
+	Files   []string
+	Entries int
+}
+
+// NewBulkWriter returns a BulkWriter into dir, keys are ordered by cmp,
+// nil is the bytewise comparator. The SSTs of dir must use the same
+// comparator.
+func NewBulkWriter(dir string, cmp Comparator) (*BulkWriter, error) {
+	if cmp == nil {
+		cmp = BytewiseComparator
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+SSTFileFormat))
+	if err != nil {
+		return nil, err
+	}
+
+	var maxID uint64
+	for _, file := range files {
+		sst, err := parseSSTMetadata(file)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", file, err)
+		}
+
+		if sst.Comparator != cmp.Name() {
+			return nil, fmt.Errorf("%s is ordered by the %s comparator, not %s", file, sst.Comparator, cmp.Name())
+		}
+
+		if sst.Level == 0 {
+			maxID = max(maxID, sst.ID)
+		}
+	}
+
+	return &BulkWriter{
+		dir:    dir,
+		cmp:    cmp,
+		nextID: maxID + 1,
+	}, nil
+}
+
+// Put adds key, expiresAt is zero for keys that don't expire.
+// The chunk is written once it reaches BulkChunkSize.
+func (w *BulkWriter) Put(key string, value string, expiresAt time.Time) error {
+	if err := checkSize(key, value); err != nil {
+		return fmt.Errorf("%q: %w", key, err)
+	}
+
+	w.entries = append(w.entries, &SSTEntry{Key: key, Value: value, ExpiresAt: expiresAt})
+	w.size += entrySize(key, value, expiresAt)
+	if w.size < BulkChunkSize {
+		return nil
+	}
+
+	return w.flush()
+}
+
+// Close writes the last chunk.
+func (w *BulkWriter) Close() error {
+	return w.flush()
+}
+
+// flush writes the entries of the chunk in key order, keeping
+// the last entry of a key put twice, to a new SST of level 0.
+func (w *BulkWriter) flush() error {
+	if len(w.entries) == 0 {
+		return nil
+	}
+
+	slices.SortStableFunc(w.entries, func(a, b *SSTEntry) int {
+		return w.cmp.Compare(a.Key, b.Key)
+	})
+
+	entries := w.entries[:0]
+	for _, entry := range w.entries {
+		if n := len(entries); n > 0 && w.cmp.Compare(entries[n-1].Key, entry.Key) == 0 {
+			entries[n-1] = entry
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	name := sstFileName(0, w.nextID)
+	if err := writeSSTFile(filepath.Join(w.dir, name), entries, w.nextID, 0, time.Now(), w.cmp); err != nil {
+		return err
+	}
+
+	w.nextID++
+	w.Files = append(w.Files, name)
+	w.Entries += len(entries)
+	w.entries, w.size = nil, 0
+
+	return nil
+}
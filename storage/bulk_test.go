@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkWriter(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// a key already in the store is shadowed by the import
+	sstManager, err := NewSSTManager(logger, dir, OPEN_FAST, nil)
+	require.NoError(t, err)
+	memtable := NewMemtable(BytewiseComparator)
+	memtable.Set("a", "old", false)
+	memtable.Set("z", "kept", false)
+	require.NoError(t, sstManager.FlushSST(memtable))
+
+	defer func(size int) { BulkChunkSize = size }(BulkChunkSize)
+	BulkChunkSize = 2 * entrySize("a", "v1", time.Time{})
+
+	w, err := NewBulkWriter(dir, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, w.Put("c", "v1", time.Time{}))
+	require.NoError(t, w.Put("a", "v1", time.Time{}))
+	require.NoError(t, w.Put("b", "v1", time.Time{}))
+	require.NoError(t, w.Put("b", "v2", time.Time{}))
+	require.NoError(t, w.Close())
+
+	assert.Len(t, w.Files, 2)
+	assert.Equal(t, 3, w.Entries)
+
+	sstManager, err = NewSSTManager(logger, dir, OPEN_VERIFIED, nil)
+	require.NoError(t, err)
+	store := NewStore(logger, sstManager)
+
+	var keys []string
+	require.NoError(t, store.Scan(context.Background(), "", "", func(data *KVData) bool {
+		keys = append(keys, data.Key+"="+data.Value)
+		return true
+	}))
+	assert.Equal(t, []string{"a=v1", "b=v2", "c=v1", "z=kept"}, keys)
+
+	_, err = NewBulkWriter(dir, NumericComparator)
+	assert.ErrorContains(t, err, "comparator")
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+var ErrInconsistent error = errors.New("store files are inconsistent")
+
+type CheckPolicy int
+
+// Startup check policies, what Open does with the problems
+// CheckDir finds in the files of a store.
+//
+// CHECK_WARN logs them and opens the store, which skips the SSTs it
+// can't read. CHECK_FAIL fails the open with ErrInconsistent.
+// CHECK_REPAIR repairs the store with RepairDir first, logging the
+// changes and the problems left.
+const (
+	CHECK_WARN CheckPolicy = iota
+
+	CHECK_FAIL
+
+	CHECK_REPAIR
+)
+
+// StartupCheck is the startup check policy of Open.
+var StartupCheck = CHECK_WARN
+
+func (p CheckPolicy) String() string {
+	switch p {
+	case CHECK_FAIL:
+		return "fail"
+	case CHECK_REPAIR:
+		return "repair"
+	default:
+		return "warn"
+	}
+}
+
+// ParseCheckPolicy parses the configured startup check
+// policy, an empty string defaults to CHECK_WARN.
+func ParseCheckPolicy(policy string) (CheckPolicy, error) {
+	switch policy {
+	case "", "warn":
+		return CHECK_WARN, nil
+	case "fail":
+		return CHECK_FAIL, nil
+	case "repair":
+		return CHECK_REPAIR, nil
+	default:
+		return CHECK_WARN, fmt.Errorf("unknown startup check %q", policy)
+	}
+}
+
+// checkDir checks the files of the store in dir before it is
+// opened and handles the problems found as StartupCheck asks.
+func checkDir(logger *slog.Logger, dir string) error {
+	report, err := CheckDir(dir)
+	if err != nil {
+		return fmt.Errorf("check store files: %w", err)
+	}
+
+	if len(report.Problems) == 0 {
+		return nil
+	}
+
+	switch StartupCheck {
+	case CHECK_FAIL:
+		p := report.Problems[0]
+		return fmt.Errorf("%w: %d problems in %s, %s is %s, run distrikv repair", ErrInconsistent, len(report.Problems), dir, p.File, p.Kind)
+	case CHECK_REPAIR:
+		repair, err := RepairDir(dir)
+		if repair != nil {
+			for _, action := range repair.Actions {
+				logger.Warn("repaired store file", "dir", dir, "file", action.File, "action", action.Action, "detail", action.Detail)
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("repair store files: %w", err)
+		}
+
+		report.Problems = repair.Unresolved
+	}
+
+	for _, p := range report.Problems {
+		logger.Warn("inconsistent store file", "dir", dir, "file", p.File, "kind", p.Kind, "detail", p.Detail)
+	}
+
+	return nil
+}
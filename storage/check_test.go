@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartupCheck(t *testing.T) {
+	defer func() { StartupCheck = CHECK_WARN }()
+
+	dir := t.TempDir()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var buf bytes.Buffer
+	w := newSSTWriter(&buf)
+	require.NoError(t, encodeSSTEntry(w, "a", "1", false, time.Time{}))
+	require.NoError(t, writeSSTMetadata(w, 9, 0, time.Now(), BytewiseComparator))
+
+	// misnamed, incomplete and left over by an ingest
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0_1_a.sst"), buf.Bytes(), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0_2_b.sst"), buf.Bytes()[:10], 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0_3_c.sst.tmp"), buf.Bytes(), 0644))
+
+	report, err := CheckDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, report.Problems, 3)
+	assert.Zero(t, report.Entries)
+
+	StartupCheck = CHECK_FAIL
+	_, err = Open(context.Background(), logger, dir, OPEN_FAST, nil, nil, nil)
+	assert.ErrorIs(t, err, ErrInconsistent)
+
+	StartupCheck = CHECK_REPAIR
+	store, err := Open(context.Background(), logger, dir, OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+
+	data, err := store.Get(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", data.Value)
+
+	_, err = store.Close(context.Background())
+	require.NoError(t, err)
+
+	report, err = CheckDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, report.Problems)
+}
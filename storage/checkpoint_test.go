@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpoint(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	store, err := Open(context.Background(), logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	require.NoError(t, store.Set("a", "1"))
+	require.NoError(t, store.Set("b", "2"))
+	_, err = store.Flush(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, store.Set("c", "3"))
+
+	dir := filepath.Join(t.TempDir(), "checkpoint")
+	checkpoint, err := store.Checkpoint(context.Background(), dir)
+	require.NoError(t, err)
+	assert.NotEmpty(t, checkpoint.Files)
+
+	_, err = store.Checkpoint(context.Background(), dir)
+	assert.ErrorIs(t, err, os.ErrExist)
+
+	// later writes aren't part of the checkpoint
+	require.NoError(t, store.Set("a", "changed"))
+	require.NoError(t, store.Set("d", "4"))
+
+	opened, err := OpenCheckpoint(logger, dir, nil)
+	require.NoError(t, err)
+	defer opened.Close(context.Background())
+
+	for key, value := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+		data, err := opened.Get(context.Background(), key)
+		require.NoError(t, err)
+		assert.Equal(t, value, data.Value)
+	}
+
+	_, err = opened.Get(context.Background(), "d")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.ErrorIs(t, opened.Set("e", "5"), ErrReadOnly)
+
+	// removing the checkpoint leaves the store intact
+	require.NoError(t, os.RemoveAll(dir))
+	data, err := store.Get(context.Background(), "b")
+	require.NoError(t, err)
+	assert.Equal(t, "2", data.Value)
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Clock is the time source of a store. SST timestamps,
+// expiry and retention are read from it.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock reads the wall clock of the host.
+var SystemClock Clock = systemClock{}
+
+// clockGuard keeps the readings of a clock from going backwards.
+// When the source jumps back, e.g. after an NTP correction, a warning
+// is logged and every reading advances the last one by a nanosecond,
+// ordering timestamps like a sequence until the source catches up.
+type clockGuard struct {
+	logger *slog.Logger
+	source Clock
+
+	mu     sync.Mutex
+	last   time.Time
+	skewed bool
+}
+
+func newClockGuard(logger *slog.Logger, source Clock) *clockGuard {
+	return &clockGuard{
+		logger: logger,
+		source: source,
+	}
+}
+
+func (c *clockGuard) Now() time.Time {
+	// the monotonic reading would hide jumps of the wall clock
+	now := c.source.Now().Round(0)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Before(c.last) {
+		if !c.skewed {
+			c.logger.Warn("clock jumped backwards, ordering timestamps by sequence", "by", c.last.Sub(now), "last", c.last)
+			c.skewed = true
+		}
+
+		c.last = c.last.Add(time.Nanosecond)
+		return c.last
+	}
+
+	if c.skewed {
+		c.logger.Info("clock caught up")
+		c.skewed = false
+	}
+
+	c.last = now
+	return now
+}
+
+// observe records a timestamp written before, e.g. by a
+// previous run, later readings don't go before it.
+func (c *clockGuard) observe(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t.After(c.last) {
+		c.last = t
+	}
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestClockGuard(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	source := &fakeClock{now: start}
+
+	guard := newClockGuard(slog.New(slog.NewTextHandler(io.Discard, nil)), source)
+	assert.Equal(t, start, guard.Now())
+
+	// a backwards jump continues from the last reading
+	source.now = start.Add(-time.Minute)
+	assert.Equal(t, start.Add(time.Nanosecond), guard.Now())
+	assert.Equal(t, start.Add(2*time.Nanosecond), guard.Now())
+
+	source.now = start.Add(time.Second)
+	assert.Equal(t, start.Add(time.Second), guard.Now())
+
+	// timestamps written before are never passed backwards
+	guard.observe(start.Add(time.Hour))
+	assert.Equal(t, start.Add(time.Hour+time.Nanosecond), guard.Now())
+}
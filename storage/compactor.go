@@ -4,46 +4,94 @@ import (
 	"bufio"
 	"container/heap"
 	"context"
+	"distrikv/events"
+	"distrikv/pkg"
+	"distrikv/tracing"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
-	"path"
 	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const MAX_SST_PER_LEVEL = 5
 
+// MIN_SST_INTRA_L0 is the number of tiny L0 SSTs
+// merged into a single L0 SST, below MAX_SST_PER_LEVEL
+// so frequent small flushes don't pile up in L0.
+const MIN_SST_INTRA_L0 = 4
+
+// IntraL0FileSize is the size below which an L0 SST is tiny.
+var IntraL0FileSize int64 = 64 << 10
+
+// MIN_SST_PER_HOT_LEVEL is the number of SSTs at which
+// a level covering heavily-read ranges is already compacted.
+const MIN_SST_PER_HOT_LEVEL = 2
+
+// HotCompactionHeat is the sampled read count of a level's
+// compaction candidates above which the level is considered hot.
+var HotCompactionHeat uint64 = 100
+
 type kvEntry struct {
 	key       string
 	value     string
 	isDeleted bool
+	expiresAt time.Time
+	timestamp time.Time
+	versions  []Version
 	fileID    int
+
+	// written is the timestamp of the SST of the entry.
+	written time.Time
+
+	// sstID is the ID of the SST of the entry, higher is newer.
+	sstID uint64
 }
 
-type kvHeap []*kvEntry
+type kvHeap struct {
+	entries []*kvEntry
+	cmp     Comparator
+}
 
-func (h kvHeap) Len() int {
-	return len(h)
+func (h *kvHeap) Len() int {
+	return len(h.entries)
 }
 
-func (h kvHeap) Less(i, j int) bool {
-	return h[i].key < h[j].key
+// Less orders entries by key, the newest entry of a key first. Inputs
+// are SSTs of a level, their IDs tell their recency whatever the order
+// they're listed in, then by input, later is newer. The order is
+// total so the merge doesn't depend on how the heap breaks ties.
+func (h *kvHeap) Less(i, j int) bool {
+	a, b := h.entries[i], h.entries[j]
+	if c := h.cmp.Compare(a.key, b.key); c != 0 {
+		return c < 0
+	}
+
+	if a.sstID != b.sstID {
+		return a.sstID > b.sstID
+	}
+
+	return a.fileID > b.fileID
 }
 
-func (h kvHeap) Swap(i, j int) {
-	h[i], h[j] = h[j], h[i]
+func (h *kvHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
 }
 
 func (h *kvHeap) Push(x any) {
-	*h = append(*h, x.(*kvEntry))
+	h.entries = append(h.entries, x.(*kvEntry))
 }
 
 func (h *kvHeap) Pop() any {
-	old := *h
+	old := h.entries
 	n := len(old)
 	item := old[n-1]
-	*h = old[0 : n-1]
+	h.entries = old[0 : n-1]
 	return item
 }
 
@@ -51,6 +99,20 @@ type Compactor struct {
 	logger     *slog.Logger
 	Level      int
 	sstManager *SSTManager
+
+	// wake is signaled when an SST is written to the level.
+	wake chan struct{}
+
+	// purge is signaled when the level is to be purged, see purge.go.
+	purge chan struct{}
+
+	// aborted counts the compactions stopped by a shutdown.
+	aborted atomic.Int64
+
+	// cancel stops a compactor started by a CompactorManager,
+	// done is closed once it returned.
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
 func NewCompactor(
@@ -59,16 +121,31 @@ func NewCompactor(
 	sstManager *SSTManager,
 ) *Compactor {
 	return &Compactor{
-		logger:     logger,
+		logger:     logger.With(pkg.ComponentKey, "storage.compactor"),
 		Level:      level,
 		sstManager: sstManager,
+		wake:       make(chan struct{}, 1),
+		purge:      make(chan struct{}, 1),
 	}
 }
 
 type CompactorManager struct {
 	logger     *slog.Logger
 	sstManager *SSTManager
-	compactors []Compactor
+
+	// ctx is the context of Start, canceled by Stop. Every compactor
+	// runs with a context of its own, canceled when it's paused.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// mu guards ctx, compactors and paused. Compactors are
+	// started by the events and the purge schedule.
+	mu         sync.Mutex
+	compactors []*Compactor
+	paused     bool
+
+	// wg tracks the compactors and the goroutines starting them.
+	wg sync.WaitGroup
 }
 
 func NewCompactorManager(
@@ -76,118 +153,658 @@ func NewCompactorManager(
 	sstManager *SSTManager,
 ) *CompactorManager {
 	return &CompactorManager{
-		logger:     logger,
+		logger:     logger.With(pkg.ComponentKey, "storage.compactor"),
 		sstManager: sstManager,
 	}
 }
 
-func (c *CompactorManager) StartCompactors(ctx context.Context) {
+// Start starts a compactor for every level in Levels, levels
+// between them without SSTs get none, until Stop or ctx is done.
+// Compactors are woken up by the flush, compaction, ingest and garbage
+// events of the store, levels created by compactions get their
+// compactor on the first event. The bottom level is purged as
+// PurgeSchedule says.
+func (c *CompactorManager) Start(ctx context.Context) {
 	c.logger.Info("starting compactors")
-	// TODO: will query for how many levels (n) of sst
-	// there currently is and start n numbers
-	// of goroutine to monitor each level.
-	// will also have a goroutine to poll the sst manager
-	// about total levels and add more compactors
 
-	levels := c.sstManager.GetLevels()
+	c.mu.Lock()
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	ctx = c.ctx
+	c.mu.Unlock()
+
+	if schedule := PurgeSchedule; schedule != nil {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.schedulePurges(ctx, schedule)
+		}()
+	}
+
+	sub := c.sstManager.events.Subscribe(events.DefaultBuffer, events.TOPIC_FLUSH, events.TOPIC_COMPACTION, events.TOPIC_INGEST, events.TOPIC_GARBAGE)
+
+	for _, level := range c.sstManager.Levels() {
+		c.compactor(level)
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer sub.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-sub.C:
+				level, ok := c.writtenLevel(event)
+				if !ok {
+					break
+				}
+
+				compactor := c.compactor(level)
+				if compactor == nil {
+					break
+				}
+
+				select {
+				case compactor.wake <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+}
+
+// writtenLevel returns the level an SST of this store was written to,
+// or the level of an SST of this store that reached the garbage ratio.
+func (c *CompactorManager) writtenLevel(event events.Event) (int, bool) {
+	switch data := event.Data.(type) {
+	case events.FlushEvent:
+		return 0, data.Dir == c.sstManager.dir
+	case events.CompactionEvent:
+		return data.OutputLevel, data.Dir == c.sstManager.dir
+	case events.IngestEvent:
+		return data.Level, data.Dir == c.sstManager.dir
+	case events.GarbageEvent:
+		return data.Level, data.Dir == c.sstManager.dir
+	default:
+		return 0, false
+	}
+}
+
+// compactor returns the compactor of level, starting it if needed,
+// nil while compactions are paused and once they're stopped.
+func (c *CompactorManager) compactor(level int) *Compactor {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.paused || c.ctx == nil || c.ctx.Err() != nil {
+		return nil
+	}
+
+	for _, compactor := range c.compactors {
+		if compactor.Level == level {
+			return compactor
+		}
+	}
+
+	return c.start(level)
+}
+
+// start starts the compactor of level, it's called with mu held.
+func (c *CompactorManager) start(level int) *Compactor {
+	ctx, cancel := context.WithCancel(c.ctx)
+
+	compactor := NewCompactor(c.logger, level, c.sstManager)
+	compactor.cancel = cancel
+	compactor.done = make(chan struct{})
+	c.compactors = append(c.compactors, compactor)
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer close(compactor.done)
+		compactor.startCompactor(ctx)
+	}()
+
+	return compactor
+}
 
+// Stop stops the compactors and waits until they returned. Running
+// compactions are aborted and keep their inputs, Stop returns how
+// many the running compactors aborted.
+func (c *CompactorManager) Stop() int {
+	c.mu.Lock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.mu.Unlock()
+
+	c.wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	aborted := 0
+	for _, compactor := range c.compactors {
+		aborted += int(compactor.aborted.Load())
+	}
+
+	return aborted
+}
+
+// Pause stops the compactors until Resume, e.g. during a maintenance
+// window, and returns once they returned. Running compactions are
+// aborted and keep their inputs. Manual compactions, see CompactAll,
+// still run.
+func (c *CompactorManager) Pause() {
+	c.mu.Lock()
+	if c.paused {
+		c.mu.Unlock()
+		return
+	}
+
+	c.paused = true
+	compactors := c.compactors
+	c.compactors = nil
+	c.mu.Unlock()
+
+	for _, compactor := range compactors {
+		compactor.cancel()
+	}
+
+	for _, compactor := range compactors {
+		<-compactor.done
+	}
+
+	c.logger.Info("paused compactions", "compactors", len(compactors))
+}
+
+// Resume starts the compactors of every level again after Pause,
+// they first compact the levels that filled up meanwhile.
+func (c *CompactorManager) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.paused {
+		return
+	}
+
+	c.paused = false
+	if c.ctx == nil || c.ctx.Err() != nil {
+		return
+	}
+
+	levels := c.sstManager.Levels()
 	for _, level := range levels {
-		compactor := NewCompactor(c.logger, level, c.sstManager)
-		c.compactors = append(c.compactors, *compactor)
-		go compactor.startCompactor(ctx)
+		c.start(level)
 	}
 
-	go c.startLevelChecker(ctx)
+	c.logger.Info("resumed compactions", "compactors", len(levels))
 }
 
-func (c *Compactor) startCompactor(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+// Paused reports whether compactions are paused.
+func (c *CompactorManager) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.paused
+}
 
+func (c *Compactor) startCompactor(ctx context.Context) {
 	for {
+		// a level can hold several batches of SSTs to compact
+		for c.compactOnce(ctx) {
+		}
+
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			ssts := c.sstManager.ListSST(
-				c.Level,
-				[]SSTState{SST_FLUSHED},
-				MAX_SST_PER_LEVEL,
-			)
-
-			if len(ssts) < MAX_SST_PER_LEVEL {
-				break
-			}
+		case <-c.wake:
+		case <-c.purge:
+			c.purgeOnce(ctx)
+		}
+	}
+}
 
-			err := c.compact(ssts)
-			if err != nil {
-				c.logger.Error("error compacting SST", "err", err)
+// compactOnce compacts the oldest SSTs of the level into the next
+// level if there are enough of them or one of them reached the garbage
+// ratio, otherwise tiny L0 SSTs are merged within L0. It reports
+// whether a compaction ran.
+func (c *Compactor) compactOnce(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	c.sstManager.compactMu.RLock()
+	defer c.sstManager.compactMu.RUnlock()
+
+	ssts, outLevel, _ := c.next()
+	if len(ssts) == 0 {
+		return false
+	}
+
+	return c.run(ctx, ssts, outLevel, false)
+}
+
+// Compaction triggers, why the compactor of a level compacts it.
+const (
+	// TRIGGER_FILES compacts the level holding MaxFiles SSTs.
+	TRIGGER_FILES = "files"
+
+	// TRIGGER_HOT compacts a level covering heavily-read ranges
+	// holding MIN_SST_PER_HOT_LEVEL SSTs.
+	TRIGGER_HOT = "hot"
+
+	// TRIGGER_GARBAGE compacts an SST that reached GarbageRatio.
+	TRIGGER_GARBAGE = "garbage"
+
+	// TRIGGER_INTRA_L0 merges tiny L0 SSTs within L0.
+	TRIGGER_INTRA_L0 = "intra_l0"
+)
+
+// next returns the SSTs the compactor compacts next, the level
+// they're written to and the trigger, no SSTs when none is due.
+func (c *Compactor) next() ([]*SST, int, string) {
+	maxFiles := levelOptions(c.Level).MaxFiles
+	ssts := c.sstManager.ListSST(
+		c.Level,
+		[]SSTState{SST_FLUSHED},
+		maxFiles,
+	)
+
+	if threshold := c.compactionThreshold(ssts); len(ssts) >= threshold {
+		if threshold < maxFiles {
+			return ssts, c.Level + 1, TRIGGER_HOT
+		}
+
+		return ssts, c.Level + 1, TRIGGER_FILES
+	}
+
+	if n := garbageInputs(ssts); n > 0 {
+		return ssts[:n], c.Level + 1, TRIGGER_GARBAGE
+	}
+
+	if c.Level == 0 {
+		if tiny := c.tinyL0SSTs(); len(tiny) >= MIN_SST_INTRA_L0 {
+			return tiny, 0, TRIGGER_INTRA_L0
+		}
+	}
+
+	return nil, c.Level + 1, ""
+}
+
+// CompactAll compacts the SSTs of every level into the next one,
+// regardless of the compaction thresholds. The last level is only
+// compacted into a new level when it holds several SSTs. Background
+// compactions wait until it returned, progress is called once
+// a level is compacted.
+func (s *SSTManager) CompactAll(ctx context.Context, progress func(done int, total int)) error {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	levels := s.Levels()
+
+	for i, level := range levels {
+		compactor := NewCompactor(s.logger, level, s)
+		last := i == len(levels)-1
+
+		for {
+			ssts := s.ListSST(level, []SSTState{SST_FLUSHED}, levelOptions(level).MaxFiles)
+			if len(ssts) == 0 || (last && len(ssts) < 2) {
 				break
 			}
 
-			// update sst to be deleted
-			err = c.sstManager.updateBatch(
-				c.Level,
-				ssts,
-				SST_COMPACTED,
-			)
-			if err != nil {
-				c.logger.Error("error updating SST", "err", err)
-				break
+			if !compactor.run(ctx, ssts, level+1, false) {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
+				return fmt.Errorf("compaction of level %d failed", level)
 			}
 		}
+
+		progress(i+1, len(levels))
 	}
+
+	return nil
 }
 
-func (c *CompactorManager) GetLevels() []int {
-	var levels []int
-	for _, compactor := range c.compactors {
-		levels = append(levels, compactor.Level)
+// tinyL0SSTs returns the newest run of L0 SSTs smaller than
+// IntraL0FileSize. Only the newest SSTs can be merged within L0,
+// the output is newer than every SST left in the level.
+func (c *Compactor) tinyL0SSTs() []*SST {
+	all := c.sstManager.ListSST(
+		0,
+		[]SSTState{SST_FLUSHING, SST_FLUSHED, SST_COMPACTING},
+		0,
+	)
+
+	// states are only read under the level lock
+	flushed := c.sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0)
+
+	var tiny []*SST
+	for i := len(all) - 1; i >= 0; i-- {
+		sst := all[i]
+		if !slices.Contains(flushed, sst) {
+			break
+		}
+
+		info, err := os.Stat(sst.Path())
+		if err != nil || info.Size() >= IntraL0FileSize {
+			break
+		}
+
+		tiny = append(tiny, sst)
 	}
 
-	return levels
+	slices.Reverse(tiny)
+
+	return tiny
 }
 
-func (c *CompactorManager) startLevelChecker(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+// run compacts ssts into new SSTs on outLevel, or moves them there
+// when they don't overlap, and marks the inputs compacted, it reports
+// whether it succeeded.
+// A compaction aborted by ctx keeps its inputs. purge is
+// set for the passes of the purge schedule, see purge.go.
+func (c *Compactor) run(ctx context.Context, ssts []*SST, outLevel int, purge bool) bool {
+	start := time.Now()
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			levels := c.sstManager.GetLevels()
-			existingLevels := c.GetLevels()
-
-			for _, level := range levels {
-				if !slices.Contains(existingLevels, level) {
-					compactor := NewCompactor(c.logger, level, c.sstManager)
-					c.compactors = append(c.compactors, *compactor)
-					go compactor.startCompactor(ctx)
-				}
-			}
+	ctx, span := tracing.Start(
+		ctx,
+		"compaction",
+		tracing.SPAN_KIND_INTERNAL,
+		tracing.Attr{Key: "distrikv.dir", Value: c.sstManager.dir},
+		tracing.Attr{Key: "distrikv.level", Value: c.Level},
+		tracing.Attr{Key: "distrikv.output_level", Value: outLevel},
+		tracing.Attr{Key: "distrikv.inputs", Value: len(ssts)},
+		tracing.Attr{Key: "distrikv.purge", Value: purge},
+	)
+	defer span.End()
+
+	// inputs no other SST overlaps are moved instead of merged
+	moved := outLevel != c.Level && c.movable(ssts, outLevel)
+
+	var outSSTs []*SST
+	var err error
+	if moved {
+		// checkpoints and snapshots link the SSTs once the move is
+		// installed or undone, the footer of the inputs changes
+		c.sstManager.linkMu.Lock()
+		defer c.sstManager.linkMu.Unlock()
+
+		outSSTs, err = c.move(ctx, ssts, outLevel)
+	} else {
+		outSSTs, err = c.compact(ctx, ssts, outLevel, purge)
+	}
+	span.SetError(err)
+	span.SetAttr("distrikv.outputs", len(outSSTs))
+	span.SetAttr("distrikv.moved", moved)
+
+	if errors.Is(err, context.Canceled) {
+		c.logger.Info("compaction aborted", "level", c.Level, "inputs", len(ssts))
+		c.aborted.Add(1)
+		return false
+	}
+
+	c.sstManager.health.record(err)
+	if err != nil {
+		c.logger.Error("error compacting SST", "err", err)
+		return false
+	}
+
+	if outLevel == c.Level && c.flushedDuring(ssts, outSSTs) {
+		c.logger.Info("discarding intra level compaction, SSTs were written meanwhile", "level", c.Level)
+		c.sstManager.discard(outLevel, outSSTs)
+		return false
+	}
+
+	for _, sst := range ssts {
+		sst.replaced.Store(true)
+	}
+
+	// the outputs are complete and can be read and compacted further,
+	// a compaction dropping every entry has none
+	if len(outSSTs) > 0 {
+		err = c.sstManager.updateBatch(
+			outLevel,
+			outSSTs,
+			SST_FLUSHED,
+		)
+		if err != nil {
+			c.logger.Error("error updating SST", "err", err)
+			return false
 		}
 	}
+
+	// update sst to be deleted
+	err = c.sstManager.updateBatch(
+		c.Level,
+		ssts,
+		SST_COMPACTED,
+	)
+	if err != nil {
+		c.logger.Error("error updating SST", "err", err)
+		return false
+	}
+
+	inputs, outputs := sstNames(ssts), sstNames(outSSTs)
+
+	var inputBytes, outputBytes int64
+	for _, sst := range ssts {
+		inputBytes += sst.fileSize()
+	}
+
+	for _, sst := range outSSTs {
+		outputBytes += sst.fileSize()
+	}
+
+	c.logger.Debug("compacted SSTs", "level", c.Level, "output_level", outLevel, "inputs", inputs, "outputs", outputs, "moved", moved, "duration", time.Since(start))
+
+	c.sstManager.events.Publish(events.TOPIC_COMPACTION, events.CompactionEvent{
+		Dir:         c.sstManager.dir,
+		Level:       c.Level,
+		OutputLevel: outLevel,
+		Inputs:      inputs,
+		Outputs:     outputs,
+		Moved:       moved,
+		InputBytes:  inputBytes,
+		OutputBytes: outputBytes,
+		Duration:    time.Since(start),
+	})
+
+	return true
 }
 
-func (c *Compactor) compact(ssts []*SST) error {
-	var scanners []*bufio.Scanner
-	var files []*os.File
+// flushedDuring reports whether an SST was added to the level between
+// the inputs of an intra level compaction and its outputs, flushed or
+// compacted from the level above. Such an SST is newer than the inputs
+// but could be shadowed by an output.
+func (c *Compactor) flushedDuring(inputs []*SST, outputs []*SST) bool {
+	newest := inputs[len(inputs)-1].ID
 
+	var last uint64
+	for _, sst := range outputs {
+		last = max(last, sst.ID)
+	}
+
+	all := c.sstManager.ListSST(
+		c.Level,
+		[]SSTState{SST_FLUSHING, SST_FLUSHED, SST_COMPACTING},
+		0,
+	)
+
+	for _, sst := range all {
+		if sst.ID > newest && sst.ID < last && !slices.Contains(outputs, sst) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// discard removes the outputs of a compaction that isn't installed.
+func (s *SSTManager) discard(level int, outputs []*SST) {
+	if len(outputs) == 0 {
+		return
+	}
+
+	s.RemoveSST(level, outputs)
+	for _, sst := range outputs {
+		os.Remove(sst.Path())
+	}
+}
+
+// compactionThreshold returns the number of SSTs needed to compact the level.
+// Levels whose candidates cover heavily-read ranges are compacted
+// earlier to reduce their read amplification first.
+func (c *Compactor) compactionThreshold(candidates []*SST) int {
+	maxFiles := levelOptions(c.Level).MaxFiles
+	hot := min(MIN_SST_PER_HOT_LEVEL, maxFiles)
+
+	if len(candidates) < hot {
+		return maxFiles
+	}
+
+	if c.sstManager.heat(candidates) >= HotCompactionHeat {
+		return hot
+	}
+
+	return maxFiles
+}
+
+// compact merges ssts into new SSTs on outLevel. The key range of the
+// inputs is split into the Concurrency of the level, merged in parallel,
+// and every range is written to SSTs of the TargetFileSize of outLevel.
+// Write and sync failures are wrapped in ErrDiskWrite, the outputs
+// are removed on failures, also when ctx is done.
+//
+// Expired entries are written as tombstones, older SSTs
+// outside the compaction may still hold values of their keys.
+// Previous versions no longer retained are dropped, as are the keys
+// dead in the input holding their newest entry. While garbage is
+// tracked, the entries of the keys written in older SSTs are dead.
+//
+// A purge compacts every SST of the bottom level, no SST outside the
+// compaction holds their keys: expired entries are dropped instead,
+// as are tombstones written before PurgeTombstoneAge.
+func (c *Compactor) compact(ctx context.Context, ssts []*SST, outLevel int, purge bool) ([]*SST, error) {
+	var older []*SST
+	if trackGarbage() {
+		older = c.sstManager.olderSSTs(ssts)
+		defer releaseSSTs(older)
+	}
+
+	bounds, err := c.rangeBounds(ctx, ssts, levelOptions(c.Level).Concurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	// the first failing range stops the others
+	rangeCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	outputs := make([][]*SST, len(bounds)+1)
+	var wg sync.WaitGroup
+	for i := range outputs {
+		var start, end string
+		if i > 0 {
+			start = bounds[i-1]
+		}
+		if i < len(bounds) {
+			end = bounds[i]
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			out, err := c.compactRange(rangeCtx, ssts, outLevel, purge, older, start, end)
+			if err != nil {
+				cancel(err)
+				return
+			}
+
+			outputs[i] = out
+		}()
+	}
+	wg.Wait()
+
+	var res []*SST
+	for _, out := range outputs {
+		res = append(res, out...)
+	}
+
+	if err := context.Cause(rangeCtx); err != nil {
+		c.sstManager.discard(outLevel, res)
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// rangeBounds returns the keys splitting the inputs of a compaction
+// into n ranges of about the same number of keys, sampled from the
+// indexes of the inputs. There are fewer with fewer distinct keys.
+func (c *Compactor) rangeBounds(ctx context.Context, ssts []*SST, n int) ([]string, error) {
+	if n <= 1 {
+		return nil, nil
+	}
+
+	cmp := c.sstManager.cmp
+
+	// samples per range and input
+	const samples = 16
+
+	var keys []string
 	for _, sst := range ssts {
-		f, err := os.Open(path.Join(baseDir, sst.FileName))
+		index, err := sst.indexed(ctx)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		scanner := bufio.NewScanner(f)
+		step := max(1, len(index)/(n*samples))
+		for i := 0; i < len(index); i += step {
+			keys = append(keys, index[i].key)
+		}
+	}
 
-		scanners = append(scanners, scanner)
-		files = append(files, f)
+	slices.SortFunc(keys, cmp.Compare)
+	keys = slices.CompactFunc(keys, func(a string, b string) bool {
+		return cmp.Compare(a, b) == 0
+	})
+
+	var bounds []string
+	for i := 1; i < n; i++ {
+		j := i * len(keys) / n
+		if j == 0 || (len(bounds) > 0 && cmp.Compare(keys[j], bounds[len(bounds)-1]) == 0) {
+			continue
+		}
+
+		bounds = append(bounds, keys[j])
 	}
 
+	return bounds, nil
+}
+
+// compactRange merges the entries of ssts from start, included, to end,
+// excluded, into new SSTs on outLevel, see compact. An empty start or
+// end leaves the range open. No SST is written for a range without
+// entries left. The outputs are removed when it fails.
+func (c *Compactor) compactRange(ctx context.Context, ssts []*SST, outLevel int, purge bool, older []*SST, start string, end string) (_ []*SST, err error) {
+	cmp := c.sstManager.cmp
+
+	readers := make([]*bufio.Reader, len(ssts))
+	var files []*os.File
+
 	defer func() {
+		for _, r := range readers {
+			if r != nil {
+				putReader(r)
+			}
+		}
+
 		for _, f := range files {
 			err := f.Close()
 			if err != nil {
@@ -196,81 +813,299 @@ func (c *Compactor) compact(ssts []*SST) error {
 		}
 	}()
 
-	h := &kvHeap{}
-
-	heap.Init(h)
-
-	for idx, scanner := range scanners {
-		if scanner.Scan() {
-			entry, err := parseSSTLine(scanner.Bytes())
+	for idx, sst := range ssts {
+		var offset int64
+		if start != "" {
+			index, err := sst.indexed(ctx)
 			if err != nil {
-				return err
+				return nil, err
 			}
 
-			heap.Push(h, &kvEntry{
-				key:    entry.Key,
-				value:  entry.Value,
-				fileID: idx,
+			i := sort.Search(len(index), func(i int) bool {
+				return cmp.Compare(index[i].key, start) >= 0
 			})
+			if i == len(index) {
+				continue
+			}
+
+			offset = index[i].offset
+		}
+
+		f, err := os.Open(sst.Path())
+		if err != nil {
+			return nil, err
 		}
+		files = append(files, f)
+
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		// entries are length prefixed, values may hold newlines
+		readers[idx] = getReader(f)
 	}
 
-	outSST := c.sstManager.NewSST(c.Level+1, SST_COMPACTING)
-	outFile, err := os.Create(path.Join(baseDir, outSST.FileName))
-	if err != nil {
-		return err
+	h := &kvHeap{cmp: cmp}
+
+	heap.Init(h)
+
+	// next pushes the next entry of the file fileID, if any in the range
+	next := func(fileID int) error {
+		if readers[fileID] == nil {
+			return nil
+		}
+
+		entry, err := readSSTEntry(readers[fileID])
+		if errors.Is(err, ErrSSTEntryEOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if end != "" && cmp.Compare(entry.Key, end) >= 0 {
+			return nil
+		}
+
+		kv := kvEntryPool.Get().(*kvEntry)
+		*kv = kvEntry{
+			key:       entry.Key,
+			value:     entry.Value,
+			isDeleted: entry.IsDeleted,
+			expiresAt: entry.ExpiresAt,
+			timestamp: entry.Timestamp,
+			versions:  entry.Versions,
+			fileID:    fileID,
+			sstID:     ssts[fileID].ID,
+			written:   ssts[fileID].Timestamp,
+		}
+		heap.Push(h, kv)
+
+		return nil
 	}
 
-	outWriter := bufio.NewWriter(outFile)
+	for idx := range readers {
+		if err := next(idx); err != nil {
+			return nil, err
+		}
+	}
+
+	now := c.sstManager.clock.Now()
+	purgeBefore := now.Add(-PurgeTombstoneAge)
+
+	// the outputs keep the timestamp of the newest input,
+	// so SST timestamps bound the write time of their entries.
+	var timestamp time.Time
+	for _, sst := range ssts {
+		if sst.Timestamp.After(timestamp) {
+			timestamp = sst.Timestamp
+		}
+	}
+
+	targetSize := levelOptions(outLevel).TargetFileSize
+	out := &compactionOutput{
+		sstManager: c.sstManager,
+		level:      outLevel,
+		timestamp:  timestamp,
+	}
+	defer func() {
+		if err != nil {
+			out.abort()
+		}
+	}()
 
 	var lastKey string
+	written := false
+
+	// kv is the entry of lastKey, written once every input entry of the key
+	// was read. The older entries of the key are dropped, or kept as its
+	// previous versions while versions are retained.
+	var kv *SSTEntry
+	var kvDead bool
+	var kvWritten time.Time
+	writeKV := func() error {
+		if kv == nil {
+			return nil
+		}
+
+		c.markOlder(ctx, older, kv.Key)
+		if kvDead {
+			return nil
+		}
+
+		expired := kv.expired(now)
+		if expired {
+			tombstone := &SSTEntry{Key: kv.Key, IsDeleted: true}
+			if versionsRetained() {
+				// reads before the expiry still see the value
+				tombstone.Timestamp = kv.ExpiresAt
+				tombstone.Versions = kv.history(kv.Timestamp)
+			}
+
+			kv = tombstone
+		}
+
+		if versionsRetained() {
+			kv.Versions = retainVersions(kv.Versions, kv.Timestamp, now)
+		} else {
+			kv.Timestamp, kv.Versions = time.Time{}, nil
+		}
+
+		if purge && kv.IsDeleted && len(kv.Versions) == 0 && (expired || kvWritten.Before(purgeBefore)) {
+			return nil
+		}
+
+		if err := out.write(kv); err != nil {
+			return err
+		}
+
+		// outputs are cut between keys
+		if targetSize > 0 && out.size >= targetSize {
+			return out.finish()
+		}
+
+		return nil
+	}
 
 	for h.Len() > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
 		entry := heap.Pop(h).(*kvEntry)
+		e := &SSTEntry{
+			Key:       entry.key,
+			Value:     entry.value,
+			IsDeleted: entry.isDeleted,
+			ExpiresAt: entry.expiresAt,
+			Timestamp: entry.timestamp,
+			Versions:  entry.versions,
+		}
 
 		// FIFO setup, first unique key to be found is consider the latest
-		if entry.key != lastKey {
-			err := encodeSSTEntry(outWriter, entry.key, entry.value, entry.isDeleted)
-			if err != nil {
-				return err
+		switch {
+		case !written || h.cmp.Compare(entry.key, lastKey) != 0:
+			if err := writeKV(); err != nil {
+				return nil, err
 			}
+
+			kv = e
+			kvDead = trackGarbage() && ssts[entry.fileID].isDead(entry.key)
+			kvWritten = entry.written
+			if versionsRetained() && kv.Timestamp.IsZero() {
+				kv.Timestamp = entry.written
+			}
+
 			lastKey = entry.key
+			written = true
+		case versionsRetained():
+			kv.Versions = append(kv.Versions, e.history(entry.written)...)
 		}
 
-		// advance entry scanner
-		scanner := scanners[entry.fileID]
-		if scanner.Scan() {
-			sstEntry, err := parseSSTLine(scanner.Bytes())
-			if err != nil && !errors.Is(err, ErrSSTEntryEOF) {
-				return err
-			}
+		fileID := entry.fileID
+		*entry = kvEntry{}
+		kvEntryPool.Put(entry)
 
-			if errors.Is(err, ErrSSTEntryEOF) {
-				continue
-			}
+		if err := next(fileID); err != nil {
+			return nil, err
+		}
+	}
 
-			heap.Push(h, &kvEntry{
-				key:    sstEntry.Key,
-				value:  sstEntry.Value,
-				fileID: entry.fileID,
-			})
+	if err := writeKV(); err != nil {
+		return nil, err
+	}
+
+	if err := out.finish(); err != nil {
+		return nil, err
+	}
+
+	return out.done, nil
+}
+
+// compactionOutput writes the entries of a compaction range
+// to SSTs of a level, a new one once finish was called.
+type compactionOutput struct {
+	sstManager *SSTManager
+	level      int
+	timestamp  time.Time
+
+	// the SST being written, nil until the next entry
+	sst           *SST
+	file          *os.File
+	writer        *bufio.Writer
+	entriesWriter *sstWriter
+	size          int64
+	entries       int64
+	minKey        string
+	maxKey        string
+
+	// done are the SSTs written
+	done []*SST
+}
+
+// write appends entry to the current SST, created if needed.
+func (o *compactionOutput) write(entry *SSTEntry) error {
+	if o.sst == nil {
+		sst := o.sstManager.NewSST(o.level, SST_COMPACTING)
+		sst.Timestamp = o.timestamp
+
+		f, err := os.Create(sst.Path())
+		if err != nil {
+			o.sstManager.RemoveSST(o.level, []*SST{sst})
+			return diskWriteError(err)
 		}
+
+		o.sst, o.file, o.writer = sst, f, bufio.NewWriter(f)
+		o.entriesWriter = newSSTWriter(o.writer)
+		o.size, o.entries = 0, 0
+		o.minKey = entry.Key
 	}
 
-	err = writeSSTMetadata(outWriter, outSST.ID, c.Level+1, time.Now())
-	if err != nil {
-		return err
+	if err := writeSSTEntry(o.entriesWriter, entry); err != nil {
+		return diskWriteError(err)
 	}
 
-	err = outWriter.Flush()
-	if err != nil {
-		return err
+	o.maxKey = entry.Key
+	o.size += int64(entry.size()) + 1
+	o.entries++
+
+	return nil
+}
+
+// finish completes the current SST, if any.
+func (o *compactionOutput) finish() error {
+	if o.sst == nil {
+		return nil
 	}
 
-	err = outFile.Close()
+	sst := o.sst
+	err := writeSSTMetadata(o.entriesWriter, sst.ID, o.level, sst.Timestamp, o.sstManager.cmp)
 	if err != nil {
+		return diskWriteError(err)
+	}
+
+	if err := finishSST(o.file, o.writer, o.sstManager.dir); err != nil {
 		return err
 	}
 
-	return err
+	sst.setKeyRange(o.minKey, o.maxKey)
+	sst.entries.Store(o.entries)
+
+	o.done = append(o.done, sst)
+	o.sst, o.file, o.writer, o.entriesWriter = nil, nil, nil, nil
+
+	return nil
+}
+
+// abort removes the SSTs written and the one being written.
+func (o *compactionOutput) abort() {
+	if o.sst != nil {
+		o.file.Close()
+		o.done = append(o.done, o.sst)
+		o.sst = nil
+	}
+
+	o.sstManager.discard(o.level, o.done)
+	o.done = nil
 }
@@ -0,0 +1,474 @@
+package storage
+
+import (
+	"container/heap"
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntraL0Compaction(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	sstManager, err := NewSSTManager(logger, t.TempDir(), OPEN_FAST, nil)
+	assert.NoError(t, err)
+
+	for i := range MIN_SST_INTRA_L0 {
+		memtable := NewMemtable(BytewiseComparator)
+		memtable.Set("a", string(rune('0'+i)), false)
+		assert.NoError(t, sstManager.FlushSST(memtable))
+	}
+
+	compactor := NewCompactor(logger, 0, sstManager)
+	assert.True(t, compactor.compactOnce(context.Background()))
+
+	// the tiny SSTs are merged within L0
+	flushed := sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0)
+	assert.Len(t, flushed, 1)
+	assert.Len(t, sstManager.ListSST(0, []SSTState{SST_COMPACTED}, 0), MIN_SST_INTRA_L0)
+	assert.NotContains(t, sstManager.Levels(), 1)
+
+	entry, err := flushed[0].FindKey(context.Background(), "a")
+	assert.NoError(t, err)
+	assert.Equal(t, string(rune('0'+MIN_SST_INTRA_L0-1)), entry.Value)
+
+	assert.False(t, compactor.compactOnce(context.Background()))
+}
+
+func TestCompactAll(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	sstManager, err := NewSSTManager(logger, t.TempDir(), OPEN_FAST, nil)
+	assert.NoError(t, err)
+
+	// too few SSTs for a background compaction
+	for i := range 2 {
+		memtable := NewMemtable(BytewiseComparator)
+		memtable.Set("a", string(rune('0'+i)), false)
+		assert.NoError(t, sstManager.FlushSST(memtable))
+	}
+
+	var progress []int
+	err = sstManager.CompactAll(context.Background(), func(done int, total int) {
+		progress = append(progress, done)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1}, progress)
+
+	assert.Empty(t, sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0))
+	flushed := sstManager.ListSST(1, []SSTState{SST_FLUSHED}, 0)
+	assert.Len(t, flushed, 1)
+
+	entry, err := flushed[0].FindKey(context.Background(), "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", entry.Value)
+
+	// a single SST in the last level is left in place
+	assert.NoError(t, sstManager.CompactAll(context.Background(), func(int, int) {}))
+	assert.Len(t, sstManager.ListSST(1, []SSTState{SST_FLUSHED}, 0), 1)
+	assert.NotContains(t, sstManager.Levels(), 2)
+}
+
+func TestCleaner(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+
+	sstManager, err := NewSSTManager(logger, dir, OPEN_FAST, nil)
+	require.NoError(t, err)
+
+	flush := func(value string) {
+		memtable := NewMemtable(BytewiseComparator)
+		memtable.Set("a", value, false)
+		require.NoError(t, sstManager.FlushSST(memtable))
+	}
+
+	files := func() []string {
+		files, err := filepath.Glob(filepath.Join(dir, "*"+SSTFileFormat))
+		require.NoError(t, err)
+		return files
+	}
+
+	flush("1")
+	flush("2")
+
+	// a read holds the SSTs it listed
+	ssts := sstManager.sstsForRead()
+	require.NoError(t, sstManager.CompactAll(context.Background(), func(int, int) {}))
+	readable := sstManager.sstsForRead()
+	assert.Len(t, readable, 1)
+	releaseSSTs(readable)
+
+	assert.False(t, sstManager.clean())
+	assert.Len(t, files(), 3)
+
+	releaseSSTs(ssts)
+	assert.True(t, sstManager.clean())
+	assert.Len(t, files(), 1)
+	assert.Empty(t, sstManager.ListSST(0, []SSTState{SST_COMPACTED}, 0))
+
+	// fewer compacted SSTs than a compaction merges, on every level
+	flush("3")
+	require.NoError(t, sstManager.CompactAll(context.Background(), func(int, int) {}))
+	assert.True(t, sstManager.clean())
+	assert.False(t, sstManager.clean())
+
+	for _, level := range sstManager.Levels() {
+		assert.Empty(t, sstManager.ListSST(level, []SSTState{SST_COMPACTED}, 0), "level %d", level)
+	}
+
+	readable = sstManager.sstsForRead()
+	defer releaseSSTs(readable)
+	assert.Len(t, files(), len(readable))
+
+	entry, err := readable[0].FindKey(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "3", entry.Value)
+}
+
+func TestCleanerDeeperLevels(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	sstManager, err := NewSSTManager(logger, dir, OPEN_FAST, nil)
+	require.NoError(t, err)
+
+	flush := func(keys ...string) {
+		memtable := NewMemtable(BytewiseComparator)
+		for _, key := range keys {
+			memtable.Set(key, key, false)
+		}
+		require.NoError(t, sstManager.FlushSST(memtable))
+	}
+
+	// two overlapping SSTs of level 1 merged into level 2
+	flush("a", "c")
+	flush("b", "d")
+	require.True(t, NewCompactor(logger, 0, sstManager).run(ctx, sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0), 1, false))
+	flush("a", "c")
+	flush("b", "d")
+	require.True(t, NewCompactor(logger, 0, sstManager).run(ctx, sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0), 1, false))
+	require.True(t, sstManager.clean())
+
+	inputs := sstManager.ListSST(1, []SSTState{SST_FLUSHED}, 0)
+	require.Len(t, inputs, 2)
+	require.True(t, NewCompactor(logger, 1, sstManager).run(ctx, inputs, 2, false))
+
+	// level 0 holds fewer SSTs than a compaction merges
+	flush("e")
+	require.Less(t, len(sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0)), MAX_SST_PER_LEVEL)
+	require.Len(t, sstManager.ListSST(1, []SSTState{SST_COMPACTED}, 0), 2)
+
+	assert.True(t, sstManager.clean())
+	assert.Empty(t, sstManager.ListSST(1, []SSTState{SST_COMPACTED}, 0))
+
+	for _, sst := range inputs {
+		assert.NoFileExists(t, sst.Path())
+	}
+}
+
+func TestCleanerKeepsSSTsOfOpenIterators(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	store, err := Open(ctx, logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	sstManager := store.Backend.sstManager
+	for _, key := range []string{"a", "b"} {
+		memtable := NewMemtable(BytewiseComparator)
+		memtable.Set(key, key, false)
+		require.NoError(t, sstManager.FlushSST(memtable))
+	}
+	inputs := sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0)
+
+	// the SSTs compacted while a scan iterates them stay until it ends
+	var keys []string
+	err = store.Scan(ctx, "", "", func(data *KVData) bool {
+		keys = append(keys, data.Key)
+		if len(keys) > 1 {
+			return true
+		}
+
+		require.NoError(t, sstManager.CompactAll(ctx, func(int, int) {}))
+		assert.False(t, sstManager.clean())
+
+		for _, sst := range inputs {
+			assert.FileExists(t, sst.Path())
+		}
+
+		return true
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, keys)
+
+	assert.True(t, sstManager.clean())
+	for _, sst := range inputs {
+		assert.NoFileExists(t, sst.Path())
+	}
+}
+
+func TestKVHeapOrder(t *testing.T) {
+	entries := []kvEntry{
+		{key: "a", sstID: 3, fileID: 0},
+		{key: "a", sstID: 2, fileID: 2},
+		{key: "a", sstID: 2, fileID: 1},
+		{key: "b", sstID: 1, fileID: 0},
+		{key: "c", sstID: 4, fileID: 1},
+	}
+
+	// entries are popped in the same order whatever the order they're pushed in
+	var permute func(n int)
+	permute = func(n int) {
+		if n == 1 {
+			h := &kvHeap{cmp: BytewiseComparator}
+			for i := range entries {
+				heap.Push(h, &entries[i])
+			}
+
+			var popped []kvEntry
+			for h.Len() > 0 {
+				popped = append(popped, *heap.Pop(h).(*kvEntry))
+			}
+
+			assert.Equal(t, []kvEntry{
+				{key: "a", sstID: 3, fileID: 0},
+				{key: "a", sstID: 2, fileID: 2},
+				{key: "a", sstID: 2, fileID: 1},
+				{key: "b", sstID: 1, fileID: 0},
+				{key: "c", sstID: 4, fileID: 1},
+			}, popped)
+			return
+		}
+
+		for i := range n {
+			permute(n - 1)
+			if n%2 == 0 {
+				entries[i], entries[n-1] = entries[n-1], entries[i]
+			} else {
+				entries[0], entries[n-1] = entries[n-1], entries[0]
+			}
+		}
+	}
+	permute(len(entries))
+}
+
+func TestCompactMerge(t *testing.T) {
+	type write struct {
+		key     string
+		value   string
+		deleted bool
+	}
+
+	tests := []struct {
+		name string
+		// inputs are flushed in order, the last is the newest
+		inputs   [][]write
+		expected []SSTEntry
+	}{
+		{
+			name:     "no inputs with entries",
+			inputs:   [][]write{{}, {}},
+			expected: nil,
+		},
+		{
+			name:     "empty inputs",
+			inputs:   [][]write{{}, {{key: "a", value: "1"}}, {}},
+			expected: []SSTEntry{{Key: "a", Value: "1"}},
+		},
+		{
+			name: "duplicate keys",
+			inputs: [][]write{
+				{{key: "a", value: "1"}, {key: "b", value: "1"}},
+				{{key: "a", value: "2"}, {key: "c", value: "2"}},
+				{{key: "a", value: "3"}, {key: "b", value: "3"}},
+			},
+			expected: []SSTEntry{{Key: "a", Value: "3"}, {Key: "b", Value: "3"}, {Key: "c", Value: "2"}},
+		},
+		{
+			name: "tombstones",
+			inputs: [][]write{
+				{{key: "a", value: "1"}, {key: "b", value: "1"}},
+				{{key: "a", deleted: true}},
+				{{key: "b", deleted: true}, {key: "c", deleted: true}},
+				{{key: "b", value: "4"}},
+			},
+			expected: []SSTEntry{{Key: "a", IsDeleted: true}, {Key: "b", Value: "4"}, {Key: "c", IsDeleted: true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			sstManager, err := NewSSTManager(logger, t.TempDir(), OPEN_FAST, nil)
+			require.NoError(t, err)
+
+			for _, input := range tt.inputs {
+				memtable := NewMemtable(BytewiseComparator)
+				for _, w := range input {
+					memtable.Set(w.key, w.value, w.deleted)
+				}
+				require.NoError(t, sstManager.FlushSST(memtable))
+			}
+
+			ssts := sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0)
+			require.Len(t, ssts, len(tt.inputs))
+
+			reversed := slices.Clone(ssts)
+			slices.Reverse(reversed)
+
+			// the newest entry wins whatever the order of the inputs
+			for _, order := range [][]*SST{ssts, reversed} {
+				compactor := NewCompactor(logger, 0, sstManager)
+				out, err := compactor.compact(context.Background(), order, 1, false)
+				require.NoError(t, err)
+
+				var entries []SSTEntry
+				for _, sst := range out {
+					entries = append(entries, readSST(t, sst)...)
+				}
+				assert.Equal(t, tt.expected, entries)
+			}
+		})
+	}
+}
+
+func readSST(t *testing.T, sst *SST) []SSTEntry {
+	it, err := sst.Iterate()
+	require.NoError(t, err)
+	defer it.Close()
+
+	var entries []SSTEntry
+	for ; it.Valid(); it.Next() {
+		entries = append(entries, *it.Entry())
+	}
+	require.NoError(t, it.Err())
+
+	return entries
+}
+
+func TestNewSSTCreatesLevelOnce(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	sstManager, err := NewSSTManager(logger, t.TempDir(), OPEN_FAST, nil)
+	require.NoError(t, err)
+
+	// parallel subcompactions create the SSTs of a new level together
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sstManager.NewSST(2, SST_COMPACTING)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, []int{2}, sstManager.Levels())
+
+	ssts := sstManager.ListSST(2, []SSTState{SST_COMPACTING}, 0)
+	require.Len(t, ssts, 8)
+	for i, sst := range ssts {
+		assert.Equal(t, uint64(i+1), sst.ID)
+	}
+}
+
+func TestReadsSeeLevelsCreatedMeanwhile(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	sstManager, err := NewSSTManager(logger, t.TempDir(), OPEN_FAST, nil)
+	require.NoError(t, err)
+
+	memtable := NewMemtable(BytewiseComparator)
+	memtable.Set("a", "1", false)
+	require.NoError(t, sstManager.FlushSST(memtable))
+
+	// a compaction moves the SST to a new level while it's read
+	var levels []int
+	require.NoError(t, sstManager.eachSST(func(sst *SST) (bool, error) {
+		levels = append(levels, sst.Level)
+		if sst.Level == 0 {
+			sstManager.NewSST(3, SST_FLUSHED)
+		}
+
+		return true, nil
+	}))
+
+	assert.Equal(t, []int{0, 3}, levels)
+}
+
+func TestStartSparseLevels(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+
+	sstManager, err := NewSSTManager(logger, dir, OPEN_FAST, nil)
+	require.NoError(t, err)
+
+	memtable := NewMemtable(BytewiseComparator)
+	memtable.Set("a", "1", false)
+	require.NoError(t, sstManager.FlushSST(memtable))
+
+	compactor := NewCompactor(logger, 0, sstManager)
+	require.True(t, compactor.run(ctx, sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0), 3, false))
+	sstManager.clean()
+
+	reopened, err := NewSSTManager(logger, dir, OPEN_FAST, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []int{3}, reopened.Levels())
+	assert.Nil(t, reopened.ListSST(1, []SSTState{SST_FLUSHED}, 0))
+
+	manager := NewCompactorManager(logger, reopened)
+	manager.Start(ctx)
+
+	manager.mu.Lock()
+	var levels []int
+	for _, c := range manager.compactors {
+		levels = append(levels, c.Level)
+	}
+	manager.mu.Unlock()
+	assert.Equal(t, []int{3}, levels)
+
+	manager.Stop()
+}
+
+func TestPauseCompactions(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	sstManager, err := NewSSTManager(logger, t.TempDir(), OPEN_FAST, nil)
+	require.NoError(t, err)
+
+	manager := NewCompactorManager(logger, sstManager)
+	manager.Start(context.Background())
+	defer manager.Stop()
+
+	manager.Pause()
+	assert.True(t, manager.Paused())
+
+	for i := range MAX_SST_PER_LEVEL {
+		memtable := NewMemtable(BytewiseComparator)
+		memtable.Set("a", string(rune('0'+i)), false)
+		require.NoError(t, sstManager.FlushSST(memtable))
+	}
+
+	compacted := func() bool {
+		return len(sstManager.ListSST(1, []SSTState{SST_FLUSHED}, 0)) == 1
+	}
+	assert.Never(t, compacted, 100*time.Millisecond, 10*time.Millisecond)
+
+	// the level filled up while paused is compacted on resume
+	manager.Resume()
+	assert.False(t, manager.Paused())
+	assert.Eventually(t, compacted, 5*time.Second, 10*time.Millisecond)
+}
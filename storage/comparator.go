@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var ErrComparatorMismatch error = errors.New("sst was written with another comparator")
+
+// Comparator orders the keys of a store. Compare returns a negative
+// number when a sorts before b, a positive one when it sorts after b,
+// and zero when a and b are the same key.
+// The name is recorded in every SST, a store refuses SSTs
+// written with another comparator.
+type Comparator interface {
+	Name() string
+	Compare(a string, b string) int
+}
+
+var (
+	// BytewiseComparator orders keys by their bytes.
+	BytewiseComparator Comparator = bytewise{}
+
+	// CaseInsensitiveComparator orders keys ignoring case,
+	// keys only differing in case are the same key.
+	CaseInsensitiveComparator Comparator = caseInsensitive{}
+
+	// NumericComparator orders runs of digits by their value,
+	// so key2 sorts before key10.
+	NumericComparator Comparator = numeric{}
+)
+
+// ParseComparator returns the built-in comparator called name,
+// an empty name is the bytewise comparator.
+func ParseComparator(name string) (Comparator, error) {
+	switch name {
+	case "", BytewiseComparator.Name():
+		return BytewiseComparator, nil
+	case CaseInsensitiveComparator.Name():
+		return CaseInsensitiveComparator, nil
+	case NumericComparator.Name():
+		return NumericComparator, nil
+	default:
+		return nil, fmt.Errorf("unknown comparator %q", name)
+	}
+}
+
+type bytewise struct{}
+
+func (bytewise) Name() string {
+	return "bytewise"
+}
+
+func (bytewise) Compare(a string, b string) int {
+	return strings.Compare(a, b)
+}
+
+type caseInsensitive struct{}
+
+func (caseInsensitive) Name() string {
+	return "case-insensitive"
+}
+
+func (caseInsensitive) Compare(a string, b string) int {
+	for a != "" && b != "" {
+		ra, na := utf8.DecodeRuneInString(a)
+		rb, nb := utf8.DecodeRuneInString(b)
+
+		if la, lb := unicode.ToLower(ra), unicode.ToLower(rb); la != lb {
+			if la < lb {
+				return -1
+			}
+
+			return 1
+		}
+
+		a, b = a[na:], b[nb:]
+	}
+
+	return len(a) - len(b)
+}
+
+type numeric struct{}
+
+func (numeric) Name() string {
+	return "numeric"
+}
+
+func (numeric) Compare(a string, b string) int {
+	x, y := a, b
+	for x != "" && y != "" {
+		if isDigit(x[0]) && isDigit(y[0]) {
+			dx, dy := digits(x), digits(y)
+
+			// leading zeros don't change the value
+			vx, vy := strings.TrimLeft(dx, "0"), strings.TrimLeft(dy, "0")
+			if len(vx) != len(vy) {
+				return len(vx) - len(vy)
+			}
+
+			if c := strings.Compare(vx, vy); c != 0 {
+				return c
+			}
+
+			x, y = x[len(dx):], y[len(dy):]
+			continue
+		}
+
+		if x[0] != y[0] {
+			return int(x[0]) - int(y[0])
+		}
+
+		x, y = x[1:], y[1:]
+	}
+
+	if len(x) != len(y) {
+		return len(x) - len(y)
+	}
+
+	// equal values written differently, like 7 and 07, are distinct keys
+	return strings.Compare(a, b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// digits returns the run of digits s starts with.
+func digits(s string) string {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+
+	return s[:i]
+}
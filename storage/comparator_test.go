@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComparators(t *testing.T) {
+	keys := []string{"key10", "Key2", "key1", "key02", "abc"}
+
+	sorted := func(cmp Comparator) []string {
+		res := slices.Clone(keys)
+		slices.SortFunc(res, cmp.Compare)
+		return res
+	}
+
+	assert.Equal(t, []string{"Key2", "abc", "key02", "key1", "key10"}, sorted(BytewiseComparator))
+	assert.Equal(t, []string{"abc", "key02", "key1", "key10", "Key2"}, sorted(CaseInsensitiveComparator))
+	assert.Equal(t, []string{"Key2", "abc", "key1", "key02", "key10"}, sorted(NumericComparator))
+
+	assert.Zero(t, CaseInsensitiveComparator.Compare("KEY", "key"))
+	assert.NotZero(t, NumericComparator.Compare("key02", "key2"))
+}
+
+func TestComparatorAcrossCompaction(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+
+	sstManager, err := NewSSTManager(logger, dir, OPEN_FAST, NumericComparator)
+	assert.NoError(t, err)
+
+	for i := range MAX_SST_PER_LEVEL {
+		memtable := NewMemtable(NumericComparator)
+		memtable.Set("k10", "old", false)
+		memtable.Set("k9", string(rune('0'+i)), false)
+		assert.NoError(t, sstManager.FlushSST(memtable))
+	}
+
+	compactor := NewCompactor(logger, 0, sstManager)
+	assert.True(t, compactor.compactOnce(context.Background()))
+
+	out := sstManager.ListSST(1, []SSTState{SST_FLUSHED}, 0)
+	assert.Len(t, out, 1)
+	assert.NoError(t, verifySST(out[0].Path(), NumericComparator))
+
+	entry, err := out[0].FindKey(context.Background(), "k9")
+	assert.NoError(t, err)
+	assert.Equal(t, string(rune('0'+MAX_SST_PER_LEVEL-1)), entry.Value)
+
+	// SSTs record their order, another comparator can't load them
+	_, err = NewSSTManager(logger, dir, OPEN_FAST, nil)
+	assert.ErrorIs(t, err, ErrComparatorMismatch)
+}
+
+func TestScanAfter(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	store, err := Open(ctx, logger, t.TempDir(), OPEN_FAST, nil, NumericComparator, nil)
+	assert.NoError(t, err)
+	defer store.Close(context.Background())
+
+	for _, key := range []string{"key02", "key2", "key3"} {
+		assert.NoError(t, store.Set(key, key))
+	}
+
+	scan := func(start string, fn func(func(*KVData) bool) func(*KVData) bool) []string {
+		var keys []string
+		assert.NoError(t, store.Scan(ctx, start, "", fn(func(data *KVData) bool {
+			keys = append(keys, data.Key)
+			return true
+		})))
+
+		return keys
+	}
+
+	after := func(fn func(*KVData) bool) func(*KVData) bool {
+		return After(NumericComparator, "key02", fn)
+	}
+	assert.Equal(t, []string{"key2", "key3"}, scan("key02", after))
+
+	// key2 sorts after key02 but before key02 followed by anything
+	identity := func(fn func(*KVData) bool) func(*KVData) bool { return fn }
+	assert.Equal(t, []string{"key3"}, scan("key02\x00", identity))
+}
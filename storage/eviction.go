@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EvictionInterval is the longest a store in cache mode waits between
+// checks of its size, writes going over the size wake it right away.
+var EvictionInterval = time.Minute
+
+// accessIndex tracks the live keys of a store in cache mode, from the
+// most to the least recently used, and the size of their entries.
+type accessIndex struct {
+	mu      sync.Mutex
+	order   *list.List
+	keys    map[string]*list.Element
+	size    int64
+	maxSize int64
+
+	// over is signaled when size goes over maxSize.
+	over chan struct{}
+}
+
+type accessEntry struct {
+	key  string
+	size int64
+}
+
+func newAccessIndex(maxSize int64) *accessIndex {
+	return &accessIndex{
+		order:   list.New(),
+		keys:    make(map[string]*list.Element),
+		maxSize: maxSize,
+		over:    make(chan struct{}, 1),
+	}
+}
+
+func cachedSize(key string, value string) int64 {
+	return int64(len(key) + len(value))
+}
+
+// put records a write of key with an entry of size bytes.
+func (a *accessIndex) put(key string, size int64) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if e, ok := a.keys[key]; ok {
+		entry := e.Value.(*accessEntry)
+		a.size += size - entry.size
+		entry.size = size
+		a.order.MoveToFront(e)
+	} else {
+		a.keys[key] = a.order.PushFront(&accessEntry{key: key, size: size})
+		a.size += size
+	}
+
+	if a.size > a.maxSize {
+		select {
+		case a.over <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// touch records a read of key.
+func (a *accessIndex) touch(key string) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if e, ok := a.keys[key]; ok {
+		a.order.MoveToFront(e)
+	}
+}
+
+// remove forgets key, deleted or expired.
+func (a *accessIndex) remove(key string) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if e, ok := a.keys[key]; ok {
+		a.size -= e.Value.(*accessEntry).size
+		a.order.Remove(e)
+		delete(a.keys, key)
+	}
+}
+
+// oldest returns the least recently used key while the size is over maxSize.
+func (a *accessIndex) oldest() (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.size <= a.maxSize || a.order.Len() == 0 {
+		return "", false
+	}
+
+	return a.order.Back().Value.(*accessEntry).key, true
+}
+
+// EnableEviction turns the store into a cache holding at most maxSize
+// bytes of live keys and values, least recently used keys are deleted
+// by StartEviction to make room. It must be called before the store
+// serves, the access index is built from the stored keys. Recency
+// isn't persisted, after a restart keys not used since are evicted
+// in key order.
+// Keys are indexed by their bytes, it requires the bytewise comparator.
+func (s *Store) EnableEviction(maxSize int64) error {
+	if maxSize <= 0 {
+		return fmt.Errorf("invalid cache size %d, it must be positive", maxSize)
+	}
+
+	if cmp := s.Backend.sstManager.cmp; cmp.Name() != BytewiseComparator.Name() {
+		return fmt.Errorf("cache mode requires the %s comparator, the store uses %s", BytewiseComparator.Name(), cmp.Name())
+	}
+
+	access := newAccessIndex(maxSize)
+	err := s.Scan(context.Background(), "", "", func(data *KVData) bool {
+		access.put(data.Key, cachedSize(data.Key, data.Value))
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	s.access = access
+	return nil
+}
+
+// StartEviction deletes the least recently used keys of a store in cache
+// mode whenever it grows over its size, until ctx is done. Evictions are
+// deletes of the store, logged through its sequencer if it has one,
+// disk space is reclaimed by compaction.
+func (s *Store) StartEviction(ctx context.Context) {
+	if s.access == nil {
+		return
+	}
+
+	runAdaptive(ctx, EvictionInterval, EvictionInterval, s.access.over, func() bool {
+		n, err := s.evict()
+		if err != nil {
+			s.logger.Error("error evicting keys", "err", err)
+		}
+
+		if n > 0 {
+			s.logger.Debug("evicted keys", "keys", n)
+		}
+
+		return n > 0
+	})
+}
+
+// evict deletes the least recently used keys until the store
+// fits its size and returns how many were deleted.
+func (s *Store) evict() (int, error) {
+	n := 0
+	for {
+		key, ok := s.access.oldest()
+		if !ok {
+			return n, nil
+		}
+
+		if err := s.deleteExpired(key); err != nil {
+			return n, err
+		}
+
+		n++
+	}
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEviction(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+
+	store, err := Open(context.Background(), logger, dir, OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+
+	// entries of 4 bytes, 3 of them fit
+	require.NoError(t, store.Set("k1", "v1"))
+	require.NoError(t, store.Set("k2", "v2"))
+	require.NoError(t, store.EnableEviction(12))
+
+	require.NoError(t, store.Set("k3", "v3"))
+	_, err = store.Get(context.Background(), "k1")
+	require.NoError(t, err)
+	require.NoError(t, store.Write([]BatchOp{{Type: BATCH_PUT, Key: "k4", Value: "v4"}}))
+
+	n, err := store.evict()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// k2 was the least recently used
+	_, err = store.Get(context.Background(), "k2")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	for _, key := range []string{"k1", "k3", "k4"} {
+		_, err := store.Get(context.Background(), key)
+		assert.NoError(t, err, key)
+	}
+
+	// a larger value evicts more keys, deletes make room
+	require.NoError(t, store.Delete("k4"))
+	require.NoError(t, store.Set("k3", "v333333"))
+	n, err = store.evict()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = store.Get(context.Background(), "k1")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.EqualValues(t, 9, store.access.size)
+
+	_, err = store.Close(context.Background())
+	require.NoError(t, err)
+
+	// the index is rebuilt from the stored keys on reopen
+	store, err = Open(context.Background(), logger, dir, OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	require.NoError(t, store.EnableEviction(12))
+	assert.EqualValues(t, 9, store.access.size)
+}
+
+func TestEvictionComparator(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store, err := Open(context.Background(), logger, t.TempDir(), OPEN_FAST, nil, NumericComparator, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	assert.Error(t, store.EnableEviction(1<<20))
+	assert.Error(t, store.EnableEviction(0))
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package storage
+
+import (
+	"errors"
+	"os"
+)
+
+// Free space is read with the statfs of Linux,
+// other systems never refuse writes for it.
+func freeSpace(string) (int64, error) {
+	return 0, errors.New("free space is not supported on this system")
+}
+
+// Link counts are read from the stat of Linux, other
+// systems copy the SSTs they move, see linkSST.
+func linkCount(os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"distrikv/events"
+	"os"
+	"slices"
+)
+
+// GarbageRatio is the share of dead bytes at which an SST is compacted
+// into the next level along with the older SSTs of its level, however
+// few they are. Zero disables the trigger and the tracking.
+//
+// An entry is dead once a newer SST holds its key: scans find them
+// as they merge the SSTs and compactions look the keys they write up
+// in the older SSTs. Dead entries are only known until a restart.
+var GarbageRatio = 0.5
+
+// trackGarbage reports whether dead entries are tracked. Entries
+// shadowed by newer ones are still read as previous versions
+// while versions are retained.
+func trackGarbage() bool {
+	return GarbageRatio > 0 && !versionsRetained()
+}
+
+// markDead records the entry of key, of size bytes, as shadowed by a
+// newer SST and returns the dead bytes of the SST before and after.
+func (s *SST) markDead(key string, size int64) (int64, int64) {
+	s.deadMu.Lock()
+	defer s.deadMu.Unlock()
+
+	before := s.deadBytes.Load()
+	if _, ok := s.dead[key]; ok {
+		return before, before
+	}
+
+	if s.dead == nil {
+		s.dead = make(map[string]struct{})
+	}
+	s.dead[key] = struct{}{}
+
+	return before, s.deadBytes.Add(size)
+}
+
+// isDead reports whether the entry of key is shadowed by a newer SST,
+// compactions of the SST drop it.
+func (s *SST) isDead(key string) bool {
+	s.deadMu.Lock()
+	defer s.deadMu.Unlock()
+
+	_, ok := s.dead[key]
+	return ok
+}
+
+// fileSize returns the size of the SST file, zero if it can't be read.
+func (s *SST) fileSize() int64 {
+	s.sizeOnce.Do(func() {
+		if info, err := os.Stat(s.Path()); err == nil {
+			s.size = info.Size()
+		}
+	})
+
+	return s.size
+}
+
+// garbageRatio returns the share of the SST file known to be dead.
+func (s *SST) garbageRatio() float64 {
+	size := s.fileSize()
+	if size == 0 {
+		return 0
+	}
+
+	return float64(s.deadBytes.Load()) / float64(size)
+}
+
+// shadowed records the entry of key in sst as dead. The compactor of
+// the level of sst is woken up once its dead bytes reach GarbageRatio.
+func (s *SSTManager) shadowed(sst *SST, key string, size int64) {
+	before, after := sst.markDead(key, size)
+
+	threshold := int64(GarbageRatio * float64(sst.fileSize()))
+	if before >= threshold || after < threshold {
+		return
+	}
+
+	s.logger.Debug("SST reached the garbage ratio", "file", sst.FileName, "level", sst.Level, "dead_bytes", after)
+
+	s.events.Publish(events.TOPIC_GARBAGE, events.GarbageEvent{
+		Dir:       s.dir,
+		File:      sst.FileName,
+		Level:     sst.Level,
+		DeadBytes: after,
+	})
+}
+
+// garbageInputs returns how many of the oldest flushed SSTs of a level,
+// candidates, are compacted for the newest of them that reached
+// GarbageRatio, zero if none did. The older ones are compacted along,
+// the next level only holds data older than the SSTs left in the level.
+func garbageInputs(candidates []*SST) int {
+	if !trackGarbage() {
+		return 0
+	}
+
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if candidates[i].garbageRatio() >= GarbageRatio {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+// olderSSTs returns the readable SSTs holding data older than
+// the inputs of a compaction, they are released by the caller.
+func (s *SSTManager) olderSSTs(inputs []*SST) []*SST {
+	ssts := s.sstsForRead()
+
+	last := -1
+	for i, sst := range ssts {
+		if slices.Contains(inputs, sst) {
+			last = i
+		}
+	}
+
+	if last < 0 {
+		releaseSSTs(ssts)
+		return nil
+	}
+
+	releaseSSTs(ssts[:last+1])
+	return ssts[last+1:]
+}
+
+// shadowedBy returns the hook of a scan recording the entries of older
+// SSTs its merge skips, nil when garbage isn't tracked. memtables is
+// the number of memtable sources, followed by ssts.
+//
+// Entries skipped for a memtable are left alone, the memtable may have
+// been flushed to one of ssts meanwhile, as are those skipped for the
+// input of a compaction whose output is already read.
+func (l *LSM) shadowedBy(memtables int, ssts []*SST) func(source int, by int, entry *SSTEntry) {
+	if !trackGarbage() {
+		return nil
+	}
+
+	return func(source int, by int, entry *SSTEntry) {
+		if by < memtables || ssts[by-memtables].replaced.Load() {
+			return
+		}
+
+		l.sstManager.shadowed(ssts[source-memtables], entry.Key, int64(entry.size())+1)
+	}
+}
+
+// markOlder records the entries of key in older, the SSTs older than a
+// compaction writing key, as dead. It stops looking up once ctx is done.
+func (c *Compactor) markOlder(ctx context.Context, older []*SST, key string) {
+	for _, sst := range older {
+		ie, err := sst.lookup(ctx, key)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err == nil && ie != nil {
+			c.sstManager.shadowed(sst, key, ie.size())
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGarbageFoundByScans(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	defer func() { GarbageRatio = 0.5 }()
+	GarbageRatio = 0.4
+
+	store, err := Open(ctx, logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	value := strings.Repeat("v", 100)
+	for _, v := range []string{"1", "2"} {
+		require.NoError(t, store.Set("a", value+v))
+		require.NoError(t, store.Set("b", value+v))
+		require.NoError(t, store.Set("c", value+v))
+		_, err := store.Flush(ctx)
+		require.NoError(t, err)
+	}
+
+	// the memtable doesn't make the SST entries of c dead
+	require.NoError(t, store.Set("c", "3"))
+
+	var keys []string
+	require.NoError(t, store.Scan(ctx, "", "", func(data *KVData) bool {
+		keys = append(keys, data.Key)
+		return true
+	}))
+	assert.Equal(t, []string{"a", "b", "c"}, keys)
+
+	// the older SST is compacted alone, without its dead entries
+	sstManager := store.Backend.sstManager
+	require.Eventually(t, func() bool {
+		return slices.Contains(sstManager.Levels(), 1) && len(sstManager.ListSST(1, []SSTState{SST_FLUSHED}, 0)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	flushed := sstManager.ListSST(1, []SSTState{SST_FLUSHED}, 0)
+	entry, err := flushed[0].FindKey(ctx, "a")
+	require.NoError(t, err)
+	assert.Nil(t, entry)
+
+	entry, err = flushed[0].FindKey(ctx, "c")
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.Equal(t, value+"1", entry.Value)
+
+	res, err := store.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, value+"2", res.Value)
+
+	newest := sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0)
+	require.Len(t, newest, 1)
+	assert.Zero(t, newest[0].deadBytes.Load())
+}
+
+func TestGarbageFoundByCompactions(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	sstManager, err := NewSSTManager(logger, t.TempDir(), OPEN_FAST, nil)
+	require.NoError(t, err)
+
+	flush := func(value string) {
+		memtable := NewMemtable(BytewiseComparator)
+		memtable.Set("a", value, false)
+		memtable.Set("b", value, false)
+		require.NoError(t, sstManager.FlushSST(memtable))
+	}
+
+	flush("0")
+	flush("1")
+	require.NoError(t, sstManager.CompactAll(ctx, func(int, int) {}))
+	oldest := sstManager.ListSST(1, []SSTState{SST_FLUSHED}, 0)
+	require.Len(t, oldest, 1)
+
+	// a only, b is still live in the oldest SST
+	memtable := NewMemtable(BytewiseComparator)
+	memtable.Set("a", "2", false)
+	require.NoError(t, sstManager.FlushSST(memtable))
+
+	compactor := NewCompactor(logger, 0, sstManager)
+	require.True(t, compactor.run(ctx, sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0), 1, false))
+
+	assert.True(t, oldest[0].isDead("a"))
+	assert.False(t, oldest[0].isDead("b"))
+	assert.Positive(t, oldest[0].deadBytes.Load())
+
+	levels := sstManager.levelStats()
+	assert.Equal(t, oldest[0].deadBytes.Load(), levels[1].DeadBytes)
+
+	// the entry of a is over a tenth of the oldest SST
+	defer func() { GarbageRatio = 0.5 }()
+	GarbageRatio = 0.1
+	compactor = NewCompactor(logger, 1, sstManager)
+	require.True(t, compactor.compactOnce(ctx))
+
+	flushed := sstManager.ListSST(2, []SSTState{SST_FLUSHED}, 0)
+	require.Len(t, flushed, 1)
+
+	entry, err := flushed[0].FindKey(ctx, "a")
+	require.NoError(t, err)
+	assert.Nil(t, entry)
+
+	entry, err = flushed[0].FindKey(ctx, "b")
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.Equal(t, "1", entry.Value)
+
+	data, err := sstManager.QueryKey(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "2", data.Value)
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrDiskWrite wraps errors writing or syncing files of the store.
+var ErrDiskWrite error = errors.New("disk write failed")
+
+// DiskErrorThreshold is the number of consecutive failed disk
+// writes after which a store turns read-only. Writes that can't
+// reach the disk must not be acknowledged any longer.
+var DiskErrorThreshold = 3
+
+// ErrDiskFull is returned by writes while the volume of the
+// store has less than MinFreeSpace bytes available.
+var ErrDiskFull error = errors.New("disk is full")
+
+// MinFreeSpace is the number of bytes the volume of a store keeps
+// available: below it, writes are refused until space is freed.
+// Reads, deletes, flushes and compactions go on, compactions
+// reclaim the space of overwritten and deleted keys. Zero, the
+// default, never refuses writes. The available space is checked
+// every FreeSpaceCheckInterval.
+var (
+	MinFreeSpace           int64
+	FreeSpaceCheckInterval = 10 * time.Second
+)
+
+// Health is the disk health of a store.
+type Health struct {
+	Healthy     bool      `json:"healthy"`
+	ReadOnly    bool      `json:"read_only"`
+	DiskErrors  uint64    `json:"disk_errors"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitzero"`
+
+	// DiskFull is set while writes are refused for the available
+	// space of the volume, FreeBytes, known once it was checked.
+	DiskFull  bool  `json:"disk_full"`
+	FreeBytes int64 `json:"free_bytes,omitempty"`
+}
+
+// Writable reports whether the store accepts writes.
+func (h Health) Writable() bool {
+	return !h.ReadOnly && !h.DiskFull
+}
+
+// diskHealth tracks the disk write failures of a store.
+// A store turned read-only stays so until it is reopened,
+// the state of data after a failed fsync is unknown.
+type diskHealth struct {
+	logger *slog.Logger
+
+	mu          sync.Mutex
+	consecutive int
+	total       uint64
+	lastErr     error
+	lastErrAt   time.Time
+	readOnly    bool
+
+	diskFull  bool
+	freeBytes int64
+}
+
+func diskWriteError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %w", ErrDiskWrite, err)
+}
+
+// record tracks the result of a disk write.
+// Errors other than ErrDiskWrite are ignored.
+func (h *diskHealth) record(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err == nil {
+		h.consecutive = 0
+		return
+	}
+
+	if !errors.Is(err, ErrDiskWrite) {
+		return
+	}
+
+	h.consecutive++
+	h.total++
+	h.lastErr = err
+	h.lastErrAt = time.Now()
+
+	if h.consecutive >= DiskErrorThreshold && !h.readOnly {
+		h.readOnly = true
+		h.logger.Error("store turned read-only after repeated disk errors", "errors", h.consecutive, "err", err)
+	}
+}
+
+func (h *diskHealth) isReadOnly() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.readOnly
+}
+
+// writable returns the error of writes to the store, nil if it accepts them.
+func (h *diskHealth) writable() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch {
+	case h.readOnly:
+		return ErrReadOnly
+	case h.diskFull:
+		return ErrDiskFull
+	}
+
+	return nil
+}
+
+// checkSpace reads the space available on the volume of dir
+// and refuses writes while it's below MinFreeSpace.
+func (h *diskHealth) checkSpace(dir string) error {
+	free, err := freeSpace(dir)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.freeBytes = free
+	full := free < MinFreeSpace
+
+	switch {
+	case full && !h.diskFull:
+		h.logger.Error("refusing writes, the disk is full", "free_bytes", free, "min_free_bytes", MinFreeSpace)
+	case !full && h.diskFull:
+		h.logger.Info("accepting writes again, disk space was freed", "free_bytes", free)
+	}
+
+	h.diskFull = full
+	return nil
+}
+
+// monitorSpace checks the space available on the volume of dir every
+// FreeSpaceCheckInterval until ctx is done.
+func (h *diskHealth) monitorSpace(ctx context.Context, dir string) {
+	ticker := time.NewTicker(FreeSpaceCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := h.checkSpace(dir); err != nil {
+			h.logger.Warn("error checking free disk space", "err", err)
+		}
+	}
+}
+
+func (h *diskHealth) status() Health {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	health := Health{
+		Healthy:     !h.readOnly,
+		ReadOnly:    h.readOnly,
+		DiskErrors:  h.total,
+		LastErrorAt: h.lastErrAt,
+		DiskFull:    h.diskFull,
+		FreeBytes:   h.freeBytes,
+	}
+
+	if h.lastErr != nil {
+		health.LastError = h.lastErr.Error()
+	}
+
+	return health
+}
+
+// syncDir syncs dir, so files created in it survive a crash.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
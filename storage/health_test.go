@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlushFailuresTurnReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	sstManager, err := NewSSTManager(logger, dir, OPEN_FAST, nil)
+	assert.NoError(t, err)
+
+	memtable := NewMemtable(BytewiseComparator)
+	memtable.Set("a", "1", false)
+
+	// flushes fail once the directory is gone
+	assert.NoError(t, os.RemoveAll(dir))
+
+	for range DiskErrorThreshold {
+		assert.ErrorIs(t, sstManager.FlushSST(memtable), ErrDiskWrite)
+	}
+
+	health := sstManager.health.status()
+	assert.True(t, health.ReadOnly)
+	assert.False(t, health.Healthy)
+	assert.Equal(t, uint64(DiskErrorThreshold), health.DiskErrors)
+
+	// partial SSTs are removed
+	assert.Empty(t, sstManager.ListSST(0, []SSTState{SST_FLUSHING}, MAX_SST_PER_LEVEL))
+
+	lsm := &LSM{sstManager: sstManager, Memtable: NewMemtable(BytewiseComparator)}
+	assert.ErrorIs(t, lsm.Set("a", "2"), ErrReadOnly)
+}
+
+func TestDiskFullRefusesWrites(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	if _, err := freeSpace(dir); err != nil {
+		t.Skip(err)
+	}
+
+	defer func() { MinFreeSpace = 0 }()
+	MinFreeSpace = 1 << 62
+
+	store, err := Open(context.Background(), logger, dir, OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	health := store.Health()
+	assert.True(t, health.DiskFull)
+	assert.True(t, health.Healthy)
+	assert.False(t, health.Writable())
+	assert.Positive(t, health.FreeBytes)
+
+	assert.ErrorIs(t, store.Set("a", "1"), ErrDiskFull)
+	assert.ErrorIs(t, store.Write([]BatchOp{{Type: BATCH_PUT, Key: "a", Value: "1"}}), ErrDiskFull)
+	_, err = store.Incr("n", 1)
+	assert.ErrorIs(t, err, ErrDiskFull)
+
+	// deletes and reads go on
+	assert.NoError(t, store.Delete("a"))
+	assert.NoError(t, store.Write([]BatchOp{{Type: BATCH_DELETE, Key: "a"}}))
+	_, err = store.Get(context.Background(), "a")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// writes resume once space is available
+	MinFreeSpace = 1
+	require.NoError(t, store.Backend.sstManager.health.checkSpace(dir))
+	assert.True(t, store.Health().Writable())
+	assert.NoError(t, store.Set("a", "1"))
+}
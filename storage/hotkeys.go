@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// HotKeySampleRate is the fraction of SST reads
+// recorded in the hot key statistics.
+var HotKeySampleRate = 0.1
+
+const (
+	// maximum number of distinct keys tracked at once
+	hotKeysMaxTracked = 4096
+
+	// counts are halved on this interval, so the statistics
+	// follow the current workload instead of the whole history.
+	hotKeysDecayInterval = time.Minute
+)
+
+// keySampler keeps sampled read counts of keys served from SSTs.
+type keySampler struct {
+	mu        sync.Mutex
+	rate      float64
+	counts    map[string]uint64
+	lastDecay time.Time
+}
+
+func newKeySampler(rate float64) *keySampler {
+	return &keySampler{
+		rate:      rate,
+		counts:    make(map[string]uint64),
+		lastDecay: time.Now(),
+	}
+}
+
+func (s *keySampler) Record(key string) {
+	if rand.Float64() >= s.rate {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.lastDecay) >= hotKeysDecayInterval {
+		s.decay()
+	}
+
+	// new keys are dropped until decay frees some space
+	if _, ok := s.counts[key]; !ok && len(s.counts) >= hotKeysMaxTracked {
+		return
+	}
+
+	s.counts[key]++
+}
+
+// decay halves every count and forgets keys that dropped to zero.
+// Must be called with mu held.
+func (s *keySampler) decay() {
+	for key, count := range s.counts {
+		if count/2 == 0 {
+			delete(s.counts, key)
+			continue
+		}
+
+		s.counts[key] = count / 2
+	}
+
+	s.lastDecay = time.Now()
+}
+
+// Heat returns the sampled read count of the keys in [minKey, maxKey],
+// a range of keys ordered by cmp.
+func (s *keySampler) Heat(minKey string, maxKey string, cmp Comparator) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var heat uint64
+	for key, count := range s.counts {
+		if cmp.Compare(key, minKey) >= 0 && cmp.Compare(key, maxKey) <= 0 {
+			heat += count
+		}
+	}
+
+	return heat
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"sync"
+)
+
+var ErrNotInteger error = errors.New("value is not an integer or out of range")
+
+// keyLockStripes is the number of locks serializing the writes of a store
+// by key, so read-modify-write operations like Incr are atomic.
+const keyLockStripes = 64
+
+type keyLocks [keyLockStripes]sync.Mutex
+
+// lock returns the lock of key.
+func (l *keyLocks) lock(key string, cmp Comparator) *sync.Mutex {
+	return &l[l.stripe(key, cmp)]
+}
+
+// stripe returns the index of the lock of key. Keys are only spread
+// over the stripes with the bytewise comparator, other comparators
+// consider different strings the same key and share a single lock.
+func (l *keyLocks) stripe(key string, cmp Comparator) int {
+	if cmp.Name() != BytewiseComparator.Name() {
+		return 0
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	return int(h.Sum32() % keyLockStripes)
+}
+
+// lookup returns the entry of key, nil if it's missing or expired.
+func (s *Store) lookup(key string) (*KVData, error) {
+	current, err := s.Backend.Get(context.Background(), key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, nil
+	}
+
+	return current, err
+}
+
+// Incr adds delta to the integer value of key and returns the stored
+// entry, a missing key counts from 0. The expiry of the key is kept.
+func (s *Store) Incr(key string, delta int64) (*KVData, error) {
+	if s.closed.Load() {
+		return nil, ErrReadOnly
+	}
+
+	if err := checkSize(key, ""); err != nil {
+		return nil, err
+	}
+
+	mu := s.locks.lock(key, s.Backend.sstManager.cmp)
+	mu.Lock()
+	defer mu.Unlock()
+
+	var n int64
+	current, err := s.Backend.Get(context.Background(), key)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return nil, err
+	}
+
+	if err == nil {
+		n, err = strconv.ParseInt(current.Value, 10, 64)
+		if err != nil {
+			return nil, ErrNotInteger
+		}
+	} else {
+		current = &KVData{Key: key}
+	}
+
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return nil, ErrNotInteger
+	}
+
+	current.Value = strconv.FormatInt(n+delta, 10)
+	if err := s.Backend.setWithExpiry(key, current.Value, current.ExpiresAt); err != nil {
+		return nil, err
+	}
+
+	s.access.put(key, cachedSize(key, current.Value))
+	return current, nil
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIncr(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	store, err := Open(context.Background(), logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	assert.NoError(t, err)
+	defer store.Close(context.Background())
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.Incr("counter", 2)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	res, err := store.Incr("counter", -1)
+	assert.NoError(t, err)
+	assert.Equal(t, "99", res.Value)
+
+	assert.NoError(t, store.Set("text", "abc"))
+	_, err = store.Incr("text", 1)
+	assert.ErrorIs(t, err, ErrNotInteger)
+
+	assert.NoError(t, store.Set("max", "9223372036854775807"))
+	_, err = store.Incr("max", 1)
+	assert.ErrorIs(t, err, ErrNotInteger)
+
+	_, err = store.Incr("max", math.MinInt64)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// InlineValueSize is the largest value kept inline in the SST index,
+// lookups of inlined values don't read the SST file.
+var InlineValueSize = 64
+
+// indexEntry locates a key in an SST file.
+type indexEntry struct {
+	key       string
+	offset    int64
+	isDeleted bool
+	expiresAt time.Time
+	valueLen  int64
+
+	// value is only set when inline is true.
+	inline bool
+	value  string
+}
+
+// sstIndex holds an entry for every key of an SST in key order.
+type sstIndex []indexEntry
+
+// find returns the index entry of key, the index is ordered by cmp.
+func (idx sstIndex) find(key string, cmp Comparator) (*indexEntry, bool) {
+	i := sort.Search(len(idx), func(i int) bool {
+		return cmp.Compare(idx[i].key, key) >= 0
+	})
+
+	if i == len(idx) || cmp.Compare(idx[i].key, key) != 0 {
+		return nil, false
+	}
+
+	return &idx[i], true
+}
+
+// buildIndex reads the SST file once and indexes every entry.
+func buildIndex(s *SST) (sstIndex, error) {
+	it, err := s.Iterate()
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var idx sstIndex
+	var offset int64
+	for ; it.Valid(); it.Next() {
+		entry := it.Entry()
+
+		ie := indexEntry{
+			key:       entry.Key,
+			offset:    offset,
+			isDeleted: entry.IsDeleted,
+			expiresAt: entry.ExpiresAt,
+			valueLen:  int64(len(entry.Value)),
+		}
+
+		if len(entry.Value) <= InlineValueSize {
+			ie.inline = true
+			ie.value = entry.Value
+		}
+
+		idx = append(idx, ie)
+
+		// entries are followed by a newline
+		offset += int64(entry.size()) + 1
+	}
+
+	return idx, it.Err()
+}
+
+// size is the length of the entry in the file, versions left out.
+func (ie *indexEntry) size() int64 {
+	return int64(entrySize(ie.key, "", ie.expiresAt)) + ie.valueLen + 1
+}
+
+// entrySize is the encoded length of an entry, without the newline.
+func entrySize(key string, value string, expiresAt time.Time) int {
+	size := 4 + 4 + 4 + 1 + len(key) + len(value)
+	if !expiresAt.IsZero() {
+		size += 8
+	}
+
+	return size
+}
+
+// readEntryAt reads the entry stored at offset of the SST file.
+func readEntryAt(s *SST, offset int64) (*SSTEntry, error) {
+	f, err := os.Open(s.Path())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, 0); err != nil {
+		return nil, err
+	}
+
+	r := getReader(f)
+	defer putReader(r)
+
+	return readSSTEntry(r)
+}
+
+// openValue opens the SST file positioned at the value of ie
+// and returns it with a reader limited to the value.
+func openValue(s *SST, ie *indexEntry) (*os.File, io.Reader, error) {
+	f, err := os.Open(s.Path())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the value follows the total length, the key and its length
+	offset := ie.offset + 4 + 4 + int64(len(ie.key)) + 4
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return f, io.LimitReader(f, ie.valueLen), nil
+}
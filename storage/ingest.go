@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"distrikv/events"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrIngestInvalid is returned for SSTs that can't be ingested,
+// it wraps the reason, e.g. ErrSSTCorrupted.
+var ErrIngestInvalid error = errors.New("sst can't be ingested")
+
+// IngestResult describes an SST added by IngestSST.
+type IngestResult struct {
+	File    string `json:"file"`
+	Level   int    `json:"level"`
+	Entries int64  `json:"entries"`
+	MinKey  string `json:"min_key"`
+	MaxKey  string `json:"max_key"`
+}
+
+// ingestSource is an SST file checked by checkIngestSST.
+type ingestSource struct {
+	path    string
+	entries int64
+	minKey  string
+	maxKey  string
+
+	// size is the size of the entries, the footer starts there.
+	size int64
+}
+
+// IngestSST adds the SST file at path, written outside the store e.g. by
+// a BulkWriter, to the store. The file must be complete, its entries in the
+// order of the comparator of the store. It's copied into the store with a
+// new footer, path is left as it is.
+//
+// The memtables are flushed first, so the SST holds newer entries than
+// every write before IngestSST. It's placed on the deepest level no SST
+// above overlaps, as the newest SST of that level: lower levels and newer
+// SSTs are read first, so its entries shadow older versions of their keys.
+// The copy only appears once it's complete and synced, and is registered
+// while no compaction runs. Entries are not logged by the replication
+// changelog, standbys don't receive them.
+func (s *Store) IngestSST(ctx context.Context, path string) (*IngestResult, error) {
+	if s.closed.Load() {
+		return nil, ErrReadOnly
+	}
+
+	if err := s.Backend.sstManager.health.writable(); err != nil {
+		return nil, err
+	}
+
+	src, err := checkIngestSST(path, s.Backend.sstManager.cmp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrIngestInvalid, path, err)
+	}
+
+	if _, err := s.Backend.Flush(ctx); err != nil {
+		return nil, err
+	}
+
+	sst, err := s.Backend.sstManager.ingest(src)
+	s.Backend.sstManager.health.record(err)
+	if err != nil {
+		return nil, err
+	}
+
+	// entries cached before are shadowed by the SST
+	s.Backend.rowCache.reset()
+	s.Backend.missCache.reset()
+
+	return &IngestResult{
+		File:    sst.FileName,
+		Level:   sst.Level,
+		Entries: src.entries,
+		MinKey:  src.minKey,
+		MaxKey:  src.maxKey,
+	}, nil
+}
+
+// checkIngestSST reads the footer and every entry of the SST at path.
+func checkIngestSST(path string, cmp Comparator) (*ingestSource, error) {
+	sst, err := OpenSSTFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if sst.Comparator != cmp.Name() {
+		return nil, fmt.Errorf("%w: ordered by %s, the store by %s", ErrComparatorMismatch, sst.Comparator, cmp.Name())
+	}
+
+	if err := verifySST(path, cmp); err != nil {
+		return nil, err
+	}
+
+	src := &ingestSource{path: path}
+	err = sst.ReadEntries(func(offset int64, entry *SSTEntry) bool {
+		if src.entries == 0 {
+			src.minKey = entry.Key
+		}
+
+		src.maxKey = entry.Key
+		src.entries++
+		src.size = offset + int64(entry.size()) + 1
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if src.entries == 0 {
+		return nil, errors.New("the sst has no entries")
+	}
+
+	return src, nil
+}
+
+// ingest copies src into the store and registers it on the deepest
+// level no SST above overlaps, holding compactMu so no compaction
+// moves SSTs meanwhile.
+func (s *SSTManager) ingest(src *ingestSource) (*SST, error) {
+	start := time.Now()
+
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	level, err := s.ingestLevel(src.minKey, src.maxKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// the next id of the level makes it the newest SST of the level,
+	// it isn't read until it's flushed
+	sst := s.NewSST(level, SST_FLUSHING)
+	if err := copySST(src.path, src.size, sst, true); err != nil {
+		s.RemoveSST(level, []*SST{sst})
+		return nil, err
+	}
+
+	sst.setKeyRange(src.minKey, src.maxKey)
+	sst.entries.Store(src.entries)
+	if err := s.updateBatch(level, []*SST{sst}, SST_FLUSHED); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("ingested SST", "source", src.path, "file", sst.FileName, "level", level, "entries", src.entries, "duration", time.Since(start))
+
+	s.events.Publish(events.TOPIC_INGEST, events.IngestEvent{
+		Dir:      s.dir,
+		File:     sst.FileName,
+		Level:    level,
+		Duration: time.Since(start),
+	})
+
+	return sst, nil
+}
+
+// ingestLevel returns the deepest level such that no SST on the levels
+// above it overlaps [minKey, maxKey]. Compacted SSTs are covered by their
+// output and left out. The key range of an SST being flushed isn't known
+// until it's written, a level holding one counts as overlapping, so the
+// SST ingested next to it on level 0 is newer than the writes it holds.
+func (s *SSTManager) ingestLevel(minKey string, maxKey string) (int, error) {
+	levels := s.Levels()
+
+	level := 0
+	for _, l := range levels {
+		level = l
+
+		ssts := s.ListSST(l, []SSTState{SST_FLUSHING, SST_FLUSHED, SST_COMPACTING}, 0)
+		for _, sst := range ssts {
+			if sst.Status == SST_FLUSHING {
+				return l, nil
+			}
+
+			lo, hi, err := sst.KeyRange()
+			if err != nil {
+				return 0, fmt.Errorf("%s: %w", sst.FileName, err)
+			}
+
+			if s.cmp.Compare(minKey, hi) <= 0 && s.cmp.Compare(lo, maxKey) <= 0 {
+				return l, nil
+			}
+		}
+	}
+
+	return level, nil
+}
+
+// copySST copies the first size bytes of the file at path, its entries,
+// into the file of sst, followed by the footer of sst. The file only
+// appears once it's complete, synced first when sync is set.
+func copySST(path string, size int64, sst *SST, sync bool) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := sst.Path() + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0744)
+	if err != nil {
+		return diskWriteError(err)
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	writer := bufio.NewWriter(f)
+	sstWriter := newSSTWriter(writer)
+	if _, err := io.CopyN(sstWriter, in, size); err != nil {
+		return diskWriteError(err)
+	}
+
+	if err := writeSSTMetadata(sstWriter, sst.ID, sst.Level, sst.Timestamp, sst.cmp); err != nil {
+		return diskWriteError(err)
+	}
+
+	if err := writer.Flush(); err != nil {
+		return diskWriteError(err)
+	}
+
+	if sync {
+		if err := f.Sync(); err != nil {
+			return diskWriteError(err)
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return diskWriteError(err)
+	}
+
+	if err := os.Rename(tmp, sst.Path()); err != nil {
+		return diskWriteError(err)
+	}
+
+	if !sync {
+		return nil
+	}
+
+	return diskWriteError(syncDir(filepath.Dir(sst.Path())))
+}
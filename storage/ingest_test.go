@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIngestSST(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	store, err := Open(ctx, logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	// a and b end up on level 1
+	require.NoError(t, store.Set("a", "1"))
+	require.NoError(t, store.Set("b", "1"))
+	_, err = store.Flush(ctx)
+	require.NoError(t, err)
+	require.NoError(t, store.Set("c", "1"))
+	_, err = store.Flush(ctx)
+	require.NoError(t, err)
+	require.NoError(t, store.CompactAll(ctx, func(int, int) {}))
+
+	build := func(cmp Comparator, keys ...string) string {
+		dir := t.TempDir()
+		w, err := NewBulkWriter(dir, cmp)
+		require.NoError(t, err)
+		for _, key := range keys {
+			require.NoError(t, w.Put(key, "ingested", time.Time{}))
+		}
+		require.NoError(t, w.Close())
+
+		return filepath.Join(dir, w.Files[0])
+	}
+
+	get := func(key string) string {
+		var value string
+		require.NoError(t, store.Scan(context.Background(), key, key+"\x00", func(data *KVData) bool {
+			value = data.Value
+			return true
+		}))
+		return value
+	}
+
+	// nothing overlaps, the SST goes to the last level
+	res, err := store.IngestSST(ctx, build(nil, "x", "y"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Level)
+	assert.Equal(t, int64(2), res.Entries)
+	assert.Equal(t, "x", res.MinKey)
+	assert.Equal(t, "y", res.MaxKey)
+	assert.Equal(t, "ingested", get("x"))
+
+	// compacted SSTs of level 0 are covered by level 1
+	assert.Equal(t, "1", get("b"))
+	res, err = store.IngestSST(ctx, build(nil, "b"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Level)
+	assert.Equal(t, "ingested", get("b"))
+
+	// memtable entries are flushed first and shadowed
+	require.NoError(t, store.Set("c", "2"))
+	res, err = store.IngestSST(ctx, build(nil, "c"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Level)
+	assert.Equal(t, "ingested", get("c"))
+	assert.Equal(t, "1", get("a"))
+
+	// the range of an SST being flushed isn't known yet, nothing
+	// overlaps it but the SST stays on its level
+	require.NoError(t, store.CompactAll(ctx, func(int, int) {}))
+	flushing := store.Backend.sstManager.NewSST(0, SST_FLUSHING)
+	res, err = store.IngestSST(ctx, build(nil, "z"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Level)
+	assert.Equal(t, "ingested", get("z"))
+	store.Backend.sstManager.RemoveSST(0, []*SST{flushing})
+
+	_, err = store.IngestSST(ctx, build(NumericComparator, "1"))
+	assert.ErrorIs(t, err, ErrIngestInvalid)
+	assert.ErrorIs(t, err, ErrComparatorMismatch)
+
+	_, err = store.IngestSST(ctx, filepath.Join(t.TempDir(), "missing.sst"))
+	assert.ErrorIs(t, err, ErrIngestInvalid)
+
+	// the ingested SSTs are named after their footer
+	report, err := VerifyDir(store.Dir())
+	require.NoError(t, err)
+	assert.Empty(t, report.Problems)
+}
@@ -0,0 +1,178 @@
+package storage
+
+import "container/heap"
+
+// entryIterator is a sorted source of entries
+// that takes part in a merged scan.
+type entryIterator interface {
+	Valid() bool
+	Next()
+	Entry() *SSTEntry
+	Err() error
+}
+
+// memtableEntryIterator adapts MemtableIterator
+// to an entryIterator.
+type memtableEntryIterator struct {
+	MemtableIterator
+
+	// entry caches the current entry, the merge
+	// heap reads it on every comparison.
+	entry *SSTEntry
+}
+
+func (i *memtableEntryIterator) Next() {
+	i.entry = nil
+	i.MemtableIterator.Next()
+}
+
+func (i *memtableEntryIterator) Entry() *SSTEntry {
+	if i.entry != nil {
+		return i.entry
+	}
+
+	i.entry = i.Data().sstEntry()
+	return i.entry
+}
+
+func (i *memtableEntryIterator) Err() error {
+	return nil
+}
+
+type mergeItem struct {
+	// priority is the index of the source, lower is newer.
+	priority int
+	it       entryIterator
+}
+
+type mergeHeap struct {
+	items []mergeItem
+	cmp   Comparator
+}
+
+func (h *mergeHeap) Len() int {
+	return len(h.items)
+}
+
+func (h *mergeHeap) Less(i, j int) bool {
+	c := h.cmp.Compare(h.items[i].it.Entry().Key, h.items[j].it.Entry().Key)
+	if c != 0 {
+		return c < 0
+	}
+
+	return h.items[i].priority < h.items[j].priority
+}
+
+func (h *mergeHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+}
+
+func (h *mergeHeap) Push(x any) {
+	h.items = append(h.items, x.(mergeItem))
+}
+
+func (h *mergeHeap) Pop() any {
+	old := h.items
+	n := len(old)
+	item := old[n-1]
+	h.items = old[0 : n-1]
+	return item
+}
+
+// mergeIterator merges sorted sources into a single sorted
+// stream of unique keys. Sources are ordered from the newest
+// to the oldest, when a key exists in several sources
+// the entry of the newest source wins.
+type mergeIterator struct {
+	sources []entryIterator
+	h       mergeHeap
+	entry   *SSTEntry
+	source  int
+
+	// shadowed is called with the older entries skipped, if set.
+	shadowed func(source int, by int, entry *SSTEntry)
+}
+
+// newMergeIterator merges sources ordered by cmp.
+func newMergeIterator(sources []entryIterator, cmp Comparator) *mergeIterator {
+	return newShadowingMergeIterator(sources, cmp, nil)
+}
+
+// newShadowingMergeIterator merges sources like newMergeIterator and
+// calls shadowed with every older entry it skips, its source and
+// the source of the entry returned for its key.
+func newShadowingMergeIterator(sources []entryIterator, cmp Comparator, shadowed func(source int, by int, entry *SSTEntry)) *mergeIterator {
+	m := &mergeIterator{
+		sources:  sources,
+		h:        mergeHeap{cmp: cmp},
+		shadowed: shadowed,
+	}
+
+	for idx, it := range sources {
+		if it.Valid() {
+			m.h.items = append(m.h.items, mergeItem{priority: idx, it: it})
+		}
+	}
+
+	heap.Init(&m.h)
+	m.Next()
+
+	return m
+}
+
+func (m *mergeIterator) Valid() bool {
+	return m.entry != nil
+}
+
+func (m *mergeIterator) Entry() *SSTEntry {
+	return m.entry
+}
+
+// Source returns the index of the source the current entry is read from.
+func (m *mergeIterator) Source() int {
+	return m.source
+}
+
+func (m *mergeIterator) Next() {
+	m.entry = nil
+	if m.h.Len() == 0 {
+		return
+	}
+
+	top := m.h.items[0]
+	m.entry = top.it.Entry()
+	m.source = top.priority
+	m.advanceTop()
+
+	// skip older versions of the same key
+	for m.h.Len() > 0 && m.h.cmp.Compare(m.h.items[0].it.Entry().Key, m.entry.Key) == 0 {
+		if m.shadowed != nil {
+			m.shadowed(m.h.items[0].priority, m.source, m.h.items[0].it.Entry())
+		}
+
+		m.advanceTop()
+	}
+}
+
+// advanceTop moves the source at the top of the heap to its next
+// entry in place, a source is only popped once it's exhausted.
+func (m *mergeIterator) advanceTop() {
+	it := m.h.items[0].it
+	it.Next()
+	if it.Valid() {
+		heap.Fix(&m.h, 0)
+	} else {
+		heap.Pop(&m.h)
+	}
+}
+
+// Err returns the first error encountered by any source.
+func (m *mergeIterator) Err() error {
+	for _, it := range m.sources {
+		if err := it.Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
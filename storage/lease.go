@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrLeaseHeld    error = errors.New("lease is held by another owner")
+	ErrLeaseNotHeld error = errors.New("lease is not held by the owner")
+	ErrLeaseOwner   error = errors.New("lease owner must not be empty")
+)
+
+// Leases are keys holding the token of their owner until their ttl
+// elapsed, a lock is held by the owner of its lease. They're written
+// under the lock of their key, so of the owners acquiring a free lease
+// at once only one gets it.
+
+// AcquireLease makes owner the holder of the lease of key for ttl. A
+// missing or expired lease is free, and owners acquiring their own
+// lease renew it; leases held by others are rejected with ErrLeaseHeld.
+func (s *Store) AcquireLease(key string, owner string, ttl time.Duration) (*KVData, error) {
+	return s.writeLease(key, owner, ttl, func(current *KVData) error {
+		if current != nil && current.Value != owner {
+			return ErrLeaseHeld
+		}
+
+		return nil
+	})
+}
+
+// RenewLease extends the lease of key held by owner to ttl from now,
+// leases held by others or expired are rejected with ErrLeaseNotHeld.
+func (s *Store) RenewLease(key string, owner string, ttl time.Duration) (*KVData, error) {
+	return s.writeLease(key, owner, ttl, func(current *KVData) error {
+		if current == nil || current.Value != owner {
+			return ErrLeaseNotHeld
+		}
+
+		return nil
+	})
+}
+
+// ReleaseLease deletes the lease of key held by owner, leases
+// held by others or expired are rejected with ErrLeaseNotHeld.
+func (s *Store) ReleaseLease(key string, owner string) error {
+	if s.closed.Load() {
+		return ErrReadOnly
+	}
+
+	mu := s.locks.lock(key, s.Backend.sstManager.cmp)
+	mu.Lock()
+	defer mu.Unlock()
+
+	current, err := s.lookup(key)
+	if err != nil {
+		return err
+	}
+
+	if current == nil || current.Value != owner {
+		return ErrLeaseNotHeld
+	}
+
+	if err := s.Backend.Delete(key); err != nil {
+		return err
+	}
+
+	s.access.remove(key)
+	return nil
+}
+
+// writeLease stores owner as the holder of the lease of key for ttl
+// when check accepts the current lease, nil if there's none.
+func (s *Store) writeLease(key string, owner string, ttl time.Duration, check func(current *KVData) error) (*KVData, error) {
+	if s.closed.Load() {
+		return nil, ErrReadOnly
+	}
+
+	if ttl <= 0 {
+		return nil, ErrInvalidTTL
+	}
+
+	// empty values are tombstones
+	if owner == "" {
+		return nil, ErrLeaseOwner
+	}
+
+	if err := checkSize(key, owner); err != nil {
+		return nil, err
+	}
+
+	mu := s.locks.lock(key, s.Backend.sstManager.cmp)
+	mu.Lock()
+	defer mu.Unlock()
+
+	current, err := s.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := check(current); err != nil {
+		return nil, err
+	}
+
+	res := &KVData{
+		Key:       key,
+		Value:     owner,
+		ExpiresAt: s.Backend.sstManager.clock.Now().Add(ttl),
+	}
+
+	if err := s.Backend.setWithExpiry(key, owner, res.ExpiresAt); err != nil {
+		return nil, err
+	}
+
+	s.access.put(key, cachedSize(key, owner))
+	return res, nil
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeases(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	store, err := Open(context.Background(), logger, t.TempDir(), OPEN_FAST, nil, nil, clock)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	// a single owner acquires a free lease
+	var acquired atomic.Int32
+	var wg sync.WaitGroup
+	for _, owner := range []string{"a", "b", "c", "d"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.AcquireLease("lock", owner, time.Minute); err == nil {
+				acquired.Add(1)
+			} else {
+				assert.ErrorIs(t, err, ErrLeaseHeld)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), acquired.Load())
+
+	holder, err := store.Get(context.Background(), "lock")
+	require.NoError(t, err)
+	owner, other := holder.Value, "a"
+	if owner == "a" {
+		other = "b"
+	}
+
+	_, err = store.RenewLease("lock", other, time.Minute)
+	assert.ErrorIs(t, err, ErrLeaseNotHeld)
+	assert.ErrorIs(t, store.ReleaseLease("lock", other), ErrLeaseNotHeld)
+
+	clock.now = clock.now.Add(30 * time.Second)
+	res, err := store.RenewLease("lock", owner, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, clock.now.Add(time.Minute), res.ExpiresAt)
+
+	// expired leases are free
+	clock.now = clock.now.Add(time.Minute)
+	_, err = store.RenewLease("lock", owner, time.Minute)
+	assert.ErrorIs(t, err, ErrLeaseNotHeld)
+	_, err = store.AcquireLease("lock", other, time.Minute)
+	require.NoError(t, err)
+
+	assert.NoError(t, store.ReleaseLease("lock", other))
+	_, err = store.Get(context.Background(), "lock")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	_, err = store.AcquireLease("lock", "", time.Minute)
+	assert.ErrorIs(t, err, ErrLeaseOwner)
+	_, err = store.AcquireLease("lock", owner, 0)
+	assert.ErrorIs(t, err, ErrInvalidTTL)
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LevelOptions are the compaction settings of a level.
+// Zero fields take the defaults of their level.
+type LevelOptions struct {
+	// MaxFiles is the number of SSTs at which the level is compacted
+	// into the next one, the most a compaction merges at once.
+	// MAX_SST_PER_LEVEL by default.
+	MaxFiles int
+
+	// TargetFileSize splits the output of the compactions writing to
+	// the level into SSTs of about this size, cut between keys.
+	// Zero writes a single SST.
+	TargetFileSize int64
+
+	// Compression of the SSTs written to the level. SSTs are stored
+	// uncompressed, "none" is the only one supported.
+	Compression string
+
+	// Concurrency is the number of key ranges a compaction of the level
+	// is split into, merged in parallel. 1 by default.
+	Concurrency int
+}
+
+// LevelConfig holds the compaction settings of every level:
+// the ones of Levels override Default, which overrides
+// the built-in defaults.
+type LevelConfig struct {
+	Default LevelOptions
+	Levels  map[int]LevelOptions
+}
+
+// CompactionLevels are the compaction settings of the levels
+// of every store, read by the compactors as they compact.
+var CompactionLevels LevelConfig
+
+// options returns the settings of level, defaults applied.
+func (c LevelConfig) options(level int) LevelOptions {
+	opts := LevelOptions{
+		MaxFiles:    MAX_SST_PER_LEVEL,
+		Compression: "none",
+		Concurrency: 1,
+	}
+
+	for _, o := range []LevelOptions{c.Default, c.Levels[level]} {
+		if o.MaxFiles > 0 {
+			opts.MaxFiles = o.MaxFiles
+		}
+
+		if o.TargetFileSize > 0 {
+			opts.TargetFileSize = o.TargetFileSize
+		}
+
+		if o.Compression != "" {
+			opts.Compression = o.Compression
+		}
+
+		if o.Concurrency > 0 {
+			opts.Concurrency = o.Concurrency
+		}
+	}
+
+	return opts
+}
+
+// levelOptions returns the settings of level in CompactionLevels.
+func levelOptions(level int) LevelOptions {
+	return CompactionLevels.options(level)
+}
+
+// ParseLevelConfig parses per level settings separated by semicolons,
+// a level or "*" for every level followed by comma separated settings:
+// max_files, target_file_size in bytes, compression and concurrency,
+// e.g. "*:concurrency=2;0:max_files=4;2:target_file_size=67108864".
+func ParseLevelConfig(spec string) (LevelConfig, error) {
+	config := LevelConfig{Levels: make(map[int]LevelOptions)}
+
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, settings, ok := strings.Cut(part, ":")
+		if !ok {
+			return LevelConfig{}, fmt.Errorf("invalid level settings %q, expected level:setting=value", part)
+		}
+
+		opts, err := parseLevelOptions(settings)
+		if err != nil {
+			return LevelConfig{}, fmt.Errorf("invalid settings of level %s: %w", name, err)
+		}
+
+		if name == "*" {
+			config.Default = opts
+			continue
+		}
+
+		level, err := strconv.Atoi(name)
+		if err != nil || level < 0 {
+			return LevelConfig{}, fmt.Errorf("invalid level %q", name)
+		}
+
+		config.Levels[level] = opts
+	}
+
+	return config, nil
+}
+
+func parseLevelOptions(settings string) (LevelOptions, error) {
+	var opts LevelOptions
+
+	for _, setting := range strings.Split(settings, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(setting), "=")
+		if !ok {
+			return opts, fmt.Errorf("invalid setting %q, expected setting=value", setting)
+		}
+
+		if key == "compression" {
+			if value != "none" {
+				return opts, fmt.Errorf("compression %q is not supported, SSTs are stored uncompressed", value)
+			}
+
+			opts.Compression = value
+			continue
+		}
+
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n <= 0 {
+			return opts, fmt.Errorf("invalid %s %q", key, value)
+		}
+
+		switch key {
+		case "max_files":
+			opts.MaxFiles = int(n)
+		case "target_file_size":
+			opts.TargetFileSize = n
+		case "concurrency":
+			opts.Concurrency = int(n)
+		default:
+			return opts, fmt.Errorf("unknown setting %q", key)
+		}
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLevelConfig(t *testing.T) {
+	config, err := ParseLevelConfig("*:concurrency=2; 0:max_files=4,compression=none;2:target_file_size=1024")
+	require.NoError(t, err)
+
+	assert.Equal(t, LevelOptions{MaxFiles: 4, Compression: "none", Concurrency: 2}, config.options(0))
+	assert.Equal(t, LevelOptions{MaxFiles: MAX_SST_PER_LEVEL, Compression: "none", Concurrency: 2}, config.options(1))
+	assert.Equal(t, LevelOptions{MaxFiles: MAX_SST_PER_LEVEL, TargetFileSize: 1024, Compression: "none", Concurrency: 2}, config.options(2))
+
+	config, err = ParseLevelConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, LevelOptions{MaxFiles: MAX_SST_PER_LEVEL, Compression: "none", Concurrency: 1}, config.options(3))
+
+	for _, spec := range []string{"0", "x:max_files=1", "-1:max_files=1", "0:max_files=0", "0:size=1", "1:compression=zstd"} {
+		_, err := ParseLevelConfig(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestCompactLevelOptions(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	defer func() { CompactionLevels = LevelConfig{} }()
+	CompactionLevels = LevelConfig{
+		Levels: map[int]LevelOptions{
+			0: {Concurrency: 3},
+			1: {TargetFileSize: 2048},
+		},
+	}
+
+	sstManager, err := NewSSTManager(logger, t.TempDir(), OPEN_FAST, nil)
+	require.NoError(t, err)
+
+	value := strings.Repeat("v", 100)
+	for i := range 3 {
+		memtable := NewMemtable(BytewiseComparator)
+		for k := range 100 {
+			memtable.Set(fmt.Sprintf("key%03d", k), fmt.Sprint(value, i), false)
+		}
+		require.NoError(t, sstManager.FlushSST(memtable))
+	}
+
+	compactor := NewCompactor(logger, 0, sstManager)
+	require.True(t, compactor.run(ctx, sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0), 1, false))
+
+	// every range is cut into SSTs of about 2 KiB
+	ssts := sstManager.ListSST(1, []SSTState{SST_FLUSHED}, 0)
+	assert.Greater(t, len(ssts), 3)
+
+	var entries int
+	for _, sst := range ssts {
+		entries += len(readSST(t, sst))
+		assert.Less(t, sst.fileSize(), int64(4096))
+	}
+	assert.Equal(t, 100, entries)
+
+	for k := range 100 {
+		data, err := sstManager.QueryKey(ctx, fmt.Sprintf("key%03d", k))
+		require.NoError(t, err)
+		assert.Equal(t, value+"2", data.Value)
+	}
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	ErrKeyTooLarge   error = errors.New("key too large")
+	ErrValueTooLarge error = errors.New("value too large")
+)
+
+// MaxKeySize and MaxValueSize cap the size in bytes of the keys and
+// values written. Entries are length prefixed with 32 bits, whatever
+// the limits an entry can't exceed maxEntrySize.
+var (
+	MaxKeySize   = 64 << 10
+	MaxValueSize = 32 << 20
+)
+
+// maxEntrySize is the largest entry the length prefix can describe,
+// entryOverhead the size of an expiring entry without key and value.
+const (
+	maxEntrySize  = math.MaxUint32
+	entryOverhead = 4 + 4 + 4 + 1 + 8
+)
+
+// SetSizeLimits sets MaxKeySize and MaxValueSize.
+func SetSizeLimits(maxKeySize int, maxValueSize int) error {
+	if maxKeySize <= 0 || maxValueSize <= 0 {
+		return fmt.Errorf("invalid size limits: key %d, value %d, they must be positive", maxKeySize, maxValueSize)
+	}
+
+	if uint64(maxKeySize)+uint64(maxValueSize)+entryOverhead > maxEntrySize {
+		return fmt.Errorf("invalid size limits: key %d, value %d, entries are at most %d bytes", maxKeySize, maxValueSize, maxEntrySize)
+	}
+
+	MaxKeySize, MaxValueSize = maxKeySize, maxValueSize
+	return nil
+}
+
+// checkSize returns an error when key or value exceed the limits.
+func checkSize(key string, value string) error {
+	if len(key) > MaxKeySize {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrKeyTooLarge, len(key), MaxKeySize)
+	}
+
+	if len(value) > MaxValueSize {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrValueTooLarge, len(value), MaxValueSize)
+	}
+
+	if size := uint64(len(key)) + uint64(len(value)) + entryOverhead; size > maxEntrySize {
+		return fmt.Errorf("%w: entry of %d bytes", ErrValueTooLarge, size)
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSizeLimits(t *testing.T) {
+	defer func(key int, value int) { MaxKeySize, MaxValueSize = key, value }(MaxKeySize, MaxValueSize)
+	require.NoError(t, SetSizeLimits(8, 16))
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store, err := Open(context.Background(), logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	key, value := strings.Repeat("k", 8), strings.Repeat("v", 16)
+
+	// the limits are inclusive
+	assert.NoError(t, store.Set(key, value))
+	assert.NoError(t, store.SetWithTTL(key, value, time.Minute))
+	assert.NoError(t, store.Write([]BatchOp{{Type: BATCH_PUT, Key: key, Value: value}}))
+
+	assert.ErrorIs(t, store.Set(key+"k", "v"), ErrKeyTooLarge)
+	assert.ErrorIs(t, store.Set("k", value+"v"), ErrValueTooLarge)
+	assert.ErrorIs(t, store.SetWithTTL("k", value+"v", time.Minute), ErrValueTooLarge)
+	_, err = store.Incr(key+"k", 1)
+	assert.ErrorIs(t, err, ErrKeyTooLarge)
+
+	// a batch with a single entry too large is rejected as a whole
+	err = store.Write([]BatchOp{
+		{Type: BATCH_PUT, Key: "a", Value: "1"},
+		{Type: BATCH_PUT, Key: "b", Value: value + "v"},
+	})
+	assert.ErrorIs(t, err, ErrValueTooLarge)
+	_, err = store.Get(context.Background(), "a")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// deletes of any key are accepted
+	assert.NoError(t, store.Write([]BatchOp{{Type: BATCH_DELETE, Key: key + "k"}}))
+
+	data, err := store.Get(context.Background(), key)
+	require.NoError(t, err)
+	assert.Equal(t, value, data.Value)
+}
+
+func TestSetSizeLimits(t *testing.T) {
+	defer func(key int, value int) { MaxKeySize, MaxValueSize = key, value }(MaxKeySize, MaxValueSize)
+
+	assert.Error(t, SetSizeLimits(0, 16))
+	assert.Error(t, SetSizeLimits(8, -1))
+	assert.Error(t, SetSizeLimits(1, math.MaxUint32))
+	assert.NoError(t, SetSizeLimits(1<<10, math.MaxUint32-1<<10-entryOverhead))
+}
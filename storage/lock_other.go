@@ -0,0 +1,14 @@
+//go:build !unix || aix
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Directories are locked with flock on Unix systems, other systems,
+// and AIX which has no flock, only create the lock file.
+func lockDir(dir string) (*os.File, error) {
+	return os.OpenFile(filepath.Join(dir, LockFile), os.O_RDWR|os.O_CREATE, 0644)
+}
@@ -0,0 +1,43 @@
+//go:build unix && !aix
+
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenLocksDir(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	store, err := Open(context.Background(), logger, dir, OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Set("a", "1"))
+
+	// flocks of separate opens conflict within a process too
+	_, err = Open(context.Background(), logger, dir, OPEN_FAST, nil, nil, nil)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	// stopped stores keep the lock, the memtables aren't flushed yet
+	store.Stop()
+	_, err = Open(context.Background(), logger, dir, OPEN_FAST, nil, nil, nil)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	// closing flushes them and releases the lock
+	_, err = store.Close(context.Background())
+	require.NoError(t, err)
+
+	store, err = Open(context.Background(), logger, dir, OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	res, err := store.Get(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", res.Value)
+}
@@ -1,19 +1,42 @@
 package storage
 
 import (
+	"context"
+	"distrikv/pkg"
+	"errors"
 	"log/slog"
 	"sync"
+	"time"
 )
 
-var baseDir = "data"
+var (
+	ErrKeyNotFound error = errors.New("key not found")
+	ErrReadOnly    error = errors.New("store is read-only")
+	ErrInvalidTTL  error = errors.New("ttl must be positive")
+)
 
 // MemtableSizeThreshold in records
 var MemtableSizeThreshold = 5
 
+// flushRetryInterval is the wait before retrying a failed flush.
+const flushRetryInterval = time.Second
+
+// flushPollInterval is the wait between checks of Flush
+// for the queued memtables to be written.
+const flushPollInterval = 10 * time.Millisecond
+
 type KVData struct {
 	Key       string
 	Value     string
 	IsDeleted bool
+
+	// ExpiresAt is zero for keys that don't expire.
+	ExpiresAt time.Time `json:",omitzero"`
+
+	// Timestamp is the version of the entry read by Get: the time it was
+	// written while in a memtable, then the time of the SST holding it.
+	// Every write of a key changes it, flushes and compactions may too.
+	Timestamp time.Time `json:"-"`
 }
 
 // LSM is a struct for Log-Structured Merge Tree.
@@ -33,15 +56,30 @@ type LSM struct {
 
 	flushQueue chan *Memtable
 
+	// queueMu keeps full memtables queued in order without
+	// holding mu, the flusher takes mu between flushes.
+	queueMu sync.Mutex
+
 	sstManager *SSTManager
+
+	// rowCache keeps entries recently read from SSTs, missCache keys
+	// recently not found in them. Both are nil when disabled.
+	rowCache  *rowCache
+	missCache *rowCache
+
+	// sequencer numbers the memtables by the changelog
+	// sequence of their first write, nil if not numbered.
+	sequencer Sequencer
 }
 
 func NewLSM(logger *slog.Logger, sstManager *SSTManager) *LSM {
 	lsm := &LSM{
-		logger:     logger,
-		Memtable:   NewMemtable(),
+		logger:     logger.With(pkg.ComponentKey, "storage.lsm"),
+		Memtable:   newMemtable(sstManager),
 		sstManager: sstManager,
 		flushQueue: make(chan *Memtable),
+		rowCache:   newRowCache(RowCacheSize, sstManager.cmp),
+		missCache:  newRowCache(NegativeCacheSize, sstManager.cmp),
 	}
 
 	lsm.StartFlusher(lsm.flushQueue, sstManager)
@@ -49,54 +87,294 @@ func NewLSM(logger *slog.Logger, sstManager *SSTManager) *LSM {
 	return lsm
 }
 
-func (l *LSM) Set(key string, value string) {
-	l.Memtable.Set(key, value, false)
+// newMemtable creates a memtable ordered and timestamped like the SSTs.
+func newMemtable(sstManager *SSTManager) *Memtable {
+	mt := NewMemtable(sstManager.cmp)
+	mt.clock = sstManager.clock
+
+	return mt
+}
+
+func (l *LSM) Set(key string, value string) error {
+	if err := l.sstManager.health.writable(); err != nil {
+		return err
+	}
+
+	l.active().Set(key, value, false)
+	l.invalidate(key)
+	l.checkFlush()
+
+	return nil
+}
+
+// SetWithTTL stores key until ttl elapsed, expired keys are not found.
+func (l *LSM) SetWithTTL(key string, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+
+	return l.setWithExpiry(key, value, l.sstManager.clock.Now().Add(ttl))
+}
+
+// setWithExpiry stores key until expiresAt, zero never expires.
+func (l *LSM) setWithExpiry(key string, value string, expiresAt time.Time) error {
+	if err := l.sstManager.health.writable(); err != nil {
+		return err
+	}
+
+	l.active().SetWithExpiry(key, value, expiresAt)
+	l.invalidate(key)
 	l.checkFlush()
+
+	return nil
 }
 
-func (l *LSM) Get(key string) (*KVData, error) {
-	var kvData KVData
+// Get returns the live entry of key. SSTs are searched until ctx
+// is done, the memtables and caches are read regardless.
+func (l *LSM) Get(ctx context.Context, key string) (*KVData, error) {
+	if data, ok := l.rowCache.get(key); ok {
+		if l.isExpired(data.ExpiresAt) {
+			return nil, ErrKeyNotFound
+		}
 
-	data, err := l.Memtable.Get(key)
-	if err != nil {
+		return data, nil
+	}
+
+	if _, ok := l.missCache.get(key); ok {
+		return nil, ErrKeyNotFound
+	}
+
+	gen, missGen := l.rowCache.generation(), l.missCache.generation()
+
+	// the newest entry of key decides, deletes included
+	if data, ok := l.memtableEntry(key); ok {
+		if data.deleted() || l.isExpired(data.ExpiresAt) {
+			return nil, ErrKeyNotFound
+		}
+
+		return &KVData{Key: data.Key, Value: data.Value, ExpiresAt: data.ExpiresAt, Timestamp: data.Timestamp}, nil
+	}
+
+	kvData, err := l.sstManager.QueryKey(ctx, key)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
 		return nil, err
 	}
 
-	kvData.Key = data.Key
-	kvData.Value = data.Value
+	if err != nil || l.isExpired(kvData.ExpiresAt) {
+		l.missCache.add(KVData{Key: key}, missGen)
+		return nil, ErrKeyNotFound
+	}
+
+	l.rowCache.add(*kvData, gen)
 
-	// TODO: add a marker to show if the data doesn't exist in memtable
-	// currently, if data is just an empty string, or is deleted in memtable
-	// it will query in the ssts
+	return kvData, nil
+}
 
-	if kvData.Value == "" {
-		res, err := l.sstManager.QueryKey(key)
+// memtableEntry returns the newest entry of key held by the memtables,
+// false if none holds it. Deletes are entries too, see deleted.
+func (l *LSM) memtableEntry(key string) (MemtableEntry, bool) {
+	for _, mt := range l.memtables() {
+		if entry, ok := mt.lookup(key); ok {
+			return entry, true
+		}
+	}
+
+	return MemtableEntry{}, false
+}
+
+// invalidate drops the cached entries of key, once a write is visible.
+func (l *LSM) invalidate(key string) {
+	l.rowCache.invalidate(key)
+	l.missCache.invalidate(key)
+}
+
+func (l *LSM) isExpired(expiresAt time.Time) bool {
+	return !expiresAt.IsZero() && !l.sstManager.clock.Now().Before(expiresAt)
+}
+
+// Delete removes key. Deletes are accepted on a full disk,
+// compactions reclaim the space of the keys they remove.
+func (l *LSM) Delete(key string) error {
+	if l.sstManager.health.isReadOnly() {
+		return ErrReadOnly
+	}
+
+	l.active().Set(key, "", false)
+	l.invalidate(key)
+	l.checkFlush()
+
+	return nil
+}
+
+// Scan calls fn for every live key in [start, end) in the order of
+// the comparator until fn returns false or ctx is done.
+// An empty end scans to the last key.
+func (l *LSM) Scan(ctx context.Context, start string, end string, fn func(*KVData) bool) error {
+	sources, ssts, closeSources, err := l.readSources()
+	if err != nil {
+		return err
+	}
+	defer closeSources()
+
+	cmp := l.sstManager.cmp
+	now := l.sstManager.clock.Now()
+	m := newShadowingMergeIterator(sources, cmp, l.shadowedBy(len(sources)-len(ssts), ssts))
+	for ; m.Valid(); m.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		entry := m.Entry()
+		if cmp.Compare(entry.Key, start) < 0 {
+			continue
+		}
+
+		if end != "" && cmp.Compare(entry.Key, end) >= 0 {
+			break
+		}
+
+		// Delete stores an empty value in the memtable
+		if entry.IsDeleted || entry.Value == "" || entry.expired(now) {
+			continue
+		}
+
+		if !fn(&KVData{Key: entry.Key, Value: entry.Value, ExpiresAt: entry.ExpiresAt}) {
+			break
+		}
+	}
+
+	return m.Err()
+}
+
+// After wraps fn to skip the keys up to after under cmp, a scan
+// starting at after then continues past it, e.g. from a cursor.
+func After(cmp Comparator, after string, fn func(*KVData) bool) func(*KVData) bool {
+	return func(data *KVData) bool {
+		if cmp.Compare(data.Key, after) <= 0 {
+			return true
+		}
+
+		return fn(data)
+	}
+}
+
+// readSources returns iterators over the memtables followed by the ssts,
+// ordered from the newest to the oldest, and the ssts themselves.
+// The returned func closes the sst iterators and releases the ssts.
+func (l *LSM) readSources() ([]entryIterator, []*SST, func(), error) {
+	// memtables are collected before the ssts, so a memtable
+	// flushed in between is still visible through one of them.
+	var sources []entryIterator
+	for _, mt := range l.memtables() {
+		sources = append(sources, &memtableEntryIterator{MemtableIterator: mt.Iterate()})
+	}
+
+	ssts := l.sstManager.sstsForRead()
+
+	var its []*SSTIterator
+	closeSources := func() {
+		for _, it := range its {
+			it.Close()
+		}
+
+		releaseSSTs(ssts)
+	}
+
+	for _, sst := range ssts {
+		it, err := sst.Iterate()
 		if err != nil {
-			return nil, err
+			closeSources()
+			return nil, nil, nil, err
 		}
 
-		kvData = *res
+		its = append(its, it)
+		sources = append(sources, it)
 	}
 
-	return &kvData, nil
+	return sources, ssts, closeSources, nil
 }
 
-func (l *LSM) Delete(key string) {
-	l.Memtable.Set(key, "", false)
-	l.checkFlush()
+// memtables returns the active memtable followed by
+// the ones being flushed, from the newest to the oldest.
+func (l *LSM) memtables() []*Memtable {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	memtables := []*Memtable{l.Memtable}
+	for i := len(l.flushingMemtables) - 1; i >= 0; i-- {
+		memtables = append(memtables, l.flushingMemtables[i])
+	}
+
+	return memtables
 }
 
 func (l *LSM) checkFlush() {
+	l.queueMu.Lock()
+	defer l.queueMu.Unlock()
+
 	l.mu.Lock()
-	defer l.mu.Unlock()
+	if l.Memtable.Size() < MemtableSizeThreshold {
+		l.mu.Unlock()
+		return
+	}
+
+	old := l.Memtable
+
+	l.flushingMemtables = append(l.flushingMemtables, old)
+	l.Memtable = newMemtable(l.sstManager)
+	l.mu.Unlock()
 
-	if l.Memtable.Size() >= MemtableSizeThreshold {
-		old := l.Memtable
+	l.flushQueue <- old
+}
+
+// Flush queues the active memtable and waits until every queued
+// memtable is written to an SST or ctx is done. It returns the
+// number of entries the queued memtables held.
+func (l *LSM) Flush(ctx context.Context) (int, error) {
+	l.queueMu.Lock()
 
+	l.mu.Lock()
+	old := l.Memtable
+	queue := old.Size() > 0
+	if queue {
 		l.flushingMemtables = append(l.flushingMemtables, old)
-		l.Memtable = NewMemtable()
+		l.Memtable = newMemtable(l.sstManager)
+	}
 
-		l.flushQueue <- old
+	entries := 0
+	for _, mt := range l.flushingMemtables {
+		entries += mt.Size()
+	}
+	l.mu.Unlock()
+
+	if queue {
+		select {
+		case l.flushQueue <- old:
+		case <-ctx.Done():
+			l.queueMu.Unlock()
+			return entries, ctx.Err()
+		}
+	}
+
+	l.queueMu.Unlock()
+
+	ticker := time.NewTicker(flushPollInterval)
+	defer ticker.Stop()
+
+	for {
+		l.mu.RLock()
+		pending := len(l.flushingMemtables)
+		l.mu.RUnlock()
+
+		if pending == 0 {
+			return entries, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return entries, ctx.Err()
+		case <-ticker.C:
+		}
 	}
 }
 
@@ -104,9 +382,16 @@ func (l *LSM) StartFlusher(flushQueue <-chan *Memtable, sstManager *SSTManager)
 	go func() {
 		for mt := range flushQueue {
 
-			// for now, only print error to log if there is a problem flushing
-			if err := l.sstManager.FlushSST(mt); err != nil {
+			// the memtable stays readable until it is flushed,
+			// failed flushes are retried until the disk recovers
+			for {
+				err := l.sstManager.FlushSST(mt)
+				if err == nil {
+					break
+				}
+
 				l.logger.Error("error flushing SST", "err", err)
+				time.Sleep(flushRetryInterval)
 			}
 
 			// remove flushed memtable from flushingMemtables
@@ -117,7 +402,12 @@ func (l *LSM) StartFlusher(flushQueue <-chan *Memtable, sstManager *SSTManager)
 					break
 				}
 			}
+			sequencer := l.sequencer
 			l.mu.Unlock()
+
+			if sequencer != nil {
+				sequencer.Flushed()
+			}
 		}
 	}()
 }
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeletedKeysAreNotFound(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	store, err := Open(ctx, logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+
+	// memtables are moved to flushing by hand below, the flusher
+	// never gets them and closing would wait for them forever
+	defer store.Stop()
+
+	assertDeleted := func(step string) {
+		t.Helper()
+
+		_, err := store.Get(context.Background(), "a")
+		assert.ErrorIs(t, err, ErrKeyNotFound, step)
+
+		res, err := store.MGet(context.Background(), []string{"a", "b"})
+		require.NoError(t, err, step)
+		assert.Nil(t, res[0], step)
+		require.NotNil(t, res[1], step)
+		assert.Equal(t, "1", res[1].Value, step)
+
+		_, err = store.GetValue(context.Background(), "a")
+		assert.ErrorIs(t, err, ErrKeyNotFound, step)
+
+		var keys []string
+		require.NoError(t, store.Scan(context.Background(), "", "", func(data *KVData) bool {
+			keys = append(keys, data.Key)
+			return true
+		}))
+		assert.Equal(t, []string{"b"}, keys, step)
+	}
+
+	flush := func() {
+		_, err := store.Flush(ctx)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, store.Set("a", "1"))
+	require.NoError(t, store.Set("b", "1"))
+	flush()
+
+	// cached by the read, the delete invalidates it
+	res, err := store.Get(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", res.Value)
+
+	require.NoError(t, store.Delete("a"))
+	assertDeleted("memtable over sst")
+
+	flush()
+	assertDeleted("level 0 over level 0")
+
+	require.NoError(t, store.CompactAll(ctx, func(int, int) {}))
+	assertDeleted("compacted")
+
+	// a delete on level 0 hides the value compacted below
+	require.NoError(t, store.Set("a", "2"))
+	flush()
+	require.NoError(t, store.CompactAll(ctx, func(int, int) {}))
+	res, err = store.Get(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "2", res.Value)
+
+	require.NoError(t, store.Delete("a"))
+	flush()
+	assertDeleted("level 0 over level 1")
+
+	// a memtable being flushed hides the SSTs too
+	lsm := store.Backend
+	require.NoError(t, lsm.Set("a", "3"))
+	lsm.mu.Lock()
+	lsm.flushingMemtables = append(lsm.flushingMemtables, lsm.Memtable)
+	lsm.Memtable = newMemtable(lsm.sstManager)
+	lsm.mu.Unlock()
+
+	res, err = store.Get(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "3", res.Value)
+
+	require.NoError(t, store.Delete("a"))
+	lsm.mu.Lock()
+	lsm.flushingMemtables = append(lsm.flushingMemtables, lsm.Memtable)
+	lsm.Memtable = newMemtable(lsm.sstManager)
+	lsm.mu.Unlock()
+	assertDeleted("flushing memtable")
+}
+
+func TestGetTimestamp(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	store, err := Open(ctx, logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	require.NoError(t, store.Set("a", "1"))
+	first, err := store.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.False(t, first.Timestamp.IsZero())
+
+	_, err = store.Backend.Flush(ctx)
+	require.NoError(t, err)
+
+	// flushed entries take the version of their SST, the same for every read
+	flushed, err := store.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.False(t, flushed.Timestamp.Before(first.Timestamp))
+
+	value, err := store.GetValue(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, flushed.Timestamp, value.Timestamp)
+	require.NoError(t, value.Close())
+
+	require.NoError(t, store.Set("a", "1"))
+	written, err := store.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.True(t, written.Timestamp.After(flushed.Timestamp))
+}
+
+func TestReadsCancelled(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	store, err := Open(context.Background(), logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	require.NoError(t, store.Set("a", "1"))
+	require.NoError(t, store.Set("b", "2"))
+	_, err = store.Backend.Flush(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// keys only found in SSTs are read from disk
+	_, err = store.Get(ctx, "a")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = store.MGet(ctx, []string{"a", "b"})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	err = store.Scan(ctx, "", "", func(*KVData) bool { return true })
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// keys still in the memtable are answered without the disk
+	require.NoError(t, store.Set("c", "3"))
+	res, err := store.Get(ctx, "c")
+	require.NoError(t, err)
+	assert.Equal(t, "3", res.Value)
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"errors"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/godlixe/skiplist"
@@ -50,6 +51,13 @@ type Memtable struct {
 	Store skiplist.SkipList[MemtableEntry]
 
 	State MemtableState
+
+	// clock timestamps the entries, the system clock when nil.
+	clock Clock
+
+	// seq is the changelog sequence of the first write,
+	// zero if not numbered, see Sequencer.
+	seq atomic.Uint64
 }
 
 // MemtableEntry is a struct for objects stored
@@ -59,6 +67,37 @@ type MemtableEntry struct {
 	Value     string
 	Timestamp time.Time
 	Deleted   bool
+
+	// ExpiresAt is zero for entries that don't expire.
+	ExpiresAt time.Time
+
+	// Versions are the previous versions of the key,
+	// newest first, kept while versions are retained.
+	Versions []Version
+}
+
+// deleted reports whether the entry is a delete of its key,
+// LSM.Delete stores an empty value.
+func (e MemtableEntry) deleted() bool {
+	return e.Deleted || e.Value == ""
+}
+
+// sstEntry returns the entry as written to an SST,
+// with its timestamp while versions are retained.
+func (e MemtableEntry) sstEntry() *SSTEntry {
+	entry := &SSTEntry{
+		Key:       e.Key,
+		Value:     e.Value,
+		IsDeleted: e.Deleted,
+		ExpiresAt: e.ExpiresAt,
+	}
+
+	if versionsRetained() {
+		entry.Timestamp = e.Timestamp
+		entry.Versions = e.Versions
+	}
+
+	return entry
 }
 
 func cmpMemtableEntry(a, b MemtableEntry) int {
@@ -74,15 +113,56 @@ func New() *Memtable {
 	}
 }
 
+func (m *Memtable) now() time.Time {
+	if m.clock == nil {
+		return time.Now()
+	}
+
+	return m.clock.Now()
+}
+
 func (m *Memtable) Set(key string, value string, deleted bool) {
-	m.Store.Set(MemtableEntry{
+	m.put(MemtableEntry{
 		Key:       key,
 		Value:     value,
-		Timestamp: time.Now(),
+		Timestamp: m.now(),
 		Deleted:   deleted,
 	})
 }
 
+// SetWithExpiry stores key until expiresAt.
+func (m *Memtable) SetWithExpiry(key string, value string, expiresAt time.Time) {
+	m.put(MemtableEntry{
+		Key:       key,
+		Value:     value,
+		Timestamp: m.now(),
+		ExpiresAt: expiresAt,
+	})
+}
+
+// put stores entry, keeping the entry it replaces
+// as a version while versions are retained.
+func (m *Memtable) put(entry MemtableEntry) {
+	if versionsRetained() {
+		if prev, ok := m.lookup(entry.Key); ok {
+			versions := prev.sstEntry().history(prev.Timestamp)
+			entry.Versions = retainVersions(versions, entry.Timestamp, entry.Timestamp)
+		}
+	}
+
+	m.Store.Set(entry)
+}
+
+// lookup returns the entry of key, false if the memtable doesn't hold it.
+func (m *Memtable) lookup(key string) (MemtableEntry, bool) {
+	res, err := m.Store.Search(MemtableEntry{Key: key})
+	if err != nil {
+		return MemtableEntry{}, false
+	}
+
+	return res, true
+}
+
 func (m *Memtable) Get(key string) (MemtableEntry, error) {
 	res, err := m.Store.Search(MemtableEntry{
 		Key: key,
@@ -102,9 +182,9 @@ func (m *Memtable) Get(key string) (MemtableEntry, error) {
 }
 
 func (m *Memtable) Delete(key string) {
-	m.Store.Set(MemtableEntry{
+	m.put(MemtableEntry{
 		Key:       key,
-		Timestamp: time.Now(),
+		Timestamp: m.now(),
 		Deleted:   true,
 	})
 }
@@ -129,8 +209,11 @@ func (m *Memtable) Iterate() MemtableIterator {
 	}
 }
 
-func NewMemtable() *Memtable {
+// NewMemtable creates a memtable ordered by cmp.
+func NewMemtable(cmp Comparator) *Memtable {
 	return &Memtable{
-		Store: skiplist.NewDefault(cmpMemtableEntry),
+		Store: skiplist.NewDefault(func(a, b MemtableEntry) int {
+			return cmp.Compare(a.Key, b.Key)
+		}),
 	}
 }
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"slices"
+)
+
+// MGet looks keys up like Get and returns their entries in the order
+// of keys, nil for keys not found. The SSTs are searched once for all
+// keys and every file is opened once for the values it holds.
+func (l *LSM) MGet(ctx context.Context, keys []string) ([]*KVData, error) {
+	found := make(map[string]*KVData, len(keys))
+
+	var pending []string
+	for _, key := range keys {
+		if _, ok := found[key]; ok {
+			continue
+		}
+
+		found[key] = nil
+
+		// deletes in the memtables hide the SSTs as well
+		if data, ok := l.memtableEntry(key); ok {
+			found[key] = &KVData{Key: data.Key, Value: data.Value, IsDeleted: data.deleted(), ExpiresAt: data.ExpiresAt}
+			continue
+		}
+
+		pending = append(pending, key)
+	}
+
+	if len(pending) > 0 {
+		if err := l.sstManager.multiGet(ctx, pending, found); err != nil {
+			return nil, err
+		}
+	}
+
+	res := make([]*KVData, len(keys))
+	for i, key := range keys {
+		data := found[key]
+		if data == nil || data.Value == "" || data.IsDeleted || l.isExpired(data.ExpiresAt) {
+			continue
+		}
+
+		res[i] = data
+	}
+
+	return res, nil
+}
+
+// multiGet stores the entries of keys found in the SSTs in found.
+// Keys are searched in the order of QueryKey, a key found in an SST
+// isn't searched in the following ones.
+func (s *SSTManager) multiGet(ctx context.Context, keys []string, found map[string]*KVData) error {
+	for _, key := range keys {
+		s.hotKeys.Record(key)
+	}
+
+	// sorted keys are read at ascending offsets
+	keys = slices.Clone(keys)
+	slices.SortFunc(keys, s.cmp.Compare)
+
+	return s.eachSST(func(sst *SST) (bool, error) {
+		var remaining []string
+		var readKeys []string
+		var reads []int64
+
+		for _, key := range keys {
+			ie, err := sst.lookup(ctx, key)
+			if err != nil {
+				return false, err
+			}
+
+			switch {
+			case ie == nil:
+				remaining = append(remaining, key)
+			case ie.inline:
+				found[key] = &KVData{Key: ie.key, Value: ie.value, IsDeleted: ie.isDeleted, ExpiresAt: ie.expiresAt}
+			default:
+				readKeys = append(readKeys, key)
+				reads = append(reads, ie.offset)
+			}
+		}
+
+		if len(reads) > 0 {
+			if err := ctx.Err(); err != nil {
+				return false, err
+			}
+
+			entries, err := readEntriesAt(sst, reads)
+			if err != nil {
+				return false, err
+			}
+
+			for i, entry := range entries {
+				found[readKeys[i]] = &KVData{Key: entry.Key, Value: entry.Value, IsDeleted: entry.IsDeleted, ExpiresAt: entry.ExpiresAt}
+			}
+		}
+
+		keys = remaining
+		return len(keys) > 0, nil
+	})
+}
+
+// readEntriesAt reads the entries at offsets with a single open of the file.
+func readEntriesAt(s *SST, offsets []int64) ([]*SSTEntry, error) {
+	f, err := os.Open(s.Path())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := getReader(f)
+	defer putReader(r)
+
+	entries := make([]*SSTEntry, 0, len(offsets))
+	for _, offset := range offsets {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		r.Reset(f)
+
+		entry, err := readSSTEntry(r)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMGet(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	store, err := Open(context.Background(), logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	assert.NoError(t, err)
+	defer store.Close(context.Background())
+
+	large := strings.Repeat("l", 2*InlineValueSize)
+	assert.NoError(t, store.Set("a", "1"))
+	assert.NoError(t, store.Set("b", large))
+	assert.NoError(t, store.Set("c", "3"))
+	assert.NoError(t, store.Delete("d"))
+	assert.NoError(t, store.Set("e", large))
+	_, err = store.Flush(context.Background())
+	assert.NoError(t, err)
+
+	// memtable entries win
+	assert.NoError(t, store.Set("a", "2"))
+
+	res, err := store.MGet(context.Background(), []string{"e", "a", "missing", "c", "d", "b", "a"})
+	assert.NoError(t, err)
+
+	var values []string
+	for _, data := range res {
+		if data == nil {
+			values = append(values, "<nil>")
+			continue
+		}
+		values = append(values, data.Value)
+	}
+	assert.Equal(t, []string{large, "2", "<nil>", "3", "<nil>", large, "2"}, values)
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrKeyExists error = errors.New("key already exists")
+
+type SetMode int
+
+// Set Modes
+//
+// SET_ALWAYS writes the key whether it exists or not.
+//
+// SET_IF_NOT_EXISTS only creates missing keys, existing keys fail with ErrKeyExists.
+//
+// SET_MUST_EXIST only overwrites existing keys, missing keys fail with ErrKeyNotFound.
+//
+// Expired keys are missing.
+const (
+	SET_ALWAYS SetMode = iota
+
+	SET_IF_NOT_EXISTS
+
+	SET_MUST_EXIST
+)
+
+// ParseSetMode parses a write mode of the API,
+// an empty string defaults to SET_ALWAYS.
+func ParseSetMode(mode string) (SetMode, error) {
+	switch mode {
+	case "", "always":
+		return SET_ALWAYS, nil
+	case "if-not-exists":
+		return SET_IF_NOT_EXISTS, nil
+	case "must-exist":
+		return SET_MUST_EXIST, nil
+	default:
+		return SET_ALWAYS, fmt.Errorf("unknown set mode %q", mode)
+	}
+}
+
+// SetWithMode stores key when mode allows it, expiring it after ttl
+// unless ttl is zero. The key is checked and written under its lock,
+// so of the writers creating a missing key at once only one succeeds.
+func (s *Store) SetWithMode(key string, value string, ttl time.Duration, mode SetMode) error {
+	if s.closed.Load() {
+		return ErrReadOnly
+	}
+
+	if ttl < 0 {
+		return ErrInvalidTTL
+	}
+
+	if err := checkSize(key, value); err != nil {
+		return err
+	}
+
+	mu := s.locks.lock(key, s.Backend.sstManager.cmp)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if mode != SET_ALWAYS {
+		_, err := s.Backend.Get(context.Background(), key)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+
+		if mode == SET_IF_NOT_EXISTS && err == nil {
+			return ErrKeyExists
+		}
+
+		if mode == SET_MUST_EXIST && err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if ttl > 0 {
+		err = s.Backend.SetWithTTL(key, value, ttl)
+	} else {
+		err = s.Backend.Set(key, value)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.access.put(key, cachedSize(key, value))
+	return nil
+}
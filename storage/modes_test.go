@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetWithMode(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	store, err := Open(context.Background(), logger, t.TempDir(), OPEN_FAST, nil, nil, clock)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	// a single writer creates a missing key
+	var created atomic.Int32
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := store.SetWithMode("a", strconv.Itoa(i), 0, SET_IF_NOT_EXISTS)
+			if err == nil {
+				created.Add(1)
+			} else {
+				assert.ErrorIs(t, err, ErrKeyExists)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), created.Load())
+
+	assert.ErrorIs(t, store.SetWithMode("b", "1", 0, SET_MUST_EXIST), ErrKeyNotFound)
+	_, err = store.Get(context.Background(), "b")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	require.NoError(t, store.SetWithMode("a", "updated", time.Minute, SET_MUST_EXIST))
+	res, err := store.Get(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "updated", res.Value)
+	assert.Equal(t, clock.now.Add(time.Minute), res.ExpiresAt)
+
+	// expired keys are missing
+	clock.now = clock.now.Add(time.Minute)
+	assert.ErrorIs(t, store.SetWithMode("a", "1", 0, SET_MUST_EXIST), ErrKeyNotFound)
+	assert.NoError(t, store.SetWithMode("a", "1", 0, SET_IF_NOT_EXISTS))
+
+	mode, err := ParseSetMode("must-exist")
+	assert.NoError(t, err)
+	assert.Equal(t, SET_MUST_EXIST, mode)
+	_, err = ParseSetMode("xx")
+	assert.Error(t, err)
+}
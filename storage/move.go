@@ -0,0 +1,269 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// movable reports whether the inputs of a compaction into outLevel can be
+// moved there as they are: their key ranges don't overlap each other nor
+// any SST of outLevel, so no entry shadows another. Inputs holding dead
+// entries are compacted to drop them, as are all of them while versions
+// are retained, compactions drop the versions no longer retained.
+func (c *Compactor) movable(ssts []*SST, outLevel int) bool {
+	if versionsRetained() {
+		return false
+	}
+
+	cmp := c.sstManager.cmp
+
+	type keyRange struct{ lo, hi string }
+	var ranges []keyRange
+
+	for _, sst := range ssts {
+		if sst.deadBytes.Load() > 0 {
+			return false
+		}
+
+		lo, hi, err := sst.KeyRange()
+		if err != nil || lo == "" && hi == "" {
+			return false
+		}
+
+		ranges = append(ranges, keyRange{lo, hi})
+	}
+
+	for _, sst := range c.sstManager.ListSST(outLevel, []SSTState{SST_FLUSHING, SST_FLUSHED, SST_COMPACTING}, 0) {
+		lo, hi, err := sst.KeyRange()
+		if err != nil {
+			return false
+		}
+
+		ranges = append(ranges, keyRange{lo, hi})
+	}
+
+	slices.SortFunc(ranges, func(a keyRange, b keyRange) int {
+		return cmp.Compare(a.lo, b.lo)
+	})
+
+	for i := 1; i < len(ranges); i++ {
+		if cmp.Compare(ranges[i].lo, ranges[i-1].hi) <= 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// move moves ssts to outLevel without merging them, each becomes a new
+// SST of outLevel holding its entries, in the order of ssts. The file of
+// an SST is linked under the name of its output and only its footer,
+// recording the level, is written anew, see linkSST. Files shared with
+// checkpoints or snapshots are copied instead. It's called holding
+// linkMu. The outputs are removed when it fails, also when ctx is done,
+// and the footers of the inputs restored.
+func (c *Compactor) move(ctx context.Context, ssts []*SST, outLevel int) (_ []*SST, err error) {
+	var outputs []*SST
+	var moved []movedSST
+	defer func() {
+		if err != nil {
+			c.sstManager.discard(outLevel, outputs)
+			for _, m := range moved {
+				m.restore()
+			}
+		}
+	}()
+
+	sync := SSTSync == SYNC_ALWAYS
+	for _, sst := range ssts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		size, err := entriesSize(sst.Path())
+		if err != nil {
+			return nil, err
+		}
+
+		out := c.sstManager.NewSST(outLevel, SST_COMPACTING)
+		outputs = append(outputs, out)
+
+		// entries keep the write time bound of their SST
+		out.Timestamp = sst.Timestamp
+
+		footer, linked, err := linkSST(sst, size, out, sync)
+		if err != nil {
+			return nil, err
+		}
+
+		if linked {
+			moved = append(moved, movedSST{sst: sst, size: size, footer: footer, sync: sync})
+			sst.movedTo = out
+		} else if err := copySST(sst.Path(), size, out, sync); err != nil {
+			return nil, err
+		}
+
+		lo, hi, _ := sst.KeyRange()
+		out.setKeyRange(lo, hi)
+		out.entries.Store(sst.entries.Load())
+	}
+
+	return outputs, nil
+}
+
+// movedSST is an input of a move whose file was linked under
+// the name of its output, footer is the footer it replaced.
+type movedSST struct {
+	sst    *SST
+	size   int64
+	footer []byte
+	sync   bool
+}
+
+// restore writes back the footer of the input of a move that failed.
+func (m movedSST) restore() {
+	m.sst.movedTo = nil
+
+	f, err := os.OpenFile(m.sst.Path(), os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	writeSSTFooter(f, m.size, m.footer, m.sync)
+}
+
+// linkSST links the file of sst under the name of out and rewrites its
+// footer, after the size bytes of entries, to record the level and id of
+// out: the entries aren't written again. Both names share the file until
+// the cleaner removes the name of sst, a crash meanwhile leaves a name
+// whose footer records another SST, removed when the store opens, see
+// movedSSTName. Files also linked into checkpoints or snapshots keep
+// their footer, linkSST reports false and out is to be copied. It returns
+// the footer it replaced. It's called holding linkMu.
+func linkSST(sst *SST, size int64, out *SST, sync bool) ([]byte, bool, error) {
+	f, err := os.OpenFile(sst.Path(), os.O_RDWR, 0)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if n, ok := linkCount(info); !ok || n > 1 {
+		return nil, false, nil
+	}
+
+	footer := make([]byte, info.Size()-size)
+	if _, err := f.ReadAt(footer, size); err != nil {
+		return nil, false, err
+	}
+
+	old, err := parseSSTFooter(footer)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := os.Link(sst.Path(), out.Path()); err != nil {
+		return nil, false, diskWriteError(err)
+	}
+
+	// the checksum covers the entries only, it's kept
+	err = writeSSTFooter(f, size, sstFooter(out.ID, out.Level, out.Timestamp, out.cmp, old.Checksum, old.HasChecksum), sync)
+	if err == nil && sync {
+		err = syncDir(filepath.Dir(out.Path()))
+	}
+
+	if err != nil {
+		writeSSTFooter(f, size, footer, sync)
+		os.Remove(out.Path())
+		return nil, false, diskWriteError(err)
+	}
+
+	return footer, true, nil
+}
+
+// writeSSTFooter replaces the footer of the SST file f,
+// starting after the size bytes of its entries.
+func writeSSTFooter(f *os.File, size int64, footer []byte, sync bool) error {
+	if _, err := f.WriteAt(footer, size); err != nil {
+		return err
+	}
+
+	if err := f.Truncate(size + int64(len(footer))); err != nil {
+		return err
+	}
+
+	if sync {
+		return f.Sync()
+	}
+
+	return nil
+}
+
+// movedSSTName reports whether the SST file at path, whose footer records
+// level and id, is a name left by a move interrupted by a crash, see
+// linkSST: it's named after another SST than its footer records, and the
+// file is also named after the SST of its footer. It returns that other
+// name, the one the store keeps.
+func movedSSTName(path string, level int, id uint64) (string, bool) {
+	if l, i, ok := parseSSTFileName(filepath.Base(path)); ok && l == level && i == id {
+		return "", false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", false
+	}
+
+	names, err := filepath.Glob(filepath.Join(filepath.Dir(path), fmt.Sprintf("%d_%d_*%s", level, id, SSTFileFormat)))
+	if err != nil {
+		return "", false
+	}
+
+	for _, name := range names {
+		other, err := os.Stat(name)
+		if err == nil && name != path && os.SameFile(info, other) {
+			return filepath.Base(name), true
+		}
+	}
+
+	return "", false
+}
+
+// entriesSize returns the size of the entries of the SST file at path,
+// the offset of its footer.
+func entriesSize(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// the footer fits in the metadata read by parseSSTMetadata
+	tail := min(stat.Size(), 512)
+	buf := make([]byte, tail)
+	if _, err := f.ReadAt(buf, stat.Size()-tail); err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	i := bytes.LastIndex(buf, sstMetadataMarker)
+	if i < 0 {
+		return 0, ErrSSTIncomplete
+	}
+
+	return stat.Size() - tail + int64(i), nil
+}
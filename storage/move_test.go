@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactionMovesDisjointSSTs(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	sstManager, err := NewSSTManager(logger, dir, OPEN_FAST, nil)
+	require.NoError(t, err)
+
+	flush := func(keys ...string) {
+		memtable := NewMemtable(BytewiseComparator)
+		for _, key := range keys {
+			memtable.Set(key, key, false)
+		}
+		require.NoError(t, sstManager.FlushSST(memtable))
+	}
+
+	flush("a", "b")
+	flush("c", "d")
+
+	inputs := sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0)
+	input, err := os.Stat(inputs[0].Path())
+	require.NoError(t, err)
+
+	compactor := NewCompactor(logger, 0, sstManager)
+	require.True(t, compactor.movable(inputs, 1))
+	require.True(t, compactor.run(ctx, inputs, 1, false))
+
+	moved := sstManager.ListSST(1, []SSTState{SST_FLUSHED}, 0)
+	require.Len(t, moved, 2)
+
+	// the entries aren't written again, the output is the file of the input
+	output, err := os.Stat(moved[0].Path())
+	require.NoError(t, err)
+	if _, ok := linkCount(input); ok {
+		assert.True(t, os.SameFile(input, output))
+	}
+	assert.Equal(t, []SSTEntry{{Key: "a", Value: "a"}, {Key: "b", Value: "b"}}, readSST(t, moved[0]))
+	assert.Equal(t, []SSTEntry{{Key: "c", Value: "c"}, {Key: "d", Value: "d"}}, readSST(t, moved[1]))
+	assert.Equal(t, inputs[1].Timestamp, moved[1].Timestamp)
+
+	// the footer records the new level
+	sst, err := OpenSSTFile(moved[0].Path())
+	require.NoError(t, err)
+	assert.Equal(t, 1, sst.Level)
+	assert.Equal(t, moved[0].ID, sst.ID)
+
+	// b..c overlaps both SSTs of level 1
+	flush("b", "c")
+	inputs = sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0)
+	assert.False(t, compactor.movable(inputs, 1))
+	require.True(t, compactor.run(ctx, inputs, 1, false))
+
+	sstManager.clean()
+	reopened, err := NewSSTManager(logger, dir, OPEN_VERIFIED, nil)
+	require.NoError(t, err)
+	assert.Len(t, reopened.ListSST(1, []SSTState{SST_FLUSHED}, 0), 3)
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		data, err := reopened.QueryKey(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, key, data.Value)
+	}
+}
+
+func TestCompactionCopiesSharedSSTs(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+
+	sstManager, err := NewSSTManager(logger, dir, OPEN_FAST, nil)
+	require.NoError(t, err)
+
+	memtable := NewMemtable(BytewiseComparator)
+	memtable.Set("a", "a", false)
+	require.NoError(t, sstManager.FlushSST(memtable))
+
+	// the SST is also linked into a checkpoint, its footer must not change
+	inputs := sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0)
+	checkpoint := filepath.Join(t.TempDir(), inputs[0].FileName)
+	require.NoError(t, os.Link(inputs[0].Path(), checkpoint))
+
+	compactor := NewCompactor(logger, 0, sstManager)
+	require.True(t, compactor.run(context.Background(), inputs, 1, false))
+
+	moved := sstManager.ListSST(1, []SSTState{SST_FLUSHED}, 0)
+	require.Len(t, moved, 1)
+	assert.Equal(t, []SSTEntry{{Key: "a", Value: "a"}}, readSST(t, moved[0]))
+
+	input, err := os.Stat(checkpoint)
+	require.NoError(t, err)
+	output, err := os.Stat(moved[0].Path())
+	require.NoError(t, err)
+	assert.False(t, os.SameFile(input, output))
+
+	sst, err := OpenSSTFile(checkpoint)
+	require.NoError(t, err)
+	assert.Equal(t, 0, sst.Level)
+	assert.Equal(t, inputs[0].ID, sst.ID)
+}
+
+func TestOpenRemovesNamesOfInterruptedMoves(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+
+	sstManager, err := NewSSTManager(logger, dir, OPEN_FAST, nil)
+	require.NoError(t, err)
+
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	if _, ok := linkCount(info); !ok {
+		t.Skip("moves copy SSTs on this system")
+	}
+
+	for _, key := range []string{"a", "b"} {
+		memtable := NewMemtable(BytewiseComparator)
+		memtable.Set(key, key, false)
+		require.NoError(t, sstManager.FlushSST(memtable))
+	}
+
+	// the move was installed but its inputs not cleaned
+	inputs := sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0)
+	compactor := NewCompactor(logger, 0, sstManager)
+	require.True(t, compactor.run(context.Background(), inputs, 1, false))
+	moved := sstManager.ListSST(1, []SSTState{SST_FLUSHED}, 0)
+
+	// the link of a move interrupted before its footer was written
+	memtable := NewMemtable(BytewiseComparator)
+	memtable.Set("c", "c", false)
+	require.NoError(t, sstManager.FlushSST(memtable))
+	unmoved := sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0)[0]
+	require.NoError(t, os.Link(unmoved.Path(), filepath.Join(dir, "1_3_c.sst")))
+
+	report, err := VerifyDir(dir)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []Problem{
+		{File: "1_3_c.sst", Kind: PROBLEM_ORPHANED, Detail: "name of " + unmoved.FileName + " left by an interrupted move"},
+		{File: inputs[0].FileName, Kind: PROBLEM_ORPHANED, Detail: "name of " + moved[0].FileName + " left by an interrupted move"},
+		{File: inputs[1].FileName, Kind: PROBLEM_ORPHANED, Detail: "name of " + moved[1].FileName + " left by an interrupted move"},
+	}, report.Problems)
+
+	reopened, err := NewSSTManager(logger, dir, OPEN_VERIFIED, nil)
+	require.NoError(t, err)
+	assert.Len(t, reopened.ListSST(0, []SSTState{SST_FLUSHED}, 0), 1)
+	assert.Len(t, reopened.ListSST(1, []SSTState{SST_FLUSHED}, 0), 2)
+
+	for _, key := range []string{"a", "b", "c"} {
+		data, err := reopened.QueryKey(context.Background(), key)
+		require.NoError(t, err)
+		assert.Equal(t, key, data.Value)
+	}
+
+	report, err = VerifyDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, report.Problems)
+}
+
+func TestCompactionMergesOverlappingSSTs(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	sstManager, err := NewSSTManager(logger, t.TempDir(), OPEN_FAST, nil)
+	require.NoError(t, err)
+
+	for _, keys := range [][]string{{"a", "c"}, {"b"}} {
+		memtable := NewMemtable(BytewiseComparator)
+		for _, key := range keys {
+			memtable.Set(key, key, false)
+		}
+		require.NoError(t, sstManager.FlushSST(memtable))
+	}
+
+	compactor := NewCompactor(logger, 0, sstManager)
+	assert.False(t, compactor.movable(sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0), 1))
+
+	// dead entries are dropped by merging
+	ssts := sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 1)
+	assert.True(t, compactor.movable(ssts, 1))
+	ssts[0].markDead("a", 10)
+	assert.False(t, compactor.movable(ssts, 1))
+}
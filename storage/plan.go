@@ -0,0 +1,105 @@
+package storage
+
+// CompactionPlan is what the compactor of a level would do next.
+// Inputs is empty when no compaction is due.
+type CompactionPlan struct {
+	Level       int    `json:"level"`
+	OutputLevel int    `json:"output_level"`
+	Trigger     string `json:"trigger,omitempty"`
+
+	// Candidates are the flushed SSTs of the level the next compaction
+	// picks from, oldest first, and Threshold how many it waits for.
+	Candidates []string `json:"candidates"`
+	Threshold  int      `json:"threshold"`
+
+	// Inputs are the SSTs compacted, Overlaps the SSTs of the output
+	// level sharing keys with them. Inputs overlapping neither each
+	// other nor the output level are moved instead of merged.
+	Inputs   []string `json:"inputs"`
+	Overlaps []string `json:"overlaps"`
+	Move     bool     `json:"move"`
+
+	// InputBytes is the size of the inputs, EstimatedBytes the size
+	// written: the inputs without their known dead entries.
+	InputBytes     int64 `json:"input_bytes"`
+	EstimatedBytes int64 `json:"estimated_bytes"`
+}
+
+// compactionPlan returns the next compaction of every level in
+// level order, as its compactor would pick it now.
+func (s *SSTManager) compactionPlan() []CompactionPlan {
+	levels := s.Levels()
+
+	var res []CompactionPlan
+	for _, level := range levels {
+		c := NewCompactor(s.logger, level, s)
+
+		candidates := s.ListSST(level, []SSTState{SST_FLUSHED}, levelOptions(level).MaxFiles)
+		ssts, outLevel, trigger := c.next()
+
+		plan := CompactionPlan{
+			Level:       level,
+			OutputLevel: outLevel,
+			Trigger:     trigger,
+			Candidates:  sstNames(candidates),
+			Threshold:   c.compactionThreshold(candidates),
+			Inputs:      sstNames(ssts),
+			Overlaps:    []string{},
+		}
+
+		if len(ssts) > 0 {
+			plan.Overlaps = sstNames(s.overlapping(ssts, outLevel))
+			plan.Move = outLevel != level && c.movable(ssts, outLevel)
+		}
+
+		for _, sst := range ssts {
+			size := sst.fileSize()
+			plan.InputBytes += size
+			plan.EstimatedBytes += max(0, size-sst.deadBytes.Load())
+		}
+
+		res = append(res, plan)
+	}
+
+	return res
+}
+
+// overlapping returns the SSTs of level, inputs excluded, whose
+// key range shares keys with one of inputs.
+func (s *SSTManager) overlapping(inputs []*SST, level int) []*SST {
+	var res []*SST
+	for _, sst := range s.ListSST(level, []SSTState{SST_FLUSHING, SST_FLUSHED, SST_COMPACTING}, 0) {
+		lo, hi, err := sst.KeyRange()
+		if err != nil {
+			continue
+		}
+
+		for _, input := range inputs {
+			if input == sst {
+				continue
+			}
+
+			inLo, inHi, err := input.KeyRange()
+			if err != nil {
+				continue
+			}
+
+			if s.cmp.Compare(inLo, hi) <= 0 && s.cmp.Compare(lo, inHi) <= 0 {
+				res = append(res, sst)
+				break
+			}
+		}
+	}
+
+	return res
+}
+
+// sstNames returns the file names of ssts, never nil.
+func sstNames(ssts []*SST) []string {
+	names := make([]string, 0, len(ssts))
+	for _, sst := range ssts {
+		names = append(names, sst.FileName)
+	}
+
+	return names
+}
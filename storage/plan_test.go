@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactionPlan(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	// no SST is tiny enough to be merged within L0
+	defer func(size int64) { IntraL0FileSize = size }(IntraL0FileSize)
+	IntraL0FileSize = 0
+
+	sstManager, err := NewSSTManager(logger, t.TempDir(), OPEN_FAST, nil)
+	require.NoError(t, err)
+
+	flush := func(keys ...string) {
+		memtable := NewMemtable(BytewiseComparator)
+		for _, key := range keys {
+			memtable.Set(key, key, false)
+		}
+		require.NoError(t, sstManager.FlushSST(memtable))
+	}
+
+	flush("a", "b")
+	flush("c", "d")
+	require.NoError(t, sstManager.CompactAll(ctx, func(int, int) {}))
+
+	for i := range MAX_SST_PER_LEVEL - 1 {
+		flush(fmt.Sprint("a", i), "c")
+	}
+
+	plans := sstManager.compactionPlan()
+	require.Len(t, plans, 2)
+	assert.Equal(t, 0, plans[0].Level)
+	assert.Len(t, plans[0].Candidates, MAX_SST_PER_LEVEL-1)
+	assert.Equal(t, MAX_SST_PER_LEVEL, plans[0].Threshold)
+	assert.Empty(t, plans[0].Inputs)
+	assert.Empty(t, plans[0].Trigger)
+
+	flush("e")
+
+	plans = sstManager.compactionPlan()
+	plan := plans[0]
+	assert.Equal(t, TRIGGER_FILES, plan.Trigger)
+	assert.Equal(t, 1, plan.OutputLevel)
+	assert.Equal(t, plan.Candidates, plan.Inputs)
+	assert.False(t, plan.Move)
+	assert.Positive(t, plan.InputBytes)
+	assert.Equal(t, plan.InputBytes, plan.EstimatedBytes)
+
+	// a0 to c overlaps both SSTs of level 1
+	assert.Equal(t, sstNames(sstManager.ListSST(1, []SSTState{SST_FLUSHED}, 0)), plan.Overlaps)
+
+	assert.Equal(t, 1, plans[1].Level)
+	assert.Empty(t, plans[1].Inputs)
+	assert.Equal(t, []string{}, plans[1].Overlaps)
+}
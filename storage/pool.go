@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// maxPooledLineSize is the largest entry buffer kept for reuse,
+// buffers of larger entries are left to the garbage collector.
+const maxPooledLineSize = 64 << 10
+
+// readerPool recycles the buffered readers of SST files.
+var readerPool = sync.Pool{
+	New: func() any {
+		return bufio.NewReader(nil)
+	},
+}
+
+func getReader(r io.Reader) *bufio.Reader {
+	br := readerPool.Get().(*bufio.Reader)
+	br.Reset(r)
+
+	return br
+}
+
+func putReader(br *bufio.Reader) {
+	br.Reset(nil)
+	readerPool.Put(br)
+}
+
+// linePool recycles the buffers entries are read into,
+// parsed entries copy their key and value out of them.
+var linePool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 512)
+		return &b
+	},
+}
+
+// getLine returns a buffer of size bytes.
+func getLine(size int) *[]byte {
+	b := linePool.Get().(*[]byte)
+	if cap(*b) < size {
+		*b = make([]byte, size)
+	}
+	*b = (*b)[:size]
+
+	return b
+}
+
+func putLine(b *[]byte) {
+	if cap(*b) > maxPooledLineSize {
+		return
+	}
+
+	linePool.Put(b)
+}
+
+var iteratorPool = sync.Pool{
+	New: func() any {
+		return new(SSTIterator)
+	},
+}
+
+var kvEntryPool = sync.Pool{
+	New: func() any {
+		return new(kvEntry)
+	},
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// PurgeSchedule is when the bottom level of every store is purged,
+// nil never purges it. Compactions turn expired entries into tombstones
+// and never drop tombstones, older SSTs may still hold their keys. The
+// bottom level is only compacted once it holds enough SSTs, so they
+// could stay there for good.
+//
+// A purge rewrites the SSTs of the bottom level when they hold
+// expired entries or tombstones written before PurgeTombstoneAge, and
+// drops them: no SST is older. It runs in the compactor of the level,
+// between its compactions, and is skipped while the level above is
+// compacted into it.
+var PurgeSchedule *Schedule
+
+// PurgeTombstoneAge is how long tombstones are kept
+// at least, expired entries are purged right away.
+var PurgeTombstoneAge = 24 * time.Hour
+
+// schedulePurges asks the compactor of the bottom level
+// to purge it as scheduled, until ctx is done.
+func (c *CompactorManager) schedulePurges(ctx context.Context, schedule *Schedule) {
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			c.logger.Warn("purge schedule never matches")
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		level, ok := c.sstManager.bottomLevel()
+		if !ok {
+			continue
+		}
+
+		compactor := c.compactor(level)
+		if compactor == nil {
+			continue
+		}
+
+		select {
+		case compactor.purge <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// bottomLevel returns the deepest level holding SSTs, being compacted
+// into included, false when there is none.
+func (s *SSTManager) bottomLevel() (int, bool) {
+	levels := s.Levels()
+	slices.Reverse(levels)
+
+	for _, level := range levels {
+		if len(s.ListSST(level, []SSTState{SST_FLUSHED, SST_COMPACTING}, 0)) > 0 {
+			return level, true
+		}
+	}
+
+	return 0, false
+}
+
+// purgeOnce purges the level of the compactor if it's still the bottom
+// level and its SSTs hold entries to drop, it reports whether it did.
+func (c *Compactor) purgeOnce(ctx context.Context) bool {
+	c.sstManager.compactMu.RLock()
+	defer c.sstManager.compactMu.RUnlock()
+
+	if level, ok := c.sstManager.bottomLevel(); !ok || level != c.Level {
+		return false
+	}
+
+	ssts := c.sstManager.ListSST(c.Level, []SSTState{SST_FLUSHED}, 0)
+
+	purge, err := purgeable(ctx, ssts, c.sstManager.clock.Now())
+	if err != nil {
+		c.logger.Error("error reading SSTs to purge", "level", c.Level, "err", err)
+		return false
+	}
+
+	if !purge {
+		return false
+	}
+
+	c.logger.Info("purging bottom level", "level", c.Level, "inputs", len(ssts))
+
+	return c.run(ctx, ssts, c.Level, true)
+}
+
+// purgeable reports whether ssts hold expired entries at now or
+// tombstones written before PurgeTombstoneAge, read from their index.
+func purgeable(ctx context.Context, ssts []*SST, now time.Time) (bool, error) {
+	purgeBefore := now.Add(-PurgeTombstoneAge)
+
+	for _, sst := range ssts {
+		index, err := sst.indexed(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		for _, ie := range index {
+			if !ie.expiresAt.IsZero() && !now.Before(ie.expiresAt) {
+				return true, nil
+			}
+
+			if ie.isDeleted && sst.Timestamp.Before(purgeBefore) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurgeBottomLevel(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	sstManager, err := NewSSTManager(logger, t.TempDir(), OPEN_FAST, nil)
+	require.NoError(t, err)
+
+	clock := &fakeClock{now: time.Now()}
+	sstManager.clock = newClockGuard(logger, clock)
+
+	memtable := NewMemtable(BytewiseComparator)
+	memtable.Set("a", "1", false)
+	memtable.Set("b", "1", false)
+	memtable.Set("c", "1", false)
+	require.NoError(t, sstManager.FlushSST(memtable))
+
+	memtable = NewMemtable(BytewiseComparator)
+	memtable.SetWithExpiry("a", "2", clock.now.Add(time.Hour))
+	memtable.Delete("b")
+	require.NoError(t, sstManager.FlushSST(memtable))
+
+	require.NoError(t, sstManager.CompactAll(ctx, func(int, int) {}))
+
+	keys := func(level int) []string {
+		t.Helper()
+
+		flushed := sstManager.ListSST(level, []SSTState{SST_FLUSHED}, 0)
+		require.Len(t, flushed, 1)
+
+		var keys []string
+		it, err := flushed[0].Iterate()
+		require.NoError(t, err)
+		defer it.Close()
+
+		for ; it.Valid(); it.Next() {
+			keys = append(keys, it.Entry().Key)
+		}
+
+		return keys
+	}
+
+	assert.Equal(t, []string{"a", "b", "c"}, keys(1))
+
+	// nothing expired yet and the tombstone of b is too young
+	compactor := NewCompactor(logger, 1, sstManager)
+	assert.False(t, compactor.purgeOnce(ctx))
+
+	// a expired, b is still too young
+	clock.now = clock.now.Add(2 * time.Hour)
+	assert.True(t, compactor.purgeOnce(ctx))
+	assert.Equal(t, []string{"b", "c"}, keys(1))
+	assert.False(t, compactor.purgeOnce(ctx))
+
+	defer func() { PurgeTombstoneAge = 24 * time.Hour }()
+	PurgeTombstoneAge = 0
+
+	// only the bottom level is purged
+	assert.False(t, NewCompactor(logger, 0, sstManager).purgeOnce(ctx))
+
+	assert.True(t, compactor.purgeOnce(ctx))
+	assert.Equal(t, []string{"c"}, keys(1))
+
+	for _, key := range []string{"a", "b"} {
+		_, err := sstManager.QueryKey(ctx, key)
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+	}
+}
@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RepairAction is a change RepairDir made to a file of a store.
+type RepairAction struct {
+	File   string `json:"file"`
+	Action string `json:"action"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// RepairReport lists the changes of RepairDir
+// and the problems it couldn't resolve.
+type RepairReport struct {
+	Dir        string         `json:"dir"`
+	Actions    []RepairAction `json:"actions"`
+	Unresolved []Problem      `json:"unresolved"`
+}
+
+// RepairDir fixes the problems VerifyDir finds in the store in dir,
+// which must not be open. Damaged files are moved into QuarantineDir:
+//   - incomplete SSTs are dropped, their write never finished,
+//   - the entries of corrupted SSTs up to the corruption are rewritten
+//     in an SST of the same level and id, SSTs whose footer is unreadable
+//     are orphans, their entries are rewritten in a new SST on level 0,
+//   - the entries of SSTs out of order are sorted and rewritten,
+//     the last entry of a key written twice is kept,
+//   - SSTs named after another level or id are renamed after their footer,
+//   - snapshots left by an interrupted transfer are removed.
+//
+// SSTs sharing a level and id or ordered by another comparator
+// are left as they are, they are reported as unresolved.
+func RepairDir(dir string) (*RepairReport, error) {
+	verify, err := VerifyDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RepairReport{Dir: dir, Actions: []RepairAction{}}
+
+	kinds := make(map[string][]ProblemKind)
+	var files []string
+	for _, p := range verify.Problems {
+		if _, ok := kinds[p.File]; !ok {
+			files = append(files, p.File)
+		}
+
+		kinds[p.File] = append(kinds[p.File], p.Kind)
+	}
+
+	for _, file := range files {
+		kinds := kinds[file]
+		if slices.Contains(kinds, PROBLEM_COMPARATOR_MISMATCH) {
+			continue
+		}
+
+		var action RepairAction
+		switch {
+		case slices.Contains(kinds, PROBLEM_ORPHANED):
+			action, err = RepairAction{File: file, Action: "removed"}, os.RemoveAll(filepath.Join(dir, file))
+		case slices.Contains(kinds, PROBLEM_INCOMPLETE):
+			action, err = quarantine(dir, file, "incomplete")
+		case slices.Contains(kinds, PROBLEM_CORRUPTED), slices.Contains(kinds, PROBLEM_OUT_OF_ORDER):
+			action, err = salvageSST(dir, file)
+		case slices.Contains(kinds, PROBLEM_NAME_MISMATCH) && !slices.Contains(kinds, PROBLEM_DUPLICATE_ID):
+			action, err = renameSST(dir, file)
+		default:
+			continue
+		}
+
+		if err != nil {
+			return report, fmt.Errorf("repair %s: %w", file, err)
+		}
+
+		report.Actions = append(report.Actions, action)
+	}
+
+	verify, err = VerifyDir(dir)
+	if err != nil {
+		return report, err
+	}
+
+	report.Unresolved = verify.Problems
+
+	return report, nil
+}
+
+// quarantine moves file into the QuarantineDir of dir.
+func quarantine(dir string, file string, reason string) (RepairAction, error) {
+	if err := os.MkdirAll(filepath.Join(dir, QuarantineDir), 0755); err != nil {
+		return RepairAction{}, err
+	}
+
+	err := os.Rename(filepath.Join(dir, file), filepath.Join(dir, QuarantineDir, file))
+	return RepairAction{File: file, Action: "quarantined", Detail: reason}, err
+}
+
+// salvageSST rewrites the readable entries of an SST in key order
+// and quarantines the original.
+func salvageSST(dir string, file string) (RepairAction, error) {
+	sst, err := OpenSSTFile(filepath.Join(dir, file))
+	orphan := err != nil
+	if orphan {
+		// the entries may still be readable without the footer
+		sst, err = orphanSST(dir, file)
+		if err != nil {
+			return RepairAction{}, err
+		}
+	}
+
+	var entries []*SSTEntry
+	readErr := sst.ReadEntries(func(_ int64, entry *SSTEntry) bool {
+		entries = append(entries, entry)
+		return true
+	})
+
+	// the last entry of a key wins, like in the memtable
+	sort.SliceStable(entries, func(i, j int) bool {
+		return sst.cmp.Compare(entries[i].Key, entries[j].Key) < 0
+	})
+
+	var sorted []*SSTEntry
+	for _, entry := range entries {
+		if n := len(sorted); n > 0 && sst.cmp.Compare(sorted[n-1].Key, entry.Key) == 0 {
+			sorted[n-1] = entry
+			continue
+		}
+
+		sorted = append(sorted, entry)
+	}
+
+	if _, err := quarantine(dir, file, ""); err != nil {
+		return RepairAction{}, err
+	}
+
+	action := RepairAction{File: file, Action: "quarantined"}
+	if len(sorted) == 0 {
+		action.Detail = "no readable entries"
+		return action, nil
+	}
+
+	name := sstFileName(sst.Level, sst.ID)
+	if err := writeSSTFile(filepath.Join(dir, name), sorted, sst.ID, sst.Level, sst.Timestamp, sst.cmp); err != nil {
+		return RepairAction{}, err
+	}
+
+	action.Action = "salvaged"
+	action.Detail = fmt.Sprintf("%d entries rewritten to %s", len(sorted), name)
+	if readErr != nil {
+		action.Detail += ", the rest is unreadable"
+	}
+
+	if orphan {
+		action.Detail += ", the footer was unreadable, moved to level 0"
+	} else if readErr == nil && checkSSTFileChecksum(filepath.Join(dir, QuarantineDir, file)) != nil {
+		action.Detail += ", they don't match the checksum of the original, an entry may hold flipped bits"
+	}
+
+	return action, nil
+}
+
+// orphanSST returns an SST of the entries of file, whose footer can't be
+// read, as the newest SST of level 0 ordered by the comparator of the store.
+func orphanSST(dir string, file string) (*SST, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+SSTFileFormat))
+	if err != nil {
+		return nil, err
+	}
+
+	cmp := BytewiseComparator
+	var maxID uint64
+	for _, f := range files {
+		sst, err := OpenSSTFile(f)
+		if err != nil {
+			continue
+		}
+
+		cmp = sst.cmp
+		if sst.Level == 0 {
+			maxID = max(maxID, sst.ID)
+		}
+	}
+
+	return &SST{
+		ID:         maxID + 1,
+		FileName:   file,
+		Level:      0,
+		Timestamp:  time.Now(),
+		Comparator: cmp.Name(),
+		cmp:        cmp,
+		dir:        dir,
+	}, nil
+}
+
+// renameSST renames file after the level and id of its footer.
+func renameSST(dir string, file string) (RepairAction, error) {
+	sst, err := OpenSSTFile(filepath.Join(dir, file))
+	if err != nil {
+		return RepairAction{}, err
+	}
+
+	name := sstFileName(sst.Level, sst.ID)
+	if parts := strings.SplitN(file, "_", 3); len(parts) == 3 {
+		// keep the uuid
+		name = fmt.Sprintf("%d_%d_%s", sst.Level, sst.ID, parts[2])
+	}
+
+	if err := os.Rename(filepath.Join(dir, file), filepath.Join(dir, name)); err != nil {
+		return RepairAction{}, err
+	}
+
+	return RepairAction{File: file, Action: "renamed", Detail: "to " + name}, syncDir(dir)
+}
+
+func sstFileName(level int, id uint64) string {
+	return fmt.Sprintf("%d_%d_%s%s", level, id, uuid.New(), SSTFileFormat)
+}
+
+// writeSSTFile writes the entries, in key order, and the footer of an
+// SST to file. The file only appears once it is complete and synced.
+func writeSSTFile(file string, entries []*SSTEntry, id uint64, level int, timestamp time.Time, cmp Comparator) error {
+	tmp := file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0744)
+	if err != nil {
+		return diskWriteError(err)
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	writer := bufio.NewWriter(f)
+	sstWriter := newSSTWriter(writer)
+	for _, entry := range entries {
+		if err := writeSSTEntry(sstWriter, entry); err != nil {
+			return diskWriteError(err)
+		}
+	}
+
+	if err := writeSSTMetadata(sstWriter, id, level, timestamp, cmp); err != nil {
+		return diskWriteError(err)
+	}
+
+	if err := writer.Flush(); err != nil {
+		return diskWriteError(err)
+	}
+
+	if err := f.Sync(); err != nil {
+		return diskWriteError(err)
+	}
+
+	if err := f.Close(); err != nil {
+		return diskWriteError(err)
+	}
+
+	if err := os.Rename(tmp, file); err != nil {
+		return diskWriteError(err)
+	}
+
+	return diskWriteError(syncDir(filepath.Dir(file)))
+}
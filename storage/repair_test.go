@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairDir(t *testing.T) {
+	dir := t.TempDir()
+
+	encode := func(id uint64, level int, keys ...string) []byte {
+		var buf bytes.Buffer
+		w := newSSTWriter(&buf)
+		for _, key := range keys {
+			require.NoError(t, encodeSSTEntry(w, key, "v"+key, false, time.Time{}))
+		}
+
+		require.NoError(t, writeSSTMetadata(w, id, level, time.Now(), BytewiseComparator))
+		return buf.Bytes()
+	}
+
+	write := func(name string, data []byte) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0644))
+	}
+
+	write("0_1_a.sst", encode(1, 0, "a"))
+	write("1_4_b.sst", encode(4, 1, "c", "b", "c"))
+	write("0_2_c.sst", encode(2, 0, "d", "e")[:20])
+	write("0_9_d.sst", encode(3, 0, "f"))
+
+	// the footer of the orphan can't be read
+	orphan := encode(7, 0, "g")
+	orphan = append(orphan[:entrySize("g", "vg", time.Time{})+1], []byte("\n<metadata>\ntimestamp: broken\n<sst_done>")...)
+	write("0_7_e.sst", orphan)
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "snapshot-1"), 0755))
+
+	report, err := RepairDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, report.Unresolved)
+
+	actions := make(map[string]string)
+	for _, a := range report.Actions {
+		actions[a.File] = a.Action
+	}
+
+	assert.Equal(t, map[string]string{
+		"0_2_c.sst":  "quarantined",
+		"0_7_e.sst":  "salvaged",
+		"0_9_d.sst":  "renamed",
+		"1_4_b.sst":  "salvaged",
+		"snapshot-1": "removed",
+	}, actions)
+
+	quarantined, err := filepath.Glob(filepath.Join(dir, QuarantineDir, "*"))
+	require.NoError(t, err)
+	assert.Len(t, quarantined, 3)
+
+	// the salvaged SSTs are readable by a store
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	sstManager, err := NewSSTManager(logger, dir, OPEN_VERIFIED, nil)
+	require.NoError(t, err)
+	store := NewStore(logger, sstManager)
+
+	for key, value := range map[string]string{"a": "va", "b": "vb", "c": "vc", "f": "vf", "g": "vg"} {
+		data, err := store.Get(context.Background(), key)
+		require.NoError(t, err, key)
+		assert.Equal(t, value, data.Value)
+	}
+
+	level, id, ok := parseSSTFileName(filepath.Base(sstManager.levels[0].ssts[len(sstManager.levels[0].ssts)-1].FileName))
+	assert.True(t, ok)
+	assert.Equal(t, 0, level)
+	assert.Equal(t, uint64(4), id)
+}
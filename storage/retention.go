@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetentionInterval is how often retention rules are enforced.
+var RetentionInterval = time.Hour
+
+// RetentionRule deletes the keys under Prefix
+// that weren't written for MaxAge.
+type RetentionRule struct {
+	Prefix string
+	MaxAge time.Duration
+}
+
+// ParseRetentionRules parses prefix=age rules, e.g. "events/=30d".
+// Ages are Go durations, or a number of days with a "d" suffix.
+func ParseRetentionRules(rules []string) ([]RetentionRule, error) {
+	var res []RetentionRule
+	for _, r := range rules {
+		prefix, age, ok := strings.Cut(r, "=")
+		if !ok || age == "" {
+			return nil, fmt.Errorf("invalid retention rule %q, expected prefix=age", r)
+		}
+
+		maxAge, err := parseAge(age)
+		if err != nil || maxAge <= 0 {
+			return nil, fmt.Errorf("invalid retention age %q", age)
+		}
+
+		res = append(res, RetentionRule{
+			Prefix: prefix,
+			MaxAge: maxAge,
+		})
+	}
+
+	return res, nil
+}
+
+func parseAge(age string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(age, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(age)
+}
+
+// PrefixEnd returns the first key after every key starting with prefix,
+// or an empty string when there is none.
+func PrefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+
+	return ""
+}
+
+// expiredKeys returns the live keys under prefix whose newest version
+// was written before cutoff. SSTs are the time buckets: the timestamp
+// of an SST is the latest write time of its entries.
+func (l *LSM) expiredKeys(prefix string, cutoff time.Time) ([]string, error) {
+	sources, ssts, closeSources, err := l.readSources()
+	if err != nil {
+		return nil, err
+	}
+	defer closeSources()
+
+	// the memtables come before the ssts in sources
+	memtables := len(sources) - len(ssts)
+	end := PrefixEnd(prefix)
+
+	// keys under a prefix are only contiguous in bytewise order
+	bytewise := l.sstManager.cmp.Name() == BytewiseComparator.Name()
+
+	var keys []string
+	m := newMergeIterator(sources, l.sstManager.cmp)
+	for ; m.Valid(); m.Next() {
+		entry := m.Entry()
+		if bytewise && end != "" && entry.Key >= end {
+			break
+		}
+
+		if !strings.HasPrefix(entry.Key, prefix) {
+			continue
+		}
+
+		if entry.IsDeleted || entry.Value == "" || m.Source() < memtables {
+			continue
+		}
+
+		if ssts[m.Source()-memtables].Timestamp.Before(cutoff) {
+			keys = append(keys, entry.Key)
+		}
+	}
+
+	return keys, m.Err()
+}
+
+// StartRetention enforces rules every RetentionInterval until ctx is done,
+// and again right away after deleting keys.
+// There are no range tombstones, expired keys are deleted one by one.
+func (s *Store) StartRetention(ctx context.Context, rules []RetentionRule) {
+	if len(rules) == 0 {
+		return
+	}
+
+	runAdaptive[struct{}](ctx, RetentionInterval, RetentionInterval, nil, func() bool {
+		deleted := 0
+		for _, rule := range rules {
+			n, err := s.applyRetention(rule, s.Backend.sstManager.clock.Now())
+			if err != nil {
+				s.logger.Error("error applying retention", "prefix", rule.Prefix, "err", err)
+			}
+
+			deleted += n
+		}
+
+		return deleted > 0
+	})
+}
+
+// applyRetention deletes the keys expired by rule and returns how many.
+func (s *Store) applyRetention(rule RetentionRule, now time.Time) (int, error) {
+	keys, err := s.Backend.expiredKeys(rule.Prefix, now.Add(-rule.MaxAge))
+	if err != nil {
+		return 0, err
+	}
+
+	for i, key := range keys {
+		if err := s.deleteExpired(key); err != nil {
+			return i, err
+		}
+	}
+
+	if len(keys) > 0 {
+		s.logger.Info("deleted expired keys", "prefix", rule.Prefix, "keys", len(keys))
+	}
+
+	return len(keys), nil
+}
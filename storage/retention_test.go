@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRetentionRules(t *testing.T) {
+	rules, err := ParseRetentionRules([]string{"events/=30d", "logs/=12h"})
+	assert.NoError(t, err)
+	assert.Equal(t, []RetentionRule{
+		{Prefix: "events/", MaxAge: 30 * 24 * time.Hour},
+		{Prefix: "logs/", MaxAge: 12 * time.Hour},
+	}, rules)
+
+	_, err = ParseRetentionRules([]string{"events/"})
+	assert.Error(t, err)
+
+	_, err = ParseRetentionRules([]string{"events/=-1h"})
+	assert.Error(t, err)
+}
+
+func TestPrefixEnd(t *testing.T) {
+	assert.Equal(t, "events0", PrefixEnd("events/"))
+	assert.Equal(t, "b", PrefixEnd("a\xff"))
+	assert.Equal(t, "", PrefixEnd("\xff\xff"))
+}
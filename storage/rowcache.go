@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// RowCacheSize caps the bytes of keys and values read from SSTs kept
+// in memory by every store, zero disables the row cache.
+var RowCacheSize int64 = 8 << 20
+
+// NegativeCacheSize caps the bytes of keys every store remembers were
+// not found in the SSTs, zero disables the negative cache. It's kept
+// apart from the row cache, so probes of missing keys can't evict rows.
+var NegativeCacheSize int64 = 1 << 20
+
+// rowCacheMaxEntry is the fraction of the cache a single entry may
+// take, larger values would evict most of the cache for one key.
+const rowCacheMaxEntry = 8
+
+// CacheStats are the counters of the caches of a store.
+type CacheStats struct {
+	Rows     RowCacheStats `json:"rows"`
+	Negative RowCacheStats `json:"negative"`
+}
+
+// RowCacheStats are the counters of a cache of a store.
+type RowCacheStats struct {
+	Entries  int     `json:"entries"`
+	Bytes    int64   `json:"bytes"`
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+// rowCache keeps the entries of recently read keys found in SSTs, or
+// of keys not found in the negative cache, least recently used entries
+// are dropped first. Writes invalidate the key, entries read while a
+// write ran aren't added: readers take the generation before reading,
+// every invalidation bumps it.
+type rowCache struct {
+	mu      sync.Mutex
+	order   *list.List
+	keys    map[string]*list.Element
+	size    int64
+	maxSize int64
+	gen     uint64
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type rowEntry struct {
+	data KVData
+	size int64
+}
+
+// newRowCache returns a cache of maxSize bytes, or nil when disabled.
+// Keys are cached by their bytes, stores ordered by another comparator
+// than the bytewise one can't invalidate every spelling of a key and
+// don't cache.
+func newRowCache(maxSize int64, cmp Comparator) *rowCache {
+	if maxSize <= 0 || cmp.Name() != BytewiseComparator.Name() {
+		return nil
+	}
+
+	return &rowCache{
+		order:   list.New(),
+		keys:    make(map[string]*list.Element),
+		maxSize: maxSize,
+	}
+}
+
+// get returns a copy of the cached entry of key.
+func (c *rowCache) get(key string) (*KVData, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	e, ok := c.keys[key]
+	if ok {
+		c.order.MoveToFront(e)
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	c.hits.Add(1)
+	data := e.Value.(*rowEntry).data
+	return &data, true
+}
+
+// generation returns the generation to add entries read from now on with.
+func (c *rowCache) generation() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gen
+}
+
+// add caches data unless a write was invalidated since gen was taken.
+func (c *rowCache) add(data KVData, gen uint64) {
+	if c == nil {
+		return
+	}
+
+	size := int64(len(data.Key) + len(data.Value))
+	if size > c.maxSize/rowCacheMaxEntry {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+
+	if e, ok := c.keys[data.Key]; ok {
+		c.size -= e.Value.(*rowEntry).size
+		c.order.Remove(e)
+	}
+
+	c.keys[data.Key] = c.order.PushFront(&rowEntry{data: data, size: size})
+	c.size += size
+
+	for c.size > c.maxSize {
+		c.drop(c.order.Back())
+	}
+}
+
+// invalidate drops the entry of key, written after it was cached.
+// It must be called once the write is visible to readers.
+func (c *rowCache) invalidate(key string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if e, ok := c.keys[key]; ok {
+		c.drop(e)
+	}
+}
+
+// reset drops every entry, once writes of unknown keys are visible.
+func (c *rowCache) reset() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.order.Init()
+	clear(c.keys)
+	c.size = 0
+}
+
+// Must be called with mu held.
+func (c *rowCache) drop(e *list.Element) {
+	entry := e.Value.(*rowEntry)
+	c.size -= entry.size
+	c.order.Remove(e)
+	delete(c.keys, entry.data.Key)
+}
+
+func (c *rowCache) stats() RowCacheStats {
+	if c == nil {
+		return RowCacheStats{}
+	}
+
+	c.mu.Lock()
+	stats := RowCacheStats{
+		Entries: c.order.Len(),
+		Bytes:   c.size,
+	}
+	c.mu.Unlock()
+
+	stats.Hits = c.hits.Load()
+	stats.Misses = c.misses.Load()
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+
+	return stats
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRowCache(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store, err := Open(context.Background(), logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	require.NoError(t, store.Set("a", "1"))
+	require.NoError(t, store.Set("b", "2"))
+	_, err = store.Flush(context.Background())
+	require.NoError(t, err)
+
+	for range 3 {
+		data, err := store.Get(context.Background(), "a")
+		require.NoError(t, err)
+		assert.Equal(t, "1", data.Value)
+	}
+
+	stats := store.Caches().Rows
+	assert.Equal(t, 1, stats.Entries)
+	assert.EqualValues(t, 2, stats.Hits)
+	assert.EqualValues(t, 1, stats.Misses)
+
+	// writes invalidate the key
+	require.NoError(t, store.Set("a", "10"))
+	data, err := store.Get(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "10", data.Value)
+
+	_, err = store.Get(context.Background(), "b")
+	require.NoError(t, err)
+	require.NoError(t, store.Write([]BatchOp{{Type: BATCH_PUT, Key: "b", Value: "20"}}))
+	assert.Zero(t, store.Caches().Rows.Entries)
+
+	data, err = store.Get(context.Background(), "b")
+	require.NoError(t, err)
+	assert.Equal(t, "20", data.Value)
+}
+
+func TestRowCacheGeneration(t *testing.T) {
+	c := newRowCache(1<<10, BytewiseComparator)
+
+	// an entry read before a write isn't added after it
+	gen := c.generation()
+	c.invalidate("a")
+	c.add(KVData{Key: "a", Value: "old"}, gen)
+	_, ok := c.get("a")
+	assert.False(t, ok)
+
+	c.add(KVData{Key: "a", Value: "new"}, c.generation())
+	data, ok := c.get("a")
+	require.True(t, ok)
+	assert.Equal(t, "new", data.Value)
+
+	// the least recently read entries are dropped over the size
+	for _, key := range []string{"b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		c.add(KVData{Key: key, Value: string(make([]byte, 120))}, c.generation())
+		c.get("a")
+	}
+
+	_, ok = c.get("a")
+	assert.True(t, ok)
+	_, ok = c.get("b")
+	assert.False(t, ok)
+	assert.LessOrEqual(t, c.stats().Bytes, int64(1<<10))
+
+	assert.Nil(t, newRowCache(1<<10, NumericComparator))
+	assert.Nil(t, newRowCache(0, BytewiseComparator))
+}
+
+func TestNegativeCache(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store, err := Open(context.Background(), logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	require.NoError(t, store.Set("a", "1"))
+	_, err = store.Flush(context.Background())
+	require.NoError(t, err)
+
+	for range 3 {
+		_, err := store.Get(context.Background(), "missing")
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+	}
+
+	stats := store.Caches().Negative
+	assert.Equal(t, 1, stats.Entries)
+	assert.EqualValues(t, 2, stats.Hits)
+
+	// a write of the key is found right away
+	require.NoError(t, store.Set("missing", "found"))
+	assert.Zero(t, store.Caches().Negative.Entries)
+
+	data, err := store.Get(context.Background(), "missing")
+	require.NoError(t, err)
+	assert.Equal(t, "found", data.Value)
+}
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Intervals of the cleaner, see runAdaptive.
+const (
+	CleanerMinInterval = time.Second
+	CleanerMaxInterval = time.Minute
+)
+
+// idleBackoff spaces out the runs of a background task: a busy run is
+// followed right away, idle runs by an interval doubling from min to max.
+type idleBackoff struct {
+	min      time.Duration
+	max      time.Duration
+	interval time.Duration
+}
+
+func newIdleBackoff(min time.Duration, max time.Duration) *idleBackoff {
+	return &idleBackoff{
+		min: min,
+		max: max,
+	}
+}
+
+// next returns the wait before the next run, given whether the last one found work.
+func (b *idleBackoff) next(busy bool) time.Duration {
+	if busy {
+		b.interval = 0
+		return 0
+	}
+
+	if b.interval == 0 {
+		b.interval = b.min
+	} else {
+		b.interval = min(2*b.interval, b.max)
+	}
+
+	return b.interval
+}
+
+// runAdaptive runs fn until ctx is done, waiting between runs as
+// scheduled by an idleBackoff or until wake is signaled, nil never is.
+// fn reports whether it found work.
+func runAdaptive[T any](ctx context.Context, minInterval time.Duration, maxInterval time.Duration, wake <-chan T, fn func() bool) {
+	backoff := newIdleBackoff(minInterval, maxInterval)
+
+	for {
+		wait := backoff.next(fn())
+		if wait == 0 {
+			if ctx.Err() != nil {
+				return
+			}
+
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-wake:
+			timer.Stop()
+		}
+	}
+}
+
+// Schedule is a cron schedule: minute, hour, day of month, month and
+// day of week fields, e.g. "0 3 * * *" every day at 03:00. Fields are
+// "*", numbers, ranges "1-5" and lists "1,15", stepped with "/",
+// e.g. "*/15". "@hourly", "@daily" and "@weekly" are shorthands.
+// Times are matched in their own location.
+type Schedule struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+
+	// anyDay and anyWeekday are set for "*" fields, when both days
+	// fields are restricted a time matching either of them matches.
+	anyDay     bool
+	anyWeekday bool
+}
+
+var scheduleShorthands = map[string]string{
+	"@hourly": "0 * * * *",
+	"@daily":  "0 0 * * *",
+	"@weekly": "0 0 * * 0",
+}
+
+// ParseSchedule parses a cron spec, an empty spec or "off"
+// is a nil schedule.
+func ParseSchedule(spec string) (*Schedule, error) {
+	if spec == "" || spec == "off" {
+		return nil, nil
+	}
+
+	if expanded, ok := scheduleShorthands[spec]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q, expected 5 fields", spec)
+	}
+
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseScheduleField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+
+		sets[i] = set
+	}
+
+	return &Schedule{
+		minutes:    sets[0],
+		hours:      sets[1],
+		days:       sets[2],
+		months:     sets[3],
+		weekdays:   sets[4],
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}, nil
+}
+
+// parseScheduleField returns the values of field between lo and hi as a bit set.
+func parseScheduleField(field string, lo int, hi int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, stepped := strings.Cut(part, "/")
+
+		step := 1
+		if stepped {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+			step = n
+		}
+
+		from, to := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+
+			var err error
+			if from, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if stepped {
+				to = hi
+			}
+		}
+
+		if from < lo || to > hi || from > to {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, lo, hi)
+		}
+
+		for v := from; v <= to; v += step {
+			set |= 1 << v
+		}
+	}
+
+	return set, nil
+}
+
+// Next returns the first time after t the schedule matches, to the
+// minute. It's zero when no time does within five years, e.g. "0 0 30 2 *".
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case s.months&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hours&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minutes&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// matchDay reports whether the day of t matches the day of month
+// and day of week fields, either of them when both are restricted.
+func (s *Schedule) matchDay(t time.Time) bool {
+	day := s.days&(1<<t.Day()) != 0
+	weekday := s.weekdays&(1<<int(t.Weekday())) != 0
+
+	if !s.anyDay && !s.anyWeekday {
+		return day || weekday
+	}
+
+	return day && weekday
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdleBackoff(t *testing.T) {
+	b := newIdleBackoff(time.Second, 5*time.Second)
+
+	var waits []time.Duration
+	for _, busy := range []bool{false, false, false, false, false, true, false} {
+		waits = append(waits, b.next(busy))
+	}
+
+	assert.Equal(t, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second,
+		0, time.Second,
+	}, waits)
+}
+
+func TestSchedule(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+
+		res, err := time.Parse("2006-01-02 15:04", s)
+		assert.NoError(t, err)
+		return res
+	}
+
+	// 2025-01-01 is a Wednesday
+	now := at("2025-01-01 12:30")
+
+	tests := []struct {
+		spec string
+		next string
+	}{
+		{"0 3 * * *", "2025-01-02 03:00"},
+		{"@daily", "2025-01-02 00:00"},
+		{"@hourly", "2025-01-01 13:00"},
+		{"*/20 * * * *", "2025-01-01 12:40"},
+		{"30 12 * * *", "2025-01-02 12:30"},
+		{"0 0 * * 0", "2025-01-05 00:00"},
+		{"0 0 15 * 5", "2025-01-03 00:00"},
+		{"0 0 1 3 *", "2025-03-01 00:00"},
+		{"15,45 9-10 * * 1-5", "2025-01-02 09:15"},
+	}
+
+	for _, test := range tests {
+		schedule, err := ParseSchedule(test.spec)
+		if assert.NoError(t, err, test.spec) {
+			assert.Equal(t, at(test.next), schedule.Next(now), test.spec)
+		}
+	}
+
+	never, err := ParseSchedule("0 0 30 2 *")
+	assert.NoError(t, err)
+	assert.True(t, never.Next(now).IsZero())
+
+	for _, spec := range []string{"", "off"} {
+		schedule, err := ParseSchedule(spec)
+		assert.NoError(t, err)
+		assert.Nil(t, schedule)
+	}
+
+	for _, spec := range []string{"0 3 * *", "60 * * * *", "* * * * 7", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		_, err := ParseSchedule(spec)
+		assert.Error(t, err, spec)
+	}
+}
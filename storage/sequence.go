@@ -0,0 +1,83 @@
+package storage
+
+// Sequencer numbers the writes of a store with the sequence of their
+// change in a changelog kept above the store, so the changes its flushes
+// made durable are known, see Store.SetSequencer.
+type Sequencer interface {
+	// Sequence returns the sequence of the change being written.
+	Sequence() uint64
+
+	// Flushed is called once a memtable of the store is written to an SST.
+	Flushed()
+
+	// DeleteExpired deletes a key expired by the retention or the
+	// eviction of the store, logged like the other changes.
+	DeleteExpired(key string) error
+}
+
+// SetSequencer numbers the writes of the store with seq, see
+// UnflushedSeq, and deletes the keys expired by its retention
+// and eviction through seq. Nil stops numbering them.
+func (s *Store) SetSequencer(seq Sequencer) {
+	s.Backend.mu.Lock()
+	defer s.Backend.mu.Unlock()
+
+	s.Backend.sequencer = seq
+}
+
+// UnflushedSeq returns the sequence of the first write still in a memtable,
+// false if the memtables hold no numbered write. The changes before it are
+// written to SSTs.
+func (s *Store) UnflushedSeq() (uint64, bool) {
+	return s.Backend.unflushedSeq()
+}
+
+// deleteExpired deletes a key expired by the retention or the eviction of
+// the store, through its sequencer when it has one: a delete missing from
+// the changelog would be undone by replaying the write of the key.
+func (s *Store) deleteExpired(key string) error {
+	s.Backend.mu.RLock()
+	seq := s.Backend.sequencer
+	s.Backend.mu.RUnlock()
+
+	if seq == nil {
+		return s.Delete(key)
+	}
+
+	return seq.DeleteExpired(key)
+}
+
+// active returns the active memtable for a write, numbered by the
+// sequence of the write when it's the first one of the memtable.
+func (l *LSM) active() *Memtable {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	l.number(l.Memtable)
+	return l.Memtable
+}
+
+// number numbers mt by the sequence of the write
+// being applied if it has none yet.
+// Must be called with mu held.
+func (l *LSM) number(mt *Memtable) {
+	if l.sequencer != nil && mt.seq.Load() == 0 {
+		mt.seq.CompareAndSwap(0, l.sequencer.Sequence())
+	}
+}
+
+// unflushedSeq returns the sequence of the oldest memtable not written
+// to an SST, false if none is numbered.
+func (l *LSM) unflushedSeq() (uint64, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, mt := range l.flushingMemtables {
+		if seq := mt.seq.Load(); seq != 0 {
+			return seq, true
+		}
+	}
+
+	seq := l.Memtable.seq.Load()
+	return seq, seq != 0
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSequencer numbers the writes from 1 up and logs the expired deletes.
+type fakeSequencer struct {
+	store *Store
+
+	mu      sync.Mutex
+	seq     uint64
+	flushes int
+	deletes []string
+}
+
+func (s *fakeSequencer) Sequence() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.seq + 1
+}
+
+func (s *fakeSequencer) Flushed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.flushes++
+}
+
+func (s *fakeSequencer) DeleteExpired(key string) error {
+	if err := s.store.Delete(key); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deletes = append(s.deletes, key)
+	return nil
+}
+
+// write sets key as the next change.
+func (s *fakeSequencer) write(t *testing.T, key string) {
+	require.NoError(t, s.store.Set(key, "1"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+}
+
+func TestUnflushedSeq(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store, err := Open(context.Background(), logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	seq := &fakeSequencer{store: store}
+	store.SetSequencer(seq)
+
+	_, ok := store.UnflushedSeq()
+	assert.False(t, ok)
+
+	// the first memtable is flushed in the background once full
+	for i := range MemtableSizeThreshold + 2 {
+		seq.write(t, string(rune('a'+i)))
+	}
+
+	require.Eventually(t, func() bool {
+		seq.mu.Lock()
+		defer seq.mu.Unlock()
+
+		return seq.flushes == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	unflushed, ok := store.UnflushedSeq()
+	require.True(t, ok)
+	assert.Equal(t, uint64(MemtableSizeThreshold+1), unflushed)
+
+	_, err = store.Flush(context.Background())
+	require.NoError(t, err)
+
+	_, ok = store.UnflushedSeq()
+	assert.False(t, ok)
+}
+
+func TestExpiredDeletesAreSequenced(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store, err := Open(context.Background(), logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	seq := &fakeSequencer{store: store}
+	store.SetSequencer(seq)
+
+	seq.write(t, "a")
+	seq.write(t, "b")
+	require.NoError(t, store.EnableEviction(3))
+
+	n, err := store.evict()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = store.Get(context.Background(), "a")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.Equal(t, []string{"a"}, seq.deletes)
+}
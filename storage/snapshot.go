@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Snapshot is a consistent copy of a store: hard links to its SSTs
+// and the newest memtable entry of every key not flushed yet.
+// The snapshot must be closed to remove the links.
+type Snapshot struct {
+	Dir     string
+	Files   []string
+	Entries []SSTEntry
+}
+
+func (s *Snapshot) Close() error {
+	return os.RemoveAll(s.Dir)
+}
+
+// Snapshot links the SSTs of the store into a snapshot directory
+// and copies its memtables. Writes must be paused by the caller
+// for the copy to be consistent.
+func (s *Store) Snapshot() (*Snapshot, error) {
+	return s.Backend.snapshot()
+}
+
+func (l *LSM) snapshot() (*Snapshot, error) {
+	snapshot := &Snapshot{
+		Dir: filepath.Join(l.sstManager.dir, "snapshot-"+uuid.NewString()),
+	}
+
+	if err := os.Mkdir(snapshot.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	var err error
+	snapshot.Files, snapshot.Entries, err = l.link(snapshot.Dir)
+	if err != nil {
+		snapshot.Close()
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// link hard-links the live SSTs into dir and returns their file names,
+// along with the newest entry of every key of the memtables.
+func (l *LSM) link(dir string) ([]string, []SSTEntry, error) {
+	sources, ssts, closeSources, err := l.readSources()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer closeSources()
+
+	// only the memtables are merged, the ssts are linked as they are
+	var entries []SSTEntry
+	memtables := sources[:len(sources)-len(ssts)]
+	m := newMergeIterator(memtables, l.sstManager.cmp)
+	for ; m.Valid(); m.Next() {
+		entries = append(entries, *m.Entry())
+	}
+
+	// moves only rewrite the footer of SSTs linked nowhere else
+	l.sstManager.linkMu.Lock()
+	defer l.sstManager.linkMu.Unlock()
+
+	var files []string
+	for _, sst := range ssts {
+		// the file of an SST moved meanwhile records its output
+		if sst.movedTo != nil {
+			sst = sst.movedTo
+		}
+
+		if err := os.Link(sst.Path(), filepath.Join(dir, sst.FileName)); err != nil {
+			return nil, nil, err
+		}
+
+		files = append(files, sst.FileName)
+	}
+
+	return files, entries, nil
+}
+
+// WriteSnapshotEntries writes the memtable entries of a snapshot
+// installed into dir as the newest level 0 SST of dir,
+// the entries are ordered by cmp.
+func WriteSnapshotEntries(dir string, entries []SSTEntry, cmp Comparator) error {
+	_, err := writeSnapshotEntries(dir, entries, cmp)
+	return err
+}
+
+// writeSnapshotEntries returns the name of the SST written,
+// empty when there are no entries.
+func writeSnapshotEntries(dir string, entries []SSTEntry, cmp Comparator) (string, error) {
+	if len(entries) == 0 {
+		return "", nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+SSTFileFormat))
+	if err != nil {
+		return "", err
+	}
+
+	var id uint64
+	for _, file := range files {
+		sst, err := parseSSTMetadata(file)
+		if err != nil {
+			return "", fmt.Errorf("parse %s: %w", file, err)
+		}
+
+		if sst.Level == 0 {
+			id = max(id, sst.ID)
+		}
+	}
+	id++
+
+	name := fmt.Sprintf("%d_%d_%s%s", 0, id, uuid.New(), SSTFileFormat)
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	writer := bufio.NewWriter(f)
+	sstWriter := newSSTWriter(writer)
+	for _, entry := range entries {
+		if err := writeSSTEntry(sstWriter, &entry); err != nil {
+			return "", err
+		}
+	}
+
+	if err := writeSSTMetadata(sstWriter, id, 0, time.Now(), cmp); err != nil {
+		return "", err
+	}
+
+	if err := writer.Flush(); err != nil {
+		return "", err
+	}
+
+	if err := f.Sync(); err != nil {
+		return "", err
+	}
+
+	return name, f.Close()
+}
@@ -2,31 +2,81 @@ package storage
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var ErrSSTEntryEOF error = errors.New("sst eof reached")
+var ErrSSTCorrupted error = errors.New("sst is corrupted")
+
+// sstMetadataMarker marks the end of the entries in an SST file.
+var sstMetadataMarker = []byte("\n<metadata>")
 
 // SST File Format
-// [TotalLength][KeyLength][Key][ValLength][Val][IsDeleted]
+// [TotalLength][KeyLength][Key][ValLength][Val][ExpiresAt][Versions][Flags]
+// ExpiresAt is only written for expiring entries, as unix nanoseconds.
+// Versions are only written for entries keeping their previous
+// versions, see versions.go. Flags are entryDeleted and entryVersioned.
 // ...
 // ...
 // <metadata>
 // level [level]
 // timestamp [creation timestamp]
+// comparator [comparator name]
+// id [id]
+// crc32c [CRC-32C of the entries, in hex]
 // <sst_done> (just a marker for marking that a sst is done made)
 
 type SSTEntry struct {
 	Key       string
 	Value     string
 	IsDeleted bool
+
+	// ExpiresAt is zero for entries that don't expire.
+	ExpiresAt time.Time `json:",omitzero"`
+
+	// Timestamp is the time the entry was written and Versions its
+	// previous versions, newest first. Both are only kept while
+	// versions are retained, see VersionsRetained.
+	Timestamp time.Time `json:",omitzero"`
+	Versions  []Version `json:",omitempty"`
+}
+
+// Flags of the last byte of an entry.
+const (
+	entryDeleted   byte = 1
+	entryVersioned byte = 2
+)
+
+// versioned reports whether the entry is written with its versions.
+func (e *SSTEntry) versioned() bool {
+	return !e.Timestamp.IsZero()
+}
+
+// size is the encoded length of the entry, without the newline.
+func (e *SSTEntry) size() int {
+	size := entrySize(e.Key, e.Value, e.ExpiresAt)
+	if e.versioned() {
+		size += versionsSize(e.Versions)
+	}
+
+	return size
+}
+
+// expired reports whether the entry expired at now.
+func (e *SSTEntry) expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt)
 }
 
 type SST struct {
@@ -35,52 +85,328 @@ type SST struct {
 	Level     int
 	Timestamp time.Time
 	Status    SSTState
+
+	// Comparator is the name of the comparator the keys are ordered by.
+	Comparator string
+
+	// Checksum is the CRC-32C of the entries of the file, SSTs
+	// written before checksums were recorded have none.
+	Checksum    uint32
+	HasChecksum bool
+
+	// cmp orders the keys of the SST.
+	cmp Comparator
+
+	// dir is the directory of the SST file.
+	dir string
+
+	// verifyOnce guards the lazy verification of SSTs
+	// loaded in OPEN_FAST mode, the result is kept in verifyErr.
+	verifyOnce sync.Once
+	verifyErr  error
+
+	// smallest and largest key of the SST, loaded once.
+	rangeOnce sync.Once
+	minKey    string
+	maxKey    string
+	rangeErr  error
+
+	// index of the keys of the SST, built on the first lookup.
+	// indexDone is closed once it's built.
+	indexOnce sync.Once
+	indexDone chan struct{}
+	index     sstIndex
+	indexErr  error
+
+	// entries is the number of entries of the SST,
+	// zero until it is written or indexed by this process.
+	entries atomic.Int64
+
+	// refs counts the reads using the SST outside of the lock of its
+	// level, its file is kept until they're done, see SSTManager.clean.
+	refs atomic.Int64
+
+	// dead holds the keys of the SST shadowed by newer SSTs and
+	// deadBytes the size of their entries, see garbage.go.
+	deadMu    sync.Mutex
+	dead      map[string]struct{}
+	deadBytes atomic.Int64
+
+	// replaced is set once the output of a compaction of the SST is
+	// readable, it no longer tells which entries of others are dead.
+	replaced atomic.Bool
+
+	// movedTo is the output of a move sharing the file of the SST, whose
+	// footer records it, see linkSST. It's set and read holding linkMu.
+	movedTo *SST
+
+	// size of the SST file, read once.
+	sizeOnce sync.Once
+	size     int64
+}
+
+// readable reports whether the SST is read: SSTs still being written
+// are left out, like compacted ones, covered by their output.
+// It's called under the lock of the level of the SST.
+func (s *SST) readable() bool {
+	return s.Status == SST_FLUSHED
+}
+
+// release ends a read of the SST, see SSTManager.sstsForRead.
+func (s *SST) release() {
+	s.refs.Add(-1)
+}
+
+// releaseSSTs ends the reads of ssts.
+func releaseSSTs(ssts []*SST) {
+	for _, sst := range ssts {
+		sst.release()
+	}
+}
+
+// Path returns the path of the SST file.
+func (s *SST) Path() string {
+	return path.Join(s.dir, s.FileName)
+}
+
+// Verify checks the integrity of the SST file.
+// The file is only read once, later calls return the cached result.
+func (s *SST) Verify() error {
+	s.verifyOnce.Do(func() {
+		s.verifyErr = verifySST(s.Path(), s.cmp)
+	})
+
+	return s.verifyErr
+}
+
+// markVerified skips verification for SSTs written by this process.
+func (s *SST) markVerified() {
+	s.verifyOnce.Do(func() {})
+}
+
+// KeyRange returns the smallest and largest key stored in the SST.
+// The range of SSTs not written by this process is read from the file once.
+func (s *SST) KeyRange() (string, string, error) {
+	s.rangeOnce.Do(func() {
+		it, err := s.Iterate()
+		if err != nil {
+			s.rangeErr = err
+			return
+		}
+		defer it.Close()
+
+		if it.Valid() {
+			s.minKey = it.Entry().Key
+		}
+
+		for ; it.Valid(); it.Next() {
+			s.maxKey = it.Entry().Key
+		}
+
+		s.rangeErr = it.Err()
+	})
+
+	return s.minKey, s.maxKey, s.rangeErr
+}
+
+// setKeyRange records the key range of an SST written by this process.
+func (s *SST) setKeyRange(minKey string, maxKey string) {
+	s.rangeOnce.Do(func() {
+		s.minKey = minKey
+		s.maxKey = maxKey
+	})
+}
+
+// FindKey looks key up in the index of the SST,
+// the file is only read for values too large to be inlined.
+func (s *SST) FindKey(ctx context.Context, key string) (*SSTEntry, error) {
+	ie, err := s.lookup(ctx, key)
+	if err != nil || ie == nil {
+		return nil, err
+	}
+
+	if ie.inline {
+		return &SSTEntry{
+			Key:       ie.key,
+			Value:     ie.value,
+			IsDeleted: ie.isDeleted,
+			ExpiresAt: ie.expiresAt,
+		}, nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return readEntryAt(s, ie.offset)
+}
+
+// lookup returns the index entry of key, nil if the SST doesn't hold it.
+func (s *SST) lookup(ctx context.Context, key string) (*indexEntry, error) {
+	index, err := s.indexed(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ie, ok := index.find(key, s.cmp)
+	if !ok {
+		return nil, nil
+	}
+
+	return ie, nil
 }
 
-func (s *SST) FindKey(key string) (*SSTEntry, error) {
-	f, err := os.Open(path.Join(baseDir, s.FileName))
+// indexed returns the index of the SST. The first call reads the whole
+// file to build it: calls done with ctx meanwhile stop waiting, the
+// index is still built.
+func (s *SST) indexed(ctx context.Context) (sstIndex, error) {
+	s.indexOnce.Do(func() {
+		s.indexDone = make(chan struct{})
+		go func() {
+			defer close(s.indexDone)
+			s.index, s.indexErr = buildIndex(s)
+			s.entries.Store(int64(len(s.index)))
+		}()
+	})
+
+	select {
+	case <-s.indexDone:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if s.indexErr != nil {
+		return nil, s.indexErr
+	}
+
+	return s.index, nil
+}
+
+// Iterate opens the SST file and returns an iterator
+// positioned at the first entry. The iterator must be closed.
+func (s *SST) Iterate() (*SSTIterator, error) {
+	if err := s.Verify(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(s.Path())
 	if err != nil {
 		return nil, err
 	}
 
+	it := iteratorPool.Get().(*SSTIterator)
+	it.file = f
+	it.reader = getReader(f)
+	it.Next()
+
+	return it, nil
+}
+
+// SSTIterator iterates the entries of an SST file in key order.
+type SSTIterator struct {
+	file   *os.File
+	reader *bufio.Reader
+	entry  *SSTEntry
+	err    error
+}
+
+func (i *SSTIterator) Valid() bool {
+	return i.entry != nil
+}
+
+func (i *SSTIterator) Next() {
+	i.entry = nil
+	if i.err != nil {
+		return
+	}
+
+	entry, err := readSSTEntry(i.reader)
+	if errors.Is(err, ErrSSTEntryEOF) {
+		return
+	}
+
+	if err != nil {
+		i.err = err
+		return
+	}
+
+	i.entry = entry
+}
+
+func (i *SSTIterator) Entry() *SSTEntry {
+	return i.entry
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (i *SSTIterator) Err() error {
+	return i.err
+}
+
+// Close closes the file and recycles the iterator,
+// it must not be used afterwards.
+func (i *SSTIterator) Close() error {
+	err := i.file.Close()
+
+	putReader(i.reader)
+	*i = SSTIterator{}
+	iteratorPool.Put(i)
+
+	return err
+}
+
+// ReadEntries calls fn with every entry of the SST file and its
+// offset, in file order, until fn returns false. Unlike Iterate the
+// file isn't verified first, entries before a corruption are read.
+// Errors are wrapped in ErrSSTCorrupted with the offset of the entry.
+func (s *SST) ReadEntries(fn func(offset int64, entry *SSTEntry) bool) error {
+	f, err := os.Open(s.Path())
+	if err != nil {
+		return err
+	}
 	defer f.Close()
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		// TODO: Binary search the file for key
-		entry, err := parseSSTLine(scanner.Bytes())
-		if err != nil && err != ErrSSTEntryEOF {
-			return nil, err
-		}
+	r := getReader(f)
+	defer putReader(r)
 
-		// return if SST EOF reached
+	var offset int64
+	for {
+		entry, err := readSSTEntry(r)
 		if errors.Is(err, ErrSSTEntryEOF) {
-			return nil, nil
+			return nil
 		}
 
-		if entry != nil && entry.Key == key {
-			return entry, nil
+		if err != nil {
+			return fmt.Errorf("%w at offset %d: %s", ErrSSTCorrupted, offset, err)
+		}
+
+		if !fn(offset, entry) {
+			return nil
 		}
-	}
 
-	return nil, nil
+		offset += int64(entry.size()) + 1
+	}
 }
 
-// Writes the SST Content to w
-func (s SST) DecodeSST(w io.Writer) error {
-	return nil
+func encodeSSTEntry(w io.Writer, key string, value string, isDeleted bool, expiresAt time.Time) error {
+	return writeSSTEntry(w, &SSTEntry{
+		Key:       key,
+		Value:     value,
+		IsDeleted: isDeleted,
+		ExpiresAt: expiresAt,
+	})
 }
 
-func encodeSSTEntry(w io.Writer, key string, value string, isDeleted bool) error {
-	keyBytes := []byte(key)
-	valBytes := []byte(value)
-	var isDeletedByte byte = 0
+// writeSSTEntry encodes entry with its versions, if any.
+func writeSSTEntry(w io.Writer, entry *SSTEntry) error {
+	keyBytes := []byte(entry.Key)
+	valBytes := []byte(entry.Value)
+	var flags byte = 0
 
-	if isDeleted {
-		isDeletedByte = 1
+	if entry.IsDeleted {
+		flags |= entryDeleted
 	}
 
-	totalLength := 4 + 4 + 4 + 1 + len(keyBytes) + len(valBytes)
+	totalLength := entry.size()
 
 	if err := binary.Write(w, binary.LittleEndian, uint32(totalLength)); err != nil {
 		return err
@@ -102,7 +428,20 @@ func encodeSSTEntry(w io.Writer, key string, value string, isDeleted bool) error
 		return err
 	}
 
-	if _, err := w.Write([]byte{isDeletedByte}); err != nil {
+	if !entry.ExpiresAt.IsZero() {
+		if err := binary.Write(w, binary.LittleEndian, entry.ExpiresAt.UnixNano()); err != nil {
+			return err
+		}
+	}
+
+	if entry.versioned() {
+		flags |= entryVersioned
+		if _, err := w.Write(appendVersions(nil, entry.Timestamp, entry.Versions)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.Write([]byte{flags}); err != nil {
 		return err
 	}
 
@@ -114,15 +453,47 @@ func encodeSSTEntry(w io.Writer, key string, value string, isDeleted bool) error
 	return nil
 }
 
-func writeSSTMetadata(w io.Writer, id uint64, level int, timestamp time.Time) error {
-	metadata := fmt.Sprintf("\n<metadata>\nlevel: %d\ntimestamp: %s\nid: %d\n<sst_done>", level, timestamp.Format(time.RFC3339), id)
-	if _, err := w.Write([]byte(metadata)); err != nil {
+// castagnoli is the table of the CRC-32C checksums of SST entries.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// sstWriter passes the entries of an SST to w and computes their
+// checksum, writeSSTMetadata records it in the footer.
+type sstWriter struct {
+	w   io.Writer
+	crc uint32
+}
+
+func newSSTWriter(w io.Writer) *sstWriter {
+	return &sstWriter{w: w}
+}
+
+func (w *sstWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.crc = crc32.Update(w.crc, castagnoli, p[:n])
+	return n, err
+}
+
+// writeSSTMetadata writes the footer of the SST whose entries were
+// written to w, with their checksum.
+func writeSSTMetadata(w *sstWriter, id uint64, level int, timestamp time.Time, cmp Comparator) error {
+	if _, err := w.w.Write(sstFooter(id, level, timestamp, cmp, w.crc, true)); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// sstFooter returns the footer of an SST, without the checksum
+// line for the entries of SSTs written before checksums.
+func sstFooter(id uint64, level int, timestamp time.Time, cmp Comparator, checksum uint32, hasChecksum bool) []byte {
+	footer := fmt.Sprintf("\n<metadata>\nlevel: %d\ntimestamp: %s\ncomparator: %s\nid: %d\n", level, timestamp.Format(time.RFC3339), cmp.Name(), id)
+	if hasChecksum {
+		footer += fmt.Sprintf("crc32c: %08x\n", checksum)
+	}
+
+	return []byte(footer + "<sst_done>")
+}
+
 func parseSSTLine(line []byte) (*SSTEntry, error) {
 	if len(line) == 0 {
 		return nil, ErrSSTEntryEOF
@@ -137,11 +508,16 @@ func parseSSTLine(line []byte) (*SSTEntry, error) {
 	var valLength uint32
 	var key string
 	var value string
-	var isDeletedByte byte
+	var flags byte
 
 	// first 4 bytes is the key length
 	totalLength = binary.LittleEndian.Uint32(line[0:4])
 
+	// entries are written with a trailing newline
+	if len(line) == int(totalLength)+1 && line[len(line)-1] == '\n' {
+		line = line[:len(line)-1]
+	}
+
 	if len(line) != int(totalLength) {
 		return nil, errors.New("data length is incorrect")
 	}
@@ -157,21 +533,61 @@ func parseSSTLine(line []byte) (*SSTEntry, error) {
 	// next valLength bytes is the value length
 	value = string(line[12+keyLength : 12+keyLength+valLength])
 
-	// last byte is the isDeleted
-	isDeletedByte = line[len(line)-1]
+	// last byte is the flags
+	flags = line[len(line)-1]
+	rest := line[12+keyLength+valLength : len(line)-1]
 
-	var isDeleted bool = false
-	if isDeletedByte == 1 {
-		isDeleted = true
+	var timestamp time.Time
+	var versions []Version
+	if flags&entryVersioned != 0 {
+		var err error
+		rest, timestamp, versions, err = parseVersions(rest)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// expiring entries hold 8 more bytes, the expiry timestamp
+	var expiresAt time.Time
+	switch len(rest) {
+	case 0:
+	case 8:
+		nanos := binary.LittleEndian.Uint64(rest)
+		expiresAt = time.Unix(0, int64(nanos))
+	default:
+		return nil, errors.New("data length is incorrect")
 	}
 
 	return &SSTEntry{
 		Key:       key,
 		Value:     value,
-		IsDeleted: isDeleted,
+		IsDeleted: flags&entryDeleted != 0,
+		ExpiresAt: expiresAt,
+		Timestamp: timestamp,
+		Versions:  versions,
 	}, nil
 }
 
+// OpenSSTFile reads the metadata of the SST file at path, which
+// isn't part of a store. Its entries are read with ReadEntries.
+func OpenSSTFile(file string) (*SST, error) {
+	sst, err := parseSSTMetadata(file)
+	if err != nil {
+		return nil, err
+	}
+
+	cmp, err := ParseComparator(sst.Comparator)
+	if err != nil {
+		return nil, err
+	}
+
+	sst.FileName = path.Base(file)
+	sst.dir = path.Dir(file)
+	sst.cmp = cmp
+
+	return sst, nil
+}
+
 func parseSSTMetadata(filename string) (*SST, error) {
 	f, err := os.Open(filename)
 	if err != nil {
@@ -206,11 +622,28 @@ func parseSSTMetadata(filename string) (*SST, error) {
 		return nil, err
 	}
 
-	// parse metadata from buffer
+	sst, err := parseSSTFooter(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	sst.FileName = filename
+
+	return sst, nil
+}
+
+// parseSSTFooter parses the metadata at the end of buf,
+// the end of an SST file.
+func parseSSTFooter(buf []byte) (*SST, error) {
 	lines := strings.Split(string(buf), "\n")
 	var level int
 	var ts time.Time
 	var id uint64
+	var checksum uint32
+	var hasChecksum bool
+
+	// SSTs written before comparators were recorded are bytewise
+	comparator := BytewiseComparator.Name()
 
 	if lines[len(lines)-1] != "<sst_done>" {
 		return nil, ErrSSTIncomplete
@@ -226,18 +659,138 @@ func parseSSTMetadata(filename string) (*SST, error) {
 				return nil, err
 			}
 			ts = parsed
+		} else if strings.HasPrefix(lines[i], "comparator: ") {
+			comparator = strings.TrimPrefix(lines[i], "comparator: ")
 		} else if strings.HasPrefix(lines[i], "id: ") {
 			fmt.Sscanf(lines[i], "id: %d", &id)
+		} else if strings.HasPrefix(lines[i], "crc32c: ") {
+			if _, err := fmt.Sscanf(lines[i], "crc32c: %x", &checksum); err != nil {
+				return nil, fmt.Errorf("%w: unreadable checksum %q", ErrSSTCorrupted, lines[i])
+			}
+			hasChecksum = true
 		} else {
 			break
 		}
 	}
 
 	return &SST{
-		ID:        id,
-		FileName:  filename,
-		Level:     level,
-		Timestamp: ts,
-		Status:    SST_FLUSHED,
+		ID:          id,
+		Level:       level,
+		Timestamp:   ts,
+		Status:      SST_FLUSHED,
+		Comparator:  comparator,
+		Checksum:    checksum,
+		HasChecksum: hasChecksum,
 	}, nil
 }
+
+// readSSTEntry reads the next entry from r.
+// ErrSSTEntryEOF is returned once the metadata footer is reached.
+func readSSTEntry(r *bufio.Reader) (*SSTEntry, error) {
+	head, err := r.Peek(len(sstMetadataMarker))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if bytes.Equal(head, sstMetadataMarker) {
+		return nil, ErrSSTEntryEOF
+	}
+
+	if len(head) < 4 {
+		return nil, ErrSSTIncomplete
+	}
+
+	// every entry is followed by a newline
+	totalLength := binary.LittleEndian.Uint32(head[0:4])
+	buf := getLine(int(totalLength) + 1)
+	defer putLine(buf)
+	line := *buf
+
+	_, err = io.ReadFull(r, line)
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return nil, ErrSSTIncomplete
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if line[totalLength] != '\n' {
+		return nil, errors.New("entry is not terminated")
+	}
+
+	return parseSSTLine(line[:totalLength])
+}
+
+// verifySST reads the whole SST file and checks that the metadata
+// footer is complete, the entries match its checksum, every entry can
+// be parsed and the keys are stored in ascending order of cmp.
+func verifySST(filename string, cmp Comparator) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.HasSuffix(data, []byte(SSTDoneMarker)) {
+		return ErrSSTIncomplete
+	}
+
+	if err := checkSSTChecksum(data); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(bytes.NewReader(data))
+
+	var lastKey *string
+	for {
+		entry, err := readSSTEntry(r)
+		if errors.Is(err, ErrSSTEntryEOF) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrSSTCorrupted, err)
+		}
+
+		if lastKey != nil && cmp.Compare(entry.Key, *lastKey) <= 0 {
+			return fmt.Errorf("%w: key %q is out of order", ErrSSTCorrupted, entry.Key)
+		}
+
+		lastKey = &entry.Key
+	}
+}
+
+// checkSSTFileChecksum compares the entries of the SST file
+// with the checksum of its footer.
+func checkSSTFileChecksum(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	return checkSSTChecksum(data)
+}
+
+// checkSSTChecksum compares the entries of data, an SST file, with the
+// checksum of its footer. SSTs without a checksum pass.
+func checkSSTChecksum(data []byte) error {
+	i := bytes.LastIndex(data, sstMetadataMarker)
+	if i < 0 {
+		return ErrSSTIncomplete
+	}
+
+	footer, err := parseSSTFooter(data[i:])
+	if err != nil {
+		return err
+	}
+
+	if !footer.HasChecksum {
+		return nil
+	}
+
+	if sum := crc32.Checksum(data[:i], castagnoli); sum != footer.Checksum {
+		return fmt.Errorf("%w: the entries have checksum %08x, the footer records %08x", ErrSSTCorrupted, sum, footer.Checksum)
+	}
+
+	return nil
+}
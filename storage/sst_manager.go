@@ -3,6 +3,9 @@ package storage
 import (
 	"bufio"
 	"context"
+	"distrikv/events"
+	"distrikv/pkg"
+	"distrikv/tracing"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -45,6 +48,34 @@ const (
 	SST_COMPACTED
 )
 
+type OpenMode int
+
+// Open Modes
+//
+// OPEN_FAST trusts the metadata footer of every SST on startup,
+// the entries of a file are verified lazily on its first read.
+//
+// OPEN_VERIFIED reads and verifies every SST before the manager is returned,
+// corrupted files are left out and not served.
+const (
+	OPEN_FAST OpenMode = iota
+
+	OPEN_VERIFIED
+)
+
+// ParseOpenMode parses the configured open mode,
+// an empty string defaults to OPEN_FAST.
+func ParseOpenMode(mode string) (OpenMode, error) {
+	switch mode {
+	case "", "fast":
+		return OPEN_FAST, nil
+	case "verified":
+		return OPEN_VERIFIED, nil
+	default:
+		return OPEN_FAST, fmt.Errorf("unknown open mode %q", mode)
+	}
+}
+
 type SSTLevel struct {
 	mu   sync.RWMutex
 	ssts []*SST
@@ -58,6 +89,10 @@ type SSTLevel struct {
 // that are used for compaction.
 type SSTManager struct {
 	logger *slog.Logger
+
+	// dir is the directory holding the SST files.
+	dir string
+
 	// mutex here will lock the whole manager and
 	// sst map even if updates are done on different levels.
 	// will probably have a better solution later.
@@ -70,50 +105,100 @@ type SSTManager struct {
 	// sorted by insertion timestamp, because SST are
 	// appended to the slice on insertion.
 	levels map[int]*SSTLevel
+
+	// cmp orders the keys of the store.
+	cmp Comparator
+
+	// clock timestamps SSTs and entries, it never goes backwards.
+	clock *clockGuard
+
+	// hotKeys samples the keys read from SSTs, compactors use it
+	// to compact levels covering heavily-read ranges first.
+	hotKeys *keySampler
+
+	// events receives flush and compaction notifications,
+	// compactors are woken up by them.
+	events *events.Bus
+
+	// health tracks disk write failures,
+	// repeated failures turn the store read-only.
+	health *diskHealth
+
+	// compactMu is held by compactions,
+	// exclusively by manual compactions.
+	compactMu sync.RWMutex
+
+	// linkMu is held while SSTs are linked into checkpoints and
+	// snapshots, and while moves rewrite the footer of unshared SSTs.
+	linkMu sync.Mutex
 }
 
+// NewSST adds a new SST in state to level, creating the level if needed.
+// Its ID is the next of the level, it's the newest SST of the level.
 func (s *SSTManager) NewSST(level int, state SSTState) *SST {
-	levels := s.GetLevels()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if !slices.Contains(levels, level) {
-		s.levels[level] = &SSTLevel{
+	sstLevel, ok := s.levels[level]
+	if !ok {
+		sstLevel = &SSTLevel{
 			ssts: make([]*SST, 0),
 		}
+		s.levels[level] = sstLevel
 	}
 
-	// increment level counter
-	s.levels[level].counter.Add(1)
-
 	// sstID is just a naming convention for SST Files.
 	// UUID is used to ensure there are no conflicting SST Filename.
-	sstID := s.levels[level].counter.Load()
+	sstID := sstLevel.counter.Add(1)
 	sstUUID := uuid.New()
 	sst := &SST{
-		ID:        sstID,
-		FileName:  fmt.Sprintf("%d_%d_%s%s", level, sstID, sstUUID, SSTFileFormat),
-		Level:     level,
-		Status:    state,
-		Timestamp: time.Now(),
+		ID:         sstID,
+		FileName:   fmt.Sprintf("%d_%d_%s%s", level, sstID, sstUUID, SSTFileFormat),
+		Level:      level,
+		Status:     state,
+		Timestamp:  s.clock.Now(),
+		Comparator: s.cmp.Name(),
+		cmp:        s.cmp,
+		dir:        s.dir,
 	}
+	sst.markVerified()
 
-	s.mu.Lock()
-	s.levels[level].mu.Lock()
-	s.levels[level].ssts = append(s.levels[level].ssts, sst)
-	s.levels[level].mu.Unlock()
+	sstLevel.mu.Lock()
+	sstLevel.ssts = append(sstLevel.ssts, sst)
+	sstLevel.mu.Unlock()
 
-	s.mu.Unlock()
 	return sst
 }
 
-func NewSSTManager(logger *slog.Logger) (*SSTManager, error) {
-	logger.Info("starting SST Manager")
+// NewSSTManager loads the SSTs of dir, keys are ordered by cmp,
+// nil is the bytewise comparator. SSTs written with another
+// comparator can't be merged with the store and fail the load.
+func NewSSTManager(logger *slog.Logger, dir string, mode OpenMode, cmp Comparator) (*SSTManager, error) {
+	if cmp == nil {
+		cmp = BytewiseComparator
+	}
+
+	logger = logger.With(pkg.ComponentKey, "storage.sst")
+	logger.Info("starting SST Manager", "dir", dir, "mode", mode, "comparator", cmp.Name())
 	// Load ssts here
-	files, err := filepath.Glob(fmt.Sprintf("%s/*%s", baseDir, SSTFileFormat))
+	files, err := filepath.Glob(fmt.Sprintf("%s/*%s", dir, SSTFileFormat))
 	if err != nil {
 		return nil, err
 	}
 
-	ssts := parseSSTFiles(logger, files)
+	ssts := parseSSTFiles(logger, dir, files)
+
+	for _, sst := range ssts {
+		if sst.Comparator != cmp.Name() {
+			return nil, fmt.Errorf("%w: %s is ordered by %s, the store by %s", ErrComparatorMismatch, sst.FileName, sst.Comparator, cmp.Name())
+		}
+
+		sst.cmp = cmp
+	}
+
+	if mode == OPEN_VERIFIED {
+		ssts = verifySSTFiles(logger, ssts)
+	}
 
 	sstm := make(map[int]*SSTLevel)
 
@@ -139,9 +224,21 @@ func NewSSTManager(logger *slog.Logger) (*SSTManager, error) {
 	}
 	logger.Info("found sst files", "count", len(ssts))
 
+	// new SSTs are never older than the ones written before
+	clock := newClockGuard(logger, SystemClock)
+	for _, sst := range ssts {
+		clock.observe(sst.Timestamp)
+	}
+
 	return &SSTManager{
-		logger: logger,
-		levels: sstm,
+		clock:   clock,
+		logger:  logger,
+		dir:     dir,
+		levels:  sstm,
+		cmp:     cmp,
+		hotKeys: newKeySampler(HotKeySampleRate),
+		events:  events.NewBus(),
+		health:  &diskHealth{logger: logger},
 	}, nil
 }
 
@@ -159,15 +256,20 @@ func (m *SSTManager) updateBatch(
 		queries[q.FileName] = state
 	}
 
-	m.levels[level].mu.Lock()
-	for idx, sst := range m.levels[level].ssts {
+	sstLevel, ok := m.levels[level]
+	if !ok {
+		return fmt.Errorf("level %d has no SSTs", level)
+	}
+
+	sstLevel.mu.Lock()
+	for idx, sst := range sstLevel.ssts {
 		newState, ok := queries[sst.FileName]
 		if ok {
-			m.levels[level].ssts[idx].Status = newState
+			sstLevel.ssts[idx].Status = newState
 		}
 	}
 
-	m.levels[level].mu.Unlock()
+	sstLevel.mu.Unlock()
 
 	return nil
 }
@@ -180,10 +282,16 @@ func (m *SSTManager) ListSST(
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	// levels without SSTs yet have none
+	sstLevel, ok := m.levels[level]
+	if !ok {
+		return nil
+	}
+
 	var res []*SST
-	m.levels[level].mu.RLock()
-	defer m.levels[level].mu.RUnlock()
-	for _, sst := range m.levels[level].ssts {
+	sstLevel.mu.RLock()
+	defer sstLevel.mu.RUnlock()
+	for _, sst := range sstLevel.ssts {
 		if slices.Contains(states, sst.Status) {
 			res = append(res, sst)
 
@@ -203,23 +311,28 @@ func (m *SSTManager) RemoveSST(
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.levels[level].mu.Lock()
-	defer m.levels[level].mu.Unlock()
+	sstLevel, ok := m.levels[level]
+	if !ok {
+		return
+	}
+
+	sstLevel.mu.Lock()
+	defer sstLevel.mu.Unlock()
 
 	var final []*SST
-	for _, sst := range m.levels[level].ssts {
+	for _, sst := range sstLevel.ssts {
 		if slices.Contains(ssts, sst) {
 			continue
 		}
 
 		final = append(final, sst)
 	}
-	m.levels[level].ssts = final
+	sstLevel.ssts = final
 }
 
 // SST file name format is
 // level_uuid.sst
-func parseSSTFiles(logger *slog.Logger, fileNames []string) []*SST {
+func parseSSTFiles(logger *slog.Logger, dir string, fileNames []string) []*SST {
 	var res []*SST
 	for _, n := range fileNames {
 
@@ -230,55 +343,129 @@ func parseSSTFiles(logger *slog.Logger, fileNames []string) []*SST {
 			continue
 		}
 		sst.FileName = path.Base(n)
+		sst.dir = dir
+
+		if name, ok := movedSSTName(n, sst.Level, sst.ID); ok {
+			logger.Warn("removing the name of an SST left by an interrupted move", "file", sst.FileName, "sst", name)
+			os.Remove(n)
+			continue
+		}
+
 		res = append(res, sst)
 	}
 
 	return res
 }
 
-// TODO: Restructure SST format to include tombstone and timestamp
+// verifySSTFiles fully reads every SST and
+// returns the ones that passed verification.
+func verifySSTFiles(logger *slog.Logger, ssts []*SST) []*SST {
+	start := time.Now()
+
+	var res []*SST
+	for _, sst := range ssts {
+		if err := sst.Verify(); err != nil {
+			logger.Error("error verifying SST", "file", sst.FileName, "err", err)
+			continue
+		}
+
+		res = append(res, sst)
+	}
+
+	logger.Info("verified sst files", "count", len(res), "duration", time.Since(start))
+
+	return res
+}
+
+// FlushSST writes memtable to a new SST on level 0.
+// Write and sync failures are wrapped in ErrDiskWrite,
+// the partial SST is removed so the flush can be retried.
 func (s *SSTManager) FlushSST(memtable *Memtable) error {
+	start := time.Now()
 	sst := s.NewSST(0, SST_FLUSHING)
 
+	_, span := tracing.Start(
+		context.Background(),
+		"flush",
+		tracing.SPAN_KIND_INTERNAL,
+		tracing.Attr{Key: "distrikv.dir", Value: s.dir},
+		tracing.Attr{Key: "distrikv.level", Value: 0},
+		tracing.Attr{Key: "distrikv.file", Value: sst.FileName},
+		tracing.Attr{Key: "distrikv.entries", Value: memtable.Size()},
+	)
+	defer span.End()
+
+	err := s.writeFlushSST(sst, memtable)
+	s.health.record(err)
+	span.SetError(err)
+	if err != nil {
+		s.RemoveSST(0, []*SST{sst})
+		os.Remove(sst.Path())
+		return err
+	}
+
+	err = s.updateBatch(0, []*SST{sst}, SST_FLUSHED)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Debug("flushed memtable", "file", sst.FileName, "entries", memtable.Size(), "duration", time.Since(start))
+
+	s.events.Publish(events.TOPIC_FLUSH, events.FlushEvent{
+		Dir:      s.dir,
+		File:     sst.FileName,
+		Duration: time.Since(start),
+	})
+
+	return nil
+}
+
+// TODO: Restructure SST format to include tombstone and timestamp
+func (s *SSTManager) writeFlushSST(sst *SST, memtable *Memtable) error {
 	f, err := os.OpenFile(
-		path.Join(baseDir, sst.FileName),
-		os.O_APPEND|os.O_CREATE|os.O_SYNC|os.O_RDWR,
+		sst.Path(),
+		os.O_APPEND|os.O_CREATE|os.O_RDWR,
 		0744,
 	)
 	if err != nil {
-		return err
+		return diskWriteError(err)
 	}
 
 	defer f.Close()
 
 	writer := bufio.NewWriter(f)
+	sstWriter := newSSTWriter(writer)
 
 	// add stored data
+	var minKey, maxKey string
+	var entries int64
 	for i := memtable.Iterate(); i.Valid(); i.Next() {
-		err := encodeSSTEntry(writer, i.Data().Key, i.Data().Value, i.Data().Deleted)
+		err := writeSSTEntry(sstWriter, i.Data().sstEntry())
 		if err != nil {
-			return err
+			return diskWriteError(err)
 		}
-	}
-
-	writeSSTMetadata(writer, sst.ID, 0, time.Now())
 
-	err = writer.Flush()
-	if err != nil {
-		return err
+		if minKey == "" {
+			minKey = i.Data().Key
+		}
+		maxKey = i.Data().Key
+		entries++
 	}
+	sst.setKeyRange(minKey, maxKey)
+	sst.entries.Store(entries)
 
-	err = s.updateBatch(0, []*SST{sst}, SST_FLUSHED)
-	if err != nil {
-		return err
+	if err := writeSSTMetadata(sstWriter, sst.ID, 0, sst.Timestamp, s.cmp); err != nil {
+		return diskWriteError(err)
 	}
 
-	return nil
+	return finishSST(f, writer, s.dir)
 }
 
-func (s *SSTManager) GetLevels() []int {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// Levels returns the levels holding SSTs or that held some, in level
+// order. They can be sparse, e.g. SSTs ingested to a deeper level.
+func (s *SSTManager) Levels() []int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
 	var levels []int
 
@@ -286,75 +473,248 @@ func (s *SSTManager) GetLevels() []int {
 		levels = append(levels, level)
 	}
 
+	sort.Ints(levels)
+
 	return levels
 }
 
-func (s *SSTManager) QueryKey(key string) (*KVData, error) {
+// nextLevel returns the first level after level, false if there is none.
+// Reads walk the levels with it rather than with the levels listed first:
+// SSTs moved meanwhile to a level created by their compaction are read.
+func (s *SSTManager) nextLevel(level int) (int, *SSTLevel, bool) {
 	s.mu.RLock()
-	levels := s.levels
-	s.mu.RUnlock()
+	defer s.mu.RUnlock()
+
+	next := -1
+	for l := range s.levels {
+		if l > level && (next == -1 || l < next) {
+			next = l
+		}
+	}
+
+	if next == -1 {
+		return 0, nil, false
+	}
+
+	return next, s.levels[next], true
+}
+
+// QueryKey returns the newest entry of key held by the SSTs. Keys no
+// SST holds and keys whose newest entry is a delete are ErrKeyNotFound,
+// the search stops at the delete. Expired entries are returned.
+func (s *SSTManager) QueryKey(ctx context.Context, key string) (*KVData, error) {
+	sst, _, err := s.findKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if sst == nil {
+		return nil, ErrKeyNotFound
+	}
+	defer sst.release()
+
+	data, err := sst.FindKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil || data.IsDeleted || data.Value == "" {
+		return nil, ErrKeyNotFound
+	}
+
+	return &KVData{
+		Key:       data.Key,
+		Value:     data.Value,
+		IsDeleted: data.IsDeleted,
+		ExpiresAt: data.ExpiresAt,
+		Timestamp: sst.Timestamp,
+	}, nil
+}
+
+// findKey returns the SST holding the entry of key read by QueryKey
+// and its index entry, nil if no SST holds key. The SST found is
+// released by the caller.
+func (s *SSTManager) findKey(ctx context.Context, key string) (*SST, *indexEntry, error) {
+	s.hotKeys.Record(key)
+
+	var found *SST
+	var entry *indexEntry
+	err := s.eachSST(func(sst *SST) (bool, error) {
+		ie, err := sst.lookup(ctx, key)
+		if err != nil || ie == nil {
+			return err == nil, err
+		}
+
+		sst.refs.Add(1)
+		found, entry = sst, ie
+		return false, nil
+	})
+
+	return found, entry, err
+}
+
+// eachSST calls fn for the readable SSTs from the newest to the oldest
+// data, like sstsForRead, until fn returns false or an error: the first
+// SST holding a key has its latest version. fn runs under the lock of
+// the level of the SST, the cleaner doesn't remove it meanwhile.
+func (s *SSTManager) eachSST(fn func(*SST) (bool, error)) error {
+	for level, sstLevel, ok := s.nextLevel(-1); ok; level, sstLevel, ok = s.nextLevel(level) {
+		sstLevel.mu.RLock()
+		for i := len(sstLevel.ssts) - 1; i >= 0; i-- {
+			sst := sstLevel.ssts[i]
+			if !sst.readable() {
+				continue
+			}
+
+			more, err := fn(sst)
+			if err != nil || !more {
+				sstLevel.mu.RUnlock()
+				return err
+			}
+		}
+		sstLevel.mu.RUnlock()
+	}
+
+	return nil
+}
+
+// LevelStats is the number and size of the readable SSTs of a level.
+// DeadBytes are the bytes of their entries known to be shadowed.
+type LevelStats struct {
+	Level     int   `json:"level"`
+	Files     int   `json:"files"`
+	Bytes     int64 `json:"bytes"`
+	DeadBytes int64 `json:"dead_bytes"`
+}
+
+// levelStats returns the stats of every level in level order.
+func (s *SSTManager) levelStats() []LevelStats {
+	levels := s.Levels()
 
-	var data KVData
+	var res []LevelStats
 	for _, level := range levels {
-		level.mu.RLock()
+		stats := LevelStats{Level: level}
 
-		for _, sst := range level.ssts {
-			data, err := sst.FindKey(key)
+		for _, sst := range s.ListSST(level, []SSTState{SST_FLUSHED, SST_COMPACTING}, 0) {
+			info, err := os.Stat(sst.Path())
 			if err != nil {
-				level.mu.RUnlock()
-				return nil, err
-			}
-			if data != nil {
-				level.mu.RUnlock()
-				return &KVData{
-					Key:       data.Key,
-					Value:     data.Value,
-					IsDeleted: data.IsDeleted,
-				}, nil
+				// compacted meanwhile
+				continue
 			}
+
+			stats.Files++
+			stats.Bytes += info.Size()
+			stats.DeadBytes += sst.deadBytes.Load()
+		}
+
+		res = append(res, stats)
+	}
+
+	return res
+}
+
+// heat returns the sampled read count of the key ranges covered by ssts.
+func (s *SSTManager) heat(ssts []*SST) uint64 {
+	var heat uint64
+	for _, sst := range ssts {
+		minKey, maxKey, err := sst.KeyRange()
+		if err != nil {
+			s.logger.Error("error reading SST key range", "file", sst.FileName, "err", err)
+			continue
 		}
 
-		level.mu.RUnlock()
+		heat += s.hotKeys.Heat(minKey, maxKey, s.cmp)
 	}
 
-	return &data, nil
+	return heat
+}
+
+// sstsForRead returns the readable SSTs ordered from the newest
+// to the oldest data: lower levels first, newer files first within a level.
+// SSTs that are still being written or compacted are left out, the output
+// of a compaction is readable before its inputs are compacted. The SSTs
+// are kept until the caller releases them, see releaseSSTs.
+func (s *SSTManager) sstsForRead() []*SST {
+	var res []*SST
+	for level, sstLevel, ok := s.nextLevel(-1); ok; level, sstLevel, ok = s.nextLevel(level) {
+		sstLevel.mu.RLock()
+		for i := len(sstLevel.ssts) - 1; i >= 0; i-- {
+			sst := sstLevel.ssts[i]
+			if !sst.readable() {
+				continue
+			}
+
+			sst.refs.Add(1)
+			res = append(res, sst)
+		}
+		sstLevel.mu.RUnlock()
+	}
+
+	return res
 }
 
-// Cleans compacted sst
+// StartCleaner removes the files of compacted SSTs until ctx is done.
+// It runs after every compaction, again right away after removing files,
+// and backs off from CleanerMinInterval to CleanerMaxInterval while idle.
 func (s *SSTManager) StartCleaner(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			s.mu.RLock()
-			levels := s.levels
-			s.mu.RUnlock()
-
-			for level := range levels {
-				ssts := s.ListSST(
-					level,
-					[]SSTState{SST_COMPACTED},
-					MAX_SST_PER_LEVEL,
-				)
-
-				if len(ssts) < MAX_SST_PER_LEVEL {
-					break
-				}
-
-				s.RemoveSST(level, ssts)
-
-				// cleanup files
-				for _, sst := range ssts {
-					err := os.Remove(path.Join(baseDir, sst.FileName))
-					if err != nil {
-						s.logger.Error("error removing file", "file", sst.FileName, "err", err)
-					}
-				}
+	// the bus may be shared by several stores,
+	// a compaction of another one runs a cheap idle pass
+	sub := s.events.Subscribe(events.DefaultBuffer, events.TOPIC_COMPACTION)
+	defer sub.Close()
+
+	runAdaptive(ctx, CleanerMinInterval, CleanerMaxInterval, sub.C, s.clean)
+}
+
+// clean removes the files of compacted SSTs no read uses any longer,
+// of every level, and reports whether it did. The ones still read are
+// removed by a later pass.
+func (s *SSTManager) clean() bool {
+	removed := false
+	for _, level := range s.Levels() {
+		ssts := s.removeCompacted(level)
+		if len(ssts) == 0 {
+			continue
+		}
+
+		removed = true
+
+		// cleanup files
+		for _, sst := range ssts {
+			err := os.Remove(sst.Path())
+			if err != nil {
+				s.logger.Error("error removing file", "file", sst.FileName, "err", err)
+				continue
 			}
+
+			s.logger.Debug("removed compacted SST", "file", sst.FileName, "level", level)
 		}
 	}
+
+	return removed
+}
+
+// removeCompacted removes the compacted SSTs of level no read
+// uses from the level and returns them. Reads take their
+// references under the lock of the level, see sstsForRead.
+func (s *SSTManager) removeCompacted(level int) []*SST {
+	s.mu.RLock()
+	sstLevel := s.levels[level]
+	s.mu.RUnlock()
+
+	sstLevel.mu.Lock()
+	defer sstLevel.mu.Unlock()
+
+	var removed []*SST
+	var kept []*SST
+	for _, sst := range sstLevel.ssts {
+		if sst.Status == SST_COMPACTED && sst.refs.Load() == 0 {
+			removed = append(removed, sst)
+			continue
+		}
+
+		kept = append(kept, sst)
+	}
+
+	sstLevel.ssts = kept
+	return removed
 }
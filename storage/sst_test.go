@@ -2,10 +2,18 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestEncodeAndParseSSTEntry(t *testing.T) {
@@ -17,7 +25,7 @@ func TestEncodeAndParseSSTEntry(t *testing.T) {
 		IsDeleted: true,
 	}
 
-	err := encodeSSTEntry(&buf, original.Key, original.Value, original.IsDeleted)
+	err := encodeSSTEntry(&buf, original.Key, original.Value, original.IsDeleted, original.ExpiresAt)
 	assert.NoError(t, err)
 	fmt.Println(buf)
 
@@ -28,3 +36,159 @@ func TestEncodeAndParseSSTEntry(t *testing.T) {
 	assert.Equal(t, original.Value, parsed.Value)
 	assert.Equal(t, original.IsDeleted, parsed.IsDeleted)
 }
+
+func TestVerifySST(t *testing.T) {
+	var buf bytes.Buffer
+	w := newSSTWriter(&buf)
+
+	assert.NoError(t, encodeSSTEntry(w, "a", "1", false, time.Time{}))
+	assert.NoError(t, encodeSSTEntry(w, "b", "2", true, time.Time{}))
+	assert.NoError(t, writeSSTMetadata(w, 1, 0, time.Now(), BytewiseComparator))
+
+	valid := filepath.Join(t.TempDir(), "valid.sst")
+	assert.NoError(t, os.WriteFile(valid, buf.Bytes(), 0644))
+	assert.NoError(t, verifySST(valid, BytewiseComparator))
+
+	// flip a length byte of the first entry
+	corrupted := bytes.Clone(buf.Bytes())
+	corrupted[0] = 0xff
+	corruptedFile := filepath.Join(t.TempDir(), "corrupted.sst")
+	assert.NoError(t, os.WriteFile(corruptedFile, corrupted, 0644))
+	assert.ErrorIs(t, verifySST(corruptedFile, BytewiseComparator), ErrSSTCorrupted)
+
+	incompleteFile := filepath.Join(t.TempDir(), "incomplete.sst")
+	assert.NoError(t, os.WriteFile(incompleteFile, buf.Bytes()[:buf.Len()-3], 0644))
+	assert.ErrorIs(t, verifySST(incompleteFile, BytewiseComparator), ErrSSTIncomplete)
+
+	// a flipped bit in a value keeps the entry readable, not the checksum
+	flipped := bytes.Clone(buf.Bytes())
+	flipped[bytes.IndexByte(flipped, '1')] ^= 0x02
+	flippedFile := filepath.Join(t.TempDir(), "flipped.sst")
+	assert.NoError(t, os.WriteFile(flippedFile, flipped, 0644))
+	assert.ErrorIs(t, verifySST(flippedFile, BytewiseComparator), ErrSSTCorrupted)
+
+	sst, err := OpenSSTFile(flippedFile)
+	require.NoError(t, err)
+	assert.True(t, sst.HasChecksum)
+	require.NoError(t, sst.ReadEntries(func(int64, *SSTEntry) bool { return true }))
+
+	// SSTs written before checksums have none to compare
+	unchecked := bytes.Replace(flipped, []byte(fmt.Sprintf("crc32c: %08x\n", w.crc)), nil, 1)
+	uncheckedFile := filepath.Join(t.TempDir(), "unchecked.sst")
+	assert.NoError(t, os.WriteFile(uncheckedFile, unchecked, 0644))
+	assert.NoError(t, verifySST(uncheckedFile, BytewiseComparator))
+}
+
+func TestFindKey(t *testing.T) {
+	var buf bytes.Buffer
+	w := newSSTWriter(&buf)
+
+	large := strings.Repeat("x", InlineValueSize+1)
+	assert.NoError(t, encodeSSTEntry(w, "a", "1", false, time.Time{}))
+	assert.NoError(t, encodeSSTEntry(w, "b", large, false, time.Time{}))
+	assert.NoError(t, encodeSSTEntry(w, "c", "", true, time.Time{}))
+	assert.NoError(t, writeSSTMetadata(w, 1, 0, time.Now(), BytewiseComparator))
+
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "1.sst"), buf.Bytes(), 0644))
+	sst := &SST{FileName: "1.sst", dir: dir, cmp: BytewiseComparator}
+
+	entry, err := sst.FindKey(context.Background(), "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", entry.Value)
+	assert.True(t, sst.index[0].inline)
+
+	// large values are read from the file
+	entry, err = sst.FindKey(context.Background(), "b")
+	assert.NoError(t, err)
+	assert.Equal(t, large, entry.Value)
+	assert.False(t, sst.index[1].inline)
+
+	entry, err = sst.FindKey(context.Background(), "c")
+	assert.NoError(t, err)
+	assert.True(t, entry.IsDeleted)
+
+	entry, err = sst.FindKey(context.Background(), "d")
+	assert.NoError(t, err)
+	assert.Nil(t, entry)
+}
+
+func TestQueryKeyOrder(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	sstManager, err := NewSSTManager(logger, t.TempDir(), OPEN_FAST, nil)
+	assert.NoError(t, err)
+
+	flush := func(entries ...string) {
+		memtable := NewMemtable(BytewiseComparator)
+		for i := 0; i < len(entries); i += 2 {
+			memtable.Set(entries[i], entries[i+1], false)
+		}
+		assert.NoError(t, sstManager.FlushSST(memtable))
+	}
+
+	// level 1 holds the oldest versions
+	flush("a", "1", "b", "1")
+	assert.NoError(t, sstManager.CompactAll(context.Background(), func(int, int) {}))
+	flush("a", "2")
+	flush("a", "3", "c", "3")
+	flush("c", "4")
+
+	for key, value := range map[string]string{"a": "3", "b": "1", "c": "4"} {
+		res, err := sstManager.QueryKey(context.Background(), key)
+		assert.NoError(t, err)
+		assert.Equal(t, value, res.Value, key)
+	}
+
+	found := make(map[string]*KVData)
+	assert.NoError(t, sstManager.multiGet(context.Background(), []string{"a", "b", "c"}, found))
+	assert.Equal(t, "3", found["a"].Value)
+	assert.Equal(t, "1", found["b"].Value)
+	assert.Equal(t, "4", found["c"].Value)
+}
+
+func TestReadEntries(t *testing.T) {
+	var buf bytes.Buffer
+	w := newSSTWriter(&buf)
+
+	expiresAt := time.Unix(1700000000, 0)
+	assert.NoError(t, encodeSSTEntry(w, "a", "1", false, time.Time{}))
+	assert.NoError(t, encodeSSTEntry(w, "b", "2", false, expiresAt))
+	assert.NoError(t, encodeSSTEntry(w, "c", "", true, time.Time{}))
+	assert.NoError(t, writeSSTMetadata(w, 7, 2, time.Now(), NumericComparator))
+
+	file := filepath.Join(t.TempDir(), "2_7.sst")
+	assert.NoError(t, os.WriteFile(file, buf.Bytes(), 0644))
+
+	sst, err := OpenSSTFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(7), sst.ID)
+	assert.Equal(t, 2, sst.Level)
+	assert.Equal(t, NumericComparator.Name(), sst.Comparator)
+
+	var offsets []int64
+	var entries []SSTEntry
+	assert.NoError(t, sst.ReadEntries(func(offset int64, entry *SSTEntry) bool {
+		offsets = append(offsets, offset)
+		entries = append(entries, *entry)
+		return true
+	}))
+
+	assert.Equal(t, []int64{0, 16, 40}, offsets)
+	assert.Equal(t, expiresAt, entries[1].ExpiresAt)
+	assert.True(t, entries[2].IsDeleted)
+
+	// entries before a corruption are read
+	corrupted := bytes.Clone(buf.Bytes())
+	corrupted[16] = 0xff
+	assert.NoError(t, os.WriteFile(file, corrupted, 0644))
+
+	entries = nil
+	err = sst.ReadEntries(func(offset int64, entry *SSTEntry) bool {
+		entries = append(entries, *entry)
+		return true
+	})
+	assert.ErrorIs(t, err, ErrSSTCorrupted)
+	assert.ErrorContains(t, err, "offset 16")
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,116 @@
+package storage
+
+import "os"
+
+// Stats is a snapshot of the state of a store.
+type Stats struct {
+	// KeysEstimate counts the entries of the memtables and readable
+	// SSTs. Keys written several times are counted once per memtable
+	// or SST holding them, deleted keys while their tombstone lives.
+	// SSTs not read since startup are estimated from their size.
+	KeysEstimate int64 `json:"keys_estimate"`
+
+	// LiveBytes is the size of the readable SSTs, DiskBytes of
+	// every SST file, including compacted ones not removed yet.
+	LiveBytes int64 `json:"live_bytes"`
+	DiskBytes int64 `json:"disk_bytes"`
+
+	Levels   []LevelStats  `json:"levels"`
+	Memtable MemtableStats `json:"memtable"`
+
+	// PendingCompactions is the number of compactions the levels
+	// hold enough SSTs for, whether or not they are running.
+	PendingCompactions int `json:"pending_compactions"`
+
+	Caches CacheStats `json:"caches"`
+	Health Health     `json:"health"`
+}
+
+// MemtableStats are the entries in memory, not written to SSTs yet.
+type MemtableStats struct {
+	Entries   int `json:"entries"`
+	Threshold int `json:"threshold"`
+
+	// Flushing memtables are full and queued to be written.
+	Flushing        int `json:"flushing"`
+	FlushingEntries int `json:"flushing_entries"`
+}
+
+// Stats returns a snapshot of the state of the store.
+func (s *Store) Stats() (Stats, error) {
+	disk, err := s.DiskUsage()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	stats := Stats{
+		DiskBytes: disk,
+		Levels:    s.Levels(),
+		Memtable:  s.Backend.memtableStats(),
+		Caches:    s.Caches(),
+		Health:    s.Health(),
+	}
+
+	for _, level := range stats.Levels {
+		stats.LiveBytes += level.Bytes
+		stats.PendingCompactions += s.Backend.sstManager.pendingCompactions(level.Level)
+	}
+
+	stats.KeysEstimate = int64(stats.Memtable.Entries+stats.Memtable.FlushingEntries) + s.Backend.sstManager.keysEstimate()
+
+	return stats, nil
+}
+
+func (l *LSM) memtableStats() MemtableStats {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	stats := MemtableStats{
+		Entries:   l.Memtable.Size(),
+		Threshold: MemtableSizeThreshold,
+		Flushing:  len(l.flushingMemtables),
+	}
+
+	for _, mt := range l.flushingMemtables {
+		stats.FlushingEntries += mt.Size()
+	}
+
+	return stats
+}
+
+// pendingCompactions returns how many batches of
+// the MaxFiles flushed SSTs of its options level holds.
+func (s *SSTManager) pendingCompactions(level int) int {
+	return len(s.ListSST(level, []SSTState{SST_FLUSHED}, 0)) / levelOptions(level).MaxFiles
+}
+
+// keysEstimate returns the entries of the readable SSTs. SSTs whose
+// count isn't known are estimated at the average entry size of the
+// others, they don't count while no count is known.
+func (s *SSTManager) keysEstimate() int64 {
+	var entries, countedBytes, uncountedBytes int64
+	for _, level := range s.Levels() {
+		for _, sst := range s.ListSST(level, []SSTState{SST_FLUSHED, SST_COMPACTING}, 0) {
+			info, err := os.Stat(sst.Path())
+			if err != nil {
+				// compacted meanwhile
+				continue
+			}
+
+			n := sst.entries.Load()
+			if n == 0 {
+				uncountedBytes += info.Size()
+				continue
+			}
+
+			entries += n
+			countedBytes += info.Size()
+		}
+	}
+
+	if countedBytes > 0 {
+		entries += uncountedBytes * entries / countedBytes
+	}
+
+	return entries
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store, err := Open(context.Background(), logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	for i := range 12 {
+		require.NoError(t, store.Set(fmt.Sprintf("key-%02d", i), "value"))
+	}
+
+	_, err = store.Flush(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, store.Set("key-12", "value"))
+
+	stats, err := store.Stats()
+	require.NoError(t, err)
+
+	assert.EqualValues(t, 13, stats.KeysEstimate)
+	assert.Equal(t, 1, stats.Memtable.Entries)
+	assert.Equal(t, MemtableSizeThreshold, stats.Memtable.Threshold)
+	assert.Zero(t, stats.Memtable.Flushing)
+	assert.NotEmpty(t, stats.Levels)
+	assert.Positive(t, stats.LiveBytes)
+	assert.GreaterOrEqual(t, stats.DiskBytes, stats.LiveBytes)
+	assert.True(t, stats.Health.Healthy)
+}
@@ -1,6 +1,23 @@
 package storage
 
-import "log/slog"
+import (
+	"context"
+	"distrikv/events"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// ErrLocked is returned opening a store whose directory
+// is held by another process.
+var ErrLocked error = errors.New("data directory is locked by another process")
+
+// LockFile is the file of a store directory locked while it's open,
+// so two processes never write the same directory.
+const LockFile = "LOCK"
 
 // Store is expected to be
 // a layer of abstraction to the core storage.
@@ -9,18 +26,345 @@ import "log/slog"
 type Store struct {
 	logger  *slog.Logger
 	Backend *LSM
+
+	// cancel stops the background compaction and cleanup.
+	cancel     context.CancelFunc
+	compactors *CompactorManager
+
+	// closed rejects the writes to a closed store.
+	closed atomic.Bool
+
+	// lock holds the lock of the directory until Close.
+	lock *os.File
+
+	// locks serializes the writes of a key.
+	locks keyLocks
+
+	// access orders the keys by use in cache mode, nil otherwise.
+	access *accessIndex
+}
+
+func (s *Store) Set(key string, value string) error {
+	if s.closed.Load() {
+		return ErrReadOnly
+	}
+
+	if err := checkSize(key, value); err != nil {
+		return err
+	}
+
+	mu := s.locks.lock(key, s.Backend.sstManager.cmp)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := s.Backend.Set(key, value); err != nil {
+		return err
+	}
+
+	s.access.put(key, cachedSize(key, value))
+	return nil
+}
+
+// SetWithTTL stores key until ttl elapsed.
+func (s *Store) SetWithTTL(key string, value string, ttl time.Duration) error {
+	if s.closed.Load() {
+		return ErrReadOnly
+	}
+
+	if err := checkSize(key, value); err != nil {
+		return err
+	}
+
+	mu := s.locks.lock(key, s.Backend.sstManager.cmp)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := s.Backend.SetWithTTL(key, value, ttl); err != nil {
+		return err
+	}
+
+	s.access.put(key, cachedSize(key, value))
+	return nil
+}
+
+// Get returns the live entry of key, see LSM.Get.
+func (s *Store) Get(ctx context.Context, key string) (*KVData, error) {
+	data, err := s.Backend.Get(ctx, key)
+	s.recordRead(key, err)
+
+	return data, err
+}
+
+func (s *Store) MGet(ctx context.Context, keys []string) ([]*KVData, error) {
+	res, err := s.Backend.MGet(ctx, keys)
+	if err != nil || s.access == nil {
+		return res, err
+	}
+
+	for i, data := range res {
+		if data == nil {
+			s.recordRead(keys[i], ErrKeyNotFound)
+		} else {
+			s.recordRead(keys[i], nil)
+		}
+	}
+
+	return res, nil
 }
 
-func (s *Store) Set(key string, value string) {
-	s.Backend.Set(key, value)
+func (s *Store) GetValue(ctx context.Context, key string) (*ValueReader, error) {
+	value, err := s.Backend.GetValue(ctx, key)
+	s.recordRead(key, err)
+
+	return value, err
 }
 
-func (s *Store) Get(key string) (*KVData, error) {
-	return s.Backend.Get(key)
+// recordRead tracks a read of key in cache mode,
+// keys not found anymore have expired.
+func (s *Store) recordRead(key string, err error) {
+	switch {
+	case err == nil:
+		s.access.touch(key)
+	case errors.Is(err, ErrKeyNotFound):
+		s.access.remove(key)
+	}
 }
 
-func (s *Store) Delete(key string) {
-	s.Backend.Delete(key)
+func (s *Store) Delete(key string) error {
+	if s.closed.Load() {
+		return ErrReadOnly
+	}
+
+	mu := s.locks.lock(key, s.Backend.sstManager.cmp)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := s.Backend.Delete(key); err != nil {
+		return err
+	}
+
+	s.access.remove(key)
+	return nil
+}
+
+// Scan calls fn for every live key in [start, end) in ascending order
+// until fn returns false or ctx is done. An empty end scans to the last key.
+func (s *Store) Scan(ctx context.Context, start string, end string, fn func(*KVData) bool) error {
+	return s.Backend.Scan(ctx, start, end, fn)
+}
+
+// Stop rejects further writes and stops the background compaction and
+// cleanup of the store. Running compactions are aborted and keep their
+// inputs, Stop returns how many were aborted. The data of the memtables
+// and the lock of the directory are kept until Close.
+func (s *Store) Stop() int {
+	s.closed.Store(true)
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	if s.compactors == nil {
+		return 0
+	}
+
+	return s.compactors.Stop()
+}
+
+// Close stops the store, see Stop, flushes its memtables, waiting until
+// ctx is done, and releases the lock of its directory so another process
+// can open it. It returns the number of entries flushed. A store failing
+// to flush keeps the lock, its data would be lost to the next opener.
+func (s *Store) Close(ctx context.Context) (int, error) {
+	s.Stop()
+
+	entries, err := s.Flush(ctx)
+	if err != nil {
+		return entries, err
+	}
+
+	return entries, s.release()
+}
+
+// PauseCompactions stops the background compactions of the store until
+// ResumeCompactions, running ones are aborted and keep their inputs.
+// It returns once they're stopped. Manual compactions still run.
+func (s *Store) PauseCompactions() {
+	if s.compactors != nil {
+		s.compactors.Pause()
+	}
+}
+
+// ResumeCompactions resumes the background compactions paused
+// by PauseCompactions.
+func (s *Store) ResumeCompactions() {
+	if s.compactors != nil {
+		s.compactors.Resume()
+	}
+}
+
+// CompactionsPaused reports whether the background
+// compactions of the store are paused.
+func (s *Store) CompactionsPaused() bool {
+	return s.compactors != nil && s.compactors.Paused()
+}
+
+// release releases the lock of the directory of a stopped
+// store, once its memtables were flushed.
+func (s *Store) release() error {
+	if s.lock == nil {
+		return nil
+	}
+
+	err := s.lock.Close()
+	s.lock = nil
+	return err
+}
+
+// Flush writes the memtables to SSTs, waiting until ctx is done.
+// It returns the number of entries written.
+func (s *Store) Flush(ctx context.Context) (int, error) {
+	return s.Backend.Flush(ctx)
+}
+
+// CompactAll compacts every level of the store, see SSTManager.CompactAll.
+func (s *Store) CompactAll(ctx context.Context, progress func(done int, total int)) error {
+	return s.Backend.sstManager.CompactAll(ctx, progress)
+}
+
+// Dir returns the directory the store is kept in.
+func (s *Store) Dir() string {
+	return s.Backend.sstManager.dir
+}
+
+// DiskUsage returns the size of the SST files of the store.
+func (s *Store) DiskUsage() (int64, error) {
+	files, err := filepath.Glob(filepath.Join(s.Dir(), "*"+SSTFileFormat))
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if errors.Is(err, os.ErrNotExist) {
+			// compacted meanwhile
+			continue
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		size += info.Size()
+	}
+
+	return size, nil
+}
+
+// Levels returns the SST count and size of every level.
+func (s *Store) Levels() []LevelStats {
+	return s.Backend.sstManager.levelStats()
+}
+
+// CompactionPlan returns the next compaction of every level.
+func (s *Store) CompactionPlan() []CompactionPlan {
+	return s.Backend.sstManager.compactionPlan()
+}
+
+// Caches returns the counters of the row and negative caches of the store.
+func (s *Store) Caches() CacheStats {
+	return CacheStats{
+		Rows:     s.Backend.rowCache.stats(),
+		Negative: s.Backend.missCache.stats(),
+	}
+}
+
+// Health returns the disk health of the store.
+func (s *Store) Health() Health {
+	return s.Backend.sstManager.health.status()
+}
+
+// Open opens the store kept in dir and starts its
+// background compaction and cleanup, which run until ctx is done.
+// Flush and compaction events are published on bus,
+// keys are ordered by cmp, nil is the bytewise comparator.
+// Timestamps are read from clock, nil is the system clock.
+// dir is locked until Close, a dir locked by another process
+// fails with ErrLocked. Its files are checked first, see StartupCheck.
+func Open(
+	ctx context.Context,
+	logger *slog.Logger,
+	dir string,
+	mode OpenMode,
+	bus *events.Bus,
+	cmp Comparator,
+	clock Clock,
+) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	lock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// snapshots left over by a crash are no longer streamed
+	snapshots, err := filepath.Glob(filepath.Join(dir, "snapshot-*"))
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	for _, snapshot := range snapshots {
+		if err := os.RemoveAll(snapshot); err != nil {
+			lock.Close()
+			return nil, err
+		}
+	}
+
+	if err := checkDir(logger, dir); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	sstManager, err := NewSSTManager(logger, dir, mode, cmp)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	if bus != nil {
+		sstManager.events = bus
+	}
+
+	if clock != nil {
+		sstManager.clock.source = clock
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	go sstManager.StartCleaner(ctx)
+
+	if MinFreeSpace > 0 {
+		// systems whose free space can't be read never refuse writes
+		if err := sstManager.health.checkSpace(dir); err != nil {
+			logger.Warn("error checking free disk space, writes are not limited", "err", err)
+		} else {
+			go sstManager.health.monitorSpace(ctx, dir)
+		}
+	}
+
+	compactorManager := NewCompactorManager(logger, sstManager)
+	compactorManager.Start(ctx)
+
+	store := NewStore(logger, sstManager)
+	store.cancel = cancel
+	store.compactors = compactorManager
+	store.lock = lock
+
+	return &store, nil
 }
 
 func NewStore(
@@ -30,6 +374,7 @@ func NewStore(
 	lsmBackend := NewLSM(logger, sstManager)
 
 	return Store{
+		logger:  logger,
 		Backend: lsmBackend,
 	}
 }
@@ -0,0 +1,55 @@
+package storage
+
+import "context"
+
+// GetDel deletes key and returns the entry it held. Missing keys fail
+// with ErrKeyNotFound, concurrent GetDels of a key return it once.
+func (s *Store) GetDel(key string) (*KVData, error) {
+	if s.closed.Load() {
+		return nil, ErrReadOnly
+	}
+
+	mu := s.locks.lock(key, s.Backend.sstManager.cmp)
+	mu.Lock()
+	defer mu.Unlock()
+
+	current, err := s.Backend.Get(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Backend.Delete(key); err != nil {
+		return nil, err
+	}
+
+	s.access.remove(key)
+	return current, nil
+}
+
+// GetSet stores value as key and returns the entry it replaced, nil if
+// the key was missing. Like Set, the key no longer expires.
+func (s *Store) GetSet(key string, value string) (*KVData, error) {
+	if s.closed.Load() {
+		return nil, ErrReadOnly
+	}
+
+	if err := checkSize(key, value); err != nil {
+		return nil, err
+	}
+
+	mu := s.locks.lock(key, s.Backend.sstManager.cmp)
+	mu.Lock()
+	defer mu.Unlock()
+
+	current, err := s.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Backend.Set(key, value); err != nil {
+		return nil, err
+	}
+
+	s.access.put(key, cachedSize(key, value))
+	return current, nil
+}
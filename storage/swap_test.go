@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetDel(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	store, err := Open(context.Background(), logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	require.NoError(t, store.Set("job", "1"))
+
+	// a single caller gets the key
+	var got atomic.Int32
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := store.GetDel("job")
+			if err == nil {
+				assert.Equal(t, "1", res.Value)
+				got.Add(1)
+			} else {
+				assert.ErrorIs(t, err, ErrKeyNotFound)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), got.Load())
+
+	_, err = store.Get(context.Background(), "job")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestGetSet(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	store, err := Open(context.Background(), logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	res, err := store.GetSet("a", "1")
+	require.NoError(t, err)
+	assert.Nil(t, res)
+
+	require.NoError(t, store.SetWithTTL("a", "2", time.Hour))
+	res, err = store.GetSet("a", "3")
+	require.NoError(t, err)
+	assert.Equal(t, "2", res.Value)
+	assert.False(t, res.ExpiresAt.IsZero())
+
+	current, err := store.Get(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "3", current.Value)
+	assert.True(t, current.ExpiresAt.IsZero())
+}
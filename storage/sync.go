@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+)
+
+type SyncPolicy int
+
+// SST sync policies
+//
+// SYNC_ALWAYS syncs every SST written by a flush or a compaction, and
+// its directory, before the SST is read, so it survives a crash.
+//
+// SYNC_NEVER leaves them to the page cache, for bulk loads that can be
+// started over: a crash may lose or tear the SSTs written since the
+// operating system last wrote them back, compaction outputs included,
+// whose inputs are already removed.
+const (
+	SYNC_ALWAYS SyncPolicy = iota
+
+	SYNC_NEVER
+)
+
+// SSTSync is the sync policy of the SSTs written by flushes and
+// compactions. Ingested and repaired SSTs are always synced.
+var SSTSync = SYNC_ALWAYS
+
+func (p SyncPolicy) String() string {
+	if p == SYNC_NEVER {
+		return "nosync"
+	}
+
+	return "sync"
+}
+
+// ParseSyncPolicy parses the configured SST sync
+// policy, an empty string defaults to SYNC_ALWAYS.
+func ParseSyncPolicy(policy string) (SyncPolicy, error) {
+	switch policy {
+	case "", "sync":
+		return SYNC_ALWAYS, nil
+	case "nosync":
+		return SYNC_NEVER, nil
+	default:
+		return SYNC_ALWAYS, fmt.Errorf("unknown sst sync policy %q", policy)
+	}
+}
+
+// finishSST writes the buffered end of the SST f, syncs it and dir
+// as SSTSync asks and closes f. Errors are wrapped in ErrDiskWrite.
+func finishSST(f *os.File, w *bufio.Writer, dir string) error {
+	if err := w.Flush(); err != nil {
+		return diskWriteError(err)
+	}
+
+	if SSTSync == SYNC_ALWAYS {
+		if err := f.Sync(); err != nil {
+			return diskWriteError(err)
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return diskWriteError(err)
+	}
+
+	if SSTSync == SYNC_NEVER {
+		return nil
+	}
+
+	return diskWriteError(syncDir(dir))
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSSTSyncNever(t *testing.T) {
+	defer func() { SSTSync = SYNC_ALWAYS }()
+
+	policy, err := ParseSyncPolicy("nosync")
+	require.NoError(t, err)
+	SSTSync = policy
+
+	_, err = ParseSyncPolicy("fsync")
+	assert.Error(t, err)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	sstManager, err := NewSSTManager(logger, t.TempDir(), OPEN_FAST, nil)
+	require.NoError(t, err)
+
+	// unsynced SSTs are read like the others
+	for i := range 2 {
+		memtable := NewMemtable(BytewiseComparator)
+		memtable.Set("a", string(rune('0'+i)), false)
+		require.NoError(t, sstManager.FlushSST(memtable))
+	}
+
+	require.NoError(t, sstManager.CompactAll(context.Background(), func(int, int) {}))
+
+	flushed := sstManager.ListSST(1, []SSTState{SST_FLUSHED}, 0)
+	require.Len(t, flushed, 1)
+	require.NoError(t, flushed[0].Verify())
+
+	entry, err := flushed[0].FindKey(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", entry.Value)
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiringEntries(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	sstManager, err := NewSSTManager(logger, t.TempDir(), OPEN_FAST, nil)
+	assert.NoError(t, err)
+
+	// the expiry of the second entry holds a newline byte
+	expired := time.Unix(0, 0x0a0a).Add(time.Hour)
+	live := time.Now().Add(time.Hour)
+
+	for i := range MIN_SST_INTRA_L0 {
+		memtable := NewMemtable(BytewiseComparator)
+		memtable.SetWithExpiry("a", string(rune('0'+i)), live)
+		memtable.SetWithExpiry("b", "old", expired)
+		memtable.Set("c", "kept", false)
+		assert.NoError(t, sstManager.FlushSST(memtable))
+	}
+
+	entry, err := sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0)[0].FindKey(context.Background(), "b")
+	assert.NoError(t, err)
+	assert.True(t, entry.ExpiresAt.Equal(expired))
+
+	compactor := NewCompactor(logger, 0, sstManager)
+	assert.True(t, compactor.compactOnce(context.Background()))
+
+	// expired entries are compacted into tombstones
+	out := sstManager.ListSST(0, []SSTState{SST_FLUSHED}, 0)[0]
+
+	entry, err = out.FindKey(context.Background(), "a")
+	assert.NoError(t, err)
+	assert.True(t, entry.ExpiresAt.Equal(live))
+
+	entry, err = out.FindKey(context.Background(), "b")
+	assert.NoError(t, err)
+	assert.True(t, entry.IsDeleted)
+	assert.Empty(t, entry.Value)
+
+	lsm := NewLSM(logger, sstManager)
+
+	_, err = lsm.Get(context.Background(), "b")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	assert.ErrorIs(t, lsm.SetWithTTL("d", "soon", 0), ErrInvalidTTL)
+	assert.NoError(t, lsm.SetWithTTL("d", "soon", 20*time.Millisecond))
+
+	res, err := lsm.Get(context.Background(), "d")
+	assert.NoError(t, err)
+	assert.Equal(t, "soon", res.Value)
+
+	time.Sleep(30 * time.Millisecond)
+
+	_, err = lsm.Get(context.Background(), "d")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	var keys []string
+	assert.NoError(t, lsm.Scan(context.Background(), "", "", func(kv *KVData) bool {
+		keys = append(keys, kv.Key)
+		return true
+	}))
+	assert.Equal(t, []string{"a", "c"}, keys)
+}
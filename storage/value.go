@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ValueReader reads the value of a key without copying it into a string.
+// Values not inlined in an SST index are read straight from the SST file,
+// the reader must be closed.
+type ValueReader struct {
+	io.Reader
+	Key       string
+	Size      int64
+	ExpiresAt time.Time
+
+	// Timestamp is the version of the value, see KVData.Timestamp.
+	Timestamp time.Time
+
+	file *os.File
+}
+
+func (r *ValueReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+
+	return r.file.Close()
+}
+
+// GetValue looks key up like Get and returns a reader of its value.
+func (l *LSM) GetValue(ctx context.Context, key string) (*ValueReader, error) {
+	if data, ok := l.memtableEntry(key); ok {
+		if data.deleted() || l.isExpired(data.ExpiresAt) {
+			return nil, ErrKeyNotFound
+		}
+
+		return &ValueReader{
+			Reader:    strings.NewReader(data.Value),
+			Key:       data.Key,
+			Size:      int64(len(data.Value)),
+			ExpiresAt: data.ExpiresAt,
+			Timestamp: data.Timestamp,
+		}, nil
+	}
+
+	sst, ie, err := l.sstManager.findKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if sst == nil {
+		return nil, ErrKeyNotFound
+	}
+
+	// the file stays readable once opened
+	defer sst.release()
+
+	if ie.isDeleted || ie.valueLen == 0 || l.isExpired(ie.expiresAt) {
+		return nil, ErrKeyNotFound
+	}
+
+	if ie.inline {
+		return &ValueReader{
+			Reader:    strings.NewReader(ie.value),
+			Key:       ie.key,
+			Size:      ie.valueLen,
+			ExpiresAt: ie.expiresAt,
+			Timestamp: sst.Timestamp,
+		}, nil
+	}
+
+	f, r, err := openValue(sst, ie)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ValueReader{
+		Reader:    r,
+		Key:       ie.key,
+		Size:      ie.valueLen,
+		ExpiresAt: ie.expiresAt,
+		Timestamp: sst.Timestamp,
+		file:      f,
+	}, nil
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetValue(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	store, err := Open(context.Background(), logger, t.TempDir(), OPEN_FAST, nil, nil, nil)
+	assert.NoError(t, err)
+	defer store.Close(context.Background())
+
+	large := strings.Repeat("v", 4*InlineValueSize)
+	assert.NoError(t, store.Set("large", large))
+	assert.NoError(t, store.Set("small", "s"))
+	assert.NoError(t, store.Set("deleted", "d"))
+	assert.NoError(t, store.Delete("deleted"))
+
+	// fill the memtable so the keys are flushed
+	for i := range MemtableSizeThreshold {
+		assert.NoError(t, store.Set(fmt.Sprintf("fill-%d", i), "x"))
+	}
+	_, err = store.Flush(context.Background())
+	assert.NoError(t, err)
+
+	for key, want := range map[string]string{"large": large, "small": "s"} {
+		value, err := store.GetValue(context.Background(), key)
+		assert.NoError(t, err)
+
+		data, err := io.ReadAll(value)
+		assert.NoError(t, err)
+		assert.NoError(t, value.Close())
+
+		assert.Equal(t, want, string(data))
+		assert.Equal(t, int64(len(want)), value.Size)
+	}
+
+	_, err = store.GetValue(context.Background(), "deleted")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	_, err = store.GetValue(context.Background(), "missing")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// QuarantineDir is the directory of a store damaged files are moved to,
+// stores don't read it.
+const QuarantineDir = "quarantine"
+
+type ProblemKind string
+
+// Problems found by VerifyDir.
+const (
+	// PROBLEM_INCOMPLETE SSTs lack the done marker, their write
+	// was interrupted. Stores skip them.
+	PROBLEM_INCOMPLETE ProblemKind = "incomplete"
+
+	// PROBLEM_CORRUPTED SSTs have an unreadable footer or entry,
+	// or entries not matching the checksum of their footer.
+	PROBLEM_CORRUPTED ProblemKind = "corrupted"
+
+	// PROBLEM_OUT_OF_ORDER SSTs hold keys out of the order of their comparator.
+	PROBLEM_OUT_OF_ORDER ProblemKind = "out_of_order"
+
+	// PROBLEM_NAME_MISMATCH SSTs are named after another level or id than
+	// their footer records. Stores follow the footer.
+	PROBLEM_NAME_MISMATCH ProblemKind = "name_mismatch"
+
+	// PROBLEM_DUPLICATE_ID SSTs share their level and id with another SST,
+	// which of them is newer can't be told.
+	PROBLEM_DUPLICATE_ID ProblemKind = "duplicate_id"
+
+	// PROBLEM_COMPARATOR_MISMATCH SSTs are ordered by another comparator
+	// than most SSTs of the store, which then fails to open.
+	PROBLEM_COMPARATOR_MISMATCH ProblemKind = "comparator_mismatch"
+
+	// PROBLEM_ORPHANED files are left over by a crash and not used by the store.
+	PROBLEM_ORPHANED ProblemKind = "orphaned"
+)
+
+// Problem is an inconsistency of a file of a store.
+type Problem struct {
+	File   string      `json:"file"`
+	Kind   ProblemKind `json:"kind"`
+	Detail string      `json:"detail,omitempty"`
+}
+
+// Quarantinable reports whether the file can be moved away without
+// losing data the store would read. Other problems need a decision.
+func (p Problem) Quarantinable() bool {
+	switch p.Kind {
+	case PROBLEM_INCOMPLETE, PROBLEM_CORRUPTED, PROBLEM_OUT_OF_ORDER, PROBLEM_ORPHANED:
+		return true
+	default:
+		return false
+	}
+}
+
+// VerifyReport is the result of checking the files of a store,
+// Entries are only counted by VerifyDir.
+type VerifyReport struct {
+	Dir      string    `json:"dir"`
+	SSTs     int       `json:"ssts"`
+	Entries  int       `json:"entries"`
+	Problems []Problem `json:"problems"`
+}
+
+// VerifyDir reads every SST file of the store in dir, which must not be
+// open, and checks their footer, the checksum, framing and order of their
+// entries, that their names match their footer and that they agree on the
+// comparator.
+func VerifyDir(dir string) (*VerifyReport, error) {
+	return verifyDir(dir, true)
+}
+
+// CheckDir checks the files of the store in dir like VerifyDir, from
+// the footers of its SSTs only, without reading their entries.
+func CheckDir(dir string) (*VerifyReport, error) {
+	return verifyDir(dir, false)
+}
+
+// verifyDir checks the files of the store in dir, reading
+// the entries of its SSTs when entries is true.
+func verifyDir(dir string, entries bool) (*VerifyReport, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+SSTFileFormat))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &VerifyReport{Dir: dir, Problems: []Problem{}}
+
+	comparators := make(map[string][]string)
+	ids := make(map[[2]uint64][]string)
+	for _, file := range files {
+		name := filepath.Base(file)
+		report.SSTs++
+
+		sst, err := OpenSSTFile(file)
+		if errors.Is(err, ErrSSTIncomplete) {
+			report.add(name, PROBLEM_INCOMPLETE, "")
+			continue
+		}
+
+		if err != nil {
+			report.add(name, PROBLEM_CORRUPTED, err.Error())
+			continue
+		}
+
+		if other, ok := movedSSTName(file, sst.Level, sst.ID); ok {
+			report.add(name, PROBLEM_ORPHANED, "name of "+other+" left by an interrupted move")
+			continue
+		}
+
+		comparators[sst.Comparator] = append(comparators[sst.Comparator], name)
+
+		key := [2]uint64{uint64(sst.Level), sst.ID}
+		ids[key] = append(ids[key], name)
+
+		if level, id, ok := parseSSTFileName(name); !ok || level != sst.Level || id != sst.ID {
+			report.add(name, PROBLEM_NAME_MISMATCH, fmt.Sprintf("footer records level %d, id %d", sst.Level, sst.ID))
+		}
+
+		if !entries {
+			continue
+		}
+
+		var last *string
+		var outOfOrder string
+		err = sst.ReadEntries(func(_ int64, entry *SSTEntry) bool {
+			if last != nil && sst.cmp.Compare(entry.Key, *last) <= 0 {
+				outOfOrder = fmt.Sprintf("key %q follows %q", entry.Key, *last)
+				return false
+			}
+
+			report.Entries++
+			last = &entry.Key
+			return true
+		})
+
+		switch {
+		case err != nil:
+			report.add(name, PROBLEM_CORRUPTED, err.Error())
+		case outOfOrder != "":
+			report.add(name, PROBLEM_OUT_OF_ORDER, outOfOrder)
+		default:
+			if err := checkSSTFileChecksum(file); err != nil {
+				report.add(name, PROBLEM_CORRUPTED, err.Error())
+			}
+		}
+	}
+
+	for _, names := range ids {
+		if len(names) > 1 {
+			for _, name := range names {
+				report.add(name, PROBLEM_DUPLICATE_ID, "same level and id as "+strings.Join(names, ", "))
+			}
+		}
+	}
+
+	// the SSTs of the most used comparator are taken as the right ones
+	if len(comparators) > 1 {
+		var common string
+		for cmp, names := range comparators {
+			if common == "" || len(names) > len(comparators[common]) || (len(names) == len(comparators[common]) && cmp < common) {
+				common = cmp
+			}
+		}
+
+		for cmp, names := range comparators {
+			if cmp == common {
+				continue
+			}
+
+			for _, name := range names {
+				report.add(name, PROBLEM_COMPARATOR_MISMATCH, fmt.Sprintf("ordered by %s, the other SSTs by %s", cmp, common))
+			}
+		}
+	}
+
+	// snapshots are removed when the store opens
+	snapshots, err := filepath.Glob(filepath.Join(dir, "snapshot-*"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, snapshot := range snapshots {
+		report.add(filepath.Base(snapshot), PROBLEM_ORPHANED, "snapshot left by an interrupted transfer")
+	}
+
+	// ingests and repairs write their SST next to its final name
+	temps, err := filepath.Glob(filepath.Join(dir, "*"+SSTFileFormat+".tmp"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, temp := range temps {
+		report.add(filepath.Base(temp), PROBLEM_ORPHANED, "SST left by an interrupted ingest or repair")
+	}
+
+	sort.SliceStable(report.Problems, func(i, j int) bool {
+		return report.Problems[i].File < report.Problems[j].File
+	})
+
+	return report, nil
+}
+
+func (r *VerifyReport) add(file string, kind ProblemKind, detail string) {
+	r.Problems = append(r.Problems, Problem{File: file, Kind: kind, Detail: detail})
+}
+
+// Quarantine moves the files of quarantinable problems into the
+// QuarantineDir of the store and returns their new paths.
+func (r *VerifyReport) Quarantine() ([]string, error) {
+	var moved []string
+	for _, p := range r.Problems {
+		if !p.Quarantinable() || slices.Contains(moved, filepath.Join(r.Dir, QuarantineDir, p.File)) {
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Join(r.Dir, QuarantineDir), 0755); err != nil {
+			return moved, err
+		}
+
+		to := filepath.Join(r.Dir, QuarantineDir, p.File)
+		if err := os.Rename(filepath.Join(r.Dir, p.File), to); err != nil {
+			return moved, err
+		}
+
+		moved = append(moved, to)
+	}
+
+	return moved, nil
+}
+
+// parseSSTFileName returns the level and id of an SST named
+// level_id_uuid.sst, see SSTManager.NewSST.
+func parseSSTFileName(name string) (int, uint64, bool) {
+	parts := strings.SplitN(strings.TrimSuffix(name, SSTFileFormat), "_", 3)
+	if len(parts) != 3 {
+		return 0, 0, false
+	}
+
+	level, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return level, id, true
+}
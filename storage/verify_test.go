@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyDir(t *testing.T) {
+	dir := t.TempDir()
+
+	writeSST := func(name string, id uint64, cmp Comparator, keys ...string) []byte {
+		var buf bytes.Buffer
+		w := newSSTWriter(&buf)
+		for _, key := range keys {
+			require.NoError(t, encodeSSTEntry(w, key, "v", false, time.Time{}))
+		}
+
+		require.NoError(t, writeSSTMetadata(w, id, 0, time.Now(), cmp))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644))
+		return buf.Bytes()
+	}
+
+	writeSST("0_1_a.sst", 1, BytewiseComparator, "a", "b")
+	writeSST("0_2_b.sst", 2, BytewiseComparator, "b", "a")
+	writeSST("0_3_c.sst", 3, NumericComparator, "k2", "k10")
+	writeSST("0_9_d.sst", 4, BytewiseComparator, "c")
+	data := writeSST("0_5_e.sst", 5, BytewiseComparator, "d")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0_5_e.sst"), data[:len(data)-2], 0644))
+
+	// a value with a flipped bit
+	data = writeSST("0_6_f.sst", 6, BytewiseComparator, "e")
+	data[bytes.IndexByte(data, 'v')] = 'w'
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0_6_f.sst"), data, 0644))
+
+	report, err := VerifyDir(dir)
+	require.NoError(t, err)
+
+	assert.Equal(t, 6, report.SSTs)
+	assert.Equal(t, []Problem{
+		{File: "0_2_b.sst", Kind: PROBLEM_OUT_OF_ORDER, Detail: `key "a" follows "b"`},
+		{File: "0_3_c.sst", Kind: PROBLEM_COMPARATOR_MISMATCH, Detail: "ordered by numeric, the other SSTs by bytewise"},
+		{File: "0_5_e.sst", Kind: PROBLEM_INCOMPLETE},
+		{File: "0_6_f.sst", Kind: PROBLEM_CORRUPTED, Detail: "sst is corrupted: the entries have checksum 71ab9ada, the footer records d4ea08a4"},
+		{File: "0_9_d.sst", Kind: PROBLEM_NAME_MISMATCH, Detail: "footer records level 0, id 4"},
+	}, report.Problems)
+
+	moved, err := report.Quarantine()
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, QuarantineDir, "0_2_b.sst"),
+		filepath.Join(dir, QuarantineDir, "0_5_e.sst"),
+		filepath.Join(dir, QuarantineDir, "0_6_f.sst"),
+	}, moved)
+
+	report, err = VerifyDir(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.SSTs)
+	assert.Len(t, report.Problems, 2)
+}
@@ -0,0 +1,301 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+var ErrVersionsNotRetained error = errors.New("previous versions are not retained")
+
+// VersionsRetained is the number of previous versions kept for every
+// key, VersionRetention keeps the versions superseded for less than it
+// as well, so reads at any time within it are answered. Both zero, the
+// default, only keeps the latest version. Versions are kept with their
+// entry and dropped by compactions once they're no longer retained.
+var (
+	VersionsRetained int
+	VersionRetention time.Duration
+)
+
+// versionsRetained reports whether previous versions are kept.
+func versionsRetained() bool {
+	return VersionsRetained > 0 || VersionRetention > 0
+}
+
+// Version is a version of a key, its value from Timestamp
+// until the next version was written.
+type Version struct {
+	Value     string
+	IsDeleted bool
+
+	// ExpiresAt is zero for versions that don't expire.
+	ExpiresAt time.Time `json:",omitzero"`
+
+	Timestamp time.Time
+}
+
+// live reports whether the version holds a value at t.
+func (v Version) live(t time.Time) bool {
+	if v.IsDeleted || v.Value == "" {
+		return false
+	}
+
+	return v.ExpiresAt.IsZero() || t.Before(v.ExpiresAt)
+}
+
+// history returns the versions of e, newest first. Entries written
+// without their timestamp get the one of their SST, written after them.
+// Deletes store an empty value, their versions are tombstones.
+func (e *SSTEntry) history(written time.Time) []Version {
+	current := Version{
+		Value:     e.Value,
+		IsDeleted: e.IsDeleted || e.Value == "",
+		ExpiresAt: e.ExpiresAt,
+		Timestamp: e.Timestamp,
+	}
+
+	if current.Timestamp.IsZero() {
+		current.Timestamp = written
+	}
+
+	return append([]Version{current}, e.Versions...)
+}
+
+// retainVersions returns the previous versions of an entry written at
+// timestamp still retained at now, newest first: the VersionsRetained
+// newest ones and the ones superseded for less than VersionRetention.
+func retainVersions(versions []Version, timestamp time.Time, now time.Time) []Version {
+	superseded := timestamp
+	for i, v := range versions {
+		if i >= VersionsRetained && (VersionRetention == 0 || now.Sub(superseded) >= VersionRetention) {
+			return versions[:i]
+		}
+
+		superseded = v.Timestamp
+	}
+
+	return versions
+}
+
+// versionAt returns the version of versions, newest first,
+// written at or before t, false if they're all newer.
+func versionAt(versions []Version, t time.Time) (Version, bool) {
+	for _, v := range versions {
+		if !v.Timestamp.After(t) {
+			return v, true
+		}
+	}
+
+	return Version{}, false
+}
+
+// versionOverhead is the encoded size of a version without its value.
+const versionOverhead = 8 + 8 + 1 + 4
+
+// Versions block of the entries keeping their versions,
+// before the flags of the entry:
+// [Timestamp][Count][Version]...[BlockLength]
+// Version: [Timestamp][ExpiresAt][IsDeleted][ValLength][Val]
+// Times are unix nanoseconds, 0 for versions that don't expire.
+
+// versionsSize is the encoded length of a versions block.
+func versionsSize(versions []Version) int {
+	size := 8 + 4 + 4
+	for _, v := range versions {
+		size += versionOverhead + len(v.Value)
+	}
+
+	return size
+}
+
+// appendVersions appends the versions block of an entry written at timestamp.
+func appendVersions(buf []byte, timestamp time.Time, versions []Version) []byte {
+	start := len(buf)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(timestamp.UnixNano()))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(versions)))
+
+	for _, v := range versions {
+		var expiresAt int64
+		if !v.ExpiresAt.IsZero() {
+			expiresAt = v.ExpiresAt.UnixNano()
+		}
+
+		var deleted byte
+		if v.IsDeleted {
+			deleted = 1
+		}
+
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(v.Timestamp.UnixNano()))
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(expiresAt))
+		buf = append(buf, deleted)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v.Value)))
+		buf = append(buf, v.Value...)
+	}
+
+	return binary.LittleEndian.AppendUint32(buf, uint32(len(buf)-start))
+}
+
+// parseVersions splits the versions block off the end of data
+// and returns the rest of data with the decoded block.
+func parseVersions(data []byte) ([]byte, time.Time, []Version, error) {
+	errInvalid := errors.New("versions block is incorrect")
+
+	if len(data) < 4 {
+		return nil, time.Time{}, nil, errInvalid
+	}
+
+	size := int(binary.LittleEndian.Uint32(data[len(data)-4:]))
+	if size < 12 || size > len(data)-4 {
+		return nil, time.Time{}, nil, errInvalid
+	}
+
+	rest := data[:len(data)-4-size]
+	block := data[len(data)-4-size : len(data)-4]
+
+	timestamp := time.Unix(0, int64(binary.LittleEndian.Uint64(block[0:8])))
+	count := int(binary.LittleEndian.Uint32(block[8:12]))
+	block = block[12:]
+
+	if count > len(block)/versionOverhead {
+		return nil, time.Time{}, nil, errInvalid
+	}
+
+	versions := make([]Version, 0, count)
+	for range count {
+		if len(block) < versionOverhead {
+			return nil, time.Time{}, nil, errInvalid
+		}
+
+		v := Version{
+			Timestamp: time.Unix(0, int64(binary.LittleEndian.Uint64(block[0:8]))),
+			IsDeleted: block[16] == 1,
+		}
+
+		if nanos := int64(binary.LittleEndian.Uint64(block[8:16])); nanos != 0 {
+			v.ExpiresAt = time.Unix(0, nanos)
+		}
+
+		valLength := int(binary.LittleEndian.Uint32(block[17:21]))
+		block = block[versionOverhead:]
+		if valLength > len(block) {
+			return nil, time.Time{}, nil, errInvalid
+		}
+
+		v.Value = string(block[:valLength])
+		block = block[valLength:]
+
+		versions = append(versions, v)
+	}
+
+	if len(block) != 0 {
+		return nil, time.Time{}, nil, errInvalid
+	}
+
+	return rest, timestamp, versions, nil
+}
+
+// histories calls fn with the versions of key held by every memtable
+// and SST, from the newest to the oldest, until fn returns false.
+func (l *LSM) histories(ctx context.Context, key string, fn func(versions []Version) bool) error {
+	// memtables are collected before the ssts, see readSources
+	for _, mt := range l.memtables() {
+		entry, ok := mt.lookup(key)
+		if ok && !fn(entry.sstEntry().history(entry.Timestamp)) {
+			return nil
+		}
+	}
+
+	ssts := l.sstManager.sstsForRead()
+	defer releaseSSTs(ssts)
+
+	for _, sst := range ssts {
+		ie, err := sst.lookup(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		if ie == nil {
+			continue
+		}
+
+		entry, err := readEntryAt(sst, ie.offset)
+		if err != nil {
+			return err
+		}
+
+		if !fn(entry.history(sst.Timestamp)) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// GetAt returns the version of key visible at t, read from the
+// versions retained. Keys deleted, expired or not yet written at t
+// aren't found, like keys whose versions at t are no longer retained.
+func (l *LSM) GetAt(ctx context.Context, key string, t time.Time) (*KVData, error) {
+	if !versionsRetained() {
+		return nil, ErrVersionsNotRetained
+	}
+
+	var res *Version
+	err := l.histories(ctx, key, func(versions []Version) bool {
+		if v, ok := versionAt(versions, t); ok {
+			res = &v
+		}
+
+		return res == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if res == nil || !res.live(t) {
+		return nil, ErrKeyNotFound
+	}
+
+	return &KVData{Key: key, Value: res.Value, ExpiresAt: res.ExpiresAt}, nil
+}
+
+// Versions returns the versions of key retained, newest first, its
+// tombstones included. Versions no longer retained may still be
+// returned until their key is compacted.
+func (l *LSM) Versions(ctx context.Context, key string) ([]Version, error) {
+	if !versionsRetained() {
+		return nil, ErrVersionsNotRetained
+	}
+
+	var res []Version
+	err := l.histories(ctx, key, func(versions []Version) bool {
+		for _, v := range versions {
+			// SSTs compacted while they were listed repeat the versions of their output
+			if len(res) == 0 || v.Timestamp.Before(res[len(res)-1].Timestamp) {
+				res = append(res, v)
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res) == 0 {
+		return nil, ErrKeyNotFound
+	}
+
+	return res, nil
+}
+
+// GetAt returns the version of key visible at t, see LSM.GetAt.
+func (s *Store) GetAt(ctx context.Context, key string, t time.Time) (*KVData, error) {
+	return s.Backend.GetAt(ctx, key, t)
+}
+
+// Versions returns the versions of key retained, see LSM.Versions.
+func (s *Store) Versions(ctx context.Context, key string) ([]Version, error) {
+	return s.Backend.Versions(ctx, key)
+}
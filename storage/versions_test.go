@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeVersionedSSTEntry(t *testing.T) {
+	start := time.Unix(0, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano())
+
+	original := SSTEntry{
+		Key:       "a",
+		Value:     "3",
+		ExpiresAt: start.Add(time.Hour),
+		Timestamp: start.Add(2 * time.Second),
+		Versions: []Version{
+			{IsDeleted: true, Timestamp: start.Add(time.Second)},
+			{Value: "1\n", ExpiresAt: start.Add(time.Minute), Timestamp: start},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeSSTEntry(&buf, &original))
+	assert.Equal(t, original.size()+1, buf.Len())
+
+	parsed, err := parseSSTLine(buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, original, *parsed)
+
+	// entries without versions keep the previous format
+	buf.Reset()
+	require.NoError(t, writeSSTEntry(&buf, &SSTEntry{Key: "b", Value: "1"}))
+	assert.Equal(t, entrySize("b", "1", time.Time{})+1, buf.Len())
+}
+
+func TestRetainVersions(t *testing.T) {
+	defer func() { VersionsRetained, VersionRetention = 0, 0 }()
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	versions := []Version{
+		{Value: "3", Timestamp: now.Add(-2 * time.Minute)},
+		{Value: "2", Timestamp: now.Add(-time.Hour)},
+		{Value: "1", Timestamp: now.Add(-2 * time.Hour)},
+	}
+
+	VersionsRetained = 1
+	assert.Equal(t, versions[:1], retainVersions(versions, now.Add(-time.Minute), now))
+
+	// "2" was superseded two minutes ago, "1" an hour ago
+	VersionsRetained, VersionRetention = 0, 10*time.Minute
+	assert.Equal(t, versions[:2], retainVersions(versions, now.Add(-time.Minute), now))
+
+	VersionsRetained = 3
+	assert.Equal(t, versions, retainVersions(versions, now.Add(-time.Minute), now))
+}
+
+func TestVersions(t *testing.T) {
+	defer func() { VersionsRetained = 0 }()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	sstManager, err := NewSSTManager(logger, t.TempDir(), OPEN_FAST, nil)
+	require.NoError(t, err)
+
+	// times read from SSTs are local
+	start := time.Unix(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Unix(), 0)
+	clock := &fakeClock{now: start}
+	sstManager.clock.source = clock
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+
+	lsm := NewLSM(logger, sstManager)
+
+	_, err = lsm.GetAt(context.Background(), "a", at(0))
+	assert.ErrorIs(t, err, ErrVersionsNotRetained)
+
+	VersionsRetained = 2
+
+	assertAt := func(seconds int, value string) {
+		t.Helper()
+
+		res, err := lsm.GetAt(context.Background(), "a", at(seconds))
+		if value == "" {
+			assert.ErrorIs(t, err, ErrKeyNotFound, "at %d", seconds)
+			return
+		}
+
+		require.NoError(t, err, "at %d", seconds)
+		assert.Equal(t, value, res.Value, "at %d", seconds)
+	}
+
+	write := func(seconds int, value string) {
+		clock.now = at(seconds)
+		if value == "" {
+			require.NoError(t, lsm.Delete("a"))
+		} else {
+			require.NoError(t, lsm.Set("a", value))
+		}
+	}
+
+	write(10, "1")
+	write(20, "2")
+	write(30, "")
+	assertAt(5, "")
+	assertAt(10, "1")
+	assertAt(25, "2")
+	assertAt(30, "")
+
+	// only the 2 previous versions are retained
+	write(40, "3")
+	assertAt(15, "")
+	assertAt(25, "2")
+	assertAt(45, "3")
+
+	_, err = lsm.Flush(context.Background())
+	require.NoError(t, err)
+	assertAt(25, "2")
+	assertAt(35, "")
+
+	// versions are read across memtables and SSTs
+	write(50, "4")
+	assertAt(45, "3")
+	assertAt(55, "4")
+
+	_, err = lsm.Flush(context.Background())
+	require.NoError(t, err)
+	assertAt(45, "3")
+
+	versions, err := lsm.Versions(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, []Version{
+		{Value: "4", Timestamp: at(50)},
+		{Value: "3", Timestamp: at(40)},
+		{IsDeleted: true, Timestamp: at(30)},
+		{Value: "2", Timestamp: at(20)},
+	}, versions)
+
+	_, err = lsm.Versions(context.Background(), "b")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// compactions merge the versions and drop the ones not retained
+	require.NoError(t, sstManager.CompactAll(context.Background(), func(int, int) {}))
+
+	out := sstManager.ListSST(1, []SSTState{SST_FLUSHED}, 0)
+	require.Len(t, out, 1)
+
+	entries := readSST(t, out[0])
+	require.Len(t, entries, 1)
+	assert.Equal(t, "4", entries[0].Value)
+	assert.True(t, at(50).Equal(entries[0].Timestamp))
+	require.Len(t, entries[0].Versions, 2)
+	assert.Equal(t, "3", entries[0].Versions[0].Value)
+	assert.True(t, entries[0].Versions[1].IsDeleted)
+}
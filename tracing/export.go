@@ -0,0 +1,183 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OTLP status code of failed spans.
+const otlpStatusError = 2
+
+// OTLP/HTTP JSON encoding of an export request, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding.
+// Ids are hex encoded and 64 bit integers are strings.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              SpanKind   `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+func encodeAttrs(attrs []Attr) []otlpAttr {
+	var res []otlpAttr
+	for _, attr := range attrs {
+		var value otlpValue
+		switch v := attr.Value.(type) {
+		case string:
+			value.StringValue = &v
+		case bool:
+			value.BoolValue = &v
+		case int:
+			n := strconv.Itoa(v)
+			value.IntValue = &n
+		case int64:
+			n := strconv.FormatInt(v, 10)
+			value.IntValue = &n
+		case uint64:
+			n := strconv.FormatUint(v, 10)
+			value.IntValue = &n
+		case float64:
+			value.DoubleValue = &v
+		case time.Duration:
+			n := strconv.FormatInt(v.Milliseconds(), 10)
+			value.IntValue = &n
+		default:
+			s := fmt.Sprint(v)
+			value.StringValue = &s
+		}
+
+		res = append(res, otlpAttr{Key: attr.Key, Value: value})
+	}
+
+	return res
+}
+
+func encodeSpan(s *Span) otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(s.ctx.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.ctx.SpanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Attributes:        encodeAttrs(s.attrs),
+	}
+
+	if s.parent != (SpanID{}) {
+		span.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+
+	if s.err != nil {
+		span.Status = otlpStatus{Code: otlpStatusError, Message: s.err.Error()}
+	}
+
+	return span
+}
+
+// export posts spans to the collector, failed exports are dropped.
+func (t *Tracer) export(spans []*Span) {
+	if len(spans) == 0 {
+		return
+	}
+
+	resource := append([]Attr{{Key: "service.name", Value: t.opts.ServiceName}}, t.opts.Attributes...)
+	scope := otlpScopeSpans{Scope: otlpScope{Name: "distrikv"}}
+	for _, span := range spans {
+		scope.Spans = append(scope.Spans, encodeSpan(span))
+	}
+
+	body, err := json.Marshal(otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource:   otlpResource{Attributes: encodeAttrs(resource)},
+			ScopeSpans: []otlpScopeSpans{scope},
+		}},
+	})
+	if err != nil {
+		t.logger.Error("error encoding spans", "err", err)
+		return
+	}
+
+	if err := t.post(body); err != nil {
+		t.logger.Warn("error exporting spans", "spans", len(spans), "err", err)
+	}
+}
+
+func (t *Tracer) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	url := strings.TrimSuffix(t.opts.Endpoint, "/") + "/v1/traces"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return fmt.Errorf("collector responded with %s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
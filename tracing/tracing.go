@@ -0,0 +1,311 @@
+// Package tracing records spans of the requests and background work
+// of a node and exports them to an OpenTelemetry collector over
+// OTLP/HTTP with JSON encoding. Spans of a nil Tracer are no-ops.
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type SpanKind int
+
+// Span kinds, numbered like OTLP.
+const (
+	SPAN_KIND_INTERNAL SpanKind = iota + 1
+
+	SPAN_KIND_SERVER
+
+	SPAN_KIND_CLIENT
+)
+
+// Export batching of the spans of a Tracer.
+const (
+	exportBatchSize = 512
+	exportQueueSize = 4096
+	exportInterval  = 5 * time.Second
+	exportTimeout   = 10 * time.Second
+)
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+// SpanContext identifies a span across processes.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+
+	// Sampled spans are exported, the decision
+	// of the root span is followed by its children.
+	Sampled bool
+}
+
+func (c SpanContext) IsValid() bool {
+	return c.TraceID != TraceID{} && c.SpanID != SpanID{}
+}
+
+// Attr is an attribute of a span.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// Span is a timed operation of a trace.
+type Span struct {
+	tracer *Tracer
+	ctx    SpanContext
+	parent SpanID
+	name   string
+	kind   SpanKind
+	start  time.Time
+
+	mu    sync.Mutex
+	end   time.Time
+	attrs []Attr
+	err   error
+	ended bool
+}
+
+// SetAttr sets an attribute of the span.
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attrs = append(s.attrs, Attr{Key: key, Value: value})
+}
+
+// SetError marks the span as failed with err, nil is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.err = err
+}
+
+// End records the end of the span and queues it for export,
+// only the first call counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.ctx.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+// Context returns the identity of the span.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return s.ctx
+}
+
+// Options configures a Tracer.
+type Options struct {
+	// Endpoint is the base URL of the OTLP/HTTP collector,
+	// spans are posted to Endpoint/v1/traces.
+	Endpoint string
+
+	// ServiceName and Attributes describe the node in every export.
+	ServiceName string
+	Attributes  []Attr
+
+	// SampleRatio is the fraction of new traces recorded,
+	// traces continued from a caller follow its decision.
+	SampleRatio float64
+}
+
+// Tracer starts spans and exports them in batches.
+type Tracer struct {
+	logger *slog.Logger
+	opts   Options
+	client *http.Client
+
+	queue   chan *Span
+	dropped atomic.Uint64
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// New creates a tracer exporting to opts.Endpoint until Shutdown.
+func New(logger *slog.Logger, opts Options) *Tracer {
+	t := &Tracer{
+		logger: logger,
+		opts:   opts,
+		client: &http.Client{Timeout: exportTimeout},
+		queue:  make(chan *Span, exportQueueSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go t.run()
+
+	return t
+}
+
+// Start starts a span, a child of the span of ctx if any,
+// and returns a context holding it.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind, attrs ...Attr) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+		attrs:  slices.Clone(attrs),
+	}
+
+	parent := SpanContextFromContext(ctx)
+	if parent.IsValid() {
+		span.ctx.TraceID = parent.TraceID
+		span.ctx.Sampled = parent.Sampled
+		span.parent = parent.SpanID
+	} else {
+		binary.BigEndian.PutUint64(span.ctx.TraceID[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(span.ctx.TraceID[8:], rand.Uint64())
+		span.ctx.Sampled = t.sample(span.ctx.TraceID)
+	}
+
+	binary.BigEndian.PutUint64(span.ctx.SpanID[:], rand.Uint64()|1)
+
+	return ContextWithSpanContext(ctx, span.ctx), span
+}
+
+// sample decides whether a new trace is recorded, from the
+// random low half of its id so every node would agree.
+func (t *Tracer) sample(id TraceID) bool {
+	switch {
+	case t.opts.SampleRatio >= 1:
+		return true
+	case t.opts.SampleRatio <= 0:
+		return false
+	}
+
+	return binary.BigEndian.Uint64(id[8:]) < uint64(t.opts.SampleRatio*math.MaxUint64)
+}
+
+// enqueue queues an ended span, spans are dropped while the queue is full.
+func (t *Tracer) enqueue(s *Span) {
+	select {
+	case t.queue <- s:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of spans dropped with a full queue.
+func (t *Tracer) Dropped() uint64 {
+	return t.dropped.Load()
+}
+
+// Shutdown exports the queued spans and stops the tracer,
+// waiting until ctx is done.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
+
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run exports the queued spans every exportInterval or once
+// exportBatchSize spans are queued, until Shutdown.
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) < exportBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-t.stop:
+			for len(t.queue) > 0 {
+				batch = append(batch, <-t.queue)
+			}
+
+			t.export(batch)
+			return
+		}
+
+		t.export(batch)
+		batch = nil
+	}
+}
+
+var global atomic.Pointer[Tracer]
+
+// SetTracer sets the tracer of Start, nil disables tracing.
+func SetTracer(t *Tracer) {
+	global.Store(t)
+}
+
+// Start starts a span with the tracer set by SetTracer,
+// see Tracer.Start. Without tracer the span is nil.
+func Start(ctx context.Context, name string, kind SpanKind, attrs ...Attr) (context.Context, *Span) {
+	return global.Load().Start(ctx, name, kind, attrs...)
+}
+
+type contextKey struct{}
+
+// ContextWithSpanContext returns a context whose spans are children of sc.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span of ctx,
+// invalid when ctx holds none.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(contextKey{}).(SpanContext)
+	return sc
+}
@@ -0,0 +1,154 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collector records the spans posted to it.
+type collector struct {
+	mu    sync.Mutex
+	reqs  []otlpRequest
+	spans []otlpSpan
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+
+	var req otlpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reqs = append(c.reqs, req)
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+}
+
+func newTestTracer(t *testing.T, ratio float64) (*Tracer, *collector) {
+	c := &collector{}
+	server := httptest.NewServer(c)
+	t.Cleanup(server.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	tracer := New(logger, Options{
+		Endpoint:    server.URL,
+		ServiceName: "distrikv-test",
+		Attributes:  []Attr{{Key: "service.instance.id", Value: "n1"}},
+		SampleRatio: ratio,
+	})
+
+	return tracer, c
+}
+
+func TestTracerExport(t *testing.T) {
+	tracer, c := newTestTracer(t, 1)
+
+	ctx, root := tracer.Start(context.Background(), "GET /v1/keys/:key", SPAN_KIND_SERVER, Attr{Key: "http.route", Value: "/v1/keys/:key"})
+	_, child := tracer.Start(ctx, "lookup", SPAN_KIND_INTERNAL)
+	child.SetAttr("distrikv.level", 2)
+	child.SetAttr("distrikv.cached", true)
+	child.SetError(errors.New("disk failed"))
+	child.End()
+	child.End()
+	root.End()
+
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	require.Len(t, c.spans, 2)
+	lookup, get := c.spans[0], c.spans[1]
+
+	assert.Equal(t, get.TraceID, lookup.TraceID)
+	assert.Equal(t, get.SpanID, lookup.ParentSpanID)
+	assert.Empty(t, get.ParentSpanID)
+	assert.Len(t, get.TraceID, 32)
+	assert.Len(t, get.SpanID, 16)
+
+	assert.Equal(t, SPAN_KIND_SERVER, get.Kind)
+	assert.Equal(t, "lookup", lookup.Name)
+	assert.Equal(t, otlpStatus{Code: otlpStatusError, Message: "disk failed"}, lookup.Status)
+	assert.Zero(t, get.Status)
+
+	require.Len(t, lookup.Attributes, 2)
+	assert.Equal(t, "2", *lookup.Attributes[0].Value.IntValue)
+	assert.True(t, *lookup.Attributes[1].Value.BoolValue)
+
+	resource := c.reqs[0].ResourceSpans[0].Resource.Attributes
+	assert.Equal(t, "service.name", resource[0].Key)
+	assert.Equal(t, "distrikv-test", *resource[0].Value.StringValue)
+	assert.Equal(t, "n1", *resource[1].Value.StringValue)
+}
+
+func TestTracerSampling(t *testing.T) {
+	tracer, c := newTestTracer(t, 0)
+
+	// unsampled traces propagate but aren't exported
+	ctx, root := tracer.Start(context.Background(), "root", SPAN_KIND_SERVER)
+	assert.True(t, root.Context().IsValid())
+	assert.False(t, root.Context().Sampled)
+	root.End()
+
+	// traces continued from a sampled caller are
+	caller := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}, Sampled: true}
+	_, span := tracer.Start(ContextWithSpanContext(ctx, caller), "continued", SPAN_KIND_SERVER)
+	span.End()
+
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	require.Len(t, c.spans, 1)
+	assert.Equal(t, "continued", c.spans[0].Name)
+	assert.Equal(t, "0200000000000000", c.spans[0].ParentSpanID)
+}
+
+func TestTraceparent(t *testing.T) {
+	sc := SpanContext{TraceID: TraceID{0xab, 1}, SpanID: SpanID{0xcd, 2}, Sampled: true}
+	header := http.Header{}
+	Inject(ContextWithSpanContext(context.Background(), sc), header)
+	assert.Equal(t, "00-ab010000000000000000000000000000-cd02000000000000-01", header.Get(TraceparentHeader))
+
+	assert.Equal(t, sc, SpanContextFromContext(Extract(context.Background(), header)))
+
+	for _, invalid := range []string{
+		"",
+		"00-ab010000000000000000000000000000-cd02000000000000",
+		"00-00000000000000000000000000000000-cd02000000000000-01",
+		"00-ab01-cd02000000000000-01",
+		"ff-ab010000000000000000000000000000-cd02000000000000-01",
+		"00-ab010000000000000000000000000000-cd02000000000000-01-extra",
+	} {
+		_, ok := ParseTraceparent(invalid)
+		assert.False(t, ok, invalid)
+	}
+
+	// later versions may add fields
+	_, ok := ParseTraceparent("01-ab010000000000000000000000000000-cd02000000000000-01-extra")
+	assert.True(t, ok)
+
+	// a nil tracer starts no spans
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "noop", SPAN_KIND_INTERNAL)
+	assert.Nil(t, span)
+	span.SetAttr("key", "value")
+	span.End()
+	assert.False(t, SpanContextFromContext(ctx).IsValid())
+}
@@ -0,0 +1,359 @@
+package wal
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrTruncated is returned for reads of records
+	// whose segment was already removed.
+	ErrTruncated = errors.New("wal records are no longer retained")
+
+	ErrClosed = errors.New("wal is closed")
+)
+
+// segmentExt is the extension of the segment files of a Log.
+const segmentExt = ".wal"
+
+type segment struct {
+	firstSeq uint64
+	path     string
+	size     int64
+}
+
+// Log is a WAL split into the segment files of a directory, each
+// named after the sequence of its first record. A new segment is
+// started once the last one holds segmentSize bytes, and the oldest
+// segments are removed while the segments hold more than retention
+// bytes, keeping the last one. Records are written without syncing,
+// segments are synced once complete and when the log is closed.
+type Log struct {
+	dir         string
+	segmentSize int64
+	retention   int64
+
+	mu       sync.Mutex
+	segments []segment
+	file     *os.File
+	lastSeq  uint64
+	closed   bool
+}
+
+// OpenLog opens the log of dir, creating it if needed. A record of
+// the last segment torn by a crash is truncated, with the records after it.
+func OpenLog(dir string, segmentSize int64, retention int64) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		dir:         dir,
+		segmentSize: segmentSize,
+		retention:   retention,
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+
+		firstSeq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		l.segments = append(l.segments, segment{
+			firstSeq: firstSeq,
+			path:     filepath.Join(dir, name),
+			size:     info.Size(),
+		})
+	}
+
+	slices.SortFunc(l.segments, func(a, b segment) int {
+		return cmp.Compare(a.firstSeq, b.firstSeq)
+	})
+
+	if len(l.segments) == 0 {
+		return l, nil
+	}
+
+	if err := l.recover(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// recover reads the last segment for the last sequence,
+// truncates its torn tail and opens it for appending.
+func (l *Log) recover() error {
+	last := &l.segments[len(l.segments)-1]
+	l.lastSeq = last.firstSeq - 1
+
+	var end int64
+	err := ReadRecords(last.path, func(offset int64, entry *WALEntry, err error) bool {
+		data, _ := entry.Encode()
+		end = offset + int64(len(data))
+		l.lastSeq = entry.Seq
+		return true
+	})
+	if err != nil && !errors.Is(err, ErrCorrupted) {
+		return err
+	}
+
+	f, err := os.OpenFile(last.path, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if end < last.size {
+		if err := f.Truncate(end); err != nil {
+			f.Close()
+			return fmt.Errorf("truncate torn tail of %s: %w", last.path, err)
+		}
+
+		last.size = end
+	}
+
+	if _, err := f.Seek(end, 0); err != nil {
+		f.Close()
+		return err
+	}
+
+	l.file = f
+	return nil
+}
+
+// Append writes entry after the records of the log, its sequence must
+// be larger than theirs, the first one of its Batch for a RecordBatch.
+func (l *Log) Append(entry *WALEntry) error {
+	data, err := entry.Encode()
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+
+	if entry.FirstSeq() <= l.lastSeq {
+		return fmt.Errorf("sequence %d is not after %d", entry.FirstSeq(), l.lastSeq)
+	}
+
+	if l.file == nil || l.segments[len(l.segments)-1].size >= l.segmentSize {
+		if err := l.roll(entry.FirstSeq()); err != nil {
+			return err
+		}
+	}
+
+	if _, err := l.file.Write(data); err != nil {
+		return err
+	}
+
+	l.segments[len(l.segments)-1].size += int64(len(data))
+	l.lastSeq = entry.Seq
+
+	return nil
+}
+
+// roll syncs the last segment, starts one at firstSeq and
+// removes the oldest segments beyond the retention.
+// Must be called with mu held.
+func (l *Log) roll(firstSeq uint64) error {
+	if l.file != nil {
+		if err := l.file.Sync(); err != nil {
+			return err
+		}
+
+		if err := l.file.Close(); err != nil {
+			return err
+		}
+
+		l.file = nil
+	}
+
+	path := filepath.Join(l.dir, fmt.Sprintf("%020d%s", firstSeq, segmentExt))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	l.file = f
+	l.segments = append(l.segments, segment{firstSeq: firstSeq, path: path})
+
+	var total int64
+	for _, s := range l.segments {
+		total += s.size
+	}
+
+	for len(l.segments) > 1 && total > l.retention {
+		if err := os.Remove(l.segments[0].path); err != nil {
+			return err
+		}
+
+		total -= l.segments[0].size
+		l.segments = l.segments[1:]
+	}
+
+	return nil
+}
+
+// Read calls fn with the records after seq, in order, until fn
+// returns false. A RecordBatch is passed whole when its last record
+// is after seq. Records appended while reading may be left out.
+// Reads of records no longer retained fail with ErrTruncated.
+func (l *Log) Read(seq uint64, fn func(entry *WALEntry) bool) error {
+	l.mu.Lock()
+	segments := slices.Clone(l.segments)
+	lastSeq := l.lastSeq
+	l.mu.Unlock()
+
+	if seq >= lastSeq {
+		return nil
+	}
+
+	if len(segments) == 0 || seq+1 < segments[0].firstSeq {
+		return ErrTruncated
+	}
+
+	// the segment holding seq+1 and the ones after it
+	i, found := slices.BinarySearchFunc(segments, seq+1, func(s segment, seq uint64) int {
+		return cmp.Compare(s.firstSeq, seq)
+	})
+	if !found {
+		i--
+	}
+
+	for _, s := range segments[i:] {
+		stop, err := readSegment(s, seq, fn)
+		if err != nil || stop {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readSegment calls fn with the records of s after seq
+// and tells whether fn stopped the read.
+func readSegment(s segment, seq uint64, fn func(entry *WALEntry) bool) (bool, error) {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, ErrTruncated
+	}
+
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	var stop bool
+	var corrupted error
+	err = readRecords(f, s.size, func(offset int64, entry *WALEntry, err error) bool {
+		if err != nil {
+			corrupted = fmt.Errorf("%s: %w", s.path, err)
+			return false
+		}
+
+		if entry.Seq <= seq {
+			return true
+		}
+
+		stop = !fn(entry)
+		return !stop
+	})
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", s.path, err)
+	}
+
+	return stop, corrupted
+}
+
+// FirstSeq returns the sequence of the oldest
+// retained record, 0 for an empty log.
+func (l *Log) FirstSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.segments) == 0 {
+		return 0
+	}
+
+	return l.segments[0].firstSeq
+}
+
+// LastSeq returns the sequence of the last record.
+func (l *Log) LastSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.lastSeq
+}
+
+// Size returns the bytes of the retained segments.
+func (l *Log) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var total int64
+	for _, s := range l.segments {
+		total += s.size
+	}
+
+	return total
+}
+
+// Sync syncs the last segment, the records appended
+// before it survive a crash.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+
+	if l.file == nil {
+		return nil
+	}
+
+	return l.file.Sync()
+}
+
+// Close syncs and closes the last segment.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	if l.file == nil {
+		return nil
+	}
+
+	err := errors.Join(l.file.Sync(), l.file.Close())
+	l.file = nil
+
+	return err
+}
@@ -0,0 +1,125 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readLog(t *testing.T, l *Log, seq uint64) []WALEntry {
+	var entries []WALEntry
+	assert.NoError(t, l.Read(seq, func(entry *WALEntry) bool {
+		entries = append(entries, *entry)
+		return true
+	}))
+
+	return entries
+}
+
+func TestLog(t *testing.T) {
+	dir := t.TempDir()
+
+	// every segment holds a single record
+	l, err := OpenLog(dir, 1, 1<<20)
+	assert.NoError(t, err)
+
+	expiresAt := time.Unix(0, time.Now().Add(time.Hour).UnixNano())
+	assert.NoError(t, l.Append(&WALEntry{Seq: 5, Type: RecordPut, Key: "a", Value: "1"}))
+	assert.NoError(t, l.Append(&WALEntry{Seq: 6, Type: RecordPutExpiring, Key: "b", Value: "2", ExpiresAt: expiresAt}))
+	assert.NoError(t, l.Append(&WALEntry{Seq: 7, Type: RecordDeleteRange, Key: "a", Value: "c"}))
+	assert.Error(t, l.Append(&WALEntry{Seq: 7, Type: RecordDelete, Key: "a"}))
+
+	entries := readLog(t, l, 5)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, uint64(6), entries[0].Seq)
+	assert.True(t, expiresAt.Equal(entries[0].ExpiresAt))
+	assert.Equal(t, "2", entries[0].Value)
+	assert.Equal(t, RecordDeleteRange, entries[1].Type)
+
+	assert.Len(t, readLog(t, l, 4), 3)
+	assert.Empty(t, readLog(t, l, 7))
+	assert.ErrorIs(t, l.Read(3, func(*WALEntry) bool { return true }), ErrTruncated)
+	assert.NoError(t, l.Close())
+	assert.ErrorIs(t, l.Append(&WALEntry{Seq: 8, Type: RecordDelete, Key: "a"}), ErrClosed)
+
+	// a torn record is truncated on open
+	last := filepath.Join(dir, "00000000000000000007.wal")
+	f, err := os.OpenFile(last, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte{1, 2, 3})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	l, err = OpenLog(dir, 1, 1<<20)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), l.FirstSeq())
+	assert.Equal(t, uint64(7), l.LastSeq())
+	assert.NoError(t, l.Append(&WALEntry{Seq: 8, Type: RecordDelete, Key: "a"}))
+	assert.Len(t, readLog(t, l, 4), 4)
+	assert.NoError(t, l.Close())
+}
+
+func TestLogRetention(t *testing.T) {
+	l, err := OpenLog(t.TempDir(), 1, 1)
+	assert.NoError(t, err)
+	defer l.Close()
+
+	for seq := uint64(1); seq <= 3; seq++ {
+		assert.NoError(t, l.Append(&WALEntry{Seq: seq, Type: RecordPut, Key: "a", Value: "1"}))
+	}
+
+	// only the last segment is kept
+	assert.Equal(t, uint64(3), l.FirstSeq())
+	assert.ErrorIs(t, l.Read(1, func(*WALEntry) bool { return true }), ErrTruncated)
+	assert.Len(t, readLog(t, l, 2), 1)
+}
+
+func TestLogBatch(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := OpenLog(dir, 1, 1<<20)
+	assert.NoError(t, err)
+
+	expiresAt := time.Unix(0, time.Now().Add(time.Hour).UnixNano())
+	assert.NoError(t, l.Append(&WALEntry{Seq: 1, Type: RecordPut, Key: "a", Value: "1"}))
+	assert.NoError(t, l.Append(NewBatch([]*WALEntry{
+		{Seq: 2, Type: RecordPutExpiring, Key: "b", Value: "2", ExpiresAt: expiresAt},
+		{Seq: 3, Type: RecordDelete, Key: "a"},
+		{Seq: 4, Type: RecordDeleteRange, Key: "c", Value: "d"},
+	})))
+	assert.Error(t, l.Append(NewBatch([]*WALEntry{{Seq: 4, Type: RecordDelete, Key: "a"}})))
+	assert.Error(t, l.Append(&WALEntry{Seq: 5, Type: RecordBatch}))
+	assert.NoError(t, l.Close())
+
+	// the segment of the batch is named after its first record
+	_, err = os.Stat(filepath.Join(dir, "00000000000000000002.wal"))
+	assert.NoError(t, err)
+
+	l, err = OpenLog(dir, 1, 1<<20)
+	assert.NoError(t, err)
+	defer l.Close()
+	assert.Equal(t, uint64(4), l.LastSeq())
+
+	// the batch is read whole, even after its first record
+	for _, seq := range []uint64{1, 2, 3} {
+		entries := readLog(t, l, seq)
+		assert.Len(t, entries, 1)
+		assert.Equal(t, RecordBatch, entries[0].Type)
+		assert.Equal(t, uint64(4), entries[0].Seq)
+		assert.Equal(t, uint64(2), entries[0].FirstSeq())
+
+		batch := entries[0].Batch
+		assert.Len(t, batch, 3)
+		assert.Equal(t, "b", batch[0].Key)
+		assert.Equal(t, "2", batch[0].Value)
+		assert.True(t, expiresAt.Equal(batch[0].ExpiresAt))
+		assert.Equal(t, RecordDelete, batch[1].Type)
+		assert.Equal(t, uint64(3), batch[1].Seq)
+		assert.Equal(t, "d", batch[2].Value)
+	}
+
+	assert.Empty(t, readLog(t, l, 4))
+}
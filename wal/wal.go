@@ -1,38 +1,275 @@
 package wal
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
 )
 
+// ErrCorrupted is returned for records whose CRC doesn't match
+// their content or that can't be framed or decoded.
+var ErrCorrupted = errors.New("wal is corrupted")
+
+// RecordType is the operation logged by a record.
+type RecordType byte
+
+const (
+	RecordPut RecordType = iota + 1
+	RecordDelete
+
+	// RecordDeleteRange deletes the keys in [Key, Value),
+	// an empty Value to the last key.
+	RecordDeleteRange
+
+	// RecordPutExpiring puts a key expiring at ExpiresAt.
+	RecordPutExpiring
+
+	// RecordBatch logs the records of Batch at once, so a crash keeps
+	// all of them or none. Its Seq is the one of the last record.
+	RecordBatch
+)
+
+func (t RecordType) String() string {
+	switch t {
+	case RecordPut:
+		return "put"
+	case RecordDelete:
+		return "delete"
+	case RecordDeleteRange:
+		return "delete_range"
+	case RecordPutExpiring:
+		return "put_expiring"
+	case RecordBatch:
+		return "batch"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(t))
+	}
+}
+
+// headerSize is the CRC and the length of the payload before every record.
+const headerSize = 8
+
+// payloadHeaderSize is the sequence, type and key length of a payload.
+const payloadHeaderSize = 13
+
+// expirySize is the expiry before the value of RecordPutExpiring.
+const expirySize = 8
+
+// WALEntry is a record of the WAL. It's framed as
+// crc(4) | length(4) | seq(8) | type(1) | key length(4) | key | value,
+// the CRC covering the payload after the length. The value of
+// RecordPutExpiring starts with the unix nanoseconds of ExpiresAt,
+// the one of RecordBatch is the length(4) | payload of every record
+// of Batch, which have no CRC of their own.
 type WALEntry struct {
-	CRC     uint32
-	Content [32]byte
+	CRC       uint32
+	Seq       uint64
+	Type      RecordType
+	Key       string
+	Value     string
+	ExpiresAt time.Time
+	Batch     []*WALEntry
+}
+
+// NewBatch returns the RecordBatch logging entries at once,
+// their sequences must be increasing.
+func NewBatch(entries []*WALEntry) *WALEntry {
+	return &WALEntry{
+		Seq:   entries[len(entries)-1].Seq,
+		Type:  RecordBatch,
+		Batch: entries,
+	}
 }
 
+// FirstSeq returns the sequence of the first record of a RecordBatch,
+// the sequence of the entry for the other records.
+func (e *WALEntry) FirstSeq() uint64 {
+	if e.Type == RecordBatch && len(e.Batch) > 0 {
+		return e.Batch[0].Seq
+	}
+
+	return e.Seq
+}
+
+// payload returns the payload of the entry.
+func (e *WALEntry) payload() []byte {
+	payload := make([]byte, payloadHeaderSize, payloadHeaderSize+len(e.Key)+expirySize+len(e.Value))
+	binary.LittleEndian.PutUint64(payload[0:8], e.Seq)
+	payload[8] = byte(e.Type)
+	binary.LittleEndian.PutUint32(payload[9:13], uint32(len(e.Key)))
+	payload = append(payload, e.Key...)
+
+	switch e.Type {
+	case RecordPutExpiring:
+		payload = binary.LittleEndian.AppendUint64(payload, uint64(e.ExpiresAt.UnixNano()))
+	case RecordBatch:
+		for _, entry := range e.Batch {
+			record := entry.payload()
+			payload = binary.LittleEndian.AppendUint32(payload, uint32(len(record)))
+			payload = append(payload, record...)
+		}
+
+		return payload
+	}
+
+	return append(payload, e.Value...)
+}
+
+// Encode frames the entry and sets its CRC.
 func (e *WALEntry) Encode() ([]byte, error) {
+	if e.Type == RecordBatch && len(e.Batch) == 0 {
+		return nil, errors.New("batch record holds no records")
+	}
+
+	payload := e.payload()
+	e.CRC = crc32.ChecksumIEEE(payload)
+
 	buf := new(bytes.Buffer)
+	buf.Grow(headerSize + len(payload))
 
-	crcBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(crcBytes, e.CRC)
+	header := make([]byte, headerSize)
+	binary.LittleEndian.PutUint32(header[0:4], e.CRC)
+	binary.LittleEndian.PutUint32(header[4:8], uint32(len(payload)))
 
-	buf.Write(crcBytes)
-	buf.Write(e.Content[:])
+	buf.Write(header)
+	buf.Write(payload)
 
 	return buf.Bytes(), nil
 }
 
-func decodeWALEntry(data []byte) (*WALEntry, error) {
-	if len(data) < 36 {
-		return nil, fmt.Errorf("data too short")
+func decodeWALEntry(crc uint32, payload []byte) (*WALEntry, error) {
+	if len(payload) < payloadHeaderSize {
+		return nil, fmt.Errorf("payload of %d bytes is too short", len(payload))
 	}
 
-	var e WALEntry
+	keyLen := binary.LittleEndian.Uint32(payload[9:13])
+	if uint64(keyLen) > uint64(len(payload)-payloadHeaderSize) {
+		return nil, fmt.Errorf("key of %d bytes overflows the payload of %d bytes", keyLen, len(payload))
+	}
 
-	e.CRC = binary.LittleEndian.Uint32(data[:4])
+	key := payload[payloadHeaderSize : payloadHeaderSize+keyLen]
+	value := payload[payloadHeaderSize+keyLen:]
 
-	copy(e.Content[:], data[4:])
+	entry := &WALEntry{
+		CRC:  crc,
+		Seq:  binary.LittleEndian.Uint64(payload[0:8]),
+		Type: RecordType(payload[8]),
+		Key:  string(key),
+	}
 
-	return &e, nil
+	if entry.Type == RecordPutExpiring {
+		if len(value) < expirySize {
+			return nil, fmt.Errorf("expiring value of %d bytes is too short", len(value))
+		}
+
+		entry.ExpiresAt = time.Unix(0, int64(binary.LittleEndian.Uint64(value[:expirySize])))
+		value = value[expirySize:]
+	}
+
+	if entry.Type != RecordBatch {
+		entry.Value = string(value)
+		return entry, nil
+	}
+
+	for len(value) > 0 {
+		if len(value) < 4 {
+			return nil, fmt.Errorf("batch record length of %d bytes is too short", len(value))
+		}
+
+		length := binary.LittleEndian.Uint32(value[:4])
+		if uint64(length) > uint64(len(value)-4) {
+			return nil, fmt.Errorf("batch record of %d bytes overflows the batch", length)
+		}
+
+		record, err := decodeWALEntry(0, value[4:4+length])
+		if err != nil {
+			return nil, fmt.Errorf("batch record %d: %w", len(entry.Batch), err)
+		}
+
+		if record.Type == RecordBatch {
+			return nil, fmt.Errorf("batch record %d is a batch", len(entry.Batch))
+		}
+
+		entry.Batch = append(entry.Batch, record)
+		value = value[4+length:]
+	}
+
+	if len(entry.Batch) == 0 {
+		return nil, errors.New("batch record holds no records")
+	}
+
+	return entry, nil
+}
+
+// ReadRecords calls fn with every record of the WAL file and its offset,
+// in file order, until fn returns false. A record whose CRC doesn't match
+// is passed with an error wrapping ErrCorrupted and reading goes on, the
+// length before it frames the next record. Records that can't be framed
+// or decoded stop reading, the returned error wraps ErrCorrupted with
+// their offset.
+func ReadRecords(file string, fn func(offset int64, entry *WALEntry, err error) bool) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return readRecords(f, info.Size(), fn)
+}
+
+// readRecords reads the records of the first size bytes of f,
+// see ReadRecords.
+func readRecords(f io.Reader, size int64, fn func(offset int64, entry *WALEntry, err error) bool) error {
+	r := bufio.NewReader(io.LimitReader(f, size))
+	header := make([]byte, headerSize)
+
+	var offset int64
+	for {
+		n, err := io.ReadFull(r, header)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w at offset %d: torn header of %d bytes", ErrCorrupted, offset, n)
+		}
+
+		crc := binary.LittleEndian.Uint32(header[0:4])
+		length := int64(binary.LittleEndian.Uint32(header[4:8]))
+		if offset+headerSize+length > size {
+			return fmt.Errorf("%w at offset %d: record of %d bytes runs past the end of the file", ErrCorrupted, offset, length)
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return fmt.Errorf("%w at offset %d: %s", ErrCorrupted, offset, err)
+		}
+
+		var crcErr error
+		if sum := crc32.ChecksumIEEE(payload); sum != crc {
+			crcErr = fmt.Errorf("%w at offset %d: crc %08x, expected %08x", ErrCorrupted, offset, sum, crc)
+		}
+
+		entry, err := decodeWALEntry(crc, payload)
+		if err != nil {
+			return fmt.Errorf("%w at offset %d: %s", ErrCorrupted, offset, err)
+		}
+
+		if !fn(offset, entry, crcErr) {
+			return nil
+		}
+
+		offset += headerSize + length
+	}
 }
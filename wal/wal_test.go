@@ -0,0 +1,71 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteAndReadBytes(t *testing.T) {
+	w, err := New(t.TempDir())
+	assert.NoError(t, err)
+
+	assert.NoError(t, w.WriteBytes(&WALEntry{Seq: 1, Type: RecordPut, Key: "foo", Value: "bar"}))
+	assert.NoError(t, w.WriteBytes(&WALEntry{Seq: 2, Type: RecordDelete, Key: "foo"}))
+
+	entries, err := w.ReadBytes()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, uint64(1), entries[0].Seq)
+	assert.Equal(t, RecordPut, entries[0].Type)
+	assert.Equal(t, "bar", entries[0].Value)
+	assert.Equal(t, RecordDelete, entries[1].Type)
+	assert.Equal(t, "", entries[1].Value)
+}
+
+func TestReadRecordsCorruption(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.wal")
+
+	var data []byte
+	var offsets []int64
+	for i, key := range []string{"a", "b", "c"} {
+		b, err := (&WALEntry{Seq: uint64(i + 1), Type: RecordPut, Key: key, Value: "value"}).Encode()
+		assert.NoError(t, err)
+
+		offsets = append(offsets, int64(len(data)))
+		data = append(data, b...)
+	}
+
+	// flip a byte of the second value, its length still frames the third
+	data[offsets[2]-1] ^= 0xff
+	assert.NoError(t, os.WriteFile(file, data, 0644))
+
+	var read []int64
+	var failed []int64
+	err := ReadRecords(file, func(offset int64, entry *WALEntry, err error) bool {
+		read = append(read, offset)
+		if err != nil {
+			assert.ErrorIs(t, err, ErrCorrupted)
+			failed = append(failed, offset)
+		}
+		return true
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, offsets, read)
+	assert.Equal(t, []int64{offsets[1]}, failed)
+
+	// a torn last record stops reading
+	assert.NoError(t, os.WriteFile(file, data[:len(data)-2], 0644))
+
+	read = nil
+	err = ReadRecords(file, func(offset int64, entry *WALEntry, err error) bool {
+		read = append(read, offset)
+		return true
+	})
+	assert.ErrorIs(t, err, ErrCorrupted)
+	assert.Contains(t, err.Error(), fmt.Sprintf("offset %d", offsets[2]))
+	assert.Equal(t, offsets[:2], read)
+}
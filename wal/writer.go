@@ -1,9 +1,7 @@
 package wal
 
 import (
-	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path"
 )
@@ -44,32 +42,26 @@ func (w *WAL) WriteBytes(entry *WALEntry) error {
 	return nil
 }
 
-// Read reads file per total bytes
+// ReadBytes reads the entries of the wal file, failing at the first corrupted record
 func (w *WAL) ReadBytes() ([]WALEntry, error) {
 	var entries []WALEntry
 
-	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
-		return nil, err
-	}
-
-	for {
-		b := make([]byte, 36)
-
-		_, err := io.ReadFull(w.file, b)
-		if err != nil && !errors.Is(err, io.EOF) {
-			return nil, err
-		}
-
-		if errors.Is(err, io.EOF) {
-			break
-		}
-
-		e, err := decodeWALEntry(b)
+	var corrupted error
+	err := ReadRecords(w.file.Name(), func(offset int64, entry *WALEntry, err error) bool {
 		if err != nil {
-			return nil, err
+			corrupted = err
+			return false
 		}
 
-		entries = append(entries, *e)
+		entries = append(entries, *entry)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if corrupted != nil {
+		return nil, corrupted
 	}
 
 	return entries, nil